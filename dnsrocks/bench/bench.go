@@ -0,0 +1,230 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bench
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata/cdb"
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+)
+
+// Target is a DB driver and key format to benchmark
+type Target struct {
+	Name   string
+	Driver string
+	// V2Keys compiles the DB with v2 keys, RocksDB only
+	V2Keys bool
+	// LocationIndex loads subnet to location data in memory
+	LocationIndex bool
+}
+
+// Targets are the combinations of drivers, key formats and location indexes
+var Targets = append([]Target{
+	{Name: "cdb", Driver: "cdb"},
+	{Name: "cdb-index", Driver: "cdb", LocationIndex: true},
+	{Name: "memory", Driver: "memory"},
+}, rocksDBTargets...)
+
+// FindTarget returns the target named name
+func FindTarget(name string) (Target, error) {
+	for _, t := range Targets {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return Target{}, fmt.Errorf("unknown target %q", name)
+}
+
+// Build compiles the data file at dataPath into a DB for the target in dir,
+// and returns the path of the DB
+func (t Target) Build(dataPath, dir string) (string, error) {
+	switch t.Driver {
+	case "cdb", "memory":
+		if t.V2Keys {
+			return "", fmt.Errorf("%s: v2 keys are only supported by rocksdb", t.Name)
+		}
+		dbPath := path.Join(dir, t.Name+".cdb")
+		if _, err := cdb.CreateCDB(dataPath, dbPath, nil); err != nil {
+			return "", fmt.Errorf("%s: %w", t.Name, err)
+		}
+		return dbPath, nil
+	case "rocksdb":
+		dbPath := path.Join(dir, t.Name)
+		if err := os.MkdirAll(dbPath, 0o755); err != nil {
+			return "", err
+		}
+		if err := buildRDB(dataPath, dbPath, t.V2Keys); err != nil {
+			return "", fmt.Errorf("%s: %w", t.Name, err)
+		}
+		return dbPath, nil
+	}
+	return "", fmt.Errorf("%s: unknown driver %s", t.Name, t.Driver)
+}
+
+// Open returns a handler serving the DB of the target at dbPath, without
+// cache nor logs
+func (t Target) Open(dbPath string) (*dnsserver.FBDNSDB, error) {
+	dbConfig := dnsserver.DBConfig{Path: dbPath, Driver: t.Driver, LocationIndex: t.LocationIndex}
+	h, err := dnsserver.NewFBDNSDBBasic(dnsserver.HandlerConfig{}, dbConfig, dnsserver.CacheConfig{}, &dnsserver.DummyLogger{}, &stats.DummyStats{})
+	if err != nil {
+		return nil, err
+	}
+	if err := h.Load(); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("%s: %w", t.Name, err)
+	}
+	return h, nil
+}
+
+// Request returns the DNS message of q
+func (q Query) Request() (*dns.Msg, error) {
+	req := new(dns.Msg)
+	req.SetQuestion(q.Name, q.Type)
+	if q.ClientSubnet != "" {
+		o, err := dnsserver.MakeOPTWithECS(q.ClientSubnet)
+		if err != nil {
+			return nil, err
+		}
+		req.Extra = []dns.RR{o}
+	}
+	return req, nil
+}
+
+// Requests returns the DNS messages of queries
+func Requests(queries []Query) ([]*dns.Msg, error) {
+	reqs := make([]*dns.Msg, 0, len(queries))
+	for _, q := range queries {
+		req, err := q.Request()
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// Serve has h answer req, and returns the response code
+func Serve(h *dnsserver.FBDNSDB, req *dns.Msg) (int, error) {
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := h.ServeDNSWithRCODE(dnsserver.WithAnswerBudget(context.Background(), dnsserver.AnswerBudget{MaxAnswer: 1}), rec, req); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	if rec.Msg == nil {
+		return dns.RcodeServerFailure, fmt.Errorf("no response to %s", req.Question[0].Name)
+	}
+	return rec.Msg.Rcode, nil
+}
+
+// Result is the outcome of a benchmark run
+type Result struct {
+	Target      string         `json:"target"`
+	Queries     int            `json:"queries"`
+	Concurrency int            `json:"concurrency"`
+	Errors      int64          `json:"errors"`
+	Rcodes      map[string]int `json:"rcodes"`
+	Elapsed     time.Duration  `json:"elapsed_ns"`
+	QPS         float64        `json:"qps"`
+	P50         time.Duration  `json:"p50_ns"`
+	P90         time.Duration  `json:"p90_ns"`
+	P99         time.Duration  `json:"p99_ns"`
+	Max         time.Duration  `json:"max_ns"`
+}
+
+// percentile returns the pth percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// Run has h answer reqs from concurrency goroutines, and measures the latency
+// of every query end to end through ServeDNS
+func Run(target string, h *dnsserver.FBDNSDB, reqs []*dns.Msg, concurrency int) Result {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	latencies := make([]time.Duration, len(reqs))
+	rcodes := make([]int, len(reqs))
+	var (
+		next   atomic.Int64
+		errors atomic.Int64
+		wg     sync.WaitGroup
+	)
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(reqs) {
+					return
+				}
+				t := time.Now()
+				rcode, err := Serve(h, reqs[i])
+				latencies[i] = time.Since(t)
+				rcodes[i] = rcode
+				if err != nil {
+					errors.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	r := Result{
+		Target:      target,
+		Queries:     len(reqs),
+		Concurrency: concurrency,
+		Errors:      errors.Load(),
+		Rcodes:      make(map[string]int),
+		Elapsed:     elapsed,
+	}
+	for _, rcode := range rcodes {
+		r.Rcodes[dns.RcodeToString[rcode]]++
+	}
+	if elapsed > 0 {
+		r.QPS = float64(len(reqs)) / elapsed.Seconds()
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.P50 = percentile(latencies, 0.50)
+	r.P90 = percentile(latencies, 0.90)
+	r.P99 = percentile(latencies, 0.99)
+	if len(latencies) > 0 {
+		r.Max = latencies[len(latencies)-1]
+	}
+	return r
+}
@@ -0,0 +1,149 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bench
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+var testDataset = Dataset{
+	Zones:              2,
+	NamesPerZone:       10,
+	RecordsPerName:     2,
+	Locations:          3,
+	SubnetsPerLocation: 2,
+	NXDomainRatio:      0.2,
+	Seed:               1,
+}
+
+// writeDataset writes the data of d to a file in dir and returns its path
+func writeDataset(t testing.TB, d Dataset, dir string) string {
+	var b bytes.Buffer
+	require.NoError(t, d.WriteData(&b))
+	dataPath := path.Join(dir, "data")
+	require.NoError(t, os.WriteFile(dataPath, b.Bytes(), 0o644))
+	return dataPath
+}
+
+func TestDatasetValidate(t *testing.T) {
+	require.NoError(t, DefaultDataset.Validate())
+	require.NoError(t, testDataset.Validate())
+	for _, d := range []Dataset{
+		{},
+		{Zones: 1, NamesPerZone: 1, RecordsPerName: 251},
+		{Zones: 1, NamesPerZone: 1, RecordsPerName: 1, Locations: 1},
+		{Zones: 1, NamesPerZone: 1, RecordsPerName: 1, Locations: 1 << 10, SubnetsPerLocation: 1 << 10},
+		{Zones: 1, NamesPerZone: 1, RecordsPerName: 1, NXDomainRatio: 2},
+	} {
+		require.Error(t, d.Validate(), d)
+	}
+}
+
+func TestDatasetQueries(t *testing.T) {
+	queries := testDataset.Queries(100)
+	require.Len(t, queries, 100)
+	require.Equal(t, queries, testDataset.Queries(100))
+	for _, q := range queries {
+		require.NotEmpty(t, q.ClientSubnet)
+		require.True(t, q.Type == dns.TypeA || q.Type == dns.TypeAAAA)
+	}
+	noLocations := testDataset
+	noLocations.Locations = 0
+	require.Empty(t, noLocations.Queries(1)[0].ClientSubnet)
+}
+
+// TestIPv6Address checks that names past the 65536th still get valid, distinct
+// addresses
+func TestIPv6Address(t *testing.T) {
+	require.Equal(t, "2001:db8::1", ipv6Address(0, 0).String())
+	require.Equal(t, "2001:db8:1::2", ipv6Address(1<<16, 1).String())
+	require.NotEqual(t, ipv6Address(1<<16, 0), ipv6Address(0, 0))
+}
+
+// TestTargets checks that every target answers the queries of the data set
+func TestTargets(t *testing.T) {
+	dir := t.TempDir()
+	dataPath := writeDataset(t, testDataset, dir)
+	reqs, err := Requests(testDataset.Queries(200))
+	require.NoError(t, err)
+
+	for _, target := range Targets {
+		t.Run(target.Name, func(t *testing.T) {
+			dbPath, err := target.Build(dataPath, dir)
+			require.NoError(t, err)
+			h, err := target.Open(dbPath)
+			require.NoError(t, err)
+			defer h.Close()
+
+			r := Run(target.Name, h, reqs, 4)
+			require.Equal(t, target.Name, r.Target)
+			require.Equal(t, len(reqs), r.Queries)
+			require.Zero(t, r.Errors)
+			require.Equal(t, len(reqs), r.Rcodes["NOERROR"]+r.Rcodes["NXDOMAIN"], r.Rcodes)
+			require.NotZero(t, r.Rcodes["NOERROR"])
+			require.NotZero(t, r.Rcodes["NXDOMAIN"])
+			require.LessOrEqual(t, r.P50, r.P99)
+			require.LessOrEqual(t, r.P99, r.Max)
+		})
+	}
+	_, err = Target{Name: "cdb-v2", Driver: "cdb", V2Keys: true}.Build(dataPath, dir)
+	require.Error(t, err)
+	_, err = FindTarget("tinydns")
+	require.Error(t, err)
+}
+
+// BenchmarkServeDNS measures the latency of ServeDNS for each target, on the
+// default data set
+func BenchmarkServeDNS(b *testing.B) {
+	dir := b.TempDir()
+	dataPath := writeDataset(b, DefaultDataset, dir)
+	reqs, err := Requests(DefaultDataset.Queries(10000))
+	require.NoError(b, err)
+
+	for _, target := range Targets {
+		dbPath, err := target.Build(dataPath, dir)
+		require.NoError(b, err)
+		h, err := target.Open(dbPath)
+		require.NoError(b, err)
+
+		b.Run(target.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := Serve(h, reqs[i%len(reqs)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(target.Name+"/parallel", func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					i++
+					if _, err := Serve(h, reqs[i%len(reqs)]); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+		h.Close()
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bench generates synthetic data sets and measures how fast the
+// handler answers queries for them with each DB driver and key format.
+package bench
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/netip"
+
+	"github.com/miekg/dns"
+)
+
+// mapID is the ID of the map of the synthetic data sets
+const mapID = "bm"
+
+// firstLocation is the number of the first location of the data sets, after
+// the ones with a special meaning to the handler
+const firstLocation = 16
+
+// Dataset describes the shape of a synthetic data set. The same Dataset always
+// generates the same data and queries.
+type Dataset struct {
+	// Zones is the number of zones
+	Zones int
+	// NamesPerZone is the number of names with records in each zone
+	NamesPerZone int
+	// RecordsPerName is the number of A and of AAAA records of each name, for
+	// each location
+	RecordsPerName int
+	// Locations is the number of locations of the map of every name, names
+	// aren't location aware if 0
+	Locations int
+	// SubnetsPerLocation is the number of client subnets of each location
+	SubnetsPerLocation int
+	// NXDomainRatio is the fraction of queries for names which don't exist
+	NXDomainRatio float64
+	// Seed seeds the generation of queries
+	Seed int64
+}
+
+// DefaultDataset is a data set small enough to compile in a few seconds
+var DefaultDataset = Dataset{
+	Zones:              10,
+	NamesPerZone:       1000,
+	RecordsPerName:     2,
+	Locations:          20,
+	SubnetsPerLocation: 50,
+	NXDomainRatio:      0.1,
+	Seed:               1,
+}
+
+// Query is a query of the benchmark
+type Query struct {
+	Name string
+	Type uint16
+	// ClientSubnet is the ECS option of the query, none if empty
+	ClientSubnet string
+}
+
+func zoneName(z int) string {
+	return fmt.Sprintf("zone%d.bench.example", z)
+}
+
+func hostName(z, n int) string {
+	return fmt.Sprintf("host%d.%s", n, zoneName(z))
+}
+
+// locationID returns the escaped ID of the lth location
+func locationID(l int) string {
+	id := firstLocation + l
+	return fmt.Sprintf("\\%03o\\%03o", (id>>8)&0xff, id&0xff)
+}
+
+// subnet returns the sth client subnet of the lth location
+func (d Dataset) subnet(l, s int) string {
+	c := l*d.SubnetsPerLocation + s
+	return fmt.Sprintf("10.%d.%d.0/24", (c>>8)&0xff, c&0xff)
+}
+
+// Validate checks that the data set can be generated
+func (d Dataset) Validate() error {
+	if d.Zones <= 0 || d.NamesPerZone <= 0 || d.RecordsPerName <= 0 {
+		return fmt.Errorf("zones, names per zone and records per name must be positive")
+	}
+	if d.RecordsPerName > 250 {
+		return fmt.Errorf("at most 250 records per name")
+	}
+	if d.Locations < 0 || d.SubnetsPerLocation < 0 {
+		return fmt.Errorf("locations and subnets per location can't be negative")
+	}
+	if d.Locations > 0 && d.SubnetsPerLocation == 0 {
+		return fmt.Errorf("locations need subnets")
+	}
+	if d.Locations*d.SubnetsPerLocation > 1<<16 {
+		return fmt.Errorf("at most %d subnets", 1<<16)
+	}
+	if d.NXDomainRatio < 0 || d.NXDomainRatio > 1 {
+		return fmt.Errorf("invalid NXDOMAIN ratio %v", d.NXDomainRatio)
+	}
+	return nil
+}
+
+// writeRecords writes the A and AAAA records of a name for a location, no
+// location if loc is empty
+func (d Dataset) writeRecords(w io.Writer, name string, n int, loc string) {
+	for r := 0; r < d.RecordsPerName; r++ {
+		fmt.Fprintf(w, "+%s,192.0.%d.%d,300,,%s\n", name, n%256, r+1, loc)
+		fmt.Fprintf(w, "+%s,%s,300,,%s\n", name, ipv6Address(n, r), loc)
+	}
+}
+
+// ipv6Address returns the rth IPv6 address of the nth name, in 2001:db8::/32
+// with n in the next 32 bits and r in the last ones
+func ipv6Address(n, r int) netip.Addr {
+	a := netip.MustParseAddr("2001:db8::").As16()
+	binary.BigEndian.PutUint32(a[4:8], uint32(n))     //nolint:gosec
+	binary.BigEndian.PutUint32(a[12:16], uint32(r+1)) //nolint:gosec
+	return netip.AddrFrom16(a)
+}
+
+// WriteData writes the data set in the data format of dnsrocks-data
+func (d Dataset) WriteData(w io.Writer) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if d.Locations > 0 {
+		for l := 0; l < d.Locations; l++ {
+			for s := 0; s < d.SubnetsPerLocation; s++ {
+				fmt.Fprintf(bw, "%%%s,%s,%s\n", locationID(l), d.subnet(l, s), mapID)
+			}
+		}
+	}
+	for z := 0; z < d.Zones; z++ {
+		zone := zoneName(z)
+		fmt.Fprintf(bw, "Z%s,ns1.%s,dns.%s,1,1800,900,604800,3600,300\n", zone, zone, zone)
+		fmt.Fprintf(bw, "&%s,,ns1.%s,300\n", zone, zone)
+		fmt.Fprintf(bw, "+ns1.%s,192.0.2.1,300\n", zone)
+		if d.Locations > 0 {
+			fmt.Fprintf(bw, "8%s,%s\n", zone, mapID)
+			fmt.Fprintf(bw, "M%s,%s\n", zone, mapID)
+		}
+		for n := 0; n < d.NamesPerZone; n++ {
+			name := hostName(z, n)
+			if d.Locations > 0 {
+				fmt.Fprintf(bw, "8%s,%s\n", name, mapID)
+				fmt.Fprintf(bw, "M%s,%s\n", name, mapID)
+				for l := 0; l < d.Locations; l++ {
+					d.writeRecords(bw, name, n, locationID(l))
+				}
+			}
+			// for clients outside of every location
+			d.writeRecords(bw, name, n, "")
+		}
+	}
+	return bw.Flush()
+}
+
+// Queries returns n queries for random names of the data set, from random
+// subnets of its locations
+func (d Dataset) Queries(n int) []Query {
+	rnd := rand.New(rand.NewSource(d.Seed))
+	queries := make([]Query, 0, n)
+	for i := 0; i < n; i++ {
+		z := rnd.Intn(d.Zones)
+		q := Query{Name: dns.Fqdn(hostName(z, rnd.Intn(d.NamesPerZone))), Type: dns.TypeA}
+		if rnd.Float64() < d.NXDomainRatio {
+			q.Name = dns.Fqdn(fmt.Sprintf("nx%d.%s", i, zoneName(z)))
+		}
+		if rnd.Intn(2) == 1 {
+			q.Type = dns.TypeAAAA
+		}
+		if d.Locations > 0 {
+			q.ClientSubnet = d.subnet(rnd.Intn(d.Locations), rnd.Intn(d.SubnetsPerLocation))
+		}
+		queries = append(queries, q)
+	}
+	return queries
+}
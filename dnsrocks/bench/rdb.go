@@ -0,0 +1,40 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bench
+
+import (
+	"runtime"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+)
+
+var rocksDBTargets = []Target{
+	{Name: "rocksdb", Driver: "rocksdb"},
+	{Name: "rocksdb-v2", Driver: "rocksdb", V2Keys: true},
+	{Name: "rocksdb-index", Driver: "rocksdb", LocationIndex: true},
+}
+
+// buildRDB compiles the data file at dataPath into a RocksDB at dbPath
+func buildRDB(dataPath, dbPath string, v2Keys bool) error {
+	o := rdb.CompilationOptions{
+		NumCPU:           runtime.NumCPU(),
+		BatchNumParallel: rdb.DefaultBatchNum,
+		BatchSize:        rdb.DefaultBatchSize,
+		UseV2KeySyntax:   v2Keys,
+	}
+	_, err := rdb.CompileToSpecificRDBVersion(dataPath, dbPath, o)
+	return err
+}
@@ -0,0 +1,155 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocksdb
+
+/*
+// @fb-only: #include "rocksdb/src/include/rocksdb/c.h"
+#cgo pkg-config: "rocksdb"
+#include "rocksdb/c.h" // @oss-only
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// BatchWithIndex is a wrapper for WriteBatchWithIndex. Unlike Batch, the
+// operations it holds can be read back, on their own or on top of the DB,
+// before it is executed.
+// https://github.com/facebook/rocksdb/wiki/Write-Batch-With-Index
+type BatchWithIndex struct {
+	cBatch *C.rocksdb_writebatch_wi_t
+}
+
+// NewBatchWithIndex creates a BatchWithIndex. If overwriteKeys is set, an
+// operation on a key replaces the previous ones on the same key in the index.
+func NewBatchWithIndex(overwriteKeys bool) *BatchWithIndex {
+	return &BatchWithIndex{
+		cBatch: C.rocksdb_writebatch_wi_create(0, BoolToChar(overwriteKeys)),
+	}
+}
+
+// Clear clears batch content
+func (batch *BatchWithIndex) Clear() {
+	C.rocksdb_writebatch_wi_clear(batch.cBatch)
+}
+
+// GetCount returns the number of actions in the batch
+func (batch *BatchWithIndex) GetCount() int {
+	return int(C.rocksdb_writebatch_wi_count(batch.cBatch))
+}
+
+// Put schedules storing a binary key-value pair in the batch
+func (batch *BatchWithIndex) Put(key, value []byte) {
+	cKeyPtr, cKeyLen := bytesToPtr(key)
+	cValPtr, cValLen := bytesToPtr(value)
+	C.rocksdb_writebatch_wi_put(
+		batch.cBatch,
+		cKeyPtr, cKeyLen, cValPtr, cValLen,
+	)
+}
+
+// Merge schedules applying value to the value of key with the merge operator
+func (batch *BatchWithIndex) Merge(key, value []byte) {
+	cKeyPtr, cKeyLen := bytesToPtr(key)
+	cValPtr, cValLen := bytesToPtr(value)
+	C.rocksdb_writebatch_wi_merge(
+		batch.cBatch,
+		cKeyPtr, cKeyLen, cValPtr, cValLen,
+	)
+}
+
+// Delete schedules a deletion of the key in the batch
+func (batch *BatchWithIndex) Delete(key []byte) {
+	cKeyPtr, cKeyLen := bytesToPtr(key)
+	C.rocksdb_writebatch_wi_delete(batch.cBatch, cKeyPtr, cKeyLen)
+}
+
+// GetFromBatch retrieves the value of key resulting from the operations of
+// the batch only, nil if there is none. options provide the merge operator.
+func (batch *BatchWithIndex) GetFromBatch(options *Options, key []byte) ([]byte, error) {
+	var (
+		cError    *C.char
+		cValueLen C.size_t
+	)
+	cKeyPtr, cKeyLen := bytesToPtr(key)
+	cValue := C.rocksdb_writebatch_wi_get_from_batch(
+		batch.cBatch, options.cOptions,
+		cKeyPtr, cKeyLen,
+		&cValueLen, &cError,
+	)
+	return getResult(cValue, cValueLen, cError)
+}
+
+// GetFromBatchAndDB retrieves the value of key from the DB, with the
+// operations of the batch applied
+func (batch *BatchWithIndex) GetFromBatchAndDB(db *RocksDB, readOptions *ReadOptions, key []byte) ([]byte, error) {
+	var (
+		cError    *C.char
+		cValueLen C.size_t
+	)
+	cKeyPtr, cKeyLen := bytesToPtr(key)
+	cValue := C.rocksdb_writebatch_wi_get_from_batch_and_db(
+		batch.cBatch, db.cDB, readOptions.cReadOptions,
+		cKeyPtr, cKeyLen,
+		&cValueLen, &cError,
+	)
+	return getResult(cValue, cValueLen, cError)
+}
+
+// CreateIteratorWithBase returns an iterator over baseIterator with the
+// operations of the batch applied. The returned iterator takes ownership of
+// baseIterator, which must not be used nor freed afterwards.
+func (batch *BatchWithIndex) CreateIteratorWithBase(baseIterator *Iterator) *Iterator {
+	return &Iterator{
+		cIter: C.rocksdb_writebatch_wi_create_iterator_with_base(batch.cBatch, baseIterator.cIter),
+	}
+}
+
+// Destroy destroys a BatchWithIndex object
+func (batch *BatchWithIndex) Destroy() {
+	C.rocksdb_writebatch_wi_destroy(batch.cBatch)
+}
+
+// ExecuteBatchWithIndex executes operations from the batch with provided options
+func (db *RocksDB) ExecuteBatchWithIndex(batch *BatchWithIndex, writeOptions *WriteOptions) error {
+	var cError *C.char
+	C.rocksdb_write_writebatch_wi(
+		db.cDB, writeOptions.cWriteOptions,
+		batch.cBatch, &cError,
+	)
+	if cError != nil {
+		defer C.rocksdb_free(unsafe.Pointer(cError))
+		return errors.New(C.GoString(cError))
+	}
+	return nil
+}
+
+// getResult converts the value returned by a C get call, freeing it
+func getResult(cValue *C.char, cValueLen C.size_t, cError *C.char) ([]byte, error) {
+	if cError != nil {
+		err := errors.New(C.GoString(cError))
+		C.rocksdb_free(unsafe.Pointer(cError))
+		return nil, err
+	}
+	if cValue == nil {
+		return nil, nil
+	}
+	result := C.GoBytes(unsafe.Pointer(cValue), C.int(cValueLen))
+	C.rocksdb_free(unsafe.Pointer(cValue))
+	return result, nil
+}
@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -25,6 +27,7 @@ type BlockBasedOptions struct {
 	cBlockBasedOptions *C.rocksdb_block_based_table_options_t
 	filterPolicy       *FilterPolicy
 	lruCache           *LRUCache
+	sharedCache        bool // lruCache is owned by the caller
 }
 
 // NewBlockBasedOptions creates an instance of BlockBasedOptions
@@ -43,15 +46,31 @@ func (b *BlockBasedOptions) SetFullBloomFilter(fullBloomBits int) {
 
 // SetLRUCache creates LRU cache of the given size
 func (b *BlockBasedOptions) SetLRUCache(capacity int) {
+	b.freeLRUCache()
 	b.lruCache = NewLRUCache(capacity)
+	b.sharedCache = false
 	C.rocksdb_block_based_options_set_block_cache(b.cBlockBasedOptions, b.lruCache.cCache)
 }
 
-// FreeBlockBasedOptions frees up the memory occupied by BlockBasedOptions
-func (b *BlockBasedOptions) FreeBlockBasedOptions() {
-	if b.lruCache != nil {
+// SetSharedLRUCache uses cache as block cache. The cache can be shared with
+// other options, it is not freed with them.
+func (b *BlockBasedOptions) SetSharedLRUCache(cache *LRUCache) {
+	b.freeLRUCache()
+	b.lruCache = cache
+	b.sharedCache = true
+	C.rocksdb_block_based_options_set_block_cache(b.cBlockBasedOptions, cache.cCache)
+}
+
+func (b *BlockBasedOptions) freeLRUCache() {
+	if b.lruCache != nil && !b.sharedCache {
 		b.lruCache.FreeLRUCache()
 	}
+	b.lruCache = nil
+}
+
+// FreeBlockBasedOptions frees up the memory occupied by BlockBasedOptions
+func (b *BlockBasedOptions) FreeBlockBasedOptions() {
+	b.freeLRUCache()
 	C.rocksdb_block_based_options_destroy(b.cBlockBasedOptions)
 	// NOTE: there is no need to explicitly call b.filterPolicy.FreeFilterPolicy(),
 	// it seems that rocksdb_block_based_options_destroy() frees the memory
@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -46,3 +48,8 @@ func (c *LRUCache) GetUsage() uint64 {
 func (c *LRUCache) GetPinnedUsage() uint64 {
 	return uint64(C.rocksdb_cache_get_pinned_usage(c.cCache))
 }
+
+// GetCapacity returns the capacity of the cache.
+func (c *LRUCache) GetCapacity() uint64 {
+	return uint64(C.rocksdb_cache_get_capacity(c.cCache))
+}
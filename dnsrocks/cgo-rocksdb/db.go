@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -30,6 +32,7 @@ import (
 // RocksDB is a connection instance
 type RocksDB struct {
 	cDB           *C.rocksdb_t
+	cDefaultCF    *C.rocksdb_column_family_handle_t // used by the *_cf calls
 	name          string
 	secondary     bool
 	secondaryPath string
@@ -61,9 +64,10 @@ func OpenDatabase(name string, readOnly, readOnlyErrorIfLogExist bool, options *
 		return nil, errors.New(C.GoString(cError))
 	}
 	return &RocksDB{
-		cDB:     db,
-		name:    name,
-		options: options,
+		cDB:        db,
+		cDefaultCF: C.rocksdb_get_default_column_family_handle(db),
+		name:       name,
+		options:    options,
 	}, nil
 }
 
@@ -90,6 +94,7 @@ func OpenSecondary(name, secondaryPath string, options *Options) (*RocksDB, erro
 	}
 	return &RocksDB{
 		cDB:           db,
+		cDefaultCF:    C.rocksdb_get_default_column_family_handle(db),
 		name:          name,
 		options:       options,
 		secondary:     true,
@@ -114,6 +119,12 @@ func (db *RocksDB) CatchWithPrimary() error {
 	return nil
 }
 
+// GetLatestSequenceNumber returns the sequence number of the most recent
+// update visible to the database; for a secondary, as of its last catch up
+func (db *RocksDB) GetLatestSequenceNumber() uint64 {
+	return uint64(C.rocksdb_get_latest_sequence_number(db.cDB))
+}
+
 // Put stores a binary key-value
 func (db *RocksDB) Put(writeOptions *WriteOptions, key, value []byte) error {
 	var cError *C.char
@@ -131,6 +142,24 @@ func (db *RocksDB) Put(writeOptions *WriteOptions, key, value []byte) error {
 	return nil
 }
 
+// Merge applies value to the value of key with the merge operator set in the
+// options the DB was opened with
+func (db *RocksDB) Merge(writeOptions *WriteOptions, key, value []byte) error {
+	var cError *C.char
+	cKeyPtr, cKeyLen := bytesToPtr(key)
+	cValPtr, cValLen := bytesToPtr(value)
+	C.rocksdb_merge(
+		db.cDB, writeOptions.cWriteOptions,
+		cKeyPtr, cKeyLen, cValPtr, cValLen,
+		&cError,
+	)
+	if cError != nil {
+		defer C.rocksdb_free(unsafe.Pointer(cError))
+		return errors.New(C.GoString(cError))
+	}
+	return nil
+}
+
 // Get retrieves the binary value associated with the byte key
 func (db *RocksDB) Get(readOptions *ReadOptions, key []byte) ([]byte, error) {
 	var (
@@ -157,20 +186,21 @@ func (db *RocksDB) Get(readOptions *ReadOptions, key []byte) ([]byte, error) {
 }
 
 // GetMulti retrieves multiple binary values associated with multiple byte keys;
-// returns two arrays of corresponding size - one with results, and another
-// with errors (or nil's)
+// returns two arrays of corresponding size - one with results (nil for missing
+// keys), and another with errors (or nil's)
 func (db *RocksDB) GetMulti(readOptions *ReadOptions, keys [][]byte) ([][]byte, []error) {
 	keysCount := len(keys)
 
-	scValues := make(charsSlice, keysCount)
-	stValueLengths := make(sizeTSlice, keysCount)
+	// values are pinned in the block cache or memtable instead of being copied
+	// to separately allocated buffers, until copied to a single Go buffer below
+	cValues := make([]*C.rocksdb_pinnableslice_t, keysCount)
 	scErrors := make(charsSlice, keysCount)
 
 	keyList := bytesListToPtrList(keys)
-	C.rocksdb_multi_get(
-		db.cDB, readOptions.cReadOptions,
+	C.rocksdb_batched_multi_get_cf(
+		db.cDB, readOptions.cReadOptions, db.cDefaultCF,
 		C.size_t(keysCount), keyList.cChars.c(), keyList.cLengths.c(),
-		scValues.c(), stValueLengths.c(), scErrors.c(),
+		unsafe.SliceData(cValues), scErrors.c(), C.bool(false),
 	)
 	keyList.freePtrList()
 
@@ -186,15 +216,29 @@ func (db *RocksDB) GetMulti(readOptions *ReadOptions, keys [][]byte) ([][]byte,
 	}
 
 	// process values
+	valuePtrs := make([]*C.char, keysCount)
+	valueLens := make([]int, keysCount)
+	totalLen := 0
+	var cValueLen C.size_t
+	for i, cValue := range cValues {
+		if cValue == nil {
+			continue
+		}
+		valuePtrs[i] = C.rocksdb_pinnableslice_value(cValue, &cValueLen)
+		valueLens[i] = int(cValueLen)
+		totalLen += valueLens[i]
+	}
+	buf := make([]byte, totalLen)
 	valueList := make([][]byte, keysCount)
-	for i := 0; i < len(scValues); i++ {
-		// NOTE: ditto about C.GoBytes(), if it is ever a bottleneck, then
-		// a wrapper on top of pure C arrays (with slice-like interface) is
-		// the way to go
-		valueList[i] = C.GoBytes(unsafe.Pointer(scValues[i]), C.int(stValueLengths[i]))
-		if scValues[i] != nil {
-			C.rocksdb_free(unsafe.Pointer(scValues[i]))
+	for i, cValue := range cValues {
+		if cValue == nil {
+			continue
 		}
+		n := copy(buf, unsafe.Slice((*byte)(unsafe.Pointer(valuePtrs[i])), valueLens[i]))
+		// cap the slice so that appending to a value does not overwrite the next one
+		valueList[i] = buf[:n:n]
+		buf = buf[n:]
+		C.rocksdb_pinnableslice_destroy(cValue)
 	}
 
 	return valueList, errorList
@@ -369,6 +413,7 @@ func (db *RocksDB) GetOptions() *Options {
 // CloseDatabase frees the memory and closes the connection
 func (db *RocksDB) CloseDatabase() {
 	db.options.FreeOptions()
+	C.rocksdb_column_family_handle_destroy(db.cDefaultCF)
 	C.rocksdb_close(db.cDB)
 }
 
@@ -411,6 +456,16 @@ func (batch *Batch) Put(key, value []byte) {
 	)
 }
 
+// Merge schedules applying value to the value of key with the merge operator
+func (batch *Batch) Merge(key, value []byte) {
+	cKeyPtr, cKeyLen := bytesToPtr(key)
+	cValPtr, cValLen := bytesToPtr(value)
+	C.rocksdb_writebatch_merge(
+		batch.cBatch,
+		cKeyPtr, cKeyLen, cValPtr, cValLen,
+	)
+}
+
 // PutVector stores key-value pair composed of multiple "chunks".
 // If you just want to store more than one key-value pair, this is NOT
 // what you are looking for.
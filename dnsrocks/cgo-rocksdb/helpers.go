@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -36,6 +38,7 @@ func (s sizeTSlice) c() *C.size_t {
 type ptrList struct {
 	cChars   charsSlice
 	cLengths sizeTSlice
+	cBuffer  unsafe.Pointer
 }
 
 func bytesToPtr(bytes []byte) (*C.char, C.size_t) {
@@ -57,18 +60,26 @@ func strToPtr(s string) (*C.char, C.size_t) {
 }
 
 // bytesListToPtrList converts slice of byte slices into an array of char arrays and sizes,
-// it explicitly allocates new arrays and copies data; the caller is responsible for
+// it copies data to a single buffer allocated with malloc; the caller is responsible for
 // calling freePtrList
 func bytesListToPtrList(bytes [][]byte) *ptrList {
 	bytesLen := len(bytes)
-	// NOTE: this array's content is allocated with malloc, and needs to be freed outside
+	totalLen := 0
+	for _, token := range bytes {
+		totalLen += len(token)
+	}
 	cBytes := make(charsSlice, bytesLen)
 	cLengths := make(sizeTSlice, bytesLen)
+	// this needs to be C.freed
+	cBuffer := C.malloc(C.size_t(totalLen + 1))
+	buffer := unsafe.Slice((*byte)(cBuffer), totalLen)
+	offset := 0
 	for i, token := range bytes {
 		length := len(token)
 		if length > 0 {
-			// this needs to be C.freed
-			cBytes[i] = (*C.char)(C.CBytes(token))
+			copy(buffer[offset:], token)
+			cBytes[i] = (*C.char)(unsafe.Add(cBuffer, offset))
+			offset += length
 		} else {
 			cBytes[i] = nil
 		}
@@ -77,12 +88,11 @@ func bytesListToPtrList(bytes [][]byte) *ptrList {
 	return &ptrList{
 		cChars:   cBytes,
 		cLengths: cLengths,
+		cBuffer:  cBuffer,
 	}
 }
 
 // freePtrList frees up the memory allocated for ptrList
 func (l *ptrList) freePtrList() {
-	for _, chars := range l.cChars {
-		C.free(unsafe.Pointer(chars))
-	}
+	C.free(l.cBuffer)
 }
@@ -0,0 +1,94 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// C side of merge_operator.go: RocksDB calls these, which call the Go
+// MergeOperator identified by the handle passed as state.
+
+#include <stdint.h>
+#include <stdlib.h>
+
+// @fb-only: #include "rocksdb/src/include/rocksdb/c.h"
+#include "rocksdb/c.h" // @oss-only
+
+#include "_cgo_export.h"
+
+static void dnsrocks_mergeoperator_destructor(void* state) {
+  dnsrocksMergeOperatorDestroy((uintptr_t)state);
+}
+
+static char* dnsrocks_mergeoperator_full_merge(
+    void* state,
+    const char* key,
+    size_t key_length,
+    const char* existing_value,
+    size_t existing_value_length,
+    const char* const* operands_list,
+    const size_t* operands_list_length,
+    int num_operands,
+    unsigned char* success,
+    size_t* new_value_length) {
+  return dnsrocksMergeOperatorFullMerge(
+      (uintptr_t)state,
+      (char*)key,
+      key_length,
+      (char*)existing_value,
+      existing_value_length,
+      (char**)operands_list,
+      (size_t*)operands_list_length,
+      num_operands,
+      success,
+      new_value_length);
+}
+
+static char* dnsrocks_mergeoperator_partial_merge(
+    void* state,
+    const char* key,
+    size_t key_length,
+    const char* const* operands_list,
+    const size_t* operands_list_length,
+    int num_operands,
+    unsigned char* success,
+    size_t* new_value_length) {
+  return dnsrocksMergeOperatorPartialMerge(
+      (uintptr_t)state,
+      (char*)key,
+      key_length,
+      (char**)operands_list,
+      (size_t*)operands_list_length,
+      num_operands,
+      success,
+      new_value_length);
+}
+
+static void dnsrocks_mergeoperator_delete_value(
+    void* state,
+    const char* value,
+    size_t value_length) {
+  free((void*)value);
+}
+
+static const char* dnsrocks_mergeoperator_name(void* state) {
+  return dnsrocksMergeOperatorName((uintptr_t)state);
+}
+
+rocksdb_mergeoperator_t* dnsrocks_mergeoperator_create(uintptr_t handle) {
+  return rocksdb_mergeoperator_create(
+      (void*)handle,
+      dnsrocks_mergeoperator_destructor,
+      dnsrocks_mergeoperator_full_merge,
+      dnsrocks_mergeoperator_partial_merge,
+      dnsrocks_mergeoperator_delete_value,
+      dnsrocks_mergeoperator_name);
+}
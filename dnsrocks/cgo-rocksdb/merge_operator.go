@@ -0,0 +1,135 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocksdb
+
+/*
+// @fb-only: #include "rocksdb/src/include/rocksdb/c.h"
+#cgo pkg-config: "rocksdb"
+#include "rocksdb/c.h" // @oss-only
+#include <stdint.h>
+#include <stdlib.h> // for free()
+
+// defined in merge_operator.c
+extern rocksdb_mergeoperator_t* dnsrocks_mergeoperator_create(uintptr_t handle);
+*/
+import "C"
+
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+// MergeOperator combines merge operands with the existing value of a key,
+// allowing to update values without reading them first.
+// https://github.com/facebook/rocksdb/wiki/Merge-Operator
+// Its methods are called from RocksDB threads, and must be safe for concurrent use.
+type MergeOperator interface {
+	// Name identifies the operator; a DB must always be opened with the same one.
+	Name() string
+	// FullMerge returns the value resulting from applying the operands, oldest first,
+	// to existingValue, which is nil if the key does not exist.
+	// It returns false if the operands cannot be applied.
+	FullMerge(key, existingValue []byte, operands [][]byte) ([]byte, bool)
+	// PartialMerge combines the operands, oldest first, into a single operand.
+	// It returns false if they cannot be combined, in which case they are kept
+	// until a FullMerge.
+	PartialMerge(key []byte, operands [][]byte) ([]byte, bool)
+}
+
+// mergeOperatorState is what the C merge operator refers to
+type mergeOperatorState struct {
+	op    MergeOperator
+	cName *C.char
+}
+
+// SetMergeOperator sets the merge operator used by Merge operations.
+// The operator is released along with the options.
+func (options *Options) SetMergeOperator(op MergeOperator) {
+	state := &mergeOperatorState{op: op, cName: C.CString(op.Name())}
+	handle := cgo.NewHandle(state)
+	C.rocksdb_options_set_merge_operator(options.cOptions, C.dnsrocks_mergeoperator_create(C.uintptr_t(handle)))
+}
+
+func getMergeOperatorState(handle C.uintptr_t) *mergeOperatorState {
+	return cgo.Handle(handle).Value().(*mergeOperatorState)
+}
+
+// operandsToBytes copies the C list of merge operands
+func operandsToBytes(cOperands **C.char, cOperandsLen *C.size_t, cNumOperands C.int) [][]byte {
+	n := int(cNumOperands)
+	ptrs := unsafe.Slice(cOperands, n)
+	lens := unsafe.Slice(cOperandsLen, n)
+	operands := make([][]byte, n)
+	for i := range operands {
+		operands[i] = C.GoBytes(unsafe.Pointer(ptrs[i]), C.int(lens[i]))
+	}
+	return operands
+}
+
+// mergeResult returns the merged value as RocksDB expects it; it is freed by
+// dnsrocks_mergeoperator_delete_value
+func mergeResult(value []byte, ok bool, cSuccess *C.uchar, cNewValueLen *C.size_t) *C.char {
+	*cSuccess = BoolToChar(ok)
+	if !ok {
+		*cNewValueLen = 0
+		return nil
+	}
+	*cNewValueLen = C.size_t(len(value))
+	return (*C.char)(C.CBytes(value))
+}
+
+//export dnsrocksMergeOperatorDestroy
+func dnsrocksMergeOperatorDestroy(handle C.uintptr_t) {
+	state := getMergeOperatorState(handle)
+	C.free(unsafe.Pointer(state.cName))
+	cgo.Handle(handle).Delete()
+}
+
+//export dnsrocksMergeOperatorName
+func dnsrocksMergeOperatorName(handle C.uintptr_t) *C.char {
+	return getMergeOperatorState(handle).cName
+}
+
+//export dnsrocksMergeOperatorFullMerge
+func dnsrocksMergeOperatorFullMerge(
+	handle C.uintptr_t,
+	cKey *C.char, cKeyLen C.size_t,
+	cExistingValue *C.char, cExistingValueLen C.size_t,
+	cOperands **C.char, cOperandsLen *C.size_t, cNumOperands C.int,
+	cSuccess *C.uchar, cNewValueLen *C.size_t,
+) *C.char {
+	key := C.GoBytes(unsafe.Pointer(cKey), C.int(cKeyLen))
+	var existingValue []byte
+	if cExistingValue != nil {
+		existingValue = C.GoBytes(unsafe.Pointer(cExistingValue), C.int(cExistingValueLen))
+	}
+	operands := operandsToBytes(cOperands, cOperandsLen, cNumOperands)
+	value, ok := getMergeOperatorState(handle).op.FullMerge(key, existingValue, operands)
+	return mergeResult(value, ok, cSuccess, cNewValueLen)
+}
+
+//export dnsrocksMergeOperatorPartialMerge
+func dnsrocksMergeOperatorPartialMerge(
+	handle C.uintptr_t,
+	cKey *C.char, cKeyLen C.size_t,
+	cOperands **C.char, cOperandsLen *C.size_t, cNumOperands C.int,
+	cSuccess *C.uchar, cNewValueLen *C.size_t,
+) *C.char {
+	key := C.GoBytes(unsafe.Pointer(cKey), C.int(cKeyLen))
+	operands := operandsToBytes(cOperands, cOperandsLen, cNumOperands)
+	value, ok := getMergeOperatorState(handle).op.PartialMerge(key, operands)
+	return mergeResult(value, ok, cSuccess, cNewValueLen)
+}
@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -17,6 +19,7 @@ package rocksdb
 // @fb-only: #include "rocksdb/src/include/rocksdb/c.h"
 #cgo pkg-config: "rocksdb"
 #include "rocksdb/c.h" // @oss-only
+#include <stdlib.h> // for free()
 
 // RocksDB-compatible boolean values
 const unsigned char BOOL_CHAR_FALSE = 0;
@@ -28,6 +31,7 @@ import (
 	"C"
 )
 import (
+	"sync"
 	"time"
 	"unsafe"
 )
@@ -81,6 +85,9 @@ const (
 type Options struct {
 	cOptions          *C.rocksdb_options_t
 	blockBasedOptions *BlockBasedOptions
+
+	statisticsNamesOnce sync.Once
+	statisticsNames     *statisticsNames
 }
 
 // NewOptions creates and returns default Options structure.
@@ -210,6 +217,13 @@ func (options *Options) SetPrefixExtractorSize(prefixSize int) {
 	C.rocksdb_options_set_prefix_extractor(options.cOptions, p)
 }
 
+// SetMemtablePrefixBloomSizeRatio enables a prefix bloom filter in memtables,
+// of ratio * write_buffer_size bytes; requires a prefix extractor
+// https://github.com/facebook/rocksdb/wiki/Prefix-Seek
+func (options *Options) SetMemtablePrefixBloomSizeRatio(ratio float64) {
+	C.rocksdb_options_set_memtable_prefix_bloom_size_ratio(options.cOptions, C.double(ratio))
+}
+
 // some compaction options
 
 // SetNumLevels sets number of compaction levels when level-based compaction is used.
@@ -339,6 +353,25 @@ func (options *Options) SetLRUCacheSize(capacity int) {
 	)
 }
 
+// SetBlockCache uses cache as block cache, possibly shared with other
+// options. It must outlive the databases opened with them.
+func (options *Options) SetBlockCache(cache *LRUCache) {
+	if options.blockBasedOptions == nil {
+		options.blockBasedOptions = NewBlockBasedOptions()
+	}
+	options.blockBasedOptions.SetSharedLRUCache(cache)
+	C.rocksdb_options_set_block_based_table_factory(
+		options.cOptions,
+		options.blockBasedOptions.cBlockBasedOptions,
+	)
+}
+
+// SetWriteBufferManager bounds the memory of memtables with w, possibly
+// shared with other options. It must outlive the databases opened with them.
+func (options *Options) SetWriteBufferManager(w *WriteBufferManager) {
+	C.rocksdb_options_set_write_buffer_manager(options.cOptions, w.cWriteBufferManager)
+}
+
 // GetCache provides access to block cache
 func (options *Options) GetCache() *LRUCache {
 	if options.blockBasedOptions == nil {
@@ -433,6 +466,9 @@ func (snapshot *Snapshot) FreeSnapshot() {
 // ReadOptions is a set of options for read operations
 type ReadOptions struct {
 	cReadOptions *C.rocksdb_readoptions_t
+	// RocksDB keeps pointers to the iterate bounds, they are owned here
+	cLowerBound unsafe.Pointer
+	cUpperBound unsafe.Pointer
 }
 
 // NewDefaultReadOptions creates ReadOptions object with default properties
@@ -476,23 +512,78 @@ func (readOptions *ReadOptions) UnsetSnapshot() {
 	C.rocksdb_readoptions_set_snapshot(readOptions.cReadOptions, nil)
 }
 
-// SetIterateLowerBound sets lower bound for key seek
+// SetIterateLowerBound sets the inclusive lower bound for iterators; nil
+// removes it. Iterators created with these options use the bound in effect
+// at the time of each seek.
 // https://github.com/facebook/rocksdb/wiki/Iterator
 func (readOptions *ReadOptions) SetIterateLowerBound(key []byte) {
-	cKeyPtr, cKeyLen := bytesToPtr(key)
-	C.rocksdb_readoptions_set_iterate_lower_bound(readOptions.cReadOptions, cKeyPtr, cKeyLen)
+	cKey, cKeyLen := copyBound(key)
+	C.rocksdb_readoptions_set_iterate_lower_bound(readOptions.cReadOptions, (*C.char)(cKey), cKeyLen)
+	C.free(readOptions.cLowerBound)
+	readOptions.cLowerBound = cKey
 }
 
-// SetIterateUpperBound sets upper bound for key seek
+// SetIterateUpperBound sets the exclusive upper bound for iterators; nil
+// removes it. Iterators created with these options use the bound in effect
+// at the time of each seek.
 // https://github.com/facebook/rocksdb/wiki/Iterator
 func (readOptions *ReadOptions) SetIterateUpperBound(key []byte) {
-	cKeyPtr, cKeyLen := bytesToPtr(key)
-	C.rocksdb_readoptions_set_iterate_upper_bound(readOptions.cReadOptions, cKeyPtr, cKeyLen)
+	cKey, cKeyLen := copyBound(key)
+	C.rocksdb_readoptions_set_iterate_upper_bound(readOptions.cReadOptions, (*C.char)(cKey), cKeyLen)
+	C.free(readOptions.cUpperBound)
+	readOptions.cUpperBound = cKey
+}
+
+// copyBound copies an iterate bound to C memory, as RocksDB does not copy it
+func copyBound(key []byte) (unsafe.Pointer, C.size_t) {
+	if key == nil {
+		return nil, 0
+	}
+	// allocate at least one byte, so an empty bound is not taken for no bound
+	cKey := C.malloc(C.size_t(len(key) + 1))
+	copy(unsafe.Slice((*byte)(cKey), len(key)), key)
+	return cKey, C.size_t(len(key))
+}
+
+// SetPrefixSameAsStart makes iterators only return keys with the same prefix
+// as the seek key, as defined by the prefix extractor
+// https://github.com/facebook/rocksdb/wiki/Prefix-Seek
+func (readOptions *ReadOptions) SetPrefixSameAsStart(v bool) {
+	C.rocksdb_readoptions_set_prefix_same_as_start(readOptions.cReadOptions, BoolToChar(v))
+}
+
+// SetTotalOrderSeek makes iterators ignore the prefix extractor, and iterate
+// over all keys in order
+// https://github.com/facebook/rocksdb/wiki/Prefix-Seek
+func (readOptions *ReadOptions) SetTotalOrderSeek(v bool) {
+	C.rocksdb_readoptions_set_total_order_seek(readOptions.cReadOptions, BoolToChar(v))
+}
+
+// SetDeadline makes Get and GetMulti fail with a "timed out" error when
+// they have not completed by deadline; the zero time removes the deadline.
+// Iterators do not honor it.
+// https://github.com/facebook/rocksdb/wiki/Basic-Operations#read-deadlines
+func (readOptions *ReadOptions) SetDeadline(deadline time.Time) {
+	var us uint64
+	if !deadline.IsZero() {
+		us = uint64(max(deadline.UnixMicro(), 1)) //nolint:gosec
+	}
+	C.rocksdb_readoptions_set_deadline(readOptions.cReadOptions, C.uint64_t(us))
+}
+
+// SetIOTimeout makes reads fail with a "timed out" error when a single file
+// read takes longer than timeout; 0 removes the timeout.
+func (readOptions *ReadOptions) SetIOTimeout(timeout time.Duration) {
+	C.rocksdb_readoptions_set_io_timeout(readOptions.cReadOptions, C.uint64_t(max(timeout.Microseconds(), 0)))
 }
 
 // FreeReadOptions frees up the memory previously allocated by NewReadOptions
 func (readOptions *ReadOptions) FreeReadOptions() {
 	C.rocksdb_readoptions_destroy(readOptions.cReadOptions)
+	C.free(readOptions.cLowerBound)
+	C.free(readOptions.cUpperBound)
+	readOptions.cLowerBound = nil
+	readOptions.cUpperBound = nil
 }
 
 // WaitForCompactOptions is a set of options for WaitForCompact call
@@ -0,0 +1,98 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocksdb
+
+/*
+// @fb-only: #include "rocksdb/src/include/rocksdb/c.h"
+#cgo pkg-config: "rocksdb"
+#include "rocksdb/c.h" // @oss-only
+#include <stdlib.h> // for free()
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+// PerfLevel specifies which metrics are counted in the perf context
+type PerfLevel int
+
+// Perf levels.
+const (
+	PerfLevelDisable                  = PerfLevel(C.rocksdb_disable)
+	PerfLevelEnableCount              = PerfLevel(C.rocksdb_enable_count)
+	PerfLevelEnableTimeExceptForMutex = PerfLevel(C.rocksdb_enable_time_except_for_mutex)
+	PerfLevelEnableTime               = PerfLevel(C.rocksdb_enable_time)
+)
+
+// PerfMetric is a counter of the perf context
+type PerfMetric int
+
+// Perf metrics.
+const (
+	PerfUserKeyComparisonCount  = PerfMetric(C.rocksdb_user_key_comparison_count)
+	PerfBlockCacheHitCount      = PerfMetric(C.rocksdb_block_cache_hit_count)
+	PerfBlockReadCount          = PerfMetric(C.rocksdb_block_read_count)
+	PerfBlockReadByte           = PerfMetric(C.rocksdb_block_read_byte)
+	PerfBlockReadTime           = PerfMetric(C.rocksdb_block_read_time)
+	PerfInternalKeySkippedCount = PerfMetric(C.rocksdb_internal_key_skipped_count)
+	PerfGetFromMemtableCount    = PerfMetric(C.rocksdb_get_from_memtable_count)
+	PerfSeekOnMemtableCount     = PerfMetric(C.rocksdb_seek_on_memtable_count)
+	PerfSeekChildSeekCount      = PerfMetric(C.rocksdb_seek_child_seek_count)
+)
+
+// SetPerfLevel sets the perf level of the calling OS thread. Like the perf
+// context, it is thread local: goroutines using it must be locked to their
+// thread with runtime.LockOSThread.
+// https://github.com/facebook/rocksdb/wiki/Perf-Context-and-IO-Stats-Context
+func SetPerfLevel(level PerfLevel) {
+	C.rocksdb_set_perf_level(C.int(level))
+}
+
+// PerfContext gives access to the perf context of the OS thread it was
+// created on, which counts the work done by the reads of that thread
+type PerfContext struct {
+	cContext *C.rocksdb_perfcontext_t
+}
+
+// NewPerfContext returns the perf context of the calling OS thread
+func NewPerfContext() *PerfContext {
+	return &PerfContext{cContext: C.rocksdb_perfcontext_create()}
+}
+
+// Reset zeroes the metrics of the perf context
+func (p *PerfContext) Reset() {
+	C.rocksdb_perfcontext_reset(p.cContext)
+}
+
+// Metric returns the value of a metric of the perf context
+func (p *PerfContext) Metric(metric PerfMetric) uint64 {
+	return uint64(C.rocksdb_perfcontext_metric(p.cContext, C.int(metric)))
+}
+
+// Report returns the metrics of the perf context as text, without the ones
+// which are zero if excludeZeroCounters is set
+func (p *PerfContext) Report(excludeZeroCounters bool) string {
+	cReport := C.rocksdb_perfcontext_report(p.cContext, BoolToChar(excludeZeroCounters))
+	defer C.free(unsafe.Pointer(cReport))
+	return C.GoString(cReport)
+}
+
+// Destroy frees the perf context handle, the metrics of the thread are kept
+func (p *PerfContext) Destroy() {
+	C.rocksdb_perfcontext_destroy(p.cContext)
+	p.cContext = nil
+}
@@ -0,0 +1,147 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocksdb
+
+/*
+// @fb-only: #include "rocksdb/src/include/rocksdb/c.h"
+#cgo pkg-config: "rocksdb"
+#include "rocksdb/c.h" // @oss-only
+*/
+import "C"
+
+import (
+	"strings"
+)
+
+// HistogramData is a snapshot of a statistics histogram
+type HistogramData struct {
+	Median  float64
+	P95     float64
+	P99     float64
+	Average float64
+	Count   uint64
+	Sum     uint64
+}
+
+// statisticsNames maps ticker and histogram names to their ids
+type statisticsNames struct {
+	tickers    map[string]uint32
+	histograms map[string]uint32
+}
+
+// parseStatisticsNames derives the ids of tickers and histograms from the
+// statistics string, where RocksDB lists them in the order of their ids.
+// Tickers are listed first, as "name COUNT : value", then histograms, as
+// "name P50 : value P95 : value ...".
+// Resolving names at runtime keeps the ids in line with the linked library.
+func parseStatisticsNames(s string) *statisticsNames {
+	names := &statisticsNames{
+		tickers:    make(map[string]uint32),
+		histograms: make(map[string]uint32),
+	}
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[1] {
+		case "COUNT":
+			names.tickers[fields[0]] = uint32(len(names.tickers)) //nolint:gosec
+		case "P50":
+			names.histograms[fields[0]] = uint32(len(names.histograms)) //nolint:gosec
+		}
+	}
+	return names
+}
+
+// Statistics gives access to the tickers and histograms of Options with
+// statistics enabled
+// https://github.com/facebook/rocksdb/wiki/Statistics
+type Statistics struct {
+	options *Options
+	names   *statisticsNames
+}
+
+// GetStatistics returns the statistics of the options, nil if they are not enabled
+func (options *Options) GetStatistics() *Statistics {
+	options.statisticsNamesOnce.Do(func() {
+		if s := options.GetStatisticsString(); s != "" {
+			options.statisticsNames = parseStatisticsNames(s)
+		}
+	})
+	if options.statisticsNames == nil {
+		return nil
+	}
+	return &Statistics{options: options, names: options.statisticsNames}
+}
+
+// TickerCount returns the value of the ticker, e.g. "rocksdb.block.cache.hit";
+// false if there is no such ticker
+func (s *Statistics) TickerCount(name string) (uint64, bool) {
+	id, ok := s.names.tickers[name]
+	if !ok {
+		return 0, false
+	}
+	return uint64(C.rocksdb_options_statistics_get_ticker_count(s.options.cOptions, C.uint32_t(id))), true
+}
+
+// Histogram returns the data of the histogram, e.g. "rocksdb.db.get.micros";
+// false if there is no such histogram
+func (s *Statistics) Histogram(name string) (HistogramData, bool) {
+	id, ok := s.names.histograms[name]
+	if !ok {
+		return HistogramData{}, false
+	}
+	cData := C.rocksdb_statistics_histogram_data_create()
+	defer C.rocksdb_statistics_histogram_data_destroy(cData)
+	C.rocksdb_options_statistics_get_histogram_data(s.options.cOptions, C.uint32_t(id), cData)
+	return HistogramData{
+		Median:  float64(C.rocksdb_statistics_histogram_data_get_median(cData)),
+		P95:     float64(C.rocksdb_statistics_histogram_data_get_p95(cData)),
+		P99:     float64(C.rocksdb_statistics_histogram_data_get_p99(cData)),
+		Average: float64(C.rocksdb_statistics_histogram_data_get_average(cData)),
+		Count:   uint64(C.rocksdb_statistics_histogram_data_get_count(cData)),
+		Sum:     uint64(C.rocksdb_statistics_histogram_data_get_sum(cData)),
+	}, true
+}
+
+// LiveFileMetaData describes an SST file of the database
+type LiveFileMetaData struct {
+	Name      string
+	Level     int
+	Size      int64
+	Entries   uint64
+	Deletions uint64
+}
+
+// GetLiveFilesMetaData returns the metadata of the SST files of the database
+func (db *RocksDB) GetLiveFilesMetaData() []LiveFileMetaData {
+	cFiles := C.rocksdb_livefiles(db.cDB)
+	defer C.rocksdb_livefiles_destroy(cFiles)
+	count := int(C.rocksdb_livefiles_count(cFiles))
+	files := make([]LiveFileMetaData, count)
+	for i := range files {
+		cIndex := C.int(i)
+		files[i] = LiveFileMetaData{
+			Name:      C.GoString(C.rocksdb_livefiles_name(cFiles, cIndex)),
+			Level:     int(C.rocksdb_livefiles_level(cFiles, cIndex)),
+			Size:      int64(C.rocksdb_livefiles_size(cFiles, cIndex)),
+			Entries:   uint64(C.rocksdb_livefiles_entries(cFiles, cIndex)),
+			Deletions: uint64(C.rocksdb_livefiles_deletions(cFiles, cIndex)),
+		}
+	}
+	return files
+}
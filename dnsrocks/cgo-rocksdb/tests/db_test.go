@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -23,6 +25,7 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -479,6 +482,221 @@ func TestBatch(t *testing.T) {
 	}
 }
 
+// appendOperator is a merge operator appending operands to values,
+// separated by commas
+type appendOperator struct{}
+
+func (appendOperator) Name() string {
+	return "append"
+}
+
+func (appendOperator) FullMerge(_, existingValue []byte, operands [][]byte) ([]byte, bool) {
+	values := operands
+	if existingValue != nil {
+		values = append([][]byte{existingValue}, operands...)
+	}
+	return bytes.Join(values, []byte(",")), true
+}
+
+func (appendOperator) PartialMerge(_ []byte, operands [][]byte) ([]byte, bool) {
+	return bytes.Join(operands, []byte(",")), true
+}
+
+// openMergeDB opens a new database using appendOperator
+func openMergeDB(t *testing.T) *rocksdb.RocksDB {
+	dir := t.TempDir()
+	options := rocksdb.NewOptions()
+	options.EnableCreateIfMissing()
+	options.SetMergeOperator(appendOperator{})
+	mergeDB, err := rocksdb.OpenDatabase(dir, false, false, options)
+	if err != nil {
+		options.FreeOptions()
+		t.Fatalf("Cannot create database: %s", err.Error())
+	}
+	t.Cleanup(mergeDB.CloseDatabase)
+	return mergeDB
+}
+
+// TestSharedMemory opens databases sharing a block cache and a write buffer
+// manager
+func TestSharedMemory(t *testing.T) {
+	cache := rocksdb.NewLRUCache(16 << 20)
+	defer cache.FreeLRUCache()
+	wbm := rocksdb.NewWriteBufferManager(4<<20, cache)
+	defer wbm.FreeWriteBufferManager()
+	if size := wbm.BufferSize(); size != 4<<20 {
+		t.Errorf("Write buffer manager size mismatch: %d / %d", size, 4<<20)
+	}
+
+	for i := 0; i < 2; i++ {
+		options := rocksdb.NewOptions()
+		options.EnableCreateIfMissing()
+		options.SetLRUCacheSize(1 << 20)
+		options.SetBlockCache(cache)
+		options.SetWriteBufferManager(wbm)
+		if options.GetCache() != cache {
+			t.Fatalf("Options don't use the shared cache")
+		}
+		sharedDB, err := rocksdb.OpenDatabase(t.TempDir(), false, false, options)
+		if err != nil {
+			options.FreeOptions()
+			t.Fatalf("Cannot create database: %s", err.Error())
+		}
+		if err := sharedDB.Put(writeOptions, []byte("key"), []byte("value")); err != nil {
+			t.Fatalf("Error writing bytes: %s", err.Error())
+		}
+		// closing frees the options, but not the shared cache
+		sharedDB.CloseDatabase()
+	}
+	if capacity := cache.GetCapacity(); capacity != 16<<20 {
+		t.Errorf("Shared cache capacity mismatch: %d / %d", capacity, 16<<20)
+	}
+}
+
+// TestMerge tests Merge, directly and in batches, concurrently
+func TestLatestSequenceNumber(t *testing.T) {
+	before := db.GetLatestSequenceNumber()
+	if err := db.Put(writeOptions, []byte("seqkey"), []byte("v")); err != nil {
+		t.Fatalf("Error writing bytes: %s", err.Error())
+	}
+	if seq := db.GetLatestSequenceNumber(); seq != before+1 {
+		t.Errorf("Sequence number mismatch after put: %d / %d", seq, before+1)
+	}
+
+	batch := db.NewBatch()
+	defer batch.Destroy()
+	batch.Put([]byte("seqkey1"), []byte("v"))
+	batch.Put([]byte("seqkey2"), []byte("v"))
+	batch.Delete([]byte("seqkey"))
+	if err := db.ExecuteBatch(batch, writeOptions); err != nil {
+		t.Fatalf("Error executing write batch: %s", err.Error())
+	}
+	if seq := db.GetLatestSequenceNumber(); seq != before+4 {
+		t.Errorf("Sequence number mismatch after batch: %d / %d", seq, before+4)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	mergeDB := openMergeDB(t)
+	wo := rocksdb.NewDefaultWriteOptions()
+	defer wo.FreeWriteOptions()
+	ro := rocksdb.NewDefaultReadOptions()
+	defer ro.FreeReadOptions()
+
+	key := []byte("key")
+	if err := mergeDB.Put(wo, key, []byte("a")); err != nil {
+		t.Fatalf("Error writing bytes: %s", err.Error())
+	}
+	const numWriters = 8
+	var wg sync.WaitGroup
+	for i := 0; i < numWriters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := mergeDB.Merge(wo, key, []byte("b")); err != nil {
+				t.Errorf("Error merging bytes: %s", err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+
+	batch := mergeDB.NewBatch()
+	defer batch.Destroy()
+	batch.Merge(key, []byte("c"))
+	batch.Merge([]byte("newkey"), []byte("d"))
+	if err := mergeDB.ExecuteBatch(batch, wo); err != nil {
+		t.Fatalf("Error executing write batch: %s", err.Error())
+	}
+
+	expected := "a" + strings.Repeat(",b", numWriters) + ",c"
+	if res, err := mergeDB.GetStr(ro, "key"); err != nil {
+		t.Errorf("Error reading string: %s", err.Error())
+	} else if res != expected {
+		t.Errorf("String mismatch: %s / %s", res, expected)
+	}
+	if res, err := mergeDB.GetStr(ro, "newkey"); err != nil {
+		t.Errorf("Error reading string: %s", err.Error())
+	} else if res != "d" {
+		t.Errorf("String mismatch: %s / %s", res, "d")
+	}
+}
+
+// TestBatchWithIndex tests reading back the operations of a batch before executing it
+func TestBatchWithIndex(t *testing.T) {
+	mergeDB := openMergeDB(t)
+	wo := rocksdb.NewDefaultWriteOptions()
+	defer wo.FreeWriteOptions()
+	ro := rocksdb.NewDefaultReadOptions()
+	defer ro.FreeReadOptions()
+
+	if err := mergeDB.PutStr(wo, "merged", "a"); err != nil {
+		t.Fatalf("Error writing string: %s", err.Error())
+	}
+	if err := mergeDB.PutStr(wo, "deleted", "a"); err != nil {
+		t.Fatalf("Error writing string: %s", err.Error())
+	}
+
+	batch := rocksdb.NewBatchWithIndex(false)
+	defer batch.Destroy()
+	batch.Put([]byte("put"), []byte("b"))
+	batch.Merge([]byte("merged"), []byte("b"))
+	batch.Delete([]byte("deleted"))
+	if itemCount := batch.GetCount(); itemCount != 3 {
+		t.Errorf("Batch size mismatch: %d / %d", itemCount, 3)
+	}
+
+	expected := map[string][]byte{
+		"put":     []byte("b"),
+		"merged":  []byte("a,b"),
+		"deleted": nil,
+		"missing": nil,
+	}
+	for key, value := range expected {
+		if res, err := batch.GetFromBatchAndDB(mergeDB, ro, []byte(key)); err != nil {
+			t.Errorf("Error reading bytes: %s", err.Error())
+		} else if !bytes.Equal(res, value) {
+			t.Errorf("Byte mismatch for %s: %v / %v", key, res, value)
+		}
+	}
+	if res, err := batch.GetFromBatch(mergeDB.GetOptions(), []byte("put")); err != nil {
+		t.Errorf("Error reading bytes: %s", err.Error())
+	} else if !bytes.Equal(res, []byte("b")) {
+		t.Errorf("Byte mismatch: %v / %v", res, []byte("b"))
+	}
+
+	// the DB is unchanged until the batch is executed
+	iter := batch.CreateIteratorWithBase(mergeDB.CreateIterator(ro))
+	var keys []string
+	for iter.SeekToFirst(); iter.IsValid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.FreeIterator()
+	if strings.Join(keys, " ") != "merged put" {
+		t.Errorf("Keys mismatch: %v", keys)
+	}
+	if res, err := mergeDB.GetStr(ro, "merged"); err != nil {
+		t.Errorf("Error reading string: %s", err.Error())
+	} else if res != "a" {
+		t.Errorf("String mismatch: %s / %s", res, "a")
+	}
+
+	if err := mergeDB.ExecuteBatchWithIndex(batch, wo); err != nil {
+		t.Fatalf("Error executing write batch: %s", err.Error())
+	}
+	for key, value := range expected {
+		if res, err := mergeDB.Get(ro, []byte(key)); err != nil {
+			t.Errorf("Error reading bytes: %s", err.Error())
+		} else if !bytes.Equal(res, value) {
+			t.Errorf("Byte mismatch for %s: %v / %v", key, res, value)
+		}
+	}
+
+	batch.Clear()
+	if itemCount := batch.GetCount(); itemCount != 0 {
+		t.Errorf("The batch still has %d elements", itemCount)
+	}
+}
+
 // fillValues adds count of kv pairs matching provided format
 func fillValues(keyFmt, valFmt string, count int) error {
 	batch := db.NewBatch()
@@ -494,6 +712,90 @@ func fillValues(keyFmt, valFmt string, count int) error {
 	return nil
 }
 
+// TestStatistics tests reading tickers, histograms and live files
+func TestStatistics(t *testing.T) {
+	if db.GetOptions().GetStatistics() != nil {
+		t.Errorf("Expected no statistics when they are not enabled")
+	}
+
+	dir := t.TempDir()
+	options := rocksdb.NewOptions()
+	options.EnableCreateIfMissing()
+	options.EnableStatistics()
+	statsDB, err := rocksdb.OpenDatabase(dir, false, false, options)
+	if err != nil {
+		options.FreeOptions()
+		t.Fatalf("Cannot create database: %s", err.Error())
+	}
+	defer statsDB.CloseDatabase()
+
+	for i := 0; i < 10; i++ {
+		if err := statsDB.Put(writeOptions, []byte(fmt.Sprintf("stats_key%d", i)), []byte("value")); err != nil {
+			t.Fatalf("Error writing bytes: %s", err.Error())
+		}
+	}
+	if err := statsDB.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err.Error())
+	}
+	if _, err := statsDB.Get(readOptions, []byte("stats_key0")); err != nil {
+		t.Fatalf("Error reading bytes: %s", err.Error())
+	}
+
+	statistics := statsDB.GetOptions().GetStatistics()
+	if statistics == nil {
+		t.Fatalf("Expected statistics to be enabled")
+	}
+	if _, ok := statistics.TickerCount("rocksdb.block.cache.miss"); !ok {
+		t.Errorf("Expected ticker rocksdb.block.cache.miss")
+	}
+	if _, ok := statistics.TickerCount("rocksdb.no.such.ticker"); ok {
+		t.Errorf("Unexpected ticker rocksdb.no.such.ticker")
+	}
+	if _, ok := statistics.Histogram("rocksdb.db.get.micros"); !ok {
+		t.Errorf("Expected histogram rocksdb.db.get.micros")
+	}
+	if _, ok := statistics.Histogram("rocksdb.block.cache.miss"); ok {
+		t.Errorf("Unexpected histogram rocksdb.block.cache.miss")
+	}
+
+	files := statsDB.GetLiveFilesMetaData()
+	if len(files) == 0 {
+		t.Fatalf("Expected live files after flush")
+	}
+	var entries uint64
+	for _, f := range files {
+		if f.Size <= 0 || f.Name == "" {
+			t.Errorf("Unexpected file metadata %+v", f)
+		}
+		entries += f.Entries
+	}
+	if entries != 10 {
+		t.Errorf("Expected 10 entries in live files, got %d", entries)
+	}
+}
+
+// TestPerfContext tests reading the perf context of the calling thread
+func TestPerfContext(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	rocksdb.SetPerfLevel(rocksdb.PerfLevelEnableTimeExceptForMutex)
+	defer rocksdb.SetPerfLevel(rocksdb.PerfLevelDisable)
+	perf := rocksdb.NewPerfContext()
+	defer perf.Destroy()
+
+	if _, err := db.Get(readOptions, []byte("perf_key")); err != nil {
+		t.Fatalf("Error reading bytes: %s", err.Error())
+	}
+	perf.Report(false)
+
+	perf.Reset()
+	for _, metric := range []rocksdb.PerfMetric{rocksdb.PerfUserKeyComparisonCount, rocksdb.PerfBlockReadCount, rocksdb.PerfSeekChildSeekCount} {
+		if v := perf.Metric(metric); v != 0 {
+			t.Errorf("Expected metric %d to be reset, got %d", metric, v)
+		}
+	}
+}
+
 // TestMulti tests writing (with batches) and reading (with GetMulti) multiple values.
 func TestMulti(t *testing.T) {
 	const batchSize = 10000
@@ -528,6 +830,14 @@ func TestMulti(t *testing.T) {
 		}
 		batch.Delete(requestKeys[i])
 	}
+	if responses[batchSize] != nil {
+		t.Errorf("Expected nil value for missing key, got %v", responses[batchSize])
+	}
+	// values share a buffer, but must not overlap
+	responses[0] = append(responses[0], 'x')
+	if !bytes.Equal(responses[1], expectedResponses[1]) {
+		t.Errorf("Value of key %v modified by appending to the previous one: %v", requestKeys[1], responses[1])
+	}
 
 	// validate that the batch contains the expected number of Delete()
 	if itemCount := batch.GetCount(); itemCount != batchSize {
@@ -635,6 +945,47 @@ func TestIterator(t *testing.T) {
 		iter.Prev()
 		checkPair(1234)
 	})
+
+	t.Run("TestIterator_Bounds", func(t *testing.T) {
+		t.Parallel()
+
+		boundedOptions := rocksdb.NewDefaultReadOptions()
+		defer boundedOptions.FreeReadOptions()
+		lower, upper := []byte(fmt.Sprintf(keyFmt, 100)), []byte(fmt.Sprintf(keyFmt, 200))
+		boundedOptions.SetIterateLowerBound(lower)
+		boundedOptions.SetIterateUpperBound(upper)
+		// the bounds must have been copied
+		copy(lower, fmt.Sprintf(keyFmt, 0))
+		copy(upper, fmt.Sprintf(keyFmt, 999))
+
+		iter := db.CreateIterator(boundedOptions)
+		defer iter.FreeIterator()
+
+		count := 0
+		for iter.SeekToFirst(); iter.IsValid(); iter.Next() {
+			if count == 0 {
+				if expected := fmt.Sprintf(keyFmt, 100); string(iter.Key()) != expected {
+					t.Errorf("Key mismatch: expected %s, got %s", expected, iter.Key())
+				}
+			}
+			count++
+		}
+		if count != 100 {
+			t.Errorf("Expected 100 keys within bounds, got %d", count)
+		}
+
+		iter.SeekForPrev([]byte(fmt.Sprintf(keyFmt, 50)))
+		if iter.IsValid() {
+			t.Errorf("Expected no key below the lower bound, got %s", iter.Key())
+		}
+
+		// changing the bound affects the following seeks
+		boundedOptions.SetIterateLowerBound([]byte(fmt.Sprintf(keyFmt, 10)))
+		iter.SeekForPrev([]byte(fmt.Sprintf(keyFmt, 50)))
+		if !iter.IsValid() || string(iter.Key()) != fmt.Sprintf(keyFmt, 50) {
+			t.Errorf("Expected key %s after changing the lower bound", fmt.Sprintf(keyFmt, 50))
+		}
+	})
 }
 
 // TestSnapshot tests isolation between a snapshot and latest view
@@ -17,6 +17,7 @@ limitations under the License.
 package rocksdbtest
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"testing"
@@ -172,6 +173,44 @@ func BenchmarkGetMulti(b *testing.B) {
 	}
 }
 
+// BenchmarkGetMultiValueSize benchmarks GetMulti on values of various sizes,
+// where copying values is significant
+func BenchmarkGetMultiValueSize(b *testing.B) {
+	const keysCount = 100
+	testSizes := []int{16, 256, 4096, 65536}
+	for _, size := range testSizes {
+		b.Run(fmt.Sprintf("BenchmarkGetMultiValueSize%d", size), func(b *testing.B) {
+			keys := make([][]byte, keysCount)
+			batch := db.NewBatch()
+			defer batch.Destroy()
+			value := bytes.Repeat([]byte{'v'}, size)
+			for i := 0; i < keysCount; i++ {
+				keys[i] = []byte(fmt.Sprintf("bench_value_size%d_key%06d", size, i))
+				batch.Put(keys[i], value)
+			}
+			if err := db.ExecuteBatch(batch, writeOptions); err != nil {
+				b.Errorf("Error executing write batch: %s", err.Error())
+			}
+
+			b.ReportAllocs()
+			b.SetBytes(int64(size * keysCount))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				res, errs := db.GetMulti(readOptions, keys)
+				for _, err := range errs {
+					if err != nil {
+						b.Error(err)
+					}
+				}
+				if len(res) != keysCount {
+					b.Errorf("GetMulti mismatch: expected %d, got %d", keysCount, len(res))
+				}
+			}
+		})
+	}
+}
+
 // BenchmarkIteratorGet benchmarks Iterator
 func BenchmarkIteratorGet(b *testing.B) {
 	testSizes := []int{10, 100, 1000, 100000, 1000000}
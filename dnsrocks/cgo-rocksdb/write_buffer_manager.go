@@ -0,0 +1,51 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocksdb
+
+/*
+// @fb-only: #include "rocksdb/src/include/rocksdb/c.h"
+#cgo pkg-config: "rocksdb"
+#include "rocksdb/c.h" // @oss-only
+*/
+import "C"
+
+// WriteBufferManager is a box for rocksdb_write_buffer_manager_t. It bounds
+// the memory used by the memtables of all the databases it is set on.
+type WriteBufferManager struct {
+	cWriteBufferManager *C.rocksdb_write_buffer_manager_t
+}
+
+// NewWriteBufferManager creates a WriteBufferManager limiting memtables to
+// bufferSize bytes, charged to cache so that they count against its capacity
+// along with the blocks it holds
+func NewWriteBufferManager(bufferSize int, cache *LRUCache) *WriteBufferManager {
+	return &WriteBufferManager{
+		cWriteBufferManager: C.rocksdb_write_buffer_manager_create_with_cache(
+			C.size_t(bufferSize), cache.cCache, C.bool(false),
+		),
+	}
+}
+
+// BufferSize returns the memtable memory limit
+func (w *WriteBufferManager) BufferSize() int {
+	return int(C.rocksdb_write_buffer_manager_buffer_size(w.cWriteBufferManager))
+}
+
+// FreeWriteBufferManager destroys an instance of WriteBufferManager, once
+// the databases using it are closed
+func (w *WriteBufferManager) FreeWriteBufferManager() {
+	C.rocksdb_write_buffer_manager_destroy(w.cWriteBufferManager)
+}
@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -15,33 +17,143 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 
+	"github.com/facebook/dns/dnsrocks/dnsdata"
 	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb/dbdiff"
 )
 
 func main() {
 	inputFileName := flag.String("i", "", "File path to input dns data diff")
 	serial := flag.Uint("serial", 0, "Value for the Serial field of the changed SOA records")
 	outputDirPath := flag.String("o", "", "Output directory path to write compiled DNS DB")
+	artifact := flag.String("artifact", "", "File path to a diff artifact to apply, instead of a text diff")
+	emit := flag.String("emit-artifact", "", "File path to write the text diff compiled as an artifact to, instead of applying it")
+	fromSerial := flag.Uint("from-serial", 0, "With -emit-artifact, provenance serial of the DB the artifact applies to")
+	version := flag.String("dataset-version", "", "With -emit-artifact, version of the dataset the artifact produces")
+	v2Keys := flag.Bool("useV2Keys", true, "With -emit-artifact, compile records with the V2 keys syntax, as dnsrocks-data does by default")
+	dryRun := flag.Bool("dry-run", false, "With a text diff, report the keys and values it would add and remove without writing them")
+	verify := flag.Bool("verify", false, "With a text diff, read the changed keys back once written and fail if they don't hold the expected values")
+	samples := flag.Int("samples", rdb.DefaultChangeSamples, "With a text diff, number of added and removed values listed in the summary of changes")
+	mergeAdds := flag.Bool("merge-adds", false, "With a text diff, write the values of diffs which only add values as RocksDB merge operands instead of reading and rewriting the values of their keys. Databases with merge operands can't be read by binaries predating them until fully compacted, e.g. with dnsrocks-compactrdb.")
+	migrateV2Keys := flag.Bool("migrate-v2-keys", false, "Migrate the DB in place from the V1 keys syntax to the V2 one, keeping the V1 keys until -remove-v1-keys")
+	removeV1Keys := flag.Bool("remove-v1-keys", false, "Remove the V1 keys left by -migrate-v2-keys, once all readers use the V2 ones")
+	emitMigration := flag.String("emit-migration-artifact", "", "File path to write an artifact migrating the DB from the V1 keys syntax to the V2 one to")
+	batchSize := flag.Int("batch-size", rdb.DefaultBatchSize, "With -migrate-v2-keys and -remove-v1-keys, number of keys written at a time")
 	flag.Parse()
 
-	if *inputFileName != "" {
-		if err := rdb.ApplyDiff(*inputFileName, *outputDirPath); err != nil {
+	if *migrateV2Keys {
+		n, err := rdb.MigrateToV2Keys(*outputDirPath, *batchSize)
+		if err != nil {
 			log.Fatal(err)
 		}
-	} else {
+		log.Printf("Wrote %d V2 keys", n)
+	} else if *removeV1Keys {
+		n, err := rdb.RemoveV1Keys(*outputDirPath, *batchSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Removed %d V1 keys", n)
+	} else if *emitMigration != "" {
+		n, err := rdb.WriteKeyMigrationArtifact(*outputDirPath, *emitMigration)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Wrote %d records to %s", n, *emitMigration)
+	} else if *emit != "" {
 		if *serial == 0 {
 			log.Fatal("Need to specify serial")
 		}
-		db, err := rdb.NewUpdater(*outputDirPath)
+		in := os.Stdin
+		if *inputFileName != "" {
+			f, err := os.Open(*inputFileName)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			in = f
+		}
+		out, err := os.Create(*emit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n, err := rdb.CompileDiffArtifact(in, out, dbdiff.ArtifactHeader{
+			FromSerial: uint32(*fromSerial), //nolint:gosec
+			ToSerial:   uint32(*serial),     //nolint:gosec
+			Version:    *version,
+			UseV2Keys:  *v2Keys,
+		})
+		if err == nil {
+			err = out.Close()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Wrote %d records to %s", n, *emit)
+	} else if *artifact != "" {
+		h, err := rdb.ApplyArtifact(*artifact, *outputDirPath)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer db.Close()
-		if err := db.ApplyDiff(os.Stdin, uint32(*serial)); err != nil {
+		log.Printf("Updated DB from serial %d to %d, version %q", h.FromSerial, h.ToSerial, h.Version)
+	} else {
+		opts := rdb.ApplyOptions{DryRun: *dryRun, Verify: *verify, MaxSamples: *samples}
+		if *samples == 0 {
+			opts.MaxSamples = -1
+		}
+		updaterOpts := rdb.UpdaterOptions{MergeAdds: *mergeAdds}
+		var summary *rdb.ChangeSummary
+		var err error
+		if *inputFileName != "" {
+			summary, err = applyFile(*inputFileName, *outputDirPath, updaterOpts, opts)
+		} else {
+			if *serial == 0 {
+				log.Fatal("Need to specify serial")
+			}
+			summary, err = applyStdin(*outputDirPath, uint32(*serial), updaterOpts, opts) //nolint:gosec
+		}
+		if summary != nil {
+			if *dryRun {
+				log.Printf("Would change %s", summary)
+			} else {
+				log.Printf("Changed %s", summary)
+			}
+		}
+		if err != nil {
 			log.Fatal(err)
 		}
 	}
 }
+
+// applyFile applies the text diff at diffpath to the database at dbpath, with
+// the SOA serial derived from the diff
+func applyFile(diffpath, dbpath string, updaterOpts rdb.UpdaterOptions, opts rdb.ApplyOptions) (*rdb.ChangeSummary, error) {
+	file, err := os.Open(diffpath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: can't open input: %w", diffpath, err)
+	}
+	defer file.Close()
+	serial, err := dnsdata.DeriveSerial(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: can't derive SOA serial: %w", diffpath, err)
+	}
+	db, err := rdb.NewUpdaterWithOptions(dbpath, updaterOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return db.ApplyDiffWithOptions(file, serial, opts)
+}
+
+// applyStdin applies the text diff read from stdin to the database at dbpath
+func applyStdin(dbpath string, serial uint32, updaterOpts rdb.UpdaterOptions, opts rdb.ApplyOptions) (*rdb.ChangeSummary, error) {
+	db, err := rdb.NewUpdaterWithOptions(dbpath, updaterOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return db.ApplyDiffWithOptions(os.Stdin, serial, opts)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/facebook/dns/dnsrocks/bench"
+)
+
+func main() {
+	d := bench.DefaultDataset
+	flag.IntVar(&d.Zones, "zones", d.Zones, "number of zones of the synthetic data set")
+	flag.IntVar(&d.NamesPerZone, "names", d.NamesPerZone, "number of names of each zone")
+	flag.IntVar(&d.RecordsPerName, "records", d.RecordsPerName, "number of A and of AAAA records of each name, per location")
+	flag.IntVar(&d.Locations, "locations", d.Locations, "number of locations of the map of every name, 0 for names without map")
+	flag.IntVar(&d.SubnetsPerLocation, "subnets", d.SubnetsPerLocation, "number of client subnets of each location")
+	flag.Float64Var(&d.NXDomainRatio, "nxdomain", d.NXDomainRatio, "fraction of queries for names which don't exist")
+	flag.Int64Var(&d.Seed, "seed", d.Seed, "seed of the queries")
+	queries := flag.Int("queries", 100000, "number of queries per target")
+	concurrency := flag.Int("concurrency", 1, "number of concurrent queries")
+	targetNames := flag.String("targets", "", "comma separated targets to benchmark, all of them if empty")
+	dir := flag.String("dir", "", "directory to write the data set and DBs to, a temporary one if empty")
+	jsonOutput := flag.Bool("json", false, "print results as JSON")
+	flag.Parse()
+
+	targets := bench.Targets
+	if *targetNames != "" {
+		targets = nil
+		for _, name := range strings.Split(*targetNames, ",") {
+			t, err := bench.FindTarget(strings.TrimSpace(name))
+			if err != nil {
+				log.Fatal(err)
+			}
+			targets = append(targets, t)
+		}
+	}
+
+	if *dir == "" {
+		tmp, err := os.MkdirTemp("", "dnsrocks-bench")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(tmp)
+		*dir = tmp
+	}
+	dataPath := path.Join(*dir, "data")
+	f, err := os.Create(dataPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := d.WriteData(f); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	reqs, err := bench.Requests(d.Queries(*queries))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var results []bench.Result
+	for _, t := range targets {
+		log.Printf("building %s", t.Name)
+		dbPath, err := t.Build(dataPath, *dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		h, err := t.Open(dbPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// warm up caches the same way for every target
+		bench.Run(t.Name, h, reqs[:len(reqs)/10], *concurrency)
+		log.Printf("running %d queries against %s", len(reqs), t.Name)
+		results = append(results, bench.Run(t.Name, h, reqs, *concurrency))
+		h.Close()
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tQUERIES\tERRORS\tQPS\tP50\tP90\tP99\tMAX")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.0f\t%v\t%v\t%v\t%v\n", r.Target, r.Queries, r.Errors, r.QPS, r.P50, r.P90, r.P99, r.Max)
+	}
+	w.Flush()
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// dnsrocks-conformance runs DNS and EDNS wire format compliance probes against
+// a dnsrocks instance it spawns on a CDB/RDB file, or against a running
+// server, and reports the outcome of each probe. It exits with status 1 if
+// any probe fails, to gate releases.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/facebook/dns/dnsrocks/conformance"
+	"github.com/facebook/dns/dnsrocks/fbserver"
+)
+
+func main() {
+	var opts conformance.Options
+	conf := fbserver.NewServerConfig()
+	server := flag.String("server", "", "Address of a running server to probe, rather than spawning one on -dbpath.")
+	flag.StringVar(&conf.DBConfig.Path, "dbpath", "", "Path to the CDB/RDB the spawned server serves.")
+	flag.StringVar(&conf.DBConfig.Driver, "dbdriver", "rocksdb", "DB driver (cdb or rocksdb)")
+	flag.BoolVar(&conf.HandlerConfig.CNAMEChasing, "cname-chasing", false, "Whether or not the spawned server does CNAME chasing.")
+	flag.StringVar(&opts.Zone, "zone", "", "Zone the server is authoritative for.")
+	flag.StringVar(&opts.Name, "name", "", "Name of the zone with A records. (default: the zone)")
+	flag.StringVar(&opts.TruncName, "tc-name", "", "Name of the zone whose A records don't fit in 512 bytes, to probe truncation. (default: truncation not probed)")
+	flag.StringVar(&opts.NXName, "nxdomain-name", "", "Name of the zone that doesn't exist, nor matches a wildcard. (default: nxdomain-conformance-probe.<zone>)")
+	flag.StringVar(&opts.OutOfZone, "out-of-zone", conformance.DefaultOutOfZone, "Name the server is not authoritative for.")
+	flag.DurationVar(&opts.Timeout, "timeout", conformance.DefaultTimeout, "Timeout of each query.")
+	probeNames := flag.String("probes", "", "Comma separated probes to run. (default: all)")
+	jsonOutput := flag.Bool("json", false, "Print the report as JSON.")
+	list := flag.Bool("list", false, "List the probes and exit.")
+	flag.Parse()
+
+	if *list {
+		for _, probe := range conformance.Probes {
+			fmt.Printf("%-10s %s\n", probe.Name, probe.Description)
+		}
+		return
+	}
+	if opts.Zone == "" {
+		log.Fatalf("-zone is required")
+	}
+	var names []string
+	if *probeNames != "" {
+		names = strings.Split(*probeNames, ",")
+	}
+	probes, err := conformance.SelectProbes(names)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	var srv *fbserver.Server
+	if *server == "" {
+		if conf.DBConfig.Path == "" {
+			log.Fatalf("-server or -dbpath is required")
+		}
+		if srv, err = conformance.StartServer(conf); err != nil {
+			log.Fatalf("Failed to start server: %s", err)
+		}
+		addrs := srv.Addrs()
+		*server, opts.TCPServer = addrs["udp"], addrs["tcp"]
+	}
+
+	report := conformance.Run(context.Background(), *server, opts, probes)
+	if srv != nil {
+		srv.Shutdown()
+	}
+	if *jsonOutput {
+		err = report.WriteJSON(os.Stdout)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if report.Failed() > 0 {
+		os.Exit(1)
+	}
+}
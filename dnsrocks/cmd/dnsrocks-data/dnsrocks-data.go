@@ -0,0 +1,194 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/cdb"
+)
+
+func main() {
+	inputFileName := flag.String("i", "data", "Comma separated paths to input dns data files, or directories whose files are read in file name order")
+	outputPath := flag.String("o", "", "Output path to write compiled DNS DB")
+	useHardlinks := flag.Bool("h", false, "While using RDB builder allows to move files instead of copying during ingestion phase. It is faster, but doesn't work on filesystems that don't support hardlinks")
+	rmOld := flag.Bool("rm", false, "Remove all files from output path before compiling")
+	numCPU := flag.Int("numcpu", 1, "control parallelism, 0 means all available CPUs")
+	batchNum := flag.Int("batchnum", defaultBatchNum, "(RocksDB-only) controls number of parallel RDB batches when not using builder")
+	batchSize := flag.Int("batchsize", defaultBatchSize, "(RocksDB-only) controls size of batches. Use with batchnum flag to limit memory consumption")
+	useBuilder := flag.Bool("b", true, "(RocksDB-only) Use RDB builder (fast and furious)")
+	memoryLimitMB := flag.Int64("memoryLimitMB", 0, "(RocksDB-only) When using builder, spill the parsed records to sorted runs on disk whenever they take that many MiB in memory, to compile datasets larger than memory. 0 keeps them all in memory")
+	spillDir := flag.String("spillDir", "", "(RocksDB-only) Directory where the builder spills records with -memoryLimitMB, the default directory for temporary files if empty")
+	useV2Keys := flag.Bool("useV2Keys", true, "(RocksDB-only) Use V2 keys syntax")
+	strictNames := flag.Bool("strictNames", false, "Reject owner names with bad length, charset or punycode")
+	convertIDN := flag.Bool("convertIDN", false, "Convert U-labels in owner names to A-labels (punycode)")
+	emptyNonTerminals := flag.Bool("emptyNonTerminals", false, "Emit markers for the empty non-terminals of zones, so that queries for them are answered with NODATA rather than NXDOMAIN")
+	var reverseZones []dnsdata.ReverseZone
+	flag.Func("reverseZone", "Generate the PTR records of a reverse zone from the A and AAAA records of forward zones, as prefix=zone[,zone...], e.g. 10.0.0.0/8=example.com. The SOA and NS records of the first zone are copied unless the data has a SOA for it. Can be repeated", func(s string) error {
+		z, err := dnsdata.ParseReverseZone(s)
+		if err == nil {
+			reverseZones = append(reverseZones, z)
+		}
+		return err
+	})
+	var httpsZones []dnsdata.HTTPSZone
+	flag.Func("httpsZone", "Synthesize an HTTPS record advertising alpn, h2 and h3 by default, with address hints for the names of a zone with A or AAAA records and no HTTPS record, as zone[=alpn[|alpn...]], e.g. example.com=h2|h3. Can be repeated", func(s string) error {
+		z, err := dnsdata.ParseHTTPSZone(s)
+		if err == nil {
+			httpsZones = append(httpsZones, z)
+		}
+		return err
+	})
+	serial := flag.Uint("serial", 0, "SOA serial of records without one, for output which only depends on the input data. 0 to derive it from the input modification time")
+	dbDriver := flag.String("dbdriver", "rocksdb", "DB driver (cdb or rocksdb)")
+	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to `file`")
+	memprofile := flag.String("memprofile", "", "write memory profile to `file`")
+	strictLocations := flag.Bool("strict-locations", false, "Fail on subnets mapped to different locations in the same map, rocksdb only")
+	duplicates := flag.String("duplicates", "keep", "What to do with records written more than once in the input: keep, drop or error")
+	datasetVersion := flag.String("dataset-version", "", "Version of the dataset, e.g. a publish ID, stored in the DB with the SOA serial for servers to report")
+	checksum := flag.Bool("checksum", false, "Store the checksum of the DB in it, for servers to verify and report")
+	signingKey := flag.String("signing-key", "", "PEM Ed25519 private key `file` signing the checksum stored in the DB, implies -checksum")
+	dryRunAgainst := flag.String("dry-run-against", "", "Instead of writing the DB, print a summary of the changes relative to the given previous data `file`")
+	dryRunJSON := flag.Bool("dry-run-json", false, "Print the dry run summary as JSON")
+	flag.Parse()
+
+	inputs := strings.Split(*inputFileName, ",")
+	duplicatePolicy, err := dnsdata.ParseDuplicatePolicy(*duplicates)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var signingPrivateKey ed25519.PrivateKey
+	if *signingKey != "" {
+		if signingPrivateKey, err = dnsdata.LoadSigningKey(*signingKey); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *cpuprofile != "" {
+		f, err := os.Create(*cpuprofile)
+		if err != nil {
+			log.Fatal("could not create CPU profile: ", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatal("could not start CPU profile: ", err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	if *dryRunAgainst != "" {
+		if len(inputs) > 1 {
+			log.Fatal("dry runs take a single input")
+		}
+		if err := dryRun(*dryRunAgainst, *inputFileName, *numCPU, *dryRunJSON); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	switch *dbDriver {
+	case "rocksdb":
+		o := rdbOptions{
+			Hardlinks:         *useHardlinks,
+			NumCPU:            *numCPU,
+			UseBuilder:        *useBuilder,
+			MemoryLimit:       *memoryLimitMB << 20,
+			SpillDir:          *spillDir,
+			BatchNum:          *batchNum,
+			BatchSize:         *batchSize,
+			V2Keys:            *useV2Keys,
+			StrictNames:       *strictNames,
+			ConvertIDN:        *convertIDN,
+			EmptyNonTerminals: *emptyNonTerminals,
+			ReverseZones:      reverseZones,
+			HTTPSZones:        httpsZones,
+			Serial:            uint32(*serial), // nolint:gosec
+			Duplicates:        duplicatePolicy,
+			StrictLocations:   *strictLocations,
+			Version:           *datasetVersion,
+			Checksum:          *checksum,
+			SigningKey:        signingPrivateKey,
+		}
+		writtenRecs, err := compileRDB(inputs, *outputPath, *rmOld, o)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("%d records written", writtenRecs)
+	case "cdb":
+		if *useHardlinks {
+			log.Fatal("Cannot use hardlinks with driver cdb")
+		}
+		if *rmOld {
+			if err := os.RemoveAll(*outputPath); err != nil {
+				log.Fatal(err)
+			}
+		}
+		options := &cdb.CreatorOptions{
+			NumCPU:            *numCPU,
+			StrictNames:       *strictNames,
+			ConvertIDN:        *convertIDN,
+			EmptyNonTerminals: *emptyNonTerminals,
+			ReverseZones:      reverseZones,
+			HTTPSZones:        httpsZones,
+			Serial:            uint32(*serial), // nolint:gosec
+			Duplicates:        duplicatePolicy,
+			Version:           *datasetVersion,
+			Checksum:          *checksum,
+			SigningKey:        signingPrivateKey,
+		}
+		writtenRecs, err := cdb.CreateCDBFromInputs(inputs, *outputPath, options)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%d records written", writtenRecs)
+	default:
+		log.Fatalf("unsupported db driver '%s'", *dbDriver)
+	}
+
+	if *memprofile != "" {
+		f, err := os.Create(*memprofile)
+		if err != nil {
+			log.Fatal("could not create memory profile: ", err)
+		}
+		runtime.GC() // get up-to-date statistics
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			log.Fatal("could not write memory profile: ", err)
+		}
+		f.Close()
+	}
+}
+
+// dryRun prints the impact of publishing the data at path instead of the one
+// at previousPath, without writing any DB
+func dryRun(previousPath, path string, workers int, asJSON bool) error {
+	impact, err := dnsdata.CompareFiles(previousPath, path, workers)
+	if err != nil {
+		return err
+	}
+	if !asJSON {
+		return impact.WriteText(os.Stdout)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(impact)
+}
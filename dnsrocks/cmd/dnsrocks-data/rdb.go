@@ -0,0 +1,83 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/ed25519"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+)
+
+const (
+	defaultBatchNum  = rdb.DefaultBatchNum
+	defaultBatchSize = rdb.DefaultBatchSize
+)
+
+// rdbOptions are the RocksDB-only compilation options
+type rdbOptions struct {
+	Hardlinks         bool
+	NumCPU            int
+	UseBuilder        bool
+	MemoryLimit       int64
+	SpillDir          string
+	BatchNum          int
+	BatchSize         int
+	V2Keys            bool
+	StrictNames       bool
+	ConvertIDN        bool
+	EmptyNonTerminals bool
+	ReverseZones      []dnsdata.ReverseZone
+	HTTPSZones        []dnsdata.HTTPSZone
+	Serial            uint32
+	Duplicates        dnsdata.DuplicatePolicy
+	StrictLocations   bool
+	Version           string
+	Checksum          bool
+	SigningKey        ed25519.PrivateKey
+}
+
+// compileRDB compiles the data files of inputs into a RocksDB at output,
+// removing its previous files first if rmOld
+func compileRDB(inputs []string, output string, rmOld bool, o rdbOptions) (int, error) {
+	// cleanup output directory
+	if rmOld {
+		if err := rdb.CleanRDBDir(output); err != nil {
+			return 0, err
+		}
+	}
+	return rdb.CompileInputsToRDB(inputs, output, rdb.CompilationOptions{
+		BuilderUseHardlinks: o.Hardlinks,
+		NumCPU:              o.NumCPU,
+		UseBuilder:          o.UseBuilder,
+		BuilderMemoryLimit:  o.MemoryLimit,
+		SpillDir:            o.SpillDir,
+		BatchNumParallel:    o.BatchNum,
+		BatchSize:           o.BatchSize,
+		UseV2KeySyntax:      o.V2Keys,
+		StrictNames:         o.StrictNames,
+		ConvertIDN:          o.ConvertIDN,
+		EmptyNonTerminals:   o.EmptyNonTerminals,
+		ReverseZones:        o.ReverseZones,
+		HTTPSZones:          o.HTTPSZones,
+		Serial:              o.Serial,
+		Duplicates:          o.Duplicates,
+		StrictLocations:     o.StrictLocations,
+		Version:             o.Version,
+		Checksum:            o.Checksum,
+		SigningKey:          o.SigningKey,
+	})
+}
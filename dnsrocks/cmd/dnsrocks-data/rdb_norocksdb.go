@@ -0,0 +1,56 @@
+//go:build !cgo || norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/ed25519"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// the RocksDB batch defaults, unused without the rocksdb driver
+const (
+	defaultBatchNum  = 0
+	defaultBatchSize = 0
+)
+
+// rdbOptions are the RocksDB-only compilation options
+type rdbOptions struct {
+	Hardlinks         bool
+	NumCPU            int
+	UseBuilder        bool
+	MemoryLimit       int64
+	SpillDir          string
+	BatchNum          int
+	BatchSize         int
+	V2Keys            bool
+	StrictNames       bool
+	ConvertIDN        bool
+	EmptyNonTerminals bool
+	ReverseZones      []dnsdata.ReverseZone
+	HTTPSZones        []dnsdata.HTTPSZone
+	Serial            uint32
+	Duplicates        dnsdata.DuplicatePolicy
+	StrictLocations   bool
+	Version           string
+	Checksum          bool
+	SigningKey        ed25519.PrivateKey
+}
+
+func compileRDB(_ []string, _ string, _ bool, _ rdbOptions) (int, error) {
+	return 0, db.ErrRocksDBUnsupported
+}
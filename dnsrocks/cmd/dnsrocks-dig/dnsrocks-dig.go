@@ -0,0 +1,176 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// dnsrocks-dig answers queries from a CDB/RDB file without running a server,
+// and traces the DB probes done to answer them.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+	"golang.org/x/term"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+// describeRow returns the text form of a resource record row, or an empty
+// string if it is not one
+func describeRow(row []byte) string {
+	if len(row) < 3 {
+		return ""
+	}
+	ch := row[2]
+	wildcard := ch == '*' || ch == '*'+1
+	if !wildcard && ch != '=' && ch != '='+1 {
+		return ""
+	}
+	rec, err := db.ExtractRRFromRow(row, wildcard)
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s ttl=%d", dnsdata.WireType(rec.Qtype), rec.TTL)
+	if rec.Qtype == dns.TypeA || rec.Qtype == dns.TypeAAAA {
+		fmt.Fprintf(&b, " weight=%d", rec.Weight)
+	}
+	if wildcard {
+		b.WriteString(" wildcard")
+	}
+	if ch == '='+1 || ch == '*'+1 {
+		b.WriteString(" location-specific")
+	}
+	hdr := dns.RR_Header{Name: ".", Rrtype: rec.Qtype, Class: dns.ClassINET, Ttl: rec.TTL, Rdlength: uint16(len(row) - rec.Offset)}
+	if rr, _, err := dns.UnpackRRWithHeader(hdr, row, rec.Offset); err == nil {
+		fmt.Fprintf(&b, " %s", strings.TrimPrefix(rr.String(), rr.Header().String()))
+	}
+	return b.String()
+}
+
+// trace prints DB probes
+func trace(op string, key []byte, values [][]byte, err error) {
+	switch {
+	case err != nil:
+		fmt.Printf(";; %-10s %q: error %v\n", op, key, err)
+	case len(values) == 0:
+		fmt.Printf(";; %-10s %q: not found\n", op, key)
+	default:
+		fmt.Printf(";; %-10s %q: %d found\n", op, key, len(values))
+	}
+	// only values of find and foreach probes can be resource records
+	rows := op == db.TraceFind || op == db.TraceForEach
+	for _, v := range values {
+		if row := describeRow(v); rows && row != "" {
+			fmt.Printf(";;   %s\n", row)
+		} else {
+			fmt.Printf(";;   %q\n", v)
+		}
+	}
+}
+
+// query answers a query and prints the response and metadata of qname
+func query(tdb *dnsserver.FBDNSDB, qType, qName, resolver, subnet string, maxans int) error {
+	fmt.Printf(";; QUERY %s %s from %s", qName, qType, resolver)
+	if subnet != "" {
+		fmt.Printf(" subnet %s", subnet)
+	}
+	fmt.Println()
+	rec, err := tdb.QuerySingle(qType, qName, resolver, subnet, maxans)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n%s\n", dns.RcodeToString[rec.Rcode], rec.Msg)
+	metadata, err := tdb.QueryMetadata(qName)
+	if err != nil {
+		return fmt.Errorf("failed to get metadata: %w", err)
+	}
+	for _, m := range metadata {
+		fmt.Printf(";; METADATA: %s\n", m)
+	}
+	return nil
+}
+
+// interactive reads queries as `qname [qtype [resolver [subnet]]]` lines
+func interactive(tdb *dnsserver.FBDNSDB, qType, resolver, subnet string, maxans int) error {
+	prompt := term.IsTerminal(int(os.Stdin.Fd()))
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		if prompt {
+			fmt.Print("> ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+		f := strings.Fields(scanner.Text())
+		if len(f) == 0 {
+			continue
+		}
+		args := []string{f[0], qType, resolver, subnet}
+		copy(args, f)
+		if err := query(tdb, args[1], args[0], args[2], args[3], maxans); err != nil {
+			fmt.Printf(";; ERROR: %v\n", err)
+		}
+	}
+	return scanner.Err()
+}
+
+func main() {
+	var (
+		maxans        int
+		err           error
+		tdb           *dnsserver.FBDNSDB
+		cacheConfig   dnsserver.CacheConfig
+		dbConfig      dnsserver.DBConfig
+		handlerConfig dnsserver.HandlerConfig
+	)
+	flag.StringVar(&dbConfig.Path, "dbpath", "", "Path to CDB")
+	flag.StringVar(&dbConfig.Driver, "dbdriver", "rocksdb", "DB driver (cdb or rocksdb)")
+	flag.IntVar(&maxans, "maxans", 1, "Max number of answer server should return.")
+	flag.BoolVar(&handlerConfig.CNAMEChasing, "cname-chasing", false, "Whether or not to do CNAME chasing.")
+	flag.IntVar(&handlerConfig.MaxCNAMEHops, "max-cname-hops", 10, "Max number of hops for CNAME chasing.")
+	qType := flag.String("qtype", "A", "Type of the query")
+	qName := flag.String("qname", "", "Name to query. Queries are read from stdin as 'qname [qtype [resolver [subnet]]]' lines if empty.")
+	resolver := flag.String("resolver", "127.0.0.1", "IP of the resolver to simulate the query from.")
+	subnet := flag.String("subnet", "", "client subnet")
+	verbose := flag.Bool("trace", true, "Trace the DB probes: keys probed, maps and locations matched, records found.")
+	flag.Parse()
+
+	if tdb, err = dnsserver.NewFBDNSDB(handlerConfig, dbConfig, cacheConfig, &dnsserver.DummyLogger{}, &stats.DummyStats{}); err != nil {
+		log.Fatalf("Failed to instantiate DB: %s", err)
+	}
+	if err = tdb.Load(); err != nil {
+		log.Fatalf("Failed to load DB: %s %s", dbConfig.Path, err)
+	}
+	if *verbose {
+		if err = tdb.SetTracer(trace); err != nil {
+			log.Fatalf("Failed to trace DB: %s", err)
+		}
+	}
+
+	if *qName == "" {
+		err = interactive(tdb, *qType, *resolver, *subnet, maxans)
+	} else {
+		err = query(tdb, *qType, *qName, *resolver, *subnet, maxans)
+	}
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+}
@@ -113,6 +113,49 @@ func processRecs(recs []dns.RR) map[string][]string {
 	return results
 }
 
+// rootName is normalizeDot keeping the root name, e.g. for root zone records
+func rootName(s string) string {
+	if n := normalizeDot(s); n != "" {
+		return n
+	}
+	return "."
+}
+
+// processReferralRecs converts a referral-only zone, e.g. the root zone
+// (RFC 8806): its SOA, its NS records, the DS records of its delegations and
+// their glue are kept with their TTLs, DNSSEC records are dropped.
+func processReferralRecs(recs []dns.RR) []string {
+	var soa, ns, ds, glue []string
+	for _, rr := range recs {
+		switch v := rr.(type) {
+		case *dns.SOA:
+			// Zfqdn,mname,rname,ser,ref,ret,exp,min,ttl,timestamp,lo
+			soa = append(soa, fmt.Sprintf("Z%s,%s,%s,%d,%d,%d,%d,%d,%d", rootName(v.Hdr.Name), rootName(v.Ns), rootName(v.Mbox), v.Serial, v.Refresh, v.Retry, v.Expire, v.Minttl, v.Hdr.Ttl))
+		case *dns.NS:
+			// &fqdn,ip,x,ttl,timestamp,lo
+			ns = append(ns, fmt.Sprintf("&%s,,%s,%d", rootName(v.Hdr.Name), rootName(v.Ns), v.Hdr.Ttl))
+		case *dns.DS:
+			// Kfqdn,keytag,algorithm,digesttype,digest,ttl,timestamp,lo
+			ds = append(ds, fmt.Sprintf("K%s,%d,%d,%d,%s,%d", rootName(v.Hdr.Name), v.KeyTag, v.Algorithm, v.DigestType, v.Digest, v.Hdr.Ttl))
+		case *dns.A:
+			// +fqdn,ip,ttl,timestamp,lo
+			glue = append(glue, fmt.Sprintf("+%s,%s,%d", normalizeDot(v.Hdr.Name), v.A, v.Hdr.Ttl))
+		case *dns.AAAA:
+			// +fqdn,ip,ttl,timestamp,lo
+			glue = append(glue, fmt.Sprintf("+%s,%s,%d", normalizeDot(v.Hdr.Name), v.AAAA, v.Hdr.Ttl))
+		// ignore, the zone is served unsigned
+		case *dns.RRSIG, *dns.NSEC, *dns.NSEC3, *dns.NSEC3PARAM, *dns.DNSKEY, *dns.ZONEMD:
+		default:
+			log.Warningf("Unsupported record type %T in referral-only zone", rr)
+		}
+	}
+	lines := soa
+	for _, group := range [][]string{ns, ds, glue} {
+		lines = append(lines, group...)
+	}
+	return lines
+}
+
 func getSOAns(origin string) []string {
 	result := []string{
 		fmt.Sprintf("Z%s,a.ns.facebook.com,dns.facebook.com,,14400,1800,604800,3600,3600", origin),
@@ -126,11 +169,23 @@ func getSOAns(origin string) []string {
 func main() {
 	rawOrigin := flag.String("origin", "", "Zone's origin to fill apex records if not explicitly specified")
 	addSOA := flag.Bool("addSOA", true, "If we should generate SOA and NS records")
+	referral := flag.Bool("referral", false, "Convert a referral-only zone, e.g. the root zone: keep its SOA, NS, DS and glue records and their TTLs")
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Convert DNS Zone in BIND/RFC 1035 format to TinyDNS/FBDNS format.\nWARNING: Ignores NS/SOA records.\nTTL < 3600 are set to 3600\n")
+		fmt.Fprintf(os.Stderr, "Convert DNS Zone in BIND/RFC 1035 format to TinyDNS/FBDNS format.\nWARNING: Ignores NS/SOA records, unless -referral is set.\nTTL < 3600 are set to 3600\n")
 		fmt.Fprintf(os.Stderr, "Usage: %s -origin example.com < /tmp/zone.bind > /tmp/zone.tiny\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s -referral < /tmp/root.zone > /tmp/root.tiny\n", os.Args[0])
 	}
 	flag.Parse()
+	if *referral {
+		rrs, err := getRecs(os.Stdin, *rawOrigin)
+		if err != nil {
+			log.Fatalf("Failed parsing: %v", err)
+		}
+		for _, line := range processReferralRecs(rrs) {
+			fmt.Println(line)
+		}
+		return
+	}
 	if *addSOA && *rawOrigin == "" {
 		log.Fatal("You need to specify 'origin' for SOA and NS is records")
 	}
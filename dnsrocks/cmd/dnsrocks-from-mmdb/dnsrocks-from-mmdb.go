@@ -0,0 +1,97 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/geoip"
+)
+
+func main() {
+	mmdbPath := flag.String("mmdb", "", "Path to the MaxMind-format (MMDB) database")
+	configPath := flag.String("config", "", "Path to the JSON config mapping countries/ASNs to location IDs")
+	useRanger := flag.Bool("ranger", false, "Emit range point (\"!\") records instead of \"%\" records")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Convert a MaxMind-format geo database to FBDNS subnet-to-location records.\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s -mmdb GeoLite2-Country.mmdb -config geoip.json > /tmp/nets.data\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *mmdbPath == "" || *configPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*mmdbPath, *configPath, *useRanger); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run writes the records converted from the MMDB database at mmdbPath to
+// stdout. What was written is flushed even on error, the error telling the
+// output is incomplete.
+func run(mmdbPath, configPath string, useRanger bool) error {
+	conf, err := geoip.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	src, err := geoip.OpenMMDB(mmdbPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	err = writeRecords(out, src, conf, useRanger)
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// writeRecords writes the "%" records of src to out, or with useRanger the
+// range point records they are preprocessed into
+func writeRecords(out io.Writer, src *geoip.MMDB, conf *geoip.Config, useRanger bool) error {
+	if !useRanger {
+		n, err := geoip.WriteNets(out, src, conf)
+		if err != nil {
+			return err
+		}
+		log.Printf("%d records written", n)
+		return nil
+	}
+
+	// feed generated "%" records through the preprocessor, same as dnsrocks-preproc
+	codec := new(dnsdata.Codec)
+	codec.Acc.Ranger.Enable()
+	codec.Acc.NoPrefixSets = true
+	codec.NoRnetOutput = true
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := geoip.WriteNets(pw, src, conf)
+		pw.CloseWithError(err)
+	}()
+	err := codec.Preprocess(pr, out)
+	// unblock the writer if the preprocessor stopped reading early
+	pr.CloseWithError(err)
+	return err
+}
@@ -14,17 +14,58 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/miekg/dns"
 
+	"github.com/facebook/dns/dnsrocks/dnsjson"
 	"github.com/facebook/dns/dnsrocks/dnsserver"
 	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
 )
 
+// queryBatch resolves the queries listed in the JSON file at path, and prints
+// the results as JSON. Queries without type or client get the default ones.
+// With rfc8427, the results also hold the responses in the RFC 8427 format.
+func queryBatch(tdb *dnsserver.FBDNSDB, path, qType, resolver string, maxans int, rfc8427 bool) error {
+	var (
+		data []byte
+		err  error
+	)
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	var queries []dnsserver.Query
+	if err = json.Unmarshal(data, &queries); err != nil {
+		return fmt.Errorf("failed to parse queries: %w", err)
+	}
+	for i := range queries {
+		if queries[i].Type == "" {
+			queries[i].Type = qType
+		}
+		if queries[i].Client == "" {
+			queries[i].Client = resolver
+		}
+	}
+	results := tdb.QueryBatch
+	if rfc8427 {
+		results = tdb.QueryBatchRFC8427
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results(queries, maxans))
+}
+
 func main() {
 	var (
 		maxans        int
@@ -41,17 +82,66 @@ func main() {
 	qName := flag.String("qname", "", "Name to query")
 	resolver := flag.String("resolver", "127.0.0.1", "IP of the resolver to simulate the query from.")
 	subnet := flag.String("subnet", "", "client subnet")
+	batch := flag.String("batch", "", "Path to a JSON list of queries ({\"name\", \"type\", \"client\", \"ecs\"} objects) to resolve instead, - for stdin. Results are printed as JSON.")
+	rfc8427 := flag.Bool("rfc8427", false, "Print the response as RFC 8427 JSON, with the metadata of the name as comment. With -batch, add it to the results as \"message\".")
+	auditNames := flag.Bool("audit-names", false, "Instead of querying, list the owner names spelled in more than one way (case, trailing dots) in the DB keys, as JSON, and exit with status 1 if there is any.")
 	flag.Parse()
 
-	if tdb, err = dnsserver.NewFBDNSDB(handlerConfig, dbConfig, cacheConfig, &dnsserver.TextLogger{IoWriter: os.Stdout}, &stats.DummyStats{}); err != nil {
+	var logger dnsserver.Logger = &dnsserver.TextLogger{IoWriter: os.Stdout}
+	if *batch != "" || *auditNames || *rfc8427 {
+		// keep the output JSON
+		logger = &dnsserver.DummyLogger{}
+	}
+	if tdb, err = dnsserver.NewFBDNSDB(handlerConfig, dbConfig, cacheConfig, logger, &stats.DummyStats{}); err != nil {
 		log.Fatalf("Failed to instantiate DB: %s", err)
 	}
 	if err = tdb.Load(); err != nil {
 		log.Fatalf("Failed to load DB: %s %s", dbConfig.Path, err)
 	}
+	if *auditNames {
+		conflicts, err := tdb.AuditOwnerNames()
+		if err != nil {
+			log.Fatalf("Failed to audit owner names: %s", err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(conflicts); err != nil {
+			log.Fatalf("%s", err)
+		}
+		if len(conflicts) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if *batch != "" {
+		if err = queryBatch(tdb, *batch, *qType, *resolver, maxans, *rfc8427); err != nil {
+			log.Fatalf("%s", err)
+		}
+		return
+	}
 	rec, err := tdb.QuerySingle(*qType, *qName, *resolver, *subnet, maxans)
 	if err != nil {
 		log.Fatalf("%s", err)
 	}
+	metadata, err := tdb.QueryMetadata(*qName)
+	if err != nil {
+		log.Fatalf("Failed to get metadata: %s", err)
+	}
+	if *rfc8427 {
+		m, err := dnsjson.FromMsg(rec.Msg)
+		if err != nil {
+			log.Fatalf("Failed to convert response: %s", err)
+		}
+		m.Comment = strings.Join(metadata, "\n")
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(m); err != nil {
+			log.Fatalf("%s", err)
+		}
+		return
+	}
 	fmt.Printf("%s\n%s\n", dns.RcodeToString[rec.Rcode], rec.Msg)
+	for _, m := range metadata {
+		fmt.Printf(";; METADATA: %s\n", m)
+	}
 }
@@ -14,9 +14,12 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"runtime"
 	"runtime/pprof"
+	"strings"
 
+	"github.com/facebook/dns/dnsrocks/dnsdata"
 	"github.com/facebook/dns/dnsrocks/dnsdata/cdb"
 
 	"flag"
@@ -25,13 +28,45 @@ import (
 )
 
 func main() {
-	ipath := flag.String("i", "data", "File path to input dns data")
+	ipath := flag.String("i", "data", "Comma separated paths to input dns data files, or directories whose files are read in file name order")
 	opath := flag.String("o", "data.cdb", "Output path to write the dns DB")
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to `file`")
 	memprofile := flag.String("memprofile", "", "write memory profile to `file`")
 	numCPU := flag.Int("numcpu", 1, "number of CPUs to use for parsing in parallel, 0 means all")
+	strictNames := flag.Bool("strictNames", false, "Reject owner names with bad length, charset or punycode")
+	convertIDN := flag.Bool("convertIDN", false, "Convert U-labels in owner names to A-labels (punycode)")
+	emptyNonTerminals := flag.Bool("emptyNonTerminals", false, "Emit markers for the empty non-terminals of zones, so that queries for them are answered with NODATA rather than NXDOMAIN")
+	var reverseZones []dnsdata.ReverseZone
+	flag.Func("reverseZone", "Generate the PTR records of a reverse zone from the A and AAAA records of forward zones, as prefix=zone[,zone...], e.g. 10.0.0.0/8=example.com. The SOA and NS records of the first zone are copied unless the data has a SOA for it. Can be repeated", func(s string) error {
+		z, err := dnsdata.ParseReverseZone(s)
+		if err == nil {
+			reverseZones = append(reverseZones, z)
+		}
+		return err
+	})
+	var httpsZones []dnsdata.HTTPSZone
+	flag.Func("httpsZone", "Synthesize an HTTPS record advertising alpn, h2 and h3 by default, with address hints for the names of a zone with A or AAAA records and no HTTPS record, as zone[=alpn[|alpn...]], e.g. example.com=h2|h3. Can be repeated", func(s string) error {
+		z, err := dnsdata.ParseHTTPSZone(s)
+		if err == nil {
+			httpsZones = append(httpsZones, z)
+		}
+		return err
+	})
+	serial := flag.Uint("serial", 0, "SOA serial of records without one, for output which only depends on the input data. 0 to derive it from the input modification time")
+	duplicates := flag.String("duplicates", "keep", "What to do with records written more than once in the input: keep, drop or error")
+	datasetVersion := flag.String("dataset-version", "", "Version of the dataset, e.g. a publish ID, stored in the DB with the SOA serial for servers to report")
+	checksum := flag.Bool("checksum", false, "Store the checksum of the DB in it, for servers to verify and report")
+	signingKey := flag.String("signing-key", "", "PEM Ed25519 private key `file` signing the checksum stored in the DB, implies -checksum")
+	dryRunAgainst := flag.String("dry-run-against", "", "Instead of writing the DB, print a summary of the changes relative to the given previous data `file`")
+	dryRunJSON := flag.Bool("dry-run-json", false, "Print the dry run summary as JSON")
 	flag.Parse()
 
+	inputs := strings.Split(*ipath, ",")
+	duplicatePolicy, err := dnsdata.ParseDuplicatePolicy(*duplicates)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -43,10 +78,34 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
+	if *dryRunAgainst != "" {
+		if len(inputs) > 1 {
+			log.Fatal("dry runs take a single input")
+		}
+		if err := dryRun(*dryRunAgainst, *ipath, *numCPU, *dryRunJSON); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	options := &cdb.CreatorOptions{
-		NumCPU: *numCPU,
+		NumCPU:            *numCPU,
+		StrictNames:       *strictNames,
+		ConvertIDN:        *convertIDN,
+		EmptyNonTerminals: *emptyNonTerminals,
+		ReverseZones:      reverseZones,
+		HTTPSZones:        httpsZones,
+		Serial:            uint32(*serial), // nolint:gosec
+		Duplicates:        duplicatePolicy,
+		Version:           *datasetVersion,
+		Checksum:          *checksum,
+	}
+	if *signingKey != "" {
+		if options.SigningKey, err = dnsdata.LoadSigningKey(*signingKey); err != nil {
+			log.Fatal(err)
+		}
 	}
-	nw, err := cdb.CreateCDB(*ipath, *opath, options)
+	nw, err := cdb.CreateCDBFromInputs(inputs, *opath, options)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -64,3 +123,18 @@ func main() {
 		f.Close()
 	}
 }
+
+// dryRun prints the impact of publishing the data at path instead of the one
+// at previousPath, without writing any DB
+func dryRun(previousPath, path string, workers int, asJSON bool) error {
+	impact, err := dnsdata.CompareFiles(previousPath, path, workers)
+	if err != nil {
+		return err
+	}
+	if !asJSON {
+		return impact.WriteText(os.Stdout)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(impact)
+}
@@ -23,11 +23,13 @@ import (
 
 func main() {
 	serial := flag.Int("serial", 0, "optional SOA serial")
+	strictLocations := flag.Bool("strict-locations", false, "fail on subnets mapped to different locations in the same map")
 	flag.Parse()
 	codec := new(dnsdata.Codec)
 	codec.Acc.Ranger.Enable()
 	codec.Acc.NoPrefixSets = true
 	codec.NoRnetOutput = true
+	codec.Acc.Ranger.Strict = *strictLocations
 	if *serial > 0 {
 		codec.Serial = uint32(*serial)
 	}
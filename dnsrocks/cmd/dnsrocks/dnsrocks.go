@@ -0,0 +1,503 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/quote"
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+	"github.com/facebook/dns/dnsrocks/fbserver"
+	"github.com/facebook/dns/dnsrocks/flagconfig"
+	"github.com/facebook/dns/dnsrocks/logger"
+	"github.com/facebook/dns/dnsrocks/metrics"
+
+	"github.com/golang/glog"
+
+	_ "net/http/pprof"
+)
+
+func setCPU(cpu string) (int, error) {
+	var numCPU int
+
+	availCPU := runtime.NumCPU()
+
+	if strings.HasSuffix(cpu, "%") {
+		// Percent
+		var percent float32
+		pctStr := cpu[:len(cpu)-1]
+		pctInt, err := strconv.Atoi(pctStr)
+		if err != nil || pctInt < 1 || pctInt > 100 {
+			return -1, errors.New("invalid CPU value: percentage must be between 1-100")
+		}
+		percent = float32(pctInt) / 100
+		numCPU = int(float32(availCPU) * percent)
+	} else {
+		// Number
+		num, err := strconv.Atoi(cpu)
+		if err != nil || num < 1 {
+			return -1, errors.New("invalid CPU value: provide a number or percent greater than 0")
+		}
+		numCPU = num
+	}
+
+	if numCPU > availCPU {
+		numCPU = availCPU
+	}
+
+	runtime.GOMAXPROCS(numCPU)
+	return numCPU, nil
+}
+
+func main() {
+	var serverConfig = fbserver.NewServerConfig()
+	var loggerConfig logger.Config
+	var doTTLSATtl uint64
+	var metricsAddr, thriftAddr string
+	var statsFlushInterval time.Duration
+	var toStderr bool
+	var verbosity int
+	var privacyKeyFile string
+	var answerSeedKeyFile string
+	var reloadChecksFile string
+	var healthChecksFile string
+	var checksumKeyFiles string
+	var recordCountZones string
+	const DefaultMetricsAddr string = ":18888"
+	cliflags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	// DNS Server config
+	cliflags.IntVar(&serverConfig.Port, "port", 8053, "port to run on")
+	cliflags.IntVar(&serverConfig.MaxUDPSize, "max-udp-size", 0, "Largest EDNS0 UDP buffer size honored and advertised in responses, larger ones are clamped to it, e.g. 1232 as per DNS flag day 2020. (default: none)")
+	cliflags.BoolVar(&serverConfig.TCP, "tcp", true, "Whether or not to also listen on TCP.")
+	cliflags.IntVar(&serverConfig.MaxTCPQueries, "tcp-max-queries", -1, "Maximum number of queries handled on a single TCP connection before closing the socket. This also applies for TLS. (unlimited if -1).")
+	// Idle Timeout default is based on miekg/dns original default: https://fburl.com/t0tmjp2c
+	cliflags.DurationVar(&serverConfig.TCPIdleTimeout, "tcp-idle-timeout", 8*time.Second, "TCP/TLS connections idle timeout. A connection TCP connection will be torn down if the TCP connection is idle for that time after first read.")
+	cliflags.DurationVar(&serverConfig.ReadTimeout, "read-timeout", 2*time.Second, "Sets the deadline for future Read calls and any currently-blocked Read call. A zero value means Read will not time out. For TCP, this value only applied to first read.")
+	cliflags.DurationVar(&statsFlushInterval, "stats-flush-interval", 0, "Minimum interval between aggregations of the counters exported, 0 to aggregate them on every export")
+
+	cliflags.IntVar(&serverConfig.ReusePort, "reuse-port", 0, "Whether or not to use SO_REUSEPORT when opening listeners. X = 0 to disable and start only 1 listener without SO_REUSEPORT, X > 0 to start X listeners with SO_REUSEPORT.")
+	cliflags.StringVar(&serverConfig.WhoamiDomain, "whoami-domain", "", "Domain name to answer debug queries. If empty, the functionality is disabled (default disabled)")
+	cliflags.StringVar(&serverConfig.DebugZone, "debug-zone", "", "Zone answering debug queries with the map and location matched for the client, e.g. whoami.dnsrocks.arpa. If empty, the functionality is disabled (default disabled)")
+	cliflags.BoolVar(&serverConfig.NSID, "nsid", false, "Flag to enable NSID responses with debug info (default: disabled)")
+	cliflags.BoolVar(&serverConfig.PrivateInfo, "private-info", false, "Flag to add encrypted debug info (default: disabled)")
+	cliflags.BoolVar(&serverConfig.RefuseANY, "refuse-any", false, "Whether or not to refuse ANY queries.")
+	// the default setup should be backward compatible with current spec: 1 IP address and maxanswer not specified
+	cliflags.Var(&serverConfig.IPAns, "ip", "IPs to bind to. Usage: -ip=::1 -ip=127.0.0.1 (default is wildcard)")
+	cliflags.Var(&serverConfig.IPAns, "ipwithmaxans", "Max number of answers returned by query for each ip, separated by comma. Usage: -ipwithmaxans 192.0.2.53,1  -ipwithmaxans 192.0.2.35,8")
+
+	// DNSSEC
+	cliflags.StringVar(&serverConfig.DNSSECConfig.Zones, "dnssec-zones", "", "Comma separated list of zones for which DNSSEC is enabled.")
+	cliflags.StringVar(&serverConfig.DNSSECConfig.Keys, "dnssec-keys", "", "Comma separated list of DNSSEC keyfile, as generated by `dnssec-keygen -a ECDSAP256SHA256 <zonename>`, to use for DNSSEC signing. Example: Kexample.com.+013+28484")
+	// Handler Config
+	cliflags.BoolVar(&serverConfig.HandlerConfig.AlwaysCompress, "alwaysCompress", false, "Enable unconditional compression of labels in server responses")
+	cliflags.BoolVar(&serverConfig.HandlerConfig.CNAMEChasing, "cname-chasing", false, "Whether or not to do CNAME chasing. (default: disabled)")
+	cliflags.IntVar(&serverConfig.HandlerConfig.MaxCNAMEHops, "max-cname-hops", 10, "Max number of hops to take while CNAME chasing. (default: 10)")
+	cliflags.BoolVar(&serverConfig.HandlerConfig.MinimalResponses, "minimal-responses", false, "Omit additional records when not required, i.e. outside of referrals. (default: disabled)")
+	cliflags.StringVar(&serverConfig.HandlerConfig.AnswerOrder, "answer-order", dnsserver.AnswerOrderDefault, "Order of the records of multi-value RRsets in answers. Empty to keep the database order (A and AAAA records being shuffled), 'shuffle' to shuffle them, 'fixed' to sort them, 'round-robin' to rotate the sorted records for each response. (default: database order)")
+	cliflags.StringVar(&answerSeedKeyFile, "answer-seed-key-file", "", "Path to the file containing the key used to seed the weighted random sampling of A and AAAA answers from the query name, client subnet and time. Empty to sample at random. (default: random)")
+	cliflags.DurationVar(&serverConfig.HandlerConfig.AnswerSeed.Window, "answer-seed-window", dnsserver.DefaultAnswerSeedWindow, "Duration during which seeded weighted answers stay the same for a client subnet.")
+	cliflags.DurationVar(&serverConfig.HandlerConfig.DBReadTimeout, "db-read-timeout", 0, "How long the RocksDB lookups of a query can take before it fails with SERVFAIL. 0 to disable. (default: disabled)")
+	cliflags.Float64Var(&serverConfig.HandlerConfig.PerfSampling.SampleRate, "perf-sample-rate", 0, "Fraction of queries whose RocksDB work (block reads, block cache hits, iterator seeks) is sampled, in [0.0, 1.0]. (default: disabled)")
+	cliflags.DurationVar(&serverConfig.HandlerConfig.PerfSampling.SlowThreshold, "perf-slow-threshold", 10*time.Millisecond, "How long sampled queries take at least for their RocksDB work to be logged, with -v 1")
+	cliflags.Func("response-padding", "Pad responses to queries with an EDNS0 padding option over encrypted transports to a multiple of a block size (RFC 7830), as 'transport[=size],...' with transports among dot, doh and doq, e.g. 'dot,doh'. The block size defaults to 468 bytes as per RFC 8467. (default: disabled)", func(s string) error {
+		sizes, err := dnsserver.ParsePaddingBlockSizes(s)
+		if err != nil {
+			return err
+		}
+		serverConfig.HandlerConfig.Padding.BlockSizes = sizes
+		return nil
+	})
+	cliflags.StringVar(&serverConfig.HandlerConfig.UnknownOptions.Policy, "edns-unknown-options", dnsserver.UnknownOptionsIgnore, "What to do with the EDNS0 options of queries the server does not implement. Empty to leave them out of responses, 'count' to also count them, 'echo' to echo the ones of -edns-allowed-options in responses and count them all. (default: ignore)")
+	cliflags.Func("edns-allowed-options", "Comma separated EDNS0 option codes echoed in responses with -edns-unknown-options echo, and counted on their own, e.g. '65001,65002'.", func(s string) error {
+		codes, err := dnsserver.ParseOptionCodes(s)
+		if err != nil {
+			return err
+		}
+		serverConfig.HandlerConfig.UnknownOptions.AllowedCodes = codes
+		return nil
+	})
+	cliflags.IntVar(&serverConfig.HandlerConfig.AnswerBudget.MaxAnswerRecords, "max-answer-records", 0, "Largest number of records in the answer section of responses. 0 for no limit. (default: no limit)")
+	cliflags.IntVar(&serverConfig.HandlerConfig.AnswerBudget.MaxAdditionalRecords, "max-additional-records", 0, "Largest number of records in the additional section of responses, OPT excluded. 0 for no limit. (default: no limit)")
+	cliflags.StringVar(&serverConfig.HandlerConfig.AnswerBudget.Overflow, "answer-overflow", dnsserver.OverflowTruncate, "What to do with records over -max-answer-records and -max-additional-records: 'truncate' drops them and sets the TC bit of UDP responses with answers dropped, 'trim' drops them silently, 'prefer-aaaa' drops A records first.")
+	cliflags.StringVar(&serverConfig.HandlerConfig.QuestionCount, "question-count", dnsserver.QuestionCountFirst, "How to answer queries without exactly one question: empty to answer the first question and SERVFAIL queries without any, 'formerr' to answer FORMERR, 'notimp' to answer NOTIMP. (default: first question)")
+	cliflags.StringVar(&serverConfig.HandlerConfig.NotAuthoritative.Policy, "not-authoritative", dnsserver.NotAuthoritativeRefuse, "How to answer queries for zones not served: 'refuse' to answer REFUSED, 'drop' to not answer. (default: refuse)")
+	cliflags.Func("not-authoritative-rule", "Policy for queries for zones not served, received by a listener or from sources, overriding -not-authoritative, as 'refuse|drop [listener=addr] [from=prefix,...]'. Can be repeated, the first matching rule applies.", func(s string) error {
+		r, err := dnsserver.ParseNotAuthoritativeRule(s)
+		if err != nil {
+			return err
+		}
+		serverConfig.HandlerConfig.NotAuthoritative.Rules = append(serverConfig.HandlerConfig.NotAuthoritative.Rules, r)
+		return nil
+	})
+	cliflags.Func("zone-quota", "Rate of queries allowed for zones and the names below them, shared by the zones listed, as 'zone[,zone...] qps=rate [burst=queries] [action=refuse|drop] [owner=tag]'. Can be repeated, the closest enclosing zone applies.", func(s string) error {
+		q, err := dnsserver.ParseZoneQuota(s)
+		if err != nil {
+			return err
+		}
+		serverConfig.HandlerConfig.ZoneQuotas = append(serverConfig.HandlerConfig.ZoneQuotas, q)
+		return nil
+	})
+	cliflags.Func("map-namespace", "Maps used instead of the ones names are assigned to for the queries received by listeners, as 'name listeners=addr,... maps=from=to,...' with map IDs written as in data files, e.g. 'corp listeners=192.0.2.53 maps=pr=corp'. Can be repeated, the first namespace with a matching listener applies.", func(s string) error {
+		n, err := dnsserver.ParseMapNamespace(s)
+		if err != nil {
+			return err
+		}
+		serverConfig.HandlerConfig.MapNamespaces = append(serverConfig.HandlerConfig.MapNamespaces, n)
+		return nil
+	})
+	cliflags.Func("ttl-clamp", "Lowest and highest TTLs of the records of responses, applied when responses are assembled, as '[min=ttl] [max=ttl]', e.g. 'min=30 max=86400'. Can be changed at runtime with the ttlclamp control file. (default: disabled)", func(s string) error {
+		c, err := dnsserver.ParseTTLClamp(s)
+		if err != nil {
+			return err
+		}
+		serverConfig.HandlerConfig.TTLClamp = c
+		return nil
+	})
+	cliflags.BoolVar(&serverConfig.HandlerConfig.PreserveQNameCase, "preserve-qname-case", false, "Copy the exact case of the query name in responses, cached ones included, for resolvers checking DNS 0x20 case randomization. (default: disabled)")
+	cliflags.StringVar(&serverConfig.HandlerConfig.ResolverPrivacy.Mode, "resolver-privacy", dnsserver.PrivacyModeOff, "Anonymize resolver IPs before map lookups and logging. Empty to disable, 'truncate' to keep only the configured prefix, 'hash' to also log a keyed hash of that prefix. (default: disabled)")
+	cliflags.IntVar(&serverConfig.HandlerConfig.ResolverPrivacy.IPv4PrefixLen, "resolver-privacy-v4-prefix", dnsserver.DefaultPrivacyIPv4PrefixLen, "Number of leading bits of IPv4 resolver addresses kept when resolver privacy is enabled.")
+	cliflags.IntVar(&serverConfig.HandlerConfig.ResolverPrivacy.IPv6PrefixLen, "resolver-privacy-v6-prefix", dnsserver.DefaultPrivacyIPv6PrefixLen, "Number of leading bits of IPv6 resolver addresses kept when resolver privacy is enabled.")
+	cliflags.StringVar(&privacyKeyFile, "resolver-privacy-hash-key-file", "", "Path to the file containing the key used to hash resolver IPs in 'hash' resolver privacy mode.")
+
+	// DB config
+	cliflags.IntVar(&serverConfig.DBConfig.ReloadInterval, "reloadtime", 10, "Time between each CDB reload")
+	cliflags.DurationVar(&serverConfig.DBConfig.ReloadTimeout, "reloadtimeout", time.Second, "Time to wait for DB to finish reload")
+	cliflags.StringVar(&reloadChecksFile, "reload-checks-file", "", "Path to the file of canary queries a new DB must answer as expected before a reload switches to it, one 'name type rcode [min-answers [client]]' per line.")
+	cliflags.BoolVar(&serverConfig.DBConfig.VerifyChecksum, "verify-checksum", false, "Recompute the checksum of the DB when opening it, and refuse DBs not matching the checksum stored by the compiler.")
+	cliflags.BoolVar(&serverConfig.DBConfig.RecordCounts.Enabled, "count-records", false, "Count the records of the DB at every load and reload, and export the counts with their change since the previous DB. This reads the whole DB. (default: disabled)")
+	cliflags.StringVar(&recordCountZones, "count-records-zones", "", "Comma separated zones whose records -count-records also counts on their own, at most 100.")
+	cliflags.StringVar(&checksumKeyFiles, "checksum-keys", "", "Comma separated paths to PEM Ed25519 public keys, one of which must have signed the checksum of the DB. Implies -verify-checksum.")
+	cliflags.StringVar(&serverConfig.ChecksumName, "checksum-name", "", "Name answering CH TXT queries with the checksum of the DB in use, e.g. checksum.dnsrocks. If empty, the functionality is disabled (default disabled)")
+	cliflags.StringVar(&serverConfig.VersionName, "version-name", "", "Name answering CH TXT queries with the version of the dataset in use, as stored by the compiler, e.g. version.dnsrocks. If empty, the functionality is disabled (default disabled)")
+	cliflags.IntVar(&serverConfig.VersionOption, "version-option", 0, "EDNS0 local option code, between 65001 and 65534, which queries carry to get the version of the dataset in use in the same option of responses. 0 to disable. (default: disabled)")
+	cliflags.IntVar(&serverConfig.ClientErrorsConfig.MaxSources, "client-errors-sources", 0, "Number of source subnets FORMERR, NOTIMP and EDNS violations are counted per, the errors of further subnets being counted together. 0 to disable. (default: disabled)")
+	cliflags.IntVar(&serverConfig.ClientErrorsConfig.IPv4PrefixLen, "client-errors-v4-prefix", fbserver.DefaultClientErrorsIPv4PrefixLen, "Length of the IPv4 source subnets of client errors")
+	cliflags.IntVar(&serverConfig.ClientErrorsConfig.IPv6PrefixLen, "client-errors-v6-prefix", fbserver.DefaultClientErrorsIPv6PrefixLen, "Length of the IPv6 source subnets of client errors")
+	cliflags.IntVar(&serverConfig.UDPPacingConfig.Rate, "udp-pacing-rate", 0, "Bytes per second of UDP responses sent to each destination address without delay, further responses being queued. 0 to disable. (default: disabled)")
+	cliflags.IntVar(&serverConfig.UDPPacingConfig.Burst, "udp-pacing-burst", 0, "Bytes of UDP responses sent at once to a destination before pacing applies. 0 for -udp-pacing-rate.")
+	cliflags.DurationVar(&serverConfig.UDPPacingConfig.MaxDelay, "udp-pacing-max-delay", fbserver.DefaultUDPPacingMaxDelay, "Longest a paced UDP response is queued, responses which would wait longer being replaced by empty truncated ones")
+	cliflags.IntVar(&serverConfig.UDPPacingConfig.MaxDestinations, "udp-pacing-destinations", fbserver.DefaultUDPPacingMaxDestinations, "Number of destination addresses paced at once, responses to further ones being sent without delay")
+	cliflags.BoolVar(&serverConfig.DBConfig.WatchDB, "watchdb", false, "Watch DB file change and reload")
+	cliflags.DurationVar(&serverConfig.DBConfig.WatchQuietPeriod, "watchdb-quiet-period", 0, "Time DB file changes must stop for before -watchdb reloads, coalescing the changes of a publish. 0 to reload on every change")
+	cliflags.DurationVar(&serverConfig.DBConfig.WatchMinInterval, "watchdb-min-interval", 0, "Minimum time between two reloads triggered by -watchdb. 0 for no limit")
+	cliflags.StringVar(&serverConfig.DBConfig.Path, "dbpath", "./rocksdb", "Path to the database")
+	cliflags.StringVar(&serverConfig.DBConfig.ControlPath, "control-path", "",
+		`Path to the control directory. When not empty, FBDNS watches given directory for trigger files that control DB reloads.
+Currently two types of trigger files are supported:
+* 'switchdb' - full reload trigger file, must contain new DB path as a text in it
+* 'reload' - partial reload (WAL catchup) trigger file, content of the file is ignored`)
+	cliflags.StringVar(&serverConfig.DBConfig.Driver, "dbdriver", "rocksdb", "Name of the database engine to use (cdb, memory to load a CDB in memory, rocksdb)")
+	cliflags.BoolVar(&serverConfig.DBConfig.LocationIndex, "location-index", false, "Load subnet to location maps in memory on each DB (re)load, to serve resolver and ECS location lookups without reading the DB. (default: disabled)")
+	cliflags.IntVar(&serverConfig.DBConfig.LocationCacheSize, "location-cache-size", 0, "Number of recent resolver and ECS location lookups to cache in memory, emptied on each DB reload. 0 to disable. (default: disabled)")
+	cliflags.BoolVar(&serverConfig.DBConfig.ReadOnly, "rdb-read-only", false, "Open RocksDB read-only instead of as a secondary instance, for DBs replaced rather than updated in place. (default: disabled)")
+	cliflags.DurationVar(&serverConfig.DBConfig.CatchUpInterval, "rdb-catchup-interval", 0, "Interval at which a RocksDB secondary catches up with its primary through a partial reload. 0 to disable. (default: disabled)")
+	cliflags.DurationVar(&serverConfig.DBConfig.MaxStaleness, "rdb-max-staleness", 0, "Time a RocksDB secondary can go without catching up with its primary before the rocksdb.catchup.stale counter is set. 0 to disable. (default: disabled)")
+	cliflags.IntVar(&serverConfig.DBConfig.MemoryBudgetMB, "rdb-memory-budget-mb", 0, "RocksDB block cache size in MB shared by the DB, the DB opened on full reloads and the shadow DB, instead of each allocating its own. 0 to disable. (default: disabled)")
+	cliflags.IntVar(&serverConfig.DBConfig.WriteBufferBudgetMB, "rdb-write-buffer-budget-mb", 0, "How much of -rdb-memory-budget-mb RocksDB memtables can use. 0 for no limit")
+	cliflags.DurationVar(&serverConfig.DBConfig.Faults.Latency, "db-fault-latency", 0, "Latency injected in every DB lookup, for tests and chaos drills only. (default: disabled)")
+	cliflags.Float64Var(&serverConfig.DBConfig.Faults.ErrorRate, "db-fault-error-rate", 0, "Fraction of DB lookups failed on purpose, for tests and chaos drills only. (default: disabled)")
+	cliflags.Float64Var(&serverConfig.DBConfig.Faults.MissRate, "db-fault-miss-rate", 0, "Fraction of DB lookups and values reported missing on purpose, for tests and chaos drills only. (default: disabled)")
+
+	// Shadow reads config
+	cliflags.StringVar(&serverConfig.HandlerConfig.Shadow.DB.Path, "shadow-dbpath", "", "Path to a second database a fraction of queries is also resolved against, counting differences with the served answers. Empty to disable. (default: disabled)")
+	cliflags.StringVar(&serverConfig.HandlerConfig.Shadow.DB.Driver, "shadow-dbdriver", "rocksdb", "Name of the database engine to use for the shadow database (cdb, rocksdb)")
+	cliflags.Float64Var(&serverConfig.HandlerConfig.Shadow.SampleRate, "shadow-sample-rate", 0.01, "Fraction of queries also resolved against the shadow database, in [0.0, 1.0]")
+	cliflags.Float64Var(&serverConfig.HandlerConfig.Shadow.LogSampleRate, "shadow-log-sample-rate", 0.01, "Fraction of differences with the shadow database that are logged, in [0.0, 1.0]")
+	cliflags.IntVar(&serverConfig.HandlerConfig.Shadow.MaxInFlight, "shadow-max-inflight", dnsserver.DefaultShadowMaxInFlight, "Max number of concurrent shadow reads, queries are not shadowed above it")
+
+	// NOTIFY config
+	cliflags.StringVar(&serverConfig.HandlerConfig.Notify.Zones, "notify-zones", "", "Comma separated list of zones whose SOA serial is tracked across DB reloads. Secondaries are notified when it changes.")
+	cliflags.StringVar(&serverConfig.HandlerConfig.Notify.Secondaries, "notify-secondaries", "", "Comma separated list of host[:port] of secondaries to send NOTIFY messages to.")
+	cliflags.DurationVar(&serverConfig.HandlerConfig.Notify.Timeout, "notify-timeout", dnsserver.DefaultNotifyTimeout, "Time to wait for a secondary to acknowledge a NOTIFY")
+	cliflags.IntVar(&serverConfig.HandlerConfig.Notify.Retries, "notify-retries", dnsserver.DefaultNotifyRetries, "Number of times a NOTIFY is sent to a secondary before giving up")
+	// Per map statistics config
+	cliflags.BoolVar(&serverConfig.HandlerConfig.MapStats.Enabled, "map-stats", false, "Count location lookups per map ID, as hits, misses and default locations")
+	cliflags.Float64Var(&serverConfig.HandlerConfig.MapStats.UnmatchedSampleRate, "map-stats-unmatched-sample-rate", 0, "Fraction of lookups ending in a default or empty location which are logged with the client subnets, in [0.0, 1.0]")
+	cliflags.StringVar(&serverConfig.NotifyReceiverConfig.Zones, "notify-receive-zones", "", "Comma separated list of zones for which inbound NOTIFY messages trigger a DB reload. Empty to disable. (default: disabled)")
+	cliflags.StringVar(&serverConfig.NotifyReceiverConfig.TSIGKeyFile, "notify-tsig-key-file", "", "Path to the file containing the TSIG keys inbound NOTIFY messages must be signed with, one '[algorithm:]name:secret' per line.")
+	cliflags.StringVar(&serverConfig.DebugConfig.Addr, "debug-http-addr", "", "host:port of the debug HTTP server answering /resolve and /zones with lookup traces. Empty to disable. (default: disabled)")
+	cliflags.StringVar(&serverConfig.DebugConfig.Zones, "debug-http-zones", "", "Comma separated list of zones listed by /zones of the debug HTTP server along with the zones of the DB, even when missing from it.")
+	cliflags.IntVar(&serverConfig.HandlerConfig.TopTalkers.Size, "top-talkers", 0, "Number of resolver subnets and query names tracked to report the top talkers on /toptalkers of the debug HTTP server. 0 to disable. (default: disabled)")
+	cliflags.IntVar(&serverConfig.HandlerConfig.Poison.LogSize, "poison-log-size", dnsserver.DefaultPoisonLogSize, "Number of last queries whose handling panicked kept in memory, served on /poison of the debug HTTP server.")
+	cliflags.DurationVar(&serverConfig.HandlerConfig.Poison.Quarantine, "poison-quarantine", 0, "How long queries with the same name, type, class and client subnet as a query whose handling panicked are answered SERVFAIL without being handled. 0 to disable. (default: disabled)")
+	cliflags.IntVar(&serverConfig.HandlerConfig.QueryLog.Size, "query-log-size", 0, "Number of last queries and responses kept in memory, served on /querylog of the debug HTTP server and logged on SIGUSR2 (except on Windows). 0 to disable. (default: disabled)")
+	cliflags.DurationVar(&serverConfig.HandlerConfig.TopTalkers.Window, "top-talkers-window", dnsserver.DefaultTopTalkersWindow, "Sliding window the top talkers are reported over.")
+	cliflags.IntVar(&serverConfig.HandlerConfig.TopTalkers.IPv4PrefixLen, "top-talkers-v4-prefix", dnsserver.DefaultTopTalkersIPv4PrefixLen, "Length of the IPv4 subnets resolvers are grouped by in top talkers.")
+	cliflags.IntVar(&serverConfig.HandlerConfig.TopTalkers.IPv6PrefixLen, "top-talkers-v6-prefix", dnsserver.DefaultTopTalkersIPv6PrefixLen, "Length of the IPv6 subnets resolvers are grouped by in top talkers.")
+	cliflags.StringVar(&serverConfig.HealthConfig.Addr, "health-addr", "", "host:port, or path of a unix socket if starting with '/', of the /health endpoint answering 200 when the self checks pass and 503 otherwise. Empty to disable. (default: disabled)")
+	cliflags.StringVar(&serverConfig.HealthConfig.Hook, "health-hook", "", "Command run with 'up' or 'down' as last argument at startup and whenever the health of the instance changes, e.g. to announce or withdraw an anycast route. Empty to disable. (default: disabled)")
+	cliflags.StringVar(&healthChecksFile, "health-checks-file", "", "Path to the file of self checks run against the live DB, in the -reload-checks-file format. Required by -health-addr and -health-hook.")
+	cliflags.DurationVar(&serverConfig.HealthConfig.Interval, "health-interval", dnsserver.DefaultHealthInterval, "Interval between rounds of health checks, also the timeout of -health-hook.")
+	cliflags.IntVar(&serverConfig.HealthConfig.Fall, "health-fall", dnsserver.DefaultHealthFall, "Number of consecutive failed rounds of health checks making the instance unhealthy.")
+	cliflags.IntVar(&serverConfig.HealthConfig.Rise, "health-rise", dnsserver.DefaultHealthRise, "Number of consecutive passed rounds of health checks making the instance healthy.")
+	cliflags.StringVar(&serverConfig.RPZConfig.File, "rpz-file", "", "Path to a response policy zone applied to queries before DB lookups. Empty to disable. (default: disabled)")
+	cliflags.DurationVar(&serverConfig.RPZConfig.ReloadInterval, "rpz-reload-interval", 0, "How often to check the response policy zone file for changes, 0 to never reload it")
+	cliflags.StringVar(&serverConfig.OverridesConfig.File, "overrides-file", "", "Path to temporary overrides applied to queries before the response policy zone and DB lookups, one 'name type [from=prefix,...] action [ttl rdata]' rule per line. Empty to disable. (default: disabled)")
+	cliflags.DurationVar(&serverConfig.OverridesConfig.ReloadInterval, "overrides-reload-interval", 0, "How often to check the overrides file for changes, 0 to never reload it")
+
+	// Cache config
+	cliflags.BoolVar(&serverConfig.CacheConfig.Enabled, "cache", false, "Whether or not we should cache DNS messages")
+	cliflags.IntVar(&serverConfig.CacheConfig.LRUSize, "cache-lru-size", 1024*1024, "LRU cache size")
+	cliflags.Int64Var(&serverConfig.CacheConfig.WRSTimeout, "cache-wrs-timeout", 0, "How long should the weighted random sampled DNS messages should be cached. 0 to not cache them.")
+	cliflags.Int64Var(&serverConfig.CacheConfig.PrefetchHits, "cache-prefetch-hits", 0, "Number of hits after which cached responses are refreshed from the DB ahead of expiry. 0 to disable.")
+	cliflags.Int64Var(&serverConfig.CacheConfig.PrefetchWindow, "cache-prefetch-window", 10, "How many seconds before expiry cached responses hit -cache-prefetch-hits times are refreshed.")
+	// TLS Config
+	cliflags.BoolVar(&serverConfig.TLS, "tls", false, "Whether or not to also listen on TCP with TLS.")
+	cliflags.IntVar(&serverConfig.TLSConfig.Port, "tls-port", 8853, "Port to run DNS-over-TLS on.")
+	cliflags.StringVar(&serverConfig.TLSConfig.CertFile, "tls-cert-file", "", "Path to TLS cert file")
+	cliflags.StringVar(&serverConfig.TLSConfig.KeyFile, "tls-key-file", "", "Path to TLS key file")
+	cliflags.StringVar(&serverConfig.TLSConfig.CryptoSSL.Tier, "tls-cryptossl-tier", "", "Name of CryptoSSL tier.")
+	cliflags.StringVar(&serverConfig.TLSConfig.CryptoSSL.CertName, "tls-cryptossl-cert-name", "", "Name of certificate to use.")
+	cliflags.StringVar(&serverConfig.TLSConfig.SessionTicketKeys.SeedFile, "tls-seed-file", "", "Path to the file containing TLS tickets seeds.")
+	cliflags.IntVar(&serverConfig.TLSConfig.SessionTicketKeys.SeedFileReloadInterval, "tls-seed-file-reload-interval", 60, "Interval at which to reload TLS Session Ticket Keys seeds.")
+	cliflags.BoolVar(&serverConfig.TLSConfig.DoTTLSAEnabled, "tls-tlsa-record", false, "Whether or not to enable the handler to distribute TLS SPKI using DANE/TLSA")
+	cliflags.Uint64Var(&doTTLSATtl, "tls-tlsa-record-ttl", 0, "TTL to use with DoT TLSA records. A value of 0 will let the plugin use its default (currently 3600)")
+	// Loggers
+	cliflags.StringVar(&loggerConfig.Target, "dnstap-target", "stdout", "DNSTap destination to write to. Use `stdout` for Stdout, `unix` for unix socket and `tcp` for tcp socket (stdout, tcp, unix)")
+	cliflags.StringVar(&loggerConfig.Remote, "dnstap-remote", "", "DNSTap remote to write to. Provide ip:port or path-to-unix-socket")
+	cliflags.StringVar(&loggerConfig.LogFormat, "dnstap-stdout-format", "text", "DNSTap log format, only in use for the `stdout` target (text, yaml, json, rfc8427)")
+	cliflags.IntVar(&loggerConfig.Timeout, "dnstap-timeout", 1, "Timeout before dnstap client fails to connect to remote.")
+	cliflags.IntVar(&loggerConfig.Retry, "dnstap-retry", 3, "Time between dnstap client reconnection attempts.")
+	cliflags.IntVar(&loggerConfig.FlushInterval, "dnstap-flush-interval", 5, "Maximum time data will be kept in the output buffer.")
+	cliflags.Float64Var(&loggerConfig.SamplingRate, "dnstap-sampling-rate", 1.0, "What rate of queries are being sampled in. Value should be [0.0, 1.0]. 1.0 means logging everything. The value will be coerced to the closest 1/N value")
+	// scribe related config flags. To maintain cli flag compatibility
+	cliflags.Float64Var(&loggerConfig.SamplingRate, "scribe-sampling-rate", 1.0, "What rate of queries are being sampled in. Value should be [0.0, 1.0]. 1.0 means logging everything. The value will be coerced to the closest 1/N value")
+	cliflags.StringVar(&loggerConfig.Category, "scribe-category", "-", "Scribe category to write to. Use `-` for Stdout.")
+	cliflags.IntVar(&loggerConfig.Timeout, "scribe-timeout", 1, "Timeout before scribecat client fails to connect to scribed.")
+	cliflags.IntVar(&loggerConfig.Retry, "scribe-retries", 3, "Number of times scribecat client will attempt to flush messages before giving up and dropping them.")
+	cliflags.IntVar(&loggerConfig.FlushInterval, "scribe-flush-interval", 5, "Interval at which the scribecat client will flush logs to scribed.")
+	cliflags.StringVar(&metricsAddr, "metrics-addr", DefaultMetricsAddr, "Where to serve metrics from")
+	// Just needed to maintain cli flag compatibility, for now
+	cliflags.StringVar(&thriftAddr, "thrift-addr", DefaultMetricsAddr, "Where to serve thrift from")
+	// Misc
+	pprofconf := cliflags.String("pprof", "", "Address to have the profiler listen on, disabled if empty.")
+	cpu := cliflags.String("cpu", "1", "CPU cap. Accepts percentage or integer.")
+	cliflags.IntVar(&serverConfig.MaxConcurrency, "max-concurrency", -1, "Maximum number of concurrent queries per CPU (default: unlimited)")
+	logPrefix := cliflags.String("log-prefix", "", "Prefix to use in logger")
+	dnsRecordKeyToValidate := cliflags.String("record-key-to-validate", "", "DNS record key expected to present in DB file.")
+
+	version := cliflags.Bool("version", false, "Print versioning information.")
+	configFlags := flagconfig.Register(cliflags)
+
+	// Enable glog format (already defined by glog lib)
+	// This hack is required for glog compatibility, as it does not expose verbosity level
+	cliflags.BoolVar(&toStderr, "logtostderr", true, "log to standard error instead of files")
+	cliflags.IntVar(&verbosity, "v", 2, "log level for V logs")
+	err := cliflags.Parse(os.Args[1:])
+	if err != nil {
+		glog.Errorf("Failed to parse cli flags: %v", err)
+	}
+	exit, err := configFlags.Run(cliflags, os.Stdout)
+	if err != nil {
+		glog.Fatalf("Failed to load config: %v", err)
+	}
+	if exit {
+		os.Exit(0)
+	}
+	err = flag.Set("logtostderr", strconv.FormatBool(toStderr))
+	if err != nil {
+		glog.Errorf("Failed to set glog logging to stdout. Err: %v", err)
+	}
+	err = flag.Set("v", strconv.FormatInt(int64(verbosity), 10))
+	if err != nil {
+		glog.Errorf("Failed to set glog verbosity level to 2. Err: %v", err)
+	}
+	flag.CommandLine = cliflags
+	flag.Parse()
+	// glog cli flag hack over.
+
+	if thriftAddr != DefaultMetricsAddr {
+		metricsAddr = thriftAddr
+	}
+	if doTTLSATtl > math.MaxUint32 {
+		glog.Fatalf("tls-tlsa-record-ttl %d is greater than max uint32: %d", doTTLSATtl, math.MaxUint32)
+	}
+	serverConfig.TLSConfig.DoTTLSATtl = uint32(doTTLSATtl)
+	serverConfig.DBConfig.Path = path.Clean(serverConfig.DBConfig.Path)
+	unquotedKey, err := quote.Bunquote([]byte(*dnsRecordKeyToValidate))
+	if err != nil {
+		glog.Fatalf("Failed to unquote validation dns record: '%s', %v\n", *dnsRecordKeyToValidate, err)
+	}
+	serverConfig.DBConfig.ValidationKey = unquotedKey
+	if serverConfig.HandlerConfig.Shadow.DB.Path != "" {
+		serverConfig.HandlerConfig.Shadow.DB.Path = path.Clean(serverConfig.HandlerConfig.Shadow.DB.Path)
+		serverConfig.HandlerConfig.Shadow.DB.ReloadTimeout = serverConfig.DBConfig.ReloadTimeout
+	}
+	if reloadChecksFile != "" {
+		f, err := os.Open(reloadChecksFile)
+		if err != nil {
+			glog.Fatalf("Failed to open reload checks file: %v", err)
+		}
+		serverConfig.DBConfig.ReloadChecks, err = dnsserver.ParseReloadChecks(f)
+		f.Close()
+		if err != nil {
+			glog.Fatalf("Failed to parse reload checks file %s: %v", reloadChecksFile, err)
+		}
+	}
+	if checksumKeyFiles != "" {
+		for _, keyFile := range strings.Split(checksumKeyFiles, ",") {
+			key, err := dnsdata.LoadVerifyKey(keyFile)
+			if err != nil {
+				glog.Fatalf("Failed to load checksum key: %v", err)
+			}
+			serverConfig.DBConfig.ChecksumKeys = append(serverConfig.DBConfig.ChecksumKeys, key)
+		}
+	}
+	if recordCountZones != "" {
+		for _, zone := range strings.Split(recordCountZones, ",") {
+			serverConfig.DBConfig.RecordCounts.Zones = append(serverConfig.DBConfig.RecordCounts.Zones, strings.TrimSpace(zone))
+		}
+	}
+	if healthChecksFile != "" {
+		f, err := os.Open(healthChecksFile)
+		if err != nil {
+			glog.Fatalf("Failed to open health checks file: %v", err)
+		}
+		serverConfig.HealthConfig.Checks, err = dnsserver.ParseReloadChecks(f)
+		f.Close()
+		if err != nil {
+			glog.Fatalf("Failed to parse health checks file %s: %v", healthChecksFile, err)
+		}
+	}
+	if privacyKeyFile != "" {
+		serverConfig.HandlerConfig.ResolverPrivacy.HashKey, err = os.ReadFile(privacyKeyFile)
+		if err != nil {
+			glog.Fatalf("Failed to read resolver privacy hash key: %v", err)
+		}
+	}
+	if answerSeedKeyFile != "" {
+		serverConfig.HandlerConfig.AnswerSeed.Key, err = os.ReadFile(answerSeedKeyFile)
+		if err != nil {
+			glog.Fatalf("Failed to read answer seed key: %v", err)
+		}
+	}
+
+	if *version {
+		glog.Infof("go version: %s go arch: %s go OS: %s", runtime.Version(), runtime.GOARCH, runtime.GOOS)
+		os.Exit(0)
+	}
+	serverConfig.NumCPU, err = setCPU(*cpu)
+	failOnErr(err, "Error setting number of CPU")
+
+	// TODO (jifen) this should be deprecated in subsequent
+	// diff since IDN no longer rely on this
+	if len(*logPrefix) > 0 {
+		glog.Warningf("Provided prefix %s but not used", *logPrefix)
+	}
+
+	if *pprofconf != "" {
+		go func() {
+			err = http.ListenAndServe(*pprofconf, nil)
+			if err != nil {
+				glog.Errorf("Failed to start pprof. Err: %v", err)
+			}
+		}()
+	}
+
+	// Metrics server
+	metricsServer, err := metrics.NewMetricsServer(metricsAddr)
+	if err != nil {
+		glog.Fatalf("cannot initialize metrics server: %s\n", err)
+	}
+
+	go func() {
+		if serverError := metricsServer.Serve(); serverError != nil {
+			glog.Fatalf("cannot start metrics server: %s\n", serverError)
+		}
+	}()
+
+	// Logger
+	l, err := logger.NewLogger(loggerConfig)
+	if err != nil {
+		glog.Fatalf("Error creating dnstap logger, invalid configuration provided: %s\n", err)
+	}
+	l.StartLoggerOutput()
+
+	// stat collector
+	stats := metrics.NewStatsWithFlushInterval(statsFlushInterval)
+
+	srv := fbserver.NewServer(serverConfig, l, stats, metricsServer)
+
+	if len(*dnsRecordKeyToValidate) > 0 {
+		err = srv.ValidateDbKey(unquotedKey)
+		if err != nil {
+			failOnErr(err, "Invalid DB file, expected record not present.")
+		}
+	}
+	// NotifyStartedFunc is used to notify wait group that servers are started
+	srv.NotifyStartedFunc = func() {
+		srv.ServersStartedWG.Done()
+	}
+	failOnErr(srv.Start(), "Failed to start servers")
+
+	// It is necessary to set NotifyStartedFunc to call Done() on wait group, otherwise
+	// this will block and status will never be changed
+	go func() {
+		srv.ServersStartedWG.Wait()
+		metricsServer.SetAlive()
+	}()
+	err = metricsServer.ConsumeStats("dns", stats)
+	if err != nil {
+		glog.Errorf("Failed to register stats for consumption: %v. Err: %v", stats, err)
+	}
+	go metricsServer.UpdateExporter()
+
+	hangupchan := make(chan os.Signal, 1)
+	signal.Notify(hangupchan, syscall.SIGHUP)
+	go func() {
+		for range hangupchan {
+			glog.Info("SIGHUP received, refreshing database")
+			srv.ReloadDB()
+		}
+	}()
+
+	dumpQueryLogOnSignal(srv)
+
+	if serverConfig.DBConfig.WatchDB {
+		go srv.WatchDBAndReload()
+	}
+
+	if serverConfig.DBConfig.ControlPath != "" {
+		go srv.WatchControlDirAndReload()
+	}
+
+	go srv.LogMapAge()
+	go srv.DumpBackendStats()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	s := <-sig
+	glog.Infof("Signal (%v) received, stopping\n", s)
+
+	srv.Shutdown()
+}
+
+func failOnErr(err error, msg string) {
+	if err != nil {
+		glog.Fatalf("%s: %v\n", msg, err)
+	}
+}
@@ -0,0 +1,38 @@
+//go:build !windows
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/golang/glog"
+
+	"github.com/facebook/dns/dnsrocks/fbserver"
+)
+
+// dumpQueryLogOnSignal logs the query log of srv on each SIGUSR2
+func dumpQueryLogOnSignal(srv *fbserver.Server) {
+	dumpchan := make(chan os.Signal, 1)
+	signal.Notify(dumpchan, syscall.SIGUSR2)
+	go func() {
+		for range dumpchan {
+			glog.Info("SIGUSR2 received, dumping query log")
+			srv.DumpQueryLog()
+		}
+	}()
+}
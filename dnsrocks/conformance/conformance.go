@@ -0,0 +1,544 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package conformance probes a DNS server for compliance with the DNS and EDNS
+wire format, in the spirit of ISC's EDNS compliance tests: each probe sends a
+query exercising one aspect of the protocol, e.g. an unknown EDNS version,
+option or flag, and checks the rcode, flags and OPT record of the response.
+*/
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Options describes the zone served by the server probed
+type Options struct {
+	// Zone is a zone the server is authoritative for, e.g. "example.com."
+	Zone string
+	// Name is a name of Zone with A records, Zone itself by default
+	Name string
+	// TruncName, if set, is a name of Zone whose A records don't fit in 512
+	// bytes, to probe truncation
+	TruncName string
+	// NXName is a name of Zone that doesn't exist, nor matches a wildcard,
+	// nxdomain-conformance-probe.<Zone> by default
+	NXName string
+	// OutOfZone is a name the server is not authoritative for
+	OutOfZone string
+	// TCPServer is the address of the server over TCP, the address probed
+	// by default
+	TCPServer string
+	// Timeout of each query
+	Timeout time.Duration
+}
+
+// DefaultTimeout is the timeout of queries when Options don't set any
+const DefaultTimeout = 2 * time.Second
+
+// DefaultOutOfZone is the name queried for REFUSED when Options don't set any
+const DefaultOutOfZone = "conformance.invalid."
+
+// Probe is a query exercising one aspect of the protocol, and the checks of
+// its response
+type Probe struct {
+	// Name is short, following the ISC tests where there is one, e.g. edns1
+	Name string
+	// Description is what the probe sends and expects
+	Description string
+	run         func(p *prober) error
+}
+
+// Result is the outcome of a probe
+type Result struct {
+	Probe string `json:"probe"`
+	// Rcode of the response, empty without response
+	Rcode string `json:"rcode,omitempty"`
+	// Error is why the probe failed, empty if it passed
+	Error string `json:"error,omitempty"`
+}
+
+// Passed returns true if the probe passed
+func (r Result) Passed() bool {
+	return r.Error == ""
+}
+
+// Report is the outcome of probing a server
+type Report struct {
+	Server  string   `json:"server"`
+	Results []Result `json:"results"`
+}
+
+// Failed returns the number of probes failed
+func (r *Report) Failed() int {
+	n := 0
+	for _, res := range r.Results {
+		if !res.Passed() {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteText writes the report as one line per probe, ok or the reason it
+// failed, followed by a summary line
+func (r *Report) WriteText(w io.Writer) error {
+	width := 0
+	for _, res := range r.Results {
+		width = max(width, len(res.Probe))
+	}
+	for _, res := range r.Results {
+		status := "ok"
+		if !res.Passed() {
+			status = "FAIL: " + res.Error
+		}
+		if _, err := fmt.Fprintf(w, "%-*s  %-8s  %s\n", width, res.Probe, res.Rcode, status); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s: %d/%d probes passed\n", r.Server, len(r.Results)-r.Failed(), len(r.Results))
+	return err
+}
+
+// WriteJSON writes the report as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// prober runs probes against a server
+type prober struct {
+	ctx    context.Context
+	server string
+	opts   Options
+	// rcode of the last response
+	rcode int
+}
+
+// query returns a query for qname and qtype, with EDNS if version >= 0
+func query(qname string, qtype uint16, version int) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(qname), qtype)
+	m.RecursionDesired = false
+	if version >= 0 {
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		m.IsEdns0().SetVersion(uint8(version))
+	}
+	return m
+}
+
+// exchange sends m over network, udp or tcp, and checks the header, question
+// and OPT record of the response common to all probes
+func (p *prober) exchange(network string, m *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{Net: network, Timeout: p.opts.Timeout, UDPSize: dns.DefaultMsgSize}
+	server := p.server
+	if network == "tcp" && p.opts.TCPServer != "" {
+		server = p.opts.TCPServer
+	}
+	r, _, err := c.ExchangeContext(p.ctx, m, server)
+	if err != nil {
+		return nil, err
+	}
+	p.rcode = r.Rcode
+	if !r.Response {
+		return r, errors.New("QR not set")
+	}
+	if r.Opcode != m.Opcode {
+		return r, fmt.Errorf("opcode %s, expected %s", dns.OpcodeToString[r.Opcode], dns.OpcodeToString[m.Opcode])
+	}
+	if r.Zero {
+		return r, errors.New("Z set")
+	}
+	if r.RecursionDesired != m.RecursionDesired {
+		return r, errors.New("RD not copied from the query")
+	}
+	if r.RecursionAvailable {
+		return r, errors.New("RA set")
+	}
+	if r.Rcode != dns.RcodeNotImplemented && r.Rcode != dns.RcodeFormatError {
+		if len(r.Question) != 1 || r.Question[0] != m.Question[0] {
+			return r, fmt.Errorf("question %v not copied from the query", r.Question)
+		}
+	}
+	return r, checkOPT(m, r)
+}
+
+// checkOPT checks the OPT record of the response r to m: one if m has one,
+// none otherwise, version 0 and no unknown flag
+func checkOPT(m, r *dns.Msg) error {
+	var opts []*dns.OPT
+	for _, rr := range r.Extra {
+		if opt, ok := rr.(*dns.OPT); ok {
+			opts = append(opts, opt)
+		}
+	}
+	switch {
+	case m.IsEdns0() == nil && len(opts) > 0:
+		return errors.New("OPT record in the response to a query without EDNS")
+	case m.IsEdns0() == nil:
+		return nil
+	case len(opts) == 0:
+		return errors.New("no OPT record in the response")
+	case len(opts) > 1:
+		return fmt.Errorf("%d OPT records in the response", len(opts))
+	}
+	opt := opts[0]
+	if opt.Version() != 0 {
+		return fmt.Errorf("EDNS version %d in the response", opt.Version())
+	}
+	if opt.Z() != 0 {
+		return fmt.Errorf("unknown EDNS flags %#04x in the response", opt.Z())
+	}
+	return nil
+}
+
+// rcodeString returns the name of rcode, BADVERS rather than BADSIG for 16
+func rcodeString(rcode int) string {
+	if rcode == dns.RcodeBadVers {
+		return "BADVERS"
+	}
+	return dns.RcodeToString[rcode]
+}
+
+// expectRcode checks the rcode, including the extended rcode, of r
+func expectRcode(r *dns.Msg, rcode int) error {
+	if r.Rcode != rcode {
+		return fmt.Errorf("rcode %s, expected %s", rcodeString(r.Rcode), rcodeString(rcode))
+	}
+	return nil
+}
+
+// expectAnswer checks that r is an authoritative NOERROR answer
+func expectAnswer(r *dns.Msg) error {
+	if err := expectRcode(r, dns.RcodeSuccess); err != nil {
+		return err
+	}
+	if !r.Authoritative {
+		return errors.New("AA not set")
+	}
+	if len(r.Answer) == 0 {
+		return errors.New("no answer")
+	}
+	return nil
+}
+
+// expectNegative checks that r is an authoritative negative answer with
+// rcode, and the SOA of the zone in the authority section
+func expectNegative(r *dns.Msg, rcode int) error {
+	if err := expectRcode(r, rcode); err != nil {
+		return err
+	}
+	if !r.Authoritative {
+		return errors.New("AA not set")
+	}
+	if len(r.Answer) > 0 {
+		return fmt.Errorf("%d answers", len(r.Answer))
+	}
+	for _, rr := range r.Ns {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			return nil
+		}
+	}
+	return errors.New("no SOA in the authority section")
+}
+
+// hasOption returns true if the OPT record of r has an option of code
+func hasOption(r *dns.Msg, code uint16) bool {
+	if opt := r.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if o.Option() == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unknownOption is an EDNS option code no server implements, reserved for
+// local use, e.g. the ISC tests use 100
+const unknownOption = 100
+
+// unknownFlag is an EDNS flag not yet assigned
+const unknownFlag = 0x80
+
+// Probes are the probes run, in order
+var Probes = []Probe{
+	{Name: "dns", Description: "query without EDNS: NOERROR answer, no OPT", run: func(p *prober) error {
+		r, err := p.exchange("udp", query(p.opts.Name, dns.TypeA, -1))
+		if err != nil {
+			return err
+		}
+		return expectAnswer(r)
+	}},
+	{Name: "rd", Description: "query with RD: RD copied, RA not set", run: func(p *prober) error {
+		m := query(p.opts.Name, dns.TypeA, -1)
+		m.RecursionDesired = true
+		r, err := p.exchange("udp", m)
+		if err != nil {
+			return err
+		}
+		return expectAnswer(r)
+	}},
+	{Name: "zflag", Description: "query with the header Z bit: NOERROR answer, Z not set", run: func(p *prober) error {
+		m := query(p.opts.Name, dns.TypeA, -1)
+		m.Zero = true
+		r, err := p.exchange("udp", m)
+		if err != nil {
+			return err
+		}
+		return expectAnswer(r)
+	}},
+	{Name: "opcode", Description: "query with unknown opcode 15: NOTIMP", run: func(p *prober) error {
+		m := query(p.opts.Name, dns.TypeA, -1)
+		m.Opcode = 15
+		r, err := p.exchange("udp", m)
+		if err != nil {
+			return err
+		}
+		return expectRcode(r, dns.RcodeNotImplemented)
+	}},
+	{Name: "edns", Description: "EDNS query: NOERROR answer, OPT version 0", run: func(p *prober) error {
+		r, err := p.exchange("udp", query(p.opts.Name, dns.TypeA, 0))
+		if err != nil {
+			return err
+		}
+		return expectAnswer(r)
+	}},
+	{Name: "edns1", Description: "EDNS version 1 query: BADVERS, OPT version 0, no answer", run: func(p *prober) error {
+		r, err := p.exchange("udp", query(p.opts.Name, dns.TypeA, 1))
+		if err != nil {
+			return err
+		}
+		if err := expectRcode(r, dns.RcodeBadVers); err != nil {
+			return err
+		}
+		if len(r.Answer) > 0 {
+			return fmt.Errorf("%d answers", len(r.Answer))
+		}
+		return nil
+	}},
+	{Name: "ednsflags", Description: "EDNS query with an unknown flag: NOERROR answer, flag not copied", run: func(p *prober) error {
+		m := query(p.opts.Name, dns.TypeA, 0)
+		m.IsEdns0().SetZ(unknownFlag)
+		r, err := p.exchange("udp", m)
+		if err != nil {
+			return err
+		}
+		return expectAnswer(r)
+	}},
+	{Name: "ednsopt", Description: "EDNS query with an unknown option: NOERROR answer, option not copied", run: func(p *prober) error {
+		m := query(p.opts.Name, dns.TypeA, 0)
+		m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_LOCAL{Code: unknownOption})
+		r, err := p.exchange("udp", m)
+		if err != nil {
+			return err
+		}
+		if hasOption(r, unknownOption) {
+			return errors.New("unknown option copied")
+		}
+		return expectAnswer(r)
+	}},
+	{Name: "edns1opt", Description: "EDNS version 1 query with an unknown option: BADVERS, option not copied", run: func(p *prober) error {
+		m := query(p.opts.Name, dns.TypeA, 1)
+		m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_LOCAL{Code: unknownOption})
+		r, err := p.exchange("udp", m)
+		if err != nil {
+			return err
+		}
+		if hasOption(r, unknownOption) {
+			return errors.New("unknown option copied")
+		}
+		return expectRcode(r, dns.RcodeBadVers)
+	}},
+	{Name: "do", Description: "EDNS query with DO: NOERROR answer, DO copied", run: func(p *prober) error {
+		m := query(p.opts.Name, dns.TypeA, 0)
+		m.IsEdns0().SetDo()
+		r, err := p.exchange("udp", m)
+		if err != nil {
+			return err
+		}
+		if !r.IsEdns0().Do() {
+			return errors.New("DO not copied")
+		}
+		return expectAnswer(r)
+	}},
+	{Name: "ednstcp", Description: "EDNS query over TCP: NOERROR answer, OPT version 0", run: func(p *prober) error {
+		r, err := p.exchange("tcp", query(p.opts.Name, dns.TypeA, 0))
+		if err != nil {
+			return err
+		}
+		return expectAnswer(r)
+	}},
+	{Name: "optlist", Description: "EDNS query with NSID, client subnet, cookie and expire options: NOERROR answer", run: func(p *prober) error {
+		m := query(p.opts.Name, dns.TypeA, 0)
+		m.IsEdns0().Option = append(m.IsEdns0().Option,
+			&dns.EDNS0_NSID{Code: dns.EDNS0NSID},
+			&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: []byte{192, 0, 2, 0}},
+			&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"},
+			&dns.EDNS0_EXPIRE{Code: dns.EDNS0EXPIRE, Empty: true},
+		)
+		r, err := p.exchange("udp", m)
+		if err != nil {
+			return err
+		}
+		return expectAnswer(r)
+	}},
+	{Name: "nxdomain", Description: "EDNS query for a name not in the zone: authoritative NXDOMAIN with SOA", run: func(p *prober) error {
+		r, err := p.exchange("udp", query(p.opts.NXName, dns.TypeA, 0))
+		if err != nil {
+			return err
+		}
+		return expectNegative(r, dns.RcodeNameError)
+	}},
+	{Name: "nodata", Description: "EDNS query for a type without records: authoritative NOERROR with SOA", run: func(p *prober) error {
+		// a private use type no data has
+		r, err := p.exchange("udp", query(p.opts.Name, 65400, 0))
+		if err != nil {
+			return err
+		}
+		return expectNegative(r, dns.RcodeSuccess)
+	}},
+	{Name: "refused", Description: "EDNS query out of the zones served: REFUSED, AA not set", run: func(p *prober) error {
+		r, err := p.exchange("udp", query(p.opts.OutOfZone, dns.TypeA, 0))
+		if err != nil {
+			return err
+		}
+		if err := expectRcode(r, dns.RcodeRefused); err != nil {
+			return err
+		}
+		if r.Authoritative {
+			return errors.New("AA set")
+		}
+		return nil
+	}},
+	{Name: "tc", Description: "query without EDNS for a large answer: TC set within 512 bytes, full answer over TCP", run: func(p *prober) error {
+		if p.opts.TruncName == "" {
+			return errSkipped
+		}
+		m := query(p.opts.TruncName, dns.TypeA, -1)
+		c := &dns.Client{Net: "udp", Timeout: p.opts.Timeout}
+		conn, err := c.Dial(p.server)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		// read the raw response, as the client would unpack truncated
+		// records and fail
+		if err := conn.WriteMsg(m); err != nil {
+			return err
+		}
+		buf := make([]byte, dns.MaxMsgSize)
+		_ = conn.SetReadDeadline(time.Now().Add(p.opts.Timeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		if n > dns.MinMsgSize {
+			return fmt.Errorf("%d bytes response over UDP without EDNS", n)
+		}
+		r := new(dns.Msg)
+		if err := r.Unpack(buf[:n]); err != nil {
+			return err
+		}
+		p.rcode = r.Rcode
+		if !r.Truncated {
+			return errors.New("TC not set")
+		}
+		full, err := p.exchange("tcp", m)
+		if err != nil {
+			return err
+		}
+		if full.Truncated {
+			return errors.New("TC set over TCP")
+		}
+		if len(full.Answer) <= len(r.Answer) {
+			return fmt.Errorf("%d answers over TCP, %d truncated over UDP", len(full.Answer), len(r.Answer))
+		}
+		return expectAnswer(full)
+	}},
+}
+
+// errSkipped is returned by probes not run for lack of options
+var errSkipped = errors.New("skipped")
+
+// Run runs probes against the server at address server, returning the report
+// of all of them, whatever their outcome. Probes needing options not set are
+// left out.
+func Run(ctx context.Context, server string, opts Options, probes []Probe) *Report {
+	opts.Zone = dns.Fqdn(opts.Zone)
+	if opts.Name == "" {
+		opts.Name = opts.Zone
+	}
+	if opts.NXName == "" {
+		opts.NXName = "nxdomain-conformance-probe." + opts.Zone
+	}
+	if opts.OutOfZone == "" {
+		opts.OutOfZone = DefaultOutOfZone
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	report := &Report{Server: server}
+	for _, probe := range probes {
+		p := &prober{ctx: ctx, server: server, opts: opts, rcode: -1}
+		err := probe.run(p)
+		if errors.Is(err, errSkipped) {
+			continue
+		}
+		res := Result{Probe: probe.Name}
+		if p.rcode >= 0 {
+			res.Rcode = rcodeString(p.rcode)
+		}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report
+}
+
+// SelectProbes returns the probes named in names, in that order, or
+// all of them if names is empty
+func SelectProbes(names []string) ([]Probe, error) {
+	if len(names) == 0 {
+		return Probes, nil
+	}
+	var probes []Probe
+	for _, name := range names {
+		found := false
+		for _, probe := range Probes {
+			if probe.Name == name {
+				probes = append(probes, probe)
+				found = true
+			}
+		}
+		if !found {
+			var all []string
+			for _, probe := range Probes {
+				all = append(all, probe.Name)
+			}
+			return nil, fmt.Errorf("unknown probe %q, expected one of %s", name, strings.Join(all, ", "))
+		}
+	}
+	return probes, nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata/cdb"
+	"github.com/facebook/dns/dnsrocks/fbserver"
+)
+
+const testData = `Zexample.com,a.ns.example.com,dns.example.com,123,7200,1800,604800,120,120,,
+&example.com,,a.ns.example.com,172800,,
++a.ns.example.com,192.0.2.53,172800,,
++www.example.com,192.0.2.1,180,,
+$GENERATE 1-60 +big.example.com,198.51.100.$,180,,
+`
+
+// startTestServer starts a server on a CDB compiled from testData
+func startTestServer(t *testing.T) (*fbserver.Server, Options) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	require.NoError(t, os.WriteFile(data, []byte(testData), 0o644))
+	conf := fbserver.NewServerConfig()
+	conf.DBConfig.Driver = "cdb"
+	conf.DBConfig.Path = filepath.Join(dir, "data.cdb")
+	_, err := cdb.CreateCDB(data, conf.DBConfig.Path, cdb.NewDefaultCreatorOptions())
+	require.NoError(t, err)
+	conf.IPAns["127.0.0.1"] = 100
+	srv, err := StartServer(conf)
+	require.NoError(t, err)
+	t.Cleanup(srv.Shutdown)
+	return srv, Options{
+		Zone:      "example.com",
+		Name:      "www.example.com",
+		TruncName: "big.example.com",
+		TCPServer: srv.Addrs()["tcp"],
+	}
+}
+
+func TestConformance(t *testing.T) {
+	srv, opts := startTestServer(t)
+	report := Run(context.Background(), srv.Addrs()["udp"], opts, Probes)
+
+	var text bytes.Buffer
+	require.NoError(t, report.WriteText(&text))
+	t.Log(text.String())
+	require.Len(t, report.Results, len(Probes))
+	require.Zero(t, report.Failed())
+
+	// the truncation probe is left out without name
+	opts.TruncName = ""
+	report = Run(context.Background(), srv.Addrs()["udp"], opts, Probes)
+	require.Len(t, report.Results, len(Probes)-1)
+}
+
+func TestConformanceFailures(t *testing.T) {
+	srv, opts := startTestServer(t)
+	probes, err := SelectProbes([]string{"refused", "dns"})
+	require.NoError(t, err)
+	require.Equal(t, "refused", probes[0].Name)
+
+	// an in-zone name is not refused, a name without A records has no answer
+	opts.OutOfZone = "www.example.com"
+	opts.Name = "nowhere.example.com"
+	report := Run(context.Background(), srv.Addrs()["udp"], opts, probes)
+	require.Equal(t, []Result{
+		{Probe: "refused", Rcode: "NOERROR", Error: "rcode NOERROR, expected REFUSED"},
+		{Probe: "dns", Rcode: "NXDOMAIN", Error: "rcode NXDOMAIN, expected NOERROR"},
+	}, report.Results)
+	require.Equal(t, 2, report.Failed())
+
+	var out bytes.Buffer
+	require.NoError(t, report.WriteJSON(&out))
+	var decoded Report
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	require.Equal(t, *report, decoded)
+
+	_, err = SelectProbes([]string{"bogus"})
+	require.Error(t, err)
+}
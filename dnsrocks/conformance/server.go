@@ -0,0 +1,52 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conformance
+
+import (
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/fbserver"
+	"github.com/facebook/dns/dnsrocks/metrics"
+)
+
+// noMetrics drops the connection metrics of the server
+type noMetrics struct{}
+
+func (noMetrics) ConsumeStats(string, *metrics.Stats) error {
+	return nil
+}
+
+// StartServer starts a dnsrocks server with conf, listening on UDP and TCP on
+// 127.0.0.1 unless conf sets IPs, and returns it once it serves queries, see
+// fbserver.Server.Addrs for its addresses. With port 0, the UDP and TCP ports
+// differ, see Options.TCPServer.
+func StartServer(conf fbserver.ServerConfig) (*fbserver.Server, error) {
+	if len(conf.IPAns) == 0 {
+		conf.IPAns = fbserver.NewServerConfig().IPAns
+		conf.IPAns["127.0.0.1"] = dnsserver.DefaultMaxAnswer
+	}
+	conf.TCP = true
+	if conf.NumCPU == 0 {
+		conf.NumCPU = 1
+	}
+	srv := fbserver.NewServer(conf, &dnsserver.DummyLogger{}, &stats.DummyStats{}, noMetrics{})
+	srv.NotifyStartedFunc = func() {
+		srv.ServersStartedWG.Done()
+	}
+	if err := srv.Start(); err != nil {
+		return nil, err
+	}
+	srv.ServersStartedWG.Wait()
+	return srv, nil
+}
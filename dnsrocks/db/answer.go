@@ -22,6 +22,8 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
 )
 
 // ResourceRecord holds the representation of a row from DB
@@ -50,6 +52,13 @@ type recordProcessor struct {
 	wildcard    bool
 	qname       string
 	qtype       uint16
+	// alias is the packed target of the ALIAS record found for an A/AAAA query
+	alias []byte
+	// ttl overrides the TTL of the answers if ttlOverride is set
+	ttl         uint32
+	ttlOverride bool
+	// dualStack is the dual-stack policy found for the queried name, if any
+	dualStack dnsdata.DualStackPolicy
 }
 
 func (rp *recordProcessor) parseResult(result []byte) error {
@@ -70,7 +79,33 @@ func (rp *recordProcessor) parseResult(result []byte) error {
 		glog.Errorf("Failed to extract rr from row: %v", err)
 		return err
 	}
+	if rec.Qtype == uint16(dnsdata.TypeTTL) {
+		// TTL overrides are not records of their own. Location specific ones
+		// are found first and take precedence.
+		if !rp.ttlOverride {
+			rp.ttl, rp.ttlOverride = rec.TTL, true
+		}
+		return nil
+	}
+	if rec.Qtype == uint16(dnsdata.TypeDualStack) {
+		// Neither are dual-stack policies, which apply the same way.
+		if rp.dualStack == 0 && len(result) > rec.Offset {
+			rp.dualStack = dnsdata.DualStackPolicy(result[rec.Offset])
+		}
+		return nil
+	}
 	rp.recordFound = true
+	if rec.Qtype == uint16(dnsdata.TypeENT) {
+		// Empty non-terminal markers only make their name exist
+		return nil
+	}
+	if rec.Qtype == uint16(dnsdata.TypeALIAS) {
+		// ALIAS records are never served, see flattenAlias
+		if rp.alias == nil && (rp.qtype == dns.TypeA || rp.qtype == dns.TypeAAAA) {
+			rp.alias = append([]byte(nil), result[rec.Offset:]...)
+		}
+		return nil
+	}
 	if rec.Qtype == dns.TypeCNAME || rec.Qtype == rp.qtype || rp.qtype == dns.TypeANY {
 		// When dealing with A/AAAA we may have weighted round-robin records
 		// Compute the weight and update wrr4/wrr6 with the current winner.
@@ -100,6 +135,16 @@ func (rp *recordProcessor) parseResult(result []byte) error {
 	return nil
 }
 
+// overrideTTLs sets the TTL of answers to the override found, if any
+func (rp *recordProcessor) overrideTTLs(answers []dns.RR) {
+	if !rp.ttlOverride {
+		return
+	}
+	for _, rr := range answers {
+		rr.Header().Ttl = rp.ttl
+	}
+}
+
 func (rp *recordProcessor) responseCode() int {
 	// If any records are returned, we suppress most errors. This ensures that we don't
 	// drop a potentially useful response. However, if no records are returned and an error
@@ -261,16 +306,26 @@ func (r *DataReader) IsAuthoritative(q []byte, locID ID) (ns bool, auth bool, zo
 
 // FindAnswer will find answers for a given query q
 func (r *DataReader) FindAnswer(q []byte, packedControlName []byte, qname string, qtype uint16, locID ID, a *dns.Msg, maxAnswer int) (bool, int) {
+	answered := len(a.Answer)
+	rp := r.findAnswer(q, packedControlName, qname, qtype, locID, a, maxAnswer)
+	weighted, rcode := flattenAlias(r, r.findAnswer, rp, len(a.Answer) > answered, locID, a, maxAnswer)
+	applyDualStackPolicy(r, rp, packedControlName, locID, a, answered)
+	return weighted, rcode
+}
+
+// findAnswer finds answers for q without flattening ALIAS records
+func (r *DataReader) findAnswer(q []byte, packedControlName []byte, qname string, qtype uint16, locID ID, a *dns.Msg, maxAnswer int) *recordProcessor {
 	var (
 		rrs []dns.RR
 		err error
 		key = make([]byte, len(q)+len(locID))
 		rp  = &recordProcessor{
 			msg:   a,
-			wrs:   Wrs{MaxAnswers: maxAnswer},
+			wrs:   Wrs{MaxAnswers: maxAnswer, rand: r.rand},
 			qname: qname,
 			qtype: qtype,
 		}
+		answered = len(a.Answer)
 	)
 
 	for {
@@ -323,8 +378,80 @@ func (r *DataReader) FindAnswer(q []byte, packedControlName []byte, qname string
 		q = q[q[0]+1:]
 		rp.wildcard = true
 	}
+	rp.overrideTTLs(a.Answer[answered:])
+
+	return rp
+}
+
+// answerFinder finds answers for a query without flattening ALIAS records
+type answerFinder func(q []byte, packedControlName []byte, qname string, qtype uint16, locID ID, a *dns.Msg, maxAnswer int) *recordProcessor
+
+// flattenAlias completes the answers found by rp: when the name has an ALIAS
+// record and no records of the queried A/AAAA type, the records of the ALIAS
+// target we are authoritative for are added instead, with their own TTLs and
+// as found for locID, but owned by the queried name. Targets are not
+// flattened further, so that ALIAS records can't loop.
+func flattenAlias(r Reader, find answerFinder, rp *recordProcessor, answered bool, locID ID, a *dns.Msg, maxAnswer int) (bool, int) {
+	weighted, rcode := rp.wrs.WeightedAnswer(), rp.responseCode()
+	if rp.alias == nil || answered {
+		return weighted, rcode
+	}
+	_, auth, zoneCut, err := r.IsAuthoritative(rp.alias, locID)
+	if err != nil {
+		glog.Errorf("Failed to find zone of ALIAS target: %v", err)
+		return weighted, dns.RcodeServerFailure
+	}
+	if !auth {
+		return weighted, rcode
+	}
+	target := find(rp.alias, zoneCut, rp.qname, rp.qtype, locID, a, maxAnswer)
+	if target.responseCode() == dns.RcodeServerFailure {
+		return weighted, dns.RcodeServerFailure
+	}
+	// the queried name exists, whether the target does or not
+	return weighted || target.wrs.WeightedAnswer(), rcode
+}
 
-	return rp.wrs.WeightedAnswer(), rp.responseCode()
+// applyDualStackPolicy removes the A or AAAA records answered since answered
+// when suppressed by the dual-stack policy of the queried name, or else of
+// its zone. The name still exists, so the answer becomes NODATA if no other
+// record is left.
+func applyDualStackPolicy(r Reader, rp *recordProcessor, zoneCut []byte, locID ID, a *dns.Msg, answered int) {
+	addresses := false
+	for _, rr := range a.Answer[answered:] {
+		rrtype := rr.Header().Rrtype
+		addresses = addresses || rrtype == dns.TypeA || rrtype == dns.TypeAAAA
+	}
+	if !addresses {
+		return
+	}
+	policy := rp.dualStack
+	if policy == 0 {
+		err := r.ForEachResourceRecord(zoneCut, locID, func(result []byte) error {
+			rec, err := ExtractRRFromRow(result, false)
+			if err != nil {
+				// nolint: nilerr
+				return nil
+			}
+			if policy == 0 && rec.Qtype == uint16(dnsdata.TypeDualStack) && len(result) > rec.Offset {
+				policy = dnsdata.DualStackPolicy(result[rec.Offset])
+			}
+			return nil
+		})
+		if err != nil {
+			glog.Errorf("Failed to find dual-stack policy: %v", err)
+		}
+	}
+	if policy == 0 {
+		return
+	}
+	kept := a.Answer[:answered]
+	for _, rr := range a.Answer[answered:] {
+		if !policy.Suppresses(rr.Header().Rrtype) {
+			kept = append(kept, rr)
+		}
+	}
+	a.Answer = kept
 }
 
 // FindSOA find SOA record and set it into the Authority section of the message.
@@ -23,15 +23,25 @@ import (
 )
 
 func (r *sortedDataReader) FindAnswer(q []byte, packedControlName []byte, qname string, qtype uint16, locID ID, a *dns.Msg, maxAnswer int) (bool, int) {
+	answered := len(a.Answer)
+	rp := r.findAnswer(q, packedControlName, qname, qtype, locID, a, maxAnswer)
+	weighted, rcode := flattenAlias(r, r.findAnswer, rp, len(a.Answer) > answered, locID, a, maxAnswer)
+	applyDualStackPolicy(r, rp, packedControlName, locID, a, answered)
+	return weighted, rcode
+}
+
+// findAnswer finds answers for q without flattening ALIAS records
+func (r *sortedDataReader) findAnswer(q []byte, packedControlName []byte, qname string, qtype uint16, locID ID, a *dns.Msg, maxAnswer int) *recordProcessor {
 	var (
 		rrs []dns.RR
 		err error
 		rp  = &recordProcessor{
 			msg:   a,
-			wrs:   Wrs{MaxAnswers: maxAnswer},
+			wrs:   Wrs{MaxAnswers: maxAnswer, rand: r.rand},
 			qname: qname,
 			qtype: qtype,
 		}
+		answered = len(a.Answer)
 	)
 
 	var lastLength = len(q)
@@ -95,8 +105,9 @@ func (r *sortedDataReader) FindAnswer(q []byte, packedControlName []byte, qname
 	if err != nil {
 		rp.seenError = true
 	}
+	rp.overrideTTLs(a.Answer[answered:])
 
-	return rp.wrs.WeightedAnswer(), rp.responseCode()
+	return rp
 }
 
 func (r *sortedDataReader) IsAuthoritative(q []byte, locID ID) (ns bool, auth bool, zoneCut []byte, err error) {
@@ -314,6 +314,45 @@ func TestDBFindAnswer(t *testing.T) {
 	}
 }
 
+// TestDBFindAnswerRandSeed checks that readers with the same seed pick the
+// same weighted records, and that the pick depends on the seed
+func TestDBFindAnswerRandSeed(t *testing.T) {
+	var q = make([]byte, 255)
+	var controlName = make([]byte, 255)
+	offset, err := dns.PackDomainName("wrr.example.com.", q, 0, nil, false)
+	require.NoError(t, err)
+	controlOffset, err := dns.PackDomainName("example.com.", controlName, 0, nil, false)
+	require.NoError(t, err)
+
+	for _, config := range testaid.TestDBs {
+		t.Run(config.Driver, func(t *testing.T) {
+			db, err := Open(config.Path, config.Driver)
+			require.NoError(t, err)
+			defer db.Destroy()
+
+			pick := func(seed uint64) string {
+				r, err := NewReader(db)
+				require.NoError(t, err)
+				defer r.Close()
+				r.SetRandSeed(seed)
+				a := new(dns.Msg)
+				weighted, _ := r.FindAnswer(q[:offset], controlName[:controlOffset], "wrr.example.com.", dns.TypeA, []byte{0, 0}, a, 1)
+				require.True(t, weighted)
+				require.Len(t, a.Answer, 1)
+				return a.Answer[0].(*dns.A).A.String()
+			}
+
+			seen := make(map[string]bool)
+			for seed := uint64(0); seed < 100; seed++ {
+				ip := pick(seed)
+				require.Equal(t, ip, pick(seed), "seed %d", seed)
+				seen[ip] = true
+			}
+			require.Len(t, seen, 3)
+		})
+	}
+}
+
 func BenchmarkFindAnswer(b *testing.B) {
 	var (
 		packedQName = make([]byte, 255)
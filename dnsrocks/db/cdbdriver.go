@@ -29,18 +29,24 @@ import (
 type cdbdriver struct {
 	db          *cdb.Cdb
 	contextPool sync.Pool
+	// separateBitMap is Options.SeparateBitMap
+	separateBitMap bool
 }
 
 var newCdbContextFunc = func() interface{} {
 	return cdb.NewContext()
 }
 
-func openCDB(name string) (DBI, error) {
+func openCDB(name string, opts Options) (DBI, error) {
 	c, err := cdb.Open(name)
 	if err != nil {
 		return nil, err
 	}
-	driver := &cdbdriver{c, sync.Pool{New: newCdbContextFunc}}
+	driver := &cdbdriver{
+		db:             c,
+		contextPool:    sync.Pool{New: newCdbContextFunc},
+		separateBitMap: opts.SeparateBitMap,
+	}
 	return driver, nil
 }
 
@@ -75,6 +81,17 @@ func (c *cdbdriver) Find(key []byte, context Context) ([]byte, error) {
 // Starting from a domain = q, first we try to get an exact match
 // then, we remove 1 label at a time and try to find a wildcard match.
 func (c *cdbdriver) FindMap(domain, mtype []byte, context Context) ([]byte, error) {
+	return findMap(c, domain, mtype, context)
+}
+
+// keyFinder finds the first value of a key, io.EOF if it has none
+type keyFinder interface {
+	Find(key []byte, context Context) ([]byte, error)
+}
+
+// findMap implements FindMap for drivers storing each value of a key as its
+// own record
+func findMap(c keyFinder, domain, mtype []byte, context Context) ([]byte, error) {
 	var (
 		k = make([]byte, 0, 50) // prime the byte array capacity
 	)
@@ -92,8 +109,7 @@ func (c *cdbdriver) FindMap(domain, mtype []byte, context Context) ([]byte, erro
 		} else {
 			k = append(k[:dlen], exactMatchKeyElement...)
 		}
-		c.FindStart(context)
-		mapID, err := c.FindNext(k, context)
+		mapID, err := c.Find(k, context)
 
 		// We found a match. Copy this to the MapID
 		if err == nil {
@@ -115,6 +131,12 @@ func (c *cdbdriver) FindMap(domain, mtype []byte, context Context) ([]byte, erro
 
 // GetLocationByMap finds and returns location and mask. If the location is not found, returns nil and 0.
 func (c *cdbdriver) GetLocationByMap(ipnet *net.IPNet, mapID []byte, context Context) ([]byte, uint8, error) {
+	return getLocationByMap(c, c.separateBitMap, ipnet, mapID, context)
+}
+
+// getLocationByMap implements GetLocationByMap for drivers storing each value
+// of a key as its own record
+func getLocationByMap(c keyFinder, separateBitMap bool, ipnet *net.IPNet, mapID []byte, context Context) ([]byte, uint8, error) {
 	var (
 		clientIP = ipnet.IP
 		// maskLens is an array or mask length
@@ -145,15 +167,14 @@ func (c *cdbdriver) GetLocationByMap(ipnet *net.IPNet, mapID []byte, context Con
 	}
 	// maskLens DB key: "\000/"
 	bitmapKey := maskLensKeyElement
-	if SeparateBitMap {
+	if separateBitMap {
 		if isv4 {
 			bitmapKey = maskLensKeyElementv4
 		} else {
 			bitmapKey = maskLensKeyElementv6
 		}
 	}
-	c.FindStart(context)
-	maskLens, err := c.FindNext(bitmapKey, context)
+	maskLens, err := c.Find(bitmapKey, context)
 
 	if errors.Is(err, io.EOF) {
 		// No maskLens found, return what we have. e.g default l.LocID == {0, 0}
@@ -185,8 +206,7 @@ func (c *cdbdriver) GetLocationByMap(ipnet *net.IPNet, mapID []byte, context Con
 			k[dlen+i] &= currentCIDRMask[i]
 		}
 		k[dlen+net.IPv6len] = mask
-		c.FindStart(context)
-		locID, err := c.FindNext(k, context)
+		locID, err := c.Find(k, context)
 		if errors.Is(err, io.EOF) {
 			continue
 		}
@@ -222,7 +242,7 @@ func (c *cdbdriver) Close() error {
 func (c *cdbdriver) Reload(path string) (DBI, error) {
 	start := time.Now()
 	glog.Infof("Doing full CDB reload, new path=%s", path)
-	newDBI, err := openCDB(path)
+	newDBI, err := openCDB(path, Options{SeparateBitMap: c.separateBitMap})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,89 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// ErrNoChecksum is returned when verifying the checksum of a DB compiled
+// without one
+var ErrNoChecksum = errors.New("no checksum")
+
+// specialValue returns the value of a special key storing a single value, or
+// nil if missing
+func (f *DB) specialValue(key string) ([]byte, error) {
+	var value []byte
+	reader, err := NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	err = reader.ForEach([]byte(key), func(v []byte) error {
+		value = slices.Clone(v)
+		return nil
+	})
+	reader.Close()
+	return value, err
+}
+
+// Checksum returns the checksum stored in the DB by the compiler, or
+// ErrNoChecksum
+func (f *DB) Checksum() (*dnsdata.Checksum, error) {
+	value, err := f.specialValue(dnsdata.ChecksumKey)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ErrNoChecksum
+	}
+	sum := new(dnsdata.Checksum)
+	if err := sum.UnmarshalText(value); err != nil {
+		return nil, err
+	}
+	return sum, nil
+}
+
+// VerifyChecksum recomputes the checksum of the content of the DB and
+// compares it to the stored one, whose signature must be from one of keys if
+// any. It returns the verified checksum. This reads the whole DB.
+func (f *DB) VerifyChecksum(keys []ed25519.PublicKey) (*dnsdata.Checksum, error) {
+	stored, err := f.Checksum()
+	if err != nil {
+		return nil, err
+	}
+	walker, err := walkerOf(f.dbi)
+	if err != nil {
+		return nil, err
+	}
+	hasher := dnsdata.NewChecksumHasher()
+	err = walker.forEachRecord(nil, false, func(key, value []byte) error {
+		hasher.Add(key, value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sum := hasher.Sum(nil); sum.String() != stored.String() {
+		return nil, fmt.Errorf("%w: content has checksum %s, DB has %s", dnsdata.ErrChecksumMismatch, sum, stored)
+	}
+	if err := stored.Verify(keys); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gocdb "github.com/repustate/go-cdb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/cdb"
+)
+
+func TestChecksumAndProvenanceCDB(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	require.NoError(t, os.WriteFile(data, []byte("Zexample.com,ns.example.com,dns.example.com,,,,,,\n+www.example.com,192.0.2.1\n+www.example.com,192.0.2.2\n"), 0o644))
+	compile := func(name string, opts cdb.CreatorOptions) *DB {
+		path := filepath.Join(dir, name)
+		opts.NumCPU = 1
+		_, err := cdb.CreateCDB(data, path, &opts)
+		require.NoError(t, err)
+		db, err := Open(path, "cdb")
+		require.NoError(t, err)
+		t.Cleanup(db.Destroy)
+		return db
+	}
+
+	plain := compile("plain.cdb", cdb.CreatorOptions{})
+	_, err = plain.Checksum()
+	require.ErrorIs(t, err, ErrNoChecksum)
+	_, err = plain.VerifyChecksum(nil)
+	require.ErrorIs(t, err, ErrNoChecksum)
+	_, err = plain.Provenance()
+	require.ErrorIs(t, err, ErrNoProvenance)
+
+	unsigned := compile("unsigned.cdb", cdb.CreatorOptions{Checksum: true})
+	sum, err := unsigned.VerifyChecksum(nil)
+	require.NoError(t, err)
+	stored, err := unsigned.Checksum()
+	require.NoError(t, err)
+	require.Equal(t, stored, sum)
+	_, err = unsigned.VerifyChecksum([]ed25519.PublicKey{pub})
+	require.ErrorIs(t, err, dnsdata.ErrChecksumMismatch)
+
+	signed := compile("signed.cdb", cdb.CreatorOptions{SigningKey: priv})
+	signedSum, err := signed.VerifyChecksum([]ed25519.PublicKey{otherPub, pub})
+	require.NoError(t, err)
+	require.Equal(t, sum.Digest, signedSum.Digest, "signing does not change the digest")
+
+	versioned := compile("versioned.cdb", cdb.CreatorOptions{Checksum: true, Version: "v1", Serial: 42})
+	versionedSum, err := versioned.VerifyChecksum(nil)
+	require.NoError(t, err)
+	require.NotEqual(t, sum.Digest, versionedSum.Digest, "the checksum covers the provenance")
+	p, err := versioned.Provenance()
+	require.NoError(t, err)
+	require.Equal(t, &dnsdata.Provenance{Version: "v1", Serial: 42}, p)
+	_, err = signed.VerifyChecksum([]ed25519.PublicKey{otherPub})
+	require.ErrorIs(t, err, dnsdata.ErrChecksumMismatch)
+
+	// a DB whose content does not match its checksum
+	h := dnsdata.NewChecksumHasher()
+	h.Add([]byte("key"), []byte("value"))
+	text, err := h.Sum(nil).MarshalText()
+	require.NoError(t, err)
+	path := filepath.Join(dir, "tampered.cdb")
+	w, err := gocdb.NewWriter(path)
+	require.NoError(t, err)
+	require.NoError(t, w.Put([]byte("key"), []byte("other value")))
+	require.NoError(t, w.Put([]byte(dnsdata.ChecksumKey), text))
+	require.NoError(t, w.Close())
+	tampered, err := Open(path, "cdb")
+	require.NoError(t, err)
+	defer tampered.Destroy()
+	_, err = tampered.VerifyChecksum(nil)
+	require.ErrorIs(t, err, dnsdata.ErrChecksumMismatch)
+}
@@ -17,7 +17,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand/v2"
 	"net"
+	"os"
 	"sync"
 	"time"
 
@@ -80,14 +82,48 @@ type Reader interface {
 	ForEach(key []byte, f func(value []byte) error) (err error)
 	ForEachResourceRecord(domainName []byte, locID ID, parseRecord func(result []byte) error) error
 
+	// Zones returns the zones of the DB, i.e. the owner names of its SOA
+	// records, sorted
+	Zones() ([]string, error)
+
+	// ForEachZoneRecord calls f with every resource record of zone, but the
+	// ones of its subzones with their own SOA record, the delegations to them
+	// excepted
+	ForEachZoneRecord(zone string, f func(rec ZoneRecord) error) error
+
+	// AuditOwnerNames returns the owner names of resource record keys which
+	// are spelled in more than one way, see DB.AuditOwnerNames
+	AuditOwnerNames() ([]OwnerNameConflict, error)
+
+	// SetDeadline makes the lookups of the reader fail once deadline has
+	// passed, when the backing storage supports it; the zero time removes it
+	SetDeadline(deadline time.Time)
+
+	// SetRandSeed makes the weighted random sampling of the answers of the
+	// reader reproducible, derived from seed
+	SetRandSeed(seed uint64)
+
+	// SetMapNamespace makes the location lookups of the reader use the maps
+	// of ns instead of the ones names are assigned to
+	SetMapNamespace(ns MapNamespace)
+
 	Close()
 }
 
 // DataReader wraps an DB to carry a Context around.
 // This structure will be able to perform DNS queries.
 type DataReader struct {
-	db      *DB
+	db *DB
+	// dbi is the DBI of db when the reader was created, possibly wrapped
+	// to trace the probes of this reader only
+	dbi     DBI
 	context Context
+	// rand, if set by SetRandSeed, replaces the shared PRNG of weighted
+	// random sampling
+	rand wrsRand
+	// mapNamespace, if set by SetMapNamespace, replaces maps in location
+	// lookups
+	mapNamespace MapNamespace
 }
 
 type sortedDataReader struct {
@@ -101,23 +137,91 @@ var ErrValidationKeyNotFound = errors.New("validation key not found in DB")
 // ErrReloadTimeout - DB reload timeout
 var ErrReloadTimeout = errors.New("DB reload timeout")
 
+// Options are the settings of a DB, fixed at open time
+type Options struct {
+	// SeparateBitMap makes CDB location lookups use the mask lengths of
+	// IPv4 and IPv6 subnets stored separately instead of the shared ones
+	SeparateBitMap bool
+	// LocationIndex loads the subnet to location data in memory, see
+	// OpenWithLocationIndex
+	LocationIndex bool
+	// LocationCacheSize, if positive, is the number of recent location
+	// lookups, keyed by map ID and subnet, to keep in memory. The cache is
+	// emptied on reloads.
+	LocationCacheSize int
+	// ReadOnly opens RDB databases in read-only mode instead of as a
+	// secondary; reloading the same path then reopens the database instead
+	// of catching up with the primary
+	ReadOnly bool
+	// MaxStaleness, if set, is how long an RDB secondary can go without
+	// catching up before its stats report it as stale
+	MaxStaleness time.Duration
+	// MemoryBudget, if set, is the block cache and write buffer memory RDB
+	// databases share with the other ones opened with it, see NewMemoryBudget
+	MemoryBudget *MemoryBudget
+	// Faults, if enabled, are injected in lookups, see FaultConfig
+	Faults FaultConfig
+}
+
+// DefaultOptions returns the options used by Open. SeparateBitMap is set if
+// the FBDNS_SEPARATE_MASKLENS environment variable is not empty, Faults by
+// the FBDNS_FAULT_* ones.
+func DefaultOptions() Options {
+	return Options{
+		SeparateBitMap: os.Getenv("FBDNS_SEPARATE_MASKLENS") != "",
+		Faults:         defaultFaultConfig(),
+	}
+}
+
 // Open opens the named file read-only and returns a new db object.  The file
-// should exist and be a compatible (CDB or RDB) database file.
+// should exist and be a compatible (CDB or RDB) database file, the memory
+// driver loading a CDB file in memory.
 func Open(name string, driver string) (*DB, error) {
-	var openfunc func(string) (DBI, error)
+	return OpenWithOptions(name, driver, DefaultOptions())
+}
+
+// OpenWithOptions opens the named DB like Open, with the given options.
+// DBs opened with different options can be used side by side.
+func OpenWithOptions(name string, driver string, opts Options) (*DB, error) {
+	var openfunc func(string, Options) (DBI, error)
 
 	switch driver {
 	case "cdb":
 		openfunc = openCDB
+	case "memory":
+		openfunc = openMemory
 	case "rocksdb":
 		openfunc = openRDB
 	default:
-		return nil, fmt.Errorf("%s: invalid argument; valid values are: cdb, rocksdb", driver)
+		return nil, fmt.Errorf("%s: invalid argument; valid values are: cdb, memory, rocksdb", driver)
 	}
-	dbi, err := openfunc(name)
+	dbi, err := openfunc(name, opts)
 	if err != nil {
 		return nil, err
 	}
+	if opts.LocationIndex {
+		indexed, err := newIndexedLocationDriver(dbi)
+		if err != nil {
+			dbi.Close()
+			return nil, err
+		}
+		dbi = indexed
+	}
+	if opts.LocationCacheSize > 0 {
+		cached, err := newLocationCacheDriver(dbi, opts.LocationCacheSize)
+		if err != nil {
+			dbi.Close()
+			return nil, err
+		}
+		dbi = cached
+	}
+	if opts.Faults.Enabled() {
+		if err := opts.Faults.Validate(); err != nil {
+			dbi.Close()
+			return nil, err
+		}
+		dbi = newFaultInjectingDBI(dbi, opts.Faults)
+	}
 	return &DB{dbi: dbi}, nil
 }
 
@@ -131,7 +235,7 @@ func NewReader(db *DB) (Reader, error) {
 	db.refCount++
 	context := db.dbi.NewContext()
 
-	reader := DataReader{db: db, context: context}
+	reader := DataReader{db: db, dbi: db.dbi, context: context}
 
 	closestKeyFinder := db.dbi.ClosestKeyFinder()
 
@@ -161,6 +265,29 @@ func (f *DB) Destroy() {
 // to verify that the format of the DB file is valid, by checking for the existence of a key
 // that is known to exist. If the DB file is invalid, the old DB will continue to be used.
 func (f *DB) Reload(path string, validationKey []byte, reloadTimeout time.Duration) (*DB, error) {
+	return f.ReloadWithCheck(path, func(newDB *DB) error {
+		return newDB.ValidateDbKey(validationKey)
+	}, reloadTimeout)
+}
+
+// ReloadWithCheck is Reload with the validation key check replaced by check,
+// which is run against the reloaded DB before it is used. If check fails, the
+// old DB will continue to be used.
+func (f *DB) ReloadWithCheck(path string, check func(newDB *DB) error, reloadTimeout time.Duration) (*DB, error) {
+	newDB, err := f.ReopenWithCheck(path, check, reloadTimeout)
+	if err == nil && newDB != f {
+		glog.Infof("New DBI, old one will be destroyed")
+		// we have to deal with it here in this fashion because we handle refcounter on this level
+		f.Destroy()
+	}
+	return newDB, err
+}
+
+// ReopenWithCheck is ReloadWithCheck leaving the old DB to the caller: when
+// it returns a new DB, f is still open, so that the caller can switch to the
+// new one before destroying f. It returns f when RocksDB caught up in place,
+// or on failure.
+func (f *DB) ReopenWithCheck(path string, check func(newDB *DB) error, reloadTimeout time.Duration) (*DB, error) {
 	c := make(chan int)
 	ctx, cancel := context.WithTimeout(context.Background(), reloadTimeout)
 	defer cancel()
@@ -207,27 +334,26 @@ func (f *DB) Reload(path string, validationKey []byte, reloadTimeout time.Durati
 
 		// Validate newDBI
 		newDB := &DB{dbi: newDBI}
-		err = newDB.validateDbKeyOrDestroy(validationKey)
+		if newDBI == f.dbi {
+			// caught up in place, the DBI is still in use on failure
+			if err = check(newDB); err != nil {
+				glog.Errorf("Validation for caught up DBI failed: %v", err)
+				return f, err
+			}
+			return f, nil
+		}
+		err = newDB.checkOrDestroy(check)
 		if err != nil {
-			glog.Errorf("Key validation for New DBI failed, using old DB instead")
+			glog.Errorf("Validation for New DBI failed, using old DB instead: %v", err)
 			return f, err
 		}
-
-		if newDBI != f.dbi {
-			glog.Infof("New DBI, old one will be destroyed")
-			// we have to deal with it here in this fashion because we handle refcounter on this level
-			f.Destroy()
-			return newDB, nil
-		}
+		return newDB, nil
 	}
-
-	return f, nil
 }
 
-// validateDbKeyOrDestroy validates DB with the validationKey, and destroys the
-// DB on failure
-func (f *DB) validateDbKeyOrDestroy(validationKey []byte) error {
-	err := f.ValidateDbKey(validationKey)
+// checkOrDestroy validates DB with check, and destroys the DB on failure
+func (f *DB) checkOrDestroy(check func(*DB) error) error {
+	err := check(f)
 	if err != nil {
 		f.Destroy()
 		return err
@@ -282,19 +408,47 @@ func (r *DataReader) Data(key []byte) ([]byte, error) {
 // Find returns the first data value for the given key as a byte slice.
 // Find is the same as FindStart followed by FindNext.
 func (r *DataReader) Find(key []byte) ([]byte, error) {
-	return r.db.dbi.Find(key, r.context)
+	return r.dbi.Find(key, r.context)
 }
 
 // ForEach calls a function for each key match.
 // The function takes a byte slice as a value and return an error.
 // if error is not nil, the loop will stop.
 func (r *DataReader) ForEach(key []byte, f func(value []byte) error) (err error) {
-	return r.db.dbi.ForEach(key, f, r.context)
+	return r.dbi.ForEach(key, f, r.context)
+}
+
+// deadlineContext is a Context of a backing storage supporting read deadlines
+type deadlineContext interface {
+	SetDeadline(deadline time.Time)
+}
+
+// SetDeadline makes the lookups of the reader fail once deadline has passed.
+// RocksDB reads fail with an error wrapping context.DeadlineExceeded, CDB
+// lookups, served from memory, ignore the deadline.
+func (r *DataReader) SetDeadline(deadline time.Time) {
+	if c, ok := r.context.(deadlineContext); ok {
+		c.SetDeadline(deadline)
+	}
+}
+
+// SetRandSeed makes the weighted random sampling of the answers of the reader,
+// CNAME targets included, derive from seed rather than from the shared PRNG,
+// so that the same seed picks the same records out of the same data.
+func (r *DataReader) SetRandSeed(seed uint64) {
+	r.rand = rand.New(rand.NewPCG(seed, seed))
+}
+
+// SetMapNamespace makes the location lookups of the reader, CNAME targets
+// included, look locations up in the maps of ns instead of the ones names are
+// assigned to. Maps missing from ns are used as is.
+func (r *DataReader) SetMapNamespace(ns MapNamespace) {
+	r.mapNamespace = ns
 }
 
 // Close close a reader. This puts back a context in the pool
 func (r *DataReader) Close() {
-	r.db.dbi.FreeContext(r.context)
+	r.dbi.FreeContext(r.context)
 	r.db.l.Lock()
 	defer r.db.l.Unlock()
 	r.db.refCount--
@@ -52,7 +52,10 @@ func TestDbKeyValidationV1Keys(t *testing.T) {
 		},
 	}
 
-	testDBs := []testaid.TestDB{testaid.TestCDB, testaid.TestRDB}
+	testDBs := []testaid.TestDB{testaid.TestCDB}
+	if testaid.RocksDB {
+		testDBs = append(testDBs, testaid.TestRDB)
+	}
 
 	for _, testDb := range testDBs {
 		db, err := Open(testDb.Path, testDb.Driver)
@@ -72,6 +75,7 @@ func TestDbKeyValidationV1Keys(t *testing.T) {
 
 // TestDbKeyValidation verify the db key validation function works as expected
 func TestDbKeyValidationV2Keys(t *testing.T) {
+	testaid.RequireRocksDB(t)
 	testCases := []struct {
 		key []byte
 		err error
@@ -0,0 +1,244 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ErrInjectedFault is the error of DB lookups failed by fault injection
+var ErrInjectedFault = errors.New("injected DB fault")
+
+// Environment variables setting the FaultConfig of DefaultOptions
+const (
+	FaultLatencyEnv   = "FBDNS_FAULT_LATENCY"
+	FaultErrorRateEnv = "FBDNS_FAULT_ERROR_RATE"
+	FaultMissRateEnv  = "FBDNS_FAULT_MISS_RATE"
+)
+
+// FaultConfig configures faults injected in the lookups of a DB, to check how
+// the handler behaves when its backend fails, in integration tests and chaos
+// drills. Faults are never injected in production by default.
+type FaultConfig struct {
+	// Latency is added to every lookup
+	Latency time.Duration
+	// ErrorRate is the fraction of lookups failing with ErrInjectedFault
+	ErrorRate float64
+	// MissRate is the fraction of lookups finding no data, as if keys were
+	// missing. Values of multi-value lookups are dropped independently,
+	// returning partial data.
+	MissRate float64
+}
+
+// Enabled returns true if c injects any fault
+func (c FaultConfig) Enabled() bool {
+	return c.Latency > 0 || c.ErrorRate > 0 || c.MissRate > 0
+}
+
+// Validate checks the rates of c
+func (c FaultConfig) Validate() error {
+	if c.Latency < 0 {
+		return fmt.Errorf("invalid fault latency %v", c.Latency)
+	}
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("invalid fault error rate %v", c.ErrorRate)
+	}
+	if c.MissRate < 0 || c.MissRate > 1 {
+		return fmt.Errorf("invalid fault miss rate %v", c.MissRate)
+	}
+	return nil
+}
+
+// FaultConfigFromEnv returns the FaultConfig set by the FBDNS_FAULT_*
+// environment variables
+func FaultConfigFromEnv() (FaultConfig, error) {
+	var (
+		c   FaultConfig
+		err error
+	)
+	if v := os.Getenv(FaultLatencyEnv); v != "" {
+		if c.Latency, err = time.ParseDuration(v); err != nil {
+			return c, fmt.Errorf("%s: %w", FaultLatencyEnv, err)
+		}
+	}
+	if v := os.Getenv(FaultErrorRateEnv); v != "" {
+		if c.ErrorRate, err = strconv.ParseFloat(v, 64); err != nil {
+			return c, fmt.Errorf("%s: %w", FaultErrorRateEnv, err)
+		}
+	}
+	if v := os.Getenv(FaultMissRateEnv); v != "" {
+		if c.MissRate, err = strconv.ParseFloat(v, 64); err != nil {
+			return c, fmt.Errorf("%s: %w", FaultMissRateEnv, err)
+		}
+	}
+	return c, c.Validate()
+}
+
+// defaultFaultConfig returns the FaultConfig set by the environment, none if
+// it is invalid
+func defaultFaultConfig() FaultConfig {
+	c, err := FaultConfigFromEnv()
+	if err != nil {
+		glog.Errorf("Ignoring DB fault injection: %v", err)
+		return FaultConfig{}
+	}
+	if c.Enabled() {
+		glog.Warningf("Injecting DB faults: %+v", c)
+	}
+	return c
+}
+
+// faultCounters counts the faults injected, shared across reloads
+type faultCounters struct {
+	delays atomic.Int64
+	errors atomic.Int64
+	misses atomic.Int64
+}
+
+// faultInjectingDBI is a DBI injecting faults in lookups
+type faultInjectingDBI struct {
+	DBI
+	conf     FaultConfig
+	rand     *rand.Rand
+	counters *faultCounters
+}
+
+func newFaultInjectingDBI(dbi DBI, conf FaultConfig) *faultInjectingDBI {
+	return &faultInjectingDBI{DBI: dbi, conf: conf, rand: NewRand(), counters: &faultCounters{}}
+}
+
+// inject delays a lookup and returns whether it fails or finds no data
+func (f *faultInjectingDBI) inject() (fail, miss bool) {
+	if f.conf.Latency > 0 {
+		f.counters.delays.Add(1)
+		time.Sleep(f.conf.Latency)
+	}
+	if f.conf.ErrorRate > 0 && f.rand.Float64() < f.conf.ErrorRate {
+		f.counters.errors.Add(1)
+		return true, false
+	}
+	return false, f.drop()
+}
+
+// drop returns whether a value is dropped
+func (f *faultInjectingDBI) drop() bool {
+	if f.conf.MissRate > 0 && f.rand.Float64() < f.conf.MissRate {
+		f.counters.misses.Add(1)
+		return true
+	}
+	return false
+}
+
+func (f *faultInjectingDBI) Find(key []byte, context Context) ([]byte, error) {
+	fail, miss := f.inject()
+	if fail {
+		return nil, ErrInjectedFault
+	}
+	if miss {
+		return nil, io.EOF
+	}
+	return f.DBI.Find(key, context)
+}
+
+func (f *faultInjectingDBI) ForEach(key []byte, fn func(value []byte) error, context Context) error {
+	if fail, _ := f.inject(); fail {
+		return ErrInjectedFault
+	}
+	return f.DBI.ForEach(key, func(value []byte) error {
+		if f.drop() {
+			return nil
+		}
+		return fn(value)
+	}, context)
+}
+
+func (f *faultInjectingDBI) FindMap(domain, mtype []byte, context Context) ([]byte, error) {
+	fail, miss := f.inject()
+	if fail {
+		return nil, ErrInjectedFault
+	}
+	if miss {
+		return nil, nil
+	}
+	return f.DBI.FindMap(domain, mtype, context)
+}
+
+func (f *faultInjectingDBI) GetLocationByMap(ipnet *net.IPNet, mapID []byte, context Context) ([]byte, uint8, error) {
+	fail, miss := f.inject()
+	if fail {
+		return nil, 0, ErrInjectedFault
+	}
+	if miss {
+		return nil, 0, nil
+	}
+	return f.DBI.GetLocationByMap(ipnet, mapID, context)
+}
+
+func (f *faultInjectingDBI) ClosestKeyFinder() ClosestKeyFinder {
+	finder := f.DBI.ClosestKeyFinder()
+	if finder == nil {
+		return nil
+	}
+	return &faultInjectingClosestKeyFinder{ClosestKeyFinder: finder, f: f}
+}
+
+// Reload reloads the wrapped DBI, injecting faults in the new one as well
+func (f *faultInjectingDBI) Reload(path string) (DBI, error) {
+	newDBI, err := f.DBI.Reload(path)
+	if err != nil {
+		return nil, err
+	}
+	if newDBI == f.DBI {
+		return f, nil
+	}
+	return &faultInjectingDBI{DBI: newDBI, conf: f.conf, rand: f.rand, counters: f.counters}, nil
+}
+
+// GetStats reports DB backend stats, along with the faults injected
+func (f *faultInjectingDBI) GetStats() map[string]int64 {
+	stats := f.DBI.GetStats()
+	stats["fault.delays"] = f.counters.delays.Load()
+	stats["fault.errors"] = f.counters.errors.Load()
+	stats["fault.misses"] = f.counters.misses.Load()
+	return stats
+}
+
+// faultInjectingClosestKeyFinder is a ClosestKeyFinder injecting latency and
+// errors. Misses are not injected, as they would return keys of other names.
+type faultInjectingClosestKeyFinder struct {
+	ClosestKeyFinder
+	f *faultInjectingDBI
+}
+
+func (c *faultInjectingClosestKeyFinder) FindClosestKey(key []byte, context Context) ([]byte, error) {
+	if c.f.conf.Latency > 0 {
+		c.f.counters.delays.Add(1)
+		time.Sleep(c.f.conf.Latency)
+	}
+	if c.f.conf.ErrorRate > 0 && c.f.rand.Float64() < c.f.conf.ErrorRate {
+		c.f.counters.errors.Add(1)
+		return nil, ErrInjectedFault
+	}
+	return c.ClosestKeyFinder.FindClosestKey(key, context)
+}
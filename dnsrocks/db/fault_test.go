@@ -0,0 +1,128 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestFaultConfigFromEnv(t *testing.T) {
+	c, err := FaultConfigFromEnv()
+	require.NoError(t, err)
+	require.False(t, c.Enabled())
+
+	t.Setenv(FaultLatencyEnv, "5ms")
+	t.Setenv(FaultErrorRateEnv, "0.5")
+	t.Setenv(FaultMissRateEnv, "0.25")
+	c, err = FaultConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, FaultConfig{Latency: 5 * time.Millisecond, ErrorRate: 0.5, MissRate: 0.25}, c)
+	require.Equal(t, c, DefaultOptions().Faults)
+
+	t.Setenv(FaultErrorRateEnv, "2")
+	_, err = FaultConfigFromEnv()
+	require.Error(t, err)
+	require.False(t, DefaultOptions().Faults.Enabled())
+
+	t.Setenv(FaultLatencyEnv, "soon")
+	_, err = FaultConfigFromEnv()
+	require.Error(t, err)
+}
+
+func TestFaultConfigValidate(t *testing.T) {
+	require.NoError(t, FaultConfig{}.Validate())
+	require.Error(t, FaultConfig{Latency: -1}.Validate())
+	require.Error(t, FaultConfig{ErrorRate: -0.1}.Validate())
+	require.Error(t, FaultConfig{MissRate: 1.1}.Validate())
+}
+
+// lookupFoo looks up the A records of foo.example.org for resolver 1.1.1.1
+func lookupFoo(t *testing.T, db *DB) (*dns.Msg, error) {
+	q := make([]byte, 255)
+	offset, err := dns.PackDomainName("foo.example.org.", q, 0, nil, false)
+	require.NoError(t, err)
+	r, err := NewReader(db)
+	require.NoError(t, err)
+	defer r.Close()
+
+	loc, err := r.FindLocation(q[:offset], nil, "1.1.1.1")
+	if err != nil {
+		return nil, err
+	}
+	_, _, zoneCut, err := r.IsAuthoritative(q[:offset], loc.LocID)
+	if err != nil {
+		return nil, err
+	}
+	a := new(dns.Msg)
+	r.FindAnswer(q[:offset], zoneCut, "foo.example.org.", dns.TypeA, loc.LocID, a, 1)
+	return a, nil
+}
+
+func TestFaultInjection(t *testing.T) {
+	for _, config := range testaid.TestDBs {
+		t.Run(fmt.Sprintf("%s/%s", config.Driver, config.Flavour), func(t *testing.T) {
+			open := func(faults FaultConfig) *DB {
+				opts := DefaultOptions()
+				opts.Faults = faults
+				db, err := OpenWithOptions(config.Path, config.Driver, opts)
+				require.NoError(t, err)
+				return db
+			}
+
+			db := open(FaultConfig{ErrorRate: 1})
+			_, err := lookupFoo(t, db)
+			require.ErrorIs(t, err, ErrInjectedFault)
+			require.NotZero(t, db.GetStats()["fault.errors"])
+			db.Destroy()
+
+			db = open(FaultConfig{MissRate: 1})
+			a, err := lookupFoo(t, db)
+			require.NoError(t, err)
+			require.Empty(t, a.Answer)
+			require.NotZero(t, db.GetStats()["fault.misses"])
+			db.Destroy()
+
+			db = open(FaultConfig{Latency: 10 * time.Millisecond})
+			start := time.Now()
+			a, err = lookupFoo(t, db)
+			require.NoError(t, err)
+			require.Len(t, a.Answer, 1)
+			require.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+			require.NotZero(t, db.GetStats()["fault.delays"])
+			require.Zero(t, db.GetStats()["fault.errors"])
+			// walks over the whole DB are not faulted
+			_, err = db.AuditOwnerNames()
+			require.NoError(t, err)
+
+			// faults are still injected after reloading
+			dbi, err := db.dbi.Reload(config.Path)
+			require.NoError(t, err)
+			require.IsType(t, &faultInjectingDBI{}, dbi)
+			if dbi != db.dbi {
+				dbi.Close()
+			}
+			db.Destroy()
+
+			_, err = OpenWithOptions(config.Path, config.Driver, Options{Faults: FaultConfig{MissRate: 2}})
+			require.Error(t, err)
+		})
+	}
+}
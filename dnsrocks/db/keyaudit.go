@@ -0,0 +1,207 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// OwnerNameConflict lists the spellings of an owner name found in the
+// resource record keys of a DB, which only differ in case or trailing dots.
+// Lookups only ever use the lower case spelling, so the records of the
+// others are shadowed.
+type OwnerNameConflict struct {
+	// Name is the normalized owner name: lower case, without trailing dots
+	Name string `json:"name"`
+	// Variants are the spellings found, escaped like in zone files, sorted
+	Variants []string `json:"variants"`
+}
+
+// AuditOwnerNames returns the owner names of resource record keys which are
+// spelled in more than one way, sorted by name. The compilers normalize owner
+// names, so such keys only come from DBs built by buggy or legacy pipelines.
+func (f *DB) AuditOwnerNames() ([]OwnerNameConflict, error) {
+	spellings := make(map[string]map[string]struct{})
+	err := f.forEachResourceRecord(".", false, func(e resourceRecordEntry) error {
+		name := normalizeOwnerName(e.labels)
+		if spellings[name] == nil {
+			spellings[name] = make(map[string]struct{})
+		}
+		spellings[name][presentOwnerName(e.labels, e.trailingRoots)] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := []OwnerNameConflict{}
+	for name, variants := range spellings {
+		if len(variants) < 2 {
+			continue
+		}
+		c := OwnerNameConflict{Name: name}
+		for v := range variants {
+			c.Variants = append(c.Variants, v)
+		}
+		sort.Strings(c.Variants)
+		conflicts = append(conflicts, c)
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Name < conflicts[j].Name })
+	return conflicts, nil
+}
+
+// AuditOwnerNames is DB.AuditOwnerNames on the DB of the reader, which the
+// reader keeps open, whatever reloads happen meanwhile
+func (r *DataReader) AuditOwnerNames() ([]OwnerNameConflict, error) {
+	return r.db.AuditOwnerNames()
+}
+
+// parseLabels splits the packed domain at the start of b into its labels,
+// and returns the bytes following its terminating zero.
+func parseLabels(b []byte) (labels [][]byte, rest []byte, ok bool) {
+	for len(b) > 0 {
+		n := int(b[0])
+		if n == 0 {
+			return labels, b[1:], true
+		}
+		if n > 63 || len(b) < n+1 {
+			return nil, nil, false
+		}
+		labels = append(labels, b[1:n+1])
+		b = b[n+1:]
+	}
+	return nil, nil, false
+}
+
+// skipLocation returns the bytes following the location ID at the start of b
+func skipLocation(b []byte) ([]byte, bool) {
+	if len(b) < 2 {
+		return nil, false
+	}
+	if b[0] != 0xff {
+		return b[2:], true
+	}
+	n := int(b[1])
+	if len(b) < n+2 {
+		return nil, false
+	}
+	return b[n+2:], true
+}
+
+// isSpecialKey returns true if key is one of the special keys sharing the
+// marker of v2 resource record keys
+func isSpecialKey(key []byte) bool {
+	for _, special := range []string{dnsdata.FeaturesKey, dnsdata.ChecksumKey, dnsdata.ProvenanceKey, dnsdata.V1MapKeysKey} {
+		if string(key) == special {
+			return true
+		}
+	}
+	return false
+}
+
+// parseV1ResourceRecordKey parses a location ID followed by a packed owner
+// name. Zero bytes after the name are counted as trailing root labels, any
+// other bytes mean the key is not a resource record one (e.g. map keys end
+// with '=' or '*').
+func parseV1ResourceRecordKey(key []byte) (labels [][]byte, trailingRoots int, ok bool) {
+	for _, prefix := range []string{dnsdata.RangePointKeyMarker, dnsdata.MetadataKeyMarker, dnsdata.LocationFallbackKeyMarker, dnsdata.FeaturesKey, dnsdata.ChecksumKey, dnsdata.ProvenanceKey, dnsdata.V1MapKeysKey} {
+		if bytes.HasPrefix(key, []byte(prefix)) {
+			return nil, 0, false
+		}
+	}
+	if bytes.HasPrefix(key, ipMapKeyElement) {
+		return nil, 0, false
+	}
+	b, ok := skipLocation(key)
+	if !ok || len(b) == 0 {
+		return nil, 0, false
+	}
+	labels, rest, ok := parseLabels(b)
+	if !ok {
+		return nil, 0, false
+	}
+	for _, c := range rest {
+		if c != 0 {
+			return nil, 0, false
+		}
+	}
+	return labels, len(rest), true
+}
+
+// parseV2ResourceRecordKey parses the marker, the packed owner name with its
+// labels in reverse order, and the location ID. A zero byte in front of the
+// location ID is counted as a trailing root label.
+func parseV2ResourceRecordKey(key []byte) (labels [][]byte, trailingRoots int, ok bool) {
+	if !bytes.HasPrefix(key, []byte(dnsdata.ResourceRecordsKeyMarker)) || isSpecialKey(key) {
+		return nil, 0, false
+	}
+	reversed, rest, ok := parseLabels(key[len(dnsdata.ResourceRecordsKeyMarker):])
+	if !ok {
+		return nil, 0, false
+	}
+	for len(rest) > 0 {
+		after, ok := skipLocation(rest)
+		if ok && len(after) == 0 {
+			break
+		}
+		if rest[0] != 0 {
+			return nil, 0, false
+		}
+		trailingRoots++
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		return nil, 0, false
+	}
+	for i := len(reversed) - 1; i >= 0; i-- {
+		labels = append(labels, reversed[i])
+	}
+	return labels, trailingRoots, true
+}
+
+// normalizeOwnerName returns the name the way the compilers store it: lower
+// case, without empty labels nor dots at the end of labels.
+func normalizeOwnerName(labels [][]byte) string {
+	var parts []string
+	for _, l := range labels {
+		l = bytes.TrimRight(bytes.ToLower(l), ".")
+		if len(l) > 0 {
+			parts = append(parts, string(l))
+		}
+	}
+	return dns.Fqdn(strings.Join(parts, "."))
+}
+
+// presentOwnerName returns the name as stored, with each trailing root label
+// shown as an extra dot
+func presentOwnerName(labels [][]byte, trailingRoots int) string {
+	packed := make([]byte, 0, 256)
+	for _, l := range labels {
+		packed = append(packed, byte(len(l)))
+		packed = append(packed, l...)
+	}
+	packed = append(packed, 0)
+	name, _, err := dns.UnpackDomainName(packed, 0)
+	if err != nil {
+		name = fmt.Sprintf("%q", packed)
+	}
+	return name + strings.Repeat(".", trailingRoots)
+}
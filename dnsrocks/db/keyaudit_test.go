@@ -0,0 +1,105 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+// keyListDBI serves a fixed list of keys
+type keyListDBI struct {
+	DBI
+	keys [][]byte
+	v2   bool
+}
+
+func (k *keyListDBI) forEachRecord(_ []byte, _ bool, f func(key, value []byte) error) error {
+	for _, key := range k.keys {
+		if err := f(key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindClosestKey is only there for keyListDBI to tell v2 keys apart, as
+// drivers with sorted keys do
+func (k *keyListDBI) FindClosestKey(_ []byte, _ Context) ([]byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (k *keyListDBI) ClosestKeyFinder() ClosestKeyFinder {
+	if k.v2 {
+		return k
+	}
+	return nil
+}
+
+func TestAuditOwnerNamesV1(t *testing.T) {
+	keys := [][]byte{
+		[]byte("\000\000\003www\007example\003com\000"),
+		[]byte("\000\001\003WWW\007Example\003com\000"),
+		[]byte("\000\000\003www\007example\003com\000\000"),
+		[]byte("\000\000\003foo\007example\003com\000"),
+		[]byte("\377\003abc\003foo\007example\003com\000"),
+		[]byte("\000\000\003Bar\007example\003com\000"),
+		// map key with the same name
+		[]byte("\000\000\003bar\007example\003com\000="),
+		[]byte(dnsdata.FeaturesKey),
+		[]byte(dnsdata.RangePointKeyMarker + "\000\000"),
+		[]byte("\000/"),
+	}
+	d := &DB{dbi: &keyListDBI{keys: keys}}
+	conflicts, err := d.AuditOwnerNames()
+	require.NoError(t, err)
+	require.Equal(t, []OwnerNameConflict{
+		{Name: "www.example.com.", Variants: []string{"WWW.Example.com.", "www.example.com.", "www.example.com.."}},
+	}, conflicts)
+}
+
+func TestAuditOwnerNamesV2(t *testing.T) {
+	keys := [][]byte{
+		[]byte(dnsdata.ResourceRecordsKeyMarker + "\003com\007example\003www\000\000\000"),
+		[]byte(dnsdata.ResourceRecordsKeyMarker + "\003com\007example\003wWw\000\000\001"),
+		[]byte(dnsdata.ResourceRecordsKeyMarker + "\003com\007example\003www\000\000\377\002ab"),
+		[]byte(dnsdata.ResourceRecordsKeyMarker + "\003com\007example\003www\000\000\000\000"),
+		[]byte(dnsdata.ResourceRecordsKeyMarker + "\003com\007example\003foo\000\000\000"),
+		[]byte(dnsdata.FeaturesKey),
+	}
+	d := &DB{dbi: &keyListDBI{keys: keys, v2: true}}
+	conflicts, err := d.AuditOwnerNames()
+	require.NoError(t, err)
+	require.Equal(t, []OwnerNameConflict{
+		{Name: "www.example.com.", Variants: []string{"wWw.example.com.", "www.example.com.", "www.example.com.."}},
+	}, conflicts)
+}
+
+func TestAuditOwnerNamesTestDBs(t *testing.T) {
+	for _, config := range testaid.TestDBs {
+		t.Run(config.Driver+"/"+config.Flavour, func(t *testing.T) {
+			d, err := Open(config.Path, config.Driver)
+			require.NoError(t, err)
+			defer d.Destroy()
+			conflicts, err := d.AuditOwnerNames()
+			require.NoError(t, err)
+			require.Empty(t, conflicts)
+		})
+	}
+}
@@ -15,11 +15,16 @@ package db
 
 import (
 	"bytes"
+	"fmt"
+	"math"
 	"net"
-	"os"
+	"slices"
 
 	"github.com/golang/glog"
 	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/quote"
 )
 
 // Define some constants
@@ -42,11 +47,7 @@ var exactMatchKeyElement = []byte{'='}
 
 var cachedCIDRMask [129]net.IPMask
 
-var SeparateBitMap bool
-
 func init() {
-	SeparateBitMap = os.Getenv("FBDNS_SEPARATE_MASKLENS") != ""
-
 	for i := 0; i < len(cachedCIDRMask); i++ {
 		cachedCIDRMask[i] = net.CIDRMask(i, 128)
 	}
@@ -78,6 +79,30 @@ func (id ID) Contents() []byte {
 	return id[2:]
 }
 
+// ParseID parses an ID written the way the data format does, e.g. `ec` or
+// `\000\001`, into the ID stored in the DB, with a long-ID header if longer
+// than 2 bytes.
+func ParseID(text string) (ID, error) {
+	b, err := quote.Bunquote([]byte(text))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID %q: %w", text, err)
+	}
+	switch {
+	case len(b) < 2:
+		return nil, fmt.Errorf("invalid ID %q: shorter than 2 bytes", text)
+	case len(b) == 2:
+		return ID(b), nil
+	case len(b) > math.MaxUint8:
+		return nil, fmt.Errorf("invalid ID %q: longer than %d bytes", text, math.MaxUint8)
+	}
+	return ID(append([]byte{0xff, byte(len(b))}, b...)), nil
+}
+
+// MapNamespace replaces the maps names are assigned to by other maps of the
+// DB, by map ID, so that the same records are served with another mapping of
+// clients to locations
+type MapNamespace map[string]ID
+
 // Location is a native representation of a DNS location representation.
 // It holds:
 // the MapID it belongs to
@@ -87,6 +112,8 @@ type Location struct {
 	MapID ID    // The map in which we found the name.
 	Mask  uint8 // The subnet mask we found a match for. Used for ECS.
 	LocID ID    // The location ID.
+	// The location ID found in the map, when LocID is one of its fallbacks.
+	FallbackFrom ID
 }
 
 // FindECS finds a EDNS0_SUBNET option in a DNS Msg.
@@ -146,17 +173,20 @@ func (r *DataReader) findLocation(q []byte, mtype []byte, ipnet *net.IPNet) (*Lo
 	// Starting from a domain = q, first we try to get an exact match
 	// then, we remove 1 label at a time and try to find a wildcard match.
 	// If there is a map found, overwrites mapID.
-	mapID, err := r.db.dbi.FindMap(q, mtype, r.context)
+	mapID, err := r.dbi.FindMap(q, mtype, r.context)
 	if err != nil {
 		return nil, err
 	}
 	if mapID != nil {
+		if replacement, ok := r.mapNamespace[string(mapID)]; ok {
+			mapID = replacement
+		}
 		location.MapID = make([]byte, len(mapID))
 		copy(location.MapID, mapID)
 	}
 
 	// Find the location id
-	locID, mask, err := r.db.dbi.GetLocationByMap(ipnet, location.MapID, r.context)
+	locID, mask, err := r.dbi.GetLocationByMap(ipnet, location.MapID, r.context)
 	if err != nil {
 		return nil, err
 	}
@@ -164,10 +194,72 @@ func (r *DataReader) findLocation(q []byte, mtype []byte, ipnet *net.IPNet) (*Lo
 		location.LocID = make([]byte, len(locID))
 		copy(location.LocID, locID)
 		location.Mask = mask
+		if err := r.fallBack(q, &location); err != nil {
+			return nil, err
+		}
 	}
 	return &location, nil
 }
 
+// fallBack replaces the location ID of location by the first of its fallback
+// locations with records for q, if it has none of its own. Otherwise, the
+// records of the location and of the default one are served as usual.
+func (r *DataReader) fallBack(q []byte, location *Location) error {
+	if location.LocID.IsZero() {
+		return nil
+	}
+	var fallbacks []byte
+	key := append([]byte(dnsdata.LocationFallbackKeyMarker), location.LocID...)
+	err := r.ForEach(key, func(value []byte) error {
+		if fallbacks == nil {
+			fallbacks = slices.Clone(value)
+		}
+		return nil
+	})
+	if err != nil || fallbacks == nil {
+		return err
+	}
+	found, err := r.hasRecords(q, location.LocID)
+	if err != nil || found {
+		return err
+	}
+	for len(fallbacks) > 0 {
+		rest, ok := skipLocation(fallbacks)
+		if !ok {
+			return fmt.Errorf("bad fallbacks of location %v", location.LocID)
+		}
+		locID := ID(fallbacks[:len(fallbacks)-len(rest)])
+		if found, err = r.hasRecords(q, locID); err != nil {
+			return err
+		}
+		if found {
+			location.FallbackFrom = location.LocID
+			location.LocID = locID
+			return nil
+		}
+		fallbacks = rest
+	}
+	return nil
+}
+
+// hasRecords returns true if the name q has records of its own in the
+// location locID, wildcards excepted
+func (r *DataReader) hasRecords(q []byte, locID ID) (bool, error) {
+	var key []byte
+	if r.dbi.ClosestKeyFinder() != nil {
+		key = append([]byte(dnsdata.ResourceRecordsKeyMarker), reverseZoneName(q)...)
+		key = append(key, locID...)
+	} else {
+		key = append(slices.Clone(locID), q...)
+	}
+	found := false
+	err := r.ForEach(key, func([]byte) error {
+		found = true
+		return nil
+	})
+	return found, err
+}
+
 // ResolverLocation find the location associated with a client IP (resolver)
 func (r *DataReader) ResolverLocation(q []byte, ip string) (*Location, error) {
 	resolverIP := net.ParseIP(ip)
@@ -224,3 +316,11 @@ func (r *DataReader) EcsLocation(q []byte, ecs *dns.EDNS0_SUBNET) (*Location, er
 
 	return loc, nil
 }
+
+var (
+	firstIPv4 = net.ParseIP("0000:0000:0000:0000:0000:ffff:0000:0000") // the very first IPv4 address according to RFC-2765
+)
+
+func isIPv4(addr net.IP) bool {
+	return addr != nil && (len(addr) == net.IPv4len || net.IP.Equal(addr[:12], firstIPv4[:12]))
+}
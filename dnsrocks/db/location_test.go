@@ -17,11 +17,13 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
 
+	"github.com/facebook/dns/dnsrocks/dnsdata/cdb"
 	"github.com/facebook/dns/dnsrocks/testaid"
 )
 
@@ -95,29 +97,32 @@ func BenchmarkResolverLocation(b *testing.B) {
 		},
 	}
 	for _, config := range testaid.TestDBs {
-		if db, err = Open(config.Path, config.Driver); err != nil {
-			b.Fatalf("Could not open fixture database: %v", err)
-		}
-		r, err := NewReader(db)
-		if err != nil {
-			b.Fatalf("Could not open db file: %v", err)
-		}
-		offset, _ := dns.PackDomainName(qname, packedQName, 0, nil, false)
-		_separateBitMap := SeparateBitMap
-		for _, s := range []bool{true, false} {
-			SeparateBitMap = s
-			for _, bm := range benchmarks {
-				b.Run(fmt.Sprintf("%s/%s SeparateBitMap %v", config.Driver, bm.name, s), func(b *testing.B) {
-					for n := 0; n < b.N; n++ {
-						_, err := r.ResolverLocation(packedQName[:offset], bm.ip)
-						if err != nil {
-							b.Fatalf("%v", err)
+		for _, o := range benchmarkOpeners {
+			offset, _ := dns.PackDomainName(qname, packedQName, 0, nil, false)
+			for _, s := range []bool{true, false} {
+				opts := o.opts
+				opts.SeparateBitMap = s
+				if db, err = OpenWithOptions(config.Path, config.Driver, opts); err != nil {
+					b.Fatalf("Could not open fixture database: %v", err)
+				}
+				r, err := NewReader(db)
+				if err != nil {
+					b.Fatalf("Could not open db file: %v", err)
+				}
+				for _, bm := range benchmarks {
+					b.Run(fmt.Sprintf("%s/%s/%s SeparateBitMap %v", config.Driver, o.name, bm.name, s), func(b *testing.B) {
+						for n := 0; n < b.N; n++ {
+							_, err := r.ResolverLocation(packedQName[:offset], bm.ip)
+							if err != nil {
+								b.Fatalf("%v", err)
+							}
 						}
-					}
-				})
+					})
+				}
+				r.Close()
+				db.Destroy()
 			}
 		}
-		SeparateBitMap = _separateBitMap
 	}
 }
 
@@ -190,30 +195,33 @@ func BenchmarkECSLocation(b *testing.B) {
 	}
 
 	for _, config := range testaid.TestDBs {
-		if db, err = Open(config.Path, config.Driver); err != nil {
-			b.Fatalf("Could not open fixture database: %v", err)
-		}
-		r, err := NewReader(db)
-		if err != nil {
-			b.Fatalf("Could not open db file: %v", err)
-		}
-		offset, _ := dns.PackDomainName(qname, packedQName, 0, nil, false)
-		_separateBitMap := SeparateBitMap
-		for _, s := range []bool{true, false} {
-			SeparateBitMap = s
-			for _, bm := range benchmarks {
-				b.Run(fmt.Sprintf("%s/%s SeparateBitMap %v", config.Driver, bm.name, s), func(b *testing.B) {
-					edns, _ := MakeOPTWithECS(bm.subnet)
-					for n := 0; n < b.N; n++ {
-						_, err := r.EcsLocation(packedQName[:offset], edns.Option[0].(*dns.EDNS0_SUBNET))
-						if err != nil {
-							b.Fatalf("%v", err)
+		for _, o := range benchmarkOpeners {
+			offset, _ := dns.PackDomainName(qname, packedQName, 0, nil, false)
+			for _, s := range []bool{true, false} {
+				opts := o.opts
+				opts.SeparateBitMap = s
+				if db, err = OpenWithOptions(config.Path, config.Driver, opts); err != nil {
+					b.Fatalf("Could not open fixture database: %v", err)
+				}
+				r, err := NewReader(db)
+				if err != nil {
+					b.Fatalf("Could not open db file: %v", err)
+				}
+				for _, bm := range benchmarks {
+					b.Run(fmt.Sprintf("%s/%s/%s SeparateBitMap %v", config.Driver, o.name, bm.name, s), func(b *testing.B) {
+						edns, _ := MakeOPTWithECS(bm.subnet)
+						for n := 0; n < b.N; n++ {
+							_, err := r.EcsLocation(packedQName[:offset], edns.Option[0].(*dns.EDNS0_SUBNET))
+							if err != nil {
+								b.Fatalf("%v", err)
+							}
 						}
-					}
-				})
+					})
+				}
+				r.Close()
+				db.Destroy()
 			}
 		}
-		SeparateBitMap = _separateBitMap
 	}
 }
 
@@ -222,8 +230,6 @@ func testDBFindLocationCustomBitmap(t *testing.T, separateBitmap bool) {
 	var err error
 	var q = make([]byte, 255)
 
-	SeparateBitMap = separateBitmap
-
 	testCases := []struct {
 		desc             string
 		qname            string
@@ -523,7 +529,7 @@ func testDBFindLocationCustomBitmap(t *testing.T, separateBitmap bool) {
 		},
 	}
 	for _, dbconfig := range testaid.TestDBs {
-		if db, err = Open(dbconfig.Path, dbconfig.Driver); err != nil {
+		if db, err = OpenWithOptions(dbconfig.Path, dbconfig.Driver, Options{SeparateBitMap: separateBitmap}); err != nil {
 			t.Fatalf("Could not open fixture database: %v", err)
 		}
 		r, err := NewReader(db)
@@ -564,11 +570,6 @@ func testDBFindLocationCustomBitmap(t *testing.T, separateBitmap bool) {
 
 // TestDBFindLocation checks locations
 func TestDBFindLocation(t *testing.T) {
-	_separateBitMap := SeparateBitMap
-	defer func() {
-		SeparateBitMap = _separateBitMap
-	}()
-
 	t.Run("TestDBFindLocationCustomBitmap !SeparateBitMap", func(t *testing.T) {
 		testDBFindLocationCustomBitmap(t, false)
 	})
@@ -582,7 +583,6 @@ func testFindLocationForResolversCustomBitmap(t *testing.T, separateBitmap bool)
 	var db *DB
 	var packedQName = make([]byte, 255)
 	var err error
-	SeparateBitMap = separateBitmap
 
 	testCases := []struct {
 		domain           string
@@ -632,7 +632,7 @@ func testFindLocationForResolversCustomBitmap(t *testing.T, separateBitmap bool)
 	}
 
 	for _, config := range testaid.TestDBs {
-		if db, err = Open(config.Path, config.Driver); err != nil {
+		if db, err = OpenWithOptions(config.Path, config.Driver, Options{SeparateBitMap: separateBitmap}); err != nil {
 			t.Fatalf("Could not open fixture database: %v", err)
 		}
 		r, err := NewReader(db)
@@ -669,10 +669,6 @@ func testFindLocationForResolversCustomBitmap(t *testing.T, separateBitmap bool)
 
 // TestFindLocationForResolvers checks locations
 func TestFindLocationForResolvers(t *testing.T) {
-	_separateBitMap := SeparateBitMap
-	defer func() {
-		SeparateBitMap = _separateBitMap
-	}()
 	t.Run("TestFindLocationForResolversCustomBitmap !SeparateBitMap", func(t *testing.T) {
 		testFindLocationForResolversCustomBitmap(t, false)
 	})
@@ -686,7 +682,6 @@ func testDBEcsLocationCustomBitmap(t *testing.T, separateBitMap bool) {
 	var db *DB
 	var packedQName = make([]byte, 255)
 	var err error
-	SeparateBitMap = separateBitMap
 
 	testCases := []struct {
 		domain string
@@ -727,7 +722,7 @@ func testDBEcsLocationCustomBitmap(t *testing.T, separateBitMap bool) {
 	}
 
 	for _, config := range testaid.TestDBs {
-		if db, err = Open(config.Path, config.Driver); err != nil {
+		if db, err = OpenWithOptions(config.Path, config.Driver, Options{SeparateBitMap: separateBitMap}); err != nil {
 			t.Fatalf("Could not open fixture database: %v", err)
 		}
 		r, err := NewReader(db)
@@ -751,11 +746,6 @@ func testDBEcsLocationCustomBitmap(t *testing.T, separateBitMap bool) {
 
 // TestFindLocationForResolvers checks locations
 func TestDBEcsLocation(t *testing.T) {
-	_separateBitMap := SeparateBitMap
-	defer func() {
-		SeparateBitMap = _separateBitMap
-	}()
-
 	t.Run("TestDBEcsLocationCustomBitmap !SeparateBitMap", func(t *testing.T) {
 		testDBEcsLocationCustomBitmap(t, false)
 	})
@@ -769,7 +759,6 @@ func testDBCorrectEcsAnswerCustomBitmap(t *testing.T, separateBitMap bool) {
 	var db *DB
 	var packedQName = make([]byte, 255)
 	var err error
-	SeparateBitMap = separateBitMap
 
 	testCases := []struct {
 		ecs         string
@@ -810,7 +799,7 @@ func testDBCorrectEcsAnswerCustomBitmap(t *testing.T, separateBitMap bool) {
 	}
 
 	for _, config := range testaid.TestDBs {
-		if db, err = Open(config.Path, config.Driver); err != nil {
+		if db, err = OpenWithOptions(config.Path, config.Driver, Options{SeparateBitMap: separateBitMap}); err != nil {
 			t.Fatalf("Could not open fixture database: %v", err)
 		}
 		r, err := NewReader(db)
@@ -834,11 +823,6 @@ func testDBCorrectEcsAnswerCustomBitmap(t *testing.T, separateBitMap bool) {
 
 // TestDBCorrectEcsAnswer checks locations
 func TestDBCorrectEcsAnswer(t *testing.T) {
-	_separateBitMap := SeparateBitMap
-	defer func() {
-		SeparateBitMap = _separateBitMap
-	}()
-
 	t.Run("TestDBCorrectEcsAnswerCustomBitmap !SeparateBitMap", func(t *testing.T) {
 		testDBCorrectEcsAnswerCustomBitmap(t, false)
 	})
@@ -938,3 +922,128 @@ func TestDBECSResolverFindMap(t *testing.T) {
 		}
 	}
 }
+
+// fallbackTestData has a location \000\003 falling back to \000\002 then
+// \000\004, where resolver 1.1.1.1 is mapped
+const fallbackTestData = `Zexample.com,ns.example.com,dns.example.com,,,,,,
+M*.example.com,m1
+%\000\003,1.1.1.0/24,m1
+L\000\003,\000\002,\000\004
++a.example.com,192.0.2.3,,,\000\003
++a.example.com,192.0.2.2,,,\000\002
++b.example.com,192.0.2.2,,,\000\002
++b.example.com,192.0.2.4,,,\000\004
++c.example.com,192.0.2.4,,,\000\004
++d.example.com,192.0.2.1
+`
+
+// requireLocationFallback checks the locations and answers of the names of
+// fallbackTestData compiled in db
+func requireLocationFallback(t *testing.T, db *DB) {
+	r, err := NewReader(db)
+	require.NoError(t, err)
+	defer r.Close()
+	testCases := []struct {
+		name         string
+		locID        ID
+		fallbackFrom ID
+		answer       string
+	}{
+		{name: "a.example.com.", locID: ID{0, 3}, answer: "192.0.2.3"},
+		{name: "b.example.com.", locID: ID{0, 2}, fallbackFrom: ID{0, 3}, answer: "192.0.2.2"},
+		{name: "c.example.com.", locID: ID{0, 4}, fallbackFrom: ID{0, 3}, answer: "192.0.2.4"},
+		{name: "d.example.com.", locID: ID{0, 3}, answer: "192.0.2.1"},
+	}
+	for _, tc := range testCases {
+		q := make([]byte, 255)
+		offset, err := dns.PackDomainName(tc.name, q, 0, nil, false)
+		require.NoError(t, err)
+		loc, err := r.FindLocation(q[:offset], nil, "1.1.1.1")
+		require.NoError(t, err)
+		require.Equal(t, tc.locID, loc.LocID, tc.name)
+		require.Equal(t, tc.fallbackFrom, loc.FallbackFrom, tc.name)
+
+		_, _, zoneCut, err := r.IsAuthoritative(q[:offset], loc.LocID)
+		require.NoError(t, err)
+		a := new(dns.Msg)
+		r.FindAnswer(q[:offset], zoneCut, tc.name, dns.TypeA, loc.LocID, a, 1)
+		require.Len(t, a.Answer, 1, tc.name)
+		require.Equal(t, tc.answer, a.Answer[0].(*dns.A).A.String(), tc.name)
+	}
+}
+
+func TestLocationFallbackCDB(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	require.NoError(t, os.WriteFile(data, []byte(fallbackTestData), 0o644))
+	path := filepath.Join(dir, "data.cdb")
+	_, err := cdb.CreateCDB(data, path, &cdb.CreatorOptions{NumCPU: 1})
+	require.NoError(t, err)
+	db, err := Open(path, "cdb")
+	require.NoError(t, err)
+	defer db.Destroy()
+	requireLocationFallback(t, db)
+}
+
+func TestParseID(t *testing.T) {
+	for text, expected := range map[string]ID{
+		"ec":         ID("ec"),
+		`\000\001`:   ID{0, 1},
+		"corp":       ID("\xff\x04corp"),
+		`c\000`:      ID{'c', 0},
+		`long\072id`: ID("\xff\x07long:id"),
+	} {
+		id, err := ParseID(text)
+		require.NoError(t, err, text)
+		require.Equal(t, expected, id, text)
+	}
+	for _, text := range []string{"", "e", `\0`} {
+		_, err := ParseID(text)
+		require.Error(t, err, text)
+	}
+}
+
+func TestMapNamespace(t *testing.T) {
+	testDBs := testaid.NewFixture().
+		Zone("example.com", 300, "ns.example.com").
+		ResolverMap("www.example.com", "pr").
+		Subnet("pr", "192.0.2.0/24", testaid.Loc(1)).
+		Subnet("corp", "192.0.2.0/24", testaid.Loc(2)).
+		Address("www.example.com", "198.51.100.1", 300, testaid.Loc(1)).
+		Address("www.example.com", "198.51.100.2", 300, testaid.Loc(2)).
+		Build(t)
+
+	corp, err := ParseID("corp")
+	require.NoError(t, err)
+	packed := make([]byte, 255)
+	offset, err := dns.PackDomainName("www.example.com.", packed, 0, nil, false)
+	require.NoError(t, err)
+
+	for _, config := range testDBs {
+		t.Run(config.Driver+"/"+config.Flavour, func(t *testing.T) {
+			db, err := Open(config.Path, config.Driver)
+			require.NoError(t, err)
+			defer db.Destroy()
+
+			for _, tc := range []struct {
+				ns    MapNamespace
+				mapID ID
+				locID ID
+			}{
+				{ns: nil, mapID: ID("pr"), locID: ID{0, 1}},
+				{ns: MapNamespace{"pr": corp}, mapID: corp, locID: ID{0, 2}},
+				// maps missing from the namespace are used as is
+				{ns: MapNamespace{"ec": corp}, mapID: ID("pr"), locID: ID{0, 1}},
+			} {
+				r, err := NewReader(db)
+				require.NoError(t, err)
+				r.SetMapNamespace(tc.ns)
+				loc, err := r.FindLocation(packed[:offset], nil, "192.0.2.1")
+				r.Close()
+				require.NoError(t, err)
+				require.Equal(t, tc.mapID, loc.MapID)
+				require.Equal(t, tc.locID, loc.LocID)
+			}
+		})
+	}
+}
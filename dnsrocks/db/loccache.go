@@ -0,0 +1,113 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"net"
+	"sync/atomic"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// locationCacheEntry is the cached outcome of a GetLocationByMap lookup,
+// including misses.
+type locationCacheEntry struct {
+	loc  []byte
+	mlen uint8
+}
+
+// locationCacheDriver wraps a DBI and keeps the results of the most recent
+// location lookups in a bounded LRU, keyed by map ID and subnet. Most queries
+// come from a small set of resolvers, which then skip the search through
+// subnets or range points.
+type locationCacheDriver struct {
+	DBI
+	size   int
+	cache  *lru.Cache
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func newLocationCacheDriver(dbi DBI, size int) (*locationCacheDriver, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &locationCacheDriver{DBI: dbi, size: size, cache: cache}, nil
+}
+
+// locationCacheKey returns the cache key of a lookup: the map ID followed
+// by the IPv6 address and mask length of the subnet.
+func locationCacheKey(ipnet *net.IPNet, mapID []byte) string {
+	key := make([]byte, len(mapID)+net.IPv6len+1)
+	copy(key, mapID)
+	copy(key[len(mapID):], ipnet.IP.To16())
+	ones, _ := ipnet.Mask.Size()
+	if isIPv4(ipnet.IP) {
+		ones += 128 - 32
+	}
+	key[len(key)-1] = uint8(ones) //nolint:gosec
+	return string(key)
+}
+
+// GetLocationByMap finds and returns location and mask, from the cache if
+// the same lookup was done recently. The returned location must not be
+// modified.
+func (d *locationCacheDriver) GetLocationByMap(ipnet *net.IPNet, mapID []byte, context Context) ([]byte, uint8, error) {
+	key := locationCacheKey(ipnet, mapID)
+	if v, ok := d.cache.Get(key); ok {
+		d.hits.Add(1)
+		entry := v.(locationCacheEntry)
+		return entry.loc, entry.mlen, nil
+	}
+	d.misses.Add(1)
+	loc, mlen, err := d.DBI.GetLocationByMap(ipnet, mapID, context)
+	if err != nil {
+		// errors are not cached, the next lookup will retry
+		return nil, 0, err
+	}
+	// the location may point to a buffer owned by the context
+	loc = bytes.Clone(loc)
+	d.cache.Add(key, locationCacheEntry{loc: loc, mlen: mlen})
+	return loc, mlen, nil
+}
+
+// Reload reloads the wrapped DBI, with an empty cache.
+func (d *locationCacheDriver) Reload(path string) (DBI, error) {
+	newDBI, err := d.DBI.Reload(path)
+	if err != nil {
+		return nil, err
+	}
+	if newDBI == d.DBI {
+		// data was updated in place (e.g. RocksDB catching up with primary)
+		d.cache.Purge()
+		return d, nil
+	}
+	newDriver, err := newLocationCacheDriver(newDBI, d.size)
+	if err != nil {
+		newDBI.Close()
+		return nil, err
+	}
+	return newDriver, nil
+}
+
+// GetStats reports DB backend stats, along with the cache hits and misses
+func (d *locationCacheDriver) GetStats() map[string]int64 {
+	stats := d.DBI.GetStats()
+	stats["location_cache.entries"] = int64(d.cache.Len())
+	stats["location_cache.hits"] = d.hits.Load()
+	stats["location_cache.misses"] = d.misses.Load()
+	return stats
+}
@@ -0,0 +1,109 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+// failingLocationDBI fails location lookups while fail is set
+type failingLocationDBI struct {
+	DBI
+	fail bool
+}
+
+func (f *failingLocationDBI) GetLocationByMap(ipnet *net.IPNet, mapID []byte, context Context) ([]byte, uint8, error) {
+	if f.fail {
+		return nil, 0, ErrInjectedFault
+	}
+	return f.DBI.GetLocationByMap(ipnet, mapID, context)
+}
+
+func TestLocationCacheKey(t *testing.T) {
+	ipnet := &net.IPNet{IP: net.ParseIP("1.1.1.1"), Mask: net.CIDRMask(24, 32)}
+	key := locationCacheKey(ipnet, []byte{'c', 0})
+	require.Equal(t, "c\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xff\xff\x01\x01\x01\x01\x78", key)
+	require.NotEqual(t, key, locationCacheKey(ipnet, []byte{'e', 'c'}))
+	ipnet.Mask = net.CIDRMask(32, 32)
+	require.NotEqual(t, key, locationCacheKey(ipnet, []byte{'c', 0}))
+}
+
+func TestLocationCache(t *testing.T) {
+	for _, config := range testaid.TestDBs {
+		t.Run(config.Driver+"/"+config.Flavour, func(t *testing.T) {
+			d, err := OpenWithOptions(config.Path, config.Driver, Options{LocationCacheSize: 2})
+			require.NoError(t, err)
+			defer d.Destroy()
+			cached, ok := d.dbi.(*locationCacheDriver)
+			require.True(t, ok)
+			failing := &failingLocationDBI{DBI: cached.DBI}
+			cached.DBI = failing
+
+			ctx := d.dbi.NewContext()
+			defer d.dbi.FreeContext(ctx)
+			found := &net.IPNet{IP: net.ParseIP("2.2.2.5"), Mask: net.CIDRMask(32, 32)}
+			notFound := &net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(128, 128)}
+			for range 2 {
+				loc, mlen, err := d.dbi.GetLocationByMap(found, []byte{'c', 0}, ctx)
+				require.NoError(t, err)
+				require.Equal(t, []byte{0, 3}, loc)
+				require.Equal(t, uint8(120), mlen)
+				loc, _, err = d.dbi.GetLocationByMap(notFound, []byte{'n', 'o'}, ctx)
+				require.NoError(t, err)
+				require.Nil(t, loc)
+			}
+			stats := d.GetStats()
+			require.Equal(t, int64(2), stats["location_cache.hits"])
+			require.Equal(t, int64(2), stats["location_cache.misses"])
+			require.Equal(t, int64(2), stats["location_cache.entries"])
+
+			// cached lookups are served without the backend, errors are not cached
+			failing.fail = true
+			_, _, err = d.dbi.GetLocationByMap(found, []byte{'c', 0}, ctx)
+			require.NoError(t, err)
+			other := &net.IPNet{IP: net.ParseIP("3.3.3.1"), Mask: net.CIDRMask(32, 32)}
+			_, _, err = d.dbi.GetLocationByMap(other, []byte{'c', 0}, ctx)
+			require.ErrorIs(t, err, ErrInjectedFault)
+			require.Equal(t, int64(2), d.GetStats()["location_cache.entries"])
+		})
+	}
+}
+
+func TestLocationCacheReload(t *testing.T) {
+	for _, config := range testaid.TestDBs {
+		t.Run(config.Driver+"/"+config.Flavour, func(t *testing.T) {
+			d, err := OpenWithOptions(config.Path, config.Driver, Options{LocationCacheSize: 16})
+			require.NoError(t, err)
+			ipnet := &net.IPNet{IP: net.ParseIP("2.2.2.5"), Mask: net.CIDRMask(32, 32)}
+			ctx := d.dbi.NewContext()
+			_, _, err = d.dbi.GetLocationByMap(ipnet, []byte{'c', 0}, ctx)
+			d.dbi.FreeContext(ctx)
+			require.NoError(t, err)
+			require.Equal(t, int64(1), d.GetStats()["location_cache.entries"])
+
+			d, err = d.Reload(config.Path, nil, 10*time.Second)
+			require.NoError(t, err)
+			defer d.Destroy()
+			_, ok := d.dbi.(*locationCacheDriver)
+			require.True(t, ok)
+			require.Equal(t, int64(0), d.GetStats()["location_cache.entries"])
+		})
+	}
+}
@@ -0,0 +1,376 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// locationIndex is an immutable in-memory copy of the subnet to location
+// data of a DB, answering GetLocationByMap without touching the KV store.
+type locationIndex interface {
+	// lookup has the same semantics as DBI.GetLocationByMap
+	lookup(ipnet *net.IPNet, mapID []byte) (loc []byte, mlen uint8, err error)
+	// size returns the number of subnets or range points in the index
+	size() int
+}
+
+// locationIndexer is implemented by drivers which can build a locationIndex
+// from their data.
+type locationIndexer interface {
+	buildLocationIndex() (locationIndex, error)
+}
+
+// indexedLocationDriver wraps a DBI and serves location lookups from a
+// locationIndex built at open and reload time.
+type indexedLocationDriver struct {
+	DBI
+	index atomic.Pointer[locationIndex]
+}
+
+// OpenWithLocationIndex opens the named DB like Open, and additionally loads
+// its subnet to location data in memory. Resolver and ECS location lookups are
+// then served from memory, trading memory and reload time for lookup latency.
+func OpenWithLocationIndex(name string, driver string) (*DB, error) {
+	opts := DefaultOptions()
+	opts.LocationIndex = true
+	return OpenWithOptions(name, driver, opts)
+}
+
+func newIndexedLocationDriver(dbi DBI) (*indexedLocationDriver, error) {
+	d := &indexedLocationDriver{DBI: dbi}
+	if err := d.rebuild(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *indexedLocationDriver) rebuild() error {
+	indexer, ok := d.DBI.(locationIndexer)
+	if !ok {
+		return fmt.Errorf("%T does not support in-memory location index", d.DBI)
+	}
+	start := time.Now()
+	index, err := indexer.buildLocationIndex()
+	if err != nil {
+		return fmt.Errorf("building location index: %w", err)
+	}
+	d.index.Store(&index)
+	glog.Infof("Built location index with %d entries in %v", index.size(), time.Since(start))
+	return nil
+}
+
+// GetLocationByMap finds and returns location and mask from the in-memory index.
+func (d *indexedLocationDriver) GetLocationByMap(ipnet *net.IPNet, mapID []byte, _ Context) ([]byte, uint8, error) {
+	return (*d.index.Load()).lookup(ipnet, mapID)
+}
+
+// Reload reloads the wrapped DBI and rebuilds the index from the new data.
+func (d *indexedLocationDriver) Reload(path string) (DBI, error) {
+	newDBI, err := d.DBI.Reload(path)
+	if err != nil {
+		return nil, err
+	}
+	if newDBI == d.DBI {
+		// data was updated in place (e.g. RocksDB catching up with primary)
+		if err := d.rebuild(); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+	newDriver, err := newIndexedLocationDriver(newDBI)
+	if err != nil {
+		newDBI.Close()
+		return nil, err
+	}
+	return newDriver, nil
+}
+
+// GetStats reports DB backend stats, along with the index size
+func (d *indexedLocationDriver) GetStats() map[string]int64 {
+	stats := d.DBI.GetStats()
+	stats["location_index.entries"] = int64((*d.index.Load()).size())
+	return stats
+}
+
+// splitMapID splits a map ID, short or long, from the beginning of b.
+func splitMapID(b []byte) (mapID []byte, rest []byte, err error) {
+	n := 2
+	if len(b) >= 2 && b[0] == 0xff {
+		n += int(b[1])
+	}
+	if len(b) < n {
+		return nil, nil, fmt.Errorf("short map ID in %v", b)
+	}
+	return b[:n], b[n:], nil
+}
+
+// radixNode is a node of a path-compressed binary trie over IPv6 (or
+// v6-mapped IPv4) prefixes.
+type radixNode struct {
+	ip     [net.IPv6len]byte // prefix bits, zero past plen
+	plen   uint8
+	hasLoc bool
+	loc    []byte
+	child  [2]*radixNode
+}
+
+func ipBit(ip *[net.IPv6len]byte, i uint8) int {
+	return int(ip[i/8]>>(7-i%8)) & 1
+}
+
+// commonPrefixLen returns the number of leading bits a and b have in common,
+// up to limit.
+func commonPrefixLen(a, b *[net.IPv6len]byte, limit uint8) uint8 {
+	var n uint8
+	for i := 0; i < net.IPv6len && n < limit; i++ {
+		x := a[i] ^ b[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		break
+	}
+	if n > limit {
+		return limit
+	}
+	return n
+}
+
+func maskedIP(ip [net.IPv6len]byte, plen uint8) [net.IPv6len]byte {
+	mask := cachedCIDRMask[plen]
+	for i := range ip {
+		ip[i] &= mask[i]
+	}
+	return ip
+}
+
+func (t *radixNode) insert(ip [net.IPv6len]byte, plen uint8, loc []byte) {
+	ip = maskedIP(ip, plen)
+	np := &t
+	for {
+		n := *np
+		if n == nil {
+			*np = &radixNode{ip: ip, plen: plen, hasLoc: true, loc: loc}
+			return
+		}
+		common := commonPrefixLen(&n.ip, &ip, min(n.plen, plen))
+		if common == n.plen {
+			if common == plen {
+				// keep the first value seen, as FindNext would
+				if !n.hasLoc {
+					n.hasLoc, n.loc = true, loc
+				}
+				return
+			}
+			np = &n.child[ipBit(&ip, n.plen)]
+			continue
+		}
+		// split n at the first differing bit
+		m := &radixNode{ip: maskedIP(ip, common), plen: common}
+		m.child[ipBit(&n.ip, common)] = n
+		if common == plen {
+			m.hasLoc, m.loc = true, loc
+		} else {
+			m.child[ipBit(&ip, common)] = &radixNode{ip: ip, plen: plen, hasLoc: true, loc: loc}
+		}
+		*np = m
+		return
+	}
+}
+
+// longestMatch returns the most specific prefix containing ip, no longer than
+// maxMask and whose length is allowed.
+func (t *radixNode) longestMatch(ip *[net.IPv6len]byte, maxMask uint8, allowed *[129]bool) *radixNode {
+	var best *radixNode
+	for n := t; n != nil && n.plen <= maxMask; {
+		if commonPrefixLen(&n.ip, ip, n.plen) != n.plen {
+			break
+		}
+		if n.hasLoc && allowed[n.plen] {
+			best = n
+		}
+		if n.plen == 8*net.IPv6len {
+			break
+		}
+		n = n.child[ipBit(ip, n.plen)]
+	}
+	return best
+}
+
+// prefixIndex indexes "%" subnet records, as stored in CDB.
+type prefixIndex struct {
+	trees   map[string]*radixNode
+	entries int
+	// masks allowed by the "\000/", "\0004" and "\0006" mask bitmaps
+	masks, masksv4, masksv6 *[129]bool
+	// separateBitMap selects masksv4 and masksv6 over masks
+	separateBitMap bool
+}
+
+func (p *prefixIndex) add(key, value []byte) error {
+	mapID, rest, err := splitMapID(key[len(ipMapKeyElement):])
+	if err != nil {
+		return err
+	}
+	if len(rest) != net.IPv6len+1 {
+		// tinydns-compatible short IPv4 key, not used for lookups
+		return nil
+	}
+	if rest[net.IPv6len] > 8*net.IPv6len {
+		return fmt.Errorf("invalid subnet key %v", key)
+	}
+	if len(value) < 2 {
+		return fmt.Errorf("Invalid location length %d, value %v", len(value), value)
+	}
+	loc := bytes.Clone(value)
+	if loc[0] == 0xff {
+		locLen := int(loc[1])
+		if 2+locLen > len(loc) {
+			return fmt.Errorf("invalid location length byte %d > %d", locLen, len(loc))
+		}
+		loc = loc[:2+locLen]
+	}
+	var ip [net.IPv6len]byte
+	copy(ip[:], rest)
+	root := p.trees[string(mapID)]
+	if root == nil {
+		root = &radixNode{}
+		p.trees[string(mapID)] = root
+	}
+	root.insert(ip, rest[net.IPv6len], loc)
+	p.entries++
+	return nil
+}
+
+func maskSet(maskLens []byte) *[129]bool {
+	var s [129]bool
+	for _, m := range maskLens {
+		if int(m) < len(s) {
+			s[m] = true
+		}
+	}
+	return &s
+}
+
+func (p *prefixIndex) lookup(ipnet *net.IPNet, mapID []byte) ([]byte, uint8, error) {
+	ones, _ := ipnet.Mask.Size()
+	maxMask := uint8(ones) //nolint:gosec
+	masks := p.masks
+	if ipnet.IP.To4() != nil {
+		maxMask += 96
+		if p.separateBitMap {
+			masks = p.masksv4
+		}
+	} else if p.separateBitMap {
+		masks = p.masksv6
+	}
+	if masks == nil {
+		return nil, 0, nil
+	}
+	root := p.trees[string(mapID)]
+	if root == nil {
+		return nil, 0, nil
+	}
+	var ip [net.IPv6len]byte
+	copy(ip[:], ipnet.IP.To16())
+	if n := root.longestMatch(&ip, maxMask, masks); n != nil {
+		return n.loc, n.plen, nil
+	}
+	return nil, 0, nil
+}
+
+func (p *prefixIndex) size() int {
+	return p.entries
+}
+
+func (c *cdbdriver) buildLocationIndex() (locationIndex, error) {
+	return buildPrefixIndex(c, c.separateBitMap)
+}
+
+func (m *memdriver) buildLocationIndex() (locationIndex, error) {
+	return buildPrefixIndex(m, m.separateBitMap)
+}
+
+// buildPrefixIndex indexes the "%" subnet records listed by walker, as stored
+// in CDB
+func buildPrefixIndex(walker recordWalker, separateBitMap bool) (locationIndex, error) {
+	p := &prefixIndex{trees: make(map[string]*radixNode), separateBitMap: separateBitMap}
+	err := walker.forEachRecord(nil, false, func(key, value []byte) error {
+		switch {
+		case bytes.HasPrefix(key, ipMapKeyElement):
+			return p.add(key, value)
+		case bytes.Equal(key, maskLensKeyElement):
+			p.masks = maskSet(value)
+		case bytes.Equal(key, maskLensKeyElementv4):
+			p.masksv4 = maskSet(value)
+		case bytes.Equal(key, maskLensKeyElementv6):
+			p.masksv6 = maskSet(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// rangePoint is the start of an address range sharing the same location, as
+// produced by the Rearranger.
+type rangePoint struct {
+	key  [net.IPv6len + 1]byte // IPv6 address followed by mask length
+	loc  []byte
+	mlen uint8
+}
+
+// rangeIndex indexes "!" range point records, as stored in RocksDB.
+type rangeIndex struct {
+	points  map[string][]rangePoint
+	entries int
+}
+
+func (r *rangeIndex) lookup(ipnet *net.IPNet, mapID []byte) ([]byte, uint8, error) {
+	points := r.points[string(mapID)]
+	var key [net.IPv6len + 1]byte
+	copy(key[:], ipnet.IP.To16())
+	reqMaskLen, _ := ipnet.Mask.Size()
+	if isIPv4(ipnet.IP) {
+		reqMaskLen += 128 - 32
+	}
+	key[net.IPv6len] = uint8(reqMaskLen) //nolint:gosec
+
+	// the closest range point preceding or equal to the key
+	i := sort.Search(len(points), func(i int) bool {
+		return bytes.Compare(points[i].key[:], key[:]) > 0
+	}) - 1
+	if i < 0 {
+		return nil, 0, nil
+	}
+	return points[i].loc, points[i].mlen, nil
+}
+
+func (r *rangeIndex) size() int {
+	return r.entries
+}
@@ -0,0 +1,160 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+var benchmarkOpeners = []struct {
+	name string
+	opts Options
+}{
+	{name: "kv", opts: Options{}},
+	{name: "index", opts: Options{LocationIndex: true}},
+	{name: "cache", opts: Options{LocationCacheSize: 1024}},
+}
+
+func TestRadixNodeLongestMatch(t *testing.T) {
+	root := &radixNode{}
+	prefixes := map[string]string{
+		"::/0":            "a",
+		"10.0.0.0/8":      "b",
+		"10.1.0.0/16":     "c",
+		"10.1.2.0/24":     "d",
+		"10.1.2.3/32":     "e",
+		"10.128.0.0/9":    "f",
+		"2001:db8::/32":   "g",
+		"2001:db8:1::/48": "h",
+	}
+	for p, loc := range prefixes {
+		_, ipnet, err := net.ParseCIDR(p)
+		require.NoError(t, err)
+		ones, bits := ipnet.Mask.Size()
+		plen := uint8(ones + 128 - bits)
+		var ip [net.IPv6len]byte
+		copy(ip[:], ipnet.IP.To16())
+		root.insert(ip, plen, []byte(loc))
+	}
+	all := maskSet([]byte{128, 120, 112, 105, 104, 80, 48, 32, 0})
+
+	testCases := []struct {
+		ip       string
+		maxMask  uint8
+		masks    *[129]bool
+		expected string
+	}{
+		{ip: "10.1.2.3", maxMask: 128, masks: all, expected: "e"},
+		{ip: "10.1.2.4", maxMask: 128, masks: all, expected: "d"},
+		{ip: "10.1.3.4", maxMask: 128, masks: all, expected: "c"},
+		{ip: "10.2.3.4", maxMask: 128, masks: all, expected: "b"},
+		{ip: "10.200.0.1", maxMask: 128, masks: all, expected: "f"},
+		{ip: "11.0.0.1", maxMask: 128, masks: all, expected: "a"},
+		{ip: "10.1.2.3", maxMask: 120, masks: all, expected: "d"},
+		{ip: "10.1.2.3", maxMask: 128, masks: maskSet([]byte{112, 0}), expected: "c"},
+		{ip: "10.1.2.3", maxMask: 128, masks: maskSet([]byte{32}), expected: ""},
+		{ip: "2001:db8:1::1", maxMask: 128, masks: all, expected: "h"},
+		{ip: "2001:db8:2::1", maxMask: 128, masks: all, expected: "g"},
+		{ip: "2001:db8:1::1", maxMask: 47, masks: all, expected: "g"},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s/%d", tc.ip, tc.maxMask), func(t *testing.T) {
+			var ip [net.IPv6len]byte
+			copy(ip[:], net.ParseIP(tc.ip).To16())
+			n := root.longestMatch(&ip, tc.maxMask, tc.masks)
+			if tc.expected == "" {
+				require.Nil(t, n)
+				return
+			}
+			require.NotNil(t, n)
+			require.Equal(t, tc.expected, string(n.loc))
+		})
+	}
+}
+
+// TestLocationIndexMatchesDB checks that location lookups served from memory
+// match the ones served from the DB.
+func TestLocationIndexMatchesDB(t *testing.T) {
+	mapIDs := [][]byte{{'c', 0}, {'e', 'c'}, {'n', 'o'}}
+	var subnets []*net.IPNet
+	for _, ip := range []string{
+		"1.1.1.0", "1.1.1.1", "1.1.2.0", "2.2.2.5", "2.2.3.1", "3.3.3.1", "3.3.3.2", "3.3.4.1",
+		"4.0.0.1", "4.0.1.1", "4.1.0.1", "4.4.4.4", "4.4.5.1", "4.4.5.2", "4.4.5.3", "4.5.6.7",
+		"6.6.6.0", "6.6.6.1", "6.6.6.2", "6.6.6.3", "127.0.0.1", "255.255.255.255",
+		"::", "::1", "fd58:6525:66bd:a::1", "fd58:6525:66bd:b::1", "fd8f:a2ea:9f4b::1",
+		"fd48:6525:66bd::1", "fd48:6525:66be::1", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff",
+	} {
+		parsed := net.ParseIP(ip)
+		bits := 128
+		if parsed.To4() != nil {
+			bits = 32
+		}
+		for _, ones := range []int{bits, bits - 8, 16, 0} {
+			subnets = append(subnets, &net.IPNet{IP: parsed, Mask: net.CIDRMask(ones, bits)})
+		}
+	}
+
+	for _, config := range testaid.TestDBs {
+		for _, separateBitMap := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/%s/SeparateBitMap %v", config.Driver, config.Flavour, separateBitMap), func(t *testing.T) {
+				kv, err := OpenWithOptions(config.Path, config.Driver, Options{SeparateBitMap: separateBitMap})
+				require.NoError(t, err)
+				defer kv.Destroy()
+				indexed, err := OpenWithOptions(config.Path, config.Driver, Options{SeparateBitMap: separateBitMap, LocationIndex: true})
+				require.NoError(t, err)
+				defer indexed.Destroy()
+				require.Positive(t, indexed.GetStats()["location_index.entries"])
+				ctx := kv.dbi.NewContext()
+				defer kv.dbi.FreeContext(ctx)
+				for _, mapID := range mapIDs {
+					for _, ipnet := range subnets {
+						loc, mlen, err := kv.dbi.GetLocationByMap(ipnet, mapID, ctx)
+						require.NoError(t, err)
+						iloc, imlen, err := indexed.dbi.GetLocationByMap(ipnet, mapID, nil)
+						require.NoError(t, err)
+						require.Equalf(t, loc, iloc, "map %q, %s", mapID, ipnet)
+						require.Equalf(t, mlen, imlen, "map %q, %s", mapID, ipnet)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestLocationIndexReload(t *testing.T) {
+	for _, config := range testaid.TestDBs {
+		t.Run(config.Driver, func(t *testing.T) {
+			d, err := OpenWithLocationIndex(config.Path, config.Driver)
+			require.NoError(t, err)
+			d, err = d.Reload(config.Path, nil, 10*time.Second)
+			require.NoError(t, err)
+			defer d.Destroy()
+			_, ok := d.dbi.(*indexedLocationDriver)
+			require.True(t, ok)
+
+			ipnet := &net.IPNet{IP: net.ParseIP("2.2.2.5"), Mask: net.CIDRMask(32, 32)}
+			loc, mlen, err := d.dbi.GetLocationByMap(ipnet, []byte{'c', 0}, nil)
+			require.NoError(t, err)
+			require.Equal(t, []byte{0, 3}, loc)
+			require.Equal(t, uint8(120), mlen)
+		})
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/repustate/go-cdb"
+)
+
+// memRecord is a key and one of its values
+type memRecord struct {
+	key   []byte
+	value []byte
+}
+
+// memContext is the context of memdriver, which needs none
+type memContext struct{}
+
+func (memContext) Reset() {}
+
+// implement db.DBI interface with a copy of a CDB file held in memory, which
+// trades memory and load time for lookups free of I/O
+type memdriver struct {
+	// records are the records of the file, in file order
+	records []memRecord
+	// values are the values of each key, in lookup order
+	values map[string][][]byte
+	// size is the number of bytes of the keys and values of the file
+	size int64
+	// separateBitMap is Options.SeparateBitMap
+	separateBitMap bool
+}
+
+func openMemory(name string, opts Options) (DBI, error) {
+	c, err := cdb.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	driver := &memdriver{
+		values:         make(map[string][][]byte),
+		separateBitMap: opts.SeparateBitMap,
+	}
+	err = c.ForEachRecord(func(key, value []byte) error {
+		r := memRecord{key: append([]byte(nil), key...), value: append([]byte(nil), value...)}
+		driver.records = append(driver.records, r)
+		driver.size += int64(len(key) + len(value))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// lookups don't return the values of a key in file order, look them up to
+	// serve them in the same order as cdbdriver
+	ctx := cdb.NewContext()
+	for _, r := range driver.records {
+		if _, ok := driver.values[string(r.key)]; ok {
+			continue
+		}
+		var values [][]byte
+		c.FindStart(ctx)
+		for {
+			v, err := c.FindNext(r.key, ctx)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, append([]byte(nil), v...))
+		}
+		driver.values[string(r.key)] = values
+	}
+	return driver, nil
+}
+
+func (m *memdriver) NewContext() Context {
+	return memContext{}
+}
+
+func (m *memdriver) FreeContext(Context) {}
+
+// Find returns the first value of key, io.EOF if it has none
+func (m *memdriver) Find(key []byte, _ Context) ([]byte, error) {
+	values := m.values[string(key)]
+	if len(values) == 0 {
+		return nil, io.EOF
+	}
+	return values[0], nil
+}
+
+// ForEach calls f with each value of key, until f returns an error
+func (m *memdriver) ForEach(key []byte, f func(value []byte) error, _ Context) error {
+	for _, v := range m.values[string(key)] {
+		if err := f(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindMap returns mapID for domain, like cdbdriver.FindMap
+func (m *memdriver) FindMap(domain, mtype []byte, context Context) ([]byte, error) {
+	return findMap(m, domain, mtype, context)
+}
+
+// GetLocationByMap finds and returns location and mask, like
+// cdbdriver.GetLocationByMap
+func (m *memdriver) GetLocationByMap(ipnet *net.IPNet, mapID []byte, context Context) ([]byte, uint8, error) {
+	return getLocationByMap(m, m.separateBitMap, ipnet, mapID, context)
+}
+
+// Close releases nothing, the memory is reclaimed once unreferenced
+func (m *memdriver) Close() error {
+	return nil
+}
+
+func (m *memdriver) Reload(path string) (DBI, error) {
+	start := time.Now()
+	glog.Infof("Loading CDB in memory, new path=%s", path)
+	newDBI, err := openMemory(path, Options{SeparateBitMap: m.separateBitMap})
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Finished loading CDB in memory in %v", time.Since(start))
+	return newDBI, nil
+}
+
+// GetStats reports the memory held by keys and values
+func (m *memdriver) GetStats() map[string]int64 {
+	return map[string]int64{
+		"memory.records": int64(len(m.records)),
+		"memory.bytes":   m.size,
+	}
+}
+
+// ClosestKeyFinder always returns nil, keys are not sorted
+func (m *memdriver) ClosestKeyFinder() ClosestKeyFinder {
+	return nil
+}
+
+func (m *memdriver) forEachRecord(prefix []byte, _ bool, f func(key, value []byte) error) error {
+	// each value of a key is its own record, as in the CDB file
+	f = withPrefix(prefix, f)
+	for _, r := range m.records {
+		if err := f(r.key, r.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+// TestMemoryMatchesCDB checks that the memory driver serves the same values,
+// maps and locations as the CDB file it loaded.
+func TestMemoryMatchesCDB(t *testing.T) {
+	cdb, err := Open(testaid.TestCDB.Path, "cdb")
+	require.NoError(t, err)
+	defer cdb.Destroy()
+	mem, err := Open(testaid.TestCDB.Path, "memory")
+	require.NoError(t, err)
+	defer mem.Destroy()
+	require.Positive(t, mem.GetStats()["memory.records"])
+
+	cctx := cdb.dbi.NewContext()
+	defer cdb.dbi.FreeContext(cctx)
+	mctx := mem.dbi.NewContext()
+	defer mem.dbi.FreeContext(mctx)
+
+	records := 0
+	err = cdb.dbi.(*cdbdriver).forEachRecord(nil, false, func(key, _ []byte) error {
+		records++
+		var want, got [][]byte
+		require.NoError(t, cdb.dbi.ForEach(key, func(v []byte) error {
+			want = append(want, append([]byte(nil), v...))
+			return nil
+		}, cctx))
+		require.NoError(t, mem.dbi.ForEach(key, func(v []byte) error {
+			got = append(got, v)
+			return nil
+		}, mctx))
+		require.Equalf(t, want, got, "key %q", key)
+		v, err := mem.dbi.Find(key, mctx)
+		require.NoError(t, err)
+		require.Equal(t, want[0], v)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(records), mem.GetStats()["memory.records"])
+
+	_, err = mem.dbi.Find([]byte("no such key"), mctx)
+	require.ErrorIs(t, err, io.EOF)
+
+	for _, name := range []string{"example.com.", "www.example.com.", "nonexistent.example.org.", "."} {
+		packed := make([]byte, 255)
+		n, err := dns.PackDomainName(name, packed, 0, nil, false)
+		require.NoError(t, err)
+		for _, mtype := range [][]byte{{0, 'M'}, {0, '8'}} {
+			want, err := cdb.dbi.FindMap(packed[:n], mtype, cctx)
+			require.NoError(t, err)
+			got, err := mem.dbi.FindMap(packed[:n], mtype, mctx)
+			require.NoError(t, err)
+			require.Equalf(t, want, got, "%s %q", name, mtype)
+		}
+	}
+
+	for _, ip := range []string{"1.1.1.1", "2.2.2.5", "4.4.5.2", "6.6.6.3", "127.0.0.1", "::1", "fd58:6525:66bd:a::1"} {
+		parsed := net.ParseIP(ip)
+		bits := 128
+		if parsed.To4() != nil {
+			bits = 32
+		}
+		ipnet := &net.IPNet{IP: parsed, Mask: net.CIDRMask(bits, bits)}
+		for _, mapID := range [][]byte{{'c', 0}, {'e', 'c'}} {
+			loc, mlen, err := cdb.dbi.GetLocationByMap(ipnet, mapID, cctx)
+			require.NoError(t, err)
+			mloc, mmlen, err := mem.dbi.GetLocationByMap(ipnet, mapID, mctx)
+			require.NoError(t, err)
+			require.Equalf(t, loc, mloc, "map %q, %s", mapID, ipnet)
+			require.Equalf(t, mlen, mmlen, "map %q, %s", mapID, ipnet)
+		}
+	}
+}
+
+func TestMemoryReloadWithLocationIndex(t *testing.T) {
+	d, err := OpenWithLocationIndex(testaid.TestCDB.Path, "memory")
+	require.NoError(t, err)
+	d, err = d.Reload(testaid.TestCDB.Path, nil, 10*time.Second)
+	require.NoError(t, err)
+	defer d.Destroy()
+	indexed, ok := d.dbi.(*indexedLocationDriver)
+	require.True(t, ok)
+	_, ok = indexed.DBI.(*memdriver)
+	require.True(t, ok)
+
+	ipnet := &net.IPNet{IP: net.ParseIP("2.2.2.5"), Mask: net.CIDRMask(32, 32)}
+	loc, mlen, err := d.dbi.GetLocationByMap(ipnet, []byte{'c', 0}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 3}, loc)
+	require.Equal(t, uint8(120), mlen)
+}
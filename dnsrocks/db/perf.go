@@ -0,0 +1,46 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// PerfSample is the RocksDB work done by the lookups of a sampled query, as
+// counted by the perf context of the OS thread they ran on
+type PerfSample struct {
+	// BlockReads is the number of blocks read from SST files, i.e. block
+	// cache misses, of BlockReadBytes bytes read in BlockReadTime
+	BlockReads     uint64
+	BlockReadBytes uint64
+	BlockReadTime  time.Duration
+	// BlockCacheHits is the number of blocks found in the block cache
+	BlockCacheHits uint64
+	// MemtableGets is the number of point lookups in memtables
+	MemtableGets uint64
+	// Seeks is the number of iterator seeks, in SST files and memtables
+	Seeks uint64
+	// KeyComparisons is the number of user key comparisons
+	KeyComparisons uint64
+	// SkippedKeys is the number of internal keys skipped by iterators, e.g.
+	// overwritten or deleted ones
+	SkippedKeys uint64
+}
+
+// String returns the sample in key=value form, for logs
+func (s PerfSample) String() string {
+	return fmt.Sprintf("block_reads=%d block_read_bytes=%d block_read_time=%v block_cache_hits=%d memtable_gets=%d seeks=%d key_comparisons=%d skipped_keys=%d",
+		s.BlockReads, s.BlockReadBytes, s.BlockReadTime, s.BlockCacheHits, s.MemtableGets, s.Seeks, s.KeyComparisons, s.SkippedKeys)
+}
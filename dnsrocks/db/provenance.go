@@ -0,0 +1,41 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"errors"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// ErrNoProvenance is returned when reading the provenance of a DB compiled
+// without one
+var ErrNoProvenance = errors.New("no provenance")
+
+// Provenance returns the provenance stored in the DB by the compiler, or
+// ErrNoProvenance
+func (f *DB) Provenance() (*dnsdata.Provenance, error) {
+	value, err := f.specialValue(dnsdata.ProvenanceKey)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ErrNoProvenance
+	}
+	p := new(dnsdata.Provenance)
+	if err := p.UnmarshalText(value); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -18,29 +20,75 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"runtime"
 	"time"
 
+	rocksdb "github.com/facebook/dns/dnsrocks/cgo-rocksdb"
 	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
 
 	"github.com/golang/glog"
 )
 
+// RocksDBSupported is true when the rocksdb driver is built in, which takes
+// cgo and no norocksdb tag
+const RocksDBSupported = true
+
+// StartPerfSample starts counting the RocksDB work done by the calling
+// goroutine, which is locked to its OS thread, where RocksDB counts it, until
+// the returned function is called. That function stops counting and returns
+// the work done in between. Lookups made by other goroutines are not counted.
+func StartPerfSample() func() PerfSample {
+	runtime.LockOSThread()
+	rocksdb.SetPerfLevel(rocksdb.PerfLevelEnableTimeExceptForMutex)
+	perf := rocksdb.NewPerfContext()
+	perf.Reset()
+	return func() PerfSample {
+		defer runtime.UnlockOSThread()
+		defer perf.Destroy()
+		rocksdb.SetPerfLevel(rocksdb.PerfLevelDisable)
+		return PerfSample{
+			BlockReads:     perf.Metric(rocksdb.PerfBlockReadCount),
+			BlockReadBytes: perf.Metric(rocksdb.PerfBlockReadByte),
+			BlockReadTime:  time.Duration(perf.Metric(rocksdb.PerfBlockReadTime)), //nolint:gosec
+			BlockCacheHits: perf.Metric(rocksdb.PerfBlockCacheHitCount),
+			MemtableGets:   perf.Metric(rocksdb.PerfGetFromMemtableCount),
+			Seeks:          perf.Metric(rocksdb.PerfSeekChildSeekCount) + perf.Metric(rocksdb.PerfSeekOnMemtableCount),
+			KeyComparisons: perf.Metric(rocksdb.PerfUserKeyComparisonCount),
+			SkippedKeys:    perf.Metric(rocksdb.PerfInternalKeySkippedCount),
+		}
+	}
+}
+
 // implement db.DBI interface over RocksDB
 type rdbdriver struct {
 	db           *rdb.RDB
 	path         string
 	isDataSorted bool
+	opts         Options
+}
+
+// MemoryBudget is block cache and write buffer memory shared by RDB databases
+type MemoryBudget = rdb.MemoryBudget
+
+// NewMemoryBudget returns a budget of size bytes shared by the RDB databases
+// opened with it, up to writeBufferSize of which can be used by memtables
+func NewMemoryBudget(size, writeBufferSize int) (*MemoryBudget, error) {
+	return rdb.NewMemoryBudget(size, writeBufferSize)
 }
 
-func openRDB(path string) (DBI, error) {
-	db, err := rdb.NewReader(path)
+func openRDB(path string, opts Options) (DBI, error) {
+	db, err := rdb.NewReaderWithOptions(path, rdb.ReaderOptions{
+		ReadOnly:     opts.ReadOnly,
+		MaxStaleness: opts.MaxStaleness,
+		MemoryBudget: opts.MemoryBudget,
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	isDataSorted := db.IsV2KeySyntaxUsed()
 
-	driver := &rdbdriver{db: db, path: path, isDataSorted: isDataSorted}
+	driver := &rdbdriver{db: db, path: path, isDataSorted: isDataSorted, opts: opts}
 	return driver, nil
 }
 
@@ -99,7 +147,7 @@ func (r *rdbdriver) FindMap(domain, mtype []byte, context Context) ([]byte, erro
 		firstLoop = false
 	}
 
-	mapID, _, err := r.db.FindFirst(keys)
+	mapID, _, err := r.db.FindFirstWithDeadline(keys, context.(*rdb.Context))
 	if err != nil {
 		return nil, err
 	}
@@ -128,7 +176,7 @@ func (r *rdbdriver) findMapInSortedData(domain, mtype []byte, context Context) (
 
 		var foundKey []byte
 		var foundValue []byte
-		foundKey, foundValue, err = r.findClosest(k, ctx)
+		foundKey, foundValue, err = r.db.FindClosestWithPrefix(k, prefixLen, ctx)
 		if err != nil {
 			break
 		}
@@ -160,14 +208,6 @@ func (r *rdbdriver) findMapInSortedData(domain, mtype []byte, context Context) (
 	return mapID, err
 }
 
-var (
-	firstIPv4 = net.ParseIP("0000:0000:0000:0000:0000:ffff:0000:0000") // the very first IPv4 address according to RFC-2765
-)
-
-func isIPv4(addr net.IP) bool {
-	return addr != nil && (len(addr) == net.IPv4len || net.IP.Equal(addr[:12], firstIPv4[:12]))
-}
-
 func unpackLocation(foundKey, foundVal []byte) (loc []byte, mlen uint8, err error) {
 	if len(foundVal) < 4 {
 		if len(foundVal) == 0 {
@@ -235,7 +275,8 @@ func (r *rdbdriver) GetLocationByMap(ipnet *net.IPNet, mapID []byte, context Con
 
 	// NOTE: Rearranger has merging on adjacent locations with same mask and locID,
 	// so findClosest() might return the key that will match some other IP. It is fine for our purposes.
-	foundKey, foundVal, err := r.db.FindClosest(fullKey, ctx)
+	// Keys of other maps are never returned, though.
+	foundKey, foundVal, err := r.db.FindClosestWithPrefix(fullKey, 4+nmap, ctx)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -248,17 +289,22 @@ func (r *rdbdriver) Close() error {
 
 func (r *rdbdriver) Reload(path string) (DBI, error) {
 	start := time.Now()
-	if path == r.path {
+	if path == r.path && !r.opts.ReadOnly {
 		glog.Infof("Doing catchUpWithPrimary for RDB")
 		if err := r.db.CatchWithPrimary(); err != nil {
 			return nil, err
 		}
 		reloadTime := time.Now()
 		glog.Infof("Caught up on primary for RocksDB in %v", reloadTime.Sub(start))
-		return r, nil
+		// the keys syntax changes when the DB is migrated in place, lookups
+		// need to be set up again
+		if r.db.IsV2KeySyntaxUsed() == r.isDataSorted {
+			return r, nil
+		}
+		glog.Infof("RDB keys syntax changed, v2 keys %v", !r.isDataSorted)
 	}
 	glog.Infof("Doing full RDB reload, new path=%s", path)
-	newDB, err := openRDB(path)
+	newDB, err := openRDB(path, r.opts)
 	if err != nil {
 		return nil, err
 	}
@@ -305,3 +351,49 @@ func (r *rdbdriver) ClosestKeyFinder() ClosestKeyFinder {
 
 	return nil
 }
+
+func (r *rdbdriver) forEachRecord(prefix []byte, splitValues bool, f func(key, value []byte) error) error {
+	if !splitValues {
+		return r.db.ForEachKeyWithPrefix(prefix, f)
+	}
+	return r.db.ForEachKeyWithPrefix(prefix, func(key, data []byte) error {
+		for len(data) > 0 {
+			value, rest, err := rdb.ReadNextChunk(data)
+			if err != nil {
+				return fmt.Errorf("invalid value of key %v: %w", key, err)
+			}
+			if err := f(key, value); err != nil {
+				return err
+			}
+			data = rest
+		}
+		return nil
+	})
+}
+
+func (r *rdbdriver) buildLocationIndex() (locationIndex, error) {
+	ri := &rangeIndex{points: make(map[string][]rangePoint)}
+	// keys come sorted, so are the points of each map
+	err := r.db.ForEachKeyWithPrefix(ipMapRangePointKeyElement, func(key, data []byte) error {
+		mapID, rest, err := splitMapID(key[len(ipMapRangePointKeyElement):])
+		if err != nil {
+			return err
+		}
+		if len(rest) != net.IPv6len+1 {
+			return fmt.Errorf("invalid range point key %v", key)
+		}
+		loc, mlen, err := unpackLocation(key, data)
+		if err != nil {
+			return err
+		}
+		p := rangePoint{loc: loc, mlen: mlen}
+		copy(p.key[:], rest)
+		ri.points[string(mapID)] = append(ri.points[string(mapID)], p)
+		ri.entries++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ri, nil
+}
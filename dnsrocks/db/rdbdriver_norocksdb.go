@@ -0,0 +1,53 @@
+//go:build !cgo || norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"errors"
+)
+
+// RocksDBSupported is false when built without cgo or with the norocksdb
+// tag, in which case only the cdb driver is available
+const RocksDBSupported = false
+
+// ErrRocksDBUnsupported - the rocksdb driver is not built in
+var ErrRocksDBUnsupported = errors.New("rocksdb driver is not supported by this build, rebuild with cgo and without the norocksdb tag")
+
+func openRDB(_ string, _ Options) (DBI, error) {
+	return nil, ErrRocksDBUnsupported
+}
+
+// MemoryBudget is block cache and write buffer memory shared by RDB databases
+type MemoryBudget struct{}
+
+// NewMemoryBudget returns ErrRocksDBUnsupported
+func NewMemoryBudget(_, _ int) (*MemoryBudget, error) {
+	return nil, ErrRocksDBUnsupported
+}
+
+// Usage returns the memory used out of the budget, in bytes
+func (b *MemoryBudget) Usage() uint64 {
+	return 0
+}
+
+// Free releases the budget
+func (b *MemoryBudget) Free() {}
+
+// StartPerfSample returns a function returning an empty sample, as there is
+// no RocksDB work to count
+func StartPerfSample() func() PerfSample {
+	return func() PerfSample { return PerfSample{} }
+}
@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -14,7 +16,13 @@ limitations under the License.
 package db
 
 import (
+	"bytes"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+	"github.com/facebook/dns/dnsrocks/testaid"
 
 	"github.com/stretchr/testify/require"
 )
@@ -128,3 +136,85 @@ func TestUnpackLocation(t *testing.T) {
 		})
 	}
 }
+
+func TestRDBReadOnlyReload(t *testing.T) {
+	d, err := OpenWithOptions(testaid.TestRDB.Path, testaid.TestRDB.Driver, Options{ReadOnly: true})
+	require.NoError(t, err)
+	driver := d.dbi.(*rdbdriver)
+	require.True(t, driver.opts.ReadOnly)
+
+	// reloading the same path reopens the database instead of catching up
+	d, err = d.Reload(testaid.TestRDB.Path, nil, 10*time.Second)
+	require.NoError(t, err)
+	defer d.Destroy()
+	reloaded := d.dbi.(*rdbdriver)
+	require.NotSame(t, driver, reloaded)
+	require.True(t, reloaded.opts.ReadOnly)
+
+	ipnet := &net.IPNet{IP: net.ParseIP("2.2.2.5"), Mask: net.CIDRMask(32, 32)}
+	ctx := d.dbi.NewContext()
+	defer d.dbi.FreeContext(ctx)
+	loc, mlen, err := d.dbi.GetLocationByMap(ipnet, []byte{'c', 0}, ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 3}, loc)
+	require.Equal(t, uint8(120), mlen)
+}
+
+func TestRDBKeySyntaxReload(t *testing.T) {
+	dir := t.TempDir()
+	input := "Zexample.com,ns.example.com,dns.example.com,,,,,,\n+www.example.com,192.0.2.1\n"
+	_, err := rdb.Compile(bytes.NewReader([]byte(input)), 1, dir, rdb.CompilationOptions{NumCPU: 1})
+	require.NoError(t, err)
+	d, err := OpenWithOptions(dir, "rocksdb", Options{})
+	require.NoError(t, err)
+	driver := d.dbi.(*rdbdriver)
+	require.Nil(t, driver.ClosestKeyFinder())
+
+	// catching up with a DB migrated in place sets up v2 lookups
+	_, err = rdb.MigrateToV2Keys(dir, 0)
+	require.NoError(t, err)
+	d, err = d.Reload(dir, nil, 10*time.Second)
+	require.NoError(t, err)
+	defer d.Destroy()
+	reloaded := d.dbi.(*rdbdriver)
+	require.NotSame(t, driver, reloaded)
+	require.NotNil(t, reloaded.ClosestKeyFinder())
+}
+
+func TestRDBLocationFallback(t *testing.T) {
+	for _, useV2Keys := range []bool{false, true} {
+		dir := t.TempDir()
+		_, err := rdb.Compile(bytes.NewReader([]byte(fallbackTestData)), 1, dir, rdb.CompilationOptions{
+			NumCPU:         1,
+			UseV2KeySyntax: useV2Keys,
+		})
+		require.NoError(t, err)
+		db, err := OpenWithOptions(dir, "rocksdb", Options{ReadOnly: true})
+		require.NoError(t, err)
+		requireLocationFallback(t, db)
+		db.Destroy()
+	}
+}
+
+func TestRDBChecksum(t *testing.T) {
+	dir := t.TempDir()
+	input := "Zexample.com,ns.example.com,dns.example.com,,,,,,\n+www.example.com,192.0.2.1\n%example,192.0.2.0/24,m1\n"
+	_, err := rdb.Compile(bytes.NewReader([]byte(input)), 1, dir, rdb.CompilationOptions{
+		NumCPU:         1,
+		UseV2KeySyntax: true,
+		Checksum:       true,
+	})
+	require.NoError(t, err)
+
+	opts := DefaultOptions()
+	opts.ReadOnly = true
+	opts.LocationIndex = true
+	db, err := OpenWithOptions(dir, "rocksdb", opts)
+	require.NoError(t, err)
+	defer db.Destroy()
+	sum, err := db.VerifyChecksum(nil)
+	require.NoError(t, err)
+	stored, err := db.Checksum()
+	require.NoError(t, err)
+	require.Equal(t, stored, sum)
+}
@@ -0,0 +1,68 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import "strings"
+
+// RecordCounts are the numbers of resource records of a DB
+type RecordCounts struct {
+	// Total is the number of resource records, of every location
+	Total int64
+	// Zones are the numbers of resource records of the zones counted, by
+	// zone. The records of a subzone counted are left out of its parent.
+	Zones map[string]int64
+}
+
+// CountRecords counts the resource records of the DB, in total and for each of
+// zones, which must be canonical names. Records are counted in the closest
+// enclosing zone of their owner name, if any. The markers of the private types
+// (ALIAS, TTL, dual-stack, empty non-terminals) are never served, so they are
+// not counted. This reads the whole DB.
+func (f *DB) CountRecords(zones []string) (RecordCounts, error) {
+	counts := RecordCounts{Zones: make(map[string]int64, len(zones))}
+	for _, zone := range zones {
+		counts.Zones[zone] = 0
+	}
+	err := f.forEachResourceRecord(".", true, func(e resourceRecordEntry) error {
+		if qtype := e.qtype(); qtype == 0 || isPrivateType(qtype) {
+			return nil
+		}
+		counts.Total++
+		if len(zones) == 0 {
+			return nil
+		}
+		if zone, found := closestZone(normalizeOwnerName(e.labels), counts.Zones); found {
+			counts.Zones[zone]++
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// closestZone returns the closest zone of zones enclosing the canonical name
+func closestZone(name string, zones map[string]int64) (string, bool) {
+	for {
+		if _, found := zones[name]; found {
+			return name, true
+		}
+		if name == "." {
+			return "", false
+		}
+		i := strings.IndexByte(name, '.')
+		name = name[i+1:]
+		if name == "" {
+			name = "."
+		}
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestCountRecords(t *testing.T) {
+	testDBs := testaid.NewFixture().
+		Zone("example.com", 300, "ns.example.com").
+		Address("www.example.com", "192.0.2.1", 300, "").
+		Address("www.example.com", "2001:db8::1", 300, testaid.Loc(1)).
+		Zone("sub.example.com", 300, "ns.example.com").
+		Address("a.sub.example.com", "192.0.2.2", 300, "").
+		TXT("other.test", "not in a zone counted", 300).
+		Build(t)
+
+	for _, config := range testDBs {
+		t.Run(config.Driver+"/"+config.Flavour, func(t *testing.T) {
+			db, err := Open(config.Path, config.Driver)
+			require.NoError(t, err)
+			defer db.Destroy()
+
+			counts, err := db.CountRecords(nil)
+			require.NoError(t, err)
+			require.Equal(t, RecordCounts{Total: 8, Zones: map[string]int64{}}, counts)
+
+			counts, err = db.CountRecords([]string{"example.com.", "sub.example.com.", "example.net."})
+			require.NoError(t, err)
+			require.Equal(t, RecordCounts{
+				Total: 8,
+				Zones: map[string]int64{"example.com.": 4, "sub.example.com.": 3, "example.net.": 0},
+			}, counts)
+
+			counts, err = db.CountRecords([]string{"."})
+			require.NoError(t, err)
+			require.Equal(t, map[string]int64{".": 8}, counts.Zones)
+		})
+	}
+}
+
+func TestCountRecordsPrivateTypes(t *testing.T) {
+	// the delegation makes an empty non-terminal marker at b.example.com
+	testDBs := testaid.NewFixture().
+		Zone("example.com", 300, "ns.example.com").
+		NS("a.b.example.com", "ns.example.net", 300).
+		Build(t)
+
+	for _, config := range testDBs {
+		t.Run(config.Driver+"/"+config.Flavour, func(t *testing.T) {
+			db, err := Open(config.Path, config.Driver)
+			require.NoError(t, err)
+			defer db.Destroy()
+
+			counts, err := db.CountRecords([]string{"example.com."})
+			require.NoError(t, err)
+			require.Equal(t, RecordCounts{Total: 3, Zones: map[string]int64{"example.com.": 3}}, counts)
+		})
+	}
+}
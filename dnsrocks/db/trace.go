@@ -0,0 +1,128 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"net"
+)
+
+// Operations reported to a TraceFunc
+const (
+	TraceFind        = "find"
+	TraceForEach     = "foreach"
+	TraceFindMap     = "findmap"
+	TraceLocation    = "location"
+	TraceClosestKeys = "closestkey"
+)
+
+// TraceFunc is called for every probe of the DB backing storage, with the
+// operation, the key probed and the values found. It is meant for debugging
+// lookups offline, as it slows them down.
+//
+// For TraceFindMap, key is the map type followed by the packed domain and
+// values holds the map ID. For TraceLocation, key is the map ID followed by
+// the text form of the subnet, values holds the location ID. For
+// TraceClosestKeys, values holds the closest key found.
+type TraceFunc func(op string, key []byte, values [][]byte, err error)
+
+// SetTracer makes the DB report its probes to trace. Tracing stops when the
+// DB is reloaded.
+func (f *DB) SetTracer(trace TraceFunc) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if t, ok := f.dbi.(*tracingDBI); ok {
+		t.trace = trace
+		return
+	}
+	f.dbi = &tracingDBI{DBI: f.dbi, trace: trace}
+}
+
+// NewTracingReader returns a new DB reader reporting its probes to trace.
+// Unlike SetTracer, only the probes of this reader are reported.
+func NewTracingReader(db *DB, trace TraceFunc) (Reader, error) {
+	reader, err := NewReader(db)
+	if err != nil {
+		return reader, err
+	}
+	switch r := reader.(type) {
+	case *sortedDataReader:
+		r.dbi = &tracingDBI{DBI: r.dbi, trace: trace}
+		r.closestKeyFinder = &tracingClosestKeyFinder{ClosestKeyFinder: r.closestKeyFinder, trace: trace}
+	case *DataReader:
+		r.dbi = &tracingDBI{DBI: r.dbi, trace: trace}
+	}
+	return reader, nil
+}
+
+// tracingDBI is a DBI reporting probes to a TraceFunc
+type tracingDBI struct {
+	DBI
+	trace TraceFunc
+}
+
+func (t *tracingDBI) Find(key []byte, context Context) ([]byte, error) {
+	v, err := t.DBI.Find(key, context)
+	t.trace(TraceFind, key, valuesOf(v), err)
+	return v, err
+}
+
+func (t *tracingDBI) ForEach(key []byte, f func(value []byte) error, context Context) error {
+	var values [][]byte
+	err := t.DBI.ForEach(key, func(value []byte) error {
+		values = append(values, append([]byte(nil), value...))
+		return f(value)
+	}, context)
+	t.trace(TraceForEach, key, values, err)
+	return err
+}
+
+func (t *tracingDBI) FindMap(domain, mtype []byte, context Context) ([]byte, error) {
+	mapID, err := t.DBI.FindMap(domain, mtype, context)
+	t.trace(TraceFindMap, append(append([]byte(nil), mtype...), domain...), valuesOf(mapID), err)
+	return mapID, err
+}
+
+func (t *tracingDBI) GetLocationByMap(ipnet *net.IPNet, mapID []byte, context Context) ([]byte, uint8, error) {
+	locID, mask, err := t.DBI.GetLocationByMap(ipnet, mapID, context)
+	t.trace(TraceLocation, append(append([]byte(nil), mapID...), ipnet.String()...), valuesOf(locID), err)
+	return locID, mask, err
+}
+
+func (t *tracingDBI) ClosestKeyFinder() ClosestKeyFinder {
+	finder := t.DBI.ClosestKeyFinder()
+	if finder == nil {
+		return nil
+	}
+	return &tracingClosestKeyFinder{ClosestKeyFinder: finder, trace: t.trace}
+}
+
+// tracingClosestKeyFinder is a ClosestKeyFinder reporting probes to a TraceFunc
+type tracingClosestKeyFinder struct {
+	ClosestKeyFinder
+	trace TraceFunc
+}
+
+func (t *tracingClosestKeyFinder) FindClosestKey(key []byte, context Context) ([]byte, error) {
+	k, err := t.ClosestKeyFinder.FindClosestKey(key, context)
+	t.trace(TraceClosestKeys, key, valuesOf(k), err)
+	return k, err
+}
+
+// valuesOf returns a copy of v as a list of values, empty if v is nil
+func valuesOf(v []byte) [][]byte {
+	if v == nil {
+		return nil
+	}
+	return [][]byte{append([]byte(nil), v...)}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestTracer(t *testing.T) {
+	var q = make([]byte, 255)
+
+	for _, config := range testaid.TestDBs {
+		t.Run(fmt.Sprintf("%s/%s", config.Driver, config.Flavour), func(t *testing.T) {
+			db, err := Open(config.Path, config.Driver)
+			require.NoError(t, err, "could not open fixture database")
+			defer db.Destroy()
+
+			ops := make(map[string]int)
+			found := 0
+			db.SetTracer(func(op string, key []byte, values [][]byte, err error) {
+				require.NoError(t, err)
+				ops[op]++
+				found += len(values)
+			})
+			r, err := NewReader(db)
+			require.NoError(t, err)
+			defer r.Close()
+
+			offset, err := dns.PackDomainName("foo.example.org.", q, 0, nil, false)
+			require.NoError(t, err)
+			loc, err := r.FindLocation(q[:offset], nil, "1.1.1.1")
+			require.NoError(t, err)
+			_, auth, zoneCut, err := r.IsAuthoritative(q[:offset], loc.LocID)
+			require.NoError(t, err)
+			require.True(t, auth)
+
+			a := new(dns.Msg)
+			_, rcode := r.FindAnswer(q[:offset], zoneCut, "foo.example.org.", dns.TypeA, loc.LocID, a, 1)
+			require.Equal(t, dns.RcodeSuccess, rcode)
+			require.Len(t, a.Answer, 1)
+
+			require.Equal(t, 1, ops[TraceFindMap])
+			require.Equal(t, 1, ops[TraceLocation])
+			require.NotZero(t, ops[TraceForEach])
+			require.NotZero(t, found)
+		})
+	}
+}
+
+func TestTracingReader(t *testing.T) {
+	var q = make([]byte, 255)
+
+	for _, config := range testaid.TestDBs {
+		t.Run(fmt.Sprintf("%s/%s", config.Driver, config.Flavour), func(t *testing.T) {
+			db, err := Open(config.Path, config.Driver)
+			require.NoError(t, err, "could not open fixture database")
+			defer db.Destroy()
+
+			ops := make(map[string]int)
+			r, err := NewTracingReader(db, func(op string, key []byte, values [][]byte, err error) {
+				require.NoError(t, err)
+				ops[op]++
+			})
+			require.NoError(t, err)
+			defer r.Close()
+			untraced, err := NewReader(db)
+			require.NoError(t, err)
+			defer untraced.Close()
+
+			offset, err := dns.PackDomainName("foo.example.org.", q, 0, nil, false)
+			require.NoError(t, err)
+			_, err = untraced.FindLocation(q[:offset], nil, "1.1.1.1")
+			require.NoError(t, err)
+			require.Empty(t, ops)
+
+			_, err = r.FindLocation(q[:offset], nil, "1.1.1.1")
+			require.NoError(t, err)
+			require.Equal(t, 1, ops[TraceFindMap])
+			require.Equal(t, 1, ops[TraceLocation])
+		})
+	}
+}
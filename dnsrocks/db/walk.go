@@ -0,0 +1,90 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// recordWalker is implemented by drivers which can list all their records.
+type recordWalker interface {
+	// forEachRecord calls f with every key starting with prefix and its
+	// value, in the order the compilers wrote them. Drivers with sorted keys
+	// only read the range of keys starting with prefix, the others read all
+	// their records. With splitValues, the values holding several values of
+	// a key, as stored by RDB, are split and f is called with each of them.
+	forEachRecord(prefix []byte, splitValues bool, f func(key, value []byte) error) error
+}
+
+// walkerOf returns the record walker of dbi, or an error if it cannot list
+// its records
+func walkerOf(dbi DBI) (recordWalker, error) {
+	walker, ok := dbi.(recordWalker)
+	if !ok {
+		return nil, fmt.Errorf("%T does not support listing records", dbi)
+	}
+	return walker, nil
+}
+
+func (c *cdbdriver) forEachRecord(prefix []byte, _ bool, f func(key, value []byte) error) error {
+	// in the order of the compiler, see dnsdata.ChecksumHasher. CDB stores
+	// each value of a key as its own record.
+	return c.db.ForEachRecord(withPrefix(prefix, f))
+}
+
+// withPrefix returns f, only called for the keys starting with prefix
+func withPrefix(prefix []byte, f func(key, value []byte) error) func(key, value []byte) error {
+	if len(prefix) == 0 {
+		return f
+	}
+	return func(key, value []byte) error {
+		if !bytes.HasPrefix(key, prefix) {
+			return nil
+		}
+		return f(key, value)
+	}
+}
+
+func (d *indexedLocationDriver) forEachRecord(prefix []byte, splitValues bool, f func(key, value []byte) error) error {
+	walker, err := walkerOf(d.DBI)
+	if err != nil {
+		return err
+	}
+	return walker.forEachRecord(prefix, splitValues, f)
+}
+
+func (d *locationCacheDriver) forEachRecord(prefix []byte, splitValues bool, f func(key, value []byte) error) error {
+	walker, err := walkerOf(d.DBI)
+	if err != nil {
+		return err
+	}
+	return walker.forEachRecord(prefix, splitValues, f)
+}
+
+func (f *faultInjectingDBI) forEachRecord(prefix []byte, splitValues bool, fn func(key, value []byte) error) error {
+	walker, err := walkerOf(f.DBI)
+	if err != nil {
+		return err
+	}
+	return walker.forEachRecord(prefix, splitValues, fn)
+}
+
+func (t *tracingDBI) forEachRecord(prefix []byte, splitValues bool, f func(key, value []byte) error) error {
+	walker, err := walkerOf(t.DBI)
+	if err != nil {
+		return err
+	}
+	return walker.forEachRecord(prefix, splitValues, f)
+}
@@ -35,6 +35,14 @@ type Wrs struct {
 	V4Count    uint32
 	V6         []WrsItem
 	V6Count    uint32
+	// rand, if set, is used instead of localRand, see DataReader.SetRandSeed
+	rand wrsRand
+}
+
+// wrsRand is the randomness used to sample and shuffle records
+type wrsRand interface {
+	Uint32() uint32
+	Shuffle(n int, swap func(i, j int))
 }
 
 /*
@@ -45,6 +53,14 @@ than having to do it themselves.
 */
 var localRand = NewRand()
 
+// random returns the randomness of w
+func (w *Wrs) random() wrsRand {
+	if w.rand != nil {
+		return w.rand
+	}
+	return localRand
+}
+
 // Add adds a ResourceRecord to Wrs if its randomly computed weight is greater
 // then the existing record.
 func (w *Wrs) Add(rec ResourceRecord, data []byte) error {
@@ -52,7 +68,7 @@ func (w *Wrs) Add(rec ResourceRecord, data []byte) error {
 		return fmt.Errorf("Unsupported type %d", rec.Qtype)
 	}
 
-	key := math.Pow(float64(localRand.Uint32())*float64(1.0/math.MaxUint32), 1.0/float64(rec.Weight))
+	key := math.Pow(float64(w.random().Uint32())*float64(1.0/math.MaxUint32), 1.0/float64(rec.Weight))
 	wrsItem := WrsItem{Key: key,
 		TTL:  rec.TTL,
 		Addr: data[rec.Offset:]}
@@ -112,7 +128,7 @@ func (w *Wrs) record(name string, class uint16, qtype uint16) (rrs []dns.RR, err
 	default:
 		return nil, fmt.Errorf("Unsupported type %d", qtype)
 	}
-	localRand.Shuffle(len(items), func(i, j int) {
+	w.random().Shuffle(len(items), func(i, j int) {
 		items[i], items[j] = items[j], items[i]
 	})
 
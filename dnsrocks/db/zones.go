@@ -0,0 +1,246 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// ZoneRecord is a resource record as stored in a DB
+type ZoneRecord struct {
+	// RR is the record, with the TTL stored, before TTL overrides. The owner
+	// name of wildcard records starts with "*.".
+	RR dns.RR
+	// Location is the ID of the location the record is served to, ZeroID
+	// for every location
+	Location ID
+	// Weight is the weight of A and AAAA records in weighted random sampling
+	Weight uint32
+}
+
+// resourceRecordKeyLocation returns the location ID of a resource record key
+// parsed into labels and trailingRoots
+func resourceRecordKeyLocation(key []byte, labels [][]byte, trailingRoots int, v2 bool) ID {
+	if !v2 {
+		rest, _ := skipLocation(key)
+		return ID(key[:len(key)-len(rest)])
+	}
+	n := len(dnsdata.ResourceRecordsKeyMarker) + 1 + trailingRoots
+	for _, l := range labels {
+		n += len(l) + 1
+	}
+	return ID(key[n:])
+}
+
+// isPrivateType returns true for the types of the entries which are never
+// served: ALIAS records, TTL overrides, dual-stack policies and empty
+// non-terminal markers
+func isPrivateType(qtype uint16) bool {
+	switch dnsdata.WireType(qtype) {
+	case dnsdata.TypeALIAS, dnsdata.TypeTTL, dnsdata.TypeDualStack, dnsdata.TypeENT:
+		return true
+	}
+	return false
+}
+
+// resourceRecordEntry is a resource record entry of the DB, with its key
+// parsed
+type resourceRecordEntry struct {
+	key           []byte
+	labels        [][]byte
+	trailingRoots int
+	location      ID
+	value         []byte
+}
+
+// qtype returns the type of the entry, 0 if its value is too short to have
+// one
+func (e *resourceRecordEntry) qtype() uint16 {
+	if len(e.value) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(e.value)
+}
+
+// zoneKeyPrefix returns the prefix of the v2 resource record keys of the
+// names at or below the canonical name zone: the marker and the labels of
+// zone in reverse order, without the terminating root label
+func zoneKeyPrefix(zone string) []byte {
+	prefix := []byte(dnsdata.ResourceRecordsKeyMarker)
+	labels := dns.SplitDomainName(zone)
+	for i := len(labels) - 1; i >= 0; i-- {
+		prefix = append(prefix, byte(len(labels[i])))
+		prefix = append(prefix, labels[i]...)
+	}
+	return prefix
+}
+
+// forEachResourceRecord calls fn with every resource record entry of the DB
+// whose owner name is at or below zone, a canonical name, in no particular
+// order, skipping the other keys. Keys in the v2 format are searched by
+// prefix, so only the ones of zone are read from drivers with sorted keys;
+// the whole DB is read otherwise. With splitValues, fn is called with each
+// value of a key.
+func (f *DB) forEachResourceRecord(zone string, splitValues bool, fn func(e resourceRecordEntry) error) error {
+	walker, err := walkerOf(f.dbi)
+	if err != nil {
+		return err
+	}
+	// keys are in the v2 format if the driver can search them in order
+	v2 := f.dbi.ClosestKeyFinder() != nil
+	var prefix []byte
+	if v2 {
+		prefix = zoneKeyPrefix(zone)
+	}
+	return walker.forEachRecord(prefix, splitValues, func(key, value []byte) error {
+		e := resourceRecordEntry{key: key, value: value}
+		var ok bool
+		if v2 {
+			e.labels, e.trailingRoots, ok = parseV2ResourceRecordKey(key)
+		} else {
+			e.labels, e.trailingRoots, ok = parseV1ResourceRecordKey(key)
+		}
+		if !ok {
+			return nil
+		}
+		if !v2 && zone != "." && !dns.IsSubDomain(zone, normalizeOwnerName(e.labels)) {
+			return nil
+		}
+		e.location = resourceRecordKeyLocation(key, e.labels, e.trailingRoots, v2)
+		return fn(e)
+	})
+}
+
+// forEachStoredRecord calls f with every resource record of the DB at or
+// below zone, a canonical name, in no particular order, leaving out the
+// entries which are never served
+func (r *DataReader) forEachStoredRecord(zone string, f func(rec ZoneRecord) error) error {
+	return r.db.forEachResourceRecord(zone, true, func(e resourceRecordEntry) error {
+		value := e.value
+		wildcard := len(value) > 2 && (value[2] == '*' || value[2] == '*'+1)
+		rec, err := ExtractRRFromRow(value, wildcard)
+		if err != nil {
+			return fmt.Errorf("invalid record of %s: %w", presentOwnerName(e.labels, 0), err)
+		}
+		if isPrivateType(rec.Qtype) {
+			return nil
+		}
+		name := presentOwnerName(e.labels, 0)
+		if wildcard {
+			name = dns.Fqdn("*." + name)
+		}
+		rdlength := len(value) - rec.Offset
+		if rdlength > math.MaxUint16 {
+			return fmt.Errorf("invalid record of %s: rdata of %d bytes", name, rdlength)
+		}
+		hdr := dns.RR_Header{Name: name, Rrtype: rec.Qtype, Class: dns.ClassINET, Ttl: rec.TTL, Rdlength: uint16(rdlength)}
+		rr, _, err := dns.UnpackRRWithHeader(hdr, value, rec.Offset)
+		if err != nil {
+			return fmt.Errorf("invalid %s record of %s: %w", dns.TypeToString[rec.Qtype], name, err)
+		}
+		return f(ZoneRecord{
+			RR:       rr,
+			Location: e.location,
+			Weight:   rec.Weight,
+		})
+	})
+}
+
+// zonesAt returns the zones at or below the canonical name zone, i.e. the
+// owner names of the SOA records found there, of any location
+func (r *DataReader) zonesAt(zone string) (map[string]int64, error) {
+	zones := make(map[string]int64)
+	err := r.db.forEachResourceRecord(zone, true, func(e resourceRecordEntry) error {
+		if e.qtype() == dns.TypeSOA {
+			zones[dns.CanonicalName(presentOwnerName(e.labels, 0))] = 0
+		}
+		return nil
+	})
+	return zones, err
+}
+
+// Zones returns the zones of the DB, i.e. the owner names of its SOA
+// records, of any location, sorted. This reads the whole DB, but only
+// unpacks the SOA records.
+func (r *DataReader) Zones() ([]string, error) {
+	seen, err := r.zonesAt(".")
+	if err != nil {
+		return nil, err
+	}
+	zones := make([]string, 0, len(seen))
+	for zone := range seen {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return zones, nil
+}
+
+// ErrZoneNotFound is returned when listing the records of a zone the DB has
+// no SOA record for
+var ErrZoneNotFound = errors.New("zone not found")
+
+// ForEachZoneRecord calls f with every resource record of zone, of every
+// location, in no particular order: the records of the names at or below
+// zone, but the ones of subzones with their own SOA record. Delegations and
+// their glue are part of the zone, the NS and DS records at the apex of its
+// subzones included. f returning an error stops the walk, which returns it.
+// This reads the records below zone twice, with a range scan on drivers with
+// sorted keys, the whole DB twice otherwise.
+func (r *DataReader) ForEachZoneRecord(zone string, f func(rec ZoneRecord) error) error {
+	zone = dns.CanonicalName(zone)
+	zones, err := r.zonesAt(zone)
+	if err != nil {
+		return err
+	}
+	if _, found := zones[zone]; !found {
+		return fmt.Errorf("%w: %s", ErrZoneNotFound, zone)
+	}
+	return r.forEachStoredRecord(zone, func(rec ZoneRecord) error {
+		name := dns.CanonicalName(rec.RR.Header().Name)
+		closest, found := closestZone(name, zones)
+		if !found || (closest != zone && !isZoneCut(rec, name, zone, zones)) {
+			return nil
+		}
+		return f(rec)
+	})
+}
+
+// isZoneCut returns true for the NS and DS records at the apex of a direct
+// subzone of zone, which are served by zone as the delegation to the subzone
+func isZoneCut(rec ZoneRecord, name, zone string, zones map[string]int64) bool {
+	switch rec.RR.Header().Rrtype {
+	case dns.TypeNS, dns.TypeDS:
+	default:
+		return false
+	}
+	if _, found := zones[name]; !found || name == zone {
+		return false
+	}
+	i := strings.IndexByte(name, '.')
+	parent := name[i+1:]
+	if parent == "" {
+		parent = "."
+	}
+	closest, found := closestZone(parent, zones)
+	return found && closest == zone
+}
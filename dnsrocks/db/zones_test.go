@@ -0,0 +1,84 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestZones(t *testing.T) {
+	testDBs := testaid.NewFixture().
+		Zone("example.com", 300, "ns.example.com").
+		Address("www.example.com", "192.0.2.1", 300, "").
+		Address("www.example.com", "2001:db8::1", 300, testaid.Loc(1)).
+		Address("*.wild.example.com", "192.0.2.9", 300, "").
+		Zone("sub.example.com", 300, "ns.example.com").
+		Address("a.sub.example.com", "192.0.2.2", 300, "").
+		NS("deleg.example.com", "ns.deleg.example.com", 300).
+		Address("ns.deleg.example.com", "192.0.2.3", 300, "").
+		TXT("other.test", "not in a zone", 300).
+		Build(t)
+
+	for _, config := range testDBs {
+		t.Run(config.Driver+"/"+config.Flavour, func(t *testing.T) {
+			db, err := Open(config.Path, config.Driver)
+			require.NoError(t, err)
+			defer db.Destroy()
+			r, err := NewReader(db)
+			require.NoError(t, err)
+			defer r.Close()
+
+			zones, err := r.Zones()
+			require.NoError(t, err)
+			require.Equal(t, []string{"example.com.", "sub.example.com."}, zones)
+
+			var records []string
+			err = r.ForEachZoneRecord("Example.COM", func(rec ZoneRecord) error {
+				records = append(records, fmt.Sprintf("%s loc=%v", rec.RR.String(), []byte(rec.Location)))
+				return nil
+			})
+			require.NoError(t, err)
+			sort.Strings(records)
+			require.Equal(t, []string{
+				"*.wild.example.com.\t300\tIN\tA\t192.0.2.9 loc=[0 0]",
+				"deleg.example.com.\t300\tIN\tNS\tns.deleg.example.com. loc=[0 0]",
+				"example.com.\t300\tIN\tNS\tns.example.com. loc=[0 0]",
+				"example.com.\t300\tIN\tSOA\tns.example.com. hostmaster.example.com. 1 7200 1800 604800 300 loc=[0 0]",
+				"ns.deleg.example.com.\t300\tIN\tA\t192.0.2.3 loc=[0 0]",
+				"sub.example.com.\t300\tIN\tNS\tns.example.com. loc=[0 0]",
+				"www.example.com.\t300\tIN\tA\t192.0.2.1 loc=[0 0]",
+				"www.example.com.\t300\tIN\tAAAA\t2001:db8::1 loc=[0 1]",
+			}, records)
+
+			err = r.ForEachZoneRecord("example.net.", func(ZoneRecord) error { return nil })
+			require.True(t, errors.Is(err, ErrZoneNotFound), err)
+
+			stop := errors.New("stop")
+			calls := 0
+			err = r.ForEachZoneRecord("sub.example.com.", func(ZoneRecord) error {
+				calls++
+				return stop
+			})
+			require.Equal(t, stop, err)
+			require.Equal(t, 1, calls)
+		})
+	}
+}
@@ -14,6 +14,7 @@ limitations under the License.
 package cdb
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"io"
 	"os"
@@ -27,7 +28,31 @@ import (
 
 // CreatorOptions provides options to create CDB
 type CreatorOptions struct {
-	NumCPU int
+	NumCPU      int
+	StrictNames bool // reject owner names with bad length, charset or punycode
+	ConvertIDN  bool // convert U-labels in owner names to A-labels
+	// EmptyNonTerminals makes queries for the empty non-terminals of zones
+	// answered with NODATA rather than NXDOMAIN
+	EmptyNonTerminals bool
+	// ReverseZones are the reverse zones whose PTR records are generated from
+	// the A and AAAA records of forward zones
+	ReverseZones []dnsdata.ReverseZone
+	// HTTPSZones are the zones whose names get HTTPS records synthesized
+	// from their A and AAAA records
+	HTTPSZones []dnsdata.HTTPSZone
+	// Serial, if set, is the SOA serial of records that do not set one, instead
+	// of the one derived from the modification time of the input, so that the
+	// output only depends on the input data
+	Serial uint32
+	// Duplicates is what to do with records written more than once in the input
+	Duplicates dnsdata.DuplicatePolicy
+	// Version, if set, is stored with the serial under dnsdata.ProvenanceKey
+	Version string
+	// Checksum stores the checksum of the DB under dnsdata.ChecksumKey
+	Checksum bool
+	// SigningKey, if set, signs the checksum, which is then stored whatever
+	// Checksum
+	SigningKey ed25519.PrivateKey
 }
 
 // NewDefaultCreatorOptions gives default options
@@ -39,18 +64,25 @@ func NewDefaultCreatorOptions() *CreatorOptions {
 
 // CreateCDB compiles CDB with native Go compiler
 func CreateCDB(ipath string, opath string, options *CreatorOptions) (mw int, err error) {
+	return CreateCDBFromInputs([]string{ipath}, opath, options)
+}
+
+// CreateCDBFromInputs compiles the data files and directories of ipaths, in
+// order, into a CDB, see dnsdata.Input
+func CreateCDBFromInputs(ipaths []string, opath string, options *CreatorOptions) (mw int, err error) {
 	if options == nil {
 		options = NewDefaultCreatorOptions()
 	}
-	// Open infile for read
-	ifile, err := os.Open(ipath)
+	in, err := dnsdata.NewInput(ipaths, options.Duplicates)
 	if err != nil {
 		return 0, fmt.Errorf("can't open input file: %w", err)
 	}
-	defer ifile.Close()
-	serial, err := dnsdata.DeriveSerial(ifile)
-	if err != nil {
-		return 0, fmt.Errorf("can't stat input file: %w", err)
+	defer in.Close()
+	serial := options.Serial
+	if serial == 0 {
+		if serial, err = dnsdata.DeriveInputSerial(ipaths); err != nil {
+			return 0, fmt.Errorf("can't stat input file: %w", err)
+		}
 	}
 
 	// cleanup partially written db in case of failure
@@ -66,11 +98,16 @@ func CreateCDB(ipath string, opath string, options *CreatorOptions) (mw int, err
 	}
 	defer db.Close()
 
-	return CreateCDBFromReader(ifile, db, serial, options.NumCPU)
+	return createCDBFromReader(in, db, serial, options)
 }
 
 // CreateCDBFromReader compiles CDB with native Go compiler, reading data from io.ReadCloser
 func CreateCDBFromReader(r io.Reader, db cdb.Writer, serial uint32, workers int) (nw int, err error) {
+	return createCDBFromReader(r, db, serial, &CreatorOptions{NumCPU: workers})
+}
+
+func createCDBFromReader(r io.Reader, db cdb.Writer, serial uint32, options *CreatorOptions) (nw int, err error) {
+	workers := options.NumCPU
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("recovered panic while writing CDB: %v", r)
@@ -80,6 +117,11 @@ func CreateCDBFromReader(r io.Reader, db cdb.Writer, serial uint32, workers int)
 	// Initialize the codec
 	codec := new(dnsdata.Codec)
 	codec.Serial = serial
+	codec.StrictNames = options.StrictNames
+	codec.ConvertIDN = options.ConvertIDN
+	codec.EmptyNonTerminals = options.EmptyNonTerminals
+	codec.ReverseZones = options.ReverseZones
+	codec.HTTPSZones = options.HTTPSZones
 
 	// will be closed by ParseParallelStream
 	resultsChan := make(chan []dnsdata.MapRecord, workers)
@@ -88,6 +130,10 @@ func CreateCDBFromReader(r io.Reader, db cdb.Writer, serial uint32, workers int)
 	g.Go(func() error {
 		return dnsdata.ParseStream(r, codec, resultsChan, workers)
 	})
+	var hasher *dnsdata.ChecksumHasher
+	if options.Checksum || options.SigningKey != nil {
+		hasher = dnsdata.NewChecksumHasher()
+	}
 	nw = 0
 	for v := range resultsChan {
 		for _, m := range v {
@@ -95,11 +141,29 @@ func CreateCDBFromReader(r io.Reader, db cdb.Writer, serial uint32, workers int)
 			if err != nil {
 				return nw, err
 			}
+			if hasher != nil {
+				hasher.Add(m.Key, m.Value)
+			}
 			nw++
 		}
 	}
 	if err := g.Wait(); err != nil {
 		return nw, fmt.Errorf("can't create output database: %w", err)
 	}
+	if options.Version != "" {
+		r := (&dnsdata.Provenance{Version: options.Version, Serial: serial}).MapRecord()
+		if err := db.Put(r.Key, r.Value); err != nil {
+			return nw, err
+		}
+		if hasher != nil {
+			hasher.Add(r.Key, r.Value)
+		}
+	}
+	if hasher != nil {
+		sum, _ := hasher.Sum(options.SigningKey).MarshalText()
+		if err := db.Put([]byte(dnsdata.ChecksumKey), sum); err != nil {
+			return nw, err
+		}
+	}
 	return nw, nil
 }
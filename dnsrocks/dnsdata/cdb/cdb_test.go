@@ -19,7 +19,9 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 
+	"github.com/facebook/dns/dnsrocks/dnsdata"
 	"github.com/facebook/dns/dnsrocks/testutils"
 
 	"github.com/stretchr/testify/require"
@@ -60,3 +62,68 @@ func TestCreateCDB(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateCDBReproducible(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cdb-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	input, err := os.ReadFile(getData("data.nets"))
+	require.NoError(t, err)
+	// an SOA record without serial
+	input = append(input, "Zexample.info,a.ns.example.info,dns.example.info,,7200,1800,604800,120,120,,\n"...)
+	build := func(name string, numCPU int, mtime time.Time, serial uint32) []byte {
+		ipath := path.Join(tmpDir, name)
+		require.NoError(t, os.WriteFile(ipath, input, 0o644))
+		require.NoError(t, os.Chtimes(ipath, mtime, mtime))
+		opath := ipath + ".cdb"
+		_, err := CreateCDB(ipath, opath, &CreatorOptions{NumCPU: numCPU, Serial: serial})
+		require.NoError(t, err)
+		out, err := os.ReadFile(opath)
+		require.NoError(t, err)
+		return out
+	}
+
+	expected := build("data1", 1, time.Unix(1000000000, 0), 42)
+	require.Equal(t, expected, build("data2", 4, time.Unix(1700000000, 0), 42), "output depends on input only")
+	// like tinydns-data, the serial is derived from the modification time by default
+	require.NotEqual(t, build("data3", 1, time.Unix(1000000000, 0), 0), build("data4", 1, time.Unix(1700000000, 0), 0))
+}
+
+// TestCreateCDBFromInputs checks that data split across files compiles to
+// the same CDB as the concatenated data
+func TestCreateCDBFromInputs(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name, data string) string {
+		p := path.Join(tmpDir, name)
+		require.NoError(t, os.MkdirAll(path.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(data), 0o644))
+		return p
+	}
+	build := func(ipaths []string, duplicates dnsdata.DuplicatePolicy) ([]byte, error) {
+		opath := path.Join(tmpDir, "out.cdb")
+		if _, err := CreateCDBFromInputs(ipaths, opath, &CreatorOptions{NumCPU: 1, Duplicates: duplicates}); err != nil {
+			return nil, err
+		}
+		return os.ReadFile(opath)
+	}
+
+	soa := "Zexample.com,ns.example.com,dns.example.com,,,,,,\n"
+	hosts := "+a.example.com,192.0.2.1\n+b.example.com,192.0.2.2\n"
+	expected, err := build([]string{write("all.data", soa+hosts)}, dnsdata.DuplicatesKeep)
+	require.NoError(t, err)
+
+	zone := write("zone.data", soa+"$INCLUDE teams\n")
+	write("teams/a.data", "+a.example.com,192.0.2.1\n")
+	write("teams/b.data", "+b.example.com,192.0.2.2\n")
+	out, err := build([]string{zone}, dnsdata.DuplicatesKeep)
+	require.NoError(t, err)
+	require.Equal(t, expected, out)
+
+	dup := write("dup.data", "+a.example.com,192.0.2.1\n")
+	out, err = build([]string{zone, dup}, dnsdata.DuplicatesDrop)
+	require.NoError(t, err)
+	require.Equal(t, expected, out)
+	_, err = build([]string{zone, dup}, dnsdata.DuplicatesError)
+	require.ErrorContains(t, err, "duplicate")
+}
@@ -0,0 +1,202 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+)
+
+// ChecksumKey is a special key storing the Checksum of the other keys and
+// values of a DB
+const ChecksumKey = "\x00o_checksum"
+
+// ErrChecksumMismatch is returned when the content of a DB does not match its
+// checksum, or the checksum its signature
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Checksum is the SHA-256 digest of the content of a compiled DB, optionally
+// signed with Ed25519, which identifies the artifact a server runs.
+// The digest covers the keys and values of the DB as the driver stores them,
+// ChecksumKey excepted, see ChecksumHasher.
+type Checksum struct {
+	Digest    []byte
+	Signature []byte // Ed25519 signature of Digest, if signed
+}
+
+// String returns the hex digest, the form logged and served
+func (c *Checksum) String() string {
+	return hex.EncodeToString(c.Digest)
+}
+
+// MarshalText implements encoding.TextMarshaler, as
+// "sha256=<hex digest>[ ed25519=<base64 signature>]"
+func (c *Checksum) MarshalText() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("sha256=")
+	b.WriteString(hex.EncodeToString(c.Digest))
+	if len(c.Signature) > 0 {
+		b.WriteString(" ed25519=")
+		b.WriteString(base64.StdEncoding.EncodeToString(c.Signature))
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (c *Checksum) UnmarshalText(text []byte) error {
+	*c = Checksum{}
+	for _, field := range strings.Fields(string(text)) {
+		name, value, _ := strings.Cut(field, "=")
+		var err error
+		switch name {
+		case "sha256":
+			c.Digest, err = hex.DecodeString(value)
+			if err == nil && len(c.Digest) != sha256.Size {
+				err = fmt.Errorf("bad digest length %d", len(c.Digest))
+			}
+		case "ed25519":
+			c.Signature, err = base64.StdEncoding.DecodeString(value)
+			if err == nil && len(c.Signature) != ed25519.SignatureSize {
+				err = fmt.Errorf("bad signature length %d", len(c.Signature))
+			}
+		default:
+			err = fmt.Errorf("unknown field %q", name)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid checksum %q: %w", text, err)
+		}
+	}
+	if c.Digest == nil {
+		return fmt.Errorf("invalid checksum %q: no digest", text)
+	}
+	return nil
+}
+
+// Verify checks that the checksum is signed by one of keys. It succeeds
+// without keys, signed or not.
+func (c *Checksum) Verify(keys []ed25519.PublicKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if len(c.Signature) == 0 {
+		return fmt.Errorf("%w: checksum %s is not signed", ErrChecksumMismatch, c)
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, c.Digest, c.Signature) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: checksum %s is not signed by a trusted key", ErrChecksumMismatch, c)
+}
+
+// ChecksumHasher computes the Checksum of the keys and values of a DB, in the
+// order the DB stores them: the order records are written in for CDB, key
+// order for RocksDB, so that verifying a DB streams through it in the same
+// order as the compiler. The digest is the SHA-256 of every key and value
+// pair, ChecksumKey excepted, as the lengths and bytes of the key and of the
+// value, lengths as unsigned varints.
+type ChecksumHasher struct {
+	h   hash.Hash
+	buf [binary.MaxVarintLen64]byte
+}
+
+// NewChecksumHasher returns an empty ChecksumHasher
+func NewChecksumHasher() *ChecksumHasher {
+	return &ChecksumHasher{h: sha256.New()}
+}
+
+func (c *ChecksumHasher) write(b []byte) {
+	n := binary.PutUvarint(c.buf[:], uint64(len(b)))
+	c.h.Write(c.buf[:n])
+	c.h.Write(b)
+}
+
+// Add hashes a key and its value, unless the key is ChecksumKey
+func (c *ChecksumHasher) Add(key, value []byte) {
+	if string(key) == ChecksumKey {
+		return
+	}
+	c.write(key)
+	c.write(value)
+}
+
+// Sum returns the Checksum of what was added, signed with key if not nil
+func (c *ChecksumHasher) Sum(key ed25519.PrivateKey) *Checksum {
+	sum := &Checksum{Digest: c.h.Sum(nil)}
+	if key != nil {
+		sum.Signature = ed25519.Sign(key, sum.Digest)
+	}
+	return sum
+}
+
+// readPEM returns the DER bytes of the PEM block of type blockType in the file
+// at path
+func readPEM(path, blockType string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s: no %s PEM block", path, blockType)
+	}
+	return block.Bytes, nil
+}
+
+// LoadSigningKey reads the PKCS #8 PEM Ed25519 private key at path, as
+// written by "openssl genpkey -algorithm ed25519"
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return edKey, nil
+}
+
+// LoadVerifyKey reads the PKIX PEM Ed25519 public key at path, as written by
+// "openssl pkey -pubout"
+func LoadVerifyKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return edKey, nil
+}
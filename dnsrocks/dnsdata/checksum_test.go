@@ -0,0 +1,113 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumHasher(t *testing.T) {
+	pairs := [][2]string{{"a", "1"}, {"b", "2"}, {"a", "3"}, {"ab", ""}}
+	sum := func(order []int) *Checksum {
+		h := NewChecksumHasher()
+		for _, i := range order {
+			h.Add([]byte(pairs[i][0]), []byte(pairs[i][1]))
+		}
+		return h.Sum(nil)
+	}
+	expected := sum([]int{0, 1, 2, 3})
+	require.Equal(t, expected, sum([]int{0, 1, 2, 3}))
+	require.NotEqual(t, expected, sum([]int{3, 2, 1, 0}), "order matters")
+	require.NotEqual(t, expected, sum([]int{0, 1, 2}))
+	require.NotEqual(t, expected, sum([]int{0, 1, 2, 3, 3}), "duplicates count")
+
+	// the checksum key is ignored, and keys and values are delimited
+	h := NewChecksumHasher()
+	for _, p := range pairs {
+		h.Add([]byte(p[0]), []byte(p[1]))
+	}
+	h.Add([]byte(ChecksumKey), []byte("sha256=00"))
+	require.Equal(t, expected, h.Sum(nil))
+	h = NewChecksumHasher()
+	h.Add([]byte("a"), []byte("b"))
+	other := NewChecksumHasher()
+	other.Add([]byte("ab"), nil)
+	require.NotEqual(t, h.Sum(nil), other.Sum(nil))
+}
+
+func TestChecksumText(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	h := NewChecksumHasher()
+	h.Add([]byte("key"), []byte("value"))
+	for _, key := range []ed25519.PrivateKey{nil, priv} {
+		sum := h.Sum(key)
+		text, err := sum.MarshalText()
+		require.NoError(t, err)
+		parsed := new(Checksum)
+		require.NoError(t, parsed.UnmarshalText(text))
+		require.Equal(t, sum, parsed)
+		require.Equal(t, sum.String(), parsed.String())
+		require.NoError(t, parsed.Verify(nil))
+		if key == nil {
+			require.ErrorIs(t, parsed.Verify([]ed25519.PublicKey{pub}), ErrChecksumMismatch)
+		} else {
+			require.NoError(t, parsed.Verify([]ed25519.PublicKey{pub}))
+		}
+	}
+
+	other, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	require.ErrorIs(t, h.Sum(priv).Verify([]ed25519.PublicKey{other}), ErrChecksumMismatch)
+
+	for _, text := range []string{"", "sha256=00", "sha256=zz", "md5=00", "ed25519=AAAA"} {
+		require.Error(t, new(Checksum).UnmarshalText([]byte(text)), text)
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	write := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+		return path
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	privPath := write("key.pem", "PRIVATE KEY", der)
+	der, err = x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	pubPath := write("key.pub", "PUBLIC KEY", der)
+
+	loadedPriv, err := LoadSigningKey(privPath)
+	require.NoError(t, err)
+	require.Equal(t, priv, loadedPriv)
+	loadedPub, err := LoadVerifyKey(pubPath)
+	require.NoError(t, err)
+	require.Equal(t, pub, loadedPub)
+
+	_, err = LoadSigningKey(pubPath)
+	require.Error(t, err)
+	_, err = LoadVerifyKey(privPath)
+	require.Error(t, err)
+}
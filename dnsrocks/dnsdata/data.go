@@ -17,6 +17,7 @@ import (
 	"bytes"
 	"encoding"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -65,6 +66,9 @@ type Accum struct {
 	v4prefixset  big.Int
 	v6prefixset  big.Int
 	Ranger       SubnetRanger
+	owners       ownerNames
+	reverse      reverseData
+	https        httpsData
 	mux          sync.Mutex
 }
 
@@ -80,6 +84,17 @@ type Codec struct {
 	Acc          Accum     // a meta-record which represents an accumulated state over the whole data set
 	NoRnetOutput bool      // if set, disables Rnet ("%"-records) output in the output - use with Acc.Ranger.Enable()
 	Features     Rfeatures // a meta-record with features supported by generated DB
+	StrictNames  bool      // if set, owner names are checked for length, charset and punycode round-trip
+	ConvertIDN   bool      // if set, U-labels in owner names are converted to A-labels
+	// if set, markers are emitted for the empty non-terminals of zones, see
+	// TypeENT
+	EmptyNonTerminals bool
+	// reverse zones whose PTR records are generated from the A and AAAA
+	// records of forward zones, see ReverseZone
+	ReverseZones []ReverseZone
+	// zones whose names get HTTPS records synthesized from their A and AAAA
+	// records, see HTTPSZone
+	HTTPSZones []HTTPSZone
 }
 
 // rshared is a struct with fields are available to the most of record types
@@ -182,6 +197,55 @@ type Rcname struct {
 	c     *Codec
 }
 
+// Ralias is A → ALIAS, answered with the addresses of its target
+type Ralias struct {
+	rshared
+	target []byte // the name whose addresses are served
+	c      *Codec
+}
+
+// Rttl is T → TTL override of the records of a domain for a location
+type Rttl struct {
+	rshared
+	c *Codec
+}
+
+// Rds is K → DS, the digest of a key of a child zone served at its
+// delegation point
+type Rds struct {
+	rshared
+	keytag     uint16
+	algorithm  uint8
+	digesttype uint8
+	digest     []byte
+	c          *Codec
+}
+
+// Rdualstack is D → dual-stack answer policy of a domain, or of a zone when
+// set at its apex, for a location
+type Rdualstack struct {
+	rshared
+	policy DualStackPolicy
+	c      *Codec
+}
+
+// Rmeta is N → opaque metadata about the records of a domain (owner team,
+// ticket, source...), stored in the DB for debugging but never served
+type Rmeta struct {
+	dom        []byte
+	iswildcard bool
+	meta       []byte
+	c          *Codec
+}
+
+// Rfallback is L → the locations whose records are served, in order, for
+// the names without records of their own in a location
+type Rfallback struct {
+	lo        Loc
+	fallbacks []Loc
+	c         *Codec
+}
+
 // Rtxt is ' → TXT
 type Rtxt struct {
 	rshared
@@ -230,6 +294,9 @@ type Rfeatures struct {
 	//  - uses \x00o prefix for owner names
 	//  - location is added as suffix to owner names, not as prefix
 	UseV2Keys bool
+	// if true, markers are stored for every empty non-terminal, see
+	// Codec.EmptyNonTerminals
+	EmptyNonTerminals bool
 }
 
 // Rsvcb is SVCB (service binding) record
@@ -278,13 +345,54 @@ const (
 	TypeAAAA WireType = 28
 	// TypeSRV represents SRV record type
 	TypeSRV WireType = 33
+	// TypeDS represents DS record type
+	TypeDS WireType = 43
 	// TypeSVCB represents SVCB record type
 	// for SVCB/HTTPS, see https://datatracker.ietf.org/doc/html/draft-ietf-dnsop-svcb-https-08
 	TypeSVCB WireType = 64
 	// TypeHTTPS represents HTTPS record type
 	TypeHTTPS WireType = 65
+	// TypeALIAS represents ALIAS record type, from the private use range. It is
+	// never served, queries for addresses get the ones of its target instead.
+	TypeALIAS WireType = 65401
+	// TypeTTL represents TTL override record type, from the private use range.
+	// It is never served, it overrides the TTLs of the records of its domain.
+	TypeTTL WireType = 65402
+	// TypeDualStack represents dual-stack policy record type, from the private
+	// use range. It is never served, it filters the A/AAAA records answered.
+	TypeDualStack WireType = 65403
+	// TypeENT represents empty non-terminal marker record type, from the private
+	// use range. It is never served, it makes its name exist without records.
+	TypeENT WireType = 65404
 )
 
+// DualStackPolicy controls which address families are answered
+type DualStackPolicy byte
+
+// Dual-stack policies
+const (
+	// DualStackV4Only suppresses AAAA answers
+	DualStackV4Only DualStackPolicy = 1
+	// DualStackV6Only suppresses A answers
+	DualStackV6Only DualStackPolicy = 2
+)
+
+func (p DualStackPolicy) String() string {
+	switch p {
+	case DualStackV4Only:
+		return "v4only"
+	case DualStackV6Only:
+		return "v6only"
+	}
+	return fmt.Sprintf("%d", byte(p))
+}
+
+// Suppresses returns true if answers of type qtype are suppressed by p
+func (p DualStackPolicy) Suppresses(qtype uint16) bool {
+	return (p == DualStackV4Only && qtype == uint16(TypeAAAA)) ||
+		(p == DualStackV6Only && qtype == uint16(TypeA))
+}
+
 func (m Lmap) String() string {
 	var builder strings.Builder
 	Putlmaptext(&builder, m)
@@ -311,10 +419,20 @@ func (w WireType) String() string {
 		return "AAAA"
 	case TypeSRV:
 		return "SRV"
+	case TypeDS:
+		return "DS"
 	case TypeSVCB:
 		return "SVCB"
 	case TypeHTTPS:
 		return "HTTPS"
+	case TypeALIAS:
+		return "ALIAS"
+	case TypeTTL:
+		return "TTL"
+	case TypeDualStack:
+		return "DUALSTACK"
+	case TypeENT:
+		return "ENT"
 	}
 
 	return fmt.Sprintf("%d", w)
@@ -340,6 +458,12 @@ const (
 	prefixRangePoint Rtype = "!"
 	prefixSVCB       Rtype = "B"
 	prefixHTTPS      Rtype = "H"
+	prefixALIAS      Rtype = "A"
+	prefixTTL        Rtype = "T"
+	prefixMeta       Rtype = "N"
+	prefixDualStack  Rtype = "D"
+	prefixDS         Rtype = "K"
+	prefixFallback   Rtype = "L"
 )
 
 func decodeRtype(text []byte) Rtype {
@@ -385,6 +509,18 @@ func (c *Codec) newRecord(t Rtype) (Record, error) {
 		return &Rsvcb{c: c, wtype: TypeSVCB}, nil
 	case prefixHTTPS:
 		return &Rhttps{c: c, wtype: TypeHTTPS}, nil
+	case prefixALIAS:
+		return &Ralias{c: c}, nil
+	case prefixTTL:
+		return &Rttl{c: c}, nil
+	case prefixMeta:
+		return &Rmeta{c: c}, nil
+	case prefixDualStack:
+		return &Rdualstack{c: c}, nil
+	case prefixDS:
+		return &Rds{c: c}, nil
+	case prefixFallback:
+		return &Rfallback{c: c}, nil
 	}
 	return nil, ErrBadRType
 }
@@ -411,6 +547,11 @@ func (c *Codec) DecodeLn(text []byte) (Record, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = c.Acc.addOwners(r, c); err != nil {
+		return nil, err
+	}
+	c.Acc.addReverse(r, c)
+	c.Acc.addHTTPS(r, c)
 	return r, nil
 }
 
@@ -548,6 +689,12 @@ const (
 	FeaturesKey = "\x00o_features"
 	// ResourceRecordsKeyMarker is the prefix for the resource record keys
 	ResourceRecordsKeyMarker = "\000o"
+	// MetadataKeyMarker is the prefix for the record metadata keys, followed
+	// by the packed lower case domain whatever the key format
+	MetadataKeyMarker = "\000\000\000N"
+	// LocationFallbackKeyMarker is the prefix for the location fallback keys,
+	// followed by the location whatever the key format
+	LocationFallbackKeyMarker = "\000\000\000L"
 )
 
 // Feature is a bitmap representing different characteristics of DB data
@@ -564,6 +711,10 @@ const (
 	//  - uses \x00o prefix for owner names
 	//  - location is added as suffix to owner names, not as prefix
 	V2KeysFeature
+	// EmptyNonTerminalsFeature if set in features key means markers are
+	// stored for every empty non-terminal of the zones, not only for the ones
+	// above delegations, see Codec.EmptyNonTerminals
+	EmptyNonTerminalsFeature
 )
 
 // UnmarshalText implements encoding.TextUnmarshaler
@@ -1294,6 +1445,238 @@ func (r *Rcname) MarshalMap() ([]MapRecord, error) {
 	return m, nil
 }
 
+// UnmarshalText implements encoding.TextUnmarshaler
+func (r *Ralias) UnmarshalText(text []byte) error {
+	r.loadDefaults()
+	f := fields(text)
+	r.dom, r.iswildcard = getdom(f[0])
+	r.target, _ = quote.Bunquote(f[1]) // BUG: handle error
+	getuint32(f[2], &r.ttl)
+	// f[3] ignored
+	var err error
+	r.lo, err = getloc(f[4])
+	return err
+}
+
+func (r *Ralias) loadDefaults() {
+	r.ttl = LongTTL
+}
+
+// MarshalMap implements MapMarshaler
+func (r *Ralias) MarshalMap() ([]MapRecord, error) {
+	var err error
+	var k []byte
+	if k, err = makedomainkey(r.dom, r.lo, r.c); err != nil {
+		return nil, err
+	}
+	v := new(bytes.Buffer)
+	if err = putrrhead(v, TypeALIAS, r.ttl, r.lo, r.iswildcard); err != nil {
+		return nil, err
+	}
+	// the target is looked up as is, like query names
+	putdom(v, bytes.ToLower(r.target))
+	m := []MapRecord{{Key: k, Value: v.Bytes()}}
+	return m, nil
+}
+
+// ErrMissingTTL is returned when a TTL override record has no TTL
+var ErrMissingTTL = errors.New("missing TTL")
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (r *Rttl) UnmarshalText(text []byte) error {
+	f := fields(text)
+	r.dom, r.iswildcard = getdom(f[0])
+	if len(f[1]) == 0 {
+		return ErrMissingTTL
+	}
+	getuint32(f[1], &r.ttl)
+	// f[2] ignored
+	var err error
+	r.lo, err = getloc(f[3])
+	return err
+}
+
+// MarshalMap implements MapMarshaler
+func (r *Rttl) MarshalMap() ([]MapRecord, error) {
+	var err error
+	var k []byte
+	if k, err = makedomainkey(r.dom, r.lo, r.c); err != nil {
+		return nil, err
+	}
+	v := new(bytes.Buffer)
+	// the TTL of the header is the override, there is no rdata
+	if err = putrrhead(v, TypeTTL, r.ttl, r.lo, r.iswildcard); err != nil {
+		return nil, err
+	}
+	m := []MapRecord{{Key: k, Value: v.Bytes()}}
+	return m, nil
+}
+
+// ErrBadDSDigest is returned when a DS record has no digest, or one that is
+// not in hexadecimal
+var ErrBadDSDigest = errors.New("bad DS digest, expected hexadecimal")
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (r *Rds) UnmarshalText(text []byte) error {
+	r.loadDefaults()
+	f := fields(text)
+	r.dom, _ = quote.Bunquote(f[0]) // BUG: handle error
+	getuint16(f[1], &r.keytag)
+	getuint8(f[2], &r.algorithm)
+	getuint8(f[3], &r.digesttype)
+	var err error
+	if r.digest, err = hex.DecodeString(string(f[4])); err != nil || len(r.digest) == 0 {
+		return ErrBadDSDigest
+	}
+	getuint32(f[5], &r.ttl)
+	// f[6] ignored
+	r.lo, err = getloc(f[7])
+	return err
+}
+
+func (r *Rds) loadDefaults() {
+	r.ttl = LongTTL
+}
+
+// MarshalMap implements MapMarshaler
+func (r *Rds) MarshalMap() ([]MapRecord, error) {
+	var err error
+	var k []byte
+	if k, err = makedomainkey(r.dom, r.lo, r.c); err != nil {
+		return nil, err
+	}
+	v := new(bytes.Buffer)
+	if err = putrrhead(v, TypeDS, r.ttl, r.lo, false); err != nil {
+		return nil, err
+	}
+	if err = binary.Write(v, binary.BigEndian, r.keytag); err != nil {
+		return nil, err
+	}
+	v.WriteByte(r.algorithm)
+	v.WriteByte(r.digesttype)
+	v.Write(r.digest)
+	m := []MapRecord{{Key: k, Value: v.Bytes()}}
+	return m, nil
+}
+
+// ErrBadDualStackPolicy is returned when a dual-stack policy record has an
+// unknown policy
+var ErrBadDualStackPolicy = errors.New("bad dual-stack policy, expected v4only or v6only")
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (r *Rdualstack) UnmarshalText(text []byte) error {
+	f := fields(text)
+	r.dom, r.iswildcard = getdom(f[0])
+	switch string(f[1]) {
+	case "v4only":
+		r.policy = DualStackV4Only
+	case "v6only":
+		r.policy = DualStackV6Only
+	default:
+		return ErrBadDualStackPolicy
+	}
+	// f[2] ignored
+	var err error
+	r.lo, err = getloc(f[3])
+	return err
+}
+
+// MarshalMap implements MapMarshaler
+func (r *Rdualstack) MarshalMap() ([]MapRecord, error) {
+	var err error
+	var k []byte
+	if k, err = makedomainkey(r.dom, r.lo, r.c); err != nil {
+		return nil, err
+	}
+	v := new(bytes.Buffer)
+	if err = putrrhead(v, TypeDualStack, 0, r.lo, r.iswildcard); err != nil {
+		return nil, err
+	}
+	v.WriteByte(byte(r.policy))
+	m := []MapRecord{{Key: k, Value: v.Bytes()}}
+	return m, nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (r *Rmeta) UnmarshalText(text []byte) error {
+	f := fields(text)
+	r.dom, r.iswildcard = getdom(f[0])
+	var err error
+	r.meta, err = quote.Bunquote(f[1])
+	return err
+}
+
+// MarshalMap implements MapMarshaler
+func (r *Rmeta) MarshalMap() ([]MapRecord, error) {
+	dom := r.dom
+	if r.iswildcard {
+		dom = append([]byte("*."), dom...)
+	}
+	return []MapRecord{{Key: MetadataKey(dom), Value: r.meta}}, nil
+}
+
+// MetadataKey returns the key of the metadata of domain, wildcard included
+func MetadataKey(domain []byte) []byte {
+	k := new(bytes.Buffer)
+	k.WriteString(MetadataKeyMarker)
+	putdom(k, bytes.ToLower(domain))
+	return k.Bytes()
+}
+
+// ErrBadFallback is returned when a location fallback record has no fallback,
+// or falls back to the default location or to its own
+var ErrBadFallback = errors.New("bad location fallback, expected other non-default locations")
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (r *Rfallback) UnmarshalText(text []byte) error {
+	f := fields(text)
+	var err error
+	if r.lo, err = getloc(f[0]); err != nil {
+		return err
+	}
+	if len(r.lo) == 0 || bytes.Equal(r.lo, []byte{0, 0}) {
+		return fmt.Errorf("%w: no location", ErrBadFallback)
+	}
+	r.fallbacks = nil
+	for _, b := range f[1:] {
+		if len(b) == 0 {
+			continue
+		}
+		// more fallbacks than fields
+		if bytes.Contains(b, SEP) || bytes.Contains(b, NSEP) {
+			return fmt.Errorf("%w: at most %d fallbacks", ErrBadFallback, NUMFIELDS-1)
+		}
+		lo, err := getloc(b)
+		if err != nil {
+			return err
+		}
+		if len(lo) == 0 || bytes.Equal(lo, []byte{0, 0}) || bytes.Equal(lo, r.lo) {
+			return fmt.Errorf("%w: %q", ErrBadFallback, b)
+		}
+		r.fallbacks = append(r.fallbacks, lo)
+	}
+	if len(r.fallbacks) == 0 {
+		return fmt.Errorf("%w: no fallback", ErrBadFallback)
+	}
+	return nil
+}
+
+// MarshalMap implements MapMarshaler
+func (r *Rfallback) MarshalMap() ([]MapRecord, error) {
+	k := new(bytes.Buffer)
+	k.WriteString(LocationFallbackKeyMarker)
+	if err := putloc(k, r.lo); err != nil {
+		return nil, err
+	}
+	v := new(bytes.Buffer)
+	for _, lo := range r.fallbacks {
+		if err := putloc(v, lo); err != nil {
+			return nil, err
+		}
+	}
+	return []MapRecord{{Key: k.Bytes(), Value: v.Bytes()}}, nil
+}
+
 // UnmarshalText implements encoding.TextUnmarshaler
 func (r *Rptr) UnmarshalText(text []byte) error {
 	r.loadDefaults()
@@ -1332,10 +1715,12 @@ func (r *Rtxt) UnmarshalText(text []byte) error {
 	r.loadDefaults()
 	f := fields(text)
 	r.dom, r.iswildcard = getdom(f[0])
-	r.txt, _ = quote.Bunquote(f[1]) // BUG: handle error
+	var err error
+	if r.txt, err = unquoteData(f[1]); err != nil {
+		return fmt.Errorf("TXT data: %w", err)
+	}
 	getuint32(f[2], &r.ttl)
 	// f[3] ignored
-	var err error
 	r.lo, err = getloc(f[4])
 	return err
 }
@@ -1375,11 +1760,13 @@ func (r *Raux) UnmarshalText(text []byte) error {
 	r.dom, _ = quote.Bunquote(f[0]) // BUG: handle error
 	var rtype uint32
 	getuint32(f[1], &rtype)
-	r.rtype = WireType(rtype)         // BUG validate input
-	r.rdata, _ = quote.Bunquote(f[2]) // BUG: handle error
+	r.rtype = WireType(rtype) // BUG validate input
+	var err error
+	if r.rdata, err = unquoteData(f[2]); err != nil {
+		return fmt.Errorf("AUX rdata: %w", err)
+	}
 	getuint32(f[3], &r.ttl)
 	// f[4] ignored
-	var err error
 	r.lo, err = getloc(f[5])
 	return err
 }
@@ -1414,7 +1801,10 @@ func (r *Ripmap) UnmarshalText(text []byte) error {
 
 // MarshalMap implements MapMarshaler
 func (r *Ripmap) MarshalMap() ([]MapRecord, error) {
-	k := makemapkey([]byte("\000M"), r.dom, r.c)
+	k, err := makemapkey([]byte("\000M"), r.dom, r.c)
+	if err != nil {
+		return nil, err
+	}
 	v := new(bytes.Buffer) // BUG scale
 	putlmap(v, r.lmap)
 
@@ -1431,7 +1821,10 @@ func (r *Rcsmap) UnmarshalText(text []byte) error {
 
 // MarshalMap implements MapMarshaler
 func (r *Rcsmap) MarshalMap() ([]MapRecord, error) {
-	k := makemapkey([]byte("\0008"), r.dom, r.c)
+	k, err := makemapkey([]byte("\0008"), r.dom, r.c)
+	if err != nil {
+		return nil, err
+	}
 	v := new(bytes.Buffer) // BUG scale
 	putlmap(v, r.lmap)
 
@@ -1447,6 +1840,9 @@ func (r *Rfeatures) MarshalMap() ([]MapRecord, error) {
 	} else {
 		features |= V1KeysFeature
 	}
+	if r.EmptyNonTerminals {
+		features |= EmptyNonTerminalsFeature
+	}
 
 	return []MapRecord{{Key: []byte(FeaturesKey), Value: encodeFeatures(features)}}, nil
 }
@@ -1685,8 +2081,26 @@ func Putlmaptext(w io.Writer, m Lmap) {
 	}
 }
 
+// unquoteData unquotes the data of TXT and AUX records, which can be large,
+// reporting malformed escape sequences with their offset in the field
+func unquoteData(b []byte) ([]byte, error) {
+	if bytes.IndexByte(b, '\\') < 0 {
+		return b, nil
+	}
+	var out bytes.Buffer
+	out.Grow(len(b))
+	if _, err := out.ReadFrom(quote.NewDecoder(bytes.NewReader(b))); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
 func putquotedtext(w io.Writer, b []byte) {
-	_, err := w.Write(quote.Bquote(b))
+	e := quote.NewEncoder(w)
+	_, err := e.Write(b)
+	if err == nil {
+		err = e.Close()
+	}
 	if err != nil {
 		glog.Errorf("%v", err)
 	}
@@ -1770,6 +2184,11 @@ func Reverseaddr(ip net.IP) []byte {
 }
 
 func makedomainkey(domain []byte, lo Loc, codec *Codec) ([]byte, error) {
+	domain, err := codec.checkName(domain)
+	if err != nil {
+		return nil, err
+	}
+
 	k := new(bytes.Buffer) // BUG scale
 	k.Grow(len(domain) + 2)
 
@@ -1791,7 +2210,12 @@ func makedomainkey(domain []byte, lo Loc, codec *Codec) ([]byte, error) {
 	return k.Bytes(), nil
 }
 
-func makemapkey(mapID, domain []byte, codec *Codec) []byte {
+func makemapkey(mapID, domain []byte, codec *Codec) ([]byte, error) {
+	domain, err := codec.checkName(domain)
+	if err != nil {
+		return nil, err
+	}
+
 	k := new(bytes.Buffer) // BUG scale
 	k.Write(mapID)
 
@@ -1812,5 +2236,5 @@ func makemapkey(mapID, domain []byte, codec *Codec) []byte {
 
 	k.WriteString(suffix)
 
-	return k.Bytes()
+	return k.Bytes(), nil
 }
@@ -264,6 +264,106 @@ func (r *Rcname) MarshalText() (text []byte, err error) {
 	return w.Bytes(), nil
 }
 
+// MarshalText implements encoding.TextMarshaler
+func (r *Ralias) MarshalText() (text []byte, err error) {
+	w := new(bytes.Buffer)
+	w.WriteString(string(prefixALIAS))
+	if r.iswildcard {
+		w.WriteString("*.")
+	}
+	putdomtext(w, r.dom)
+	w.Write(NSEP)
+	putdomtext(w, r.target)
+	w.Write(NSEP)
+	fmt.Fprintf(w, "%d", r.ttl)
+	w.Write(NSEP)
+	// skip one unused field
+	w.Write(NSEP)
+	Putloctext(w, r.lo)
+	return w.Bytes(), nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (r *Rttl) MarshalText() (text []byte, err error) {
+	w := new(bytes.Buffer)
+	w.WriteString(string(prefixTTL))
+	if r.iswildcard {
+		w.WriteString("*.")
+	}
+	putdomtext(w, r.dom)
+	w.Write(NSEP)
+	fmt.Fprintf(w, "%d", r.ttl)
+	w.Write(NSEP)
+	// skip one unused field
+	w.Write(NSEP)
+	Putloctext(w, r.lo)
+	return w.Bytes(), nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (r *Rds) MarshalText() (text []byte, err error) {
+	w := new(bytes.Buffer)
+	w.WriteString(string(prefixDS))
+	putdomtext(w, r.dom)
+	w.Write(NSEP)
+	fmt.Fprintf(w, "%d", r.keytag)
+	w.Write(NSEP)
+	fmt.Fprintf(w, "%d", r.algorithm)
+	w.Write(NSEP)
+	fmt.Fprintf(w, "%d", r.digesttype)
+	w.Write(NSEP)
+	fmt.Fprintf(w, "%X", r.digest)
+	w.Write(NSEP)
+	fmt.Fprintf(w, "%d", r.ttl)
+	w.Write(NSEP)
+	// skip one unused field
+	w.Write(NSEP)
+	Putloctext(w, r.lo)
+	return w.Bytes(), nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (r *Rdualstack) MarshalText() (text []byte, err error) {
+	w := new(bytes.Buffer)
+	w.WriteString(string(prefixDualStack))
+	if r.iswildcard {
+		w.WriteString("*.")
+	}
+	putdomtext(w, r.dom)
+	w.Write(NSEP)
+	w.WriteString(r.policy.String())
+	w.Write(NSEP)
+	// skip one unused field
+	w.Write(NSEP)
+	Putloctext(w, r.lo)
+	return w.Bytes(), nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (r *Rmeta) MarshalText() (text []byte, err error) {
+	w := new(bytes.Buffer)
+	w.WriteString(string(prefixMeta))
+	if r.iswildcard {
+		w.WriteString("*.")
+	}
+	putdomtext(w, r.dom)
+	w.Write(NSEP)
+	putquotedtext(w, r.meta)
+	return w.Bytes(), nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (r *Rfallback) MarshalText() (text []byte, err error) {
+	w := new(bytes.Buffer)
+	w.WriteString(string(prefixFallback))
+	Putloctext(w, r.lo)
+	for _, lo := range r.fallbacks {
+		w.Write(NSEP)
+		Putloctext(w, lo)
+	}
+	return w.Bytes(), nil
+}
+
 // MarshalText implements encoding.TextMarshaler
 func (r *Rptr) MarshalText() (text []byte, err error) {
 	w := new(bytes.Buffer)
@@ -48,6 +48,13 @@ func (r *Rcname) TerraformValue() (string, error) {
 	return w.String(), nil
 }
 
+// TerraformValue implements TerraformRecord interface
+func (r *Ralias) TerraformValue() (string, error) {
+	w := new(bytes.Buffer)
+	putdomtext(w, r.target)
+	return w.String(), nil
+}
+
 // TerraformValue implements TerraformRecord interface
 func (r *Rptr) TerraformValue() (string, error) {
 	w := new(bytes.Buffer)
@@ -108,6 +115,8 @@ func WireTypeToTerraformString(t WireType) (string, error) {
 		return "SVCB", nil
 	case TypeHTTPS:
 		return "HTTPS", nil
+	case TypeALIAS:
+		return "ALIAS", nil
 	}
 
 	return "", fmt.Errorf("unknown wire type: %v", t)
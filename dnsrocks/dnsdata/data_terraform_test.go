@@ -47,6 +47,11 @@ func TestTerraformFormatting(t *testing.T) {
 			expectedType:  "CNAME",
 			expectedValue: "test.com",
 		},
+		{
+			input:         "Atest.com,www.test.com,3600",
+			expectedType:  "ALIAS",
+			expectedValue: "www.test.com",
+		},
 		{
 			input:         "^168.192.in-addr.arpa,some.host.net,86400,,",
 			expectedType:  "PTR",
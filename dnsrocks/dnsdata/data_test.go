@@ -22,6 +22,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata/quote"
 )
 
 type codecTest struct {
@@ -924,6 +926,118 @@ var codectests = []codecTest{
 			},
 		},
 	},
+	{
+		in:      []byte("Apla.net,Earth.pla.net,300"),
+		outText: []byte("Apla.net,Earth.pla.net,300,,"),
+		out: []MapRecord{
+			{
+				Key:   []byte{0, 0, 3, 112, 108, 97, 3, 110, 101, 116, 0},
+				Value: []byte{255, 121, 61, 0, 0, 1, 44, 0, 0, 0, 0, 0, 0, 0, 0, 5, 101, 97, 114, 116, 104, 3, 112, 108, 97, 3, 110, 101, 116, 0},
+			},
+		},
+		outV2: []MapRecord{
+			{
+				Key: []byte{
+					0, 111, // prefix
+					3, 110, 101, 116, 3, 112, 108, 97, 0, // inverted name
+					0, 0, // location
+				},
+				Value: []byte{255, 121, 61, 0, 0, 1, 44, 0, 0, 0, 0, 0, 0, 0, 0, 5, 101, 97, 114, 116, 104, 3, 112, 108, 97, 3, 110, 101, 116, 0},
+			},
+		},
+	},
+	{
+		in:      []byte("Tpla.net,60"),
+		outText: []byte("Tpla.net,60,,"),
+		out: []MapRecord{
+			{
+				Key:   []byte{0, 0, 3, 112, 108, 97, 3, 110, 101, 116, 0},
+				Value: []byte{255, 122, 61, 0, 0, 0, 60, 0, 0, 0, 0, 0, 0, 0, 0},
+			},
+		},
+		outV2: []MapRecord{
+			{
+				Key: []byte{
+					0, 111, // prefix
+					3, 110, 101, 116, 3, 112, 108, 97, 0, // inverted name
+					0, 0, // location
+				},
+				Value: []byte{255, 122, 61, 0, 0, 0, 60, 0, 0, 0, 0, 0, 0, 0, 0},
+			},
+		},
+	},
+	{
+		in:      []byte("Kpla.net,12345,8,1,2bb183af5f22588179a53b0a98631fad1a292118"),
+		outText: []byte("Kpla.net,12345,8,1,2BB183AF5F22588179A53B0A98631FAD1A292118,86400,,"),
+		out: []MapRecord{
+			{
+				Key:   []byte{0, 0, 3, 112, 108, 97, 3, 110, 101, 116, 0},
+				Value: []byte{0, 43, 61, 0, 1, 81, 128, 0, 0, 0, 0, 0, 0, 0, 0, 48, 57, 8, 1, 43, 177, 131, 175, 95, 34, 88, 129, 121, 165, 59, 10, 152, 99, 31, 173, 26, 41, 33, 24},
+			},
+		},
+		outV2: []MapRecord{
+			{
+				Key: []byte{
+					0, 111, // prefix
+					3, 110, 101, 116, 3, 112, 108, 97, 0, // inverted name
+					0, 0, // location
+				},
+				Value: []byte{0, 43, 61, 0, 1, 81, 128, 0, 0, 0, 0, 0, 0, 0, 0, 48, 57, 8, 1, 43, 177, 131, 175, 95, 34, 88, 129, 121, 165, 59, 10, 152, 99, 31, 173, 26, 41, 33, 24},
+			},
+		},
+	},
+	{
+		in:      []byte("Dpla.net,v6only"),
+		outText: []byte("Dpla.net,v6only,,"),
+		out: []MapRecord{
+			{
+				Key:   []byte{0, 0, 3, 112, 108, 97, 3, 110, 101, 116, 0},
+				Value: []byte{255, 123, 61, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2},
+			},
+		},
+		outV2: []MapRecord{
+			{
+				Key: []byte{
+					0, 111, // prefix
+					3, 110, 101, 116, 3, 112, 108, 97, 0, // inverted name
+					0, 0, // location
+				},
+				Value: []byte{255, 123, 61, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2},
+			},
+		},
+	},
+	{
+		in:      []byte("N*.Pla.net,team\\054ticket"),
+		outText: []byte("N*.Pla.net,team\\054ticket"),
+		out: []MapRecord{
+			{
+				Key:   []byte{0, 0, 0, 78, 1, 42, 3, 112, 108, 97, 3, 110, 101, 116, 0},
+				Value: []byte("team,ticket"),
+			},
+		},
+		outV2: []MapRecord{
+			{
+				Key:   []byte{0, 0, 0, 78, 1, 42, 3, 112, 108, 97, 3, 110, 101, 116, 0},
+				Value: []byte("team,ticket"),
+			},
+		},
+	},
+	{
+		in:      []byte("L\\000\\001,\\000\\002,pop1"),
+		outText: []byte("L\\000\\001,\\000\\002,pop1"),
+		out: []MapRecord{
+			{
+				Key:   []byte{0, 0, 0, 76, 0, 1},
+				Value: []byte{0, 2, 255, 4, 112, 111, 112, 49},
+			},
+		},
+		outV2: []MapRecord{
+			{
+				Key:   []byte{0, 0, 0, 76, 0, 1},
+				Value: []byte{0, 2, 255, 4, 112, 111, 112, 49},
+			},
+		},
+	},
 	{
 		in:      []byte("^168.192.in-addr.\\141rpa:some.host.n\\145t"),
 		outText: []byte("^168.192.in-addr.arpa,some.host.net,86400,,"),
@@ -1329,10 +1443,10 @@ var codectests = []codecTest{
 	{
 		// HTTPS record in ServiceMode, with all the SvcParams
 		in: []byte(
-			"Hstar-mini.c10r.facebook.com,.,300,\\000\\000,1,ipv4hint=\"1.2.3.4|2.3.4.5\";mandatory=\"ipv4hint|alpn|ipv6hint\";alpn=h2|h3;ipv6hint=face:b00c::;ech=\"dHJhZmZpYw==\";no-default-alpn=;port=8080",
+			"Hstar-mini.c10r.facebook.com,.,300,\\000\\000,1,ipv4hint=\"1.2.3.4|2.3.4.5\";mandatory=\"ipv4hint|alpn|ipv6hint\";alpn=h2|h3;ipv6hint=face:b00c::;ech=\"AAr+DQAGY29uZmln\";no-default-alpn=;port=8080",
 		),
 		outText: []byte(
-			"Hstar-mini.c10r.facebook.com,.,300,\\000\\000,1,mandatory=\"alpn|ipv4hint|ipv6hint\";alpn=\"h2|h3\";no-default-alpn=\"\";port=\"8080\";ipv4hint=\"1.2.3.4|2.3.4.5\";ech=\"dHJhZmZpYw==\";ipv6hint=\"face:b00c::\"",
+			"Hstar-mini.c10r.facebook.com,.,300,\\000\\000,1,mandatory=\"alpn|ipv4hint|ipv6hint\";alpn=\"h2|h3\";no-default-alpn=\"\";port=\"8080\";ipv4hint=\"1.2.3.4|2.3.4.5\";ech=\"AAr+DQAGY29uZmln\";ipv6hint=\"face:b00c::\"",
 		),
 		out: []MapRecord{
 			{
@@ -1371,8 +1485,9 @@ var codectests = []codecTest{
 					1, 2, 3, 4, // 1.2.3.4
 					2, 3, 4, 5, // 2.3.4.5
 					0, 5, // key type ech config
-					0, 7, // length = 7
-					't', 'r', 'a', 'f', 'f', 'i', 'c', // ech public key
+					0, 12, // length = 12
+					0, 10, 0xfe, 0x0d, 0, 6, // ECHConfigList length, ECHConfig version and length
+					'c', 'o', 'n', 'f', 'i', 'g', // ECHConfig contents
 					0, 6, // key type ipv6hint
 					0, 16, // param ipv6hint size=16
 					0xfa, 0xce, 0xb0, 0x0c, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // face:b00c::
@@ -1417,8 +1532,9 @@ var codectests = []codecTest{
 					1, 2, 3, 4, // 1.2.3.4
 					2, 3, 4, 5, // 2.3.4.5
 					0, 5, // key type ech config
-					0, 7, // length = 7
-					't', 'r', 'a', 'f', 'f', 'i', 'c', // ech public key
+					0, 12, // length = 12
+					0, 10, 0xfe, 0x0d, 0, 6, // ECHConfigList length, ECHConfig version and length
+					'c', 'o', 'n', 'f', 'i', 'g', // ECHConfig contents
 					0, 6, // key type ipv6hint
 					0, 16, // param ipv6hint size=16
 					0xfa, 0xce, 0xb0, 0x0c, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // face:b00c::
@@ -1695,6 +1811,59 @@ func TestPutloc(t *testing.T) {
 	}
 }
 
+func TestTTLOverrideMissingTTL(t *testing.T) {
+	codec := new(Codec)
+	_, err := codec.decodeRecord([]byte("Tpla.net,,,\\001\\002"))
+	require.ErrorIs(t, err, ErrMissingTTL)
+}
+
+func TestDSBadDigest(t *testing.T) {
+	codec := new(Codec)
+	for _, in := range []string{"Kpla.net,12345,8,2", "Kpla.net,12345,8,2,xyz"} {
+		_, err := codec.decodeRecord([]byte(in))
+		require.ErrorIs(t, err, ErrBadDSDigest, in)
+	}
+}
+
+func TestDualStackBadPolicy(t *testing.T) {
+	codec := new(Codec)
+	_, err := codec.decodeRecord([]byte("Dpla.net,v5only,,\\001\\002"))
+	require.ErrorIs(t, err, ErrBadDualStackPolicy)
+}
+
+func TestFallbackBad(t *testing.T) {
+	codec := new(Codec)
+	for _, text := range []string{
+		"L\\000\\001",
+		"L\\000\\001,,",
+		"L,\\000\\002",
+		"L\\000\\000,\\000\\002",
+		"L\\000\\001,\\000\\000",
+		"L\\000\\001,\\000\\002,\\000\\001",
+		"Laa,bb,cc,dd,ee,ff,gg,hh,ii,jj,kk,ll,mm,nn,oo,pp",
+	} {
+		_, err := codec.decodeRecord([]byte(text))
+		require.ErrorIs(t, err, ErrBadFallback, text)
+	}
+}
+
+func TestTXTAUXBadEscape(t *testing.T) {
+	codec := new(Codec)
+	for _, tc := range []struct {
+		text   string
+		offset int64
+	}{
+		{text: "'pla.net,v=spf1 \\q,3600,,", offset: 7},
+		{text: "'pla.net,truncated\\01,3600,,", offset: 9},
+		{text: ":pla.net,16,\\004test\\777,3600,,", offset: 8},
+	} {
+		_, err := codec.decodeRecord([]byte(tc.text))
+		var serr *quote.SyntaxError
+		require.ErrorAs(t, err, &serr, tc.text)
+		require.Equal(t, tc.offset, serr.Offset, tc.text)
+	}
+}
+
 func BenchmarkMarshalText(b *testing.B) {
 	for _, tc := range codectests {
 		b.Run(string(tc.in), func(b *testing.B) {
@@ -62,6 +62,26 @@ func (r *Rcname) WireType() WireType {
 	return TypeCNAME
 }
 
+// WireType implements WireRecord interface
+func (r *Ralias) WireType() WireType {
+	return TypeALIAS
+}
+
+// WireType implements WireRecord interface
+func (r *Rttl) WireType() WireType {
+	return TypeTTL
+}
+
+// WireType implements WireRecord interface
+func (r *Rds) WireType() WireType {
+	return TypeDS
+}
+
+// WireType implements WireRecord interface
+func (r *Rdualstack) WireType() WireType {
+	return TypeDualStack
+}
+
 // WireType implements WireRecord interface
 func (r *Rsoa) WireType() WireType {
 	return TypeSOA
@@ -87,6 +107,16 @@ func (r *Rsrv1) WireType() WireType {
 	return TypeSRV
 }
 
+// WireType implements WireRecord interface
+func (r *Rsvcb) WireType() WireType {
+	return TypeSVCB
+}
+
+// WireType implements WireRecord interface
+func (r *Raux) WireType() WireType {
+	return r.rtype
+}
+
 // WireType implements WireRecord interface
 func (r *Rhttps) WireType() WireType {
 	return TypeHTTPS
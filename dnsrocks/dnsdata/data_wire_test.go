@@ -71,6 +71,37 @@ func TestWireRecordIsImplemented(t *testing.T) {
 			location:   []byte("\005\006"),
 			ttl:        1801,
 		},
+		{
+			in:         "Atest.com,target.com,1801,,\005\006",
+			record:     &Ralias{},
+			wireType:   TypeALIAS,
+			domainName: "test.com",
+			location:   []byte("\005\006"),
+			ttl:        1801,
+		},
+		{
+			in:         "Ttest.com,60,,\005\006",
+			record:     &Rttl{},
+			wireType:   TypeTTL,
+			domainName: "test.com",
+			location:   []byte("\005\006"),
+			ttl:        60,
+		},
+		{
+			in:         "Ktest.com,12345,8,2,00ff,1803,,\005\006",
+			record:     &Rds{},
+			wireType:   TypeDS,
+			domainName: "test.com",
+			location:   []byte("\005\006"),
+			ttl:        1803,
+		},
+		{
+			in:         "Dtest.com,v4only,,\005\006",
+			record:     &Rdualstack{},
+			wireType:   TypeDualStack,
+			domainName: "test.com",
+			location:   []byte("\005\006"),
+		},
 		{
 			in:         "^168.192.in-addr.arpa,some.host.net,1802,,\006\007",
 			record:     &Rptr{},
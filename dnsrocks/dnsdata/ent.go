@@ -0,0 +1,196 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+// ownerNames accumulates the owner names of a data set, to find its empty
+// non-terminals: names without records of their own, between a zone apex and
+// the names below it which have some. Owners of NS records are always
+// accumulated, so that the names between a zone apex and its delegations
+// exist for resolvers minimizing query names (RFC 9156), other owners only
+// with Codec.EmptyNonTerminals.
+type ownerNames struct {
+	names       map[string]struct{}
+	apexes      map[string]struct{}
+	delegations map[string]struct{}
+}
+
+// addOwners accounts the owner names of the records of s, normalized the way
+// their keys are
+func (r *Accum) addOwners(s Record, c *Codec) error {
+	switch s := s.(type) {
+	case CompositeRecord:
+		for _, derived := range s.DerivedRecords() {
+			if err := r.addOwners(derived, c); err != nil {
+				return err
+			}
+		}
+	case WireRecord:
+		t := s.WireType()
+		switch t {
+		case TypeTTL, TypeDualStack:
+			// these don't make their name exist
+			return nil
+		}
+		if !c.EmptyNonTerminals && t != TypeSOA && t != TypeNS {
+			return nil
+		}
+		dom, err := c.checkName([]byte(s.DomainName()))
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(string(bytes.ToLower(dom)), ".")
+		r.mux.Lock()
+		defer r.mux.Unlock()
+		if r.owners.names == nil {
+			r.owners.names = make(map[string]struct{})
+			r.owners.apexes = make(map[string]struct{})
+			r.owners.delegations = make(map[string]struct{})
+		}
+		r.owners.names[name] = struct{}{}
+		switch t {
+		case TypeSOA:
+			r.owners.apexes[name] = struct{}{}
+		case TypeNS:
+			r.owners.delegations[name] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// parentName returns the name without its first label, and false for the root
+func parentName(name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return name[i+1:], true
+	}
+	return "", true
+}
+
+// emptyNonTerminals returns the sorted empty non-terminals of the names seen,
+// or of the delegations only if all is false. Names outside of any zone have
+// none.
+func (r *Accum) emptyNonTerminals(all bool) []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	owners := r.owners.names
+	if !all {
+		owners = r.owners.delegations
+	}
+	ents := make(map[string]struct{})
+	for name := range owners {
+		var candidates []string
+		inZone := false
+		for p, ok := parentName(name); ok; p, ok = parentName(p) {
+			if _, ok := r.owners.apexes[p]; ok {
+				inZone = true
+				break
+			}
+			if _, ok := r.owners.names[p]; !ok {
+				candidates = append(candidates, p)
+			}
+		}
+		if !inZone {
+			continue
+		}
+		for _, p := range candidates {
+			ents[p] = struct{}{}
+		}
+	}
+	sorted := make([]string, 0, len(ents))
+	for name := range ents {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// marshalEmptyNonTerminals returns a marker record for each empty non-terminal
+// of the data set, or above its delegations only without
+// Codec.EmptyNonTerminals. Markers are never served, they make queries for
+// their names answered with NODATA rather than NXDOMAIN.
+func (c *Codec) marshalEmptyNonTerminals() ([]MapRecord, error) {
+	names := c.Acc.emptyNonTerminals(c.EmptyNonTerminals)
+	m := make([]MapRecord, 0, len(names))
+	for _, name := range names {
+		r, err := c.EmptyNonTerminalMarker(name)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, r)
+	}
+	return m, nil
+}
+
+// EmptyNonTerminalMarker returns the marker record of the empty non-terminal
+// name, a lower case name without trailing dot
+func (c *Codec) EmptyNonTerminalMarker(name string) (MapRecord, error) {
+	k, err := makedomainkey([]byte(name), nil, c)
+	if err != nil {
+		return MapRecord{}, err
+	}
+	v := new(bytes.Buffer)
+	if err = putrrhead(v, TypeENT, 0, nil, false); err != nil {
+		return MapRecord{}, err
+	}
+	return MapRecord{Key: k, Value: v.Bytes()}, nil
+}
+
+// KeyOwnerName returns the owner name of a resource record key, in the v2
+// keys syntax if v2 is set, as a lower case name without trailing dot, empty
+// for the root, and false for the other keys
+func KeyOwnerName(key []byte, v2 bool) (string, bool) {
+	if !v2 {
+		var err error
+		if key, _, err = V2Key(key); err != nil {
+			return "", false
+		}
+	}
+	if !bytes.HasPrefix(key, []byte(ResourceRecordsKeyMarker)) {
+		return "", false
+	}
+	reversed, rest, ok := splitPackedDomain(key[len(ResourceRecordsKeyMarker):])
+	if !ok || len(rest) == 0 {
+		return "", false
+	}
+	labels := make([]string, len(reversed))
+	for i, l := range reversed {
+		labels[len(reversed)-1-i] = string(bytes.ToLower(l))
+	}
+	return strings.Join(labels, "."), true
+}
+
+// OwnerKeyPrefix returns the prefix the v2 resource record keys of name, a
+// lower case name without trailing dot, share with the ones of the names
+// below it: the marker and the labels of name in reverse order, without the
+// terminating root label
+func OwnerKeyPrefix(name string) []byte {
+	prefix := []byte(ResourceRecordsKeyMarker)
+	if name == "" {
+		return prefix
+	}
+	labels := strings.Split(name, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		prefix = append(prefix, byte(len(labels[i])))
+		prefix = append(prefix, labels[i]...)
+	}
+	return prefix
+}
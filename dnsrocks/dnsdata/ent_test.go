@@ -0,0 +1,122 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// entData has empty non-terminals below records, wildcards and derived
+// records. TTL overrides don't make names exist, nor do names outside of zones
+// have empty non-terminals.
+const entData = `Zexample.com,ns.example.com,dns.example.com,123,1800,900,604800,3600,300
+&example.com,,ns.example.com,300
++a.b.c.example.com,192.0.2.1,300
++*.w.example.com,192.0.2.2,300
+'WWW.Sub.Example.com,hello,300
+@example.com,192.0.2.4,mx.mail.example.com,10,300
+Cnoent.example.com,a.b.c.example.com,300
+Tttl.only.example.com,60
++x.example.org,192.0.2.3,300
+`
+
+// entMarkers returns the names of the empty non-terminal markers in records
+func entMarkers(t *testing.T, records []MapRecord) []string {
+	var names []string
+	for _, m := range records {
+		if len(m.Value) < 2 || WireType(binary.BigEndian.Uint16(m.Value)) != TypeENT {
+			continue
+		}
+		// v1 keys: the default location followed by the packed name
+		require.True(t, bytes.HasPrefix(m.Key, []byte{0, 0}))
+		var labels []string
+		for b := m.Key[2:]; b[0] != 0; b = b[b[0]+1:] {
+			labels = append(labels, string(b[1:b[0]+1]))
+		}
+		names = append(names, strings.Join(labels, "."))
+	}
+	return names
+}
+
+func TestEmptyNonTerminals(t *testing.T) {
+	codec := &Codec{EmptyNonTerminals: true}
+	records, err := Parse(strings.NewReader(entData), codec, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"b.c.example.com",
+		"c.example.com",
+		"mail.example.com",
+		"sub.example.com",
+		"w.example.com",
+	}, entMarkers(t, records))
+
+	// disabled by default
+	records, err = Parse(strings.NewReader(entData), new(Codec), 2)
+	require.NoError(t, err)
+	require.Empty(t, entMarkers(t, records))
+}
+
+// TestDelegationEmptyNonTerminals checks that the empty non-terminals above
+// delegations get markers by default
+func TestDelegationEmptyNonTerminals(t *testing.T) {
+	data := entData + `&a.b.c.example.com,,ns.child.example.net,300
+&x.sub.example.com,,ns.child.example.net,300
+Zzone.d.example.com,ns.example.com,dns.example.com,123,1800,900,604800,3600,300
+&zone.d.example.com,,ns.example.com,300
+&y.zone.d.example.com,,ns.child.example.net,300
+&outside.example.org,,ns.child.example.net,300
+`
+	records, err := Parse(strings.NewReader(data), new(Codec), 2)
+	require.NoError(t, err)
+	// d.example.com is above the apex of a zone hosted along with its parent,
+	// whose own delegations only get markers up to that apex
+	require.Equal(t, []string{
+		"b.c.example.com",
+		"c.example.com",
+		"d.example.com",
+		"sub.example.com",
+	}, entMarkers(t, records))
+
+	codec := &Codec{EmptyNonTerminals: true}
+	records, err = Parse(strings.NewReader(data), codec, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"b.c.example.com",
+		"c.example.com",
+		"d.example.com",
+		"mail.example.com",
+		"sub.example.com",
+		"w.example.com",
+	}, entMarkers(t, records))
+}
+
+func TestEmptyNonTerminalsV2(t *testing.T) {
+	codec := &Codec{EmptyNonTerminals: true}
+	codec.Features.UseV2Keys = true
+	records, err := Parse(strings.NewReader(entData), codec, 1)
+	require.NoError(t, err)
+	var keys [][]byte
+	for _, m := range records {
+		if len(m.Value) >= 2 && WireType(binary.BigEndian.Uint16(m.Value)) == TypeENT {
+			keys = append(keys, m.Key)
+		}
+	}
+	require.Len(t, keys, 5)
+	require.Equal(t, []byte(ResourceRecordsKeyMarker+"\003com\007example\001c\000\000\000"), keys[1])
+}
@@ -0,0 +1,387 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// GenerateDirective starts the lines expanded into one data line per value of
+// a range, like BIND's $GENERATE:
+//
+//	$GENERATE <range> <template>
+//
+// where range is start-stop[/step] of non-negative integers, first-last[/step]
+// of IP addresses, or an IP prefix. In the template, $ is replaced by the
+// value and $$ by a literal $. For integers, ${offset[,width[,base]]} is
+// replaced by the value plus offset, padded with zeros to width, in base d
+// (default), o, x or X. For addresses, ${ptr} is replaced by the reverse
+// lookup name of the address, and ${dash} by the address with its dots and
+// colons replaced by dashes.
+const GenerateDirective = "$GENERATE"
+
+// MaxGenerateLines is the maximum number of lines a directive expands into
+const MaxGenerateLines = 65536
+
+// template segment kinds
+const (
+	segLiteral = iota
+	segValue
+	segPtr
+	segDash
+)
+
+// templateSegment is a literal part of a template, or a substitution
+type templateSegment struct {
+	kind    int
+	literal []byte
+	offset  int64
+	width   int
+	base    byte
+}
+
+// generator expands a $GENERATE directive
+type generator struct {
+	segments []templateSegment
+	isIP     bool
+	// the next value, of integer and address ranges
+	cur  int64
+	addr netip.Addr
+	step int64
+	left int // the number of lines left to expand
+}
+
+// parseRangeEnds parses the start-stop[/step] range s with parse
+func parseRangeEnds[T any](s string, parse func(string) (T, error)) (start, stop T, step int64, err error) {
+	step = 1
+	if i := strings.LastIndexByte(s, '/'); i >= 0 {
+		if step, err = strconv.ParseInt(s[i+1:], 10, 64); err != nil || step < 1 {
+			return start, stop, step, fmt.Errorf("invalid step in range %q", s)
+		}
+		s = s[:i]
+	}
+	// IPv6 addresses have no dashes, the first one separates the ends
+	ends := strings.SplitN(s, "-", 2)
+	if len(ends) != 2 {
+		return start, stop, step, fmt.Errorf("invalid range %q", s)
+	}
+	if start, err = parse(ends[0]); err != nil {
+		return start, stop, step, fmt.Errorf("invalid range %q: %w", s, err)
+	}
+	if stop, err = parse(ends[1]); err != nil {
+		return start, stop, step, fmt.Errorf("invalid range %q: %w", s, err)
+	}
+	return start, stop, step, nil
+}
+
+// addrCount returns the number of lines an address range expands into,
+// or -1 if more than MaxGenerateLines
+func addrCount(first, last netip.Addr, step int64) int {
+	a, b := first.As16(), last.As16()
+	n := new(big.Int).Sub(new(big.Int).SetBytes(b[:]), new(big.Int).SetBytes(a[:]))
+	n.Div(n, big.NewInt(step))
+	if !n.IsInt64() || n.Int64() >= MaxGenerateLines {
+		return -1
+	}
+	return int(n.Int64()) + 1
+}
+
+// parseRange sets the range of g from s
+func (g *generator) parseRange(s string) error {
+	if !strings.ContainsAny(s, ".:") {
+		start, stop, step, err := parseRangeEnds(s, func(v string) (int64, error) {
+			return strconv.ParseInt(v, 10, 64)
+		})
+		if err != nil {
+			return err
+		}
+		if start < 0 || stop < start {
+			return fmt.Errorf("invalid range %q", s)
+		}
+		if (stop-start)/step >= MaxGenerateLines {
+			return fmt.Errorf("range %q has more than %d values", s, MaxGenerateLines)
+		}
+		g.cur, g.step = start, step
+		g.left = int((stop-start)/step) + 1
+		return nil
+	}
+	g.isIP = true
+	g.step = 1
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		if prefix.Addr().BitLen()-prefix.Bits() > 16 {
+			return fmt.Errorf("range %q has more than %d values", s, MaxGenerateLines)
+		}
+		g.addr = prefix.Masked().Addr()
+		g.left = 1 << (prefix.Addr().BitLen() - prefix.Bits())
+		return nil
+	}
+	first, last, step, err := parseRangeEnds(s, netip.ParseAddr)
+	if err != nil {
+		return err
+	}
+	if first.Is4() != last.Is4() || last.Less(first) {
+		return fmt.Errorf("invalid range %q", s)
+	}
+	g.left = addrCount(first, last, step)
+	if g.left < 0 {
+		return fmt.Errorf("range %q has more than %d values", s, MaxGenerateLines)
+	}
+	g.addr, g.step = first, step
+	return nil
+}
+
+// parseModifier parses the ${...} substitution mod
+func (g *generator) parseModifier(mod string) (templateSegment, error) {
+	if g.isIP {
+		switch mod {
+		case "ptr":
+			return templateSegment{kind: segPtr}, nil
+		case "dash":
+			return templateSegment{kind: segDash}, nil
+		}
+		return templateSegment{}, fmt.Errorf("invalid substitution ${%s} for an address range", mod)
+	}
+	seg := templateSegment{kind: segValue, base: 'd'}
+	fields := strings.Split(mod, ",")
+	if len(fields) > 3 {
+		return seg, fmt.Errorf("invalid substitution ${%s}", mod)
+	}
+	var err error
+	if seg.offset, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return seg, fmt.Errorf("invalid offset in ${%s}", mod)
+	}
+	if g.cur+seg.offset < 0 {
+		return seg, fmt.Errorf("${%s} is negative for %d", mod, g.cur)
+	}
+	if len(fields) > 1 {
+		if seg.width, err = strconv.Atoi(fields[1]); err != nil || seg.width < 0 || seg.width > 64 {
+			return seg, fmt.Errorf("invalid width in ${%s}", mod)
+		}
+	}
+	if len(fields) > 2 {
+		if len(fields[2]) != 1 || !strings.Contains("doxX", fields[2]) {
+			return seg, fmt.Errorf("invalid base in ${%s}", mod)
+		}
+		seg.base = fields[2][0]
+	}
+	return seg, nil
+}
+
+// parseTemplate sets the segments of g from the template t
+func (g *generator) parseTemplate(t string) error {
+	literal := []byte{}
+	flush := func() {
+		if len(literal) > 0 {
+			g.segments = append(g.segments, templateSegment{kind: segLiteral, literal: literal})
+			literal = []byte{}
+		}
+	}
+	for i := 0; i < len(t); i++ {
+		if t[i] != '$' {
+			literal = append(literal, t[i])
+			continue
+		}
+		switch {
+		case strings.HasPrefix(t[i:], "$$"):
+			literal = append(literal, '$')
+			i++
+		case strings.HasPrefix(t[i:], "${"):
+			end := strings.IndexByte(t[i:], '}')
+			if end < 0 {
+				return fmt.Errorf("unterminated substitution in %q", t)
+			}
+			seg, err := g.parseModifier(t[i+2 : i+end])
+			if err != nil {
+				return err
+			}
+			flush()
+			g.segments = append(g.segments, seg)
+			i += end
+		default:
+			flush()
+			g.segments = append(g.segments, templateSegment{kind: segValue, base: 'd'})
+		}
+	}
+	flush()
+	return nil
+}
+
+// newGenerator parses the $GENERATE directive line
+func newGenerator(line []byte) (*generator, error) {
+	// the template is the rest of the line, spaces included
+	rest := strings.TrimPrefix(strings.TrimSpace(string(line)), GenerateDirective)
+	r := strings.TrimLeft(rest, " \t")
+	i := strings.IndexAny(r, " \t")
+	if len(r) == len(rest) || i < 0 {
+		return nil, fmt.Errorf("expected %s <range> <template>", GenerateDirective)
+	}
+	r, template := r[:i], strings.TrimLeft(r[i:], " \t")
+	g := &generator{}
+	if err := g.parseRange(r); err != nil {
+		return nil, err
+	}
+	if err := g.parseTemplate(template); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// ptrName returns the reverse lookup name of addr
+func ptrName(addr netip.Addr) string {
+	var b strings.Builder
+	if addr.Is4() {
+		a := addr.As4()
+		for i := len(a) - 1; i >= 0; i-- {
+			b.WriteString(strconv.Itoa(int(a[i])))
+			b.WriteByte('.')
+		}
+		b.WriteString("in-addr.arpa")
+		return b.String()
+	}
+	a := addr.As16()
+	for i := len(a) - 1; i >= 0; i-- {
+		b.WriteString(strconv.FormatUint(uint64(a[i]&0xf), 16))
+		b.WriteByte('.')
+		b.WriteString(strconv.FormatUint(uint64(a[i]>>4), 16))
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa")
+	return b.String()
+}
+
+// dashReplacer replaces the separators of addresses for ${dash}
+var dashReplacer = strings.NewReplacer(".", "-", ":", "-")
+
+// writeValue writes the integer v formatted as seg to w
+func (seg *templateSegment) writeValue(w *bytes.Buffer, v int64) {
+	var s string
+	switch seg.base {
+	case 'o':
+		s = strconv.FormatInt(v, 8)
+	case 'x':
+		s = strconv.FormatInt(v, 16)
+	case 'X':
+		s = strings.ToUpper(strconv.FormatInt(v, 16))
+	default:
+		s = strconv.FormatInt(v, 10)
+	}
+	for i := len(s); i < seg.width; i++ {
+		w.WriteByte('0')
+	}
+	w.WriteString(s)
+}
+
+// next writes the next expanded line to w, returning false when done
+func (g *generator) next(w *bytes.Buffer) bool {
+	if g.left == 0 {
+		return false
+	}
+	for i := range g.segments {
+		seg := &g.segments[i]
+		switch seg.kind {
+		case segLiteral:
+			w.Write(seg.literal)
+		case segValue:
+			if g.isIP {
+				w.WriteString(g.addr.String())
+			} else {
+				seg.writeValue(w, g.cur+seg.offset)
+			}
+		case segPtr:
+			w.WriteString(ptrName(g.addr))
+		case segDash:
+			w.WriteString(dashReplacer.Replace(g.addr.String()))
+		}
+	}
+	w.WriteByte('\n')
+	g.left--
+	if g.isIP {
+		for i := int64(0); i < g.step && g.left > 0; i++ {
+			g.addr = g.addr.Next()
+		}
+	} else {
+		g.cur += g.step
+	}
+	return true
+}
+
+// GenerateReader is an io.Reader expanding the $GENERATE directives of the
+// data read from the underlying reader, see GenerateDirective. Other lines
+// are passed as they are.
+type GenerateReader struct {
+	scanner *bufio.Scanner
+	gen     *generator
+	buf     bytes.Buffer
+	line    int
+	err     error
+}
+
+// NewGenerateReader returns a GenerateReader reading from r
+func NewGenerateReader(r io.Reader) *GenerateReader {
+	return &GenerateReader{scanner: bufio.NewScanner(r)}
+}
+
+// isGenerateDirective returns true if line is a $GENERATE directive
+func isGenerateDirective(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(line, " \t"), []byte(GenerateDirective))
+}
+
+// fill adds the next line, or the next expansion of a directive, to the
+// buffer
+func (g *GenerateReader) fill() {
+	if g.gen != nil {
+		if g.gen.next(&g.buf) {
+			return
+		}
+		g.gen = nil
+	}
+	if !g.scanner.Scan() {
+		g.err = g.scanner.Err()
+		if g.err == nil {
+			g.err = io.EOF
+		}
+		return
+	}
+	g.line++
+	line := g.scanner.Bytes()
+	if !isGenerateDirective(line) {
+		g.buf.Write(line)
+		g.buf.WriteByte('\n')
+		return
+	}
+	gen, err := newGenerator(line)
+	if err != nil {
+		g.err = fmt.Errorf("line %d: %s: %w", g.line, line, err)
+		return
+	}
+	g.gen = gen
+}
+
+// Read implements io.Reader
+func (g *GenerateReader) Read(p []byte) (int, error) {
+	for g.buf.Len() == 0 {
+		if g.err != nil {
+			return 0, g.err
+		}
+		g.fill()
+	}
+	return g.buf.Read(p)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateReader(t *testing.T) {
+	in := `Zexample.com,ns.example.com,dns.example.com,,,,,,
+  $GENERATE 1-3 +host$.example.com,192.0.2.$
+$GENERATE 8-12/2 'txt-${-8,3}.example.com,cost $$$,${0,2,x}-${0,2,X}-${0,0,o}
+# $GENERATE 1-1000000 ignored
+$GENERATE	192.0.2.0/31 ^${ptr},ip-${dash}.example.com
+$GENERATE 2001:db8::1-2001:db8::5/3 =${dash}.example.com,$
+`
+	out, err := io.ReadAll(NewGenerateReader(strings.NewReader(in)))
+	require.NoError(t, err)
+	require.Equal(t, `Zexample.com,ns.example.com,dns.example.com,,,,,,
++host1.example.com,192.0.2.1
++host2.example.com,192.0.2.2
++host3.example.com,192.0.2.3
+'txt-000.example.com,cost $8,08-08-10
+'txt-002.example.com,cost $10,0a-0A-12
+'txt-004.example.com,cost $12,0c-0C-14
+# $GENERATE 1-1000000 ignored
+^0.2.0.192.in-addr.arpa,ip-192-0-2-0.example.com
+^1.2.0.192.in-addr.arpa,ip-192-0-2-1.example.com
+=2001-db8--1.example.com,2001:db8::1
+=2001-db8--4.example.com,2001:db8::4
+`, string(out))
+
+	out, err = io.ReadAll(NewGenerateReader(strings.NewReader("$GENERATE 2001:db8::/127 ^${ptr},x")))
+	require.NoError(t, err)
+	require.Equal(t, "^0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa,x\n^1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa,x\n", string(out))
+}
+
+func TestGenerateReaderErrors(t *testing.T) {
+	for _, line := range []string{
+		"$GENERATE",
+		"$GENERATE 1-3",
+		"$GENERATEx 1-3 +host$.example.com,192.0.2.$",
+		"$GENERATE 3-1 +host$.example.com,192.0.2.$",
+		"$GENERATE -1-3 +host$.example.com,192.0.2.$",
+		"$GENERATE 1-3/0 +host$.example.com,192.0.2.$",
+		"$GENERATE 1 +host$.example.com,192.0.2.$",
+		"$GENERATE 0-65536 +host$.example.com,192.0.2.$",
+		"$GENERATE 10.0.0.0/8 +host-${dash}.example.com,$",
+		"$GENERATE 2001:db8::-2001:db8::1:0 +host-${dash}.example.com,$",
+		"$GENERATE 192.0.2.1-2001:db8::1 +host-${dash}.example.com,$",
+		"$GENERATE 192.0.2.2-192.0.2.1 +host-${dash}.example.com,$",
+		"$GENERATE 1-3 +host${-2}.example.com,192.0.2.$",
+		"$GENERATE 1-3 +host${0,3,b}.example.com,192.0.2.$",
+		"$GENERATE 1-3 +host${ptr}.example.com,192.0.2.$",
+		"$GENERATE 1-3 +host${0.example.com,192.0.2.$",
+		"$GENERATE 192.0.2.0/30 +host${1}.example.com,$",
+	} {
+		_, err := io.ReadAll(NewGenerateReader(strings.NewReader("+foo.example.com,192.0.2.1\n" + line + "\n")))
+		require.ErrorContains(t, err, "line 2", line)
+	}
+}
+
+// TestParseGenerate checks that the compilers expand directives
+func TestParseGenerate(t *testing.T) {
+	expanded, err := Parse(strings.NewReader("=host1.example.com,192.0.2.1\n=host2.example.com,192.0.2.2\n"), new(Codec), 2)
+	require.NoError(t, err)
+	generated, err := Parse(strings.NewReader("$GENERATE 1-2 =host$.example.com,192.0.2.$\n"), new(Codec), 2)
+	require.NoError(t, err)
+	require.Equal(t, expanded, generated)
+
+	_, err = Parse(strings.NewReader("$GENERATE 1-2 ?host$.example.com,192.0.2.$\n"), new(Codec), 2)
+	require.Error(t, err)
+	_, err = Parse(strings.NewReader("$GENERATE 2-1 =host$.example.com,192.0.2.$\n"), new(Codec), 2)
+	require.Error(t, err)
+}
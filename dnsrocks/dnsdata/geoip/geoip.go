@@ -0,0 +1,181 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package geoip converts MaxMind-format (MMDB) geo databases into
+// subnet-to-location ("%") records understood by dnsdata.
+package geoip
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/quote"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Config describes how geo attributes are mapped to location IDs.
+// Location and map IDs use the same (optionally escaped) text form as data files.
+type Config struct {
+	// Map is the ID of the map the generated records belong to
+	Map string `json:"map"`
+	// Default is the location assigned to networks without a more specific match.
+	// Networks are skipped when empty.
+	Default string `json:"default"`
+	// Countries maps ISO 3166-1 alpha-2 country codes to location IDs
+	Countries map[string]string `json:"countries"`
+	// ASNs maps autonomous system numbers to location IDs. ASN matches take precedence over countries.
+	ASNs map[uint32]string `json:"asns"`
+}
+
+// Record is the subset of MMDB attributes used to pick a location.
+// It decodes both City/Country and ASN databases.
+type Record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	ASN uint32 `maxminddb:"autonomous_system_number"`
+}
+
+// Walker iterates over all networks of a geo database
+type Walker interface {
+	Walk(f func(ipnet *net.IPNet, rec *Record) error) error
+}
+
+// MMDB is a Walker over a MaxMind-format database file
+type MMDB struct {
+	reader *maxminddb.Reader
+}
+
+// LoadConfig reads a JSON mapping config from path. Country codes are
+// upper-cased, as Locate looks them up.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conf := new(Config)
+	if err := json.Unmarshal(b, conf); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	countries := make(map[string]string, len(conf.Countries))
+	for code, loc := range conf.Countries {
+		upper := strings.ToUpper(code)
+		if prev, ok := countries[upper]; ok && prev != loc {
+			return nil, fmt.Errorf("parsing %s: country %s mapped to both %q and %q", path, upper, prev, loc)
+		}
+		countries[upper] = loc
+	}
+	if conf.Countries != nil {
+		conf.Countries = countries
+	}
+	return conf, nil
+}
+
+// Locate returns the location ID for the given record, and false if
+// neither the record nor the default has a mapping.
+func (c *Config) Locate(rec *Record) (string, bool) {
+	if loc, ok := c.ASNs[rec.ASN]; ok && rec.ASN != 0 {
+		return loc, true
+	}
+	if loc, ok := c.Countries[strings.ToUpper(rec.Country.ISOCode)]; ok && rec.Country.ISOCode != "" {
+		return loc, true
+	}
+	if c.Default != "" {
+		return c.Default, true
+	}
+	return "", false
+}
+
+// OpenMMDB opens a MaxMind-format database
+func OpenMMDB(path string) (*MMDB, error) {
+	r, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &MMDB{reader: r}, nil
+}
+
+// Walk calls f for every network with data in the database.
+// IPv4 networks aliased into the IPv6 tree are visited once.
+func (m *MMDB) Walk(f func(ipnet *net.IPNet, rec *Record) error) error {
+	networks := m.reader.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		var rec Record
+		ipnet, err := networks.Network(&rec)
+		if err != nil {
+			return err
+		}
+		if err := f(ipnet, &rec); err != nil {
+			return err
+		}
+	}
+	return networks.Err()
+}
+
+// Close releases the database
+func (m *MMDB) Close() error {
+	return m.reader.Close()
+}
+
+// WriteNets walks src and writes a "%" record for every network which has a
+// location according to conf. It returns the number of records written.
+func WriteNets(w io.Writer, src Walker, conf *Config) (int, error) {
+	lmap, err := quote.Bunquote([]byte(conf.Map))
+	if err != nil {
+		return 0, fmt.Errorf("bad map %q: %w", conf.Map, err)
+	}
+	locs := make(map[string][]byte)
+	unquoteLoc := func(s string) ([]byte, error) {
+		if lo, ok := locs[s]; ok {
+			return lo, nil
+		}
+		lo, err := quote.Bunquote([]byte(s))
+		if err != nil {
+			return nil, fmt.Errorf("bad location %q: %w", s, err)
+		}
+		locs[s] = lo
+		return lo, nil
+	}
+
+	n := 0
+	err = src.Walk(func(ipnet *net.IPNet, rec *Record) error {
+		loc, ok := conf.Locate(rec)
+		if !ok {
+			return nil
+		}
+		lo, err := unquoteLoc(loc)
+		if err != nil {
+			return err
+		}
+		var b strings.Builder
+		b.WriteString("%")
+		dnsdata.Putloctext(&b, dnsdata.Loc(lo))
+		b.Write(dnsdata.NSEP)
+		b.WriteString(ipnet.String())
+		b.Write(dnsdata.NSEP)
+		dnsdata.Putlmaptext(&b, dnsdata.Lmap(lmap))
+		b.WriteString("\n")
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,145 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+
+	"github.com/stretchr/testify/require"
+)
+
+type entry struct {
+	cidr    string
+	country string
+	asn     uint32
+}
+
+type fakeWalker []entry
+
+func (f fakeWalker) Walk(fn func(ipnet *net.IPNet, rec *Record) error) error {
+	for _, e := range f {
+		_, ipnet, err := net.ParseCIDR(e.cidr)
+		if err != nil {
+			return err
+		}
+		rec := &Record{ASN: e.asn}
+		rec.Country.ISOCode = e.country
+		if err := fn(ipnet, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestLocate(t *testing.T) {
+	conf := &Config{
+		Countries: map[string]string{"US": "us"},
+		ASNs:      map[uint32]string{32934: "fb"},
+	}
+	testCases := []struct {
+		name    string
+		country string
+		asn     uint32
+		def     string
+		want    string
+		found   bool
+	}{
+		{name: "country", country: "US", want: "us", found: true},
+		{name: "lowercase country", country: "us", want: "us", found: true},
+		{name: "asn wins", country: "US", asn: 32934, want: "fb", found: true},
+		{name: "no match", country: "FR"},
+		{name: "default", country: "FR", def: "df", want: "df", found: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf.Default = tc.def
+			rec := &Record{ASN: tc.asn}
+			rec.Country.ISOCode = tc.country
+			loc, found := conf.Locate(rec)
+			require.Equal(t, tc.found, found)
+			require.Equal(t, tc.want, loc)
+		})
+	}
+}
+
+func TestWriteNets(t *testing.T) {
+	conf := &Config{
+		Map:       "m1",
+		Countries: map[string]string{"US": "us", "GB": "\\000\\001"},
+		ASNs:      map[uint32]string{32934: "fb"},
+	}
+	src := fakeWalker{
+		{cidr: "192.0.2.0/24", country: "US"},
+		{cidr: "198.51.100.0/24", country: "FR"},
+		{cidr: "2001:db8::/32", country: "GB"},
+		{cidr: "203.0.113.0/25", country: "US", asn: 32934},
+	}
+	var out bytes.Buffer
+	n, err := WriteNets(&out, src, conf)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.Equal(t,
+		"%\\165\\163,192.0.2.0/24,\\155\\061\n"+
+			"%\\000\\001,2001:db8::/32,\\155\\061\n"+
+			"%\\146\\142,203.0.113.0/25,\\155\\061\n",
+		out.String())
+
+	// the output must be valid data
+	codec := new(dnsdata.Codec)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		_, err := codec.ConvertLn([]byte(line))
+		require.NoError(t, err, line)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "geoip.json")
+	err := os.WriteFile(p, []byte(`{"map": "m1", "default": "df", "countries": {"US": "us"}, "asns": {"32934": "fb"}}`), 0o644)
+	require.NoError(t, err)
+	conf, err := LoadConfig(p)
+	require.NoError(t, err)
+	require.Equal(t, &Config{
+		Map:       "m1",
+		Default:   "df",
+		Countries: map[string]string{"US": "us"},
+		ASNs:      map[uint32]string{32934: "fb"},
+	}, conf)
+
+	// country codes are matched whatever their case
+	err = os.WriteFile(p, []byte(`{"countries": {"us": "us", "Gb": "gb", "GB": "gb"}}`), 0o644)
+	require.NoError(t, err)
+	conf, err = LoadConfig(p)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"US": "us", "GB": "gb"}, conf.Countries)
+	rec := &Record{}
+	rec.Country.ISOCode = "US"
+	loc, found := conf.Locate(rec)
+	require.True(t, found)
+	require.Equal(t, "us", loc)
+
+	require.NoError(t, os.WriteFile(p, []byte(`{"countries": {"us": "us", "US": "fb"}}`), 0o644))
+	_, err = LoadConfig(p)
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(p, []byte(`{`), 0o644))
+	_, err = LoadConfig(p)
+	require.Error(t, err)
+}
@@ -0,0 +1,235 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// DefaultHTTPSALPN are the ALPN protocols advertised by synthesized HTTPS
+// records, unless set for their zone
+var DefaultHTTPSALPN = []string{"h2", "h3"}
+
+// HTTPSZone is a zone whose names get a synthesized HTTPS record: the names
+// of Zone and below it with A or AAAA records (+ or = lines, not the
+// addresses of name servers and mail exchangers), and without HTTPS record in
+// the data, get an HTTPS record in ServiceMode for every location they have
+// addresses for, advertising ALPN, with their addresses as ipv4hint and
+// ipv6hint and the lowest TTL of their A and AAAA records. Wildcards get none.
+type HTTPSZone struct {
+	// Zone is the zone, without trailing dot
+	Zone string
+	ALPN []string
+}
+
+// ParseHTTPSZone parses an HTTPS zone written as zone[=alpn[|alpn...]], e.g.
+// example.com=h2|h3. ALPN defaults to DefaultHTTPSALPN.
+func ParseHTTPSZone(s string) (HTTPSZone, error) {
+	z := HTTPSZone{ALPN: DefaultHTTPSALPN}
+	zone, alpns, hasALPN := strings.Cut(s, "=")
+	z.Zone = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(zone), "."))
+	if z.Zone == "" {
+		return z, fmt.Errorf("invalid HTTPS zone %q, want zone[=alpn[|alpn...]]", s)
+	}
+	if !hasALPN {
+		return z, nil
+	}
+	z.ALPN = nil
+	for _, alpn := range strings.Split(alpns, "|") {
+		alpn = strings.TrimSpace(alpn)
+		if alpn == "" || len(alpn) > 255 || strings.ContainsAny(alpn, ",;\"") {
+			return z, fmt.Errorf("invalid HTTPS zone %q: invalid ALPN %q", s, alpn)
+		}
+		z.ALPN = append(z.ALPN, alpn)
+	}
+	return z, nil
+}
+
+// String returns z the way ParseHTTPSZone reads it
+func (z HTTPSZone) String() string {
+	return z.Zone + "=" + strings.Join(z.ALPN, "|")
+}
+
+// httpsAddr is an address of a name HTTPS records may be synthesized for
+type httpsAddr struct {
+	name string
+	addr netip.Addr
+	ttl  uint32
+	lo   Loc
+}
+
+// httpsData accumulates what HTTPS records are synthesized from
+type httpsData struct {
+	addrs []httpsAddr
+	// existing are the owners of the HTTPS records of the data
+	existing map[string]struct{}
+}
+
+// httpsZone returns the HTTPS zone of c.HTTPSZones name is in, the closest
+// one if several
+func (c *Codec) httpsZone(name string) (HTTPSZone, bool) {
+	var closest HTTPSZone
+	found := false
+	for _, z := range c.HTTPSZones {
+		if inZone(name, z.Zone) && (!found || len(z.Zone) > len(closest.Zone)) {
+			closest, found = z, true
+		}
+	}
+	return closest, found
+}
+
+// addHTTPS accounts the records of s which HTTPS records are synthesized
+// from, with Codec.HTTPSZones
+func (r *Accum) addHTTPS(s Record, c *Codec) {
+	if len(c.HTTPSZones) == 0 {
+		return
+	}
+	switch s := s.(type) {
+	case *Rpaddr:
+		r.addHTTPS((*Raddr)(s), c)
+	case *Raddr:
+		if s.ip == nil || s.iswildcard {
+			return
+		}
+		addr, ok := netip.AddrFromSlice(s.ip)
+		if !ok {
+			return
+		}
+		name := normalizedName(s.dom)
+		if _, ok := c.httpsZone(name); !ok {
+			return
+		}
+		r.mux.Lock()
+		defer r.mux.Unlock()
+		r.https.addrs = append(r.https.addrs, httpsAddr{name: name, addr: addr.Unmap(), ttl: s.ttl, lo: s.lo})
+	case *Rhttps:
+		if s.iswildcard {
+			return
+		}
+		r.mux.Lock()
+		defer r.mux.Unlock()
+		if r.https.existing == nil {
+			r.https.existing = make(map[string]struct{})
+		}
+		r.https.existing[normalizedName(s.dom)] = struct{}{}
+	}
+}
+
+// httpsRecords returns the HTTPS records synthesized from the data, one per
+// name and location, sorted by name and location
+func (r *Accum) httpsRecords(c *Codec) ([]Record, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	type httpsKey struct {
+		name string
+		lo   string
+	}
+	type httpsValue struct {
+		ttl   uint32
+		addrs map[netip.Addr]struct{}
+	}
+	values := make(map[httpsKey]*httpsValue)
+	for _, a := range r.https.addrs {
+		if _, ok := r.https.existing[a.name]; ok {
+			continue
+		}
+		k := httpsKey{name: a.name, lo: string(a.lo)}
+		v, ok := values[k]
+		if !ok {
+			v = &httpsValue{ttl: a.ttl, addrs: make(map[netip.Addr]struct{})}
+			values[k] = v
+		}
+		v.ttl = min(v.ttl, a.ttl)
+		v.addrs[a.addr] = struct{}{}
+	}
+	keys := make([]httpsKey, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].lo < keys[j].lo
+	})
+
+	records := make([]Record, 0, len(keys))
+	for _, k := range keys {
+		z, _ := c.httpsZone(k.name)
+		v := values[k]
+		addrs := make([]netip.Addr, 0, len(v.addrs))
+		for addr := range v.addrs {
+			addrs = append(addrs, addr)
+		}
+		sort.Slice(addrs, func(i, j int) bool {
+			return addrs[i].Less(addrs[j])
+		})
+		rec := &Rhttps{c: c, wtype: TypeHTTPS, priority: 1}
+		rec.dom = []byte(k.name)
+		rec.ttl = v.ttl
+		if k.lo != "" {
+			rec.lo = Loc(k.lo)
+		}
+		rec.tgtname, _ = getdom([]byte("."))
+		if err := rec.params.FromText(httpsParams(z.ALPN, addrs)); err != nil {
+			return nil, fmt.Errorf("failed to synthesize the HTTPS record of %s: %w", k.name, err)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// httpsParams returns the SvcParams of a synthesized HTTPS record, in the
+// data format
+func httpsParams(alpn []string, addrs []netip.Addr) []byte {
+	var v4, v6 []string
+	for _, addr := range addrs {
+		if addr.Is4() {
+			v4 = append(v4, addr.String())
+		} else {
+			v6 = append(v6, addr.String())
+		}
+	}
+	var buf bytes.Buffer
+	buf.WriteString("alpn=" + strings.Join(alpn, "|"))
+	if len(v4) > 0 {
+		buf.WriteString(";ipv4hint=" + strings.Join(v4, "|"))
+	}
+	if len(v6) > 0 {
+		buf.WriteString(";ipv6hint=" + strings.Join(v6, "|"))
+	}
+	return buf.Bytes()
+}
+
+// marshalHTTPSZones returns the HTTPS records synthesized from the data with
+// Codec.HTTPSZones
+func (c *Codec) marshalHTTPSZones() ([]MapRecord, error) {
+	if len(c.HTTPSZones) == 0 {
+		return nil, nil
+	}
+	records, err := c.Acc.httpsRecords(c)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		if err = c.Acc.addOwners(rec, c); err != nil {
+			return nil, err
+		}
+	}
+	return marshalMapv(records...)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHTTPSZone(t *testing.T) {
+	z, err := ParseHTTPSZone("Example.COM.")
+	require.NoError(t, err)
+	require.Equal(t, HTTPSZone{Zone: "example.com", ALPN: []string{"h2", "h3"}}, z)
+	require.Equal(t, "example.com=h2|h3", z.String())
+
+	z, err = ParseHTTPSZone("example.com=h3")
+	require.NoError(t, err)
+	require.Equal(t, HTTPSZone{Zone: "example.com", ALPN: []string{"h3"}}, z)
+
+	for _, in := range []string{
+		"",
+		".",
+		"=h2",
+		"example.com=",
+		"example.com=h2||h3",
+		"example.com=h2;port=53",
+	} {
+		_, err := ParseHTTPSZone(in)
+		require.Error(t, err, in)
+	}
+}
+
+// httpsTestData has names in and out of the HTTPS zones, some with HTTPS records
+// already
+const httpsTestData = `Zexample.com,ns.example.com,dns.example.com,123,1800,900,604800,3600,300
+&example.com,,ns.example.com,300
++www.example.com,192.0.2.2,300
++WWW.example.com.,192.0.2.1,60
++www.example.com,192.0.2.1,300
++www.example.com,2001:db8::1,300
++www.example.com,192.0.2.3,300,,\001\002
+=pub.example.com,192.0.2.5,300
++explicit.example.com,192.0.2.4,300
+Hexplicit.example.com,.,300,,1,alpn=h2
++*.w.example.com,192.0.2.7,300
+@example.com,192.0.2.6,mx.example.com,10,300
++api.sub.example.com,192.0.2.8,300
++other.example.org,192.0.2.9,300
+`
+
+func TestHTTPSZones(t *testing.T) {
+	var zones []HTTPSZone
+	for _, s := range []string{"example.com", "sub.example.com=h3"} {
+		z, err := ParseHTTPSZone(s)
+		require.NoError(t, err)
+		zones = append(zones, z)
+	}
+	codec := &Codec{HTTPSZones: zones}
+	records, err := Parse(strings.NewReader(httpsTestData), codec, 2)
+	require.NoError(t, err)
+
+	expected, err := Parse(strings.NewReader(httpsTestData+`Hapi.sub.example.com,.,300,,1,alpn=h3;ipv4hint=192.0.2.8
+Hpub.example.com,.,300,,1,alpn=h2|h3;ipv4hint=192.0.2.5
+Hwww.example.com,.,60,,1,alpn=h2|h3;ipv4hint=192.0.2.1|192.0.2.2;ipv6hint=2001:db8::1
+Hwww.example.com,.,300,\001\002,1,alpn=h2|h3;ipv4hint=192.0.2.3
+`), new(Codec), 2)
+	require.NoError(t, err)
+	require.Equal(t, sortedRecords(expected), sortedRecords(records))
+
+	// nothing is synthesized outside of the HTTPS zones
+	codec = &Codec{HTTPSZones: []HTTPSZone{{Zone: "example.net", ALPN: DefaultHTTPSALPN}}}
+	records, err = Parse(strings.NewReader(httpsTestData), codec, 1)
+	require.NoError(t, err)
+	expected, err = Parse(strings.NewReader(httpsTestData), new(Codec), 1)
+	require.NoError(t, err)
+	require.Equal(t, sortedRecords(expected), sortedRecords(records))
+}
@@ -0,0 +1,279 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DataSummary condenses a data file into what matters when reviewing a publish:
+// zones, record counts and TTLs per type, and location maps
+type DataSummary struct {
+	Zones   map[string]struct{}
+	Records map[string]int            // number of records per type
+	TTLs    map[string]map[uint32]int // number of records per type and TTL
+	// Subnets is the location of each subnet, per location map
+	Subnets map[string]map[string]string
+	// Maps is the location map assigned by each "M" and "8" record, keyed by
+	// record prefix and name
+	Maps map[string]string
+}
+
+func newDataSummary() *DataSummary {
+	return &DataSummary{
+		Zones:   make(map[string]struct{}),
+		Records: make(map[string]int),
+		TTLs:    make(map[string]map[uint32]int),
+		Subnets: make(map[string]map[string]string),
+		Maps:    make(map[string]string),
+	}
+}
+
+// Summarize parses the data from r and summarizes it
+func Summarize(r io.Reader, codec *Codec, workers int) (*DataSummary, error) {
+	summary := newDataSummary()
+	results := make(chan Record, 1024)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- ParseRecords(r, codec, results, workers)
+	}()
+	for record := range results {
+		summary.add(record)
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// SummarizeFile parses and summarizes the data file or directory at path,
+// see Input
+func SummarizeFile(path string, workers int) (*DataSummary, error) {
+	f, err := NewInput([]string{path}, DuplicatesKeep)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	summary, err := Summarize(f, new(Codec), workers)
+	if err != nil {
+		return nil, fmt.Errorf("summarizing %s: %w", path, err)
+	}
+	return summary, nil
+}
+
+// CompareFiles returns the impact of replacing the data file at beforePath
+// with the one at afterPath, e.g. the previously published data with the new one
+func CompareFiles(beforePath, afterPath string, workers int) (*ImpactSummary, error) {
+	before, err := SummarizeFile(beforePath, workers)
+	if err != nil {
+		return nil, err
+	}
+	after, err := SummarizeFile(afterPath, workers)
+	if err != nil {
+		return nil, err
+	}
+	return CompareSummaries(before, after), nil
+}
+
+func (s *DataSummary) add(record Record) {
+	switch r := record.(type) {
+	case CompositeRecord:
+		for _, derived := range r.DerivedRecords() {
+			s.add(derived)
+		}
+	case *Rnet:
+		name := r.lmap.String()
+		if s.Subnets[name] == nil {
+			s.Subnets[name] = make(map[string]string)
+		}
+		var lo strings.Builder
+		Putloctext(&lo, r.lo)
+		s.Subnets[name][r.ipnet.String()] = lo.String()
+	case *Ripmap:
+		s.Maps[string(prefixIPMap)+string(r.dom)] = r.lmap.String()
+	case *Rcsmap:
+		s.Maps[string(prefixCSMap)+string(r.dom)] = r.lmap.String()
+	case WireRecord:
+		t := r.WireType().String()
+		if r.WireType() == TypeSOA {
+			s.Zones[r.DomainName()] = struct{}{}
+		}
+		s.Records[t]++
+		if s.TTLs[t] == nil {
+			s.TTLs[t] = make(map[uint32]int)
+		}
+		s.TTLs[t][r.TTL()]++
+	}
+}
+
+// CountChange is a change of the number of records of a type
+type CountChange struct {
+	Type   string `json:"type"`
+	Before int    `json:"before"`
+	After  int    `json:"after"`
+}
+
+// TTLChange is a change of the number of records of a type with a given TTL
+type TTLChange struct {
+	Type   string `json:"type"`
+	TTL    uint32 `json:"ttl"`
+	Before int    `json:"before"`
+	After  int    `json:"after"`
+}
+
+// SubnetChange is a subnet added to, removed from or moved within a location map.
+// Before or After are empty when the subnet is absent.
+type SubnetChange struct {
+	Map    string `json:"map"`
+	Subnet string `json:"subnet"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// MapChange is a change of the location map assigned by an "M" or "8" record.
+// Before or After are empty when the record is absent.
+type MapChange struct {
+	Record string `json:"record"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// ImpactSummary lists the differences between two data summaries
+type ImpactSummary struct {
+	ZonesAdded   []string       `json:"zones_added,omitempty"`
+	ZonesRemoved []string       `json:"zones_removed,omitempty"`
+	Records      []CountChange  `json:"records,omitempty"`
+	TTLs         []TTLChange    `json:"ttls,omitempty"`
+	Subnets      []SubnetChange `json:"subnets,omitempty"`
+	Maps         []MapChange    `json:"maps,omitempty"`
+}
+
+// CompareSummaries returns the impact of going from before to after, sorted
+func CompareSummaries(before, after *DataSummary) *ImpactSummary {
+	impact := &ImpactSummary{}
+	for _, zone := range unionKeys(before.Zones, after.Zones) {
+		_, inBefore := before.Zones[zone]
+		_, inAfter := after.Zones[zone]
+		if !inBefore {
+			impact.ZonesAdded = append(impact.ZonesAdded, zone)
+		} else if !inAfter {
+			impact.ZonesRemoved = append(impact.ZonesRemoved, zone)
+		}
+	}
+	for _, t := range unionKeys(before.Records, after.Records) {
+		if before.Records[t] != after.Records[t] {
+			impact.Records = append(impact.Records, CountChange{Type: t, Before: before.Records[t], After: after.Records[t]})
+		}
+	}
+	for _, t := range unionKeys(before.TTLs, after.TTLs) {
+		b, a := before.TTLs[t], after.TTLs[t]
+		ttls := make(map[uint32]struct{})
+		for ttl := range b {
+			ttls[ttl] = struct{}{}
+		}
+		for ttl := range a {
+			ttls[ttl] = struct{}{}
+		}
+		sorted := make([]uint32, 0, len(ttls))
+		for ttl := range ttls {
+			sorted = append(sorted, ttl)
+		}
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		for _, ttl := range sorted {
+			if b[ttl] != a[ttl] {
+				impact.TTLs = append(impact.TTLs, TTLChange{Type: t, TTL: ttl, Before: b[ttl], After: a[ttl]})
+			}
+		}
+	}
+	for _, name := range unionKeys(before.Subnets, after.Subnets) {
+		b, a := before.Subnets[name], after.Subnets[name]
+		for _, subnet := range unionKeys(b, a) {
+			if b[subnet] != a[subnet] {
+				impact.Subnets = append(impact.Subnets, SubnetChange{Map: name, Subnet: subnet, Before: b[subnet], After: a[subnet]})
+			}
+		}
+	}
+	for _, record := range unionKeys(before.Maps, after.Maps) {
+		if before.Maps[record] != after.Maps[record] {
+			impact.Maps = append(impact.Maps, MapChange{Record: record, Before: before.Maps[record], After: after.Maps[record]})
+		}
+	}
+	return impact
+}
+
+// unionKeys returns the sorted keys present in a or b
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Empty tells whether there is no difference at all
+func (i *ImpactSummary) Empty() bool {
+	return len(i.ZonesAdded) == 0 && len(i.ZonesRemoved) == 0 && len(i.Records) == 0 &&
+		len(i.TTLs) == 0 && len(i.Subnets) == 0 && len(i.Maps) == 0
+}
+
+// WriteText writes a human readable version of the impact to w
+func (i *ImpactSummary) WriteText(w io.Writer) error {
+	var b strings.Builder
+	if i.Empty() {
+		b.WriteString("no changes\n")
+	}
+	for _, zone := range i.ZonesAdded {
+		fmt.Fprintf(&b, "zone added: %s\n", zone)
+	}
+	for _, zone := range i.ZonesRemoved {
+		fmt.Fprintf(&b, "zone removed: %s\n", zone)
+	}
+	for _, c := range i.Records {
+		fmt.Fprintf(&b, "%s records: %d -> %d (%+d)\n", c.Type, c.Before, c.After, c.After-c.Before)
+	}
+	for _, c := range i.TTLs {
+		fmt.Fprintf(&b, "%s records with TTL %d: %d -> %d (%+d)\n", c.Type, c.TTL, c.Before, c.After, c.After-c.Before)
+	}
+	for _, c := range i.Subnets {
+		switch {
+		case c.Before == "":
+			fmt.Fprintf(&b, "map %s: subnet %s added to location %s\n", c.Map, c.Subnet, c.After)
+		case c.After == "":
+			fmt.Fprintf(&b, "map %s: subnet %s removed from location %s\n", c.Map, c.Subnet, c.Before)
+		default:
+			fmt.Fprintf(&b, "map %s: subnet %s moved from location %s to %s\n", c.Map, c.Subnet, c.Before, c.After)
+		}
+	}
+	for _, c := range i.Maps {
+		switch {
+		case c.Before == "":
+			fmt.Fprintf(&b, "%s added, map %s\n", c.Record, c.After)
+		case c.After == "":
+			fmt.Fprintf(&b, "%s removed, was map %s\n", c.Record, c.Before)
+		default:
+			fmt.Fprintf(&b, "%s changed from map %s to %s\n", c.Record, c.Before, c.After)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
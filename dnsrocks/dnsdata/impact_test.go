@@ -0,0 +1,96 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const impactBefore = `Zfoo.test,ns.test,dns.test,123,1800,900,604800,3600,300
+Zbar.test,ns.test,dns.test,123,1800,900,604800,3600,300
++www.foo.test,127.0.0.1,300
++www.bar.test,127.0.0.2,300
+%aa,10.0.0.0/8,m1
+%bb,192.168.0.0/16,m1
+Mresolver.test,m1
+`
+
+const impactAfter = `Zfoo.test,ns.test,dns.test,123,1800,900,604800,3600,300
+Zbaz.test,ns.test,dns.test,123,1800,900,604800,3600,300
++www.foo.test,127.0.0.1,60
++www.baz.test,127.0.0.2,300
++api.baz.test,127.0.0.3,300
+%aa,10.0.0.0/8,m1
+%cc,192.168.0.0/16,m1
+%aa,172.16.0.0/12,m1
+Mresolver.test,m2
+`
+
+func summarize(t *testing.T, data string) *DataSummary {
+	summary, err := Summarize(strings.NewReader(data), new(Codec), 2)
+	require.NoError(t, err)
+	return summary
+}
+
+func TestSummarize(t *testing.T) {
+	summary := summarize(t, impactBefore)
+	require.Equal(t, map[string]struct{}{"foo.test": {}, "bar.test": {}}, summary.Zones)
+	require.Equal(t, map[string]int{"SOA": 2, "A": 2}, summary.Records)
+	require.Equal(t, map[uint32]int{300: 2}, summary.TTLs["A"])
+	require.Equal(t, map[string]string{"10.0.0.0/8": `\141\141`, "192.168.0.0/16": `\142\142`}, summary.Subnets[`\155\061`])
+	require.Equal(t, map[string]string{"Mresolver.test": `\155\061`}, summary.Maps)
+}
+
+func TestSummarizeError(t *testing.T) {
+	_, err := Summarize(strings.NewReader("some\nrandom\nstring\n"), new(Codec), 1)
+	require.Error(t, err)
+}
+
+func TestCompareSummaries(t *testing.T) {
+	impact := CompareSummaries(summarize(t, impactBefore), summarize(t, impactAfter))
+	require.Equal(t, &ImpactSummary{
+		ZonesAdded:   []string{"baz.test"},
+		ZonesRemoved: []string{"bar.test"},
+		Records:      []CountChange{{Type: "A", Before: 2, After: 3}},
+		TTLs:         []TTLChange{{Type: "A", TTL: 60, Before: 0, After: 1}},
+		Subnets: []SubnetChange{
+			{Map: `\155\061`, Subnet: "172.16.0.0/12", After: `\141\141`},
+			{Map: `\155\061`, Subnet: "192.168.0.0/16", Before: `\142\142`, After: `\143\143`},
+		},
+		Maps: []MapChange{{Record: "Mresolver.test", Before: `\155\061`, After: `\155\062`}},
+	}, impact)
+
+	var text bytes.Buffer
+	require.NoError(t, impact.WriteText(&text))
+	require.Equal(t, `zone added: baz.test
+zone removed: bar.test
+A records: 2 -> 3 (+1)
+A records with TTL 60: 0 -> 1 (+1)
+map \155\061: subnet 172.16.0.0/12 added to location \141\141
+map \155\061: subnet 192.168.0.0/16 moved from location \142\142 to \143\143
+Mresolver.test changed from map \155\061 to \155\062
+`, text.String())
+}
+
+func TestCompareSummariesNoChange(t *testing.T) {
+	impact := CompareSummaries(summarize(t, impactBefore), summarize(t, impactBefore))
+	require.True(t, impact.Empty())
+	var text bytes.Buffer
+	require.NoError(t, impact.WriteText(&text))
+	require.Equal(t, "no changes\n", text.String())
+}
@@ -0,0 +1,342 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IncludeDirective starts the lines replaced by the data of a file, or of
+// the files of a directory in file name order:
+//
+//	$INCLUDE <path>
+//
+// Relative paths are relative to the directory of the including file.
+const IncludeDirective = "$INCLUDE"
+
+// DuplicatePolicy is what to do with records written more than once in the
+// input
+type DuplicatePolicy int
+
+// Duplicate policies
+const (
+	// DuplicatesKeep compiles records as many times as they are written
+	DuplicatesKeep DuplicatePolicy = iota
+	// DuplicatesDrop compiles records once, where first written
+	DuplicatesDrop
+	// DuplicatesError fails on records written more than once
+	DuplicatesError
+)
+
+func (p DuplicatePolicy) String() string {
+	switch p {
+	case DuplicatesKeep:
+		return "keep"
+	case DuplicatesDrop:
+		return "drop"
+	case DuplicatesError:
+		return "error"
+	}
+	return fmt.Sprintf("%d", int(p))
+}
+
+// ParseDuplicatePolicy returns the policy named s
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	for _, p := range []DuplicatePolicy{DuplicatesKeep, DuplicatesDrop, DuplicatesError} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return DuplicatesKeep, fmt.Errorf("unknown duplicate policy %q, expected keep, drop or error", s)
+}
+
+// ListInputFiles returns the data files of path: path itself if it is a
+// file, or the regular files below it in file name order, hidden ones
+// excepted, if it is a directory
+func ListInputFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != path && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// inputFile is a file being read by an Input
+type inputFile struct {
+	path    string
+	parent  *inputFile // the file including this one
+	f       *os.File
+	scanner *bufio.Scanner
+	line    int
+	gen     *generator // the $GENERATE directive being expanded
+}
+
+// inputPos is the position of a line in the input
+type inputPos struct {
+	path string
+	line int
+}
+
+func (p inputPos) String() string {
+	return fmt.Sprintf("%s:%d", p.path, p.line)
+}
+
+// Input is an io.Reader of the data of several files, read in order, with
+// their $INCLUDE directives replaced by the included files and their
+// $GENERATE directives expanded, see IncludeDirective and GenerateDirective.
+// Records written more than once are handled according to its
+// DuplicatePolicy.
+type Input struct {
+	duplicates DuplicatePolicy
+	// files to read, the next one last
+	stack []*inputFile
+	buf   bytes.Buffer
+	err   error
+	// generated holds the last line expanded from a $GENERATE directive
+	generated bytes.Buffer
+	// the first position of each record, by hash, unless duplicates are kept
+	seen map[[16]byte]inputPos
+	// Dropped is the number of duplicate records dropped
+	Dropped int
+}
+
+// NewInput returns an Input reading the files of paths, see ListInputFiles
+func NewInput(paths []string, duplicates DuplicatePolicy) (*Input, error) {
+	in := &Input{duplicates: duplicates}
+	if duplicates != DuplicatesKeep {
+		in.seen = make(map[[16]byte]inputPos)
+	}
+	var files []string
+	for _, path := range paths {
+		f, err := ListInputFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f...)
+	}
+	in.push(files, nil)
+	return in, nil
+}
+
+// push adds files, included by parent, to the files to read next
+func (in *Input) push(files []string, parent *inputFile) {
+	for i := len(files) - 1; i >= 0; i-- {
+		in.stack = append(in.stack, &inputFile{path: files[i], parent: parent})
+	}
+}
+
+// include pushes the files included by the directive line of f
+func (in *Input) include(f *inputFile, line string) error {
+	rest := strings.TrimPrefix(line, IncludeDirective)
+	path := strings.TrimSpace(rest)
+	if path == "" || (rest[0] != ' ' && rest[0] != '\t') {
+		return fmt.Errorf("expected %s <path>", IncludeDirective)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(f.path), path)
+	}
+	files, err := ListInputFiles(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		for p := f; p != nil; p = p.parent {
+			if sameFile(file, p.path) {
+				return fmt.Errorf("%s includes itself", file)
+			}
+		}
+	}
+	in.push(files, f)
+	return nil
+}
+
+// sameFile returns true if paths a and b are the same file
+func sameFile(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fa, fb)
+}
+
+// isIncludeDirective returns true if line is an $INCLUDE directive
+func isIncludeDirective(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(line, " \t"), []byte(IncludeDirective))
+}
+
+// isDuplicate tracks the record line at pos, and returns true if it was
+// already read
+func (in *Input) isDuplicate(line []byte, pos inputPos) (bool, error) {
+	line = bytes.TrimSpace(line)
+	if in.seen == nil || len(line) < 2 || decodeRtype(line) == prefixComment {
+		return false, nil
+	}
+	h := fnv.New128a()
+	h.Write(line)
+	var key [16]byte
+	h.Sum(key[:0])
+	first, ok := in.seen[key]
+	if !ok {
+		in.seen[key] = pos
+		return false, nil
+	}
+	if in.duplicates == DuplicatesError {
+		return true, fmt.Errorf("%s: duplicate of the record at %s: %s", pos, first, line)
+	}
+	in.Dropped++
+	return true, nil
+}
+
+// add adds the record line at pos to the buffer, unless it is a dropped
+// duplicate
+func (in *Input) add(line []byte, pos inputPos) {
+	dup, err := in.isDuplicate(line, pos)
+	if err != nil {
+		in.err = err
+		return
+	}
+	if !dup {
+		in.buf.Write(line)
+		in.buf.WriteByte('\n')
+	}
+}
+
+// fill adds the next line of the input, or the next expansion of a
+// directive, to the buffer
+func (in *Input) fill() {
+	if len(in.stack) == 0 {
+		in.err = io.EOF
+		return
+	}
+	f := in.stack[len(in.stack)-1]
+	if f.f == nil {
+		var err error
+		if f.f, err = os.Open(f.path); err != nil {
+			in.err = err
+			return
+		}
+		f.scanner = bufio.NewScanner(f.f)
+	}
+	pos := inputPos{path: f.path, line: f.line}
+	if f.gen != nil {
+		in.generated.Reset()
+		if f.gen.next(&in.generated) {
+			in.add(bytes.TrimSuffix(in.generated.Bytes(), []byte("\n")), pos)
+			return
+		}
+		f.gen = nil
+	}
+	if !f.scanner.Scan() {
+		f.f.Close()
+		in.stack = in.stack[:len(in.stack)-1]
+		if err := f.scanner.Err(); err != nil {
+			in.err = fmt.Errorf("%s: %w", f.path, err)
+		}
+		return
+	}
+	f.line++
+	pos.line = f.line
+	line := f.scanner.Bytes()
+	var err error
+	switch {
+	case isIncludeDirective(line):
+		err = in.include(f, strings.TrimSpace(string(line)))
+	case isGenerateDirective(line):
+		f.gen, err = newGenerator(line)
+	default:
+		in.add(line, pos)
+	}
+	if err != nil {
+		in.err = fmt.Errorf("%s: %s: %w", pos, line, err)
+	}
+}
+
+// Read implements io.Reader
+func (in *Input) Read(p []byte) (int, error) {
+	for in.buf.Len() == 0 {
+		if in.err != nil {
+			return 0, in.err
+		}
+		in.fill()
+	}
+	return in.buf.Read(p)
+}
+
+// Close closes the files being read
+func (in *Input) Close() error {
+	for _, f := range in.stack {
+		if f.f != nil {
+			f.f.Close()
+		}
+	}
+	in.stack = nil
+	return nil
+}
+
+// DeriveInputSerial returns a SOA serial derived from the latest modification
+// time of the files of paths, see ListInputFiles. Included files are not
+// taken into account.
+func DeriveInputSerial(paths []string) (uint32, error) {
+	var serial uint32
+	for _, path := range paths {
+		files, err := ListInputFiles(path)
+		if err != nil {
+			return 0, err
+		}
+		for _, file := range files {
+			f, err := os.Open(file)
+			if err != nil {
+				return 0, err
+			}
+			s, err := DeriveSerial(f)
+			f.Close()
+			if err != nil {
+				return 0, err
+			}
+			serial = max(serial, s)
+		}
+	}
+	return serial, nil
+}
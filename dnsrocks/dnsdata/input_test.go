@@ -0,0 +1,117 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeInputFiles writes files, by path relative to dir
+func writeInputFiles(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+	}
+}
+
+func readInput(paths []string, duplicates DuplicatePolicy) (string, *Input, error) {
+	in, err := NewInput(paths, duplicates)
+	if err != nil {
+		return "", nil, err
+	}
+	defer in.Close()
+	out, err := io.ReadAll(in)
+	return string(out), in, err
+}
+
+func TestInput(t *testing.T) {
+	dir := t.TempDir()
+	writeInputFiles(t, dir, map[string]string{
+		"main.data":          "Zexample.com,ns.example.com,dns.example.com,,,,,,\n$INCLUDE teams\n+www.example.com,192.0.2.1\n",
+		"teams/02-b.data":    "+b.example.com,192.0.2.2\n",
+		"teams/01-a.data":    "+a.example.com,192.0.2.1\n  $INCLUDE ../common/hosts\n",
+		"teams/sub/c.data":   "$GENERATE 3-4 +c$.example.com,192.0.2.$\n",
+		"teams/.c.data.swp":  "garbage\n",
+		"teams/.hidden/d":    "garbage\n",
+		"common/hosts":       "+host.example.com,192.0.2.100\n",
+		"other/example.data": "+www.example.com,192.0.2.1\n# comment\n# comment\n",
+	})
+	paths := []string{filepath.Join(dir, "main.data"), filepath.Join(dir, "other")}
+
+	out, _, err := readInput(paths, DuplicatesKeep)
+	require.NoError(t, err)
+	require.Equal(t, `Zexample.com,ns.example.com,dns.example.com,,,,,,
++a.example.com,192.0.2.1
++host.example.com,192.0.2.100
++b.example.com,192.0.2.2
++c3.example.com,192.0.2.3
++c4.example.com,192.0.2.4
++www.example.com,192.0.2.1
++www.example.com,192.0.2.1
+# comment
+# comment
+`, out)
+
+	out, in, err := readInput(paths, DuplicatesDrop)
+	require.NoError(t, err)
+	require.Equal(t, `Zexample.com,ns.example.com,dns.example.com,,,,,,
++a.example.com,192.0.2.1
++host.example.com,192.0.2.100
++b.example.com,192.0.2.2
++c3.example.com,192.0.2.3
++c4.example.com,192.0.2.4
++www.example.com,192.0.2.1
+# comment
+# comment
+`, out)
+	require.Equal(t, 1, in.Dropped)
+
+	_, _, err = readInput(paths, DuplicatesError)
+	require.ErrorContains(t, err, filepath.Join(dir, "other/example.data")+":1: duplicate of the record at "+filepath.Join(dir, "main.data")+":3")
+}
+
+func TestInputErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeInputFiles(t, dir, map[string]string{
+		"loop.data":    "+a.example.com,192.0.2.1\n$INCLUDE loop2.data\n",
+		"loop2.data":   "$INCLUDE .\n",
+		"missing.data": "$INCLUDE nowhere.data\n",
+		"bad.data":     "$INCLUDEbad.data\n",
+		"gen.data":     "+a.example.com,192.0.2.1\n$GENERATE 2-1 +a$.example.com,192.0.2.$\n",
+	})
+	_, err := NewInput([]string{filepath.Join(dir, "nowhere.data")}, DuplicatesKeep)
+	require.Error(t, err)
+
+	for name, msg := range map[string]string{
+		"loop.data":    "loop2.data:1: $INCLUDE .: " + filepath.Join(dir, "loop.data") + " includes itself",
+		"missing.data": "missing.data:1",
+		"bad.data":     "bad.data:1",
+		"gen.data":     "gen.data:2: $GENERATE 2-1",
+	} {
+		_, _, err := readInput([]string{filepath.Join(dir, name)}, DuplicatesKeep)
+		require.ErrorContains(t, err, msg, name)
+	}
+
+	_, err = ParseDuplicatePolicy("bogus")
+	require.Error(t, err)
+	p, err := ParseDuplicatePolicy("drop")
+	require.NoError(t, err)
+	require.Equal(t, DuplicatesDrop, p)
+}
@@ -0,0 +1,137 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrNotV1Key is returned when converting a key which is not in the v1 keys
+// syntax
+var ErrNotV1Key = errors.New("not a v1 key")
+
+// V1MapKeysKey is a special key listing, as a multi-value, the v1 map keys
+// left in a DB migrated in place to the v2 keys syntax, until they are
+// removed. Unlike resource record keys, v1 and v2 map keys can't be told
+// apart.
+const V1MapKeysKey = "\x00o_v1mapkeys"
+
+// map keys whose domain is reversed in the v2 keys syntax, followed by '=' for
+// exact matches or '*' for wildcards
+var mapKeyMarkers = []string{"\000M", "\0008"}
+
+// keys which are the same in both syntaxes
+var (
+	v1v2KeyMarkers = []string{RangePointKeyMarker, MetadataKeyMarker, LocationFallbackKeyMarker, "\000%"}
+	v1v2Keys       = []string{ProvenanceKey, ChecksumKey, V1MapKeysKey, "\000/", "\0004", "\0006"}
+)
+
+// V2Key returns the v2 syntax of key, a key of a DB using the v1 keys syntax,
+// and true if it is different. Keys which are the same in both syntaxes, such
+// as location range points, metadata or the features key, whose value changes
+// instead (see V2Features), are returned as is, as are map keys whose labels
+// read the same reversed, e.g. of the root or of a single label.
+func V2Key(key []byte) ([]byte, bool, error) {
+	if string(key) == FeaturesKey {
+		return key, false, nil
+	}
+	for _, k := range v1v2Keys {
+		if string(key) == k {
+			return key, false, nil
+		}
+	}
+	for _, marker := range v1v2KeyMarkers {
+		if bytes.HasPrefix(key, []byte(marker)) {
+			return key, false, nil
+		}
+	}
+	for _, marker := range mapKeyMarkers {
+		if !bytes.HasPrefix(key, []byte(marker)) {
+			continue
+		}
+		labels, rest, ok := splitPackedDomain(key[len(marker):])
+		if ok && len(rest) == 1 && (rest[0] == '=' || rest[0] == '*') {
+			v2 := make([]byte, 0, len(key))
+			v2 = append(v2, marker...)
+			v2 = appendReversedLabels(v2, labels)
+			v2 = append(v2, rest...)
+			return v2, !bytes.Equal(v2, key), nil
+		}
+		// resource records of a location starting like a map marker
+	}
+
+	// resource records: location ID followed by the packed domain, and
+	// possibly trailing root labels written by legacy pipelines
+	locLen := 2
+	if len(key) >= 2 && key[0] == 0xff {
+		locLen += int(key[1])
+	}
+	if len(key) <= locLen {
+		return nil, false, fmt.Errorf("%w: %x", ErrNotV1Key, key)
+	}
+	labels, rest, ok := splitPackedDomain(key[locLen:])
+	if !ok || len(bytes.Trim(rest, "\000")) > 0 {
+		return nil, false, fmt.Errorf("%w: %x", ErrNotV1Key, key)
+	}
+	v2 := make([]byte, 0, len(key)+len(ResourceRecordsKeyMarker))
+	v2 = append(v2, ResourceRecordsKeyMarker...)
+	v2 = appendReversedLabels(v2, labels)
+	v2 = append(v2, rest...)
+	return append(v2, key[:locLen]...), true, nil
+}
+
+// V2Features returns the value of the features key of a DB using the v1 keys
+// syntax, once migrated to the v2 one. An empty value stands for DBs without
+// features key.
+func V2Features(value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return encodeFeatures(V2KeysFeature), nil
+	}
+	if len(value) != 4 {
+		return nil, fmt.Errorf("invalid features value %x", value)
+	}
+	features := DecodeFeatures(value)
+	features &^= V1KeysFeature
+	features |= V2KeysFeature
+	return encodeFeatures(features), nil
+}
+
+// splitPackedDomain splits the packed domain at the start of b into its
+// labels, and returns the bytes following its terminating zero
+func splitPackedDomain(b []byte) (labels [][]byte, rest []byte, ok bool) {
+	for len(b) > 0 {
+		n := int(b[0])
+		if n == 0 {
+			return labels, b[1:], true
+		}
+		if n > 63 || len(b) < n+1 {
+			return nil, nil, false
+		}
+		labels = append(labels, b[1:n+1])
+		b = b[n+1:]
+	}
+	return nil, nil, false
+}
+
+// appendReversedLabels appends the labels to b as a packed domain, last
+// label first, like putreverseddom
+func appendReversedLabels(b []byte, labels [][]byte) []byte {
+	for i := len(labels) - 1; i >= 0; i-- {
+		b = append(b, byte(len(labels[i])))
+		b = append(b, labels[i]...)
+	}
+	return append(b, 0)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sortedKeyValues returns the records as sorted "key value" strings
+func sortedKeyValues(records []MapRecord) []string {
+	kv := make([]string, len(records))
+	for i, r := range records {
+		kv[i] = string(r.Key) + " " + string(r.Value)
+	}
+	sort.Strings(kv)
+	return kv
+}
+
+func TestV2Key(t *testing.T) {
+	dataset := getDataSet()
+	for _, ranger := range []bool{false, true} {
+		newCodec := func(v2 bool) *Codec {
+			codec := &Codec{Serial: testSerial}
+			codec.Features.UseV2Keys = v2
+			if ranger {
+				codec.Acc.Ranger.Enable()
+				codec.Acc.NoPrefixSets = true
+				codec.NoRnetOutput = true
+			}
+			return codec
+		}
+		v1, err := Parse(bytes.NewReader(dataset), newCodec(false), 1)
+		require.NoError(t, err)
+		v2, err := Parse(bytes.NewReader(dataset), newCodec(true), 1)
+		require.NoError(t, err)
+
+		migrated := make([]MapRecord, len(v1))
+		changed := 0
+		for i, r := range v1 {
+			key, ok, err := V2Key(r.Key)
+			require.NoError(t, err, "%x", r.Key)
+			value := r.Value
+			if string(r.Key) == FeaturesKey {
+				value, err = V2Features(value)
+				require.NoError(t, err)
+			}
+			if ok {
+				changed++
+			}
+			migrated[i] = MapRecord{Key: key, Value: value}
+		}
+		require.NotZero(t, changed)
+		require.Equal(t, sortedKeyValues(v2), sortedKeyValues(migrated), "ranger %v", ranger)
+	}
+}
+
+func TestV2KeyErrors(t *testing.T) {
+	for _, key := range [][]byte{
+		{0, 0},
+		[]byte("\000\000\003www"),
+		[]byte("\000\000\003www\000x"),
+		[]byte("\xff\x05ab"),
+	} {
+		_, _, err := V2Key(key)
+		require.True(t, errors.Is(err, ErrNotV1Key), "%x: %v", key, err)
+	}
+
+	// map keys whose labels read the same reversed don't change
+	for _, key := range []string{"\000M\000=", "\0008\000*", "\000M\003com\000=", "\000M\001a\001b\001a\000*"} {
+		v2, ok, err := V2Key([]byte(key))
+		require.NoError(t, err)
+		require.False(t, ok, "%q", key)
+		require.Equal(t, key, string(v2))
+	}
+
+	// trailing root labels are kept
+	key, ok, err := V2Key([]byte("\000\001\003www\007example\000\000"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte("\000o\007example\003www\000\000\000\001"), key)
+
+	_, err = V2Features([]byte{1})
+	require.Error(t, err)
+	features, err := V2Features(encodeFeatures(V1KeysFeature))
+	require.NoError(t, err)
+	require.Equal(t, V2KeysFeature, DecodeFeatures(features))
+	features, err = V2Features(nil)
+	require.NoError(t, err)
+	require.Equal(t, V2KeysFeature, DecodeFeatures(features))
+}
@@ -0,0 +1,142 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	// maxLabelLen is the maximum length of a label, RFC 1035 2.3.4
+	maxLabelLen = 63
+	// maxNameWireLen is the maximum length of a name in wire format, RFC 1035 2.3.4
+	maxNameWireLen = 255
+)
+
+// ErrBadName - owner name rejected by the name checks
+var ErrBadName = errors.New("bad owner name")
+
+// idnaProfile converts between U-labels and A-labels the way resolvers do
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.Transitional(false),
+)
+
+// isUlabel tells whether label has non-ASCII characters
+func isUlabel(label []byte) bool {
+	for _, c := range label {
+		if c >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLabelChars checks that label only has letters, digits, hyphens and
+// underscores, and does not start or end with a hyphen
+func checkLabelChars(label []byte) error {
+	for _, c := range label {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return fmt.Errorf("label %q has invalid character %q", label, c)
+		}
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return fmt.Errorf("label %q starts or ends with a hyphen", label)
+	}
+	return nil
+}
+
+// checkAlabel checks that the punycode of an A-label round-trips
+func checkAlabel(label []byte) error {
+	u, err := idnaProfile.ToUnicode(string(label))
+	if err != nil {
+		return fmt.Errorf("label %q is not valid punycode: %w", label, err)
+	}
+	a, err := idnaProfile.ToASCII(u)
+	if err != nil {
+		return fmt.Errorf("label %q is not valid punycode: %w", label, err)
+	}
+	if a != string(label) {
+		return fmt.Errorf("label %q does not round-trip, expected %q", label, a)
+	}
+	return nil
+}
+
+// checkName applies the name checks enabled in the codec to the owner name
+// dom, and returns it with U-labels converted to A-labels if ConvertIDN is
+// set. A leading "*" label is allowed for wildcards.
+func (c *Codec) checkName(dom []byte) ([]byte, error) {
+	if !c.StrictNames && !c.ConvertIDN {
+		return dom, nil
+	}
+	name := bytes.TrimSuffix(dom, []byte("."))
+	if len(name) == 0 {
+		return dom, nil
+	}
+	labels := bytes.Split(name, []byte("."))
+	converted := false
+	wireLen := 1
+	for i, label := range labels {
+		if isUlabel(label) {
+			if !utf8.Valid(label) {
+				return nil, fmt.Errorf("%w %q: label %q is not valid UTF-8", ErrBadName, dom, label)
+			}
+			a, err := idnaProfile.ToASCII(string(label))
+			if err != nil {
+				return nil, fmt.Errorf("%w %q: label %q is not a valid IDN: %v", ErrBadName, dom, label, err)
+			}
+			if !c.ConvertIDN {
+				return nil, fmt.Errorf("%w %q: label %q is a U-label, use its A-label %q", ErrBadName, dom, label, a)
+			}
+			labels[i] = []byte(a)
+			converted = true
+		}
+		wireLen += len(labels[i]) + 1
+		if !c.StrictNames {
+			continue
+		}
+		label = bytes.ToLower(labels[i])
+		switch {
+		case len(label) == 0:
+			return nil, fmt.Errorf("%w %q: empty label", ErrBadName, dom)
+		case len(label) > maxLabelLen:
+			return nil, fmt.Errorf("%w %q: label %q is longer than %d characters", ErrBadName, dom, label, maxLabelLen)
+		case i == 0 && bytes.Equal(label, []byte("*")):
+			continue
+		}
+		if err := checkLabelChars(label); err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrBadName, dom, err)
+		}
+		if bytes.HasPrefix(label, []byte("xn--")) {
+			if err := checkAlabel(label); err != nil {
+				return nil, fmt.Errorf("%w %q: %v", ErrBadName, dom, err)
+			}
+		}
+	}
+	if c.StrictNames && wireLen > maxNameWireLen {
+		return nil, fmt.Errorf("%w %q: longer than %d bytes in wire format", ErrBadName, dom, maxNameWireLen)
+	}
+	if !converted {
+		return dom, nil
+	}
+	return bytes.Join(labels, []byte(".")), nil
+}
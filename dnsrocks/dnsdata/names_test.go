@@ -0,0 +1,86 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrictNames(t *testing.T) {
+	long := strings.Repeat("a", 64)
+	longName := strings.Repeat(strings.Repeat("a", 63)+".", 4) + "com"
+	testCases := []struct {
+		in    string
+		valid bool
+	}{
+		{in: "+www.example.com,1.2.3.4", valid: true},
+		{in: "+WWW.Example.COM.,1.2.3.4", valid: true},
+		{in: "+*.example.com,1.2.3.4", valid: true},
+		{in: "C*.example.com,www.example.com", valid: true},
+		{in: "'_dmarc.example.com,v=DMARC1", valid: true},
+		{in: "+xn--bcher-kva.example,1.2.3.4", valid: true},
+		{in: "Mxn--bcher-kva.example,c\\000", valid: true},
+		{in: "+" + strings.Repeat("a", 63) + ".com,1.2.3.4", valid: true},
+		{in: "+" + long + ".com,1.2.3.4"},
+		{in: "+" + longName + ",1.2.3.4"},
+		{in: "+www..example.com,1.2.3.4"},
+		{in: "+www.exa mple.com,1.2.3.4"},
+		{in: "+www.exa/mple.com,1.2.3.4"},
+		{in: "+-www.example.com,1.2.3.4"},
+		{in: "+www-.example.com,1.2.3.4"},
+		{in: "+www.*.example.com,1.2.3.4"},
+		{in: "+xn--abc-.example,1.2.3.4"},
+		{in: "+xn--BCHER-KVA.example,1.2.3.4", valid: true},
+		{in: "+xn--a.example,1.2.3.4"},
+		{in: "+bücher.example,1.2.3.4"},
+		{in: "Mbücher.example,c\\000"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			codec := &Codec{StrictNames: true}
+			_, err := codec.ConvertLn([]byte(tc.in))
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, ErrBadName)
+			}
+			// without the checks, every name is accepted
+			_, err = new(Codec).ConvertLn([]byte(tc.in))
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestConvertIDN(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		codec := &Codec{ConvertIDN: true, StrictNames: strict}
+		got, err := codec.ConvertLn([]byte("+www.Bücher.example,1.2.3.4"))
+		require.NoError(t, err)
+		want, err := new(Codec).ConvertLn([]byte("+www.xn--bcher-kva.example,1.2.3.4"))
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+
+		got, err = codec.ConvertLn([]byte("Mbücher.example,c\\000"))
+		require.NoError(t, err)
+		want, err = new(Codec).ConvertLn([]byte("Mxn--bcher-kva.example,c\\000"))
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+
+		_, err = codec.ConvertLn([]byte("+www.\xff.example,1.2.3.4"))
+		require.ErrorIs(t, err, ErrBadName)
+	}
+}
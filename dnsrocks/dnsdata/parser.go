@@ -20,6 +20,7 @@ import (
 	"io"
 	"runtime"
 	"sync"
+	"sync/atomic"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -41,13 +42,15 @@ func ParseStream(r io.Reader, codec *Codec, results chan<- []MapRecord, workers
 
 	err := parse(
 		r,
-		func(line []byte) error {
+		func(line []byte) ([]MapRecord, error) {
 			v, err := codec.ConvertLn(line)
 			if err != nil {
-				return fmt.Errorf("Conversion failed for line '%s': %w", line, err)
+				return nil, fmt.Errorf("Conversion failed for line '%s': %w", line, err)
 			}
+			return v, nil
+		},
+		func(v []MapRecord) {
 			results <- v
-			return nil
 		},
 		workers)
 
@@ -55,14 +58,37 @@ func ParseStream(r io.Reader, codec *Codec, results chan<- []MapRecord, workers
 		return err
 	}
 
+	// Pack the generated reverse zones, before the empty non-terminals
+	// their names may have
+	v, err := codec.marshalReverseZones()
+	if err != nil {
+		return fmt.Errorf("reverse zones marshalling failed: %w", err)
+	}
+	results <- v
+
+	// Pack the synthesized HTTPS records
+	v, err = codec.marshalHTTPSZones()
+	if err != nil {
+		return fmt.Errorf("HTTPS records marshalling failed: %w", err)
+	}
+	results <- v
+
 	// Pack the accumulated state
-	v, err := codec.Acc.MarshalMap()
+	v, err = codec.Acc.MarshalMap()
 	if err != nil {
 		return fmt.Errorf("acc marshalling failed: %w", err)
 	}
 	results <- v
 
+	// Pack the empty non-terminals
+	v, err = codec.marshalEmptyNonTerminals()
+	if err != nil {
+		return fmt.Errorf("empty non-terminals marshalling failed: %w", err)
+	}
+	results <- v
+
 	// Pack the supported features
+	codec.Features.EmptyNonTerminals = codec.EmptyNonTerminals
 	v, err = codec.Features.MarshalMap()
 	if err != nil {
 		return fmt.Errorf("features marshalling failed: %w", err)
@@ -79,62 +105,121 @@ func ParseRecords(r io.Reader, codec *Codec, results chan<- Record, workers int)
 
 	return parse(
 		r,
-		func(line []byte) error {
+		func(line []byte) (Record, error) {
 			v, err := codec.DecodeLn(line)
 			if err != nil {
-				return fmt.Errorf("parsing failed for line '%s': %w", line, err)
+				return nil, fmt.Errorf("parsing failed for line '%s': %w", line, err)
 			}
+			return v, nil
+		},
+		func(v Record) {
 			results <- v
-			return nil
 		},
 		workers)
 }
 
-func parse(r io.Reader, process func([]byte) error, workers int) error {
+// parseChunkSize is the number of consecutive lines a worker converts at once
+const parseChunkSize = 64
+
+// parseChunk holds consecutive lines of the input and their conversions
+type parseChunk[T any] struct {
+	lines   [][]byte
+	results []T
+	err     error
+	done    chan struct{} // closed once the lines are converted
+}
+
+func newParseChunk[T any]() *parseChunk[T] {
+	return &parseChunk[T]{
+		lines: make([][]byte, 0, parseChunkSize),
+		done:  make(chan struct{}),
+	}
+}
+
+// parse converts the lines of r with convert, in parallel when workers != 1,
+// and passes the results to emit in the order of the lines, so the output does
+// not depend on the number of workers.
+func parse[T any](r io.Reader, convert func([]byte) (T, error), emit func(T), workers int) error {
 	workers, err := getWorkers(workers)
 	if err != nil {
 		return err
 	}
 
-	// Setup scanner to go over the file line by line
-	scanner := bufio.NewScanner(r)
+	// Setup scanner to go over the file line by line, directives expanded
+	scanner := bufio.NewScanner(NewGenerateReader(r))
 	scanner.Split(bufio.ScanLines)
 
-	var g errgroup.Group
-	c := make(chan []byte, workers*10) // 10 came out of experiments, allows some buffering
+	var failed atomic.Bool
+	jobs := make(chan *parseChunk[T], workers*2)
+	// chunks in the order of the input, waiting to be emitted
+	pending := make(chan *parseChunk[T], workers*4)
 
+	var wg sync.WaitGroup
 	for i := 0; i < workers; i++ {
-		g.Go(func() error {
-			for line := range c {
-				if err := process(line); err != nil {
-					return err
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range jobs {
+				for _, line := range chunk.lines {
+					if failed.Load() {
+						break
+					}
+					v, err := convert(line)
+					if err != nil {
+						chunk.err = err
+						break
+					}
+					chunk.results = append(chunk.results, v)
 				}
+				close(chunk.done)
 			}
-			return nil
-		})
+		}()
 	}
 
-	var wg sync.WaitGroup
 	// Scan
-	wg.Add(1)
 	go func() {
-		defer wg.Done()
-		defer close(c)
-		for scanner.Scan() {
+		defer close(jobs)
+		defer close(pending)
+		chunk := newParseChunk[T]()
+		for !failed.Load() && scanner.Scan() {
 			line := bytes.TrimLeft(scanner.Bytes(), " ")
 			if len(line) < 2 || bytes.HasPrefix(line, []byte("#")) {
 				continue
 			}
 			newLine := make([]byte, len(line))
 			copy(newLine, line)
-			c <- newLine
+			chunk.lines = append(chunk.lines, newLine)
+			if len(chunk.lines) == parseChunkSize {
+				pending <- chunk
+				jobs <- chunk
+				chunk = newParseChunk[T]()
+			}
+		}
+		if len(chunk.lines) > 0 {
+			pending <- chunk
+			jobs <- chunk
 		}
 	}()
 
-	if err := g.Wait(); err != nil {
-		return err
+	for chunk := range pending {
+		<-chunk.done
+		if err != nil {
+			continue
+		}
+		// the first error in the input is reported, whatever the order chunks are converted in
+		if chunk.err != nil {
+			err = chunk.err
+			failed.Store(true)
+			continue
+		}
+		for _, v := range chunk.results {
+			emit(v)
+		}
 	}
 	wg.Wait()
+	if err != nil {
+		return err
+	}
 
 	// Check we have reached EOF properly
 	return scanner.Err()
@@ -195,7 +195,7 @@ func TestParseParallel(t *testing.T) {
 	results, err := Parse(r, &Codec{Serial: testSerial}, 0)
 	require.Nil(t, err)
 	expected := getExpected(false)
-	require.ElementsMatch(t, expected, results, "data correctly parsed")
+	require.Equal(t, expected, results, "data correctly parsed in input order")
 }
 
 func TestParseParallelV2(t *testing.T) {
@@ -206,7 +206,7 @@ func TestParseParallelV2(t *testing.T) {
 	results, err := Parse(r, codec, 0)
 	require.Nil(t, err)
 	expected := getExpected(true)
-	require.ElementsMatch(t, expected, results, "data correctly parsed")
+	require.Equal(t, expected, results, "data correctly parsed in input order")
 }
 
 func TestParseParallelGen(t *testing.T) {
@@ -214,7 +214,39 @@ func TestParseParallelGen(t *testing.T) {
 	r := bytes.NewReader(dataset)
 	results, err := Parse(r, &Codec{Serial: testSerial}, 0)
 	require.Nil(t, err)
-	require.ElementsMatch(t, expected, results, "data correctly parsed")
+	require.Equal(t, expected, results, "data correctly parsed in input order")
+}
+
+func TestParseParallelRanger(t *testing.T) {
+	dataset := []byte{}
+	for i := 0; i < dataSetSize; i++ {
+		dataset = append(dataset, []byte(fmt.Sprintf("%%%c%c,10.%d.%d.0/24,m%d\n", 'a'+i%3, 'a'+i%5, i/250, i%250, i%7))...)
+	}
+	newCodec := func() *Codec {
+		codec := &Codec{Serial: testSerial}
+		codec.Acc.Ranger.Enable()
+		codec.Acc.NoPrefixSets = true
+		codec.NoRnetOutput = true
+		return codec
+	}
+	expected, err := Parse(bytes.NewReader(dataset), newCodec(), 1)
+	require.Nil(t, err)
+	require.NotEmpty(t, expected)
+	for i := 0; i < 5; i++ {
+		results, err := Parse(bytes.NewReader(dataset), newCodec(), 0)
+		require.Nil(t, err)
+		require.Equal(t, expected, results, "output does not depend on the number of workers")
+	}
+}
+
+func TestParseParallelFirstError(t *testing.T) {
+	dataset, _ := genData(dataSetSize)
+	dataset = append(dataset, []byte("\nfirst broken line\n")...)
+	good, _ := genData(10)
+	dataset = append(dataset, good...)
+	dataset = append(dataset, []byte("\nsecond broken line\n")...)
+	_, err := Parse(bytes.NewReader(dataset), &Codec{Serial: testSerial}, 0)
+	require.ErrorContains(t, err, "first broken line")
 }
 
 func TestParseRecordsLinear(t *testing.T) {
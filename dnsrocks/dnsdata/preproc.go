@@ -40,11 +40,12 @@ type PreprocReader struct {
 	wroteAccSt time.Time
 }
 
-// NewPreprocReader creates reader that processes input line by line and filters/changes it according to codec settings
+// NewPreprocReader creates reader that processes input line by line and filters/changes it according to codec settings.
+// $GENERATE directives are expanded.
 func NewPreprocReader(r io.Reader, c *Codec) *PreprocReader {
 	return &PreprocReader{
 		codec:      c,
-		scanner:    bufio.NewScanner(r),
+		scanner:    bufio.NewScanner(NewGenerateReader(r)),
 		existsData: true,
 	}
 }
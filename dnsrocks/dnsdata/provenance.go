@@ -0,0 +1,58 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ProvenanceKey is a special key storing the Provenance of a DB
+const ProvenanceKey = "\x00o_provenance"
+
+// Provenance identifies the dataset a DB was compiled from, as set by the
+// compiler, so that servers can report which one they serve
+type Provenance struct {
+	// Version is the version of the dataset, e.g. a publish ID
+	Version string
+	// Serial is the SOA serial of the records without one
+	Serial uint32
+}
+
+// MarshalText implements encoding.TextMarshaler, as
+// "serial=<serial> version=<version>", the version being the rest of the text
+func (p *Provenance) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("serial=%d version=%s", p.Serial, p.Version)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (p *Provenance) UnmarshalText(text []byte) error {
+	serial, version, ok := strings.Cut(string(text), " version=")
+	if !ok || !strings.HasPrefix(serial, "serial=") {
+		return fmt.Errorf("invalid provenance %q", text)
+	}
+	s, err := strconv.ParseUint(strings.TrimPrefix(serial, "serial="), 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid provenance %q: %w", text, err)
+	}
+	*p = Provenance{Version: version, Serial: uint32(s)}
+	return nil
+}
+
+// MapRecord returns the record storing the provenance in a DB
+func (p *Provenance) MapRecord() MapRecord {
+	text, _ := p.MarshalText()
+	return MapRecord{Key: []byte(ProvenanceKey), Value: text}
+}
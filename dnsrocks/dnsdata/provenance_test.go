@@ -0,0 +1,38 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvenanceText(t *testing.T) {
+	for _, p := range []Provenance{
+		{Version: "2026-10-18.1", Serial: 1234},
+		{Version: "publish 42 version=x", Serial: 0},
+		{},
+	} {
+		r := p.MapRecord()
+		require.Equal(t, ProvenanceKey, string(r.Key))
+		parsed := new(Provenance)
+		require.NoError(t, parsed.UnmarshalText(r.Value))
+		require.Equal(t, p, *parsed)
+	}
+
+	for _, text := range []string{"", "version=v1", "serial=x version=v1", "serial=4294967296 version=v1"} {
+		require.Error(t, new(Provenance).UnmarshalText([]byte(text)), text)
+	}
+}
@@ -0,0 +1,354 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+const lowerhex = "0123456789abcdef"
+
+// encodeChunkSize bounds the quoted text an Encoder buffers before writing it
+const encodeChunkSize = 4096
+
+// SyntaxError reports a malformed escape sequence met by a Decoder
+type SyntaxError struct {
+	// Offset is the offset of the backslash starting the sequence in the
+	// input
+	Offset int64
+	// Sequence is the malformed sequence, as read
+	Sequence string
+	// Err is strconv.ErrSyntax, or io.ErrUnexpectedEOF if the input ended
+	// within the sequence
+	Err error
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Err == io.ErrUnexpectedEOF {
+		return fmt.Sprintf("truncated escape sequence %q at offset %d", e.Sequence, e.Offset)
+	}
+	return fmt.Sprintf("invalid escape sequence %q at offset %d", e.Sequence, e.Offset)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder unquotes the text read from an io.Reader using the rules of
+// Bunquote, without reading it all in memory. Unlike Bunquote, bytes
+// outside escape sequences are passed through as is, valid UTF-8 or not,
+// and malformed escape sequences are reported as *SyntaxError.
+type Decoder struct {
+	r       *bufio.Reader
+	offset  int64
+	seq     []byte
+	buf     [utf8.UTFMax]byte
+	pending []byte
+	err     error
+}
+
+// NewDecoder returns a Decoder reading quoted text from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Read implements io.Reader, returning unquoted bytes
+func (d *Decoder) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(d.pending) > 0 {
+			c := copy(p[n:], d.pending)
+			d.pending = d.pending[c:]
+			n += c
+			continue
+		}
+		// don't block on the underlying reader once we have something
+		if d.err != nil || (n > 0 && d.r.Buffered() == 0) {
+			break
+		}
+		c, err := d.r.ReadByte()
+		if err != nil {
+			d.err = err
+			break
+		}
+		d.offset++
+		if c != '\\' {
+			p[n] = c
+			n++
+			continue
+		}
+		d.pending, d.err = d.unescape()
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, d.err
+}
+
+// unescape decodes the escape sequence following a backslash
+func (d *Decoder) unescape() ([]byte, error) {
+	d.seq = append(d.seq[:0], '\\')
+	c, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	switch c {
+	case 'a':
+		c = '\a'
+	case 'b':
+		c = '\b'
+	case 'f':
+		c = '\f'
+	case 'n':
+		c = '\n'
+	case 'r':
+		c = '\r'
+	case 't':
+		c = '\t'
+	case 'v':
+		c = '\v'
+	case '\\':
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		v := uint32(c - '0')
+		for i := 0; i < 2; i++ {
+			if c, err = d.next(); err != nil {
+				return nil, err
+			}
+			if c < '0' || c > '7' {
+				return nil, d.syntaxError(strconv.ErrSyntax)
+			}
+			v = v<<3 | uint32(c-'0')
+		}
+		if v > 255 {
+			return nil, d.syntaxError(strconv.ErrSyntax)
+		}
+		c = byte(v)
+	case 'x', 'u', 'U':
+		digits := 2
+		if c == 'u' {
+			digits = 4
+		} else if c == 'U' {
+			digits = 8
+		}
+		var v rune
+		for i := 0; i < digits; i++ {
+			if c, err = d.next(); err != nil {
+				return nil, err
+			}
+			h, ok := unhex(c)
+			if !ok {
+				return nil, d.syntaxError(strconv.ErrSyntax)
+			}
+			v = v<<4 | h
+		}
+		if digits == 2 {
+			c = byte(v)
+			break
+		}
+		if !utf8.ValidRune(v) {
+			return nil, d.syntaxError(strconv.ErrSyntax)
+		}
+		return d.buf[:utf8.EncodeRune(d.buf[:], v)], nil
+	default:
+		return nil, d.syntaxError(strconv.ErrSyntax)
+	}
+	d.buf[0] = c
+	return d.buf[:1], nil
+}
+
+// next reads the next byte of an escape sequence
+func (d *Decoder) next() (byte, error) {
+	c, err := d.r.ReadByte()
+	if err == io.EOF {
+		return 0, d.syntaxError(io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return 0, err
+	}
+	d.offset++
+	d.seq = append(d.seq, c)
+	return c, nil
+}
+
+func (d *Decoder) syntaxError(err error) error {
+	return &SyntaxError{
+		Offset:   d.offset - int64(len(d.seq)),
+		Sequence: string(d.seq),
+		Err:      err,
+	}
+}
+
+func unhex(c byte) (rune, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return rune(c - '0'), true
+	case 'a' <= c && c <= 'f':
+		return rune(c - 'a' + 10), true
+	case 'A' <= c && c <= 'F':
+		return rune(c - 'A' + 10), true
+	}
+	return 0, false
+}
+
+// Encoder quotes the bytes written to it using the rules of Bquote, and
+// writes the quoted text to an io.Writer, without holding it all in memory.
+// Close must be called once done, to write the last bytes of a UTF-8
+// sequence split across writes.
+type Encoder struct {
+	w       io.Writer
+	partial []byte
+	buf     []byte
+}
+
+// NewEncoder returns an Encoder writing quoted text to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Write implements io.Writer. It returns the number of unquoted bytes
+// consumed from p.
+func (e *Encoder) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(e.partial) > 0 {
+		// complete the UTF-8 sequence started by the previous write
+		for len(p) > 0 && len(e.partial) < utf8.UTFMax && !utf8.FullRune(e.partial) {
+			e.partial = append(e.partial, p[0])
+			p = p[1:]
+		}
+		if !utf8.FullRune(e.partial) {
+			return n, nil
+		}
+		if err := e.write(e.partial); err != nil {
+			return 0, err
+		}
+		e.partial = e.partial[:0]
+	}
+	for len(p) > 0 {
+		end := len(p)
+		if end > encodeChunkSize {
+			end = encodeChunkSize
+			// don't split a rune across chunks
+			for i := end; i > end-utf8.UTFMax; i-- {
+				if utf8.RuneStart(p[i]) {
+					end = i
+					break
+				}
+			}
+		} else {
+			// keep an incomplete rune for the next write
+			for i := end - 1; i >= 0 && i > end-utf8.UTFMax; i-- {
+				if utf8.RuneStart(p[i]) {
+					if !utf8.FullRune(p[i:]) {
+						e.partial = append(e.partial, p[i:]...)
+						end = i
+					}
+					break
+				}
+			}
+		}
+		if end > 0 {
+			if err := e.write(p[:end]); err != nil {
+				return n - len(p), err
+			}
+		}
+		p = p[end:]
+		if len(e.partial) > 0 {
+			break
+		}
+	}
+	return n, nil
+}
+
+// Close writes the bytes of an incomplete UTF-8 sequence left by the last
+// write, escaped. It does not close the underlying writer.
+func (e *Encoder) Close() error {
+	if len(e.partial) == 0 {
+		return nil
+	}
+	err := e.write(e.partial)
+	e.partial = e.partial[:0]
+	return err
+}
+
+func (e *Encoder) write(b []byte) error {
+	e.buf = appendQuoted(e.buf[:0], b)
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// appendQuoted appends b to dst quoted the way Bquote does
+func appendQuoted(dst, b []byte) []byte {
+	for len(b) > 0 {
+		r, width := utf8.DecodeRune(b)
+		if r == utf8.RuneError && width == 1 {
+			dst = append(dst, '\\', 'x', lowerhex[b[0]>>4], lowerhex[b[0]&0xf])
+			b = b[1:]
+			continue
+		}
+		dst = appendEscapedRune(dst, r)
+		b = b[width:]
+	}
+	return dst
+}
+
+func appendEscapedRune(dst []byte, r rune) []byte {
+	switch r {
+	case '\\':
+		return append(dst, '\\', '\\')
+	case ',':
+		// field separator
+		return append(dst, `\054`...)
+	case ':':
+		// legacy field separator
+		return append(dst, `\072`...)
+	}
+	if strconv.IsPrint(r) {
+		return utf8.AppendRune(dst, r)
+	}
+	switch r {
+	case '\a':
+		return append(dst, '\\', 'a')
+	case '\b':
+		return append(dst, '\\', 'b')
+	case '\f':
+		return append(dst, '\\', 'f')
+	case '\n':
+		return append(dst, '\\', 'n')
+	case '\r':
+		return append(dst, '\\', 'r')
+	case '\t':
+		return append(dst, '\\', 't')
+	case '\v':
+		return append(dst, '\\', 'v')
+	}
+	switch {
+	case r < ' ' || r == 0x7f:
+		return append(dst, '\\', 'x', lowerhex[byte(r)>>4], lowerhex[byte(r)&0xf])
+	case r < 0x10000:
+		dst = append(dst, '\\', 'u')
+		for s := 12; s >= 0; s -= 4 {
+			dst = append(dst, lowerhex[r>>uint(s)&0xf])
+		}
+	default:
+		dst = append(dst, '\\', 'U')
+		for s := 28; s >= 0; s -= 4 {
+			dst = append(dst, lowerhex[r>>uint(s)&0xf])
+		}
+	}
+	return dst
+}
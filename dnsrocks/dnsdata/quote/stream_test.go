@@ -0,0 +1,147 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var streamtests = []string{
+	"",
+	"abc",
+	"\000\001\002\177",
+	"a,b:c\\d\"e'f",
+	"\a\b\f\n\r\t\v",
+	"héllo wörld ☺ 𝄞",
+	" ­\U000e0001",
+	"\xff\xfe invalid \xe2\x82 utf8 \xc3",
+	strings.Repeat("x☺,", 3000),
+}
+
+func TestEncoder(t *testing.T) {
+	for _, in := range append(streamtests, func() []string {
+		var s []string
+		for _, tt := range quotetests {
+			s = append(s, tt.in)
+		}
+		return s
+	}()...) {
+		want := string(Bquote([]byte(in)))
+		for _, step := range []int{1, 2, 3, 5, len(in) + 1} {
+			var out bytes.Buffer
+			e := NewEncoder(&out)
+			for p := []byte(in); len(p) > 0; {
+				n := min(step, len(p))
+				written, err := e.Write(p[:n])
+				if err != nil || written != n {
+					t.Fatalf("%q: write returned %d, %v", in, written, err)
+				}
+				p = p[n:]
+			}
+			if err := e.Close(); err != nil {
+				t.Fatalf("%q: %v", in, err)
+			}
+			if out.String() != want {
+				t.Errorf("writes of %d bytes: samples differ: %q != %q", step, out.String(), want)
+			}
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	for _, in := range streamtests {
+		quoted := Bquote([]byte(in))
+		for _, r := range []io.Reader{bytes.NewReader(quoted), iotest.OneByteReader(bytes.NewReader(quoted))} {
+			out, err := io.ReadAll(NewDecoder(r))
+			if err != nil {
+				t.Fatalf("%q: %v", quoted, err)
+			}
+			if string(out) != in {
+				t.Errorf("samples differ: %q != %q", out, in)
+			}
+		}
+	}
+	for _, tt := range unquotetests {
+		if tt.err != nil {
+			continue
+		}
+		out, err := io.ReadAll(NewDecoder(strings.NewReader(tt.in)))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.in, err)
+		}
+		if string(out) != tt.out {
+			t.Errorf("samples differ: %q != %q", out, tt.out)
+		}
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	tests := []struct {
+		in       string
+		out      string
+		offset   int64
+		sequence string
+		err      error
+	}{
+		{in: `\000\082`, out: "\000", offset: 4, sequence: `\08`, err: strconv.ErrSyntax},
+		{in: `ab\400`, out: "ab", offset: 2, sequence: `\400`, err: strconv.ErrSyntax},
+		{in: `abc\q`, out: "abc", offset: 3, sequence: `\q`, err: strconv.ErrSyntax},
+		{in: `a\"b`, out: "a", offset: 1, sequence: `\"`, err: strconv.ErrSyntax},
+		{in: `\x4g`, out: "", offset: 0, sequence: `\x4g`, err: strconv.ErrSyntax},
+		{in: `\ud800`, out: "", offset: 0, sequence: `\ud800`, err: strconv.ErrSyntax},
+		{in: `abc\`, out: "abc", offset: 3, sequence: `\`, err: io.ErrUnexpectedEOF},
+		{in: `abc\01`, out: "abc", offset: 3, sequence: `\01`, err: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		out, err := io.ReadAll(NewDecoder(strings.NewReader(tt.in)))
+		if string(out) != tt.out {
+			t.Errorf("%q: samples differ: %q != %q", tt.in, out, tt.out)
+		}
+		var serr *SyntaxError
+		if !errors.As(err, &serr) {
+			t.Fatalf("%q: expected a SyntaxError, got %v", tt.in, err)
+		}
+		if serr.Offset != tt.offset || serr.Sequence != tt.sequence {
+			t.Errorf("%q: error differ: %v", tt.in, serr)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%q: error differ: %v != %v", tt.in, serr.Err, tt.err)
+		}
+	}
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	in := []byte(strings.Repeat("\001abc,", 1000))
+	e := NewEncoder(io.Discard)
+	for n := 0; n < b.N; n++ {
+		if _, err := e.Write(in); err != nil {
+			b.Errorf("%v", err)
+		}
+	}
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	in := []byte(strings.Repeat("\\001abc\\054", 1000))
+	for n := 0; n < b.N; n++ {
+		if _, err := io.Copy(io.Discard, NewDecoder(bytes.NewReader(in))); err != nil {
+			b.Errorf("%v", err)
+		}
+	}
+}
@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -35,10 +37,8 @@ func (batch *Batch) ApplyDiff(d *dbdiff.Entry) {
 	}
 }
 
-func (rdb *RDB) ApplyDiff(r io.Reader, serial uint32) error {
-	codec := initCodec(serial)
-	codec.Features.UseV2Keys = rdb.IsV2KeySyntaxUsed()
-	batch := rdb.CreateBatch()
+// forEachDiffEntry parses and converts the entries of the text diff in r
+func forEachDiffEntry(r io.Reader, codec *dnsdata.Codec, f func(e *dbdiff.Entry) error) error {
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Bytes()
@@ -52,32 +52,71 @@ func (rdb *RDB) ApplyDiff(r io.Reader, serial uint32) error {
 		if err := e.Convert(codec); err != nil {
 			return fmt.Errorf("conversion error for line '%s' (op '%v'): %w", e.Bytes, e.Op, err)
 		}
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (rdb *RDB) ApplyDiff(r io.Reader, serial uint32) error {
+	_, err := rdb.ApplyDiffWithOptions(r, serial, ApplyOptions{MaxSamples: -1})
+	return err
+}
+
+// ApplyDiffWithOptions is ApplyDiff returning a summary of the changes made
+// to the database, or that would be made with opts.DryRun
+func (rdb *RDB) ApplyDiffWithOptions(r io.Reader, serial uint32, opts ApplyOptions) (*ChangeSummary, error) {
+	codec := initCodec(serial)
+	features := rdb.features()
+	codec.Features.UseV2Keys = features&dnsdata.V2KeysFeature != 0
+	codec.EmptyNonTerminals = features&dnsdata.EmptyNonTerminalsFeature != 0
+	batch := rdb.CreateBatch()
+	err := forEachDiffEntry(r, codec, func(e *dbdiff.Entry) error {
 		batch.ApplyDiff(e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	if err := scanner.Err(); err != nil {
-		return err
+	if err := rdb.updateEmptyNonTerminals(batch, codec); err != nil {
+		return nil, fmt.Errorf("updating empty non-terminals failed: %w", err)
 	}
-	if err := rdb.ExecuteBatch(batch); err != nil {
-		return fmt.Errorf("database update failed: %w", err)
+	summary, err := rdb.ExecuteBatchWithOptions(batch, opts)
+	if err != nil {
+		return summary, fmt.Errorf("database update failed: %w", err)
 	}
-	return nil
+	// the content no longer matches the checksum of the compiled DB
+	if !batch.IsEmpty() && !opts.DryRun {
+		if err := rdb.removeSpecialKey(dnsdata.ChecksumKey); err != nil {
+			return summary, fmt.Errorf("removing checksum failed: %w", err)
+		}
+	}
+	return summary, nil
 }
 
 // ApplyDiff applies a diff from inputFileName into RDB database at destPath.
 func ApplyDiff(diffpath, dbpath string) error {
+	_, err := ApplyDiffWithOptions(diffpath, dbpath, ApplyOptions{MaxSamples: -1})
+	return err
+}
+
+// ApplyDiffWithOptions is ApplyDiff returning a summary of the changes made
+// to the database, or that would be made with opts.DryRun
+func ApplyDiffWithOptions(diffpath, dbpath string, opts ApplyOptions) (*ChangeSummary, error) {
 	rdb, err := NewUpdater(dbpath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rdb.Close()
 	file, err := os.Open(diffpath)
 	if err != nil {
-		return fmt.Errorf("%s: can't open input: %w", diffpath, err)
+		return nil, fmt.Errorf("%s: can't open input: %w", diffpath, err)
 	}
 	defer file.Close()
 	serial, err := dnsdata.DeriveSerial(file)
 	if err != nil {
-		return fmt.Errorf("%s: can't derive SOA serial: %w", diffpath, err)
+		return nil, fmt.Errorf("%s: can't derive SOA serial: %w", diffpath, err)
 	}
-	return rdb.ApplyDiff(file, serial)
+	return rdb.ApplyDiffWithOptions(file, serial, opts)
 }
@@ -0,0 +1,151 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb/dbdiff"
+)
+
+// ErrNoProvenance is returned when applying an artifact to a DB without
+// provenance, whose serial is unknown
+var ErrNoProvenance = errors.New("DB has no provenance")
+
+// ErrSerialMismatch is returned when applying an artifact to a DB whose
+// serial is not the one the artifact was compiled against
+var ErrSerialMismatch = errors.New("DB serial does not match artifact")
+
+// ErrKeySyntaxMismatch is returned when applying an artifact to a DB using
+// another keys syntax
+var ErrKeySyntaxMismatch = errors.New("DB keys syntax does not match artifact")
+
+// CompileDiffArtifact converts the text diff in r, as taken by ApplyDiff, and
+// writes it to w as an artifact with header h. SOA records without a serial
+// get h.ToSerial. It returns the number of records written.
+func CompileDiffArtifact(r io.Reader, w io.Writer, h dbdiff.ArtifactHeader) (uint64, error) {
+	codec := initCodec(h.ToSerial)
+	codec.Features.UseV2Keys = h.UseV2Keys
+	a, err := dbdiff.NewArtifactWriter(w, h)
+	if err != nil {
+		return 0, err
+	}
+	if err := forEachDiffEntry(r, codec, a.WriteEntry); err != nil {
+		return a.Count(), err
+	}
+	return a.Count(), a.Close()
+}
+
+// provenance returns the provenance stored in the DB, nil if there is none
+func (rdb *RDB) provenance() (*dnsdata.Provenance, error) {
+	value, err := rdb.Find([]byte(dnsdata.ProvenanceKey), NewContext())
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := new(dnsdata.Provenance)
+	if err := p.UnmarshalText(value); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ApplyArtifact applies the artifact in r in a single batch, along with the
+// provenance of the dataset it produces. The DB must have the provenance
+// serial the artifact was compiled against, so that artifacts are applied in
+// order and only once. The artifact is read and verified in full before the
+// DB is updated.
+func (rdb *RDB) ApplyArtifact(r io.Reader) (*dbdiff.ArtifactHeader, error) {
+	a, err := dbdiff.NewArtifactReader(r)
+	if err != nil {
+		return nil, err
+	}
+	h := a.Header()
+	fromV2Keys := h.UseV2Keys
+	if h.KeyMigration {
+		// key migration artifacts apply to DBs using the v1 keys syntax
+		fromV2Keys = false
+	}
+	if fromV2Keys != rdb.IsV2KeySyntaxUsed() {
+		return nil, fmt.Errorf("%w: artifact v2 keys %v, key migration %v", ErrKeySyntaxMismatch, h.UseV2Keys, h.KeyMigration)
+	}
+	p, err := rdb.provenance()
+	if err != nil {
+		return nil, fmt.Errorf("reading provenance failed: %w", err)
+	}
+	if p == nil {
+		return nil, ErrNoProvenance
+	}
+	if p.Serial != h.FromSerial {
+		return nil, fmt.Errorf("%w: DB serial %d, artifact from %d to %d", ErrSerialMismatch, p.Serial, h.FromSerial, h.ToSerial)
+	}
+
+	batch := rdb.CreateBatch()
+	for {
+		op, rec, err := a.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case dbdiff.AddOp:
+			batch.Add(rec.Key, rec.Value)
+		case dbdiff.DelOp:
+			batch.Del(rec.Key, rec.Value)
+		}
+	}
+
+	// the content no longer matches the checksum of the compiled DB
+	checksum, err := rdb.Find([]byte(dnsdata.ChecksumKey), NewContext())
+	if err == nil {
+		batch.Del([]byte(dnsdata.ChecksumKey), checksum)
+	} else if !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("reading checksum failed: %w", err)
+	}
+	old := p.MapRecord()
+	batch.Del(old.Key, old.Value)
+	next := h.Provenance().MapRecord()
+	batch.Add(next.Key, next.Value)
+
+	if err := rdb.ExecuteBatch(batch); err != nil {
+		return nil, fmt.Errorf("database update failed: %w", err)
+	}
+	return &h, nil
+}
+
+// ApplyArtifact applies the artifact at artifactPath to the RDB database at
+// dbpath, see RDB.ApplyArtifact.
+func ApplyArtifact(artifactPath, dbpath string) (*dbdiff.ArtifactHeader, error) {
+	file, err := os.Open(artifactPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: can't open input: %w", artifactPath, err)
+	}
+	defer file.Close()
+	rdb, err := NewUpdater(dbpath)
+	if err != nil {
+		return nil, err
+	}
+	defer rdb.Close()
+	return rdb.ApplyArtifact(file)
+}
@@ -0,0 +1,131 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb/dbdiff"
+)
+
+func TestApplyArtifact(t *testing.T) {
+	input := []byte("Zexample.com,ns.example.com,dns.example.com,,,,,,\n+www.example.com,192.0.2.1\n+www.example.com,192.0.2.2\n")
+	diff := "-+www.example.com,192.0.2.1\n++new.example.com,192.0.2.3\n"
+
+	for _, useV2Keys := range []bool{false, true} {
+		dir := t.TempDir()
+		_, err := Compile(bytes.NewReader(input), 10, dir, CompilationOptions{
+			NumCPU:         1,
+			UseV2KeySyntax: useV2Keys,
+			Version:        "v10",
+			Checksum:       true,
+		})
+		require.NoError(t, err)
+
+		var artifact bytes.Buffer
+		h := dbdiff.ArtifactHeader{FromSerial: 10, ToSerial: 11, Version: "v11", UseV2Keys: useV2Keys}
+		n, err := CompileDiffArtifact(strings.NewReader(diff), &artifact, h)
+		require.NoError(t, err)
+		require.Positive(t, n)
+
+		// the same diff applied as text
+		textDir := t.TempDir()
+		_, err = Compile(bytes.NewReader(input), 10, textDir, CompilationOptions{NumCPU: 1, UseV2KeySyntax: useV2Keys})
+		require.NoError(t, err)
+		textDB, err := NewUpdater(textDir)
+		require.NoError(t, err)
+		require.NoError(t, textDB.ApplyDiff(strings.NewReader(diff), 11))
+		expected := map[string]string{}
+		require.NoError(t, textDB.ForEachKeyWithPrefix(nil, func(k, v []byte) error {
+			expected[string(k)] = string(v)
+			return nil
+		}))
+		require.NoError(t, textDB.Close())
+
+		artifactPath := filepath.Join(t.TempDir(), "diff")
+		require.NoError(t, os.WriteFile(artifactPath, artifact.Bytes(), 0o644))
+		applied, err := ApplyArtifact(artifactPath, dir)
+		require.NoError(t, err)
+		require.Equal(t, h, *applied)
+
+		rdb, err := NewUpdater(dir)
+		require.NoError(t, err)
+		got := map[string]string{}
+		require.NoError(t, rdb.ForEachKeyWithPrefix(nil, func(k, v []byte) error {
+			got[string(k)] = string(v)
+			return nil
+		}))
+		p, err := rdb.provenance()
+		require.NoError(t, err)
+		require.Equal(t, &dnsdata.Provenance{Version: "v11", Serial: 11}, p)
+		require.NotContains(t, got, dnsdata.ChecksumKey)
+		delete(got, dnsdata.ProvenanceKey)
+		require.Equal(t, expected, got, "v2 keys %v", useV2Keys)
+
+		// artifacts are only applied to the serial they were compiled against
+		_, err = rdb.ApplyArtifact(bytes.NewReader(artifact.Bytes()))
+		require.ErrorIs(t, err, ErrSerialMismatch)
+		artifact.Reset()
+		h.UseV2Keys = !useV2Keys
+		_, err = CompileDiffArtifact(strings.NewReader(""), &artifact, h)
+		require.NoError(t, err)
+		_, err = rdb.ApplyArtifact(bytes.NewReader(artifact.Bytes()))
+		require.ErrorIs(t, err, ErrKeySyntaxMismatch)
+
+		// the next one in the chain applies, and nothing is applied from a
+		// truncated artifact
+		artifact.Reset()
+		h = dbdiff.ArtifactHeader{FromSerial: 11, ToSerial: 12, Version: "v12", UseV2Keys: useV2Keys}
+		_, err = CompileDiffArtifact(strings.NewReader("++other.example.com,192.0.2.4\n"), &artifact, h)
+		require.NoError(t, err)
+		_, err = rdb.ApplyArtifact(bytes.NewReader(artifact.Bytes()[:artifact.Len()-1]))
+		require.ErrorIs(t, err, dbdiff.ErrBadArtifact)
+		p, err = rdb.provenance()
+		require.NoError(t, err)
+		require.Equal(t, uint32(11), p.Serial)
+		_, err = rdb.ApplyArtifact(bytes.NewReader(artifact.Bytes()))
+		require.NoError(t, err)
+		p, err = rdb.provenance()
+		require.NoError(t, err)
+		require.Equal(t, &dnsdata.Provenance{Version: "v12", Serial: 12}, p)
+		require.NoError(t, rdb.Close())
+	}
+}
+
+func TestApplyArtifactNoProvenance(t *testing.T) {
+	dir := t.TempDir()
+	_, err := Compile(strings.NewReader("+www.example.com,192.0.2.1\n"), 10, dir, CompilationOptions{NumCPU: 1})
+	require.NoError(t, err)
+	var artifact bytes.Buffer
+	_, err = CompileDiffArtifact(strings.NewReader(""), &artifact, dbdiff.ArtifactHeader{FromSerial: 10, ToSerial: 11})
+	require.NoError(t, err)
+	rdb, err := NewUpdater(dir)
+	require.NoError(t, err)
+	defer rdb.Close()
+	_, err = rdb.ApplyArtifact(&artifact)
+	require.True(t, errors.Is(err, ErrNoProvenance), "got %v", err)
+	_, err = rdb.Find([]byte(dnsdata.ProvenanceKey), NewContext())
+	require.ErrorIs(t, err, io.EOF)
+}
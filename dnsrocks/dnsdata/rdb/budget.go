@@ -0,0 +1,69 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdb
+
+import (
+	"fmt"
+
+	rocksdb "github.com/facebook/dns/dnsrocks/cgo-rocksdb"
+)
+
+// MemoryBudget is memory shared by the RDB instances opened with it, e.g. the
+// primary and shadow DBs of a server or the old and new DBs of a reload,
+// instead of each of them allocating its own block cache: they use a single
+// LRU cache of the budget size, which their memtables are charged to as well.
+type MemoryBudget struct {
+	cache *rocksdb.LRUCache
+	wbm   *rocksdb.WriteBufferManager
+}
+
+// NewMemoryBudget returns a budget of size bytes, up to writeBufferSize of
+// which can be used by memtables. Memtables are not bounded if writeBufferSize
+// is 0.
+func NewMemoryBudget(size, writeBufferSize int) (*MemoryBudget, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid memory budget size %d", size)
+	}
+	if writeBufferSize < 0 || writeBufferSize > size {
+		return nil, fmt.Errorf("invalid write buffer size %d, must be between 0 and the memory budget size %d", writeBufferSize, size)
+	}
+	b := &MemoryBudget{cache: rocksdb.NewLRUCache(size)}
+	if writeBufferSize > 0 {
+		b.wbm = rocksdb.NewWriteBufferManager(writeBufferSize, b.cache)
+	}
+	return b, nil
+}
+
+// apply makes options use the budget
+func (b *MemoryBudget) apply(options *rocksdb.Options) {
+	options.SetBlockCache(b.cache)
+	if b.wbm != nil {
+		options.SetWriteBufferManager(b.wbm)
+	}
+}
+
+// Usage returns the memory used out of the budget, in bytes
+func (b *MemoryBudget) Usage() uint64 {
+	return b.cache.GetUsage()
+}
+
+// Free releases the budget, once all the instances opened with it are closed
+func (b *MemoryBudget) Free() {
+	if b.wbm != nil {
+		b.wbm.FreeWriteBufferManager()
+	}
+	b.cache.FreeLRUCache()
+}
@@ -0,0 +1,96 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdb
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// checksum computes the checksum of the DB, signed with key if not nil
+func (rdb *RDB) checksum(key ed25519.PrivateKey) (*dnsdata.Checksum, error) {
+	hasher := dnsdata.NewChecksumHasher()
+	err := rdb.ForEachKeyWithPrefix(nil, func(k, data []byte) error {
+		hasher.Add(k, data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hasher.Sum(key), nil
+}
+
+// removeSpecialKey deletes a special key storing a single value, e.g.
+// dnsdata.ChecksumKey, if present
+func (rdb *RDB) removeSpecialKey(key string) error {
+	value, err := rdb.Find([]byte(key), NewContext())
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return rdb.Del([]byte(key), value)
+}
+
+// replaceSpecialKey sets the value of a special key storing a single value
+func (rdb *RDB) replaceSpecialKey(key string, value []byte) error {
+	if err := rdb.removeSpecialKey(key); err != nil {
+		return err
+	}
+	return rdb.Add([]byte(key), value)
+}
+
+// WriteChecksum stores the checksum of the DB at path under
+// dnsdata.ChecksumKey, signed with key if not nil, replacing the previous one
+func WriteChecksum(path string, key ed25519.PrivateKey) (*dnsdata.Checksum, error) {
+	rdb, err := NewUpdater(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdb.Close()
+	sum, err := rdb.checksum(key)
+	if err != nil {
+		return nil, fmt.Errorf("error computing checksum: %w", err)
+	}
+	text, err := sum.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	if err := rdb.replaceSpecialKey(dnsdata.ChecksumKey, text); err != nil {
+		return nil, fmt.Errorf("error storing checksum: %w", err)
+	}
+	return sum, nil
+}
+
+// WriteProvenance stores p in the DB at path under dnsdata.ProvenanceKey,
+// replacing the previous one
+func WriteProvenance(path string, p *dnsdata.Provenance) error {
+	rdb, err := NewUpdater(path)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+	r := p.MapRecord()
+	if err := rdb.replaceSpecialKey(string(r.Key), r.Value); err != nil {
+		return fmt.Errorf("error storing provenance: %w", err)
+	}
+	return nil
+}
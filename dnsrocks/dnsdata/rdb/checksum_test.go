@@ -0,0 +1,82 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdb
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+func TestCompileChecksumAndProvenance(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	input := []byte("Zexample.com,ns.example.com,dns.example.com,,,,,,\n+www.example.com,192.0.2.1\n+www.example.com,192.0.2.2\n")
+
+	for _, useBuilder := range []bool{false, true} {
+		dir := t.TempDir()
+		_, err := Compile(bytes.NewReader(input), 123, dir, CompilationOptions{
+			NumCPU:         1,
+			UseV2KeySyntax: true,
+			UseBuilder:     useBuilder,
+			Version:        "v1",
+			SigningKey:     priv,
+		})
+		require.NoError(t, err)
+
+		rdb, err := NewUpdater(dir)
+		require.NoError(t, err)
+		value, err := rdb.Find([]byte(dnsdata.ChecksumKey), NewContext())
+		require.NoError(t, err)
+		stored := new(dnsdata.Checksum)
+		require.NoError(t, stored.UnmarshalText(value))
+		require.NoError(t, stored.Verify([]ed25519.PublicKey{pub}))
+		sum, err := rdb.checksum(nil)
+		require.NoError(t, err)
+		require.Equal(t, sum.Digest, stored.Digest, "builder %v", useBuilder)
+		value, err = rdb.Find([]byte(dnsdata.ProvenanceKey), NewContext())
+		require.NoError(t, err)
+		require.Equal(t, "serial=123 version=v1", string(value))
+
+		// empty diffs keep the checksum, others remove it
+		require.NoError(t, rdb.ApplyDiff(strings.NewReader(""), 1))
+		_, err = rdb.Find([]byte(dnsdata.ChecksumKey), NewContext())
+		require.NoError(t, err)
+		require.NoError(t, rdb.ApplyDiff(strings.NewReader("++new.example.com,192.0.2.3\n"), 1))
+		_, err = rdb.Find([]byte(dnsdata.ChecksumKey), NewContext())
+		require.True(t, errors.Is(err, io.EOF), "checksum removed, got %v", err)
+		require.NoError(t, rdb.Close())
+
+		// the provenance and the checksum can be written again
+		require.NoError(t, WriteProvenance(dir, &dnsdata.Provenance{Version: "v2", Serial: 124}))
+		sum, err = WriteChecksum(dir, nil)
+		require.NoError(t, err)
+		require.NotEqual(t, stored.Digest, sum.Digest)
+		rdb, err = NewReader(dir)
+		require.NoError(t, err)
+		value, err = rdb.Find([]byte(dnsdata.ProvenanceKey), NewContext())
+		require.NoError(t, err)
+		require.Equal(t, "serial=124 version=v2", string(value))
+		require.NoError(t, rdb.Close())
+	}
+}
@@ -0,0 +1,281 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbdiff
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// An artifact is a compiled diff, distributed to update DBs from one dataset
+// to the next without shipping, or reconverting, the whole dataset:
+//
+//	magic    "DNSDIFF" followed by the format version, 1
+//	flags    1 byte, bit 0 set for v2 keys, bit 1 for keys syntax migrations
+//	from     uint32, serial of the dataset the diff applies to
+//	to       uint32, serial of the dataset it produces
+//	version  uvarint length and bytes, version of the dataset it produces
+//	records  op ('+' or '-'), uvarint key length, key, uvarint value length
+//	         and value, in the order of the diff
+//	trailer  0, uint64 number of records and SHA-256 of all the above
+//
+// Integers are big endian.
+var artifactMagic = []byte("DNSDIFF\x01")
+
+const (
+	artifactFlagV2Keys       = 1 << 0
+	artifactFlagKeyMigration = 1 << 1
+	artifactEnd              = 0
+	// maxArtifactField bounds keys and values, so that corrupted lengths
+	// are reported instead of allocated
+	maxArtifactField = 1 << 24
+)
+
+// ErrBadArtifact is returned when reading a malformed artifact
+var ErrBadArtifact = errors.New("malformed diff artifact")
+
+// ErrArtifactChecksum is returned when an artifact does not match its checksum
+var ErrArtifactChecksum = errors.New("diff artifact checksum mismatch")
+
+// ArtifactHeader describes the datasets an artifact goes from and to
+type ArtifactHeader struct {
+	// FromSerial is the serial of the dataset the diff must be applied to
+	FromSerial uint32
+	// ToSerial is the serial of the dataset after the diff is applied
+	ToSerial uint32
+	// Version is the version of the dataset after the diff is applied
+	Version string
+	// UseV2Keys is set when the records use the v2 keys syntax
+	UseV2Keys bool
+	// KeyMigration is set when the artifact migrates a DB from the v1 keys
+	// syntax to the v2 one, see UseV2Keys
+	KeyMigration bool
+}
+
+// Provenance returns the provenance of the dataset the artifact produces
+func (h *ArtifactHeader) Provenance() *dnsdata.Provenance {
+	return &dnsdata.Provenance{Version: h.Version, Serial: h.ToSerial}
+}
+
+// ArtifactWriter writes an artifact record by record
+type ArtifactWriter struct {
+	w     *bufio.Writer
+	out   io.Writer // w and hash
+	hash  hash.Hash
+	count uint64
+	buf   []byte
+}
+
+// NewArtifactWriter writes the header of an artifact to w and returns a
+// writer for its records. Close must be called to complete the artifact.
+func NewArtifactWriter(w io.Writer, h ArtifactHeader) (*ArtifactWriter, error) {
+	a := &ArtifactWriter{w: bufio.NewWriter(w), hash: sha256.New()}
+	a.out = io.MultiWriter(a.w, a.hash)
+	var flags byte
+	if h.UseV2Keys {
+		flags |= artifactFlagV2Keys
+	}
+	if h.KeyMigration {
+		flags |= artifactFlagKeyMigration
+	}
+	b := append([]byte{}, artifactMagic...)
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint32(b, h.FromSerial)
+	b = binary.BigEndian.AppendUint32(b, h.ToSerial)
+	b = binary.AppendUvarint(b, uint64(len(h.Version)))
+	b = append(b, h.Version...)
+	if _, err := a.out.Write(b); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Write appends a record to add or delete
+func (a *ArtifactWriter) Write(op Op, r dnsdata.MapRecord) error {
+	if !op.Valid() {
+		return ErrBadOp
+	}
+	b := append(a.buf[:0], op[0])
+	b = binary.AppendUvarint(b, uint64(len(r.Key)))
+	b = append(b, r.Key...)
+	b = binary.AppendUvarint(b, uint64(len(r.Value)))
+	b = append(b, r.Value...)
+	a.buf = b
+	if _, err := a.out.Write(b); err != nil {
+		return err
+	}
+	a.count++
+	return nil
+}
+
+// WriteEntry appends the records of a converted diff entry
+func (a *ArtifactWriter) WriteEntry(e *Entry) error {
+	for _, r := range e.Records {
+		if err := a.Write(e.Op, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Count returns the number of records written so far
+func (a *ArtifactWriter) Count() uint64 {
+	return a.count
+}
+
+// Close writes the trailer of the artifact and flushes it. It does not close
+// the underlying writer.
+func (a *ArtifactWriter) Close() error {
+	b := binary.BigEndian.AppendUint64([]byte{artifactEnd}, a.count)
+	if _, err := a.out.Write(b); err != nil {
+		return err
+	}
+	if _, err := a.w.Write(a.hash.Sum(nil)); err != nil {
+		return err
+	}
+	return a.w.Flush()
+}
+
+// hashingReader hashes the bytes read through it
+type hashingReader struct {
+	r    *bufio.Reader
+	hash hash.Hash
+}
+
+func (h *hashingReader) ReadByte() (byte, error) {
+	b, err := h.r.ReadByte()
+	if err == nil {
+		h.hash.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hash.Write(p[:n])
+	return n, err
+}
+
+// ArtifactReader reads an artifact record by record
+type ArtifactReader struct {
+	r      *hashingReader
+	header ArtifactHeader
+	count  uint64
+	done   bool
+}
+
+// NewArtifactReader reads the header of the artifact in r and returns a
+// reader for its records
+func NewArtifactReader(r io.Reader) (*ArtifactReader, error) {
+	a := &ArtifactReader{r: &hashingReader{r: bufio.NewReader(r), hash: sha256.New()}}
+	magic := make([]byte, len(artifactMagic)+1+4+4)
+	if _, err := io.ReadFull(a.r, magic); err != nil {
+		return nil, fmt.Errorf("%w: reading header: %w", ErrBadArtifact, err)
+	}
+	if !bytes.Equal(magic[:len(artifactMagic)], artifactMagic) {
+		return nil, fmt.Errorf("%w: unknown magic %q", ErrBadArtifact, magic[:len(artifactMagic)])
+	}
+	fields := magic[len(artifactMagic):]
+	a.header.UseV2Keys = fields[0]&artifactFlagV2Keys != 0
+	a.header.KeyMigration = fields[0]&artifactFlagKeyMigration != 0
+	a.header.FromSerial = binary.BigEndian.Uint32(fields[1:])
+	a.header.ToSerial = binary.BigEndian.Uint32(fields[5:])
+	version, err := a.readField()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	a.header.Version = string(version)
+	return a, nil
+}
+
+// Header returns the header of the artifact
+func (a *ArtifactReader) Header() ArtifactHeader {
+	return a.header
+}
+
+func (a *ArtifactReader) readField() ([]byte, error) {
+	n, err := binary.ReadUvarint(a.r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadArtifact, err)
+	}
+	if n > maxArtifactField {
+		return nil, fmt.Errorf("%w: field length %d", ErrBadArtifact, n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(a.r, b); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadArtifact, err)
+	}
+	return b, nil
+}
+
+// Next returns the next record of the artifact. At the end of the artifact,
+// it checks the trailer and returns io.EOF if the artifact is complete and
+// intact. Records must not be applied before io.EOF is returned.
+func (a *ArtifactReader) Next() (Op, dnsdata.MapRecord, error) {
+	if a.done {
+		return "", dnsdata.MapRecord{}, io.EOF
+	}
+	op, err := a.r.ReadByte()
+	if err != nil {
+		return "", dnsdata.MapRecord{}, fmt.Errorf("%w: truncated after %d records: %w", ErrBadArtifact, a.count, err)
+	}
+	if op == artifactEnd {
+		return "", dnsdata.MapRecord{}, a.checkTrailer()
+	}
+	if !Op([]byte{op}).Valid() {
+		return "", dnsdata.MapRecord{}, fmt.Errorf("%w: record %d: %w", ErrBadArtifact, a.count, ErrBadOp)
+	}
+	var r dnsdata.MapRecord
+	if r.Key, err = a.readField(); err != nil {
+		return "", r, fmt.Errorf("record %d key: %w", a.count, err)
+	}
+	if r.Value, err = a.readField(); err != nil {
+		return "", r, fmt.Errorf("record %d value: %w", a.count, err)
+	}
+	a.count++
+	return Op([]byte{op}), r, nil
+}
+
+func (a *ArtifactReader) checkTrailer() error {
+	var count [8]byte
+	if _, err := io.ReadFull(a.r, count[:]); err != nil {
+		return fmt.Errorf("%w: reading trailer: %w", ErrBadArtifact, err)
+	}
+	if n := binary.BigEndian.Uint64(count[:]); n != a.count {
+		return fmt.Errorf("%w: %d records, trailer says %d", ErrBadArtifact, a.count, n)
+	}
+	expected := a.r.hash.Sum(nil)
+	sum := make([]byte, len(expected))
+	if _, err := io.ReadFull(a.r.r, sum); err != nil {
+		return fmt.Errorf("%w: reading checksum: %w", ErrBadArtifact, err)
+	}
+	if !bytes.Equal(sum, expected) {
+		return ErrArtifactChecksum
+	}
+	if _, err := a.r.r.ReadByte(); err == nil {
+		return fmt.Errorf("%w: trailing data", ErrBadArtifact)
+	} else if err != io.EOF {
+		return err
+	}
+	a.done = true
+	return io.EOF
+}
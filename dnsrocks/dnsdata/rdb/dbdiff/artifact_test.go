@@ -0,0 +1,132 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbdiff
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+func writeTestArtifact(t *testing.T, h ArtifactHeader, entries []Entry) []byte {
+	var buf bytes.Buffer
+	a, err := NewArtifactWriter(&buf, h)
+	require.NoError(t, err)
+	for i := range entries {
+		require.NoError(t, a.WriteEntry(&entries[i]))
+	}
+	require.NoError(t, a.Close())
+	return buf.Bytes()
+}
+
+type testRecord struct {
+	op Op
+	r  dnsdata.MapRecord
+}
+
+func readTestArtifact(b []byte) (ArtifactHeader, []testRecord, error) {
+	a, err := NewArtifactReader(bytes.NewReader(b))
+	if err != nil {
+		return ArtifactHeader{}, nil, err
+	}
+	var records []testRecord
+	for {
+		op, r, err := a.Next()
+		if errors.Is(err, io.EOF) {
+			return a.Header(), records, nil
+		}
+		if err != nil {
+			return a.Header(), records, err
+		}
+		records = append(records, testRecord{op: op, r: r})
+	}
+}
+
+func TestArtifactRoundTrip(t *testing.T) {
+	h := ArtifactHeader{FromSerial: 10, ToSerial: 11, Version: "publish-11", UseV2Keys: true}
+	entries := []Entry{
+		{Op: AddOp, Records: []dnsdata.MapRecord{{Key: []byte("k1"), Value: []byte("v1")}, {Key: []byte("k2"), Value: []byte{}}}},
+		{Op: DelOp, Records: []dnsdata.MapRecord{{Key: []byte("k1"), Value: []byte("v0")}}},
+		{Op: AddOp},
+	}
+	b := writeTestArtifact(t, h, entries)
+
+	got, records, err := readTestArtifact(b)
+	require.NoError(t, err)
+	require.Equal(t, h, got)
+	require.Equal(t, []testRecord{
+		{op: AddOp, r: dnsdata.MapRecord{Key: []byte("k1"), Value: []byte("v1")}},
+		{op: AddOp, r: dnsdata.MapRecord{Key: []byte("k2"), Value: []byte{}}},
+		{op: DelOp, r: dnsdata.MapRecord{Key: []byte("k1"), Value: []byte("v0")}},
+	}, records)
+
+	// empty artifacts only update the provenance
+	got, records, err = readTestArtifact(writeTestArtifact(t, ArtifactHeader{FromSerial: 1, ToSerial: 2}, nil))
+	require.NoError(t, err)
+	require.Equal(t, ArtifactHeader{FromSerial: 1, ToSerial: 2}, got)
+	require.Empty(t, records)
+
+	h = ArtifactHeader{FromSerial: 3, ToSerial: 3, UseV2Keys: true, KeyMigration: true}
+	got, _, err = readTestArtifact(writeTestArtifact(t, h, nil))
+	require.NoError(t, err)
+	require.Equal(t, h, got)
+}
+
+func TestArtifactCorruption(t *testing.T) {
+	h := ArtifactHeader{FromSerial: 10, ToSerial: 11, Version: "v"}
+	b := writeTestArtifact(t, h, []Entry{
+		{Op: AddOp, Records: []dnsdata.MapRecord{{Key: []byte("key"), Value: []byte("value")}}},
+	})
+
+	testCases := []struct {
+		name     string
+		artifact []byte
+		expected error
+	}{
+		{name: "empty", artifact: nil, expected: ErrBadArtifact},
+		{name: "magic", artifact: append([]byte("DNSDIFF\x02"), b[8:]...), expected: ErrBadArtifact},
+		{name: "truncated header", artifact: b[:12], expected: ErrBadArtifact},
+		{name: "truncated record", artifact: b[:len(b)-45], expected: ErrBadArtifact},
+		{name: "truncated checksum", artifact: b[:len(b)-1], expected: ErrBadArtifact},
+		{name: "trailing data", artifact: append(bytes.Clone(b), 0), expected: ErrBadArtifact},
+		{name: "flipped byte", artifact: func() []byte {
+			c := bytes.Clone(b)
+			c[len(c)-42] ^= 1 // last byte of the value, before the 41 bytes trailer
+			return c
+		}(), expected: ErrArtifactChecksum},
+		{name: "bad op", artifact: func() []byte {
+			c := bytes.Clone(b)
+			c[len(c)-52] = '*' // op of the 11 bytes record
+			return c
+		}(), expected: ErrBadOp},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := readTestArtifact(tc.artifact)
+			require.ErrorIs(t, err, tc.expected)
+		})
+	}
+}
+
+func TestArtifactWriterBadOp(t *testing.T) {
+	a, err := NewArtifactWriter(io.Discard, ArtifactHeader{})
+	require.NoError(t, err)
+	require.ErrorIs(t, a.Write(Op("*"), dnsdata.MapRecord{}), ErrBadOp)
+	require.Zero(t, a.Count())
+}
@@ -0,0 +1,337 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// errStopWalk stops the walks of ownerView which found what they looked for
+var errStopWalk = errors.New("stop walk")
+
+// ownerState is what the records of an owner name make of it
+type ownerState struct {
+	// exists is true if the name has records making it exist, which TTL
+	// overrides, dual-stack policies and markers don't
+	exists bool
+	// apex is true if the name has an SOA record
+	apex bool
+	// delegation is true if the name has NS records
+	delegation bool
+	// marker is true if the name has its empty non-terminal marker
+	marker bool
+	// wildcard and wildcardDelegation are exists and delegation for the
+	// wildcard below the name, whose records are stored with the name's
+	wildcard, wildcardDelegation bool
+}
+
+// add accounts the value of a key of the name
+func (s *ownerState) add(key, value []byte, marker dnsdata.MapRecord) {
+	if len(value) < 2 {
+		return
+	}
+	switch t := dnsdata.WireType(binary.BigEndian.Uint16(value)); t {
+	case dnsdata.TypeTTL, dnsdata.TypeDualStack:
+	case dnsdata.TypeENT:
+		if bytes.Equal(key, marker.Key) && bytes.Equal(value, marker.Value) {
+			s.marker = true
+		}
+	default:
+		if len(value) > 2 && (value[2] == '*' || value[2] == '*'+1) {
+			s.wildcard = true
+			s.wildcardDelegation = s.wildcardDelegation || t == dnsdata.TypeNS
+			return
+		}
+		s.exists = true
+		s.apex = s.apex || t == dnsdata.TypeSOA
+		s.delegation = s.delegation || t == dnsdata.TypeNS
+	}
+}
+
+// makesExist returns true if the name, and below if its wildcard, exist,
+// only counting the names with NS records with delegations
+func (s *ownerState) makesExist(delegations bool) (name, below bool) {
+	name = s.exists && (s.delegation || !delegations)
+	below = s.wildcard && (s.wildcardDelegation || !delegations)
+	return name, below
+}
+
+// valuesAfter returns the multi-value data of key once batch is applied to
+// data, as integrate does
+func (batch *Batch) valuesAfter(key, data []byte) ([]byte, error) {
+	batch.sort()
+	data = copyBytes(data)
+	find := func(pairs kvList) int {
+		return sort.Search(len(pairs), func(i int) bool { return bytes.Compare(pairs[i].key, key) >= 0 })
+	}
+	for i := find(batch.addedPairs); i < len(batch.addedPairs) && bytes.Equal(batch.addedPairs[i].key, key); i++ {
+		data = appendValues(data, batch.addedPairs[i].values)
+	}
+	for i := find(batch.deletedPairs); i < len(batch.deletedPairs) && bytes.Equal(batch.deletedPairs[i].key, key); i++ {
+		var err error
+		if data, err = delValue(data, batch.deletedPairs[i].values); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// ownerView tells the state of the owner names of a DB once a batch is
+// applied, names being lower case and without trailing dot
+type ownerView struct {
+	rdb   *RDB
+	batch *Batch
+	codec *dnsdata.Codec
+	// states caches the states read
+	states map[string]ownerState
+	// below, only for the v1 keys syntax, holds the names with names
+	// existing below them, true if some of those have NS records
+	below map[string]bool
+}
+
+// forEachValue calls f with every key starting with prefix and each of the
+// values it has once the batch is applied, the keys the batch adds included,
+// until f returns errStopWalk
+func (v *ownerView) forEachValue(prefix []byte, f func(key, value []byte) error) error {
+	each := func(key, data []byte) error {
+		data, err := v.batch.valuesAfter(key, data)
+		if err != nil {
+			return err
+		}
+		for len(data) > 0 {
+			value, rest, err := ReadNextChunk(data)
+			if err != nil {
+				return err
+			}
+			if err := f(key, value); err != nil {
+				return err
+			}
+			data = rest
+		}
+		return nil
+	}
+	v.batch.sort()
+	seen := make(map[string]struct{})
+	err := v.rdb.ForEachKeyWithPrefix(prefix, func(key, data []byte) error {
+		seen[string(key)] = struct{}{}
+		return each(key, data)
+	})
+	for i := 0; err == nil && i < len(v.batch.addedPairs); i++ {
+		key := v.batch.addedPairs[i].key
+		if _, found := seen[string(key)]; found || !bytes.HasPrefix(key, prefix) {
+			continue
+		}
+		seen[string(key)] = struct{}{}
+		err = each(key, nil)
+	}
+	if errors.Is(err, errStopWalk) {
+		return nil
+	}
+	return err
+}
+
+// summarize reads the states of all the names of a DB using the v1 keys
+// syntax, whose keys of a name can't be searched by prefix
+func (v *ownerView) summarize() error {
+	v.below = make(map[string]bool)
+	err := v.forEachValue(nil, func(key, value []byte) error {
+		name, ok := dnsdata.KeyOwnerName(key, false)
+		if !ok {
+			return nil
+		}
+		var marker dnsdata.MapRecord
+		if len(value) >= 2 && dnsdata.WireType(binary.BigEndian.Uint16(value)) == dnsdata.TypeENT {
+			var err error
+			if marker, err = v.codec.EmptyNonTerminalMarker(name); err != nil {
+				return err
+			}
+		}
+		s := v.states[name]
+		s.add(key, value, marker)
+		v.states[name] = s
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for name, s := range v.states {
+		if s.wildcard {
+			v.below[name] = v.below[name] || s.wildcardDelegation
+		}
+		if !s.exists && !s.wildcard {
+			continue
+		}
+		for p, ok := parentName(name); ok; p, ok = parentName(p) {
+			v.below[p] = v.below[p] || s.delegation || s.wildcardDelegation
+		}
+	}
+	return nil
+}
+
+// state returns the state of name
+func (v *ownerView) state(name string) (ownerState, error) {
+	if s, found := v.states[name]; found || v.below != nil {
+		return s, nil
+	}
+	marker, err := v.codec.EmptyNonTerminalMarker(name)
+	if err != nil {
+		return ownerState{}, err
+	}
+	var s ownerState
+	err = v.forEachValue(append(dnsdata.OwnerKeyPrefix(name), 0), func(key, value []byte) error {
+		s.add(key, value, marker)
+		return nil
+	})
+	if err != nil {
+		return ownerState{}, err
+	}
+	v.states[name] = s
+	return s, nil
+}
+
+// existsBelow returns true if names exist below name, or only names with NS
+// records with delegations
+func (v *ownerView) existsBelow(name string, delegations bool) (bool, error) {
+	if v.below != nil {
+		hasDelegations, found := v.below[name]
+		return found && (hasDelegations || !delegations), nil
+	}
+	prefix := dnsdata.OwnerKeyPrefix(name)
+	found := false
+	err := v.forEachValue(prefix, func(key, value []byte) error {
+		var s ownerState
+		s.add(key, value, dnsdata.MapRecord{})
+		exists, wildcardExists := s.makesExist(delegations)
+		// the keys of name itself only hold its wildcard below it
+		self := len(key) == len(prefix) || key[len(prefix)] == 0
+		if wildcardExists || (exists && !self) {
+			found = true
+			return errStopWalk
+		}
+		return nil
+	})
+	return found, err
+}
+
+// inZone returns true if a name above name has an SOA record
+func (v *ownerView) inZone(name string) (bool, error) {
+	for p, ok := parentName(name); ok; p, ok = parentName(p) {
+		s, err := v.state(p)
+		if err != nil {
+			return false, err
+		}
+		if s.apex {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// parentName returns the name without its first label, and false for the root
+func parentName(name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+	_, parent, _ := strings.Cut(name, ".")
+	return parent, true
+}
+
+// updateEmptyNonTerminals adds to batch the changes of the empty non-terminal
+// markers which its other changes make, for the names they touch and the
+// names above them, as the compilers would have stored them: markers of every
+// empty non-terminal with Codec.EmptyNonTerminals, of the ones above
+// delegations only otherwise. As DBs compiled before their features told
+// them apart have no Codec.EmptyNonTerminals, markers of empty non-terminals
+// above other names are never removed without it.
+// Names are searched by prefix in the v2 keys syntax, the v1 one takes a
+// scan of the whole DB.
+func (rdb *RDB) updateEmptyNonTerminals(batch *Batch, codec *dnsdata.Codec) error {
+	v2 := codec.Features.UseV2Keys
+	touched := make(map[string]struct{})
+	for _, pairs := range []kvList{batch.addedPairs, batch.deletedPairs} {
+		for _, pair := range pairs {
+			name, ok := dnsdata.KeyOwnerName(pair.key, v2)
+			for ; ok; name, ok = parentName(name) {
+				touched[name] = struct{}{}
+			}
+		}
+	}
+	if len(touched) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(touched))
+	for name := range touched {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	v := &ownerView{rdb: rdb, batch: batch, codec: codec, states: make(map[string]ownerState)}
+	if !v2 {
+		if err := v.summarize(); err != nil {
+			return err
+		}
+	}
+	var added, deleted []dnsdata.MapRecord
+	for _, name := range names {
+		s, err := v.state(name)
+		if err != nil {
+			return err
+		}
+		wanted, kept := false, false
+		if !s.exists {
+			inZone, err := v.inZone(name)
+			if err != nil {
+				return err
+			}
+			if inZone {
+				if kept, err = v.existsBelow(name, false); err != nil {
+					return err
+				}
+				wanted = kept
+				if !codec.EmptyNonTerminals {
+					if wanted, err = v.existsBelow(name, true); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if wanted == s.marker || (!wanted && kept) {
+			continue
+		}
+		marker, err := codec.EmptyNonTerminalMarker(name)
+		if err != nil {
+			return err
+		}
+		if wanted {
+			added = append(added, marker)
+		} else {
+			deleted = append(deleted, marker)
+		}
+	}
+	for _, r := range added {
+		batch.Add(r.Key, r.Value)
+	}
+	for _, r := range deleted {
+		batch.Del(r.Key, r.Value)
+	}
+	return nil
+}
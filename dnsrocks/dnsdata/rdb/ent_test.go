@@ -0,0 +1,114 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// entMarkerKeys returns the keys of the empty non-terminal markers of rdb
+func entMarkerKeys(t *testing.T, rdb *RDB) []string {
+	var keys []string
+	err := rdb.ForEachKeyWithPrefix(nil, func(key, data []byte) error {
+		for len(data) > 0 {
+			value, rest, err := ReadNextChunk(data)
+			require.NoError(t, err)
+			if len(value) >= 2 && dnsdata.WireType(binary.BigEndian.Uint16(value)) == dnsdata.TypeENT {
+				keys = append(keys, string(key))
+			}
+			data = rest
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return keys
+}
+
+// TestApplyDiffEmptyNonTerminals checks that diffs leave the same empty
+// non-terminal markers as a compilation of the data they lead to
+func TestApplyDiffEmptyNonTerminals(t *testing.T) {
+	const base = `Zexample.com,ns.example.com,dns.example.com,123,1800,900,604800,3600,300
+&example.com,,ns.example.com,300
++a.b.c.example.com,192.0.2.1,300
+&x.sub.example.com,,ns.child.example.net,300
+&q.r.example.com,,ns.child.example.net,300
++*.w.example.com,192.0.2.2,300
+`
+	// the last names below c.example.com, r.example.com and w.example.com go
+	// away, sub.example.com gets delegated, e.example.com and g.example.com
+	// get names below them
+	const diff = `-+a.b.c.example.com,192.0.2.1,300
+-&q.r.example.com,,ns.child.example.net,300
+-+*.w.example.com,192.0.2.2,300
++&sub.example.com,,ns.child.example.net,300
+++d.e.example.com,192.0.2.5,300
++&y.g.example.com,,ns.child.example.net,300
+`
+	const result = `Zexample.com,ns.example.com,dns.example.com,123,1800,900,604800,3600,300
+&example.com,,ns.example.com,300
+&x.sub.example.com,,ns.child.example.net,300
+&sub.example.com,,ns.child.example.net,300
++d.e.example.com,192.0.2.5,300
+&y.g.example.com,,ns.child.example.net,300
+`
+	for _, v2 := range []bool{false, true} {
+		for _, all := range []bool{false, true} {
+			opts := CompilationOptions{NumCPU: 1, UseV2KeySyntax: v2, EmptyNonTerminals: all}
+			dir := t.TempDir()
+			_, err := Compile(strings.NewReader(base), 1, dir, opts)
+			require.NoError(t, err)
+			rdb, err := NewUpdater(dir)
+			require.NoError(t, err)
+			before := entMarkerKeys(t, rdb)
+			require.NoError(t, rdb.ApplyDiff(strings.NewReader(diff), 1))
+			got := entMarkerKeys(t, rdb)
+			require.NoError(t, rdb.Close())
+
+			want := t.TempDir()
+			_, err = Compile(strings.NewReader(result), 1, want, opts)
+			require.NoError(t, err)
+			rdb, err = NewUpdater(want)
+			require.NoError(t, err)
+			require.Equal(t, entMarkerKeys(t, rdb), got, "v2 %v, all %v", v2, all)
+			require.NotEqual(t, before, got)
+			require.NoError(t, rdb.Close())
+		}
+	}
+}
+
+func TestKeyOwnerName(t *testing.T) {
+	codec := new(dnsdata.Codec)
+	for _, v2 := range []bool{false, true} {
+		codec.Features.UseV2Keys = v2
+		marker, err := codec.EmptyNonTerminalMarker("b.example.com")
+		require.NoError(t, err)
+		name, ok := dnsdata.KeyOwnerName(marker.Key, v2)
+		require.True(t, ok)
+		require.Equal(t, "b.example.com", name)
+		if v2 {
+			require.True(t, bytes.HasPrefix(marker.Key, dnsdata.OwnerKeyPrefix("example.com")))
+		}
+	}
+	_, ok := dnsdata.KeyOwnerName([]byte(dnsdata.FeaturesKey), true)
+	require.False(t, ok)
+}
@@ -0,0 +1,337 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	rocksdb "github.com/facebook/dns/dnsrocks/cgo-rocksdb"
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb/dbdiff"
+)
+
+// A DB using the v1 keys syntax is migrated to the v2 one, without recompiling
+// it from source, either:
+//
+//   - in place, by MigrateToV2Keys writing the v2 keys next to the v1 ones and
+//     flipping the features key, which readers pick up when reloading, then by
+//     RemoveV1Keys once they all have
+//   - through an artifact written by WriteKeyMigrationArtifact, replacing the
+//     v1 keys by the v2 ones when applied with ApplyArtifact
+
+// v2FeaturesRecord returns the features record of the DB, the one it has once
+// migrated to the v2 keys syntax, and an error if it already uses it
+func (rdb *RDB) v2FeaturesRecord() (old, migrated []byte, err error) {
+	old, err = rdb.Find([]byte(dnsdata.FeaturesKey), NewContext())
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, fmt.Errorf("reading features failed: %w", err)
+	}
+	if dnsdata.DecodeFeatures(old)&dnsdata.V2KeysFeature != 0 {
+		return nil, nil, fmt.Errorf("%w: DB already uses v2 keys", ErrKeySyntaxMismatch)
+	}
+	migrated, err = dnsdata.V2Features(old)
+	return old, migrated, err
+}
+
+// forEachV1Key calls f, in key order, with every key of the DB which changes
+// in the v2 keys syntax, its v2 syntax and its raw (multi-value) data. Keys
+// which are not in the v1 syntax fail the iteration, or are skipped with
+// skipInvalid.
+func (rdb *RDB) forEachV1Key(skipInvalid bool, f func(key, v2key, data []byte) error) error {
+	return rdb.ForEachKeyWithPrefix(nil, func(key, data []byte) error {
+		v2key, changed, err := dnsdata.V2Key(key)
+		if skipInvalid && errors.Is(err, dnsdata.ErrNotV1Key) {
+			return nil
+		}
+		if err != nil || !changed {
+			return err
+		}
+		return f(key, v2key, data)
+	})
+}
+
+// rawBatch writes to the DB batchSize keys at a time, bypassing the
+// multi-value handling of Batch. Callers must hold the write lock.
+type rawBatch struct {
+	rdb       *RDB
+	batch     *rocksdb.Batch
+	batchSize int
+}
+
+func (rdb *RDB) newRawBatch(batchSize int) *rawBatch {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &rawBatch{rdb: rdb, batch: rdb.db.NewBatch(), batchSize: batchSize}
+}
+
+func (b *rawBatch) put(key, data []byte) error {
+	b.batch.Put(key, data)
+	return b.flushIfFull()
+}
+
+func (b *rawBatch) delete(key []byte) error {
+	b.batch.Delete(key)
+	return b.flushIfFull()
+}
+
+func (b *rawBatch) flushIfFull() error {
+	if b.batch.GetCount() < b.batchSize {
+		return nil
+	}
+	return b.flush()
+}
+
+func (b *rawBatch) flush() error {
+	if b.batch.GetCount() == 0 {
+		return nil
+	}
+	if err := b.rdb.db.ExecuteBatch(b.batch, b.rdb.writeOptions); err != nil {
+		return err
+	}
+	b.batch.Clear()
+	return nil
+}
+
+func (b *rawBatch) destroy() {
+	b.batch.Destroy()
+}
+
+// MigrateToV2Keys migrates in place the DB from the v1 keys syntax to the v2
+// one, writing batchSize keys at a time. The v2 keys are written next to the
+// v1 ones, then the features key is updated and the checksum, if any,
+// removed, so that readers keep answering from the v1 keys until they reload
+// the DB. The v1 map keys are listed under dnsdata.V1MapKeysKey for
+// RemoveV1Keys. An interrupted migration can't be resumed, as the v2 keys
+// already written can't be told apart from v1 ones. It returns the number of
+// keys written.
+func (rdb *RDB) MigrateToV2Keys(batchSize int) (int, error) {
+	rdb.writeMutex.Lock()
+	defer rdb.writeMutex.Unlock()
+	_, features, err := rdb.v2FeaturesRecord()
+	if err != nil {
+		return 0, err
+	}
+	b := rdb.newRawBatch(batchSize)
+	defer b.destroy()
+
+	n := 0
+	var mapKeys []byte
+	// the iterator reads from a snapshot, the keys written meanwhile are not
+	// visited
+	err = rdb.forEachV1Key(false, func(key, v2key, data []byte) error {
+		if !bytes.HasPrefix(v2key, []byte(dnsdata.ResourceRecordsKeyMarker)) {
+			mapKeys = appendValues(mapKeys, key)
+		}
+		n++
+		return b.put(v2key, data)
+	})
+	if err != nil {
+		return n, err
+	}
+	if err := b.flush(); err != nil {
+		return n, err
+	}
+	if len(mapKeys) > 0 {
+		if err := b.put([]byte(dnsdata.V1MapKeysKey), mapKeys); err != nil {
+			return n, err
+		}
+	}
+	// the content no longer matches the checksum of the compiled DB
+	if err := b.delete([]byte(dnsdata.ChecksumKey)); err != nil {
+		return n, err
+	}
+	if err := b.put([]byte(dnsdata.FeaturesKey), appendValues(nil, features)); err != nil {
+		return n, err
+	}
+	return n, b.flush()
+}
+
+// RemoveV1Keys deletes, batchSize at a time, the v1 keys left by
+// MigrateToV2Keys, once all readers use the v2 ones. Only the keys whose v2
+// key holds the same data are deleted, and resource record keys of the
+// location whose ID is dnsdata.ResourceRecordsKeyMarker are left, as they
+// can't be told apart from v2 ones. It returns the number of keys deleted.
+func (rdb *RDB) RemoveV1Keys(batchSize int) (int, error) {
+	rdb.writeMutex.Lock()
+	defer rdb.writeMutex.Unlock()
+	if !rdb.IsV2KeySyntaxUsed() {
+		return 0, fmt.Errorf("%w: DB does not use v2 keys", ErrKeySyntaxMismatch)
+	}
+	b := rdb.newRawBatch(batchSize)
+	defer b.destroy()
+
+	n := 0
+	remove := func(key, v2key, data []byte) error {
+		v2data, err := rdb.db.Get(rdb.readOptions, v2key)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 || !bytes.Equal(v2data, data) {
+			return nil
+		}
+		n++
+		return b.delete(key)
+	}
+
+	mapKeys, err := rdb.db.Get(rdb.readOptions, []byte(dnsdata.V1MapKeysKey))
+	if err != nil {
+		return 0, err
+	}
+	for len(mapKeys) > 0 {
+		var key []byte
+		key, mapKeys, err = ReadNextChunk(mapKeys)
+		if err != nil {
+			return n, fmt.Errorf("reading v1 map keys failed: %w", err)
+		}
+		v2key, changed, err := dnsdata.V2Key(key)
+		if err != nil {
+			return n, err
+		}
+		if !changed {
+			// the v1 key is the v2 one
+			continue
+		}
+		data, err := rdb.db.Get(rdb.readOptions, key)
+		if err != nil {
+			return n, err
+		}
+		if err := remove(key, v2key, data); err != nil {
+			return n, err
+		}
+	}
+	if err := b.delete([]byte(dnsdata.V1MapKeysKey)); err != nil {
+		return n, err
+	}
+
+	err = rdb.forEachV1Key(true, func(key, v2key, data []byte) error {
+		if bytes.HasPrefix(key, []byte(dnsdata.ResourceRecordsKeyMarker)) ||
+			!bytes.HasPrefix(v2key, []byte(dnsdata.ResourceRecordsKeyMarker)) {
+			return nil
+		}
+		return remove(key, v2key, data)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, b.flush()
+}
+
+// WriteKeyMigrationArtifact writes to w an artifact migrating the DB from the
+// v1 keys syntax to the v2 one: every value of the keys which change is
+// deleted from the v1 key and added to the v2 one, and the features key is
+// updated. The artifact applies to the current provenance serial of the DB,
+// and leaves it unchanged. It returns the number of records written.
+func (rdb *RDB) WriteKeyMigrationArtifact(w io.Writer) (uint64, error) {
+	oldFeatures, features, err := rdb.v2FeaturesRecord()
+	if err != nil {
+		return 0, err
+	}
+	p, err := rdb.provenance()
+	if err != nil {
+		return 0, fmt.Errorf("reading provenance failed: %w", err)
+	}
+	if p == nil {
+		return 0, ErrNoProvenance
+	}
+	a, err := dbdiff.NewArtifactWriter(w, dbdiff.ArtifactHeader{
+		FromSerial:   p.Serial,
+		ToSerial:     p.Serial,
+		Version:      p.Version,
+		UseV2Keys:    true,
+		KeyMigration: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	err = rdb.forEachV1Key(false, func(key, v2key, data []byte) error {
+		for {
+			value, leftover, err := ReadNextChunk(data)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("reading values of %x failed: %w", key, err)
+			}
+			if err := a.Write(dbdiff.DelOp, dnsdata.MapRecord{Key: key, Value: value}); err != nil {
+				return err
+			}
+			if err := a.Write(dbdiff.AddOp, dnsdata.MapRecord{Key: v2key, Value: value}); err != nil {
+				return err
+			}
+			data = leftover
+		}
+	})
+	if err != nil {
+		return a.Count(), err
+	}
+	if len(oldFeatures) > 0 {
+		if err := a.Write(dbdiff.DelOp, dnsdata.MapRecord{Key: []byte(dnsdata.FeaturesKey), Value: oldFeatures}); err != nil {
+			return a.Count(), err
+		}
+	}
+	if err := a.Write(dbdiff.AddOp, dnsdata.MapRecord{Key: []byte(dnsdata.FeaturesKey), Value: features}); err != nil {
+		return a.Count(), err
+	}
+	return a.Count(), a.Close()
+}
+
+// MigrateToV2Keys migrates in place the RDB database at dbpath to the v2 keys
+// syntax, see RDB.MigrateToV2Keys.
+func MigrateToV2Keys(dbpath string, batchSize int) (int, error) {
+	rdb, err := NewUpdater(dbpath)
+	if err != nil {
+		return 0, err
+	}
+	defer rdb.Close()
+	return rdb.MigrateToV2Keys(batchSize)
+}
+
+// RemoveV1Keys deletes the v1 keys left in the RDB database at dbpath once
+// migrated in place, see RDB.RemoveV1Keys.
+func RemoveV1Keys(dbpath string, batchSize int) (int, error) {
+	rdb, err := NewUpdater(dbpath)
+	if err != nil {
+		return 0, err
+	}
+	defer rdb.Close()
+	return rdb.RemoveV1Keys(batchSize)
+}
+
+// WriteKeyMigrationArtifact writes to artifactPath an artifact migrating the
+// RDB database at dbpath to the v2 keys syntax, see
+// RDB.WriteKeyMigrationArtifact.
+func WriteKeyMigrationArtifact(dbpath, artifactPath string) (uint64, error) {
+	rdb, err := NewUpdater(dbpath)
+	if err != nil {
+		return 0, err
+	}
+	defer rdb.Close()
+	out, err := os.Create(artifactPath)
+	if err != nil {
+		return 0, fmt.Errorf("%s: can't create output: %w", artifactPath, err)
+	}
+	n, err := rdb.WriteKeyMigrationArtifact(out)
+	if err != nil {
+		out.Close()
+		return n, err
+	}
+	return n, out.Close()
+}
@@ -0,0 +1,171 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdb
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// compileMigrationTestDB compiles the test data, followed by the extra lines,
+// with the given keys syntax and returns its path
+func compileMigrationTestDB(t *testing.T, useV2Keys bool, extra ...string) string {
+	input, err := os.ReadFile("../../testdata/data/data.in")
+	require.NoError(t, err)
+	for _, line := range extra {
+		input = append(input, line+"\n"...)
+	}
+	dir := t.TempDir()
+	_, err = Compile(bytes.NewReader(input), 10, dir, CompilationOptions{
+		NumCPU:         1,
+		UseV2KeySyntax: useV2Keys,
+		Version:        "v10",
+		Checksum:       true,
+	})
+	require.NoError(t, err)
+	return dir
+}
+
+// dumpDB returns the keys of the DB and their sorted values, checksum
+// excepted
+func dumpDB(t *testing.T, rdb *RDB) map[string][]string {
+	got := map[string][]string{}
+	require.NoError(t, rdb.ForEachKeyWithPrefix(nil, func(k, data []byte) error {
+		var values []string
+		for len(data) > 0 {
+			v, leftover, err := ReadNextChunk(data)
+			if err != nil {
+				return err
+			}
+			values = append(values, string(v))
+			data = leftover
+		}
+		sort.Strings(values)
+		got[string(k)] = values
+		return nil
+	}))
+	delete(got, dnsdata.ChecksumKey)
+	return got
+}
+
+func TestMigrateToV2Keys(t *testing.T) {
+	v2, err := NewUpdater(compileMigrationTestDB(t, true))
+	require.NoError(t, err)
+	expected := dumpDB(t, v2)
+	require.NoError(t, v2.Close())
+
+	rdb, err := NewUpdater(compileMigrationTestDB(t, false))
+	require.NoError(t, err)
+	defer rdb.Close()
+	v1 := dumpDB(t, rdb)
+
+	n, err := rdb.MigrateToV2Keys(7)
+	require.NoError(t, err)
+	require.Positive(t, n)
+	require.True(t, rdb.IsV2KeySyntaxUsed())
+	_, err = rdb.Find([]byte(dnsdata.ChecksumKey), NewContext())
+	require.Error(t, err)
+
+	// the v1 keys are kept next to the v2 ones
+	got := dumpDB(t, rdb)
+	require.Contains(t, got, dnsdata.V1MapKeysKey)
+	delete(got, dnsdata.V1MapKeysKey)
+	for k, v := range expected {
+		require.Equal(t, v, got[k], "%x", k)
+	}
+	for k, v := range v1 {
+		if k != dnsdata.FeaturesKey {
+			require.Equal(t, v, got[k], "%x", k)
+		}
+	}
+
+	_, err = rdb.MigrateToV2Keys(0)
+	require.ErrorIs(t, err, ErrKeySyntaxMismatch)
+
+	removed, err := rdb.RemoveV1Keys(7)
+	require.NoError(t, err)
+	require.Equal(t, n, removed)
+	require.Equal(t, expected, dumpDB(t, rdb))
+
+	removed, err = rdb.RemoveV1Keys(0)
+	require.NoError(t, err)
+	require.Zero(t, removed)
+}
+
+// TestRemoveV1KeysKeepsUnchangedMapKeys checks that map keys which are the
+// same in both syntaxes, e.g. of the root or of a single label, are kept
+func TestRemoveV1KeysKeepsUnchangedMapKeys(t *testing.T) {
+	extra := []string{"8*.,c\\000", "Mcom,c\\000", "M*.net,c\\000"}
+	rdb, err := NewUpdater(compileMigrationTestDB(t, false, extra...))
+	require.NoError(t, err)
+	defer rdb.Close()
+	unchanged := []string{"\0008\000*", "\000M\003com\000=", "\000M\003net\000*"}
+	v1 := dumpDB(t, rdb)
+	for _, key := range unchanged {
+		require.Contains(t, v1, key, "%q", key)
+	}
+
+	_, err = rdb.MigrateToV2Keys(0)
+	require.NoError(t, err)
+	_, err = rdb.RemoveV1Keys(0)
+	require.NoError(t, err)
+	got := dumpDB(t, rdb)
+	for _, key := range unchanged {
+		require.Equal(t, v1[key], got[key], "%q", key)
+	}
+}
+
+func TestRemoveV1KeysNotMigrated(t *testing.T) {
+	rdb, err := NewUpdater(compileMigrationTestDB(t, false))
+	require.NoError(t, err)
+	defer rdb.Close()
+	_, err = rdb.RemoveV1Keys(0)
+	require.ErrorIs(t, err, ErrKeySyntaxMismatch)
+}
+
+func TestKeyMigrationArtifact(t *testing.T) {
+	v2, err := NewUpdater(compileMigrationTestDB(t, true))
+	require.NoError(t, err)
+	expected := dumpDB(t, v2)
+	require.NoError(t, v2.Close())
+
+	dir := compileMigrationTestDB(t, false)
+	rdb, err := NewUpdater(dir)
+	require.NoError(t, err)
+	defer rdb.Close()
+	var artifact bytes.Buffer
+	n, err := rdb.WriteKeyMigrationArtifact(&artifact)
+	require.NoError(t, err)
+	require.Positive(t, n)
+
+	h, err := rdb.ApplyArtifact(bytes.NewReader(artifact.Bytes()))
+	require.NoError(t, err)
+	require.True(t, h.KeyMigration)
+	require.Equal(t, uint32(10), h.ToSerial)
+	require.True(t, rdb.IsV2KeySyntaxUsed())
+	require.Equal(t, expected, dumpDB(t, rdb))
+
+	_, err = rdb.ApplyArtifact(bytes.NewReader(artifact.Bytes()))
+	require.ErrorIs(t, err, ErrKeySyntaxMismatch)
+	_, err = rdb.WriteKeyMigrationArtifact(&artifact)
+	require.ErrorIs(t, err, ErrKeySyntaxMismatch)
+}
@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -15,6 +17,7 @@ package rdb
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -24,6 +27,7 @@ import (
 	"runtime"
 	"strconv"
 	"sync"
+	"time"
 
 	rocksdb "github.com/facebook/dns/dnsrocks/cgo-rocksdb"
 	"github.com/facebook/dns/dnsrocks/dnsdata"
@@ -56,6 +60,7 @@ const Mb = 1 << 20
 // DBI is an interface abstracting RocksDB operations. Enables mocks.
 type DBI interface {
 	Put(writeOptions *rocksdb.WriteOptions, key, value []byte) error
+	Merge(writeOptions *rocksdb.WriteOptions, key, value []byte) error
 	Get(readOptions *rocksdb.ReadOptions, key []byte) ([]byte, error)
 	Delete(writeOptions *rocksdb.WriteOptions, key []byte) error
 	NewBatch() *rocksdb.Batch
@@ -65,9 +70,11 @@ type DBI interface {
 	Flush() error
 	CreateIterator(readOptions *rocksdb.ReadOptions) *rocksdb.Iterator
 	CatchWithPrimary() error
+	GetLatestSequenceNumber() uint64
 	CloseDatabase()
 	GetProperty(string) string
 	GetOptions() *rocksdb.Options
+	GetLiveFilesMetaData() []rocksdb.LiveFileMetaData
 	CompactRangeAll()
 	WaitForCompact(options *rocksdb.WaitForCompactOptions) error
 }
@@ -75,18 +82,27 @@ type DBI interface {
 // RDB is RocksDB-backed DNS database
 type RDB struct {
 	db           DBI
-	writeMutex   *sync.Mutex
+	writeMutex   *sync.RWMutex // held for reading by merges, for writing by read-modify-writes
 	readOptions  *rocksdb.ReadOptions
 	writeOptions *rocksdb.WriteOptions
 	logDir       string // RocksDB log output directory
 	secondary    bool   // DB open in secondary mode
+	readOnly     bool   // DB open in read-only mode
+	mergeAdds    bool   // Add writes merge operands, see UpdaterOptions
 
 	iteratorPool *IteratorPool
+	catchUp      *catchUp // set in secondary mode
 }
 
+// ErrDeadlineExceeded is the error of the reads made past the deadline of their Context
+var ErrDeadlineExceeded = fmt.Errorf("RocksDB read deadline exceeded: %w", context.DeadlineExceeded)
+
 // Context is a structure holding the state between calls to DB
 type Context struct {
 	cache map[string]contextCacheEntry
+	// deadline of the reads, if set, enforced by RocksDB through readOptions
+	deadline    time.Time
+	readOptions *rocksdb.ReadOptions
 }
 
 type contextCacheEntry struct {
@@ -114,6 +130,7 @@ func NewRDB(path string) (*RDB, error) {
 	options.OptimizeLevelStyleCompaction(0)
 	options.SetFullBloomFilter(10)    // 10 bits
 	options.SetLRUCacheSize(128 * Mb) // 128 Mb
+	options.SetMergeOperator(valuesMergeOperator{})
 
 	db, err := rocksdb.OpenDatabase(path, false, false, options)
 	if err != nil {
@@ -126,12 +143,12 @@ func NewRDB(path string) (*RDB, error) {
 	)
 	readOptions := rocksdb.NewDefaultReadOptions()
 
-	iteratorPool := newIteratorPool(func() *rocksdb.Iterator { return db.CreateIterator(readOptions) })
+	iteratorPool := newIteratorPool(db.CreateIterator)
 	iteratorPool.enable()
 
 	return &RDB{
 		db:           db,
-		writeMutex:   &sync.Mutex{},
+		writeMutex:   &sync.RWMutex{},
 		readOptions:  readOptions,
 		writeOptions: writeOptions,
 		logDir:       path,
@@ -200,45 +217,103 @@ func DefaultOptions() *rocksdb.Options {
 		levels[i] = rocksdb.CompressionLZ4
 	}
 	options.SetCompressionPerLevel(levels)
+	// values added with Add may be merged with the existing ones, see
+	// UpdaterOptions.MergeAdds
+	options.SetMergeOperator(valuesMergeOperator{})
 	return options
 }
 
+// ReaderOptions are the options of NewReaderWithOptions
+type ReaderOptions struct {
+	// ReadOnly opens the database in read-only mode instead of as a secondary.
+	// It needs no log directory and fewer file descriptors, but cannot
+	// CatchWithPrimary, which suits databases that are not updated in place.
+	ReadOnly bool
+	// MaxStaleness, if set, is how long a secondary can go without catching
+	// up with its primary before its stats report it as stale
+	MaxStaleness time.Duration
+	// MemoryBudget, if set, is shared with the other instances opened with it
+	// instead of allocating a block cache of their own
+	MemoryBudget *MemoryBudget
+}
+
 // NewReader creates a read-only instance of RDB; path should be an existing path
 // to the directory, the database will be opened as secondary
 func NewReader(path string) (*RDB, error) {
+	return NewReaderWithOptions(path, ReaderOptions{})
+}
+
+// NewReaderWithOptions creates a read-only instance of RDB like NewReader,
+// with the given options
+func NewReaderWithOptions(path string, opts ReaderOptions) (*RDB, error) {
 	if info, err := os.Stat(path); err != nil || !info.IsDir() {
 		return nil, fmt.Errorf("%s directory does not exist: %w", path, err)
 	}
 
-	logDir, err := os.MkdirTemp("", fmt.Sprintf("rdb-log-%d", os.Getpid()))
-	if err != nil {
-		return nil, err
-	}
-
+	var (
+		db     *rocksdb.RocksDB
+		logDir string
+		err    error
+	)
 	options := DefaultOptions()
-	db, err := rocksdb.OpenSecondary(path, logDir, options)
+	if opts.MemoryBudget != nil {
+		opts.MemoryBudget.apply(options)
+	}
+	if opts.ReadOnly {
+		db, err = rocksdb.OpenDatabase(path, true, false, options)
+	} else {
+		logDir, err = os.MkdirTemp("", fmt.Sprintf("rdb-log-%d", os.Getpid()))
+		if err != nil {
+			options.FreeOptions()
+			return nil, err
+		}
+		db, err = rocksdb.OpenSecondary(path, logDir, options)
+	}
 	if err != nil {
 		options.FreeOptions()
+		if logDir != "" {
+			os.RemoveAll(logDir)
+		}
 		return nil, err
 	}
 	readOptions := rocksdb.NewDefaultReadOptions()
 
-	iteratorPool := newIteratorPool(func() *rocksdb.Iterator { return db.CreateIterator(readOptions) })
+	iteratorPool := newIteratorPool(db.CreateIterator)
 	iteratorPool.enable()
 
-	return &RDB{
+	rdb := &RDB{
 		db:           db,
 		readOptions:  readOptions,
 		logDir:       logDir,
-		secondary:    true,
+		secondary:    !opts.ReadOnly,
+		readOnly:     opts.ReadOnly,
 		iteratorPool: iteratorPool,
-	}, nil
+	}
+	if rdb.secondary {
+		rdb.catchUp = newCatchUp(db.GetLatestSequenceNumber(), opts.MaxStaleness)
+	}
+	return rdb, nil
+}
+
+// UpdaterOptions are the options of NewUpdaterWithOptions
+type UpdaterOptions struct {
+	// MergeAdds makes Add, and ExecuteBatch for batches which only add
+	// values, write values as merge operands instead of reading and
+	// rewriting the values of their key under the write lock, so that
+	// concurrent additions do not wait for each other. This changes the
+	// on-disk format, see valuesMergeOperator.
+	MergeAdds bool
 }
 
 // NewUpdater opens an existing database for update.
 // It returns an instance of RDB; dbpath should be an existing path to the directory
 // containing a RocksDB database.
 func NewUpdater(dbpath string) (*RDB, error) {
+	return NewUpdaterWithOptions(dbpath, UpdaterOptions{})
+}
+
+// NewUpdaterWithOptions is NewUpdater with opts
+func NewUpdaterWithOptions(dbpath string, opts UpdaterOptions) (*RDB, error) {
 	opt := DefaultOptions()
 	// The options below were copied from NewRDB() — their effect on the update performance is not yet determined
 	opt.SetParallelism(runtime.NumCPU())
@@ -261,10 +336,11 @@ func NewUpdater(dbpath string) (*RDB, error) {
 	ropt := rocksdb.NewDefaultReadOptions()
 	rdb := &RDB{
 		db:           db,
-		writeMutex:   &sync.Mutex{},
+		writeMutex:   &sync.RWMutex{},
 		readOptions:  ropt,
 		writeOptions: wopt,
 		logDir:       dbpath,
+		mergeAdds:    opts.MergeAdds,
 	}
 	return rdb, nil
 }
@@ -275,22 +351,30 @@ func (rdb *RDB) CatchWithPrimary() error {
 		return errors.New("database is not in secondary mode")
 	}
 
+	rdb.catchUp.running.Lock()
+	defer rdb.catchUp.running.Unlock()
+
 	// pooled iterators should be cleaned here
 	// so DB snapshot is released
 	rdb.iteratorPool.disable()
+	defer rdb.iteratorPool.enable()
 
 	err := rdb.db.CatchWithPrimary()
-	if err != nil {
-		return err
-	}
-
-	rdb.iteratorPool.enable()
-
-	return nil
+	rdb.catchUp.record(rdb.db.GetLatestSequenceNumber(), err)
+	return err
 }
 
-// Add inserts a multi-value pair of key and value
+// Add inserts a multi-value pair of key and value. With
+// UpdaterOptions.MergeAdds, the value is merged with the existing ones by
+// RocksDB, without reading them.
 func (rdb *RDB) Add(key, value []byte) error {
+	if rdb.mergeAdds {
+		rdb.writeMutex.RLock()
+		defer rdb.writeMutex.RUnlock()
+
+		return rdb.db.Merge(rdb.writeOptions, key, appendValues(nil, value))
+	}
+
 	rdb.writeMutex.Lock()
 	defer rdb.writeMutex.Unlock()
 
@@ -342,6 +426,21 @@ func (rdb *RDB) GetStats() map[string]int64 {
 		for k, v := range s {
 			stats[k] = v
 		}
+		if statistics := opts.GetStatistics(); statistics != nil {
+			for k, v := range statisticsStats(statistics) {
+				stats[k] = v
+			}
+		}
+	}
+
+	for k, v := range levelStats(rdb.db.GetLiveFilesMetaData()) {
+		stats[k] = v
+	}
+
+	if rdb.catchUp != nil {
+		for k, v := range rdb.catchUp.stats(time.Now()) {
+			stats[k] = v
+		}
 	}
 
 	return stats
@@ -377,32 +476,106 @@ func (rdb *RDB) Del(key, value []byte) error {
 }
 
 // ExecuteBatch will apply all operations from the batch. The same batch
-// cannot be applied twice.
+// cannot be applied twice. With UpdaterOptions.MergeAdds, batches which only
+// add values are merged with the existing ones by RocksDB, without reading
+// them.
 func (rdb *RDB) ExecuteBatch(batch *Batch) error {
 	if batch.IsEmpty() {
 		return nil
 	}
 
-	uniqueKeys := batch.getAffectedKeys()
+	if rdb.mergesBatch(batch) {
+		rdb.writeMutex.RLock()
+		defer rdb.writeMutex.RUnlock()
+		return rdb.mergeValues(batch)
+	}
 
 	// lock is needed, because between getting and updating values there might be a race
 	rdb.writeMutex.Lock()
 	defer rdb.writeMutex.Unlock()
+	uniqueKeys, _, dbValues, err := rdb.integrateBatch(batch, false)
+	if err != nil {
+		return err
+	}
+	return rdb.writeValues(uniqueKeys, dbValues)
+}
+
+// ExecuteBatchWithOptions is ExecuteBatch returning a summary of the changes
+// made, or only computing them with opts.DryRun, and checking them once
+// written with opts.Verify
+func (rdb *RDB) ExecuteBatchWithOptions(batch *Batch, opts ApplyOptions) (*ChangeSummary, error) {
+	if batch.IsEmpty() {
+		return new(ChangeSummary), nil
+	}
+
+	merge := rdb.mergesBatch(batch)
+	if merge && !opts.Verify {
+		// the values read only make the summary, merges don't depend on them
+		rdb.writeMutex.RLock()
+		defer rdb.writeMutex.RUnlock()
+	} else {
+		rdb.writeMutex.Lock()
+		defer rdb.writeMutex.Unlock()
+	}
+	uniqueKeys, prevValues, dbValues, err := rdb.integrateBatch(batch, true)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := summarizeChanges(uniqueKeys, prevValues, dbValues, opts.MaxSamples)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		return summary, nil
+	}
+	if merge {
+		err = rdb.mergeValues(batch)
+	} else {
+		err = rdb.writeValues(uniqueKeys, dbValues)
+	}
+	if err != nil {
+		return summary, err
+	}
+	if opts.Verify {
+		if err := rdb.verifyValues(uniqueKeys, dbValues); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+// integrateBatch returns the keys affected by batch, in sorted order, with the
+// multi-values they hold once batch is applied, and with keepPrev the ones
+// they hold now. Callers must hold the write lock, for reading if the values
+// are only used to summarize merges.
+func (rdb *RDB) integrateBatch(batch *Batch, keepPrev bool) (uniqueKeys, prevValues, dbValues [][]byte, err error) {
+	uniqueKeys = batch.getAffectedKeys()
+
 	dbValues, errors := rdb.db.GetMulti(rdb.readOptions, uniqueKeys)
 	for _, err := range errors {
 		if err != nil {
-			return err // return the first error that had happened in GetMulti()
+			return nil, nil, nil, err // return the first error that had happened in GetMulti()
+		}
+	}
+	if keepPrev {
+		prevValues = make([][]byte, len(dbValues))
+		for i, v := range dbValues {
+			prevValues[i] = copyBytes(v)
 		}
 	}
-
-	dbBatch := rdb.db.NewBatch()
-	defer dbBatch.Destroy()
 
 	// update dbValues with batch contents; it assumes that uniqueKeys is sorted
 	// and in the same order as dbValues
 	if err := batch.integrate(uniqueKeys, &dbValues); err != nil {
-		return err
+		return nil, nil, nil, err
 	}
+	return uniqueKeys, prevValues, dbValues, nil
+}
+
+// writeValues stores the multi-values of keys, deleting the empty ones
+func (rdb *RDB) writeValues(uniqueKeys, dbValues [][]byte) error {
+	dbBatch := rdb.db.NewBatch()
+	defer dbBatch.Destroy()
 
 	for i, key := range uniqueKeys {
 		val := dbValues[i]
@@ -416,11 +589,30 @@ func (rdb *RDB) ExecuteBatch(batch *Batch) error {
 	return rdb.db.ExecuteBatch(dbBatch, rdb.writeOptions)
 }
 
+// mergesBatch tells if batch is written as merge operands, see
+// UpdaterOptions.MergeAdds. Deleting values requires reading them.
+func (rdb *RDB) mergesBatch(batch *Batch) bool {
+	return rdb.mergeAdds && len(batch.deletedPairs) == 0
+}
+
+// mergeValues writes the values added by batch as merge operands, without
+// reading the existing values of their keys. batch must not delete values.
+func (rdb *RDB) mergeValues(batch *Batch) error {
+	dbBatch := rdb.db.NewBatch()
+	defer dbBatch.Destroy()
+
+	for _, kv := range batch.addedPairs {
+		dbBatch.Merge(kv.key, appendValues(nil, kv.values))
+	}
+
+	return rdb.db.ExecuteBatch(dbBatch, rdb.writeOptions)
+}
+
 // Close closes the database and frees up resources
 func (rdb *RDB) Close() error {
 	var err error
-	// flush is not implemented when open as secondary
-	if !rdb.secondary {
+	// flush is not implemented when open as secondary or read-only
+	if !rdb.secondary && !rdb.readOnly {
 		waitOpts := rocksdb.NewWaitForCompactOptions()
 		waitOpts.SetFlush(true)
 		log.Printf("waiting for potential compactions to finish")
@@ -587,6 +779,49 @@ func NewContext() *Context {
 // Reset prepares the context for a new look-up cycle.
 // May be called at the start or at the end of the cycle, upon the caller's discretion
 func (ctx *Context) Reset() {
+	ctx.SetDeadline(time.Time{})
+}
+
+// SetDeadline makes the reads of ctx fail with ErrDeadlineExceeded once
+// deadline has passed, so that a slow disk or a compaction stall fails the
+// lookup instead of holding it. RocksDB aborts the point lookups running
+// past the deadline; iterator seeks are only refused once it has passed.
+// The zero time removes the deadline.
+func (ctx *Context) SetDeadline(deadline time.Time) {
+	ctx.deadline = deadline
+	if deadline.IsZero() {
+		if ctx.readOptions != nil {
+			ctx.readOptions.FreeReadOptions()
+			ctx.readOptions = nil
+		}
+		return
+	}
+	if ctx.readOptions == nil {
+		ctx.readOptions = rocksdb.NewDefaultReadOptions()
+	}
+	ctx.readOptions.SetDeadline(deadline)
+	// bound single file reads too, some of them are not checked against the deadline
+	ctx.readOptions.SetIOTimeout(max(time.Until(deadline), time.Microsecond))
+}
+
+// readOptionsFor returns the read options of the reads made for ctx, or
+// ErrDeadlineExceeded if its deadline has passed
+func (rdb *RDB) readOptionsFor(ctx *Context) (*rocksdb.ReadOptions, error) {
+	if ctx.deadline.IsZero() {
+		return rdb.readOptions, nil
+	}
+	if !time.Now().Before(ctx.deadline) {
+		return nil, ErrDeadlineExceeded
+	}
+	return ctx.readOptions, nil
+}
+
+// readError tells reads timed out by RocksDB apart, as ErrDeadlineExceeded
+func (ctx *Context) readError(err error) error {
+	if err == nil || ctx.deadline.IsZero() || time.Now().Before(ctx.deadline) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrDeadlineExceeded, err)
 }
 
 // Find returns the first data value for the given key as a byte slice.
@@ -628,8 +863,22 @@ func ReadNextChunk(data []byte) (chunk []byte, leftover []byte, err error) {
 // return the first value of key B. The order of keys on the input DOES matter. Will return
 // nil/no error if nothing was found. If key has more than one value - will return the first one anyway.
 func (rdb *RDB) FindFirst(keys [][]byte) ([]byte, int, error) {
+	return rdb.findFirst(keys, rdb.readOptions)
+}
+
+// FindFirstWithDeadline is FindFirst with the deadline of ctx; its cache is not used.
+func (rdb *RDB) FindFirstWithDeadline(keys [][]byte, ctx *Context) ([]byte, int, error) {
+	readOptions, err := rdb.readOptionsFor(ctx)
+	if err != nil {
+		return nil, -1, err
+	}
+	v, i, err := rdb.findFirst(keys, readOptions)
+	return v, i, ctx.readError(err)
+}
+
+func (rdb *RDB) findFirst(keys [][]byte, readOptions *rocksdb.ReadOptions) ([]byte, int, error) {
 	// 4 is the length of 64-bit value in bytes. Should we declare this as a constant? That is a great debate! (TM)
-	vals, errs := rdb.db.GetMulti(rdb.readOptions, keys)
+	vals, errs := rdb.db.GetMulti(readOptions, keys)
 	for i, val := range vals {
 		if errs[i] != nil {
 			return nil, -1, errs[i]
@@ -653,19 +902,37 @@ func (rdb *RDB) FindFirst(keys [][]byte) ([]byte, int, error) {
 // or for the largest key preceding the requested key. For instance, if key {1, 2, 3, 4} is requested, but
 // such a key does not exist - it will return existing key {1, 2, 3, 3}.
 func (rdb *RDB) FindClosest(key []byte, ctx *Context) ([]byte, []byte, error) {
+	return rdb.findClosest(key, 0, ctx)
+}
+
+// FindClosestWithPrefix is like FindClosest, but only considers keys starting with the first
+// prefixLen bytes of the key, and returns nil key and value if there is none.
+// The prefix is used as the iterator lower bound, so that the seek does not step into unrelated data.
+func (rdb *RDB) FindClosestWithPrefix(key []byte, prefixLen int, ctx *Context) ([]byte, []byte, error) {
+	return rdb.findClosest(key, prefixLen, ctx)
+}
+
+func (rdb *RDB) findClosest(key []byte, prefixLen int, ctx *Context) ([]byte, []byte, error) {
+	lowerBound := key[:prefixLen]
 	cachedEntry, ok := ctx.cache[string(key)]
 
-	if ok {
+	if ok && bytes.HasPrefix(cachedEntry.key, lowerBound) {
 		return cachedEntry.key, cachedEntry.data, nil
 	}
 
+	// pooled iterators keep their own read options, only the deadline check applies
+	if _, err := rdb.readOptionsFor(ctx); err != nil {
+		return nil, nil, err
+	}
+
 	iterEntry := rdb.iteratorPool.get()
 	iter := iterEntry.iterator
 	defer func() { rdb.iteratorPool.put(iterEntry) }()
 
+	iterEntry.readOptions.SetIterateLowerBound(lowerBound)
 	iter.SeekForPrev(key)
 	if !iter.IsValid() {
-		return nil, nil, iter.GetError()
+		return nil, nil, ctx.readError(iter.GetError())
 	}
 	k, v := iter.Key(), iter.Value()
 
@@ -709,16 +976,42 @@ func (rdb *RDB) ForEach(key []byte, f func(value []byte) error, ctx *Context) (e
 	return err
 }
 
+// ForEachKeyWithPrefix calls f, in key order, with every key starting with
+// prefix, every key if it is empty, and its raw (multi-value) data.
+// If f returns an error, the iteration stops and the error is returned.
+func (rdb *RDB) ForEachKeyWithPrefix(prefix []byte, f func(key, data []byte) error) error {
+	readOptions := rocksdb.NewDefaultReadOptions()
+	defer readOptions.FreeReadOptions()
+	readOptions.SetIterateLowerBound(prefix)
+	readOptions.SetIterateUpperBound(prefixEnd(prefix))
+
+	iter := rdb.db.CreateIterator(readOptions)
+	defer iter.FreeIterator()
+
+	for iter.Seek(prefix); iter.IsValid(); iter.Next() {
+		k := iter.Key()
+		if !bytes.HasPrefix(k, prefix) {
+			break
+		}
+		if err := f(k, iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.GetError()
+}
+
 // IsV2KeySyntaxUsed returns value indicating whether v2 syntax is used for DB keys
 func (rdb *RDB) IsV2KeySyntaxUsed() bool {
+	return rdb.features()&dnsdata.V2KeysFeature > 0
+}
+
+// features returns the features stored in the DB, none if missing
+func (rdb *RDB) features() dnsdata.Feature {
 	value, err := rdb.Find([]byte(dnsdata.FeaturesKey), NewContext())
-	if err != nil {
-		return false
+	if err != nil || len(value) != 4 {
+		return 0
 	}
-
-	feature := dnsdata.DecodeFeatures(value)
-
-	return feature&dnsdata.V2KeysFeature > 0
+	return dnsdata.DecodeFeatures(value)
 }
 
 func (rdb *RDB) get(key []byte, ctx *Context) (data []byte, err error) {
@@ -727,10 +1020,14 @@ func (rdb *RDB) get(key []byte, ctx *Context) (data []byte, err error) {
 	if ok {
 		data = cachedEntry.data
 	} else {
-		data, err = rdb.db.Get(rdb.readOptions, key)
-		if err != nil {
+		var readOptions *rocksdb.ReadOptions
+		if readOptions, err = rdb.readOptionsFor(ctx); err != nil {
 			return nil, err
 		}
+		data, err = rdb.db.Get(readOptions, key)
+		if err != nil {
+			return nil, ctx.readError(err)
+		}
 		ctx.update(key, key, data)
 	}
 
@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -18,6 +20,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"slices"
 	"sync"
@@ -39,12 +42,20 @@ const minBucketSize = 30000
 // full Bloom filter bits
 const fullBloomFilterBits = 10
 
+// size after which the SST files written from spilled runs are split
+const spillSSTFileSize = 256 << 20
+
 type bucket struct {
 	startOffset, endOffset int
 }
 
+// keyOrder orders records by key, then value, so the values of a key are
+// stored in the same order whatever the order they were parsed in
 func keyOrder(a, b *dnsdata.MapRecord) int {
-	return bytes.Compare(a.Key, b.Key)
+	if c := bytes.Compare(a.Key, b.Key); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.Value, b.Value)
 }
 
 // Builder is specifically optimized for building a database from scratch.
@@ -59,6 +70,12 @@ type Builder struct {
 	valueBuckets [][]*dnsdata.MapRecord
 	path         string
 	useHardlinks bool
+	// spilling to disk, see EnableSpill
+	spillDir    string
+	memoryLimit int64
+	buffered    int64
+	runs        []string
+	err         error
 }
 
 // NewBuilder creates a new instance of Builder
@@ -99,6 +116,30 @@ func NewBuilder(path string, useHardlinks bool) (*Builder, error) {
 func (b *Builder) FreeBuilder() {
 	b.writeOptions.FreeWriteOptions()
 	b.db.CloseDatabase()
+	if b.spillDir != "" {
+		if err := os.RemoveAll(b.spillDir); err != nil {
+			log.Printf("error removing %s: %v", b.spillDir, err)
+		}
+	}
+}
+
+// EnableSpill makes the builder write the records scheduled so far to a
+// sorted run on disk whenever they take more than memoryLimit bytes in memory,
+// and merge the runs when executing, so that the dataset doesn't have to fit
+// in memory. Runs are written to a temporary directory created in dir, or in
+// the default directory for temporary files if dir is empty, and removed by
+// FreeBuilder.
+func (b *Builder) EnableSpill(dir string, memoryLimit int64) error {
+	if memoryLimit <= 0 {
+		return fmt.Errorf("bad memory limit %d", memoryLimit)
+	}
+	spillDir, err := os.MkdirTemp(dir, "rdb-spill-")
+	if err != nil {
+		return fmt.Errorf("error creating spill directory: %w", err)
+	}
+	b.spillDir = spillDir
+	b.memoryLimit = memoryLimit
+	return nil
 }
 
 // ScheduleAdd schedules addition of a multi-value pair of key and value
@@ -110,6 +151,32 @@ func (b *Builder) ScheduleAdd(d dnsdata.MapRecord) {
 	index := int(hash % uint32(len(b.valueBuckets)))
 	bucket := b.valueBuckets[index]
 	b.valueBuckets[index] = append(bucket, &d)
+	if b.memoryLimit == 0 || b.err != nil {
+		return
+	}
+	b.buffered += recordSize(&d)
+	if b.buffered >= b.memoryLimit {
+		// reported by Execute
+		b.err = b.spill()
+	}
+}
+
+// spill sorts the records scheduled since the last spill and writes them to
+// a new run
+func (b *Builder) spill() error {
+	numBuckets := len(b.valueBuckets)
+	b.sortDataset()
+	b.mergeValueBuckets()
+	path := filepath.Join(b.spillDir, fmt.Sprintf("run%d", len(b.runs)))
+	log.Println("Spilling", len(b.values), "values into", path)
+	if err := writeSpillRun(path, b.values); err != nil {
+		return fmt.Errorf("error spilling values to %s: %w", path, err)
+	}
+	b.runs = append(b.runs, path)
+	b.values = nil
+	b.valueBuckets = make([][]*dnsdata.MapRecord, numBuckets)
+	b.buffered = 0
+	return nil
 }
 
 // sort all values in binary order
@@ -130,7 +197,8 @@ func (b *Builder) sortDataset() {
 // This is a lot faster than just allowing RocksDB compaction to do it for us, becase we can do it parallel without touching anything on disk.
 func (b *Builder) mergeValueBuckets() {
 	log.Println("Merging value buckets ...")
-	for {
+	// a single bucket, as on single CPU hosts, is already merged
+	for len(b.valueBuckets) > 1 {
 		result := make([][]*dnsdata.MapRecord, len(b.valueBuckets)/2)
 		var wg sync.WaitGroup
 		for i := 0; i <= len(b.valueBuckets)-2; {
@@ -143,14 +211,11 @@ func (b *Builder) mergeValueBuckets() {
 		}
 		wg.Wait()
 		// don't forget about the last bucket when we have odd number of buckets to merge
-		if len(b.valueBuckets) > 1 && len(b.valueBuckets)%2 == 1 {
+		if len(b.valueBuckets)%2 == 1 {
 			result = append(result, b.valueBuckets[len(b.valueBuckets)-1])
 		}
 		log.Printf("Merged %d buckets into %d", len(b.valueBuckets), len(result))
 		b.valueBuckets = result
-		if len(b.valueBuckets) == 1 {
-			break
-		}
 	}
 	b.values = b.valueBuckets[0]
 }
@@ -277,6 +342,88 @@ func (b *Builder) saveBuckets() ([]string, error) {
 	return sstFilePaths, nil
 }
 
+// saveRuns merges the spilled runs into SST files for ingestion later. A new
+// file is started every fileSize bytes, between keys, so that files don't
+// overlap.
+func (b *Builder) saveRuns(fileSize uint64) (sstFilePaths []string, err error) {
+	startTime := time.Now()
+	var (
+		writer      *rocksdb.SSTFileWriter
+		filePath    string
+		prevKey     []byte
+		accumulator = make([]byte, 0, 1024)
+		keyCount    int
+		totalSize   uint64
+	)
+	defer func() {
+		if writer != nil {
+			writer.CloseWriter()
+		}
+	}()
+	finish := func() error {
+		totalSize += writer.GetFileSize()
+		if err := writer.Finish(); err != nil {
+			return fmt.Errorf("error finishing writer to %s - %w", filePath, err)
+		}
+		writer.CloseWriter()
+		writer = nil
+		return nil
+	}
+	put := func() error {
+		if err := writer.Put(prevKey, accumulator); err != nil {
+			return fmt.Errorf("error writing to %s - %w", filePath, err)
+		}
+		accumulator = accumulator[0:0]
+		keyCount++
+		return nil
+	}
+	log.Println("Merging", len(b.runs), "spilled runs ...")
+	err = mergeSpillRuns(b.runs, func(item *dnsdata.MapRecord) error {
+		if prevKey != nil && !bytes.Equal(item.Key, prevKey) {
+			if err := put(); err != nil {
+				return err
+			}
+			if writer.GetFileSize() >= fileSize {
+				if err := finish(); err != nil {
+					return err
+				}
+			}
+		}
+		if writer == nil {
+			filePath = fmt.Sprintf(templateSSTFileName, b.path, len(sstFilePaths))
+			log.Println("saving values into", filePath)
+			var err error
+			if writer, err = rocksdb.CreateSSTFileWriter(filePath); err != nil {
+				return fmt.Errorf("error creating writer to %s - %w", filePath, err)
+			}
+			sstFilePaths = append(sstFilePaths, filePath)
+		}
+		accumulator = appendValues(accumulator, item.Value)
+		prevKey = item.Key
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if writer == nil {
+		return nil, fmt.Errorf("Assertion failed: spilled runs are empty")
+	}
+	// flush
+	if err := put(); err != nil {
+		return nil, err
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	elapsed := float64(time.Since(startTime)/time.Millisecond) / 1000.0
+	log.Printf(
+		"%d files saved, %d keys in %.3f seconds, %.2f keys per second, %.1f MiB total",
+		len(sstFilePaths), keyCount, elapsed,
+		float64(keyCount)/elapsed, float64(totalSize)/(1024.0*1024.0),
+	)
+	return sstFilePaths, nil
+}
+
 func (b *Builder) ingestFiles(sstFilePaths []string) error {
 	if err := b.db.IngestSSTFiles(sstFilePaths, b.useHardlinks); err != nil {
 		return fmt.Errorf("error ingesting files: %w", err)
@@ -292,13 +439,27 @@ func (b *Builder) ingestFiles(sstFilePaths []string) error {
 	return nil
 }
 
-// Execute builds the database from accumulated dataset
+// Execute builds the database from accumulated dataset, spilled runs
+// included
 func (b *Builder) Execute() error {
-	b.sortDataset()
-	b.mergeValueBuckets()
-	b.createWriteBuckets(minBucketSize, runtime.NumCPU())
-
-	sstFilePaths, err := b.saveBuckets()
+	if b.err != nil {
+		return b.err
+	}
+	var sstFilePaths []string
+	var err error
+	if len(b.runs) > 0 {
+		if b.buffered > 0 {
+			if err = b.spill(); err != nil {
+				return err
+			}
+		}
+		sstFilePaths, err = b.saveRuns(spillSSTFileSize)
+	} else {
+		b.sortDataset()
+		b.mergeValueBuckets()
+		b.createWriteBuckets(minBucketSize, runtime.NumCPU())
+		sstFilePaths, err = b.saveBuckets()
+	}
 	if err != nil {
 		return err
 	}
@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -14,6 +16,9 @@ limitations under the License.
 package rdb
 
 import (
+	"bytes"
+	"fmt"
+	"os"
 	"slices"
 	"testing"
 
@@ -188,3 +193,109 @@ func TestScheduleAdd(t *testing.T) {
 	require.Equal(t, 0, len(b.valueBuckets[1]))
 	require.Equal(t, 1, len(b.valueBuckets[2]))
 }
+
+func TestBuilderValueOrder(t *testing.T) {
+	records := []dnsdata.MapRecord{
+		{Key: []byte{1}, Value: []byte{3}},
+		{Key: []byte{2}, Value: []byte{1}},
+		{Key: []byte{1}, Value: []byte{1}},
+		{Key: []byte{1}, Value: []byte{2}},
+		{Key: []byte{0}, Value: []byte{9}},
+	}
+	build := func(numBuckets int, order []int) []*dnsdata.MapRecord {
+		b := &Builder{valueBuckets: make([][]*dnsdata.MapRecord, numBuckets)}
+		for _, i := range order {
+			b.ScheduleAdd(records[i])
+		}
+		b.sortDataset()
+		b.mergeValueBuckets()
+		return b.values
+	}
+
+	expected := build(1, []int{0, 1, 2, 3, 4})
+	require.Equal(t, []byte{0}, expected[0].Key)
+	require.Equal(t, []byte{1}, expected[1].Value)
+	require.Equal(t, expected, build(3, []int{4, 3, 2, 1, 0}))
+	require.Equal(t, expected, build(5, []int{2, 4, 0, 3, 1}))
+}
+
+func TestCompileReproducible(t *testing.T) {
+	input := []byte{}
+	for i := 0; i < 1000; i++ {
+		input = append(input, []byte(fmt.Sprintf("+www.example%d.com,192.0.2.%d,3600\n", i%10, i%250))...)
+		input = append(input, []byte(fmt.Sprintf("%%%c%c,10.%d.%d.0/24,m%d\n", 'a'+i%3, 'a'+i%5, i/250, i%250, i%7))...)
+	}
+	input = append(input, []byte("Zexample0.com,ns.example0.com,hostmaster.example0.com,,,,,,\n")...)
+
+	compile := func(numCPU int, memoryLimit int64) [][]byte {
+		dir, err := os.MkdirTemp("", "rdb_test")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		_, err = Compile(bytes.NewReader(input), 1, dir, CompilationOptions{
+			NumCPU:         numCPU,
+			UseV2KeySyntax: true,
+			UseBuilder:     true,
+			// spill every few hundred records
+			BuilderMemoryLimit: memoryLimit,
+		})
+		require.NoError(t, err)
+
+		reader, err := NewReader(dir)
+		require.NoError(t, err)
+		defer reader.Close()
+		var content [][]byte
+		err = reader.ForEachKeyWithPrefix(nil, func(key, data []byte) error {
+			content = append(content, slices.Clone(key), slices.Clone(data))
+			return nil
+		})
+		require.NoError(t, err)
+		return content
+	}
+
+	expected := compile(1, 0)
+	require.NotEmpty(t, expected)
+	require.Equal(t, expected, compile(4, 0), "content depends on input only")
+	require.Equal(t, expected, compile(1, 32*1024), "content does not depend on spilling")
+	require.Equal(t, expected, compile(4, 32*1024), "content does not depend on spilling")
+}
+
+func TestBuilderSpill(t *testing.T) {
+	dir := t.TempDir()
+	builder, err := NewBuilder(dir, false)
+	require.NoError(t, err)
+	require.NoError(t, builder.EnableSpill(t.TempDir(), 1000))
+	spillDir := builder.spillDir
+
+	expected := make(map[string][]byte)
+	for i := 0; i < 300; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i%100))
+		value := []byte(fmt.Sprintf("value%03d", i))
+		// values of a key end up in different runs
+		builder.ScheduleAdd(dnsdata.MapRecord{Key: key, Value: value})
+		expected[string(key)] = appendValues(expected[string(key)], value)
+	}
+	require.NoError(t, builder.err)
+	require.Greater(t, len(builder.runs), 2)
+	require.NoError(t, builder.spill())
+
+	// tiny files, to check they don't overlap
+	sstFilePaths, err := builder.saveRuns(1)
+	require.NoError(t, err)
+	require.Len(t, sstFilePaths, 100)
+	require.NoError(t, builder.ingestFiles(sstFilePaths))
+
+	builder.FreeBuilder()
+	_, err = os.Stat(spillDir)
+	require.True(t, os.IsNotExist(err), "spilled runs are removed")
+
+	reader, err := NewReader(dir)
+	require.NoError(t, err)
+	defer reader.Close()
+	content := make(map[string][]byte)
+	err = reader.ForEachKeyWithPrefix(nil, func(key, data []byte) error {
+		content[string(key)] = slices.Clone(data)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, expected, content)
+}
@@ -0,0 +1,99 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdb
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// catchUp tracks how far behind its primary a secondary is
+type catchUp struct {
+	running sync.Mutex // held for the whole duration of a catch up
+
+	l            sync.Mutex // guards the fields below
+	maxStaleness time.Duration
+	lastSuccess  time.Time
+	sequence     uint64 // latest sequence number seen
+	lag          uint64 // sequence numbers applied by the last successful catch up
+	failures     int64
+	stale        bool
+}
+
+func newCatchUp(sequence uint64, maxStaleness time.Duration) *catchUp {
+	return &catchUp{
+		maxStaleness: maxStaleness,
+		// opening a secondary catches up with the primary
+		lastSuccess: time.Now(),
+		sequence:    sequence,
+	}
+}
+
+// record updates the state with the outcome of a catch up
+func (c *catchUp) record(sequence uint64, err error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if err != nil {
+		c.failures++
+		return
+	}
+	c.lastSuccess = time.Now()
+	c.lag = 0
+	if sequence > c.sequence {
+		c.lag = sequence - c.sequence
+	}
+	c.sequence = sequence
+}
+
+// checkStaleness tells whether the last successful catch up is older than
+// maxStaleness, logging when that changes
+func (c *catchUp) checkStaleness(now time.Time) bool {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.maxStaleness <= 0 {
+		return false
+	}
+	staleness := now.Sub(c.lastSuccess)
+	stale := staleness > c.maxStaleness
+	if stale && !c.stale {
+		log.Printf("secondary has not caught up with primary for %v, more than %v", staleness, c.maxStaleness)
+	} else if !stale && c.stale {
+		log.Printf("secondary caught up with primary again")
+	}
+	c.stale = stale
+	return stale
+}
+
+// stats reports the catch up state
+func (c *catchUp) stats(now time.Time) map[string]int64 {
+	stale := c.checkStaleness(now)
+	c.l.Lock()
+	defer c.l.Unlock()
+	stats := map[string]int64{
+		"rocksdb.catchup.sequence":     int64(c.sequence), //nolint:gosec
+		"rocksdb.catchup.lag.seqs":     int64(c.lag),      //nolint:gosec
+		"rocksdb.catchup.staleness.ms": now.Sub(c.lastSuccess).Milliseconds(),
+		"rocksdb.catchup.failures":     c.failures,
+	}
+	if c.maxStaleness > 0 {
+		stats["rocksdb.catchup.stale"] = 0
+		if stale {
+			stats["rocksdb.catchup.stale"] = 1
+		}
+	}
+	return stats
+}
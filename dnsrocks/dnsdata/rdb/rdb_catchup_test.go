@@ -0,0 +1,52 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatchUpStats(t *testing.T) {
+	c := newCatchUp(10, time.Minute)
+	now := c.lastSuccess
+
+	stats := c.stats(now.Add(time.Second))
+	require.Equal(t, int64(10), stats["rocksdb.catchup.sequence"])
+	require.Equal(t, int64(0), stats["rocksdb.catchup.lag.seqs"])
+	require.Equal(t, int64(1000), stats["rocksdb.catchup.staleness.ms"])
+	require.Equal(t, int64(0), stats["rocksdb.catchup.stale"])
+
+	c.record(0, errors.New("catch up failed"))
+	stats = c.stats(now.Add(2 * time.Minute))
+	require.Equal(t, int64(1), stats["rocksdb.catchup.failures"])
+	require.Equal(t, int64(1), stats["rocksdb.catchup.stale"])
+	require.Equal(t, int64(10), stats["rocksdb.catchup.sequence"])
+
+	c.record(25, nil)
+	stats = c.stats(time.Now())
+	require.Equal(t, int64(25), stats["rocksdb.catchup.sequence"])
+	require.Equal(t, int64(15), stats["rocksdb.catchup.lag.seqs"])
+	require.Equal(t, int64(0), stats["rocksdb.catchup.stale"])
+}
+
+func TestCatchUpStatsWithoutMaxStaleness(t *testing.T) {
+	c := newCatchUp(0, 0)
+	require.NotContains(t, c.stats(time.Now().Add(time.Hour)), "rocksdb.catchup.stale")
+}
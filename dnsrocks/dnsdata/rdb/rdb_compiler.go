@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -14,6 +16,7 @@ limitations under the License.
 package rdb
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"io"
 	"log"
@@ -49,9 +52,43 @@ func CleanRDBDir(rdbDir string) error {
 type CompilationOptions struct {
 	NumCPU         int  // Parser and builder parallelism
 	UseV2KeySyntax bool // specifies whether v2 keys syntax should be used
+	StrictNames    bool // reject owner names with bad length, charset or punycode
+	ConvertIDN     bool // convert U-labels in owner names to A-labels
+	// EmptyNonTerminals makes queries for the empty non-terminals of zones
+	// answered with NODATA rather than NXDOMAIN
+	EmptyNonTerminals bool
+	// ReverseZones are the reverse zones whose PTR records are generated from
+	// the A and AAAA records of forward zones
+	ReverseZones []dnsdata.ReverseZone
+	// HTTPSZones are the zones whose names get HTTPS records synthesized
+	// from their A and AAAA records
+	HTTPSZones []dnsdata.HTTPSZone
+	// Serial, if set, is the SOA serial of records that do not set one, instead
+	// of the one derived from the modification time of the input, so that the
+	// output only depends on the input data
+	Serial uint32
+	// Duplicates is what to do with records written more than once in the input
+	Duplicates dnsdata.DuplicatePolicy
+	// StrictLocations fails on subnets mapped to different locations in the
+	// same map, rather than the highest location ID winning
+	StrictLocations bool
+	// Version, if set, is stored with the serial under dnsdata.ProvenanceKey
+	Version string
+	// Checksum stores the checksum of the DB under dnsdata.ChecksumKey
+	Checksum bool
+	// SigningKey, if set, signs the checksum, which is then stored whatever
+	// Checksum
+	SigningKey ed25519.PrivateKey
 	// builder-related settings
 	UseBuilder          bool // if we use RDB builder (mem hungry, fastest) or not
 	BuilderUseHardlinks bool // if RDB builder can use hardlinks instead of copying sst files
+	// BuilderMemoryLimit, if set, makes the builder spill the records parsed
+	// to sorted runs on disk whenever they take that many bytes in memory, so
+	// that datasets larger than memory can be built, see Builder.EnableSpill
+	BuilderMemoryLimit int64
+	// SpillDir is where the builder spills records, the default directory for
+	// temporary files if empty
+	SpillDir string
 	// batch-related settings
 	BatchNumParallel int // When not using builder, how many batches can we backlog while parsing, affects mem consumption
 	BatchSize        int // When not using builder, ize of RDB batches
@@ -66,6 +103,11 @@ func compileBuilder(in io.Reader, codec *dnsdata.Codec, destPath string, opts Co
 		return 0, fmt.Errorf("error opening database at %s: %w", destPath, err)
 	}
 	defer builder.FreeBuilder()
+	if opts.BuilderMemoryLimit > 0 {
+		if err = builder.EnableSpill(opts.SpillDir, opts.BuilderMemoryLimit); err != nil {
+			return 0, err
+		}
+	}
 
 	log.Println("Reading ...")
 	// Scan
@@ -184,15 +226,40 @@ func compileBatches(in io.Reader, codec *dnsdata.Codec, destPath string, opts Co
 }
 
 // Compile data from io.Reader into RDB database at destPath.
+// With the builder, the content of the database only depends on the input and
+// the options other than NumCPU; batches executed in parallel may store the
+// values of a key in any order.
 // useHardlinks allows to use hardlinks in Builder mode. Not supported by fbcode filesystem.
 func Compile(in io.Reader, serial uint32, destPath string, opts CompilationOptions) (int, error) {
 	codec := initCodec(serial)
 	codec.Features.UseV2Keys = opts.UseV2KeySyntax
+	codec.StrictNames = opts.StrictNames
+	codec.ConvertIDN = opts.ConvertIDN
+	codec.EmptyNonTerminals = opts.EmptyNonTerminals
+	codec.ReverseZones = opts.ReverseZones
+	codec.HTTPSZones = opts.HTTPSZones
+	codec.Acc.Ranger.Strict = opts.StrictLocations
 
+	compile := compileBatches
 	if opts.UseBuilder {
-		return compileBuilder(in, codec, destPath, opts)
+		compile = compileBuilder
+	}
+	nw, err := compile(in, codec, destPath, opts)
+	if err != nil {
+		return nw, err
+	}
+	if opts.Version != "" {
+		if err := WriteProvenance(destPath, &dnsdata.Provenance{Version: opts.Version, Serial: serial}); err != nil {
+			return nw, err
+		}
 	}
-	return compileBatches(in, codec, destPath, opts)
+	if !(opts.Checksum || opts.SigningKey != nil) {
+		return nw, nil
+	}
+	if _, err := WriteChecksum(destPath, opts.SigningKey); err != nil {
+		return nw, err
+	}
+	return nw, nil
 }
 
 // CompileToRDB compiles inputFileName into RDB database at destPath.
@@ -205,17 +272,24 @@ func CompileToRDB(inputFileName, destPath string, o CompilationOptions) (int, er
 // useHardlinks allows to use hardlinks in Builder mode. Not supported by fbcode filesystem.
 // useV2KeySyntax specifies whether v2 keys syntax should be used
 func CompileToSpecificRDBVersion(inputFileName, destPath string, o CompilationOptions) (int, error) {
-	// Open infile for read
-	ifile, err := os.Open(inputFileName)
+	return CompileInputsToRDB([]string{inputFileName}, destPath, o)
+}
+
+// CompileInputsToRDB compiles the data files and directories of inputs, in
+// order, into RDB database at destPath, see dnsdata.Input
+func CompileInputsToRDB(inputs []string, destPath string, o CompilationOptions) (int, error) {
+	in, err := dnsdata.NewInput(inputs, o.Duplicates)
 	if err != nil {
-		return 0, fmt.Errorf("error opening input file %s: %w", inputFileName, err)
+		return 0, fmt.Errorf("error opening input: %w", err)
 	}
-	defer ifile.Close()
-	serial, err := dnsdata.DeriveSerial(ifile)
-	if err != nil {
-		return 0, fmt.Errorf("error accessing input file %s: %w", inputFileName, err)
+	defer in.Close()
+	serial := o.Serial
+	if serial == 0 {
+		if serial, err = dnsdata.DeriveInputSerial(inputs); err != nil {
+			return 0, fmt.Errorf("error accessing input: %w", err)
+		}
 	}
-	return Compile(ifile, serial, destPath, o)
+	return Compile(in, serial, destPath, o)
 }
 
 func initCodec(serial uint32) *dnsdata.Codec {
@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -25,17 +27,21 @@ const NumberOfIterators int = 15
 // IteratorPool allows RDB iterators reuse. Iterator creation is happen to be pretty costly operation
 type IteratorPool struct {
 	iterators      chan iteratorPoolEntry
-	enabled        bool
-	createIterator func() *rocksdb.Iterator
-	l              sync.Mutex
+	enabled        bool // guarded by l
+	createIterator func(readOptions *rocksdb.ReadOptions) *rocksdb.Iterator
+	// l is held for reading while taking an iterator, so that disable waits
+	// for gets which saw the pool enabled to take theirs before draining it
+	l sync.RWMutex
 }
 
 type iteratorPoolEntry struct {
 	iterator *rocksdb.Iterator
-	free     bool // if true - iterator is not taken from pool and should be destroyed on release
+	// readOptions the iterator was created with; its bounds can be changed before seeking
+	readOptions *rocksdb.ReadOptions
+	free        bool // if true - iterator is not taken from pool and should be destroyed on release
 }
 
-func newIteratorPool(createIterator func() *rocksdb.Iterator) *IteratorPool {
+func newIteratorPool(createIterator func(readOptions *rocksdb.ReadOptions) *rocksdb.Iterator) *IteratorPool {
 	pool := new(IteratorPool)
 	pool.iterators = make(chan iteratorPoolEntry, NumberOfIterators)
 	pool.createIterator = createIterator
@@ -43,9 +49,28 @@ func newIteratorPool(createIterator func() *rocksdb.Iterator) *IteratorPool {
 	return pool
 }
 
+func (pool *IteratorPool) newEntry(free bool) iteratorPoolEntry {
+	readOptions := rocksdb.NewDefaultReadOptions()
+	// iterators only refer to bounds set before their creation,
+	// an empty one allows changing it later
+	readOptions.SetIterateLowerBound([]byte{})
+	return iteratorPoolEntry{
+		iterator:    pool.createIterator(readOptions),
+		readOptions: readOptions,
+		free:        free,
+	}
+}
+
+func (e iteratorPoolEntry) destroy() {
+	e.iterator.FreeIterator()
+	e.readOptions.FreeReadOptions()
+}
+
 func (pool *IteratorPool) get() iteratorPoolEntry {
+	pool.l.RLock()
+	defer pool.l.RUnlock()
 	if !pool.enabled {
-		return iteratorPoolEntry{iterator: pool.createIterator(), free: true}
+		return pool.newEntry(true)
 	}
 
 	return <-pool.iterators
@@ -53,7 +78,7 @@ func (pool *IteratorPool) get() iteratorPoolEntry {
 
 func (pool *IteratorPool) put(e iteratorPoolEntry) {
 	if e.free {
-		e.iterator.FreeIterator()
+		e.destroy()
 	} else {
 		pool.iterators <- e
 	}
@@ -72,7 +97,7 @@ func (pool *IteratorPool) disable() {
 
 	for i := 0; i < NumberOfIterators; i++ {
 		e := <-pool.iterators
-		e.iterator.FreeIterator()
+		e.destroy()
 	}
 }
 
@@ -85,9 +110,7 @@ func (pool *IteratorPool) enable() {
 	}
 
 	for i := 0; i < NumberOfIterators; i++ {
-		entry := iteratorPoolEntry{iterator: pool.createIterator()}
-
-		pool.iterators <- entry
+		pool.iterators <- pool.newEntry(false)
 	}
 
 	pool.enabled = true
@@ -0,0 +1,178 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdb
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// spillRecordOverhead approximates the memory a scheduled record takes on top
+// of its key and value: the MapRecord, the pointer to it and slice growth
+const spillRecordOverhead = 64
+
+// spillBufferSize is the size of the buffers of spilled run files
+const spillBufferSize = 1 << 20
+
+// recordSize returns the approximate memory a scheduled record takes
+func recordSize(m *dnsdata.MapRecord) int64 {
+	return int64(len(m.Key) + len(m.Value) + spillRecordOverhead)
+}
+
+// writeSpillRun writes records, sorted, to a new file at path, each one as
+// the uvarint lengths of its key and value followed by both
+func writeSpillRun(path string, records []*dnsdata.MapRecord) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	w := bufio.NewWriterSize(f, spillBufferSize)
+	var head [2 * binary.MaxVarintLen64]byte
+	for _, m := range records {
+		n := binary.PutUvarint(head[:], uint64(len(m.Key)))
+		n += binary.PutUvarint(head[n:], uint64(len(m.Value)))
+		if _, err := w.Write(head[:n]); err != nil {
+			return err
+		}
+		if _, err := w.Write(m.Key); err != nil {
+			return err
+		}
+		if _, err := w.Write(m.Value); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// spillRun reads back a run written by writeSpillRun
+type spillRun struct {
+	f      *os.File
+	r      *bufio.Reader
+	record dnsdata.MapRecord
+}
+
+func openSpillRun(path string) (*spillRun, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &spillRun{f: f, r: bufio.NewReaderSize(f, spillBufferSize)}, nil
+}
+
+// next reads the next record of the run into s.record, returning io.EOF at
+// the end of the run
+func (s *spillRun) next() error {
+	keyLen, err := binary.ReadUvarint(s.r)
+	if err != nil {
+		return err
+	}
+	valueLen, err := binary.ReadUvarint(s.r)
+	if err != nil {
+		return truncatedRun(err)
+	}
+	// records are handed over to the SST writers, which copy them
+	buf := make([]byte, keyLen+valueLen)
+	if _, err := io.ReadFull(s.r, buf); err != nil {
+		return truncatedRun(err)
+	}
+	s.record = dnsdata.MapRecord{Key: buf[:keyLen], Value: buf[keyLen:]}
+	return nil
+}
+
+func (s *spillRun) close() error {
+	return s.f.Close()
+}
+
+func truncatedRun(err error) error {
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("truncated spilled run: %w", io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// spillMerger merges spilled runs in the order of keyOrder
+type spillMerger []*spillRun
+
+func (m spillMerger) Len() int { return len(m) }
+
+func (m spillMerger) Less(i, j int) bool {
+	return keyOrder(&m[i].record, &m[j].record) < 0
+}
+
+func (m spillMerger) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+
+func (m *spillMerger) Push(x any) { *m = append(*m, x.(*spillRun)) }
+
+func (m *spillMerger) Pop() any {
+	old := *m
+	run := old[len(old)-1]
+	*m = old[:len(old)-1]
+	return run
+}
+
+// mergeSpillRuns calls f with the records of the runs at paths, in the order
+// of keyOrder
+func mergeSpillRuns(paths []string, f func(*dnsdata.MapRecord) error) (err error) {
+	m := make(spillMerger, 0, len(paths))
+	defer func() {
+		for _, run := range m {
+			run.close()
+		}
+	}()
+	for _, path := range paths {
+		run, err := openSpillRun(path)
+		if err != nil {
+			return err
+		}
+		if err := run.next(); err != nil {
+			run.close()
+			if err == io.EOF {
+				continue
+			}
+			return fmt.Errorf("error reading %s: %w", path, err)
+		}
+		m = append(m, run)
+	}
+	heap.Init(&m)
+	for len(m) > 0 {
+		run := m[0]
+		record := run.record
+		if err := f(&record); err != nil {
+			return err
+		}
+		if err := run.next(); err == io.EOF {
+			heap.Pop(&m)
+			run.close()
+		} else if err != nil {
+			return fmt.Errorf("error reading %s: %w", run.f.Name(), err)
+		} else {
+			heap.Fix(&m, 0)
+		}
+	}
+	return nil
+}
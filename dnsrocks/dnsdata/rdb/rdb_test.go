@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -15,6 +17,7 @@ package rdb
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -22,6 +25,7 @@ import (
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	rocksdb "github.com/facebook/dns/dnsrocks/cgo-rocksdb"
 
@@ -32,6 +36,7 @@ type mockedDB struct {
 	get      func(key []byte) ([]byte, error)
 	getMulti func(readOptions *rocksdb.ReadOptions, keys [][]byte) ([][]byte, []error)
 	put      func(key, value []byte) error
+	merge    func(key, value []byte) error
 	delete   func(key []byte) error
 }
 
@@ -39,6 +44,10 @@ func (mock *mockedDB) Put(_ *rocksdb.WriteOptions, key, value []byte) error {
 	return mock.put(key, value)
 }
 
+func (mock *mockedDB) Merge(_ *rocksdb.WriteOptions, key, value []byte) error {
+	return mock.merge(key, value)
+}
+
 func (mock *mockedDB) Get(_ *rocksdb.ReadOptions, key []byte) ([]byte, error) {
 	return mock.get(key)
 }
@@ -62,6 +71,10 @@ func (mock *mockedDB) CatchWithPrimary() error {
 	return nil
 }
 
+func (mock *mockedDB) GetLatestSequenceNumber() uint64 {
+	return 0
+}
+
 func (mock *mockedDB) Flush() error {
 	return nil
 }
@@ -86,6 +99,10 @@ func (mock *mockedDB) GetProperty(string) string {
 	return ""
 }
 
+func (mock *mockedDB) GetLiveFilesMetaData() []rocksdb.LiveFileMetaData {
+	return nil
+}
+
 func (mock *mockedDB) WaitForCompact(*rocksdb.WaitForCompactOptions) error {
 	return nil
 }
@@ -102,17 +119,15 @@ func TestRDBAddErrorGettingValue(t *testing.T) {
 				return nil
 			},
 		},
-		writeMutex: &sync.Mutex{},
+		writeMutex: &sync.RWMutex{},
 	}
 	err := rdb.Add([]byte{}, []byte{})
-	if err != nil {
-		require.Equal(t, err.Error(), errorMsg)
-	}
+	require.EqualError(t, err, errorMsg)
 }
 
 func TestRDBAddToNewKey(t *testing.T) {
-	// check creation of a new value for non-existing key
-	newKey := []byte{0, 1, 255, 3, 4}
+	// check addition of a new key
+	newKey := []byte{9, 3, 255, 3, 4, 5}
 	newValue := []byte{1, 2, 255, 3, 0}
 	rdb := &RDB{
 		db: &mockedDB{
@@ -125,7 +140,7 @@ func TestRDBAddToNewKey(t *testing.T) {
 				return nil
 			},
 		},
-		writeMutex: &sync.Mutex{},
+		writeMutex: &sync.RWMutex{},
 	}
 	require.Nil(t, rdb.Add(newKey, newValue))
 }
@@ -155,11 +170,63 @@ func TestRDBAddErrorAddToExistingKey(t *testing.T) {
 				return nil
 			},
 		},
-		writeMutex: &sync.Mutex{},
+		writeMutex: &sync.RWMutex{},
 	}
 	require.Nil(t, rdb.Add(newKey, newValue))
 }
 
+func TestRDBMergeAddErrorMergingValue(t *testing.T) {
+	// check that returns error
+	errorMsg := "I CAN'T MERGE NO VALUE"
+	rdb := &RDB{
+		db: &mockedDB{
+			merge: func(_, _ []byte) error {
+				return errors.New(errorMsg)
+			},
+		},
+		writeMutex: &sync.RWMutex{},
+		mergeAdds:  true,
+	}
+	err := rdb.Add([]byte{}, []byte{})
+	require.EqualError(t, err, errorMsg)
+}
+
+func TestRDBMergeAddToExistingKey(t *testing.T) {
+	// check addition of a new value to existing key
+	oldValue := []byte{5, 0, 0, 0, 7, 17, 32, 0, 15}
+	newKey := []byte{9, 3, 255, 3, 4, 5}
+	newValue := []byte{8, 2, 255, 3, 9, 8, 9}
+	var operand []byte
+	rdb := &RDB{
+		db: &mockedDB{
+			get: func(_ []byte) ([]byte, error) {
+				t.Fatal("existing value must not be read")
+				return nil, nil
+			},
+			merge: func(key, value []byte) error {
+				require.Equal(t, key, newKey)
+				operand = value
+				return nil
+			},
+		},
+		writeMutex: &sync.RWMutex{},
+		mergeAdds:  true,
+	}
+	require.Nil(t, rdb.Add(newKey, newValue))
+	merged, ok := valuesMergeOperator{}.FullMerge(newKey, oldValue, [][]byte{operand})
+	require.True(t, ok)
+	require.Equal(
+		t,
+		[]byte{
+			// oldValue
+			5, 0, 0, 0, 7, 17, 32, 0, 15,
+			// length(newValue) in unit32 + value itself
+			7, 0, 0, 0, 8, 2, 255, 3, 9, 8, 9,
+		},
+		merged,
+	)
+}
+
 func TestRDBDelErrorGettingKey(t *testing.T) {
 	// check that returns error if cannot get key
 	errorMsg := "I CAN'T GET NO VALUE"
@@ -169,7 +236,7 @@ func TestRDBDelErrorGettingKey(t *testing.T) {
 				return nil, errors.New(errorMsg)
 			},
 		},
-		writeMutex: &sync.Mutex{},
+		writeMutex: &sync.RWMutex{},
 	}
 	err := rdb.Del([]byte{}, []byte{})
 	if err != nil {
@@ -185,7 +252,7 @@ func TestRDBDelErrorMissingKey(t *testing.T) {
 				return nil, nil
 			},
 		},
-		writeMutex: &sync.Mutex{},
+		writeMutex: &sync.RWMutex{},
 	}
 	err := rdb.Del([]byte{}, []byte{})
 	require.Equal(t, err, ErrNXKey)
@@ -215,7 +282,7 @@ func TestRDBDelErrorNonExistingValue(t *testing.T) {
 				return nil
 			},
 		},
-		writeMutex: &sync.Mutex{},
+		writeMutex: &sync.RWMutex{},
 	}
 	err := rdb.Del(simpleKey, simpleVal)
 	require.Equal(t, err, ErrNXVal)
@@ -246,7 +313,7 @@ func TestRDBDelErrorTruncatedValue1(t *testing.T) {
 				return nil
 			},
 		},
-		writeMutex: &sync.Mutex{},
+		writeMutex: &sync.RWMutex{},
 	}
 	err := rdb.Del(simpleKey, simpleVal)
 	require.Equal(t, err, io.ErrUnexpectedEOF)
@@ -277,7 +344,7 @@ func TestRDBDelErrorTruncatedValue2(t *testing.T) {
 				return nil
 			},
 		},
-		writeMutex: &sync.Mutex{},
+		writeMutex: &sync.RWMutex{},
 	}
 	err := rdb.Del(simpleKey, simpleVal)
 	if err != nil {
@@ -308,7 +375,7 @@ func TestRDBDelOnlyValue(t *testing.T) {
 				return nil
 			},
 		},
-		writeMutex: &sync.Mutex{},
+		writeMutex: &sync.RWMutex{},
 	}
 	err := rdb.Del(simpleKey, simpleVal)
 	require.Nil(t, err)
@@ -372,7 +439,7 @@ func TestRDBDelWithOffset(t *testing.T) {
 					return nil
 				},
 			},
-			writeMutex: &sync.Mutex{},
+			writeMutex: &sync.RWMutex{},
 		}
 		require.Nil(t, rdb.Del(simpleKey, test.deletedValue))
 	}
@@ -570,7 +637,7 @@ func TestRDBContext(t *testing.T) {
 					return nil
 				},
 			},
-			writeMutex: &sync.Mutex{},
+			writeMutex: &sync.RWMutex{},
 		}
 		context := NewContext()
 		require.NotNil(t, context)
@@ -843,6 +910,68 @@ func TestRDBFindFirst(t *testing.T) {
 	}
 }
 
+func TestRDBContextDeadline(t *testing.T) {
+	var (
+		gets    int
+		timeout bool
+	)
+	defaultOptions := rocksdb.NewDefaultReadOptions()
+	defer defaultOptions.FreeReadOptions()
+	rdb := &RDB{
+		readOptions: defaultOptions,
+		db: &mockedDB{
+			get: func(_ []byte) ([]byte, error) {
+				gets++
+				if timeout {
+					return nil, errors.New("Operation timed out: Deadline exceeded")
+				}
+				return []byte{1, 0, 0, 0, 42}, nil
+			},
+			getMulti: func(readOptions *rocksdb.ReadOptions, keys [][]byte) ([][]byte, []error) {
+				require.NotSame(t, defaultOptions, readOptions, "reads with a deadline should not use the default options")
+				return make([][]byte, len(keys)), make([]error, len(keys))
+			},
+		},
+	}
+
+	ctx := NewContext()
+	defer ctx.Reset()
+	ctx.SetDeadline(time.Now().Add(time.Minute))
+	v, err := rdb.Find([]byte("a"), ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte{42}, v)
+	_, _, err = rdb.FindFirstWithDeadline([][]byte{[]byte("b")}, ctx)
+	require.NoError(t, err)
+
+	// failing reads before the deadline are not timeouts
+	timeout = true
+	_, err = rdb.Find([]byte("c"), ctx)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrDeadlineExceeded)
+
+	ctx.SetDeadline(time.Now().Add(-time.Second))
+	require.ErrorIs(t, ctx.readError(errors.New("Operation timed out: Deadline exceeded")), ErrDeadlineExceeded)
+
+	// past the deadline, reads are not even attempted
+	gets = 0
+	_, err = rdb.Find([]byte("d"), ctx)
+	require.ErrorIs(t, err, ErrDeadlineExceeded)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	_, _, err = rdb.FindFirstWithDeadline([][]byte{[]byte("d")}, ctx)
+	require.ErrorIs(t, err, ErrDeadlineExceeded)
+	require.Equal(t, 0, gets)
+	// cached values are still served
+	v, err = rdb.Find([]byte("a"), ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte{42}, v)
+
+	ctx.Reset()
+	require.Nil(t, ctx.readOptions)
+	timeout = false
+	_, err = rdb.Find([]byte("e"), ctx)
+	require.NoError(t, err)
+}
+
 func TestRDBBatchIntegrate(t *testing.T) {
 	type testCase struct {
 		added         kvList
@@ -1137,3 +1266,336 @@ func TestExecuteBatch(t *testing.T) {
 	require.Nil(t, err)
 	require.Nilf(t, value2, "key should be removed from DB, not just value set to []byte{}")
 }
+
+func TestExecuteBatchMergeAdds(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+
+	testdb, err := NewRDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testdb.Close()
+	testdb.mergeAdds = true
+
+	key := []byte{1, 2, 3}
+	require.NoError(t, testdb.Add(key, []byte{1}))
+
+	// batches only adding values are merged
+	b := &Batch{}
+	b.Add(key, []byte{2})
+	b.Add([]byte{4, 5, 6}, []byte{3})
+	summary, err := testdb.ExecuteBatchWithOptions(b, ApplyOptions{Verify: true, MaxSamples: -1})
+	require.NoError(t, err)
+	require.Equal(t, 2, summary.AddedValues)
+	require.Equal(t, 1, summary.NewKeys)
+	b = &Batch{}
+	b.Add(key, []byte{4})
+	require.NoError(t, testdb.ExecuteBatch(b))
+
+	data, err := testdb.db.Get(testdb.readOptions, key)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 0, 0, 0, 1, 1, 0, 0, 0, 2, 1, 0, 0, 0, 4}, data)
+
+	// the ones also deleting values read them
+	b = &Batch{}
+	b.Add(key, []byte{5})
+	b.Del(key, []byte{1})
+	require.NoError(t, testdb.ExecuteBatch(b))
+	data, err = testdb.db.Get(testdb.readOptions, key)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 0, 0, 0, 2, 1, 0, 0, 0, 4, 1, 0, 0, 0, 5}, data)
+}
+
+func TestRDBConcurrentAdd(t *testing.T) {
+	for _, mergeAdds := range []bool{false, true} {
+		t.Run(fmt.Sprintf("mergeAdds=%v", mergeAdds), func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "rdb_test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir) // clean up
+
+			testdb, err := NewRDB(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer testdb.Close()
+			testdb.mergeAdds = mergeAdds
+
+			key := []byte{1, 2, 3}
+			const writers = 8
+			const perWriter = 50
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWriter; i++ {
+						require.NoError(t, testdb.Add(key, []byte{byte(w), byte(i)}))
+					}
+				}(w)
+			}
+			wg.Wait()
+
+			data, err := testdb.db.Get(testdb.readOptions, key)
+			require.NoError(t, err)
+			seen := make(map[[2]byte]bool)
+			for len(data) > 0 {
+				require.GreaterOrEqual(t, len(data), 6)
+				require.Equal(t, []byte{2, 0, 0, 0}, data[:4])
+				seen[[2]byte{data[4], data[5]}] = true
+				data = data[6:]
+			}
+			require.Len(t, seen, writers*perWriter)
+
+			// added values can be deleted, merged or not
+			require.NoError(t, testdb.Del(key, []byte{0, 0}))
+			data, err = testdb.db.Get(testdb.readOptions, key)
+			require.NoError(t, err)
+			require.Len(t, data, (writers*perWriter-1)*6)
+		})
+	}
+}
+
+func TestRDBFindClosestWithPrefix(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+
+	writer, err := NewRDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][]byte{{1, 5}, {2, 3}, {2, 7}} {
+		require.NoError(t, writer.Add(key, key))
+	}
+	require.NoError(t, writer.Close())
+
+	testdb, err := NewReader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testdb.Close()
+
+	testCases := []struct {
+		key       []byte
+		prefixLen int
+		want      []byte
+	}{
+		{key: []byte{2, 8}, prefixLen: 1, want: []byte{2, 7}},
+		{key: []byte{2, 7}, prefixLen: 1, want: []byte{2, 7}},
+		{key: []byte{2, 5}, prefixLen: 1, want: []byte{2, 3}},
+		{key: []byte{2, 1}, prefixLen: 1, want: nil},
+		{key: []byte{2, 1}, prefixLen: 0, want: []byte{1, 5}},
+		{key: []byte{1, 1}, prefixLen: 0, want: nil},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v/%d", tc.key, tc.prefixLen), func(t *testing.T) {
+			k, _, err := testdb.FindClosestWithPrefix(tc.key, tc.prefixLen, NewContext())
+			require.NoError(t, err)
+			require.Equal(t, tc.want, k)
+		})
+	}
+
+	// an unbounded result for the same key, cached in the context, is not returned
+	ctx := NewContext()
+	k, _, err := testdb.FindClosest([]byte{2, 1}, ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 5}, k)
+	k, _, err = testdb.FindClosestWithPrefix([]byte{2, 1}, 1, ctx)
+	require.NoError(t, err)
+	require.Nil(t, k)
+}
+
+func TestRDBGetStats(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+
+	writer, err := NewRDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.NoError(t, writer.Add([]byte{1, 2, 3}, []byte{4, 5, 6}))
+	require.NoError(t, writer.Close())
+
+	reader, err := NewReader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	_, err = reader.Find([]byte{1, 2, 3}, NewContext())
+	require.NoError(t, err)
+
+	stats := reader.GetStats()
+	require.Contains(t, stats, "rocksdb.db.get.micros.count")
+	require.Contains(t, stats, "rocksdb.bloom.filter.useful.ratio.pct")
+	require.Contains(t, stats, "rocksdb.block.cache.hit.ratio.pct")
+	require.Positive(t, stats["rocksdb.level.0.files"])
+	require.Positive(t, stats["rocksdb.level.0.size.bytes"])
+}
+
+func TestRDBReadOnlyReader(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+
+	writer, err := NewRDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.NoError(t, writer.Add([]byte{1, 2, 3}, []byte{4, 5, 6}))
+	require.NoError(t, writer.Close())
+
+	reader, err := NewReaderWithOptions(dir, ReaderOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Empty(t, reader.logDir, "no secondary log directory expected")
+	require.Error(t, reader.CatchWithPrimary())
+
+	value, err := reader.Find([]byte{1, 2, 3}, NewContext())
+	require.NoError(t, err)
+	require.Equal(t, []byte{4, 5, 6}, value)
+	require.NoError(t, reader.Close())
+}
+
+func TestRDBCatchUpStats(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+
+	writer, err := NewRDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.NoError(t, writer.Close())
+
+	reader, err := NewReaderWithOptions(dir, ReaderOptions{MaxStaleness: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	writer, err = NewRDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.NoError(t, writer.Add([]byte{1, 2, 3}, []byte{4, 5, 6}))
+	require.NoError(t, writer.Add([]byte{1, 2, 4}, []byte{4, 5, 6}))
+	require.NoError(t, writer.Close())
+
+	require.NoError(t, reader.CatchWithPrimary())
+	value, err := reader.Find([]byte{1, 2, 4}, NewContext())
+	require.NoError(t, err)
+	require.NotNil(t, value)
+
+	stats := reader.GetStats()
+	require.Equal(t, int64(2), stats["rocksdb.catchup.sequence"])
+	require.Equal(t, int64(2), stats["rocksdb.catchup.lag.seqs"])
+	require.Zero(t, stats["rocksdb.catchup.failures"])
+	require.Zero(t, stats["rocksdb.catchup.stale"])
+	require.Less(t, stats["rocksdb.catchup.staleness.ms"], int64(time.Hour/time.Millisecond))
+}
+
+// TestRDBCatchUpDuringLookups checks that lookups can use pooled iterators
+// while catching up with the primary disables and re-enables the pool.
+func TestRDBCatchUpDuringLookups(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+
+	writer, err := NewRDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.NoError(t, writer.Add([]byte{1, 2, 3}, []byte{4, 5, 6}))
+	require.NoError(t, writer.Close())
+
+	reader, err := NewReader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 2*NumberOfIterators; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := NewContext()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				ctx.Reset()
+				key, _, err := reader.FindClosest([]byte{1, 2, 4}, ctx)
+				if err != nil || !bytes.Equal(key, []byte{1, 2, 3}) {
+					t.Errorf("FindClosest returned %v, %v", key, err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, reader.CatchWithPrimary())
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestRDBMemoryBudget(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // clean up
+
+	writer, err := NewRDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.NoError(t, writer.Add([]byte{1, 2, 3}, []byte{4, 5, 6}))
+	require.NoError(t, writer.Close())
+
+	_, err = NewMemoryBudget(0, 0)
+	require.Error(t, err)
+	_, err = NewMemoryBudget(Mb, 2*Mb)
+	require.Error(t, err)
+
+	budget, err := NewMemoryBudget(16*Mb, 4*Mb)
+	require.NoError(t, err)
+	defer budget.Free()
+
+	var readers []*RDB
+	for i := 0; i < 2; i++ {
+		reader, err := NewReaderWithOptions(dir, ReaderOptions{ReadOnly: true, MemoryBudget: budget})
+		require.NoError(t, err)
+		require.Same(t, budget.cache, reader.db.GetOptions().GetCache())
+		value, err := reader.Find([]byte{1, 2, 3}, NewContext())
+		require.NoError(t, err)
+		require.Equal(t, []byte{4, 5, 6}, value)
+		readers = append(readers, reader)
+	}
+	require.Equal(t, uint64(16*Mb), budget.cache.GetCapacity())
+	for _, reader := range readers {
+		require.NoError(t, reader.Close())
+	}
+}
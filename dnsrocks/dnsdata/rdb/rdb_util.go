@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -24,6 +26,7 @@ import (
 	"strconv"
 	"strings"
 
+	rocksdb "github.com/facebook/dns/dnsrocks/cgo-rocksdb"
 	"github.com/facebook/dns/dnsrocks/dnsdata"
 )
 
@@ -68,6 +71,60 @@ func appendValues(data []byte, newVals []byte) []byte {
 	return data
 }
 
+// prefixEnd returns the smallest key greater than all keys starting with
+// prefix, or nil if there is no such key
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// valuesMergeOperator merges values encoded by appendValues into multi-value
+// data, allowing to add values to a key without reading it first.
+//
+// Merge operands are only written by updaters opened with
+// UpdaterOptions.MergeAdds. They are stored as such until compaction folds
+// them into the values, and every read of a key with pending operands calls FullMerge
+// through cgo. Databases with pending operands can't be read by binaries
+// predating the operator: they have to be fully compacted first, e.g. with
+// dnsrocks-compactrdb.
+type valuesMergeOperator struct{}
+
+// Name implements rocksdb.MergeOperator. RocksDB records it in the database
+// options, so it must never change.
+func (valuesMergeOperator) Name() string {
+	return "dnsrocks.AppendValues"
+}
+
+// FullMerge implements rocksdb.MergeOperator
+func (valuesMergeOperator) FullMerge(_, existingValue []byte, operands [][]byte) ([]byte, bool) {
+	return concatValues(existingValue, operands), true
+}
+
+// PartialMerge implements rocksdb.MergeOperator
+func (valuesMergeOperator) PartialMerge(_ []byte, operands [][]byte) ([]byte, bool) {
+	return concatValues(nil, operands), true
+}
+
+// concatValues appends encoded multi-value operands to data
+func concatValues(data []byte, operands [][]byte) []byte {
+	n := len(data)
+	for _, o := range operands {
+		n += len(o)
+	}
+	result := make([]byte, 0, n)
+	result = append(result, data...)
+	for _, o := range operands {
+		result = append(result, o...)
+	}
+	return result
+}
+
 // delValue will delete the 'value' from a multi-value 'data', returns error
 // if the data is malformed of the value does not exist
 func delValue(data []byte, value []byte) ([]byte, error) {
@@ -125,6 +182,52 @@ func rdbStats(q string) map[string]int64 {
 	return stats
 }
 
+// statisticsHistograms are the histograms reported by statisticsStats
+var statisticsHistograms = []string{
+	"rocksdb.db.get.micros",
+	"rocksdb.db.multiget.micros",
+	"rocksdb.db.seek.micros",
+}
+
+// statisticsStats reports ratios derived from the statistics tickers, and
+// the average, count and sum of some histograms, which the statistics string
+// does not have
+func statisticsStats(statistics *rocksdb.Statistics) map[string]int64 {
+	stats := make(map[string]int64)
+	// percentage of a/(a+b), if there is any
+	ratio := func(name, a, b string) {
+		va, okA := statistics.TickerCount(a)
+		vb, okB := statistics.TickerCount(b)
+		if okA && okB && va+vb > 0 {
+			stats[name] = int64(va * 100 / (va + vb)) //nolint:gosec
+		}
+	}
+	// lookups for which the bloom filter avoided reading a file
+	ratio("rocksdb.bloom.filter.useful.ratio.pct", "rocksdb.bloom.filter.useful", "rocksdb.bloom.filter.full.positive")
+	ratio("rocksdb.block.cache.hit.ratio.pct", "rocksdb.block.cache.hit", "rocksdb.block.cache.miss")
+
+	for _, name := range statisticsHistograms {
+		h, ok := statistics.Histogram(name)
+		if !ok {
+			continue
+		}
+		stats[name+".avg"] = int64(h.Average)
+		stats[name+".count"] = int64(h.Count) //nolint:gosec
+		stats[name+".sum"] = int64(h.Sum)     //nolint:gosec
+	}
+	return stats
+}
+
+// levelStats reports the number of files and their total size per level
+func levelStats(files []rocksdb.LiveFileMetaData) map[string]int64 {
+	stats := make(map[string]int64)
+	for _, f := range files {
+		stats[fmt.Sprintf("rocksdb.level.%d.files", f.Level)]++
+		stats[fmt.Sprintf("rocksdb.level.%d.size.bytes", f.Level)] += f.Size
+	}
+	return stats
+}
+
 // merge merges two sorted lists of records
 func merge(a []*dnsdata.MapRecord, b []*dnsdata.MapRecord) []*dnsdata.MapRecord {
 	result := make([]*dnsdata.MapRecord, 0, len(a)+len(b))
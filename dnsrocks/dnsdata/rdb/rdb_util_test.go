@@ -1,3 +1,5 @@
+//go:build cgo && !norocksdb
+
 /*
 Copyright (c) Meta Platforms, Inc. and affiliates.
 Licensed under the Apache License, Version 2.0 (the "License");
@@ -19,6 +21,7 @@ import (
 	"slices"
 	"testing"
 
+	rocksdb "github.com/facebook/dns/dnsrocks/cgo-rocksdb"
 	"github.com/facebook/dns/dnsrocks/dnsdata"
 
 	"github.com/stretchr/testify/require"
@@ -281,3 +284,45 @@ func TestMerge(t *testing.T) {
 		})
 	}
 }
+
+func TestRDBvaluesMergeOperator(t *testing.T) {
+	op := valuesMergeOperator{}
+	a := appendValues(nil, []byte{1, 2})
+	b := appendValues(nil, []byte{3})
+	c := appendValues(nil, []byte{4, 5, 6})
+
+	partial, ok := op.PartialMerge(nil, [][]byte{b, c})
+	require.True(t, ok)
+	require.Equal(t, appendValues(b, []byte{4, 5, 6}), partial)
+
+	full, ok := op.FullMerge(nil, a, [][]byte{partial})
+	require.True(t, ok)
+	require.Equal(t, []byte{2, 0, 0, 0, 1, 2, 1, 0, 0, 0, 3, 3, 0, 0, 0, 4, 5, 6}, full)
+	require.Equal(t, []byte{2, 0, 0, 0, 1, 2}, a, "existing value must not be modified")
+
+	full, ok = op.FullMerge(nil, nil, [][]byte{a})
+	require.True(t, ok)
+	require.Equal(t, a, full)
+}
+
+func TestRDBprefixEnd(t *testing.T) {
+	require.Equal(t, []byte{1, 3}, prefixEnd([]byte{1, 2}))
+	require.Equal(t, []byte{2}, prefixEnd([]byte{1, 0xff}))
+	require.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+	require.Nil(t, prefixEnd(nil))
+}
+
+func TestRDBlevelStats(t *testing.T) {
+	files := []rocksdb.LiveFileMetaData{
+		{Name: "/000010.sst", Level: 0, Size: 100},
+		{Name: "/000011.sst", Level: 0, Size: 50},
+		{Name: "/000007.sst", Level: 2, Size: 1000},
+	}
+	expected := map[string]int64{
+		"rocksdb.level.0.files":      2,
+		"rocksdb.level.0.size.bytes": 150,
+		"rocksdb.level.2.files":      1,
+		"rocksdb.level.2.size.bytes": 1000,
+	}
+	require.Equal(t, expected, levelStats(files))
+}
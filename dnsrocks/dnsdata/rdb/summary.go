@@ -0,0 +1,193 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb/dbdiff"
+)
+
+// DefaultChangeSamples is the number of changes sampled in a ChangeSummary
+// when ApplyOptions doesn't set it
+const DefaultChangeSamples = 10
+
+// ApplyOptions controls how updates are applied to the database
+type ApplyOptions struct {
+	// DryRun computes the changes an update would make without writing them
+	DryRun bool
+	// Verify reads the changed keys back once written, and fails the update
+	// if they don't hold the values expected
+	Verify bool
+	// MaxSamples is the number of changes sampled in the summary,
+	// DefaultChangeSamples if 0, none if negative
+	MaxSamples int
+}
+
+// Change is a value added to or removed from a key
+type Change struct {
+	Op    dbdiff.Op
+	Key   []byte
+	Value []byte
+}
+
+// String returns the change as "op key value", in hex
+func (c Change) String() string {
+	return fmt.Sprintf("%s %x %x", c.Op, c.Key, c.Value)
+}
+
+// ChangeSummary describes the actual changes an update makes to the database,
+// once the values already there are accounted for
+type ChangeSummary struct {
+	// NewKeys is the number of keys created, DeletedKeys the number of keys
+	// whose last value is removed, UpdatedKeys the number of other keys
+	// whose values change
+	NewKeys     int
+	DeletedKeys int
+	UpdatedKeys int
+	// AddedValues and RemovedValues are the number of values added to and
+	// removed from keys
+	AddedValues   int
+	RemovedValues int
+	// Samples are the first changes, in key order
+	Samples []Change
+}
+
+// IsEmpty returns true if the update doesn't change anything
+func (s *ChangeSummary) IsEmpty() bool {
+	return s.AddedValues+s.RemovedValues == 0
+}
+
+// String returns the counts of the summary, followed by its samples one per
+// line
+func (s *ChangeSummary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "keys: %d new, %d deleted, %d updated; values: %d added, %d removed",
+		s.NewKeys, s.DeletedKeys, s.UpdatedKeys, s.AddedValues, s.RemovedValues)
+	for _, c := range s.Samples {
+		fmt.Fprintf(&b, "\n%s", c)
+	}
+	return b.String()
+}
+
+// splitValues returns the values of a multi-value
+func splitValues(data []byte) ([][]byte, error) {
+	var values [][]byte
+	for len(data) > 0 {
+		chunk, leftover, err := ReadNextChunk(data)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, chunk)
+		data = leftover
+	}
+	return values, nil
+}
+
+// diffValues returns the values of next which are not in prev and the values
+// of prev which are not in next, repeated values being counted
+func diffValues(prev, next [][]byte) (added, removed [][]byte) {
+	counts := make(map[string]int, len(prev))
+	for _, v := range prev {
+		counts[string(v)]++
+	}
+	for _, v := range next {
+		if counts[string(v)] > 0 {
+			counts[string(v)]--
+			continue
+		}
+		added = append(added, v)
+	}
+	for _, v := range prev {
+		if counts[string(v)] > 0 {
+			counts[string(v)]--
+			removed = append(removed, v)
+		}
+	}
+	return added, removed
+}
+
+// summarizeChanges compares the previous and next multi-values of keys
+func summarizeChanges(keys, prevValues, nextValues [][]byte, maxSamples int) (*ChangeSummary, error) {
+	if maxSamples == 0 {
+		maxSamples = DefaultChangeSamples
+	}
+	s := new(ChangeSummary)
+	sample := func(op dbdiff.Op, key []byte, values [][]byte) {
+		for _, v := range values {
+			if len(s.Samples) >= maxSamples {
+				return
+			}
+			s.Samples = append(s.Samples, Change{Op: op, Key: copyBytes(key), Value: copyBytes(v)})
+		}
+	}
+	for i, key := range keys {
+		if bytes.Equal(prevValues[i], nextValues[i]) {
+			continue
+		}
+		prev, err := splitValues(prevValues[i])
+		if err != nil {
+			return nil, fmt.Errorf("key %x: %w", key, err)
+		}
+		next, err := splitValues(nextValues[i])
+		if err != nil {
+			return nil, fmt.Errorf("key %x: %w", key, err)
+		}
+		added, removed := diffValues(prev, next)
+		if len(added)+len(removed) == 0 {
+			// same values in another order
+			continue
+		}
+		switch {
+		case len(prev) == 0:
+			s.NewKeys++
+		case len(next) == 0:
+			s.DeletedKeys++
+		default:
+			s.UpdatedKeys++
+		}
+		s.AddedValues += len(added)
+		s.RemovedValues += len(removed)
+		sample(dbdiff.DelOp, key, removed)
+		sample(dbdiff.AddOp, key, added)
+	}
+	return s, nil
+}
+
+// verifyValues checks that keys hold values, as read back from the database
+func (rdb *RDB) verifyValues(keys, values [][]byte) error {
+	got, errs := rdb.db.GetMulti(rdb.readOptions, keys)
+	mismatches := 0
+	var first int
+	for i, err := range errs {
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got[i], values[i]) {
+			if mismatches == 0 {
+				first = i
+			}
+			mismatches++
+		}
+	}
+	if mismatches > 0 {
+		return fmt.Errorf("verification failed for %d of %d keys, key %x holds %x instead of %x",
+			mismatches, len(keys), keys[first], got[first], values[first])
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rdb
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb/dbdiff"
+)
+
+func TestDiffValues(t *testing.T) {
+	v := func(s ...string) [][]byte {
+		var values [][]byte
+		for _, x := range s {
+			values = append(values, []byte(x))
+		}
+		return values
+	}
+	added, removed := diffValues(v("a", "b", "b"), v("b", "c", "a"))
+	require.Equal(t, v("c"), added)
+	require.Equal(t, v("b"), removed)
+	added, removed = diffValues(v("a", "b"), v("b", "a"))
+	require.Empty(t, added)
+	require.Empty(t, removed)
+	added, removed = diffValues(nil, v("a"))
+	require.Equal(t, v("a"), added)
+	require.Empty(t, removed)
+}
+
+func TestApplyDiffWithOptions(t *testing.T) {
+	input := []byte("Zexample.com,ns.example.com,dns.example.com,,,,,,\n+www.example.com,192.0.2.1\n+www.example.com,192.0.2.2\n")
+	dir := t.TempDir()
+	_, err := Compile(bytes.NewReader(input), 123, dir, CompilationOptions{NumCPU: 1, UseV2KeySyntax: true, Checksum: true})
+	require.NoError(t, err)
+	rdb, err := NewUpdater(dir)
+	require.NoError(t, err)
+	defer rdb.Close()
+
+	diff := "-+www.example.com,192.0.2.1\n++www.example.com,192.0.2.3\n++new.example.com,192.0.2.4\n"
+	want := ChangeSummary{NewKeys: 1, UpdatedKeys: 1, AddedValues: 2, RemovedValues: 1}
+
+	// a dry run only reports the changes
+	summary, err := rdb.ApplyDiffWithOptions(strings.NewReader(diff), 1, ApplyOptions{DryRun: true})
+	require.NoError(t, err)
+	require.Len(t, summary.Samples, 3)
+	for _, c := range summary.Samples {
+		require.Contains(t, []dbdiff.Op{dbdiff.AddOp, dbdiff.DelOp}, c.Op)
+	}
+	summary.Samples = nil
+	require.Equal(t, want, *summary)
+	_, err = rdb.Find([]byte(dnsdata.ChecksumKey), NewContext())
+	require.NoError(t, err, "checksum kept by dry runs")
+
+	summary, err = rdb.ApplyDiffWithOptions(strings.NewReader(diff), 1, ApplyOptions{Verify: true, MaxSamples: 1})
+	require.NoError(t, err)
+	require.Len(t, summary.Samples, 1)
+	summary.Samples = nil
+	require.Equal(t, want, *summary)
+
+	// the same diff can't be applied twice, dry run or not
+	_, err = rdb.ApplyDiffWithOptions(strings.NewReader(diff), 1, ApplyOptions{DryRun: true})
+	require.True(t, errors.Is(err, ErrNXVal), "got %v", err)
+
+	summary, err = rdb.ApplyDiffWithOptions(strings.NewReader("-+new.example.com,192.0.2.4\n"), 1, ApplyOptions{MaxSamples: -1})
+	require.NoError(t, err)
+	require.Equal(t, ChangeSummary{DeletedKeys: 1, RemovedValues: 1}, *summary)
+	require.Equal(t, "keys: 0 new, 1 deleted, 0 updated; values: 0 added, 1 removed", summary.String())
+
+	summary, err = rdb.ApplyDiffWithOptions(strings.NewReader(""), 1, ApplyOptions{})
+	require.NoError(t, err)
+	require.True(t, summary.IsEmpty())
+}
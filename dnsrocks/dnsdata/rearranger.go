@@ -18,8 +18,10 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
 )
 
 type rangePointKind uint8
@@ -49,6 +51,9 @@ type RangePoint struct {
 	rangeStart IPv6 // the first IP address of this range in IPv6 form
 	location   rangeLocation
 	pointKind  rangePointKind
+	// implicit is set for the points starting a default range again after
+	// the IPv4 range, which were not declared as such
+	implicit bool
 }
 
 // RangePoints is an array of RangePoint, the only reason for it to exist is the String() method
@@ -60,6 +65,10 @@ type Rearranger struct {
 	hasDefaultIPv6Range bool
 	points              RangePoints
 	lmap                Lmap
+	// parallelism is the maximum number of goroutines sorting the points
+	parallelism int
+	// overlaps are the overlaps found by the last Rearrange
+	overlaps []Overlap
 }
 
 // String returns a string representation of these RangePoints, useful for debugging
@@ -151,7 +160,8 @@ func (p *RangePoint) String() string {
 // NewRearranger creates an instance of Rearranger for estimated locationCount
 func NewRearranger(locationCount int) *Rearranger {
 	return &Rearranger{
-		points: make([]*RangePoint, 0, locationCount*2),
+		points:      make([]*RangePoint, 0, locationCount*2),
+		parallelism: runtime.GOMAXPROCS(0),
 	}
 }
 
@@ -195,6 +205,7 @@ func (r *Rearranger) AddLocation(ipnet *net.IPNet, locID []byte) error {
 			rangeStart: afterIPv4,
 			pointKind:  pointKindStart,
 			location:   defaultIPv6Location,
+			implicit:   true,
 		})
 	} else if firstIPv4.EqualToNetIP(ipnet.IP.To16()) {
 		// it is 0.0.0.0/0
@@ -302,9 +313,110 @@ func ipFillUnmasked(ipaddr *net.IP, mask *net.IPMask) IPv6 {
 	return result
 }
 
+// minSortChunk is the minimum number of range points sorted by each goroutine,
+// below which starting goroutines costs more than it saves
+const minSortChunk = 1 << 14
+
+// lessRangePoint orders range points by nest: by IP, then ends before starts,
+// then starts of the shortest prefix and ends of the longest one first
+func lessRangePoint(a, b *RangePoint) bool {
+	cmp := bytes.Compare(a.rangeStart[:], b.rangeStart[:])
+	if cmp != 0 {
+		return cmp == -1
+	}
+	k1, k2 := a.pointKind, b.pointKind
+	if k1 != k2 {
+		// between pointKindStart and pointKindEnd: pointKindEnd goes first (it is less)
+		return k1 == pointKindEnd
+	}
+	l1, l2 := a.location, b.location
+	if l1.maskLen != l2.maskLen {
+		if k1 == pointKindStart {
+			// for pointKindStart between pointKindStart and pointKindStart: shortest prefix first
+			return l1.maskLen < l2.maskLen
+		}
+		// for pointKindEnd between pointKindEnd and pointKindEnd: longest prefix first
+		return l1.maskLen > l2.maskLen
+	}
+	// the same range declared more than once: order by location, so the
+	// outcome does not depend on the order the ranges were added in
+	if l1.locIDIsNull != l2.locIDIsNull {
+		return l1.locIDIsNull
+	}
+	return bytes.Compare(l1.locID, l2.locID) < 0
+}
+
+// sortRangePoints sorts points with lessRangePoint, in up to parallelism
+// chunks sorted concurrently, then merged pairwise, concurrently too. Points
+// neither less than the other are identical, so the result does not depend on
+// parallelism. The result may use points or a new slice.
+func sortRangePoints(points RangePoints, parallelism int) RangePoints {
+	chunks := min(parallelism, len(points)/minSortChunk)
+	if chunks <= 1 {
+		sort.Slice(points, func(i, j int) bool {
+			return lessRangePoint(points[i], points[j])
+		})
+		return points
+	}
+
+	// bounds of the sorted runs
+	bounds := make([]int, chunks+1)
+	var wg sync.WaitGroup
+	for i := range chunks {
+		bounds[i+1] = len(points) * (i + 1) / chunks
+		run := points[bounds[i]:bounds[i+1]]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sort.Slice(run, func(i, j int) bool {
+				return lessRangePoint(run[i], run[j])
+			})
+		}()
+	}
+	wg.Wait()
+
+	src, dst := points, make(RangePoints, len(points))
+	for len(bounds) > 2 {
+		merged := []int{0}
+		for i := 0; i+1 < len(bounds); i += 2 {
+			if i+2 == len(bounds) {
+				// odd run out
+				copy(dst[bounds[i]:], src[bounds[i]:bounds[i+1]])
+				merged = append(merged, bounds[i+1])
+				continue
+			}
+			lo, mid, hi := bounds[i], bounds[i+1], bounds[i+2]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				mergeRangePoints(dst[lo:hi], src[lo:mid], src[mid:hi])
+			}()
+			merged = append(merged, hi)
+		}
+		wg.Wait()
+		src, dst, bounds = dst, src, merged
+	}
+	return src
+}
+
+// mergeRangePoints merges the sorted a and b into dst
+func mergeRangePoints(dst, a, b RangePoints) {
+	i, j := 0, 0
+	for k := range dst {
+		if j == len(b) || (i < len(a) && !lessRangePoint(b[j], a[i])) {
+			dst[k] = a[i]
+			i++
+		} else {
+			dst[k] = b[j]
+			j++
+		}
+	}
+}
+
 // Rearrange returns a slice with RangePoints with resolved LocID for
 // finish RangePoint. It also adds implicit "null" locations spanning
-// all unmatched ranges (if necessary).
+// all unmatched ranges (if necessary). Large maps are sorted by as many
+// goroutines as GOMAXPROCS, with the same result.
 func (r *Rearranger) Rearrange() RangePoints {
 	if len(r.points) == 0 {
 		return nil
@@ -354,40 +466,21 @@ func (r *Rearranger) Rearrange() RangePoints {
 	}
 
 	// sort by nest
-	sort.Slice(result, func(i, j int) bool {
-		cmp := bytes.Compare(result[i].rangeStart[:], result[j].rangeStart[:])
-		if cmp != 0 {
-			return cmp == -1
-		}
-		k1, k2 := result[i].pointKind, result[j].pointKind
-		if k1 != k2 {
-			// between pointKindStart and pointKindEnd: pointKindEnd goes first (it is less)
-			return k1 == pointKindEnd
-		}
-		if k1 == pointKindStart {
-			// for pointKindStart between pointKindStart and pointKindStart: shortest prefix first
-			return result[i].location.maskLen < result[j].location.maskLen
-		}
-		// for pointKindEnd between pointKindEnd and pointKindEnd: longest prefix first
-		return result[i].location.maskLen > result[j].location.maskLen
-	})
+	result = sortRangePoints(result, r.parallelism)
 
-	locationStack := make([]rangeLocation, 0, 129) // normally 129 values from /0 to /128, but can be more if the same IP range was declared more than once
-	stackTop := -1
+	r.overlaps = nil
+	startStack := make(RangePoints, 0, 129) // normally 129 values from /0 to /128, but can be more if the same IP range was declared more than once
 	for _, point := range result {
 		switch point.pointKind {
 		case pointKindStart:
-			// push the location
-			stackTop++
-			if stackTop == len(locationStack) {
-				// extend stack
-				locationStack = append(locationStack, point.location)
-			} else {
-				locationStack[stackTop] = point.location
+			if len(startStack) > 0 {
+				r.checkOverlap(startStack[len(startStack)-1], point)
 			}
+			// push the range
+			startStack = append(startStack, point)
 		case pointKindEnd:
-			stackTop--                               // pop
-			point.location = locationStack[stackTop] // location comes from the range that spans this range point
+			startStack = startStack[:len(startStack)-1]             // pop
+			point.location = startStack[len(startStack)-1].location // location comes from the range that spans this range point
 		}
 	}
 
@@ -410,3 +503,108 @@ func (r *Rearranger) Rearrange() RangePoints {
 
 	return squashedIP
 }
+
+// OverlapKind is how a range overlaps the narrowest range containing it in a
+// location map
+type OverlapKind uint8
+
+// Overlap kinds
+const (
+	// OverlapConflict is a range mapped to different locations, the highest
+	// location ID wins
+	OverlapConflict OverlapKind = iota
+	// OverlapDuplicate is a range mapped to the same location more than once
+	OverlapDuplicate
+	// OverlapRedundant is a range mapped to the same location as the
+	// narrowest range containing it, it has no effect
+	OverlapRedundant
+)
+
+func (k OverlapKind) String() string {
+	switch k {
+	case OverlapConflict:
+		return "conflict"
+	case OverlapDuplicate:
+		return "duplicate"
+	case OverlapRedundant:
+		return "redundant"
+	default:
+		return fmt.Sprintf("OverlapKind(%d)", k)
+	}
+}
+
+// Overlap is a range of a location map overlapping another one
+type Overlap struct {
+	Kind OverlapKind
+	Map  Lmap
+	// Subnet and LocID are the overlapping range and its location, Parent
+	// and ParentLocID the range it overlaps, Parent is Subnet unless the
+	// overlap is OverlapRedundant
+	Subnet      string
+	LocID       Loc
+	Parent      string
+	ParentLocID Loc
+}
+
+// String returns a description of the overlap
+func (o Overlap) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s in map %s: %s at ", o.Kind, o.Map, o.Subnet)
+	Putloctext(&b, o.LocID)
+	if o.Kind == OverlapRedundant {
+		fmt.Fprintf(&b, " within %s", o.Parent)
+	} else {
+		b.WriteString(" and ")
+		Putloctext(&b, o.ParentLocID)
+	}
+	return b.String()
+}
+
+// Overlaps returns the overlaps found by the last Rearrange
+func (r *Rearranger) Overlaps() []Overlap {
+	return r.overlaps
+}
+
+// checkOverlap records how the start of a range point overlaps the start
+// point of the narrowest range containing it, if it does
+func (r *Rearranger) checkOverlap(parent, point *RangePoint) {
+	if point.implicit || point.LocIsNull() || parent.LocIsNull() {
+		return
+	}
+	sameRange := parent.rangeStart.Equal(point.rangeStart) && parent.MaskLen() == point.MaskLen()
+	sameLoc := bytes.Equal(parent.LocID(), point.LocID())
+	var kind OverlapKind
+	switch {
+	case sameRange && !sameLoc:
+		kind = OverlapConflict
+	case sameRange:
+		kind = OverlapDuplicate
+	case sameLoc:
+		kind = OverlapRedundant
+	default:
+		return
+	}
+	r.overlaps = append(r.overlaps, Overlap{
+		Kind:        kind,
+		Map:         r.lmap,
+		Subnet:      rangeSubnet(point),
+		LocID:       point.LocID(),
+		Parent:      rangeSubnet(parent),
+		ParentLocID: parent.LocID(),
+	})
+}
+
+// rangeSubnet returns the subnet a start range point was added for in CIDR
+// notation
+func rangeSubnet(p *RangePoint) string {
+	maskLen := int(p.MaskLen())
+	ip := net.IP(p.rangeStart[:])
+	if ip4 := ip.To4(); ip4 != nil {
+		if maskLen >= (net.IPv6len-net.IPv4len)*8 {
+			maskLen -= (net.IPv6len - net.IPv4len) * 8
+		}
+		return fmt.Sprintf("%s/%d", ip4, maskLen)
+	}
+	// the start of default ranges after the IPv4 range
+	return fmt.Sprintf("%s/%d", ip.Mask(net.CIDRMask(maskLen, net.IPv6len*8)), maskLen)
+}
@@ -17,6 +17,7 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -874,6 +875,109 @@ func TestInvalidLocation(t *testing.T) {
 	}
 }
 
+func TestRearrangeDuplicateRanges(t *testing.T) {
+	locations := []struct {
+		network string
+		locID   []byte
+	}{
+		{"10.0.0.0/8", []byte{0, 1}},
+		{"10.1.0.0/16", []byte{0, 2}},
+		{"10.1.0.0/16", []byte{0, 3}},
+		{"10.1.0.0/16", []byte{0, 4}},
+	}
+	rearrange := func(order []int) []string {
+		r := NewRearranger(len(locations))
+		for _, i := range order {
+			require.NoError(t, r.AddLocation(strToNet(t, locations[i].network), locations[i].locID))
+		}
+		var points []string
+		for _, pt := range r.Rearrange() {
+			points = append(points, pt.String())
+		}
+		return points
+	}
+
+	expected := rearrange([]int{0, 1, 2, 3})
+	require.Equal(t, expected, rearrange([]int{3, 2, 1, 0}))
+	require.Equal(t, expected, rearrange([]int{2, 0, 3, 1}))
+}
+
+func TestRearrangeParallel(t *testing.T) {
+	// nested, adjacent and duplicate ranges, enough for several sorted chunks
+	var locations []*net.IPNet
+	var locIDs [][]byte
+	for i := 0; i < 5*minSortChunk/4; i++ {
+		locations = append(locations, strToNet(t, fmt.Sprintf("10.%d.%d.0/24", i>>8&0xff, i&0xff)))
+		locIDs = append(locIDs, []byte{byte(i % 7), byte(i % 3)})
+		if i%5 == 0 {
+			locations = append(locations, strToNet(t, fmt.Sprintf("10.%d.0.0/16", i>>8&0xff)))
+			locIDs = append(locIDs, []byte{0, byte(i % 11)})
+		}
+		if i%3 == 0 {
+			locations = append(locations, strToNet(t, fmt.Sprintf("2001:db8:%x::/48", i)))
+			locIDs = append(locIDs, []byte{1, byte(i % 5)})
+		}
+	}
+	rearrange := func(parallelism int, reverse bool) ([]string, []Overlap) {
+		r := NewRearranger(len(locations))
+		r.parallelism = parallelism
+		for i := range locations {
+			if reverse {
+				i = len(locations) - 1 - i
+			}
+			require.NoError(t, r.AddLocation(locations[i], locIDs[i]))
+		}
+		var points []string
+		for _, pt := range r.Rearrange() {
+			points = append(points, pt.String())
+		}
+		return points, r.Overlaps()
+	}
+
+	expected, expectedOverlaps := rearrange(1, false)
+	require.NotEmpty(t, expectedOverlaps)
+	for _, parallelism := range []int{2, 3, 8} {
+		points, overlaps := rearrange(parallelism, parallelism%2 == 0)
+		require.Equal(t, expected, points, "parallelism %d", parallelism)
+		require.Equal(t, expectedOverlaps, overlaps, "parallelism %d", parallelism)
+	}
+}
+
+func TestRearrangerOverlaps(t *testing.T) {
+	r := NewRearranger(8)
+	r.lmap = Lmap("m1")
+	for _, l := range []struct {
+		network string
+		locID   []byte
+	}{
+		{"::/0", []byte{0, 9}},
+		{"2001:db8::/32", []byte{0, 9}},
+		{"2001:db8:1::/48", []byte{0, 1}},
+		{"10.0.0.0/8", []byte{0, 1}},
+		{"10.1.0.0/16", []byte{0, 2}},
+		{"10.1.0.0/16", []byte{0, 3}},
+		{"10.1.2.0/24", []byte{0, 3}},
+		{"10.2.0.0/16", []byte{0, 1}},
+		{"10.2.0.0/16", []byte{0, 1}},
+	} {
+		require.NoError(t, r.AddLocation(strToNet(t, l.network), l.locID))
+	}
+	r.Rearrange()
+	require.Equal(t, []Overlap{
+		{Kind: OverlapConflict, Map: Lmap("m1"), Subnet: "10.1.0.0/16", LocID: Loc{0, 3}, Parent: "10.1.0.0/16", ParentLocID: Loc{0, 2}},
+		{Kind: OverlapRedundant, Map: Lmap("m1"), Subnet: "10.1.2.0/24", LocID: Loc{0, 3}, Parent: "10.1.0.0/16", ParentLocID: Loc{0, 3}},
+		{Kind: OverlapRedundant, Map: Lmap("m1"), Subnet: "10.2.0.0/16", LocID: Loc{0, 1}, Parent: "10.0.0.0/8", ParentLocID: Loc{0, 1}},
+		{Kind: OverlapDuplicate, Map: Lmap("m1"), Subnet: "10.2.0.0/16", LocID: Loc{0, 1}, Parent: "10.2.0.0/16", ParentLocID: Loc{0, 1}},
+		{Kind: OverlapRedundant, Map: Lmap("m1"), Subnet: "2001:db8::/32", LocID: Loc{0, 9}, Parent: "::/0", ParentLocID: Loc{0, 9}},
+	}, r.Overlaps())
+	require.Equal(t, `conflict in map \155\061: 10.1.0.0/16 at \000\003 and \000\002`, r.Overlaps()[0].String())
+	require.Equal(t, `redundant in map \155\061: 10.1.2.0/24 at \000\003 within 10.1.0.0/16`, r.Overlaps()[1].String())
+
+	// overlaps are found again on every Rearrange
+	r.Rearrange()
+	require.Len(t, r.Overlaps(), 5)
+}
+
 func TestLpad(t *testing.T) {
 	in := []byte{1, 2}
 	npad := 4
@@ -1083,16 +1187,25 @@ func BenchmarkRearranger(b *testing.B) {
 		locations = append(locations, testLocation{network, location})
 	}
 
-	// run the DiffWith function b.N times
-	for n := 0; n < b.N; n++ {
-		r := NewRearranger(1)
-		for _, in := range locations {
-			_, net, _ := net.ParseCIDR(in.network)
-			err := r.AddLocation(net, in.locID)
-			if err != nil {
-				b.Fatalf("%v", err)
+	parallelisms := []int{1}
+	if p := runtime.GOMAXPROCS(0); p > 1 {
+		parallelisms = append(parallelisms, p)
+	}
+	for _, parallelism := range parallelisms {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			// run the Rearrange function b.N times
+			for n := 0; n < b.N; n++ {
+				r := NewRearranger(1)
+				r.parallelism = parallelism
+				for _, in := range locations {
+					_, net, _ := net.ParseCIDR(in.network)
+					err := r.AddLocation(net, in.locID)
+					if err != nil {
+						b.Fatalf("%v", err)
+					}
+				}
+				r.Rearrange()
 			}
-		}
-		r.Rearrange()
+		})
 	}
 }
@@ -0,0 +1,301 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReverseZone is a reverse zone generated from the forward data: the A and
+// AAAA records of the Forward zones and the names below them, whose address
+// is in Prefix, get a PTR record, unless their address has one in the data
+// already. The zone gets the SOA and NS records of the apex of the first
+// forward zone, unless the data has a SOA for it.
+type ReverseZone struct {
+	Prefix netip.Prefix
+	// Forward are the forward zones, without trailing dots
+	Forward []string
+}
+
+// ParseReverseZone parses a reverse zone written as prefix=zone[,zone...],
+// e.g. 10.0.0.0/8=example.com,example.net. The prefix length must be a
+// multiple of 8 for IPv4, and of 4 for IPv6.
+func ParseReverseZone(s string) (ReverseZone, error) {
+	var z ReverseZone
+	prefix, zones, ok := strings.Cut(s, "=")
+	if !ok || zones == "" {
+		return z, fmt.Errorf("invalid reverse zone %q, want prefix=zone[,zone...]", s)
+	}
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return z, fmt.Errorf("invalid reverse zone %q: %w", s, err)
+	}
+	unit := 4
+	if p.Addr().Is4() {
+		unit = 8
+	}
+	if p.Bits()%unit != 0 {
+		return z, fmt.Errorf("invalid reverse zone %q: prefix length must be a multiple of %d", s, unit)
+	}
+	z.Prefix = p.Masked()
+	for _, zone := range strings.Split(zones, ",") {
+		zone = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(zone), "."))
+		if zone == "" {
+			return z, fmt.Errorf("invalid reverse zone %q: empty forward zone", s)
+		}
+		z.Forward = append(z.Forward, zone)
+	}
+	return z, nil
+}
+
+// String returns z the way ParseReverseZone reads it
+func (z ReverseZone) String() string {
+	return z.Prefix.String() + "=" + strings.Join(z.Forward, ",")
+}
+
+// Name returns the in-addr.arpa or ip6.arpa name of the zone
+func (z ReverseZone) Name() string {
+	var labels []string
+	if z.Prefix.Addr().Is4() {
+		a := z.Prefix.Addr().As4()
+		for i := z.Prefix.Bits()/8 - 1; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(a[i])))
+		}
+		return strings.Join(append(labels, "in-addr.arpa"), ".")
+	}
+	a := z.Prefix.Addr().As16()
+	for i := z.Prefix.Bits()/4 - 1; i >= 0; i-- {
+		nibble := a[i/2] >> 4
+		if i%2 == 1 {
+			nibble = a[i/2] & 0xf
+		}
+		labels = append(labels, strconv.FormatUint(uint64(nibble), 16))
+	}
+	return strings.Join(append(labels, "ip6.arpa"), ".")
+}
+
+// inZone tells whether name is zone or a name below it, both lower case
+// without trailing dots
+func inZone(name, zone string) bool {
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// reversePTR is a PTR record to generate
+type reversePTR struct {
+	addr netip.Addr
+	host string
+	ttl  uint32
+	lo   Loc
+}
+
+// reverseData accumulates what reverse zones are generated from
+type reverseData struct {
+	ptrs []reversePTR
+	// existing are the owners of the PTR records of the data
+	existing map[string]struct{}
+	// soas and ns are the SOA and NS records of the apexes of forward and
+	// reverse zones
+	soas map[string][]Rsoa
+	ns   map[string][]Rns1
+}
+
+// normalizedName returns dom lower case, without trailing dot
+func normalizedName(dom []byte) string {
+	return strings.TrimSuffix(string(bytes.ToLower(dom)), ".")
+}
+
+// forwardZone tells whether name is in a forward zone of c.ReverseZones
+func (c *Codec) forwardZone(name string) bool {
+	for _, z := range c.ReverseZones {
+		for _, fwd := range z.Forward {
+			if inZone(name, fwd) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reverseApex tells whether name is the apex of a forward or reverse zone of
+// c.ReverseZones
+func (c *Codec) reverseApex(name string) bool {
+	for _, z := range c.ReverseZones {
+		if name == z.Name() || name == z.Forward[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// reverseAddr tells whether addr is in the prefix of a reverse zone of
+// c.ReverseZones
+func (c *Codec) reverseAddr(addr netip.Addr) bool {
+	for _, z := range c.ReverseZones {
+		if z.Prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// addReverse accounts the records of s which reverse zones are generated
+// from, with Codec.ReverseZones
+func (r *Accum) addReverse(s Record, c *Codec) {
+	if len(c.ReverseZones) == 0 {
+		return
+	}
+	switch s := s.(type) {
+	case CompositeRecord:
+		for _, derived := range s.DerivedRecords() {
+			r.addReverse(derived, c)
+		}
+	case *Raddr:
+		if s.ip == nil || s.iswildcard {
+			return
+		}
+		addr, ok := netip.AddrFromSlice(s.ip)
+		if !ok {
+			return
+		}
+		addr = addr.Unmap()
+		name := normalizedName(s.dom)
+		if !c.reverseAddr(addr) || !c.forwardZone(name) {
+			return
+		}
+		r.mux.Lock()
+		defer r.mux.Unlock()
+		r.reverse.ptrs = append(r.reverse.ptrs, reversePTR{addr: addr, host: name, ttl: s.ttl, lo: s.lo})
+	case *Rptr:
+		r.mux.Lock()
+		defer r.mux.Unlock()
+		if r.reverse.existing == nil {
+			r.reverse.existing = make(map[string]struct{})
+		}
+		r.reverse.existing[normalizedName(s.dom)] = struct{}{}
+	case *Rsoa:
+		name := normalizedName(s.dom)
+		if !c.reverseApex(name) {
+			return
+		}
+		r.mux.Lock()
+		defer r.mux.Unlock()
+		if r.reverse.soas == nil {
+			r.reverse.soas = make(map[string][]Rsoa)
+		}
+		r.reverse.soas[name] = append(r.reverse.soas[name], *s)
+	case *Rns1:
+		name := normalizedName(s.dom)
+		if !c.reverseApex(name) {
+			return
+		}
+		r.mux.Lock()
+		defer r.mux.Unlock()
+		if r.reverse.ns == nil {
+			r.reverse.ns = make(map[string][]Rns1)
+		}
+		r.reverse.ns[name] = append(r.reverse.ns[name], *s)
+	}
+}
+
+// reverseRecords returns the records of the reverse zones generated from the
+// data: the SOA and NS records of the zones without SOA in the data, then
+// the PTR records of the addresses without PTR in the data, one per address,
+// name and location, with the lowest TTL of their A or AAAA records.
+func (r *Accum) reverseRecords(c *Codec) ([]Record, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	var records []Record
+	for _, z := range c.ReverseZones {
+		name := z.Name()
+		if len(r.reverse.soas[name]) > 0 {
+			continue
+		}
+		soas := r.reverse.soas[z.Forward[0]]
+		if len(soas) == 0 {
+			return nil, fmt.Errorf("no SOA for %s, the first forward zone of reverse zone %s", z.Forward[0], z)
+		}
+		for _, soa := range soas {
+			soa.dom = []byte(name)
+			records = append(records, &soa)
+		}
+		for _, ns := range r.reverse.ns[z.Forward[0]] {
+			ns.dom = []byte(name)
+			records = append(records, &ns)
+		}
+	}
+
+	type ptrKey struct {
+		owner string
+		host  string
+		lo    string
+	}
+	ptrs := make(map[ptrKey]uint32)
+	for _, p := range r.reverse.ptrs {
+		owner := ptrName(p.addr)
+		if _, ok := r.reverse.existing[owner]; ok {
+			continue
+		}
+		k := ptrKey{owner: owner, host: p.host, lo: string(p.lo)}
+		if ttl, ok := ptrs[k]; !ok || p.ttl < ttl {
+			ptrs[k] = p.ttl
+		}
+	}
+	keys := make([]ptrKey, 0, len(ptrs))
+	for k := range ptrs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].owner != keys[j].owner {
+			return keys[i].owner < keys[j].owner
+		}
+		if keys[i].host != keys[j].host {
+			return keys[i].host < keys[j].host
+		}
+		return keys[i].lo < keys[j].lo
+	})
+	for _, k := range keys {
+		ptr := &Rptr{host: []byte(k.host), c: c}
+		ptr.dom = []byte(k.owner)
+		ptr.ttl = ptrs[k]
+		if k.lo != "" {
+			ptr.lo = Loc(k.lo)
+		}
+		records = append(records, ptr)
+	}
+	return records, nil
+}
+
+// marshalReverseZones returns the records of the reverse zones generated from
+// the data with Codec.ReverseZones. Their owner names are accounted for the
+// empty non-terminals.
+func (c *Codec) marshalReverseZones() ([]MapRecord, error) {
+	if len(c.ReverseZones) == 0 {
+		return nil, nil
+	}
+	records, err := c.Acc.reverseRecords(c)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		if err = c.Acc.addOwners(rec, c); err != nil {
+			return nil, err
+		}
+	}
+	return marshalMapv(records...)
+}
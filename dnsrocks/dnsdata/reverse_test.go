@@ -0,0 +1,133 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsdata
+
+import (
+	"bytes"
+	"net/netip"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReverseZone(t *testing.T) {
+	testCases := []struct {
+		in   string
+		name string
+	}{
+		{in: "10.0.0.0/8=example.com", name: "10.in-addr.arpa"},
+		{in: "192.0.2.1/24=example.com.,Example.NET", name: "2.0.192.in-addr.arpa"},
+		{in: "0.0.0.0/0=example.com", name: "in-addr.arpa"},
+		{in: "2001:db8::/32=example.com", name: "8.b.d.0.1.0.0.2.ip6.arpa"},
+		{in: "2001:db8:ab00::/36=example.com", name: "a.8.b.d.0.1.0.0.2.ip6.arpa"},
+	}
+	for _, tc := range testCases {
+		z, err := ParseReverseZone(tc.in)
+		require.NoError(t, err, tc.in)
+		require.Equal(t, tc.name, z.Name(), tc.in)
+	}
+	z, err := ParseReverseZone("192.0.2.1/24=example.com.,Example.NET")
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.0/24=example.com,example.net", z.String())
+
+	for _, in := range []string{
+		"10.0.0.0/8",
+		"10.0.0.0/8=",
+		"10.0.0.0=example.com",
+		"10.0.0.0/12=example.com",
+		"2001:db8::/30=example.com",
+		"10.0.0.0/8=example.com,,example.net",
+	} {
+		_, err := ParseReverseZone(in)
+		require.Error(t, err, in)
+	}
+}
+
+// reverseForwardData has addresses in and out of the reverse zones, of names
+// in and out of the forward zones, some with PTR records already
+const reverseForwardData = `Zexample.com,ns.example.com,dns.example.com,123,1800,900,604800,3600,300
+&example.com,,ns.example.com,300
++www.example.com,10.1.2.3,300
++WWW.example.com.,10.1.2.3,60
++www.example.com,10.1.2.3,300,,\001\002
++mail.example.com,10.1.2.4,300
+@example.com,10.1.2.6,mx.example.com,10,300
++v6.example.com,2001:db8::1,300
+=explicit.example.com,10.1.2.5,300
++alias.example.com,10.1.2.5,300
++*.w.example.com,10.1.2.7,300
++ext.example.com,192.0.2.1,300
++other.example.org,10.9.9.9,300
+`
+
+// sortedRecords returns records in a deterministic order
+func sortedRecords(records []MapRecord) []MapRecord {
+	sort.Slice(records, func(i, j int) bool {
+		if c := bytes.Compare(records[i].Key, records[j].Key); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(records[i].Value, records[j].Value) < 0
+	})
+	return records
+}
+
+func TestReverseZones(t *testing.T) {
+	var zones []ReverseZone
+	for _, s := range []string{"10.0.0.0/8=example.com", "2001:db8::/32=example.com"} {
+		z, err := ParseReverseZone(s)
+		require.NoError(t, err)
+		zones = append(zones, z)
+	}
+	codec := &Codec{ReverseZones: zones}
+	records, err := Parse(strings.NewReader(reverseForwardData), codec, 2)
+	require.NoError(t, err)
+
+	expected, err := Parse(strings.NewReader(reverseForwardData+`Z10.in-addr.arpa,ns.example.com,dns.example.com,123,1800,900,604800,3600,300
+&10.in-addr.arpa,,ns.example.com,300
+Z8.b.d.0.1.0.0.2.ip6.arpa,ns.example.com,dns.example.com,123,1800,900,604800,3600,300
+&8.b.d.0.1.0.0.2.ip6.arpa,,ns.example.com,300
+^3.2.1.10.in-addr.arpa,www.example.com,60
+^3.2.1.10.in-addr.arpa,www.example.com,300,,\001\002
+^4.2.1.10.in-addr.arpa,mail.example.com,300
+^6.2.1.10.in-addr.arpa,mx.example.com,300
+^1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa,v6.example.com,300
+`), new(Codec), 2)
+	require.NoError(t, err)
+	require.Equal(t, sortedRecords(expected), sortedRecords(records))
+
+	// the data has the SOA and NS records of the reverse zone already
+	data := reverseForwardData + `Z10.in-addr.arpa,ns.example.net,dns.example.net,7,1800,900,604800,3600,300
+`
+	codec = &Codec{ReverseZones: zones[:1]}
+	records, err = Parse(strings.NewReader(data), codec, 1)
+	require.NoError(t, err)
+	expected, err = Parse(strings.NewReader(data+`^3.2.1.10.in-addr.arpa,www.example.com,60
+^3.2.1.10.in-addr.arpa,www.example.com,300,,\001\002
+^4.2.1.10.in-addr.arpa,mail.example.com,300
+^6.2.1.10.in-addr.arpa,mx.example.com,300
+`), new(Codec), 1)
+	require.NoError(t, err)
+	require.Equal(t, sortedRecords(expected), sortedRecords(records))
+}
+
+func TestReverseZonesWithoutForwardSOA(t *testing.T) {
+	codec := &Codec{ReverseZones: []ReverseZone{{
+		Prefix:  netip.MustParsePrefix("10.0.0.0/8"),
+		Forward: []string{"example.org"},
+	}}}
+	_, err := Parse(strings.NewReader(reverseForwardData), codec, 1)
+	require.ErrorContains(t, err, "no SOA for example.org")
+}
@@ -17,6 +17,7 @@ import (
 	"os"
 )
 
+// DeriveSerial returns a SOA serial derived from the modification time of f
 func DeriveSerial(f *os.File) (uint32, error) {
 	fi, err := f.Stat()
 	if err != nil {
@@ -14,6 +14,9 @@ limitations under the License.
 package dnsdata
 
 import (
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/golang/glog"
@@ -23,11 +26,16 @@ import (
 const (
 	// InitLocationCount is the initial locationCount for NewRearranger
 	InitLocationCount = 2
+	// maxLoggedOverlaps caps the number of overlaps of each kind logged
+	maxLoggedOverlaps = 10
 )
 
 // SubnetRanger represents an aggregate structure responsible for building
 // the auxiliary structures for the subnet-based location lookup
 type SubnetRanger struct {
+	// Strict fails marshaling when the same range is mapped to different
+	// locations in a map, rather than the highest location ID winning
+	Strict  bool
 	enabled bool
 	arng    map[string]*Rearranger
 }
@@ -56,20 +64,27 @@ func (r *SubnetRanger) addSubnet(s *Rnet) error {
 	return a.AddLocation(s.ipnet, s.lo)
 }
 
-// MarshalMap implements MapMarshaler
+// MarshalMap implements MapMarshaler. Records of the location maps come in
+// the order of their names, for the output to be reproducible.
 func (r *SubnetRanger) MarshalMap() (result []MapRecord, err error) {
 	if !r.enabled {
 		return nil, nil
 	}
 
-	perMapRecords := make(chan []MapRecord, 1)
+	names := make([]string, 0, len(r.arng))
+	for name := range r.arng {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	perMapRecords := make([][]MapRecord, len(names))
 
 	group := &errgroup.Group{}
 
-	for _, a := range r.arng {
+	for i, name := range names {
 		// closures
-		localLmap := a.lmap
-		rearranger := a
+		index := i
+		localLmap := r.arng[name].lmap
+		rearranger := r.arng[name]
 
 		group.Go(
 			func() error {
@@ -83,26 +98,76 @@ func (r *SubnetRanger) MarshalMap() (result []MapRecord, err error) {
 					m = append(m, mr...)
 				}
 
-				perMapRecords <- m
+				perMapRecords[index] = m
 				return nil
 			})
 	}
 
-	go func() {
-		err := group.Wait()
-		if err != nil {
-			glog.Errorf("%v", err)
-		}
-		close(perMapRecords)
-	}()
+	if err = group.Wait(); err != nil {
+		glog.Errorf("%v", err)
+		return nil, err
+	}
+	if err = r.checkOverlaps(); err != nil {
+		return nil, err
+	}
 
-	for data := range perMapRecords {
+	for _, data := range perMapRecords {
 		result = append(result, data...)
 	}
 
-	err = group.Wait()
+	return result, nil
+}
 
-	return
+// Overlaps returns the overlapping ranges of all maps found by the last
+// marshaling, in the order of the map names
+func (r *SubnetRanger) Overlaps() []Overlap {
+	names := make([]string, 0, len(r.arng))
+	for name := range r.arng {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var overlaps []Overlap
+	for _, name := range names {
+		overlaps = append(overlaps, r.arng[name].Overlaps()...)
+	}
+	return overlaps
+}
+
+// ErrLocationConflict is returned in strict mode when the same range is
+// mapped to different locations in a map
+var ErrLocationConflict = errors.New("range mapped to different locations")
+
+// checkOverlaps logs the overlapping ranges found by the last marshaling, and
+// fails on conflicts in strict mode
+func (r *SubnetRanger) checkOverlaps() error {
+	var counts [OverlapRedundant + 1]int
+	var firstConflict *Overlap
+	for _, o := range r.Overlaps() {
+		counts[o.Kind]++
+		if counts[o.Kind] > maxLoggedOverlaps {
+			continue
+		}
+		if o.Kind == OverlapConflict {
+			if firstConflict == nil {
+				firstConflict = &o
+			}
+			glog.Warningf("%s", o)
+		} else {
+			glog.V(1).Infof("%s", o)
+		}
+	}
+	for kind, count := range counts {
+		if count > 0 {
+			glog.Warningf("%d ranges overlapping in location maps: %s", count, OverlapKind(kind))
+		}
+	}
+	if r.Strict && firstConflict != nil {
+		if more := counts[OverlapConflict] - 1; more > 0 {
+			return fmt.Errorf("%w: %s, and %d more", ErrLocationConflict, firstConflict, more)
+		}
+		return fmt.Errorf("%w: %s", ErrLocationConflict, firstConflict)
+	}
+	return nil
 }
 
 // OpenScanner creates Scanner which allows lazy reading of subnet range records in a text form
@@ -152,6 +217,9 @@ func (r *SubnetRanger) OpenScanner() (s *SubnetRangerScanner) {
 
 	go func() {
 		err := group.Wait()
+		if err == nil {
+			err = r.checkOverlaps()
+		}
 		s.SetError(err)
 		close(chunks)
 	}()
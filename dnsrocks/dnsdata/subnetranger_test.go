@@ -18,6 +18,8 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestSubnetRanger(t *testing.T) {
@@ -131,3 +133,46 @@ func TestSubnetRanger(t *testing.T) {
 		}
 	})
 }
+
+func TestSubnetRangerStrict(t *testing.T) {
+	tc := []string{
+		"%ab,10.0.0.0/8,m1",
+		"%ab,10.1.0.0/16,m1",
+		"%cd,192.168.1.0/24,m2",
+		"%ef,192.168.1.0/24,m2",
+	}
+	newCodec := func(strict bool) *Codec {
+		codec := new(Codec)
+		codec.Acc.Ranger.Enable()
+		codec.Acc.Ranger.Strict = strict
+		codec.Acc.NoPrefixSets = true
+		codec.NoRnetOutput = true
+		for _, in := range tc {
+			_, err := codec.ConvertLn([]byte(in))
+			require.NoError(t, err)
+		}
+		return codec
+	}
+
+	// overlaps are reported, the highest location ID winning conflicts
+	codec := newCodec(false)
+	_, err := codec.Acc.MarshalMap()
+	require.NoError(t, err)
+	overlaps := codec.Acc.Ranger.Overlaps()
+	require.Len(t, overlaps, 2)
+	require.Equal(t, OverlapRedundant, overlaps[0].Kind)
+	require.Equal(t, Lmap("m1"), overlaps[0].Map)
+	require.Equal(t, OverlapConflict, overlaps[1].Kind)
+	require.Equal(t, "192.168.1.0/24", overlaps[1].Subnet)
+
+	// conflicts fail in strict mode
+	_, err = newCodec(true).Acc.MarshalMap()
+	require.ErrorIs(t, err, ErrLocationConflict)
+	require.ErrorContains(t, err, "192.168.1.0/24")
+
+	s, err := newCodec(true).Acc.OpenScanner()
+	require.NoError(t, err)
+	for s.Scan() {
+	}
+	require.ErrorIs(t, s.Err(), ErrLocationConflict)
+}
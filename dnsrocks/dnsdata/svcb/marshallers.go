@@ -20,8 +20,10 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"regexp"
 	"sort"
 	"strconv"
+	"unicode/utf8"
 )
 
 // this file should only include value marshallers for
@@ -50,24 +52,20 @@ import (
 // +-----------------+------------------+
 // | ipv6hint        | Yes              |
 // +-----------------+------------------+
+// | dohpath         | No               |
+// +-----------------+------------------+
+// | keyNNNNN        | No               |
+// +-----------------+------------------+
 type valueMarshaller = func([]byte) ([]byte, error)
 
 func mandatoryMarshaller(input []byte) ([]byte, error) {
 	values := bytes.Split(input, valueDelimInternal)
-	sort.SliceStable(values, func(i, j int) bool {
-		inum := strToParamNum[string(values[i])]
-		jnum := strToParamNum[string(values[j])]
-		return uint16(inum) < uint16(jnum)
-	})
-
-	var buf bytes.Buffer
-	buf.Grow(len(values) * 2)
-
+	knums := make([]paramNum, 0, len(values))
 	seen := make(map[paramNum]struct{})
 
 	for _, value := range values {
-		knum, ok := strToParamNum[string(value)]
-		if !ok {
+		knum, err := parseParamNum(value)
+		if err != nil {
 			return nil, fmt.Errorf("%s is not a valid mandatory value", input)
 		} else if knum == mandatory {
 			return nil, errors.New("mandatory itself cannot be mandatory")
@@ -77,6 +75,15 @@ func mandatoryMarshaller(input []byte) ([]byte, error) {
 			return nil, fmt.Errorf("%s in mandatory values has appeared more than once", value)
 		}
 		seen[knum] = struct{}{}
+		knums = append(knums, knum)
+	}
+	sort.Slice(knums, func(i, j int) bool {
+		return knums[i] < knums[j]
+	})
+
+	var buf bytes.Buffer
+	buf.Grow(len(knums) * 2)
+	for _, knum := range knums {
 		err := binary.Write(&buf, binary.BigEndian, uint16(knum))
 		if err != nil {
 			return nil, err
@@ -138,15 +145,47 @@ func ipv4hintMarshaller(input []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// ech is a base64 encoded ECHConfigList
 func echMarshaller(input []byte) ([]byte, error) {
 	output := make([]byte, base64.StdEncoding.DecodedLen(len(input)))
 	size, err := base64.StdEncoding.Decode(output, input)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkECHConfigList(output[:size]); err != nil {
+		return nil, fmt.Errorf("%s is not a valid ECHConfigList: %w", input, err)
+	}
 	return output[:size], nil
 }
 
+// checkECHConfigList checks the framing of an ECHConfigList: a length
+// prefixed list of at least one ECHConfig, each one being a version followed
+// by length prefixed contents. The contents themselves are left to clients.
+func checkECHConfigList(list []byte) error {
+	if len(list) < 2 {
+		return errors.New("missing length")
+	}
+	configs := list[2:]
+	if int(binary.BigEndian.Uint16(list)) != len(configs) {
+		return errors.New("length does not match")
+	}
+	if len(configs) == 0 {
+		return errors.New("no ECHConfig")
+	}
+	for len(configs) > 0 {
+		// 2 bytes of version, 2 bytes of length
+		if len(configs) < 4 {
+			return errors.New("truncated ECHConfig")
+		}
+		l := 4 + int(binary.BigEndian.Uint16(configs[2:4]))
+		if l > len(configs) {
+			return errors.New("truncated ECHConfig")
+		}
+		configs = configs[l:]
+	}
+	return nil
+}
+
 func ipv6hintMarshaller(input []byte) ([]byte, error) {
 	ipv6s := bytes.Split(input, valueDelimInternal)
 
@@ -168,3 +207,65 @@ func ipv6hintMarshaller(input []byte) ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+// dohpath is a relative URI template with a dns variable, see RFC 9461
+func dohpathMarshaller(input []byte) ([]byte, error) {
+	if !utf8.Valid(input) {
+		return nil, fmt.Errorf("dohpath %q is not valid UTF-8", input)
+	}
+	if len(input) == 0 || input[0] != '/' {
+		return nil, fmt.Errorf("dohpath %q must be a relative URI starting with /", input)
+	}
+	for _, expr := range uriTemplateExpr.FindAllSubmatch(input, -1) {
+		// drop the operator, then the modifiers of every variable
+		vars := bytes.TrimLeft(expr[1], "+#./;?&")
+		for _, v := range bytes.Split(vars, []byte(",")) {
+			if i := bytes.IndexAny(v, ":*"); i >= 0 {
+				v = v[:i]
+			}
+			if string(v) == "dns" {
+				return input, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("dohpath %s has no dns variable", input)
+}
+
+// uriTemplateExpr matches the expressions of URI templates, see RFC 6570
+var uriTemplateExpr = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// the values of keys without name are character strings, bytes being
+// written as is or escaped as \DDD, and other characters escaped as \X
+func genericMarshaller(input []byte) ([]byte, error) {
+	output := make([]byte, 0, len(input))
+	for i := 0; i < len(input); i++ {
+		if input[i] != '\\' {
+			output = append(output, input[i])
+			continue
+		}
+		if i+1 == len(input) {
+			return nil, fmt.Errorf("%s ends with an escape", input)
+		}
+		if i+3 < len(input) && isDigits(input[i+1:i+4]) {
+			b, err := strconv.ParseUint(string(input[i+1:i+4]), 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid escape in %s: %w", input, err)
+			}
+			output = append(output, byte(b))
+			i += 3
+			continue
+		}
+		output = append(output, input[i+1])
+		i++
+	}
+	return output, nil
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -18,6 +18,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"sort"
+	"strconv"
 )
 
 type paramNum uint16
@@ -30,8 +31,15 @@ const (
 	ipv4hint      paramNum = 4
 	ech           paramNum = 5
 	ipv6hint      paramNum = 6
+	dohpath       paramNum = 7
+	// invalidKey is reserved, see RFC 9460 section 14.3.2
+	invalidKey paramNum = 65535
 )
 
+// genericKeyPrefix prefixes the number of keys in their generic
+// presentation format, keyNNNNN, which is the only one of keys without name
+const genericKeyPrefix = "key"
+
 var (
 	paramNumToStr = map[paramNum]string{
 		mandatory:     "mandatory",
@@ -41,6 +49,7 @@ var (
 		ipv4hint:      "ipv4hint",
 		ech:           "ech",
 		ipv6hint:      "ipv6hint",
+		dohpath:       "dohpath",
 	}
 	strToParamNum = reverseParamNumToStr()
 )
@@ -71,6 +80,7 @@ var (
 		ech:           echMarshaller,
 		port:          portMarshaller,
 		nodefaultalpn: nodefaultalpnMarshaller,
+		dohpath:       dohpathMarshaller,
 	}
 
 	// valueUnmarshallers maps the human readable
@@ -83,6 +93,7 @@ var (
 		ech:           echUnmarshaller,
 		port:          portUnmarshaller,
 		nodefaultalpn: nodefaultalpnUnmarshaller,
+		dohpath:       dohpathUnmarshaller,
 	}
 )
 
@@ -102,6 +113,52 @@ func reverseParamNumToStr() map[string]paramNum {
 	return m
 }
 
+// String returns the name of the key, keyNNNNN for keys without name
+func (k paramNum) String() string {
+	if name, ok := paramNumToStr[k]; ok {
+		return name
+	}
+	return genericKeyPrefix + strconv.Itoa(int(k))
+}
+
+// parseParamNum parses the name of a key, or its generic keyNNNNN form,
+// NNNNN being its number without leading zeros
+func parseParamNum(name []byte) (paramNum, error) {
+	if knum, ok := strToParamNum[string(name)]; ok {
+		return knum, nil
+	}
+	digits, ok := bytes.CutPrefix(name, []byte(genericKeyPrefix))
+	if !ok {
+		return 0, fmt.Errorf("unknown SVCB/HTTPS parameter key: %s", name)
+	}
+	n, err := strconv.ParseUint(string(digits), 10, 16)
+	if err != nil || strconv.FormatUint(n, 10) != string(digits) {
+		return 0, fmt.Errorf("invalid SVCB/HTTPS parameter key: %s", name)
+	}
+	if paramNum(n) == invalidKey {
+		return 0, fmt.Errorf("SVCB/HTTPS parameter key %s is reserved", name)
+	}
+	return paramNum(n), nil
+}
+
+// marshaller returns the value marshaller of the key, the generic one for
+// keys without name
+func (k paramNum) marshaller() valueMarshaller {
+	if m, ok := valueMarshallers[k]; ok {
+		return m
+	}
+	return genericMarshaller
+}
+
+// unmarshaller returns the value unmarshaller of the key, the generic one
+// for keys without name
+func (k paramNum) unmarshaller() valueUnmarshaller {
+	if u, ok := valueUnmarshallers[k]; ok {
+		return u
+	}
+	return genericUnmarshaller
+}
+
 // fromText parses the `text` (should be in TinyDNS format)
 // and saves the wire format of the svcparamkey and svcparamvalue
 // in param key and value
@@ -112,21 +169,24 @@ func (p *param) fromText(text []byte) error {
 	}
 	k, v := parsed[0], parsed[1]
 
-	knum, ok := strToParamNum[string(k)]
-	if !ok {
-		return fmt.Errorf("unknown SVCB/HTTPS parameter: %s", text)
+	knum, err := parseParamNum(k)
+	if err != nil {
+		return err
 	}
 
-	if knum != nodefaultalpn && len(v) == 0 {
+	// the values of keys without name are opaque, and may be empty
+	_, named := paramNumToStr[knum]
+	if named && knum != nodefaultalpn && len(v) == 0 {
 		return fmt.Errorf("value for %s cannot be empty", k)
 	}
 
-	// if we can find knum, we should be able to
-	// find its value marshaller
-	data, err := valueMarshallers[knum](bytes.Trim(v, "\""))
+	data, err := knum.marshaller()(bytes.Trim(v, "\""))
 	if err != nil {
 		return err
 	}
+	if len(data) > 65535 {
+		return fmt.Errorf("value for %s is too long", k)
+	}
 
 	p.value = data
 	p.keynum = knum
@@ -137,10 +197,10 @@ func (p *param) fromText(text []byte) error {
 // since the data saved by param are processed, we should
 // trust them and therefore toText won't return error
 func (p *param) toText(buf *bytes.Buffer) {
-	buf.WriteString(paramNumToStr[p.keynum])
+	buf.WriteString(p.keynum.String())
 	buf.Write(kvSeparator)
 
-	printer := valueUnmarshallers[p.keynum]
+	printer := p.keynum.unmarshaller()
 	buf.WriteByte('"')
 	printer(p.value, buf)
 	buf.WriteByte('"')
@@ -204,11 +264,7 @@ func (l *ParamList) FromText(rawparams []byte) error {
 			key := binary.BigEndian.Uint16(mandatorylist[bindex : bindex+2])
 			_, haskey := seen[paramNum(key)]
 			if !haskey {
-				// we can directly query the map paramNumToStr
-				// because this key, although is missing in the list,
-				// is at least a valid SVCB parameter key (has processed
-				// by fromText and mandatoryMarshaller)
-				return fmt.Errorf("%s is mandatory but missing in parameter list", paramNumToStr[paramNum(key)])
+				return fmt.Errorf("%s is mandatory but missing in parameter list", paramNum(key))
 			}
 		}
 	}
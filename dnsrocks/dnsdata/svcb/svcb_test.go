@@ -99,13 +99,74 @@ var paramTestCases = []testCase{
 	},
 	{
 		// this test case is not from RFC
-		// note that the ech base64 includes two "="s for padding
-		input: []byte("ech=\"dHJhZmZpYw==\""),
-		text:  []byte("ech=\"dHJhZmZpYw==\""),
+		input: []byte("ech=\"AAr+DQAGY29uZmln\""),
+		text:  []byte("ech=\"AAr+DQAGY29uZmln\""),
 		wire: []byte{
 			0, 5, // key type ech
-			0, 7, // length = 7
-			't', 'r', 'a', 'f', 'f', 'i', 'c', // the ech public key is "traffic"
+			0, 12, // length = 12
+			0, 10, // ECHConfigList length = 10
+			0xfe, 0x0d, // ECHConfig version
+			0, 6, // ECHConfig length = 6
+			'c', 'o', 'n', 'f', 'i', 'g', // the ECHConfig contents are "config"
+		},
+	},
+	{
+		// RFC 9461 section 5
+		input: []byte("dohpath=/dns-query{?dns}"),
+		text:  []byte("dohpath=\"/dns-query{?dns}\""),
+		wire: []byte{
+			0, 7, // key type dohpath
+			0, 16, // length = 16
+			'/', 'd', 'n', 's', '-', 'q', 'u', 'e', 'r', 'y', '{', '?', 'd', 'n', 's', '}',
+		},
+	},
+	{
+		// RFC 9460 Appendix D.2, keys without name are written keyNNNNN
+		input: []byte("key667=hello"),
+		text:  []byte("key667=\"hello\""),
+		wire: []byte{
+			0x02, 0x9b, // key 667
+			0, 5, // length 5
+			'h', 'e', 'l', 'l', 'o',
+		},
+	},
+	{
+		// RFC 9460 Appendix D.2, with escapes
+		input: []byte("key667=\"hello\\210qoo\""),
+		text:  []byte("key667=\"hello\\210qoo\""),
+		wire: []byte{
+			0x02, 0x9b, // key 667
+			0, 9, // length 9
+			'h', 'e', 'l', 'l', 'o', 0xd2, 'q', 'o', 'o',
+		},
+	},
+	{
+		// delimiters of the data are escaped
+		input: []byte("key65000=a\\;b\\|c d"),
+		text:  []byte("key65000=\"a\\059b\\124c\\032d\""),
+		wire: []byte{
+			0xfd, 0xe8, // key 65000
+			0, 7, // length 7
+			'a', ';', 'b', '|', 'c', ' ', 'd',
+		},
+	},
+	{
+		// keys without name may have empty values
+		input: []byte("key8="),
+		text:  []byte("key8=\"\""),
+		wire: []byte{
+			0, 8, // key 8
+			0, 0, // length 0
+		},
+	},
+	{
+		// keys with a name may be written keyNNNNN, but are printed by name
+		input: []byte("key3=53"),
+		text:  []byte("port=\"53\""),
+		wire: []byte{
+			0, 3, // key type port
+			0, 2, // length = 2
+			0, 0x35, // port 53
 		},
 	},
 }
@@ -130,6 +191,22 @@ var paramListTestCases = []testCase{
 			0xc0, 0, 2, 1, // 192.0.2.1
 		},
 	},
+	{
+		input: []byte("key1000=x;mandatory=key1000|alpn;alpn=h2"),
+		text:  []byte("mandatory=\"alpn|key1000\";alpn=\"h2\";key1000=\"x\""),
+		wire: []byte{
+			0, 0, // key type mandatory
+			0, 4, // length 4
+			0, 1, // alpn
+			0x03, 0xe8, // key1000
+			0, 1, // key type alpn
+			0, 3, // param value length 3
+			2, 'h', '2', // h2
+			0x03, 0xe8, // key 1000
+			0, 1, // length 1
+			'x',
+		},
+	},
 	{
 		input: []byte("port=8080;no-default-alpn="),
 		text:  []byte("no-default-alpn=\"\";port=\"8080\""),
@@ -160,6 +237,21 @@ var badStrParams = [][]byte{
 	[]byte("IPv4Hint=1.2.3.4"),         // key has to be in lower case
 	[]byte("ipv4hint=face:b00c::"),     // use IPv6 address in ipv4hint
 	[]byte("ech=***bad***"),            // not a valid base64 encoded str
+	[]byte("ech=dHJhZmZpYw=="),         // not an ECHConfigList
+	[]byte("ech=AAA="),                 // ECHConfigList without ECHConfig
+	[]byte("ech=AAr+DQAHY29uZmln"),     // ECHConfig longer than the list
+	[]byte("dohpath=/dns-query"),       // dohpath without dns variable
+	[]byte("dohpath=/q{?name}"),        // dohpath without dns variable
+	[]byte("dohpath=https://x/{?dns}"), // dohpath has to be relative
+	[]byte("dohpath=/\xff{?dns}"),      // dohpath has to be UTF-8
+	[]byte("dohpath="),                 // dohpath can't be empty
+	[]byte("dohpath=\"\""),             // dohpath can't be empty
+	[]byte("key65535=x"),               // reserved key
+	[]byte("key65536=x"),               // out of range key
+	[]byte("key007=x"),                 // keys have no leading zeros
+	[]byte("key=x"),                    // key without number
+	[]byte("key9=x\\"),                 // trailing escape
+	[]byte("key9=\\256"),               // escaped value out of range
 	[]byte("mandatory=foo|bar"),        // invalid keys in mandatory
 	[]byte("mandatory=alpn|alpn"),      // values in mandatory have to be unique
 	[]byte("mandatory=ALPN|IPv4Hint"),  // values in mandatory have to be lowercased (just like the param keys)
@@ -174,6 +266,8 @@ var badTinyDNSRecords = [][]byte{
 	[]byte("mandatory=ech;ipv4hint=facebook"),
 	[]byte("ipv4hint=1.2.3.4;ipv4hint=2.3.4.5"), // keys are not unique (should aggregate)
 	[]byte("mandatory=ipv4hint|alpn;alpn=h2"),   // a mandatory key is missing
+	[]byte("mandatory=key9;alpn=h2"),            // a mandatory key without name is missing
+	[]byte("key3=53;port=53"),                   // keys are not unique, whatever their spelling
 	[]byte("port=8080,no-default-alpn="),        // didn't use ; to split svcparams
 }
 
@@ -240,6 +334,26 @@ func TestParamList(t *testing.T) {
 	}
 }
 
+func TestParamListOrder(t *testing.T) {
+	// the wire format does not depend on the order params are written in
+	inputs := [][]byte{
+		[]byte("alpn=h2;port=443;ipv6hint=2001:db8::1;mandatory=port|alpn"),
+		[]byte("mandatory=alpn|port;ipv6hint=2001:db8::1;port=443;alpn=h2"),
+		[]byte("port=443;mandatory=port|alpn;alpn=h2;ipv6hint=2001:db8::1"),
+	}
+	var expected []byte
+	for _, input := range inputs {
+		l := ParamList{}
+		require.NoError(t, l.FromText(input))
+		var wireout bytes.Buffer
+		require.NoError(t, l.ToWire(&wireout))
+		if expected == nil {
+			expected = wireout.Bytes()
+		}
+		require.Equal(t, expected, wireout.Bytes(), "input: %s", input)
+	}
+}
+
 func TestBadParam(t *testing.T) {
 	for _, bad := range badStrParams {
 		kv := param{}
@@ -17,6 +17,7 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/binary"
+	"fmt"
 	"net"
 	"strconv"
 )
@@ -38,7 +39,7 @@ func mandatoryUnmarshaller(input []byte, out *bytes.Buffer) {
 			out.Write(valueDelimInternal)
 		}
 		rawnum := binary.BigEndian.Uint16(input[offset : offset+2])
-		out.WriteString(paramNumToStr[paramNum(rawnum)])
+		out.WriteString(paramNum(rawnum).String())
 	}
 }
 
@@ -88,3 +89,22 @@ func ipv6hintUnmarshaller(input []byte, out *bytes.Buffer) {
 		out.WriteString(net.IP(input[offset : offset+net.IPv6len]).To16().String())
 	}
 }
+
+func dohpathUnmarshaller(input []byte, out *bytes.Buffer) {
+	out.Write(input)
+}
+
+// genericUnmarshaller escapes the bytes which are not printable, or are
+// delimiters of the data, as \DDD
+func genericUnmarshaller(input []byte, out *bytes.Buffer) {
+	for _, b := range input {
+		if b <= ' ' || b >= 0x7f || bytes.IndexByte(genericEscaped, b) >= 0 {
+			fmt.Fprintf(out, "\\%03d", b)
+			continue
+		}
+		out.WriteByte(b)
+	}
+}
+
+// genericEscaped are the printable characters escaped by genericUnmarshaller
+var genericEscaped = []byte("\"\\;|,:")
@@ -0,0 +1,230 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dnsjson represents DNS messages in the JSON format of RFC 8427, for
+// logs and debugging tools to share a standard schema.
+package dnsjson
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Message is a DNS message as a RFC 8427 JSON object. Flags are 0 or 1, like
+// in the examples of the RFC.
+type Message struct {
+	ID      uint16 `json:"ID"`
+	QR      int    `json:"QR"`
+	Opcode  int    `json:"Opcode"`
+	AA      int    `json:"AA"`
+	TC      int    `json:"TC"`
+	RD      int    `json:"RD"`
+	RA      int    `json:"RA"`
+	AD      int    `json:"AD"`
+	CD      int    `json:"CD"`
+	RCODE   int    `json:"RCODE"`
+	QDCOUNT int    `json:"QDCOUNT"`
+	ANCOUNT int    `json:"ANCOUNT"`
+	NSCOUNT int    `json:"NSCOUNT"`
+	ARCOUNT int    `json:"ARCOUNT"`
+	// QNAME, QTYPE and QCLASS are the ones of the first question, if any
+	QNAME         string     `json:"QNAME,omitempty"`
+	QTYPE         uint16     `json:"QTYPE,omitempty"`
+	QTYPEname     string     `json:"QTYPEname,omitempty"`
+	QCLASS        uint16     `json:"QCLASS,omitempty"`
+	QCLASSname    string     `json:"QCLASSname,omitempty"`
+	QuestionRRs   []Question `json:"questionRRs,omitempty"`
+	AnswerRRs     []RR       `json:"answerRRs,omitempty"`
+	AuthorityRRs  []RR       `json:"authorityRRs,omitempty"`
+	AdditionalRRs []RR       `json:"additionalRRs,omitempty"`
+	// MessageOctetsHEX is the message in wire format, when it was parsed
+	// from it
+	MessageOctetsHEX string `json:"messageOctetsHEX,omitempty"`
+	// DateString and DateSeconds are when the message was sent or received,
+	// if known, see SetDate
+	DateString  string  `json:"dateString,omitempty"`
+	DateSeconds float64 `json:"dateSeconds,omitempty"`
+	Comment     string  `json:"comment,omitempty"`
+}
+
+// Question is an entry of the question section
+type Question struct {
+	NAME      string `json:"NAME"`
+	TYPE      uint16 `json:"TYPE"`
+	TYPEname  string `json:"TYPEname,omitempty"`
+	CLASS     uint16 `json:"CLASS"`
+	CLASSname string `json:"CLASSname,omitempty"`
+}
+
+// RR is a resource record. Rdata, the presentation format of the record
+// data, is serialized as the rdata<TYPEname> member, e.g. rdataA; OPT
+// records and records of unknown types only have RDATAHEX.
+type RR struct {
+	NAME      string `json:"NAME"`
+	TYPE      uint16 `json:"TYPE"`
+	TYPEname  string `json:"TYPEname,omitempty"`
+	CLASS     uint16 `json:"CLASS"`
+	CLASSname string `json:"CLASSname,omitempty"`
+	TTL       uint32 `json:"TTL"`
+	RDLENGTH  int    `json:"RDLENGTH"`
+	RDATAHEX  string `json:"RDATAHEX"`
+	Rdata     string `json:"-"`
+}
+
+// MarshalJSON implements json.Marshaler, adding the rdata<TYPEname> member
+func (rr RR) MarshalJSON() ([]byte, error) {
+	type plain RR
+	b, err := json.Marshal(plain(rr))
+	if err != nil || rr.Rdata == "" || rr.TYPEname == "" {
+		return b, err
+	}
+	rdata, err := json.Marshal(map[string]string{"rdata" + rr.TYPEname: rr.Rdata})
+	if err != nil {
+		return nil, err
+	}
+	// merge both objects
+	return append(append(b[:len(b)-1], ','), rdata[1:]...), nil
+}
+
+func flag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// FromMsg returns the RFC 8427 representation of m
+func FromMsg(m *dns.Msg) (*Message, error) {
+	j := &Message{
+		ID:      m.Id,
+		QR:      flag(m.Response),
+		Opcode:  m.Opcode,
+		AA:      flag(m.Authoritative),
+		TC:      flag(m.Truncated),
+		RD:      flag(m.RecursionDesired),
+		RA:      flag(m.RecursionAvailable),
+		AD:      flag(m.AuthenticatedData),
+		CD:      flag(m.CheckingDisabled),
+		RCODE:   m.Rcode & 0xf, // the upper bits are in the OPT record
+		QDCOUNT: len(m.Question),
+		ANCOUNT: len(m.Answer),
+		NSCOUNT: len(m.Ns),
+		ARCOUNT: len(m.Extra),
+	}
+	for _, q := range m.Question {
+		j.QuestionRRs = append(j.QuestionRRs, Question{
+			NAME:      q.Name,
+			TYPE:      q.Qtype,
+			TYPEname:  dns.TypeToString[q.Qtype],
+			CLASS:     q.Qclass,
+			CLASSname: dns.ClassToString[q.Qclass],
+		})
+	}
+	if len(j.QuestionRRs) > 0 {
+		q := j.QuestionRRs[0]
+		j.QNAME, j.QTYPE, j.QTYPEname, j.QCLASS, j.QCLASSname = q.NAME, q.TYPE, q.TYPEname, q.CLASS, q.CLASSname
+	}
+	var err error
+	if j.AnswerRRs, err = fromRRs(m.Answer); err != nil {
+		return nil, err
+	}
+	if j.AuthorityRRs, err = fromRRs(m.Ns); err != nil {
+		return nil, err
+	}
+	if j.AdditionalRRs, err = fromRRs(m.Extra); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// FromWire returns the RFC 8427 representation of the message b in wire
+// format, including its octets
+func FromWire(b []byte) (*Message, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return nil, err
+	}
+	j, err := FromMsg(m)
+	if err != nil {
+		return nil, err
+	}
+	j.MessageOctetsHEX = strings.ToUpper(hex.EncodeToString(b))
+	return j, nil
+}
+
+// SetDate sets the date members of j to t
+func (j *Message) SetDate(t time.Time) {
+	j.DateString = t.UTC().Format(time.RFC3339Nano)
+	j.DateSeconds = float64(t.UnixNano()) / float64(time.Second)
+}
+
+func fromRRs(rrs []dns.RR) ([]RR, error) {
+	if len(rrs) == 0 {
+		return nil, nil
+	}
+	out := make([]RR, 0, len(rrs))
+	buf := make([]byte, dns.MaxMsgSize)
+	for _, rr := range rrs {
+		j, err := fromRR(rr, buf)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, j)
+	}
+	return out, nil
+}
+
+func fromRR(rr dns.RR, buf []byte) (RR, error) {
+	hdr := rr.Header()
+	j := RR{
+		NAME:      hdr.Name,
+		TYPE:      hdr.Rrtype,
+		TYPEname:  dns.TypeToString[hdr.Rrtype],
+		CLASS:     hdr.Class,
+		CLASSname: dns.ClassToString[hdr.Class],
+		TTL:       hdr.Ttl,
+	}
+	if hdr.Rrtype == dns.TypeOPT {
+		// the class is the UDP payload size
+		j.CLASSname = ""
+	}
+	end, err := dns.PackRR(rr, buf, 0, nil, false)
+	if err != nil {
+		return j, fmt.Errorf("packing %s record %s: %w", j.TYPEname, hdr.Name, err)
+	}
+	_, start, err := dns.UnpackDomainName(buf, 0)
+	if err != nil {
+		return j, err
+	}
+	rdata := buf[start+10 : end] // past type, class, TTL and length
+	j.RDLENGTH = len(rdata)
+	j.RDATAHEX = strings.ToUpper(hex.EncodeToString(rdata))
+	if _, ok := rr.(*dns.RFC3597); !ok && hdr.Rrtype != dns.TypeOPT {
+		j.Rdata = strings.TrimPrefix(rr.String(), hdr.String())
+	}
+	return j, nil
+}
+
+// Marshal returns the JSON encoding of m as RFC 8427
+func Marshal(m *dns.Msg) ([]byte, error) {
+	j, err := FromMsg(m)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(j)
+}
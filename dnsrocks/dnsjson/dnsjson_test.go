@@ -0,0 +1,87 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsjson
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshal(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.com.", dns.TypeA)
+	m.Id = 1234
+	m.Response = true
+	m.Authoritative = true
+	m.Answer = []dns.RR{
+		&dns.CNAME{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60}, Target: "a.example.com."},
+		&dns.A{Hdr: dns.RR_Header{Name: "a.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: []byte{192, 0, 2, 1}},
+	}
+	m.SetEdns0(1232, false)
+	m.Rcode = dns.RcodeBadVers
+
+	b, err := Marshal(m)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"ID": 1234, "QR": 1, "Opcode": 0, "AA": 1, "TC": 0, "RD": 1, "RA": 0, "AD": 0, "CD": 0, "RCODE": 0,
+		"QDCOUNT": 1, "ANCOUNT": 2, "NSCOUNT": 0, "ARCOUNT": 1,
+		"QNAME": "www.example.com.", "QTYPE": 1, "QTYPEname": "A", "QCLASS": 1, "QCLASSname": "IN",
+		"questionRRs": [{"NAME": "www.example.com.", "TYPE": 1, "TYPEname": "A", "CLASS": 1, "CLASSname": "IN"}],
+		"answerRRs": [
+			{"NAME": "www.example.com.", "TYPE": 5, "TYPEname": "CNAME", "CLASS": 1, "CLASSname": "IN", "TTL": 60,
+			 "RDLENGTH": 15, "RDATAHEX": "0161076578616D706C6503636F6D00", "rdataCNAME": "a.example.com."},
+			{"NAME": "a.example.com.", "TYPE": 1, "TYPEname": "A", "CLASS": 1, "CLASSname": "IN", "TTL": 300,
+			 "RDLENGTH": 4, "RDATAHEX": "C0000201", "rdataA": "192.0.2.1"}
+		],
+		"additionalRRs": [
+			{"NAME": ".", "TYPE": 41, "TYPEname": "OPT", "CLASS": 1232, "TTL": 0, "RDLENGTH": 0, "RDATAHEX": ""}
+		]
+	}`, string(b))
+}
+
+func TestFromWire(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeTXT)
+	m.Id = 1
+	m.Answer = []dns.RR{
+		&dns.TXT{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 1}, Txt: []string{"hello world"}},
+		&dns.RFC3597{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: 65280, Class: dns.ClassINET, Ttl: 1}, Rdata: "abcd"},
+	}
+	wire, err := m.Pack()
+	require.NoError(t, err)
+
+	j, err := FromWire(wire)
+	require.NoError(t, err)
+	require.Len(t, j.MessageOctetsHEX, 2*len(wire))
+	require.Equal(t, "TXT", j.QTYPEname)
+	require.Equal(t, `"hello world"`, j.AnswerRRs[0].Rdata)
+	require.Equal(t, RR{NAME: "example.com.", TYPE: 65280, CLASS: 1, CLASSname: "IN", TTL: 1, RDLENGTH: 2, RDATAHEX: "ABCD"}, j.AnswerRRs[1])
+
+	now := time.Unix(1700000000, 500000000)
+	j.SetDate(now)
+	b, err := json.Marshal(j)
+	require.NoError(t, err)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &fields))
+	require.Equal(t, "2023-11-14T22:13:20.5Z", fields["dateString"])
+	require.Equal(t, 1700000000.5, fields["dateSeconds"])
+	require.Equal(t, `"hello world"`, fields["answerRRs"].([]interface{})[0].(map[string]interface{})["rdataTXT"])
+
+	_, err = FromWire(wire[:5])
+	require.Error(t, err)
+}
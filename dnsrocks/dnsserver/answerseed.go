@@ -0,0 +1,103 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultAnswerSeedWindow is the default duration of the time buckets of
+// answer seeds.
+const DefaultAnswerSeedWindow = time.Minute
+
+// AnswerSeedConfig controls how the weighted random sampling of A and AAAA
+// answers is seeded. With a key, it derives from a keyed hash of the query
+// name, the client subnet and a time bucket, so that answers are reproducible
+// for debugging and stable for a client within a bucket. Without one, every
+// query samples at random.
+type AnswerSeedConfig struct {
+	// Key is the HMAC key of the hash. Empty disables seeding.
+	Key []byte
+	// Window is the duration of the time buckets. 0 means
+	// DefaultAnswerSeedWindow.
+	Window time.Duration
+}
+
+// answerSeeder applies a validated AnswerSeedConfig.
+type answerSeeder struct {
+	key    []byte
+	window time.Duration
+	v4Mask net.IPMask
+	v6Mask net.IPMask
+}
+
+// newAnswerSeeder validates c and returns the matching seeder, or nil when
+// seeding is disabled.
+func newAnswerSeeder(c AnswerSeedConfig) (*answerSeeder, error) {
+	if c.Window < 0 {
+		return nil, fmt.Errorf("invalid answer seed window %v", c.Window)
+	}
+	if len(c.Key) == 0 {
+		return nil, nil
+	}
+	window := c.Window
+	if window == 0 {
+		window = DefaultAnswerSeedWindow
+	}
+	return &answerSeeder{
+		key:    c.Key,
+		window: window,
+		v4Mask: net.CIDRMask(DefaultPrivacyIPv4PrefixLen, 8*net.IPv4len),
+		v6Mask: net.CIDRMask(DefaultPrivacyIPv6PrefixLen, 8*net.IPv6len),
+	}, nil
+}
+
+// clientSubnet returns the subnet a query is seeded for: the one of its ECS
+// option if any, else the truncated resolver address.
+func (s *answerSeeder) clientSubnet(ecs *dns.EDNS0_SUBNET, resolverIP string) string {
+	if ecs != nil {
+		return ecsSubnet(ecs)
+	}
+	ip := net.ParseIP(resolverIP)
+	if ip == nil {
+		return resolverIP
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(s.v4Mask).String()
+	}
+	return ip.Mask(s.v6Mask).String()
+}
+
+// seed returns the seed of the weighted random sampling of the answers to
+// qname, for the client subnet of ecs or resolverIP, at time now.
+func (s *answerSeeder) seed(qname string, ecs *dns.EDNS0_SUBNET, resolverIP string, now time.Time) uint64 {
+	var bucket [8]byte
+	binary.BigEndian.PutUint64(bucket[:], uint64(now.UnixNano()/int64(s.window)))
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(strings.ToLower(qname)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(s.clientSubnet(ecs, resolverIP)))
+	mac.Write([]byte{0})
+	mac.Write(bucket[:])
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
@@ -0,0 +1,121 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestNewAnswerSeeder(t *testing.T) {
+	s, err := newAnswerSeeder(AnswerSeedConfig{})
+	require.NoError(t, err)
+	require.Nil(t, s)
+
+	s, err = newAnswerSeeder(AnswerSeedConfig{Key: []byte("key")})
+	require.NoError(t, err)
+	require.Equal(t, DefaultAnswerSeedWindow, s.window)
+
+	_, err = newAnswerSeeder(AnswerSeedConfig{Key: []byte("key"), Window: -time.Second})
+	require.Error(t, err)
+}
+
+func TestAnswerSeed(t *testing.T) {
+	s, err := newAnswerSeeder(AnswerSeedConfig{Key: []byte("key"), Window: time.Minute})
+	require.NoError(t, err)
+	now := time.Unix(1699999980, 0)
+	ecs := &dns.EDNS0_SUBNET{Family: 1, Address: net.ParseIP("192.0.2.77").To4(), SourceNetmask: 24}
+	seed := s.seed("www.example.com.", ecs, "198.51.100.1", now)
+
+	// same query name, ignoring case, client subnet and time bucket
+	require.Equal(t, seed, s.seed("WWW.example.com.", ecs, "198.51.100.1", now))
+	sameSubnet := &dns.EDNS0_SUBNET{Family: 1, Address: net.ParseIP("192.0.2.1").To4(), SourceNetmask: 24}
+	require.Equal(t, seed, s.seed("www.example.com.", sameSubnet, "203.0.113.1", now))
+	require.Equal(t, seed, s.seed("www.example.com.", ecs, "198.51.100.1", now.Add(59*time.Second)))
+
+	require.NotEqual(t, seed, s.seed("foo.example.com.", ecs, "198.51.100.1", now))
+	otherSubnet := &dns.EDNS0_SUBNET{Family: 1, Address: net.ParseIP("192.0.3.77").To4(), SourceNetmask: 24}
+	require.NotEqual(t, seed, s.seed("www.example.com.", otherSubnet, "198.51.100.1", now))
+	require.NotEqual(t, seed, s.seed("www.example.com.", ecs, "198.51.100.1", now.Add(time.Minute)))
+
+	other, err := newAnswerSeeder(AnswerSeedConfig{Key: []byte("other key"), Window: time.Minute})
+	require.NoError(t, err)
+	require.NotEqual(t, seed, other.seed("www.example.com.", ecs, "198.51.100.1", now))
+
+	// without ECS, resolvers of the same truncated subnet share seeds
+	require.Equal(t,
+		s.seed("www.example.com.", nil, "198.51.100.1", now),
+		s.seed("www.example.com.", nil, "198.51.100.200", now),
+	)
+	require.NotEqual(t,
+		s.seed("www.example.com.", nil, "198.51.100.1", now),
+		s.seed("www.example.com.", nil, "198.51.101.1", now),
+	)
+	require.Equal(t,
+		s.seed("www.example.com.", nil, "2001:db8:1::1", now),
+		s.seed("www.example.com.", nil, "2001:db8:1:2::1", now),
+	)
+}
+
+// TestHandlerAnswerSeed checks that seeded handlers give the same weighted
+// answers to the same client subnet, and spread different ones
+func TestHandlerAnswerSeed(t *testing.T) {
+	for _, db := range testaid.TestDBs {
+		t.Run(db.Driver, func(t *testing.T) {
+			th := OpenDbForTesting(t, &db)
+			defer th.Close()
+			var err error
+			th.seeder, err = newAnswerSeeder(AnswerSeedConfig{Key: []byte("key"), Window: time.Hour})
+			require.NoError(t, err)
+
+			query := func(subnet string) string {
+				req := new(dns.Msg)
+				req.SetQuestion("wrr.example.com.", dns.TypeA)
+				req.SetEdns0(4096, false)
+				opt := req.IsEdns0()
+				opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+					Code:          dns.EDNS0SUBNET,
+					Family:        1,
+					Address:       net.ParseIP(subnet).To4(),
+					SourceNetmask: 24,
+				})
+				rec := dnstest.NewRecorder(&test.ResponseWriter{})
+				_, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+				require.NoError(t, err)
+				require.Len(t, rec.Msg.Answer, 1)
+				return rec.Msg.Answer[0].(*dns.A).A.String()
+			}
+
+			seen := make(map[string]bool)
+			for i := 0; i < 50; i++ {
+				subnet := fmt.Sprintf("10.0.%d.0", i)
+				ip := query(subnet)
+				for j := 0; j < 5; j++ {
+					require.Equal(t, ip, query(subnet), "subnet %s", subnet)
+				}
+				seen[ip] = true
+			}
+			require.Greater(t, len(seen), 1)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// Overflow policies, for responses with more records than their budget.
+const (
+	// OverflowTruncate drops the answer records over budget and sets the TC
+	// bit of UDP responses, so that clients can retry over TCP. Additional
+	// records over budget are dropped silently.
+	OverflowTruncate = "truncate"
+	// OverflowTrim drops the records over budget silently.
+	OverflowTrim = "trim"
+	// OverflowPreferAAAA drops A records over budget before any other, then
+	// trims silently.
+	OverflowPreferAAAA = "prefer-aaaa"
+)
+
+type answerBudgetKey struct{}
+
+// AnswerBudget caps the number of records of responses
+type AnswerBudget struct {
+	// MaxAnswer is the number of records picked from weighted A and AAAA
+	// RRsets, DefaultMaxAnswer if 0
+	MaxAnswer int
+	// MaxAnswerRecords caps the number of records of the answer section, 0
+	// for no cap
+	MaxAnswerRecords int
+	// MaxAdditionalRecords caps the number of records of the additional
+	// section, OPT excluded, 0 for no cap
+	MaxAdditionalRecords int
+	// Overflow is the policy of responses over budget, one of
+	// OverflowTruncate (default), OverflowTrim or OverflowPreferAAAA
+	Overflow string
+}
+
+func (b AnswerBudget) validate() error {
+	if b.MaxAnswer < 0 || b.MaxAnswerRecords < 0 || b.MaxAdditionalRecords < 0 {
+		return fmt.Errorf("invalid answer budget %+v", b)
+	}
+	switch b.Overflow {
+	case "", OverflowTruncate, OverflowTrim, OverflowPreferAAAA:
+		return nil
+	}
+	return fmt.Errorf("unknown overflow policy %q", b.Overflow)
+}
+
+// merge returns b with the fields set in o
+func (b AnswerBudget) merge(o AnswerBudget) AnswerBudget {
+	if o.MaxAnswer > 0 {
+		b.MaxAnswer = o.MaxAnswer
+	}
+	if o.MaxAnswerRecords > 0 {
+		b.MaxAnswerRecords = o.MaxAnswerRecords
+	}
+	if o.MaxAdditionalRecords > 0 {
+		b.MaxAdditionalRecords = o.MaxAdditionalRecords
+	}
+	if o.Overflow != "" {
+		b.Overflow = o.Overflow
+	}
+	return b
+}
+
+// WithAnswerBudget makes queries served with ctx follow the fields set in b,
+// rather than the budget of the handler
+func WithAnswerBudget(ctx context.Context, b AnswerBudget) context.Context {
+	if prev, ok := GetAnswerBudget(ctx); ok {
+		b = prev.merge(b)
+	}
+	return context.WithValue(ctx, answerBudgetKey{}, b)
+}
+
+// GetAnswerBudget is used to get the answer budget from context
+func GetAnswerBudget(ctx context.Context) (AnswerBudget, bool) {
+	b, ok := ctx.Value(answerBudgetKey{}).(AnswerBudget)
+	return b, ok
+}
+
+// answerBudget returns the budget of queries served with ctx
+func (h *FBDNSDB) answerBudget(ctx context.Context) AnswerBudget {
+	b := h.handlerConfig.AnswerBudget
+	if o, ok := GetAnswerBudget(ctx); ok {
+		b = b.merge(o)
+	}
+	if b.MaxAnswer == 0 {
+		b.MaxAnswer = DefaultMaxAnswer
+	}
+	return b
+}
+
+// trimRecords drops the records of rrs beyond max, last ones first, and A
+// records before any other if preferA4. OPT records are neither counted nor
+// dropped. It returns the records kept and the number of records dropped.
+func trimRecords(rrs []dns.RR, max int, preferA4 bool) ([]dns.RR, int) {
+	n := 0
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			n++
+		}
+	}
+	excess := n - max
+	if max == 0 || excess <= 0 {
+		return rrs, 0
+	}
+	drop := make([]bool, len(rrs))
+	dropped := 0
+	if preferA4 {
+		for i := len(rrs) - 1; i >= 0 && dropped < excess; i-- {
+			if rrs[i].Header().Rrtype == dns.TypeA {
+				drop[i] = true
+				dropped++
+			}
+		}
+	}
+	for i := len(rrs) - 1; i >= 0 && dropped < excess; i-- {
+		if !drop[i] && rrs[i].Header().Rrtype != dns.TypeOPT {
+			drop[i] = true
+			dropped++
+		}
+	}
+	kept := make([]dns.RR, 0, len(rrs)-dropped)
+	for i, rr := range rrs {
+		if !drop[i] {
+			kept = append(kept, rr)
+		}
+	}
+	return kept, dropped
+}
+
+// applyBudget drops the records of resp over budget following its overflow
+// policy, and sets the TC bit of truncated UDP responses
+func (h *FBDNSDB) applyBudget(resp *dns.Msg, b AnswerBudget, udp bool) {
+	preferA4 := b.Overflow == OverflowPreferAAAA
+	var dropped int
+	if resp.Answer, dropped = trimRecords(resp.Answer, b.MaxAnswerRecords, preferA4); dropped > 0 {
+		h.stats.IncrementCounter("DNS_response.budget.answer_trimmed")
+		if udp && (b.Overflow == "" || b.Overflow == OverflowTruncate) {
+			resp.Truncated = true
+			h.stats.IncrementCounter("DNS_response.budget.truncated")
+		}
+	}
+	if resp.Extra, dropped = trimRecords(resp.Extra, b.MaxAdditionalRecords, preferA4); dropped > 0 {
+		h.stats.IncrementCounter("DNS_response.budget.additional_trimmed")
+	}
+}
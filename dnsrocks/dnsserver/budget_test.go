@@ -0,0 +1,184 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestAnswerBudgetValidate(t *testing.T) {
+	require.NoError(t, AnswerBudget{}.validate())
+	require.NoError(t, AnswerBudget{MaxAnswerRecords: 1, Overflow: OverflowPreferAAAA}.validate())
+	require.Error(t, AnswerBudget{MaxAdditionalRecords: -1}.validate())
+	require.Error(t, AnswerBudget{Overflow: "drop"}.validate())
+	_, err := NewFBDNSDBBasic(HandlerConfig{AnswerBudget: AnswerBudget{Overflow: "drop"}}, DBConfig{}, CacheConfig{}, &DummyLogger{}, &stats.DummyStats{})
+	require.Error(t, err)
+}
+
+func TestWithAnswerBudget(t *testing.T) {
+	ctx := WithMaxAnswer(context.Background(), 3)
+	ctx = WithAnswerBudget(ctx, AnswerBudget{MaxAnswerRecords: 5})
+	b, ok := GetAnswerBudget(ctx)
+	require.True(t, ok)
+	require.Equal(t, AnswerBudget{MaxAnswer: 3, MaxAnswerRecords: 5}, b)
+	maxAns, ok := GetMaxAnswer(ctx)
+	require.True(t, ok)
+	require.Equal(t, 3, maxAns)
+
+	_, ok = GetMaxAnswer(WithAnswerBudget(context.Background(), AnswerBudget{MaxAnswerRecords: 5}))
+	require.False(t, ok)
+
+	h := &FBDNSDB{handlerConfig: HandlerConfig{AnswerBudget: AnswerBudget{MaxAnswerRecords: 2, Overflow: OverflowTrim}}}
+	require.Equal(t, AnswerBudget{MaxAnswer: DefaultMaxAnswer, MaxAnswerRecords: 2, Overflow: OverflowTrim}, h.answerBudget(context.Background()))
+	require.Equal(t, AnswerBudget{MaxAnswer: 3, MaxAnswerRecords: 5, Overflow: OverflowTrim}, h.answerBudget(ctx))
+}
+
+func TestTrimRecords(t *testing.T) {
+	rrs := mustRRs(t,
+		"example.com. 300 IN A 192.0.2.1",
+		"example.com. 300 IN AAAA 2001:db8::1",
+		"example.com. 300 IN A 192.0.2.2",
+		"example.com. 300 IN AAAA 2001:db8::2",
+	)
+	// OPT records are neither counted nor dropped
+	rrs = append(rrs[:2], append([]dns.RR{&dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}}, rrs[2:]...)...)
+	kept, dropped := trimRecords(rrs, 0, false)
+	require.Equal(t, rrs, kept)
+	require.Zero(t, dropped)
+	kept, dropped = trimRecords(rrs, 4, false)
+	require.Equal(t, rrs, kept)
+	require.Zero(t, dropped)
+
+	kept, dropped = trimRecords(rrs, 3, false)
+	require.Equal(t, rrs[:4], kept)
+	require.Equal(t, 1, dropped)
+
+	kept, dropped = trimRecords(rrs, 2, true)
+	require.Equal(t, []dns.RR{rrs[1], rrs[2], rrs[4]}, kept)
+	require.Equal(t, 2, dropped)
+
+	kept, dropped = trimRecords(rrs, 1, true)
+	require.Equal(t, []dns.RR{rrs[1], rrs[2]}, kept)
+	require.Equal(t, 3, dropped)
+}
+
+// TestAnswerBudget checks that each overflow policy is applied to the answer
+// and additional sections of responses
+func TestAnswerBudget(t *testing.T) {
+	testCases := []struct {
+		name   string
+		budget AnswerBudget
+		qname  string
+		qtype  uint16
+		tcp    bool
+		// types of the answer and additional records, OPT excluded
+		answer     []uint16
+		additional []uint16
+		truncated  bool
+	}{
+		{
+			name:   "no budget",
+			qname:  "foo.example.org.",
+			qtype:  dns.TypeANY,
+			answer: []uint16{dns.TypeA, dns.TypeAAAA},
+		},
+		{
+			name:      "truncate",
+			budget:    AnswerBudget{MaxAnswerRecords: 1},
+			qname:     "foo.example.org.",
+			qtype:     dns.TypeANY,
+			answer:    []uint16{dns.TypeA},
+			truncated: true,
+		},
+		{
+			name:   "truncate over TCP",
+			budget: AnswerBudget{MaxAnswerRecords: 1, Overflow: OverflowTruncate},
+			qname:  "foo.example.org.",
+			qtype:  dns.TypeANY,
+			tcp:    true,
+			answer: []uint16{dns.TypeA},
+		},
+		{
+			name:   "trim",
+			budget: AnswerBudget{MaxAnswerRecords: 1, Overflow: OverflowTrim},
+			qname:  "foo.example.org.",
+			qtype:  dns.TypeANY,
+			answer: []uint16{dns.TypeA},
+		},
+		{
+			name:   "prefer AAAA",
+			budget: AnswerBudget{MaxAnswerRecords: 1, Overflow: OverflowPreferAAAA},
+			qname:  "foo.example.org.",
+			qtype:  dns.TypeANY,
+			answer: []uint16{dns.TypeAAAA},
+		},
+		{
+			name:       "additional",
+			budget:     AnswerBudget{MaxAdditionalRecords: 2, Overflow: OverflowPreferAAAA},
+			qname:      "lotofns.example.org.",
+			qtype:      dns.TypeNS,
+			additional: []uint16{dns.TypeAAAA, dns.TypeAAAA},
+		},
+	}
+	for _, testDB := range testaid.TestDBs {
+		for _, tc := range testCases {
+			t.Run(testDB.Driver+"/"+testDB.Flavour+"/"+tc.name, func(t *testing.T) {
+				dbConfig := DBConfig{Path: testDB.Path, Driver: testDB.Driver}
+				th, err := NewFBDNSDBBasic(HandlerConfig{AnswerBudget: tc.budget}, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, &stats.DummyStats{})
+				require.NoError(t, err)
+				require.NoError(t, th.Load())
+				defer th.Close()
+
+				req := new(dns.Msg)
+				req.SetQuestion(tc.qname, tc.qtype)
+				req.SetEdns0(4096, false)
+				var w dns.ResponseWriter = &test.ResponseWriterCustomRemote{RemoteIP: "1.1.1.1"}
+				if tc.tcp {
+					tw := &test.ResponseWriter{}
+					tw.TCP = true
+					w = tw
+				}
+				rec := dnstest.NewRecorder(w)
+				_, err = th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+				require.NoError(t, err)
+				require.NotNil(t, rec.Msg)
+				require.Equal(t, tc.truncated, rec.Msg.Truncated)
+
+				var answer, additional []uint16
+				for _, rr := range rec.Msg.Answer {
+					answer = append(answer, rr.Header().Rrtype)
+				}
+				for _, rr := range rec.Msg.Extra {
+					if rr.Header().Rrtype != dns.TypeOPT {
+						additional = append(additional, rr.Header().Rrtype)
+					}
+				}
+				require.Equal(t, tc.answer, answer)
+				if tc.additional != nil {
+					require.Equal(t, tc.additional, additional)
+				}
+			})
+		}
+	}
+}
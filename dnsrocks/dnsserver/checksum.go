@@ -0,0 +1,117 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+)
+
+// verifyChecksum returns true if DBs must match their checksum
+func (c DBConfig) verifyChecksum() bool {
+	return c.VerifyChecksum || len(c.ChecksumKeys) > 0
+}
+
+// loadChecksum returns the checksum of d, verified against its content if
+// verify is set, or nil if it has none and does not need to be verified
+func (h *FBDNSDB) loadChecksum(d *db.DB, verify bool) (*dnsdata.Checksum, error) {
+	if verify {
+		sum, err := d.VerifyChecksum(h.dbConfig.ChecksumKeys)
+		if err != nil {
+			return nil, fmt.Errorf("checksum verification failed: %w", err)
+		}
+		glog.Infof("DB checksum %s verified", sum)
+		return sum, nil
+	}
+	sum, err := d.Checksum()
+	if errors.Is(err, db.ErrNoChecksum) {
+		return nil, nil
+	}
+	if err != nil {
+		// the checksum is informative only, a broken one does not prevent
+		// serving the DB
+		glog.Errorf("Failed to read DB checksum: %v", err)
+		return nil, nil
+	}
+	glog.Infof("DB checksum %s, not verified", sum)
+	return sum, nil
+}
+
+// setChecksum records the checksum of the DB in use, and reports the first 6
+// bytes of its digest, as an integer, in the DNS_db.checksum stat, 0 if none
+func (h *FBDNSDB) setChecksum(sum *dnsdata.Checksum) {
+	h.checksum = sum
+	var prefix [8]byte
+	if sum != nil {
+		copy(prefix[2:], sum.Digest)
+	}
+	h.stats.ResetCounterTo("DNS_db.checksum", int64(binary.BigEndian.Uint64(prefix[:])))
+}
+
+// Checksum returns the checksum of the DB in use, or nil if it has none
+func (h *FBDNSDB) Checksum() *dnsdata.Checksum {
+	h.reloadMu.RLock()
+	defer h.reloadMu.RUnlock()
+	return h.checksum
+}
+
+// loadProvenance returns the provenance of d, or nil if it has none
+func loadProvenance(d *db.DB) *dnsdata.Provenance {
+	p, err := d.Provenance()
+	if errors.Is(err, db.ErrNoProvenance) {
+		return nil
+	}
+	if err != nil {
+		glog.Errorf("Failed to read DB provenance: %v", err)
+		return nil
+	}
+	glog.Infof("DB version %s, serial %d", p.Version, p.Serial)
+	return p
+}
+
+// setProvenance records the provenance of the DB in use, and reports its
+// serial in the DNS_db.serial stat, 0 if none
+func (h *FBDNSDB) setProvenance(p *dnsdata.Provenance) {
+	h.provenance = p
+	var serial int64
+	if p != nil {
+		serial = int64(p.Serial)
+	}
+	h.stats.ResetCounterTo("DNS_db.serial", serial)
+}
+
+// DatasetVersion describes the dataset of the DB in use as
+// "sha256=<checksum> serial=<serial> version=<version>", with the parts the
+// compiler stored, the version being the rest of the text. It is empty if the
+// compiler stored none.
+func (h *FBDNSDB) DatasetVersion() string {
+	h.reloadMu.RLock()
+	defer h.reloadMu.RUnlock()
+	var parts []string
+	if h.checksum != nil {
+		parts = append(parts, "sha256="+h.checksum.String())
+	}
+	if h.provenance != nil {
+		text, _ := h.provenance.MarshalText()
+		parts = append(parts, string(text))
+	}
+	return strings.Join(parts, " ")
+}
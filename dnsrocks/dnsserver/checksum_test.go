@@ -0,0 +1,107 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata"
+	"github.com/facebook/dns/dnsrocks/dnsdata/cdb"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestChecksumAndProvenance(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	require.NoError(t, os.WriteFile(data, []byte("Zexample.com,ns.example.com,dns.example.com,,,,,,\n+www.example.com,192.0.2.1\n"), 0o644))
+	signed := filepath.Join(dir, "signed.cdb")
+	_, err = cdb.CreateCDB(data, signed, &cdb.CreatorOptions{NumCPU: 1, SigningKey: priv, Version: "v1", Serial: 42})
+	require.NoError(t, err)
+
+	newDB := func(conf DBConfig) (*FBDNSDB, stats.Counters) {
+		ctr := stats.NewCounters()
+		conf.Driver = "cdb"
+		conf.ReloadTimeout = 10 * time.Second
+		th, err := NewFBDNSDBBasic(HandlerConfig{}, conf, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+		require.NoError(t, err)
+		return th, ctr
+	}
+
+	// the checksum is reported even if not verified
+	th, ctr := newDB(DBConfig{Path: signed})
+	require.NoError(t, th.Load())
+	require.NotNil(t, th.Checksum())
+	require.NotZero(t, ctr["DNS_db.checksum"])
+	require.Equal(t, int64(42), ctr["DNS_db.serial"])
+	require.Equal(t, "sha256="+th.Checksum().String()+" serial=42 version=v1", th.DatasetVersion())
+	require.NoError(t, th.Reload(*NewFullReloadSignal(testaid.TestCDB.Path)))
+	require.Nil(t, th.Checksum())
+	require.Zero(t, ctr["DNS_db.checksum"])
+	require.Zero(t, ctr["DNS_db.serial"])
+	require.Empty(t, th.DatasetVersion())
+	th.Close()
+
+	th, _ = newDB(DBConfig{Path: signed, ChecksumKeys: []ed25519.PublicKey{otherPub}})
+	require.ErrorIs(t, th.Load(), dnsdata.ErrChecksumMismatch)
+
+	th, ctr = newDB(DBConfig{Path: signed, ChecksumKeys: []ed25519.PublicKey{pub}})
+	require.NoError(t, th.Load())
+	defer th.Close()
+	sum := th.Checksum()
+	require.NotNil(t, sum)
+
+	// DBs without checksums are refused, the old one is still served
+	err = th.Reload(*NewFullReloadSignal(testaid.TestCDB.Path))
+	require.ErrorIs(t, err, db.ErrNoChecksum)
+	require.Equal(t, int64(1), ctr["DNS_db.ErrChecksum"])
+	require.Equal(t, sum, th.Checksum())
+	require.NoError(t, th.Reload(*NewPartialReloadSignal()))
+	require.Equal(t, sum, th.Checksum())
+}
+
+// TestChecksumWithLocationCache checks that the DB wrappers forward the
+// walks over the whole DB
+func TestChecksumWithLocationCache(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	require.NoError(t, os.WriteFile(data, []byte("Zexample.com,ns.example.com,dns.example.com,,,,,,\n+www.example.com,192.0.2.1\n"), 0o644))
+	signed := filepath.Join(dir, "signed.cdb")
+	_, err = cdb.CreateCDB(data, signed, &cdb.CreatorOptions{NumCPU: 1, SigningKey: priv})
+	require.NoError(t, err)
+
+	conf := DBConfig{Path: signed, Driver: "cdb", ReloadTimeout: 10 * time.Second, LocationCacheSize: 16, ChecksumKeys: []ed25519.PublicKey{pub}}
+	th, err := NewFBDNSDBBasic(HandlerConfig{}, conf, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, stats.NewCounters())
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+	require.NotNil(t, th.Checksum())
+
+	conflicts, err := th.AuditOwnerNames()
+	require.NoError(t, err)
+	require.Empty(t, conflicts)
+}
@@ -14,6 +14,7 @@ limitations under the License.
 package dnsserver
 
 import (
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"io"
@@ -21,6 +22,7 @@ import (
 	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
@@ -29,6 +31,7 @@ import (
 	lru "github.com/hashicorp/golang-lru"
 
 	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata"
 	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
 )
 
@@ -37,6 +40,11 @@ type CacheConfig struct {
 	Enabled    bool
 	LRUSize    int
 	WRSTimeout int64
+	// PrefetchHits, if positive, is the number of hits after which a cache
+	// entry hit within PrefetchWindow seconds of its expiry is refreshed from
+	// the DB in the background, so that popular names don't expire together
+	PrefetchHits   int64
+	PrefetchWindow int64
 }
 
 // DBConfig contains our DNS Database configuration.
@@ -48,6 +56,71 @@ type DBConfig struct {
 	ReloadTimeout  time.Duration
 	WatchDB        bool
 	ValidationKey  []byte
+	// WatchQuietPeriod, if set, delays the reloads triggered by WatchDB until
+	// DB file events stop for this long, coalescing the events of a publish
+	WatchQuietPeriod time.Duration
+	// WatchMinInterval, if set, is the minimum time between two reloads
+	// triggered by WatchDB
+	WatchMinInterval time.Duration
+	// LocationIndex loads subnet to location data in memory at (re)load time
+	LocationIndex bool
+	// LocationCacheSize, if positive, is the number of recent location
+	// lookups to cache in memory until the next reload
+	LocationCacheSize int
+	// SeparateBitMap makes CDB location lookups use separate IPv4 and IPv6
+	// mask lengths. It is also set by the FBDNS_SEPARATE_MASKLENS environment
+	// variable.
+	SeparateBitMap bool
+	// ReadOnly opens RDB databases read-only instead of as a secondary, for
+	// databases that are replaced rather than updated in place. Partial
+	// reloads then reopen the database.
+	ReadOnly bool
+	// CatchUpInterval, if set, makes RDB secondaries catch up with the
+	// primary at this interval, through partial reloads
+	CatchUpInterval time.Duration
+	// MaxStaleness, if set, is how long an RDB secondary can go without
+	// catching up before it is reported stale in the rocksdb.catchup.stale stat
+	MaxStaleness time.Duration
+	// ReloadChecks are canary queries a new DB must answer as expected
+	// before a reload switches to it, see ReloadCheck
+	ReloadChecks []ReloadCheck
+	// MemoryBudgetMB, if set, is the RocksDB block cache size shared by the
+	// DB, the DBs opened by full reloads and the shadow DB, instead of each
+	// of them allocating its own
+	MemoryBudgetMB int
+	// WriteBufferBudgetMB is how much of MemoryBudgetMB memtables can use
+	WriteBufferBudgetMB int
+
+	memoryBudget *db.MemoryBudget
+	// Faults, if enabled, are injected in DB lookups for tests and chaos
+	// drills, instead of the ones set by the FBDNS_FAULT_* environment
+	// variables
+	Faults db.FaultConfig
+	// VerifyChecksum recomputes the checksum of the DB when opening it, and
+	// refuses DBs not matching the checksum stored by the compiler. RDB
+	// secondaries catching up in place are not verified again.
+	VerifyChecksum bool
+	// ChecksumKeys, if set, are the Ed25519 keys one of which must have signed
+	// the checksum of the DB. They imply VerifyChecksum.
+	ChecksumKeys []ed25519.PublicKey
+	// RecordCounts controls the counting of the records of the DB at every
+	// load and reload
+	RecordCounts RecordCountsConfig
+}
+
+// dbOptions returns the options to open the DB with
+func (c DBConfig) dbOptions() db.Options {
+	opts := db.DefaultOptions()
+	opts.LocationIndex = c.LocationIndex
+	opts.LocationCacheSize = c.LocationCacheSize
+	opts.SeparateBitMap = opts.SeparateBitMap || c.SeparateBitMap
+	opts.ReadOnly = c.ReadOnly
+	opts.MaxStaleness = c.MaxStaleness
+	opts.MemoryBudget = c.memoryBudget
+	if c.Faults.Enabled() {
+		opts.Faults = c.Faults
+	}
+	return opts
 }
 
 // ReloadType - how to reload the DB
@@ -86,6 +159,9 @@ func NewPartialReloadSignal() *ReloadSignal {
 const (
 	ControlFileFullReload    = "switchdb"
 	ControlFilePartialReload = "reload"
+	// ControlFileTTLClamp holds the TTL clamping of responses to switch to,
+	// see ParseTTLClamp
+	ControlFileTTLClamp = "ttlclamp"
 )
 
 // HandlerConfig contains config used when handling a DNS request.
@@ -96,6 +172,70 @@ type HandlerConfig struct {
 	CNAMEChasing bool
 	// Controls the number of max hops we do for CNAME chasing
 	MaxCNAMEHops int
+	// Controls whether additional records are omitted when not required, i.e.
+	// outside of referrals
+	MinimalResponses bool
+	// Controls whether responses copy the exact case of the query name, as
+	// checked by resolvers randomizing it (DNS 0x20), cached ones included
+	PreserveQNameCase bool
+	// Controls how resolver IPs are anonymized before map lookups and logging
+	ResolverPrivacy PrivacyConfig
+	// Controls shadow reads against a second DB
+	Shadow ShadowConfig
+	// Controls SOA serial tracking and NOTIFY sending to secondaries
+	Notify NotifyConfig
+	// Controls per map statistics of location lookups
+	MapStats MapStatsConfig
+	// Controls the order of the records of multi-value RRsets in answers, one
+	// of AnswerOrderDefault, AnswerOrderShuffle, AnswerOrderFixed or
+	// AnswerOrderRoundRobin
+	AnswerOrder string
+	// Controls the largest EDNS0 UDP buffer size honored and advertised in
+	// responses, larger ones are clamped to it. 0 disables clamping. fbserver
+	// clamps queries for the whole handler chain instead, see ClampUDPSize.
+	MaxUDPSize int
+	// Controls the EDNS0 padding of responses over encrypted transports
+	Padding PaddingConfig
+	// Controls the number of records of responses, and what happens to the
+	// ones over budget. Queries may override it through their context.
+	AnswerBudget AnswerBudget
+	// Controls how queries without exactly one question are answered, one of
+	// QuestionCountFirst, QuestionCountFormErr or QuestionCountNotImp
+	QuestionCount string
+	// Controls how queries for zones not served are answered, by listener
+	// and client
+	NotAuthoritative NotAuthoritativeConfig
+	// Controls the tracking of the resolver subnets and query names sending
+	// the most queries
+	TopTalkers TopTalkersConfig
+	// Controls the rate of queries for the zones of each owner, and what
+	// happens to the ones over quota
+	ZoneQuotas []ZoneQuota
+	// Controls how long the DB lookups of a query can take, counted from its
+	// start, before failing it with SERVFAIL. Only RocksDB reads honor it,
+	// as does the deadline of the query context. 0 disables it.
+	DBReadTimeout time.Duration
+	// Controls the sampling of the RocksDB work done by queries, logged for
+	// the slow ones
+	PerfSampling PerfSamplingConfig
+	// Controls how the weighted random sampling of A and AAAA answers is
+	// seeded, at random or from the query name, client subnet and time
+	AnswerSeed AnswerSeedConfig
+	// Controls what happens to the EDNS0 options of queries the server does
+	// not implement
+	UnknownOptions UnknownOptionsConfig
+	// Controls the in-memory log of the last queries answered
+	QueryLog QueryLogConfig
+	// Selects other maps than the ones names are assigned to for the queries
+	// received on some listeners. The first namespace with a matching
+	// listener is used.
+	MapNamespaces []MapNamespace
+	// Controls how the queries whose handling panics are recorded and
+	// quarantined
+	Poison PoisonConfig
+	// Controls the lowest and highest TTLs of the records of responses. It
+	// can be changed at runtime, see FBDNSDB.SetTTLClamp.
+	TTLClamp TTLClampConfig
 }
 
 // FBDNSDB is the DNS DB handler.
@@ -105,23 +245,166 @@ type FBDNSDB struct {
 	dbConfig      DBConfig
 	handlerConfig HandlerConfig
 	cacheConfig   CacheConfig
+	// reloading serializes reloads, which only hold reloadMu to switch DBs,
+	// so that queries aren't blocked while the new DB is checked
+	reloading sync.Mutex
+	// reloadMu guards dnsdb and what describes it against reloads
 	reloadMu      sync.RWMutex
 	done          chan struct{}
 	lru           *lru.Cache
 	logger        Logger
 	stats         stats.Stats
-	Next          plugin.Handler
+	anonymizer    *ipAnonymizer
+	shadow        *shadowReader
+	notifier      *notifier
+	memoryBudget  *db.MemoryBudget // owned, freed on Close
+	orderer       *answerOrderer
+	seeder        *answerSeeder
+	unknownOpts   *unknownOptions
+	mapNamespaces []*mapNamespace
+	poison        *poison
+	ttlClamp      atomic.Pointer[TTLClampConfig]
+	queryLog      *queryLog
+	recordCounter *recordCounter
+	topTalkers    *topTalkers
+	quotas        *zoneQuotas
+	checksum      *dnsdata.Checksum
+	provenance    *dnsdata.Provenance
+	// wrapReader, if set, wraps the readers of queries, e.g. for tests to
+	// fail some of their lookups
+	wrapReader func(db.Reader) db.Reader
+	Next       plugin.Handler
 }
 
 // NewFBDNSDBBasic initialize a new FBDNSDB. Reloading strategy is left to be set.
 func NewFBDNSDBBasic(handlerConfig HandlerConfig, dbConfig DBConfig, cacheConfig CacheConfig, l Logger, s stats.Stats) (t *FBDNSDB, err error) {
+	if dbConfig.ReadOnly && dbConfig.CatchUpInterval > 0 {
+		return nil, errors.New("cannot catch up with primary in read-only mode")
+	}
 	var lrucache *lru.Cache
 	if cacheConfig.Enabled {
+		if err = cacheConfig.validate(); err != nil {
+			return
+		}
 		if lrucache, err = lru.New(cacheConfig.LRUSize); err != nil {
 			return
 		}
 	}
 
+	anonymizer, err := newIPAnonymizer(handlerConfig.ResolverPrivacy)
+	if err != nil {
+		return nil, err
+	}
+
+	var memoryBudget *db.MemoryBudget
+	if dbConfig.MemoryBudgetMB > 0 && dbConfig.memoryBudget == nil {
+		memoryBudget, err = db.NewMemoryBudget(dbConfig.MemoryBudgetMB<<20, dbConfig.WriteBufferBudgetMB<<20)
+		if err != nil {
+			return nil, err
+		}
+		dbConfig.memoryBudget = memoryBudget
+		defer func() {
+			if err != nil {
+				memoryBudget.Free()
+			}
+		}()
+	}
+	// the shadow DB shares the budget unless it has its own
+	if handlerConfig.Shadow.DB.MemoryBudgetMB == 0 {
+		handlerConfig.Shadow.DB.memoryBudget = dbConfig.memoryBudget
+	}
+
+	shadow, err := newShadowReader(handlerConfig.Shadow, handlerConfig, s)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier, err := newNotifier(handlerConfig.Notify, s)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := handlerConfig.MapStats.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := handlerConfig.PerfSampling.validate(); err != nil {
+		return nil, err
+	}
+
+	orderer, err := newAnswerOrderer(handlerConfig.AnswerOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	seeder, err := newAnswerSeeder(handlerConfig.AnswerSeed)
+	if err != nil {
+		return nil, err
+	}
+
+	unknownOpts, err := newUnknownOptions(handlerConfig.UnknownOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	mapNamespaces, err := newMapNamespaces(handlerConfig.MapNamespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	poison, err := newPoison(handlerConfig.Poison)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := handlerConfig.TTLClamp.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := validateMaxUDPSize(handlerConfig.MaxUDPSize); err != nil {
+		return nil, err
+	}
+
+	if err := handlerConfig.Padding.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := handlerConfig.AnswerBudget.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := validateQuestionCount(handlerConfig.QuestionCount); err != nil {
+		return nil, err
+	}
+
+	if err := handlerConfig.NotAuthoritative.validate(); err != nil {
+		return nil, err
+	}
+
+	queryLog, err := newQueryLog(handlerConfig.QueryLog)
+	if err != nil {
+		return nil, err
+	}
+
+	topTalkers, err := newTopTalkers(handlerConfig.TopTalkers)
+	if err != nil {
+		return nil, err
+	}
+
+	quotas, err := newZoneQuotas(handlerConfig.ZoneQuotas)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := dbConfig.Faults.Validate(); err != nil {
+		return nil, err
+	}
+
+	recordCounter, err := newRecordCounter(dbConfig.RecordCounts, s)
+	if err != nil {
+		return nil, err
+	}
+
 	tdb := &FBDNSDB{
 		handlerConfig: handlerConfig,
 		dbConfig:      dbConfig,
@@ -129,9 +412,23 @@ func NewFBDNSDBBasic(handlerConfig HandlerConfig, dbConfig DBConfig, cacheConfig
 		lru:           lrucache,
 		logger:        l,
 		stats:         s,
+		anonymizer:    anonymizer,
+		shadow:        shadow,
+		notifier:      notifier,
+		memoryBudget:  memoryBudget,
+		orderer:       orderer,
+		seeder:        seeder,
+		unknownOpts:   unknownOpts,
+		mapNamespaces: mapNamespaces,
+		poison:        poison,
+		queryLog:      queryLog,
+		recordCounter: recordCounter,
+		topTalkers:    topTalkers,
+		quotas:        quotas,
 		done:          make(chan struct{}),
 		ReloadChan:    make(chan ReloadSignal),
 	}
+	tdb.ttlClamp.Store(&handlerConfig.TTLClamp)
 
 	return tdb, nil
 }
@@ -143,10 +440,14 @@ func NewFBDNSDB(handlerConfig HandlerConfig, dbConfig DBConfig, cacheConfig Cach
 		return nil, err
 	}
 	go func() {
-		for s := range tdb.ReloadChan {
-			err := tdb.Reload(s)
-			if err != nil {
-				glog.Errorf("Failed to reload: %v", err)
+		for {
+			select {
+			case <-tdb.done:
+				return
+			case s := <-tdb.ReloadChan:
+				if err := tdb.Reload(s); err != nil {
+					glog.Errorf("Failed to reload: %v", err)
+				}
 			}
 		}
 	}()
@@ -154,6 +455,11 @@ func NewFBDNSDB(handlerConfig HandlerConfig, dbConfig DBConfig, cacheConfig Cach
 	if tdb.dbConfig.ReloadInterval > 0 {
 		go tdb.PeriodicDBReload(tdb.dbConfig.ReloadInterval)
 	}
+	if tdb.dbConfig.CatchUpInterval > 0 {
+		// catching up goes through partial reloads, so that the caches and
+		// the checks of reloads apply to it
+		go tdb.periodicReload(tdb.dbConfig.CatchUpInterval)
+	}
 
 	return tdb, nil
 }
@@ -202,21 +508,37 @@ func prepareDBWatcher(watchPath string) (*fsnotify.Watcher, error) {
 	return watcher, nil
 }
 
+// RequestReload sends s to ReloadChan, unless the DB is closed. ReloadChan is
+// never closed, so that late senders block rather than panic.
+func (h *FBDNSDB) RequestReload(s ReloadSignal) {
+	select {
+	case <-h.done:
+	case h.ReloadChan <- s:
+	}
+}
+
 // PeriodicDBReload is to enforce db reload in case db watch fails or stuck
 func (h *FBDNSDB) PeriodicDBReload(reloadInt int) {
-	d := time.Duration(reloadInt) * time.Second
+	h.periodicReload(time.Duration(reloadInt) * time.Second)
+}
+
+// periodicReload requests a partial reload every d, until Close
+func (h *FBDNSDB) periodicReload(d time.Duration) {
 	ticker := time.NewTicker(d)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-h.done:
 			return
 		case <-ticker.C:
-			h.ReloadChan <- *NewPartialReloadSignal()
+			h.RequestReload(*NewPartialReloadSignal())
 		}
 	}
 }
 
 func (h *FBDNSDB) watchDBAndReload(watcher *fsnotify.Watcher) (err error) {
+	debouncer := newReloadDebouncer(h.dbConfig.WatchQuietPeriod, h.dbConfig.WatchMinInterval)
+	defer debouncer.stop()
 	for {
 		select {
 		case err = <-watcher.Errors:
@@ -228,8 +550,17 @@ func (h *FBDNSDB) watchDBAndReload(watcher *fsnotify.Watcher) (err error) {
 		case <-h.done:
 			return nil
 		case ev := <-watcher.Events:
-			if filterEvent(ev.Op) && path.Clean(ev.Name) == h.dbConfig.Path {
-				h.ReloadChan <- *NewPartialReloadSignal()
+			if !filterEvent(ev.Op) || path.Clean(ev.Name) != h.dbConfig.Path {
+				continue
+			}
+			if !debouncer.enabled() {
+				h.RequestReload(*NewPartialReloadSignal())
+			} else if debouncer.event(time.Now()) {
+				h.stats.IncrementCounter("DNS_db.watch_coalesced")
+			}
+		case <-debouncer.C():
+			if debouncer.fire(time.Now()) {
+				h.RequestReload(*NewPartialReloadSignal())
 			}
 		}
 	}
@@ -288,14 +619,20 @@ func (h *FBDNSDB) watchControlDirAndReload(watcher *fsnotify.Watcher) (err error
 			switch name {
 			case ControlFilePartialReload:
 				glog.Infof("Found partial reload trigger file")
-				h.ReloadChan <- *NewPartialReloadSignal()
+				h.RequestReload(*NewPartialReloadSignal())
 			case ControlFileFullReload:
 				glog.Infof("Found full reload trigger file")
 				newPath, err := getNewDBPath(cp)
 				if err != nil {
 					return fmt.Errorf("getting new DB path: %w", err)
 				}
-				h.ReloadChan <- *NewFullReloadSignal(newPath)
+				h.RequestReload(*NewFullReloadSignal(newPath))
+			case ControlFileTTLClamp:
+				if err := h.loadTTLClampFile(cp); err != nil {
+					glog.Errorf("Failed to load TTL clamp control file: %v", err)
+				} else {
+					glog.Infof("Switched TTL clamp to %q", h.TTLClamp())
+				}
 			default:
 				glog.Infof("Ignoring unknown file in control directory: %s", name)
 			}
@@ -322,12 +659,33 @@ func (h *FBDNSDB) WatchControlDirAndReload() error {
 func (h *FBDNSDB) Load() (err error) {
 	var dnsdb *db.DB
 	glog.Infof("Loading %s using %s driver", h.dbConfig.Path, h.dbConfig.Driver)
-	if dnsdb, err = db.Open(h.dbConfig.Path, h.dbConfig.Driver); err != nil {
+	if dnsdb, err = db.OpenWithOptions(h.dbConfig.Path, h.dbConfig.Driver, h.dbConfig.dbOptions()); err != nil {
+		return err
+	}
+	sum, err := h.loadChecksum(dnsdb, h.dbConfig.verifyChecksum())
+	if err != nil {
+		dnsdb.Destroy()
 		return err
 	}
 	h.dnsdb = dnsdb
+	h.setChecksum(sum)
+	h.setProvenance(loadProvenance(dnsdb))
+	if h.recordCounter != nil {
+		h.recordCounter.report(h.recordCounter.count(dnsdb))
+	}
 	h.stats.IncrementCounter("DNS_db.reload")
 	h.stats.ResetCounter("DNS_db.ErrReloadTimeout")
+	if h.notifier != nil {
+		h.notifier.update(h.dnsdb)
+	}
+	if h.shadow != nil {
+		// a broken shadow DB must not prevent serving from the primary one
+		if err := h.shadow.load(); err != nil {
+			glog.Errorf("Failed to load shadow DB, shadow reads disabled: %v", err)
+			h.stats.IncrementCounter("DNS_shadow.load_error")
+			h.shadow = nil
+		}
+	}
 	return nil
 }
 
@@ -335,8 +693,15 @@ func (h *FBDNSDB) Load() (err error) {
 func (h *FBDNSDB) Reload(s ReloadSignal) (err error) {
 	newPath := ""
 
-	h.reloadMu.Lock()
-	defer h.reloadMu.Unlock()
+	// dnsdb and the DB path only change under reloading, the expensive
+	// checks of the new DB run without blocking queries
+	h.reloading.Lock()
+	defer h.reloading.Unlock()
+	select {
+	case <-h.done:
+		return fmt.Errorf("DB is closed")
+	default:
+	}
 
 	switch s.Kind {
 	case FullReload:
@@ -348,30 +713,83 @@ func (h *FBDNSDB) Reload(s ReloadSignal) (err error) {
 		newPath = h.dbConfig.Path
 	}
 
-	var newDB *db.DB
-	newDB, err = h.dnsdb.Reload(newPath, h.dbConfig.ValidationKey, h.dbConfig.ReloadTimeout)
+	var (
+		newDB  *db.DB
+		sum    *dnsdata.Checksum
+		prov   *dnsdata.Provenance
+		counts *db.RecordCounts
+	)
+	check := func(newDB *db.DB) (err error) {
+		if err := newDB.ValidateDbKey(h.dbConfig.ValidationKey); err != nil {
+			return err
+		}
+		// CDB files are reopened on partial reloads too
+		reopened := s.Kind == FullReload || h.dbConfig.ReadOnly || h.dbConfig.Driver != "rocksdb"
+		if sum, err = h.loadChecksum(newDB, reopened && h.dbConfig.verifyChecksum()); err != nil {
+			return err
+		}
+		prov = loadProvenance(newDB)
+		if h.recordCounter != nil {
+			counts = h.recordCounter.count(newDB)
+		}
+		// partial reloads of RocksDB catch up in place, there is nothing to
+		// switch from, unless it is open read-only and gets reopened
+		if !reopened {
+			return nil
+		}
+		return h.runReloadChecks(newDB)
+	}
+	oldDB := h.dnsdb
+	newDB, err = oldDB.ReopenWithCheck(newPath, check, h.dbConfig.ReloadTimeout)
 	if err != nil {
 		if errors.Is(err, db.ErrValidationKeyNotFound) {
 			h.stats.IncrementCounter("DNS_db.ErrValidationKeyNotFound")
 		}
+		if errors.Is(err, ErrReloadCheckFailed) {
+			h.stats.IncrementCounter("DNS_db.ErrReloadCheckFailed")
+		}
 		if errors.Is(err, db.ErrReloadTimeout) {
 			h.stats.IncrementCounter("DNS_db.ErrReloadTimeout")
 		}
+		if errors.Is(err, dnsdata.ErrChecksumMismatch) || errors.Is(err, db.ErrNoChecksum) {
+			h.stats.IncrementCounter("DNS_db.ErrChecksum")
+		}
 		return
 	}
 
-	// if we didn't timeout and reloading finished without errors
+	// if we didn't timeout and reloading finished without errors, switch
+	// to the new DB along with its description
+	h.reloadMu.Lock()
 	h.dnsdb = newDB
+	h.setChecksum(sum)
+	h.setProvenance(prov)
 	h.dbConfig.Path = newPath
-
+	if h.recordCounter != nil {
+		h.recordCounter.report(counts)
+	}
 	if h.cacheConfig.Enabled && h.lru != nil {
 		h.lru.Purge()
 	}
+	h.reloadMu.Unlock()
+	if newDB != oldDB {
+		glog.Infof("New DBI, old one will be destroyed")
+		// readers of the old DB keep it open until they are closed
+		oldDB.Destroy()
+	}
 
 	if err := h.cleanupSignalFile(s); err != nil {
 		return err
 	}
 	h.stats.IncrementCounter("DNS_db.reload")
+	if h.notifier != nil {
+		h.notifier.update(h.dnsdb)
+	}
+	if h.shadow != nil {
+		if err := h.shadow.reload(); err != nil {
+			glog.Errorf("Failed to reload shadow DB: %v", err)
+			h.stats.IncrementCounter("DNS_shadow.reload_error")
+		}
+	}
 	return nil
 }
 
@@ -382,18 +800,54 @@ func (h *FBDNSDB) Reload(s ReloadSignal) (err error) {
 func (h *FBDNSDB) AcquireReader() (db.Reader, error) {
 	h.reloadMu.RLock()
 	defer h.reloadMu.RUnlock()
-	return db.NewReader(h.dnsdb)
+	reader, err := db.NewReader(h.dnsdb)
+	if err == nil && h.wrapReader != nil {
+		reader = h.wrapReader(reader)
+	}
+	return reader, err
 }
 
-// Close closes the database. It also takes care of closing the channel used
-// for periodic reloading.
+// AcquireTracingReader is AcquireReader for a reader reporting its probes to
+// trace.
+func (h *FBDNSDB) AcquireTracingReader(trace db.TraceFunc) (db.Reader, error) {
+	h.reloadMu.RLock()
+	defer h.reloadMu.RUnlock()
+	return db.NewTracingReader(h.dnsdb, trace)
+}
+
+// SetTracer makes the loaded DB report its probes to trace, for debugging.
+// Tracing stops when the DB is reloaded.
+func (h *FBDNSDB) SetTracer(trace db.TraceFunc) error {
+	h.reloadMu.RLock()
+	defer h.reloadMu.RUnlock()
+	if h.dnsdb == nil {
+		return fmt.Errorf("DB is not loaded")
+	}
+	h.dnsdb.SetTracer(trace)
+	return nil
+}
+
+// Close closes the database, and stops reloading it.
 func (h *FBDNSDB) Close() {
+	h.reloading.Lock()
+	defer h.reloading.Unlock()
 	h.reloadMu.Lock()
 	defer h.reloadMu.Unlock()
 	glog.Infof("Closing DB")
 	close(h.done)
-	close(h.ReloadChan)
-	h.dnsdb.Destroy()
+	// the DB may have failed to load
+	if h.dnsdb != nil {
+		h.dnsdb.Destroy()
+	}
+	if h.shadow != nil {
+		h.shadow.close()
+	}
+	if h.notifier != nil {
+		h.notifier.wait()
+	}
+	if h.memoryBudget != nil {
+		h.memoryBudget.Free()
+	}
 }
 
 // ReportBackendStats refreshes backend statistics in server stats
@@ -0,0 +1,102 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"time"
+)
+
+// reloadDebouncer coalesces the DB file events of a publish into a single
+// reload, fired once the events stop for a quiet period, and no sooner than a
+// minimum interval after the previous reload it fired.
+type reloadDebouncer struct {
+	quiet       time.Duration
+	minInterval time.Duration
+	timer       *time.Timer
+	pending     bool
+	last        time.Time
+}
+
+func newReloadDebouncer(quiet, minInterval time.Duration) *reloadDebouncer {
+	return &reloadDebouncer{quiet: quiet, minInterval: minInterval}
+}
+
+// enabled returns false if every event should trigger a reload right away
+func (d *reloadDebouncer) enabled() bool {
+	return d.quiet > 0 || d.minInterval > 0
+}
+
+// C returns the channel to wait on for the pending reload, nil if there is
+// none
+func (d *reloadDebouncer) C() <-chan time.Time {
+	if !d.pending {
+		return nil
+	}
+	return d.timer.C
+}
+
+// event schedules a reload after an event at now, and returns true if it got
+// coalesced with an already pending one
+func (d *reloadDebouncer) event(now time.Time) bool {
+	coalesced := d.pending
+	d.pending = true
+	d.reset(d.wait(now))
+	return coalesced
+}
+
+// fire returns true if the pending reload should happen at now, after its
+// timer expired
+func (d *reloadDebouncer) fire(now time.Time) bool {
+	if !d.pending {
+		return false
+	}
+	if wait := d.last.Add(d.minInterval).Sub(now); !d.last.IsZero() && wait > 0 {
+		d.reset(wait)
+		return false
+	}
+	d.pending = false
+	d.last = now
+	return true
+}
+
+// wait returns how long to wait at now before reloading
+func (d *reloadDebouncer) wait(now time.Time) time.Duration {
+	wait := d.quiet
+	if !d.last.IsZero() {
+		if next := d.last.Add(d.minInterval).Sub(now); next > wait {
+			wait = next
+		}
+	}
+	return wait
+}
+
+func (d *reloadDebouncer) reset(wait time.Duration) {
+	if d.timer == nil {
+		d.timer = time.NewTimer(wait)
+		return
+	}
+	if !d.timer.Stop() {
+		select {
+		case <-d.timer.C:
+		default:
+		}
+	}
+	d.timer.Reset(wait)
+}
+
+func (d *reloadDebouncer) stop() {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
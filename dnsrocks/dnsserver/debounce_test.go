@@ -0,0 +1,90 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestReloadDebouncer(t *testing.T) {
+	require.False(t, newReloadDebouncer(0, 0).enabled())
+
+	d := newReloadDebouncer(time.Second, time.Minute)
+	defer d.stop()
+	require.True(t, d.enabled())
+	require.Nil(t, d.C())
+
+	t0 := time.Now()
+	require.False(t, d.event(t0))
+	require.True(t, d.event(t0.Add(100*time.Millisecond)))
+	require.NotNil(t, d.C())
+	require.True(t, d.fire(t0.Add(2*time.Second)))
+	require.Nil(t, d.C())
+	require.False(t, d.fire(t0.Add(3*time.Second)), "nothing pending")
+
+	// the next reload waits for the minimum interval, not just the quiet period
+	t1 := t0.Add(10 * time.Second)
+	require.False(t, d.event(t1))
+	require.Equal(t, time.Minute-8*time.Second, d.wait(t1))
+	require.False(t, d.fire(t1.Add(time.Second)))
+	require.NotNil(t, d.C(), "still pending")
+	require.True(t, d.fire(t0.Add(2*time.Second+time.Minute)))
+}
+
+func TestWatchDBAndReloadCoalesced(t *testing.T) {
+	th := OpenDbForTesting(t, &testaid.TestCDB)
+	ctr := stats.NewCounters()
+	th.stats = ctr
+	th.dbConfig.WatchQuietPeriod = 200 * time.Millisecond
+	watcher, err := prepareDBWatcher(path.Dir(th.dbConfig.Path))
+	if watcher != nil {
+		defer watcher.Close()
+	}
+	require.NoError(t, err)
+	exited := make(chan error)
+	go func() {
+		exited <- th.watchDBAndReload(watcher)
+	}()
+
+	// a publish touching the file several times in a row
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond)
+		now := time.Now()
+		require.NoError(t, os.Chtimes(testaid.TestCDB.Path, now, now))
+	}
+
+	select {
+	case reload := <-th.ReloadChan:
+		require.Equal(t, *NewPartialReloadSignal(), reload)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected to receive PartialReloadSignal in ReloadChan, but did not")
+	}
+	select {
+	case <-th.ReloadChan:
+		t.Errorf("Expected the events to be coalesced into a single reload")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	close(th.done)
+	require.NoError(t, <-exited)
+	require.NotZero(t, ctr["DNS_db.watch_coalesced"])
+}
@@ -0,0 +1,262 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+// DebugConfig configures the debug HTTP server, which answers queries
+// against the loaded DB with the trace of their lookup.
+type DebugConfig struct {
+	// Addr is the host:port to listen on. The server is disabled if empty.
+	// It exposes the DB content, so it should not be reachable from outside.
+	Addr string
+	// Zones is a comma separated list of zones listed by /zones along with
+	// the ones of the DB, even when the DB has no SOA record for them.
+	Zones string
+}
+
+// DebugProbe is a DB probe done to answer a query. Keys and values are
+// escaped the way Go quotes strings, without the surrounding quotes.
+type DebugProbe struct {
+	Op     string   `json:"op"`
+	Key    string   `json:"key"`
+	Values []string `json:"values"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// DebugResolveResult is the response of /resolve. For findmap probes,
+// values hold the map ID, for location probes the location ID.
+type DebugResolveResult struct {
+	QueryResult
+	Probes []DebugProbe `json:"probes"`
+}
+
+// DebugZone is an entry of the response of /zones
+type DebugZone struct {
+	Zone string `json:"zone"`
+	// Found is false if the zone has no SOA in the loaded DB
+	Found  bool   `json:"found"`
+	Serial uint32 `json:"serial,omitempty"`
+}
+
+// DebugServer is the debug HTTP server of a FBDNSDB
+type DebugServer struct {
+	h      *FBDNSDB
+	zones  []string
+	server *http.Server
+}
+
+// escapeBytes returns b quoted the way Go does, without the quotes
+func escapeBytes(b []byte) string {
+	q := strconv.Quote(string(b))
+	return q[1 : len(q)-1]
+}
+
+// NewDebugServer validates c and returns the matching DebugServer, or nil
+// when it is disabled.
+func NewDebugServer(h *FBDNSDB, c DebugConfig) (*DebugServer, error) {
+	if c.Addr == "" {
+		return nil, nil
+	}
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return nil, fmt.Errorf("invalid debug server address %q: %w", c.Addr, err)
+	}
+	zones := splitList(c.Zones)
+	for i, zone := range zones {
+		if _, ok := dns.IsDomainName(zone); !ok {
+			return nil, fmt.Errorf("invalid debug zone %q", zone)
+		}
+		zones[i] = dns.CanonicalName(zone)
+	}
+	s := &DebugServer{h: h, zones: zones}
+	s.server = &http.Server{Addr: c.Addr, Handler: s.Handler()}
+	return s, nil
+}
+
+// Handler returns the handler serving the debug endpoints
+func (s *DebugServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/resolve", s.resolve)
+	mux.HandleFunc("/zones", s.listZones)
+	mux.HandleFunc("/toptalkers", s.topTalkers)
+	mux.HandleFunc("/querylog", s.queryLog)
+	mux.HandleFunc("/poison", s.poisonQueries)
+	return mux
+}
+
+// Start listens on the configured address and serves in the background
+func (s *DebugServer) Start() error {
+	ln, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.server.Addr, err)
+	}
+	go func() {
+		if err := s.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			glog.Errorf("Debug HTTP server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops the server
+func (s *DebugServer) Shutdown() error {
+	return s.server.Shutdown(context.Background())
+}
+
+// writeJSON writes v as the response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		glog.Errorf("Failed to write debug response: %v", err)
+	}
+}
+
+// resolve answers /resolve?name=&type=&client=&ecs=&maxans=&format= with the
+// response and the DB probes done to build it. With format=rfc8427, the
+// whole response is also returned in the RFC 8427 JSON format.
+func (s *DebugServer) resolve(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := Query{
+		Name:   params.Get("name"),
+		Type:   params.Get("type"),
+		Client: params.Get("client"),
+		ECS:    params.Get("ecs"),
+	}
+	if q.Name == "" {
+		http.Error(w, "missing name", http.StatusBadRequest)
+		return
+	}
+	if q.Type == "" {
+		q.Type = "A"
+	}
+	if q.Client == "" {
+		q.Client = "127.0.0.1"
+	}
+	maxAns := DefaultMaxAnswer
+	if v := params.Get("maxans"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("invalid maxans %q", v), http.StatusBadRequest)
+			return
+		}
+		maxAns = n
+	}
+	var rfc8427 bool
+	switch format := params.Get("format"); format {
+	case "":
+	case "rfc8427":
+		rfc8427 = true
+	default:
+		http.Error(w, fmt.Sprintf("invalid format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	probes := []DebugProbe{}
+	ctx := WithTrace(r.Context(), func(op string, key []byte, values [][]byte, err error) {
+		p := DebugProbe{Op: op, Key: escapeBytes(key), Values: make([]string, 0, len(values))}
+		for _, v := range values {
+			p.Values = append(p.Values, escapeBytes(v))
+		}
+		if err != nil {
+			p.Error = err.Error()
+		}
+		probes = append(probes, p)
+	})
+	rec, err := s.h.querySingle(ctx, q.Type, q.Name, q.Client, q.ECS, maxAns)
+	writeJSON(w, DebugResolveResult{QueryResult: newQueryResult(q, rec, err, rfc8427), Probes: probes})
+}
+
+// listZones answers /zones with the SOA serials of the zones of the loaded
+// DB, and of the configured zones, missing ones included, sorted
+func (s *DebugServer) listZones(w http.ResponseWriter, _ *http.Request) {
+	reader, err := s.h.AcquireReader()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read DB: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	defer reader.Close()
+	names, err := reader.Zones()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to list zones: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for _, zone := range s.zones {
+		if !slices.Contains(names, zone) {
+			names = append(names, zone)
+		}
+	}
+	sort.Strings(names)
+	zones := make([]DebugZone, 0, len(names))
+	for _, zone := range names {
+		serial, found := zoneSerial(reader, zone)
+		zones = append(zones, DebugZone{Zone: zone, Found: found, Serial: serial})
+	}
+	writeJSON(w, zones)
+}
+
+// topTalkers answers /toptalkers?n= with the n resolver subnets and query
+// names which sent the most queries over the top talkers window
+func (s *DebugServer) topTalkers(w http.ResponseWriter, r *http.Request) {
+	if s.h.topTalkers == nil {
+		http.Error(w, "top talkers tracking is disabled", http.StatusNotFound)
+		return
+	}
+	n := 10
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid n %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, s.h.topTalkers.report(n))
+}
+
+// queryLog answers /querylog?n= with the n last queries answered, oldest
+// first, all the ones kept by default
+func (s *DebugServer) queryLog(w http.ResponseWriter, r *http.Request) {
+	if s.h.queryLog == nil {
+		http.Error(w, "query log is disabled", http.StatusNotFound)
+		return
+	}
+	n := -1
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid n %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, s.h.QueryLog(n))
+}
+
+// poisonQueries answers /poison with the last queries whose handling
+// panicked, oldest first
+func (s *DebugServer) poisonQueries(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, s.h.PoisonQueries())
+}
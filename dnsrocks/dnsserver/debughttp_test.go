@@ -0,0 +1,105 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func getJSON(t *testing.T, url string, v interface{}) int {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	return resp.StatusCode
+}
+
+func TestNewDebugServer(t *testing.T) {
+	s, err := NewDebugServer(nil, DebugConfig{})
+	require.NoError(t, err)
+	require.Nil(t, s)
+
+	_, err = NewDebugServer(nil, DebugConfig{Addr: "localhost"})
+	require.Error(t, err)
+
+	_, err = NewDebugServer(nil, DebugConfig{Addr: "localhost:0", Zones: "example..org"})
+	require.Error(t, err)
+}
+
+func TestDebugServer(t *testing.T) {
+	for _, tdb := range testaid.TestDBs {
+		t.Run(tdb.Driver, func(t *testing.T) {
+			th := OpenDbForTesting(t, &tdb)
+			defer th.Close()
+			s, err := NewDebugServer(th, DebugConfig{Addr: "localhost:0", Zones: "example.org, nonexistent.test"})
+			require.NoError(t, err)
+			ts := httptest.NewServer(s.Handler())
+			defer ts.Close()
+
+			var result DebugResolveResult
+			code := getJSON(t, ts.URL+"/resolve?name=foo.example.org&type=A&client=1.1.1.1", &result)
+			require.Equal(t, http.StatusOK, code)
+			require.Equal(t, "NOERROR", result.Rcode)
+			require.Equal(t, []string{"foo.example.org.\t180\tIN\tA\t1.1.1.2"}, result.Answer)
+			ops := make(map[string]DebugProbe)
+			for _, p := range result.Probes {
+				require.Empty(t, p.Error)
+				ops[p.Op] = p
+			}
+			require.Contains(t, ops, db.TraceFindMap)
+			require.Equal(t, []string{`\x00\x02`}, ops[db.TraceLocation].Values)
+			require.Nil(t, result.Message)
+
+			var rfc8427Result DebugResolveResult
+			code = getJSON(t, ts.URL+"/resolve?name=foo.example.org&type=A&client=1.1.1.1&format=rfc8427", &rfc8427Result)
+			require.Equal(t, http.StatusOK, code)
+			require.NotNil(t, rfc8427Result.Message)
+			require.Equal(t, "foo.example.org.", rfc8427Result.Message.QNAME)
+			require.Equal(t, 1, rfc8427Result.Message.AA)
+			require.Equal(t, 1, rfc8427Result.Message.ANCOUNT)
+			require.Equal(t, "01010102", rfc8427Result.Message.AnswerRRs[0].RDATAHEX)
+
+			code = getJSON(t, ts.URL+"/resolve?name=foo.example.org&type=A&format=yaml", &result)
+			require.Equal(t, http.StatusBadRequest, code)
+
+			code = getJSON(t, ts.URL+"/resolve?name=foo.example.org&type=BOGUS", &result)
+			require.Equal(t, http.StatusOK, code)
+			require.NotEmpty(t, result.Error)
+
+			code = getJSON(t, ts.URL+"/resolve?type=A", &result)
+			require.Equal(t, http.StatusBadRequest, code)
+
+			var zones []DebugZone
+			code = getJSON(t, ts.URL+"/zones", &zones)
+			require.Equal(t, http.StatusOK, code)
+			require.Equal(t, []DebugZone{
+				{Zone: "example.com.", Found: true, Serial: 123},
+				{Zone: "example.net.", Found: true, Serial: 123},
+				{Zone: "example.org.", Found: true, Serial: 123},
+				{Zone: "ipv4.example.net.", Found: true, Serial: 123},
+				{Zone: "nonexistent.test."},
+			}, zones)
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"errors"
+
+	"github.com/miekg/dns"
+)
+
+// ErrorClass tells what caused a HandlerError
+type ErrorClass string
+
+// Error classes
+const (
+	// ErrorClassQuery errors are caused by the query, e.g. for a zone we don't serve
+	ErrorClassQuery ErrorClass = "query"
+	// ErrorClassData errors are caused by the data served, e.g. CNAME cycles
+	ErrorClassData ErrorClass = "data"
+	// ErrorClassEngine errors are caused by the server, e.g. failing DB lookups
+	ErrorClassEngine ErrorClass = "engine"
+)
+
+// HandlerError is a kind of error of the resolution path. Errors of a kind are
+// always answered with the same rcode and extended DNS error, and counted under
+// the same stats keys, so that alerting can tell data problems from engine
+// problems. Errors with a cause wrap the kind, e.g.
+// fmt.Errorf("%w: %v", ErrDBLookup, err), and are matched with errors.Is.
+type HandlerError struct {
+	// Name identifies the kind in logs and stats keys
+	Name  string
+	Class ErrorClass
+	// Rcode is the response code of queries failing with this kind of error
+	Rcode int
+	// EDE is the extended DNS error info code of the responses (RFC 8914)
+	EDE uint16
+}
+
+// Kinds of HandlerError
+var (
+	ErrNotAuthoritative = &HandlerError{Name: "not_authoritative", Class: ErrorClassQuery, Rcode: dns.RcodeRefused, EDE: dns.ExtendedErrorCodeNotAuthoritative}
+	ErrQuotaExceeded    = &HandlerError{Name: "quota_exceeded", Class: ErrorClassQuery, Rcode: dns.RcodeRefused, EDE: dns.ExtendedErrorCodeProhibited}
+	ErrMalformedQuery   = &HandlerError{Name: "malformed_query", Class: ErrorClassQuery, Rcode: dns.RcodeFormatError, EDE: dns.ExtendedErrorCodeOther}
+	ErrQuarantined      = &HandlerError{Name: "quarantined", Class: ErrorClassQuery, Rcode: dns.RcodeServerFailure, EDE: dns.ExtendedErrorCodeOther}
+	ErrNoLocation       = &HandlerError{Name: "no_location", Class: ErrorClassData, Rcode: dns.RcodeServerFailure, EDE: dns.ExtendedErrorCodeOther}
+	ErrCNAMECycle       = &HandlerError{Name: "cname_cycle", Class: ErrorClassData, Rcode: dns.RcodeServerFailure, EDE: dns.ExtendedErrorCodeOther}
+	ErrMalformedData    = &HandlerError{Name: "malformed_data", Class: ErrorClassData, Rcode: dns.RcodeServerFailure, EDE: dns.ExtendedErrorCodeOther}
+	ErrDBUnavailable    = &HandlerError{Name: "db_unavailable", Class: ErrorClassEngine, Rcode: dns.RcodeServerFailure, EDE: dns.ExtendedErrorCodeNotReady}
+	ErrDBLookup         = &HandlerError{Name: "db_lookup", Class: ErrorClassEngine, Rcode: dns.RcodeServerFailure, EDE: dns.ExtendedErrorCodeOther}
+	ErrDBTimeout        = &HandlerError{Name: "db_timeout", Class: ErrorClassEngine, Rcode: dns.RcodeServerFailure, EDE: dns.ExtendedErrorCodeOther}
+	ErrInternal         = &HandlerError{Name: "internal", Class: ErrorClassEngine, Rcode: dns.RcodeServerFailure, EDE: dns.ExtendedErrorCodeOther}
+	ErrPanic            = &HandlerError{Name: "panic", Class: ErrorClassEngine, Rcode: dns.RcodeServerFailure, EDE: dns.ExtendedErrorCodeOther}
+)
+
+func (e *HandlerError) Error() string {
+	return e.Name
+}
+
+// ClassStatsKey is the stats key counting errors of the class of e
+func (e *HandlerError) ClassStatsKey() string {
+	return "DNS_error." + string(e.Class)
+}
+
+// StatsKey is the stats key counting errors of the kind of e
+func (e *HandlerError) StatsKey() string {
+	return e.ClassStatsKey() + "." + e.Name
+}
+
+// EDNS0 returns the extended DNS error option of e
+func (e *HandlerError) EDNS0() *dns.EDNS0_EDE {
+	return &dns.EDNS0_EDE{InfoCode: e.EDE}
+}
+
+// AsHandlerError returns the kind of err, ErrInternal if it has none
+func AsHandlerError(err error) *HandlerError {
+	var herr *HandlerError
+	if errors.As(err, &herr) {
+		return herr
+	}
+	return ErrInternal
+}
+
+// dbLookupError returns the kind of a failed DB lookup error: ErrDBTimeout
+// when it ran past the deadline of the query, ErrDBLookup otherwise
+func dbLookupError(err error) *HandlerError {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrDBTimeout
+	}
+	return ErrDBLookup
+}
@@ -0,0 +1,188 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestAsHandlerError(t *testing.T) {
+	err := fmt.Errorf("%w: location: %v", ErrDBLookup, db.ErrInjectedFault)
+	require.True(t, errors.Is(err, ErrDBLookup))
+	require.Equal(t, ErrDBLookup, AsHandlerError(err))
+	require.Equal(t, "DNS_error.engine", ErrDBLookup.ClassStatsKey())
+	require.Equal(t, "DNS_error.engine.db_lookup", ErrDBLookup.StatsKey())
+	require.Equal(t, ErrInternal, AsHandlerError(errors.New("unexpected")))
+	require.Equal(t, "DNS_error.query.not_authoritative", ErrNotAuthoritative.StatsKey())
+	require.Equal(t, "DNS_error.data.cname_cycle", ErrCNAMECycle.StatsKey())
+	require.Equal(t, ErrDBTimeout, dbLookupError(fmt.Errorf("read: %w", context.DeadlineExceeded)))
+	require.Equal(t, ErrDBLookup, dbLookupError(db.ErrInjectedFault))
+}
+
+// TestHandlerErrors checks the responses and stats of the kinds of errors of
+// the resolution path
+func TestHandlerErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		qname  string
+		faults db.FaultConfig
+		err    *HandlerError
+		// legacy is the counter which predates the kind of the error, if any
+		legacy string
+	}{
+		{name: "not authoritative", qname: "www.notourdomain.com.", err: ErrNotAuthoritative, legacy: "DNS_response.refused"},
+		{name: "cname cycle", qname: "cycle.example.com.", err: ErrCNAMECycle, legacy: "DNS_cname_chasing.cname_cycle"},
+		{name: "db lookup", qname: "www.example.com.", faults: db.FaultConfig{ErrorRate: 1}, err: ErrDBLookup},
+	}
+	for _, testDB := range testaid.TestDBs {
+		for _, tc := range testCases {
+			t.Run(testDB.Driver+"/"+testDB.Flavour+"/"+tc.name, func(t *testing.T) {
+				ctr := stats.NewCounters()
+				dbConfig := DBConfig{Path: testDB.Path, Driver: testDB.Driver, Faults: tc.faults}
+				th, err := NewFBDNSDBBasic(HandlerConfig{CNAMEChasing: true, MaxCNAMEHops: 2}, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+				require.NoError(t, err)
+				require.NoError(t, th.Load())
+				defer th.Close()
+
+				req := new(dns.Msg)
+				req.SetQuestion(tc.qname, dns.TypeA)
+				req.SetEdns0(4096, false)
+				w := &test.ResponseWriterCustomRemote{RemoteIP: "1.1.1.1"}
+				rec := dnstest.NewRecorder(w)
+				rcode, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+				require.NoError(t, err)
+				require.Equal(t, tc.err.Rcode, rcode)
+				require.Equal(t, int64(1), ctr[tc.err.StatsKey()])
+				require.Equal(t, int64(1), ctr[tc.err.ClassStatsKey()])
+				if tc.legacy != "" {
+					require.Equal(t, int64(1), ctr[tc.legacy])
+				}
+				require.NotNil(t, rec.Msg)
+				require.Equal(t, tc.err.Rcode, rec.Msg.Rcode)
+				require.Empty(t, rec.Msg.Answer)
+				opt := rec.Msg.IsEdns0()
+				require.NotNil(t, opt)
+				require.Len(t, opt.Option, 1)
+				require.Equal(t, tc.err.EDE, opt.Option[0].(*dns.EDNS0_EDE).InfoCode)
+			})
+		}
+	}
+}
+
+// TestHandlerDBTimeout checks that RocksDB lookups past the deadline of the
+// query fail it, and that CDB lookups ignore it
+func TestHandlerDBTimeout(t *testing.T) {
+	for _, testDB := range testaid.TestDBs {
+		t.Run(testDB.Driver+"/"+testDB.Flavour, func(t *testing.T) {
+			ctr := stats.NewCounters()
+			dbConfig := DBConfig{Path: testDB.Path, Driver: testDB.Driver}
+			th, err := NewFBDNSDBBasic(HandlerConfig{}, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+			require.NoError(t, err)
+			require.NoError(t, th.Load())
+			defer th.Close()
+
+			req := new(dns.Msg)
+			req.SetQuestion("www.example.com.", dns.TypeA)
+			rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: "1.1.1.1"})
+			ctx, cancel := context.WithDeadline(CreateTestContext(1), time.Now().Add(-time.Second))
+			defer cancel()
+			rcode, err := th.ServeDNSWithRCODE(ctx, rec, req)
+			require.NoError(t, err)
+			if testDB.Driver == "cdb" {
+				require.Equal(t, dns.RcodeSuccess, rcode)
+				require.NotNil(t, rec.Msg)
+				require.NotEmpty(t, rec.Msg.Answer)
+				return
+			}
+			require.Equal(t, dns.RcodeServerFailure, rcode)
+			require.NotNil(t, rec.Msg)
+			require.Equal(t, dns.RcodeServerFailure, rec.Msg.Rcode)
+			require.Equal(t, int64(1), ctr[ErrDBTimeout.StatsKey()])
+		})
+	}
+}
+
+// failingAuthReader is a reader whose authority lookups fail
+type failingAuthReader struct {
+	db.Reader
+}
+
+func (r failingAuthReader) IsAuthoritative(q []byte, _ db.ID) (bool, bool, []byte, error) {
+	return false, false, q, db.ErrInjectedFault
+}
+
+// TestHandlerAuthorityLookupFailed checks that queries whose authority lookup
+// fails are answered SERVFAIL, with the extended DNS error of the failure
+func TestHandlerAuthorityLookupFailed(t *testing.T) {
+	ctr := stats.NewCounters()
+	dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+	th, err := NewFBDNSDBBasic(HandlerConfig{}, dbConfig, CacheConfig{}, &DummyLogger{}, ctr)
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+	th.wrapReader = func(r db.Reader) db.Reader { return failingAuthReader{Reader: r} }
+
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeA)
+	req.SetEdns0(4096, false)
+	rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: "1.1.1.1"})
+	rcode, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeServerFailure, rcode)
+	require.NotNil(t, rec.Msg)
+	require.Equal(t, dns.RcodeServerFailure, rec.Msg.Rcode)
+	opt := rec.Msg.IsEdns0()
+	require.NotNil(t, opt)
+	require.Len(t, opt.Option, 1)
+	require.Equal(t, ErrDBLookup.EDE, opt.Option[0].(*dns.EDNS0_EDE).InfoCode)
+	require.Equal(t, int64(1), ctr[ErrDBLookup.StatsKey()])
+	require.Equal(t, int64(1), ctr["DNS_error.is_authoritative"])
+}
+
+func TestDBDeadline(t *testing.T) {
+	h := &FBDNSDB{}
+	_, ok := h.dbDeadline(context.Background())
+	require.False(t, ok)
+
+	early := time.Now().Add(time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), early)
+	defer cancel()
+	deadline, ok := h.dbDeadline(ctx)
+	require.True(t, ok)
+	require.Equal(t, early, deadline)
+
+	h.handlerConfig.DBReadTimeout = time.Hour
+	deadline, ok = h.dbDeadline(ctx)
+	require.True(t, ok)
+	require.Equal(t, early, deadline, "the earliest deadline applies")
+
+	start := time.Now()
+	deadline, ok = h.dbDeadline(context.Background())
+	require.True(t, ok)
+	require.WithinDuration(t, start.Add(time.Hour), deadline, time.Minute)
+}
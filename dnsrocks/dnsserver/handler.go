@@ -17,10 +17,12 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/netip"
 	"strings"
 	"time"
 
 	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata"
 	"github.com/facebook/dns/dnsrocks/dnsserver/test"
 
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
@@ -32,8 +34,7 @@ import (
 
 const (
 	// TypeToStatsPrefix is the prefix used for creating stats keys
-	TypeToStatsPrefix              = "DNS_query"
-	maxAnswer         maxAnswerKey = "maxans"
+	TypeToStatsPrefix = "DNS_query"
 	// DefaultMaxAnswer is the default number of answer returned for A\AAAA query
 	DefaultMaxAnswer = 1
 
@@ -45,22 +46,29 @@ const (
 
 var typeToStats = make(map[uint16]string)
 
-type cacheEntry struct {
-	expiration int64
-	response   *dns.Msg
-}
-
-type maxAnswerKey string
+type traceKey struct{}
 
-// WithMaxAnswer set max ans in context
+// WithMaxAnswer set max ans in context, the MaxAnswer of its AnswerBudget
 func WithMaxAnswer(ctx context.Context, masAns int) context.Context {
-	return context.WithValue(ctx, maxAnswer, masAns)
+	return WithAnswerBudget(ctx, AnswerBudget{MaxAnswer: masAns})
 }
 
 // GetMaxAnswer is used to get max answer number from context
 func GetMaxAnswer(ctx context.Context) (int, bool) {
-	maxAns, ok := ctx.Value(maxAnswer).(int)
-	return maxAns, ok
+	b, ok := GetAnswerBudget(ctx)
+	return b.MaxAnswer, ok && b.MaxAnswer > 0
+}
+
+// WithTrace makes queries served with ctx report their DB probes to trace.
+// Cached responses are not used for them.
+func WithTrace(ctx context.Context, trace db.TraceFunc) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+// GetTrace is used to get the trace function from context
+func GetTrace(ctx context.Context) (db.TraceFunc, bool) {
+	trace, ok := ctx.Value(traceKey{}).(db.TraceFunc)
+	return trace, ok
 }
 
 func init() {
@@ -106,41 +114,126 @@ func MakeOPTWithECS(s string) (*dns.OPT, error) {
 	return o, nil
 }
 
+// copyQNameCase sets the question and the owner of the records named after
+// qname, regardless of case, to qname. Responses may come from the cache,
+// built for a query with a different case.
+func copyQNameCase(resp *dns.Msg, qname string) {
+	for i := range resp.Question {
+		if strings.EqualFold(resp.Question[i].Name, qname) {
+			resp.Question[i].Name = qname
+		}
+	}
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			if hdr := rr.Header(); hdr.Name != qname && strings.EqualFold(hdr.Name, qname) {
+				hdr.Name = qname
+			}
+		}
+	}
+}
+
 // writeAndLog writes the response to the network as well as log and bump stats
-func (h *FBDNSDB) writeAndLog(state request.Request, resp *dns.Msg, ecs *dns.EDNS0_SUBNET, loc *db.Location) (int, error) {
+func (h *FBDNSDB) writeAndLog(ctx context.Context, state request.Request, resp *dns.Msg, ecs *dns.EDNS0_SUBNET, loc *db.Location) (int, error) {
 	rcode := resp.Rcode
 
-	state.SizeAndDo(resp)
-	state.Scrub(resp)
+	if h.orderer != nil {
+		h.orderer.order(resp.Answer)
+	}
+	h.applyBudget(resp, h.answerBudget(ctx), state.Proto() == "udp")
+	if h.handlerConfig.PreserveQNameCase {
+		copyQNameCase(resp, state.QName())
+	}
+	if h.unknownOpts != nil {
+		h.unknownOpts.handle(state.Req, resp, h.stats)
+	}
+	h.clampTTLs(resp)
+
+	// the response is sized after the clamped request, but logged with the
+	// original one
+	sizeState, clamped := clampUDPSize(state, h.handlerConfig.MaxUDPSize)
+	logState := state
+	if received, ok := receivedRequest(ctx); ok {
+		clamped = clamped || state.Proto() == "udp"
+		logState = request.Request{W: state.W, Req: received}
+	}
+	if clamped {
+		h.stats.IncrementCounter("DNS_response.udp_size_clamped")
+	}
+	sizeState.SizeAndDo(resp)
+	if trimAdditional(resp, sizeState.Size()) > 0 {
+		h.stats.IncrementCounter("DNS_response.additional_trimmed")
+	}
+	sizeState.Scrub(resp)
+	if clamped && resp.Truncated {
+		h.stats.IncrementCounter("DNS_response.udp_size_clamped.truncated")
+	}
 
 	if h.handlerConfig.AlwaysCompress {
 		// Compression should be set AFTER potential Truncate call inside Scrub
 		// otherwise it could be reset
 		resp.Compress = true
 	}
+	// padding comes last, as it depends on the final length of the response
+	if blockSize := h.handlerConfig.Padding.paddingBlockSize(state); blockSize > 0 {
+		if padResponse(resp, blockSize, sizeState.Size()) {
+			h.stats.IncrementCounter("DNS_response.padded")
+		} else {
+			h.stats.IncrementCounter("DNS_response.padding_skipped")
+		}
+	}
 
 	err := state.W.WriteMsg(resp)
 	if err != nil {
 		return dns.RcodeServerFailure, err
 	}
-	h.logger.Log(state, resp, ecs, loc)
+	if isAccounted(ctx) {
+		h.logger.Log(logState, resp, ecs, loc)
+		if h.queryLog != nil {
+			h.queryLog.add(logState, resp, rcode, ecs, loc)
+		}
+	}
 	if !resp.Authoritative {
-		h.stats.IncrementCounter("DNS_queries_notauthoritative")
+		h.countQuery(ctx, "DNS_queries_notauthoritative")
 	}
 	if rcode == dns.RcodeNameError {
-		h.stats.IncrementCounter("DNS_queries_nxdomain")
+		h.countQuery(ctx, "DNS_queries_nxdomain")
 	} else if rcode == dns.RcodeRefused {
-		h.stats.IncrementCounter("DNS_queries_refused")
+		h.countQuery(ctx, "DNS_queries_refused")
 	} else if rcode == dns.RcodeBadVers {
-		h.stats.IncrementCounter("DNS_queries_badvers")
+		h.countQuery(ctx, "DNS_queries_badvers")
 	} else if rcode == dns.RcodeSuccess && len(resp.Answer) == 0 {
-		h.stats.IncrementCounter("DNS_queries_nodata")
+		h.countQuery(ctx, "DNS_queries_nodata")
 	}
 
 	return rcode, nil
 }
 
-func (h *FBDNSDB) chaseCNAME(reader db.Reader, localState request.Request, maxAns int, a *dns.Msg, ecs *dns.EDNS0_SUBNET) ([]dns.RR, bool, error) {
+// countError counts err under the stats keys of its kind, and logs data and
+// engine errors
+func (h *FBDNSDB) countError(qname string, err error) *HandlerError {
+	herr := AsHandlerError(err)
+	h.stats.IncrementCounter(herr.ClassStatsKey())
+	h.stats.IncrementCounter(herr.StatsKey())
+	if herr.Class != ErrorClassQuery {
+		glog.Errorf("%s: %s error: %v", qname, herr.Class, err)
+	}
+	return herr
+}
+
+// fail counts err and answers the query with its rcode and extended DNS error
+func (h *FBDNSDB) fail(ctx context.Context, state request.Request, err error, ecs *dns.EDNS0_SUBNET, loc *db.Location) (int, error) {
+	herr := h.countError(state.Name(), err)
+	m := new(dns.Msg)
+	m.SetRcode(state.Req, herr.Rcode)
+	if state.Req.IsEdns0() != nil {
+		m.SetEdns0(4096, true)
+		m.IsEdns0().Option = append(m.IsEdns0().Option, herr.EDNS0())
+	}
+	// does not matter if this write fails
+	return h.writeAndLog(ctx, state, m, ecs, loc)
+}
+
+func (h *FBDNSDB) chaseCNAME(reader db.Reader, localState request.Request, resolverIP string, maxAns int, a *dns.Msg, ecs *dns.EDNS0_SUBNET) ([]dns.RR, bool, error) {
 	var (
 		packedQName = make([]byte, 255)
 		// the location matching this requestor and target
@@ -150,33 +243,25 @@ func (h *FBDNSDB) chaseCNAME(reader db.Reader, localState request.Request, maxAn
 	offset, err := dns.PackDomainName(localState.Name(), packedQName, 0, nil, false)
 	if err != nil {
 		h.stats.IncrementCounter("DNS_cname_chasing.pack_domain_fail")
-		glog.Errorf("could not pack cname domain %s", localState.Name())
-		dns.HandleFailed(localState.W, localState.Req)
-		h.logger.LogFailed(localState, nil, nil)
-		return nil, false, err
+		return nil, false, fmt.Errorf("%w: CNAME target %s: %v", ErrMalformedData, localState.Name(), err)
 	}
 
 	packedQName = packedQName[:offset]
 
-	if loc, err = reader.FindLocation(packedQName, ecs, localState.IP()); err != nil {
-		glog.Errorf("%s: failed to find location: %v", localState.Name(), err)
-		h.logger.LogFailed(localState, ecs, loc)
-		return nil, false, err
+	if loc, err = reader.FindLocation(packedQName, ecs, resolverIP); err != nil {
+		return nil, false, fmt.Errorf("%w: location of %s: %v", dbLookupError(err), localState.Name(), err)
 	}
 
 	if loc == nil {
 		// We could not find a location, not even the default one... potentially a bogus DB.
 		h.stats.IncrementCounter("DNS_cname_chasing.location.nil")
-		glog.Errorf("%s: nil location", localState.Name())
-		h.logger.LogFailed(localState, ecs, loc)
-		return nil, false, fmt.Errorf("no location found, not even default one")
+		return nil, false, fmt.Errorf("%w: %s", ErrNoLocation, localState.Name())
 	}
 
 	_, auth, zoneCut, err := reader.IsAuthoritative(packedQName, loc.LocID)
 	if err != nil {
 		h.stats.IncrementCounter("DNS_cname_chasing.is_authoritative.error")
-		dns.HandleFailed(localState.W, localState.Req)
-		return nil, false, err
+		return nil, false, fmt.Errorf("%w: authority of %s: %v", dbLookupError(err), localState.Name(), err)
 	}
 
 	if !auth {
@@ -186,18 +271,60 @@ func (h *FBDNSDB) chaseCNAME(reader db.Reader, localState request.Request, maxAn
 	}
 
 	answerSizeBefore := len(a.Answer)
-	weighted, _ := reader.FindAnswer(packedQName, zoneCut, localState.QName(), localState.QType(), loc.LocID, a, maxAns)
+	weighted, rcode := reader.FindAnswer(packedQName, zoneCut, localState.QName(), localState.QType(), loc.LocID, a, maxAns)
 
 	newRecords := a.Answer[answerSizeBefore:]
 	if len(newRecords) == 0 {
 		h.stats.IncrementCounter("DNS_cname_chasing.qtype.not_found")
+		// The response code and the authority section of a negative answer
+		// relate to the last name of the chain, so that it can be cached
+		// (RFC 2308 section 2.1 and 2.2, RFC 6604).
+		if rcode == dns.RcodeNameError {
+			h.stats.IncrementCounter("DNS_cname_chasing.nxdomain")
+		}
+		a.Rcode = rcode
+		zoneCutString, _, err := dns.UnpackDomainName(zoneCut, 0)
+		if err != nil {
+			glog.Errorf("Failed to unpack control domain name %s", err)
+		} else {
+			db.FindSOA(reader, zoneCut, zoneCutString, loc.LocID, a)
+		}
 	}
 	return newRecords, weighted, nil
 }
 
+// dbDeadline returns the deadline of the DB lookups of a query, the earliest
+// of the deadline of ctx and the DB read timeout from now, if any
+func (h *FBDNSDB) dbDeadline(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Deadline()
+	if timeout := h.handlerConfig.DBReadTimeout; timeout > 0 {
+		if d := time.Now().Add(timeout); !ok || d.Before(deadline) {
+			deadline, ok = d, true
+		}
+	}
+	return deadline, ok
+}
+
 // ServeDNSWithRCODE handles a dns query and with return the RCODE and eventual
-// error that happen during processing.
-func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+// error that happen during processing. Queries whose handling panics are
+// recorded as poison queries, and answered SERVFAIL if they were not answered
+// yet.
+func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (rcode int, err error) {
+	h.countQuery(ctx, "DNS_queries")
+	if h.poison.isQuarantined(r, time.Now()) {
+		skipShadow(ctx)
+		return h.failPoison(w, r, ErrQuarantined)
+	}
+	ww := &writtenWriter{ResponseWriter: w}
+	defer func() {
+		if e := recover(); e != nil {
+			rcode, err = h.recoverPanic(ctx, ww, r, e)
+		}
+	}()
+	return h.serveDNS(ctx, ww, r)
+}
+
+func (h *FBDNSDB) serveDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 	var (
 		// the location matching this requestor
 		loc *db.Location
@@ -213,57 +340,102 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 		// When caching is enabled, this will hold the cache key
 		cacheKey string
 	)
-	h.stats.IncrementCounter("DNS_queries")
+	if rcode, rejected := RejectQuestionCount(h.handlerConfig.QuestionCount, w, r, h.stats); rejected {
+		return rcode, nil
+	}
 
-	reader, err := h.AcquireReader()
-	if err != nil {
-		h.stats.IncrementCounter("DNS_db.read_error")
-		// We cannot acquire a reader, most likely because the DB couldn't be loaded.
-		// dns.HandleFailed(w, r)
-		// FIXME error code "E"
-		// nolint: nilerr
-		return dns.RcodeServerFailure, nil
+	var (
+		reader db.Reader
+		err    error
+	)
+	trace, traced := GetTrace(ctx)
+	prefetching := isPrefetch(ctx)
+	accounted := isAccounted(ctx)
+	// health probes check the DB itself, not the cache
+	cached := h.cacheConfig.Enabled && !traced && !isProbe(ctx)
+	if accounted && h.shouldSamplePerf() {
+		defer h.logPerfSample(r, time.Now(), db.StartPerfSample())
+	}
+	if traced {
+		reader, err = h.AcquireTracingReader(trace)
+	} else {
+		reader, err = h.AcquireReader()
 	}
-	defer reader.Close()
 	// State carries important information about the current request.
 	// It is also used to write the reply.
 	state := request.Request{W: w, Req: r}
+	resolverIP := state.IP()
+	// the real client address, before any anonymization, for source ACLs
+	clientIP := resolverIP
+	if h.anonymizer != nil {
+		// Only the truncated address is used for map lookups, and the logged
+		// state only ever sees the anonymized one.
+		resolverIP = h.anonymizer.lookupIP(resolverIP)
+		state = request.Request{W: h.anonymizer.wrap(w), Req: r}
+	}
+	if h.topTalkers != nil && accounted {
+		h.topTalkers.add(resolverIP, state.Name())
+	}
+	if info, ok := GetClientInfo(ctx); ok {
+		h.countQuery(ctx, "DNS_queries.transport."+string(info.Transport))
+		state = request.Request{W: &clientInfoWriter{ResponseWriter: state.W, info: info}, Req: r}
+	}
+	if err != nil {
+		// We cannot acquire a reader, most likely because the DB couldn't be loaded.
+		h.stats.IncrementCounter("DNS_db.read_error")
+		return h.fail(ctx, state, fmt.Errorf("%w: %v", ErrDBUnavailable, err), ecs, loc)
+	}
+	defer reader.Close()
+	if deadline, ok := h.dbDeadline(ctx); ok {
+		reader.SetDeadline(deadline)
+	}
+
+	if h.quotas != nil && accounted {
+		switch h.quotas.enforce(state.Name(), h.stats) {
+		case QuotaDrop:
+			// no response at all, as if the query was lost
+			h.countError(state.Name(), ErrQuotaExceeded)
+			return dns.RcodeSuccess, nil
+		case QuotaRefuse:
+			skipShadow(ctx)
+			return h.fail(ctx, state, ErrQuotaExceeded, ecs, loc)
+		}
+	}
 
 	if state.Do() {
-		h.stats.IncrementCounter("DNS_queries.edns0.do_bit")
+		h.countQuery(ctx, "DNS_queries.edns0.do_bit")
 	}
-	h.stats.IncrementCounter(typeToStatsKey(state.QType()))
+	h.countQuery(ctx, typeToStatsKey(state.QType()))
 
 	// Check if this is a supported edns version
 	if a, err := edns.Version(state.Req); err != nil { // Wrong EDNS version, return at once.
-		return h.writeAndLog(state, a, ecs, loc)
+		return h.writeAndLog(ctx, state, a, ecs, loc)
 	}
 
 	offset, err := dns.PackDomainName(state.Name(), packedQName, 0, nil, false)
 	if err != nil {
 		h.stats.IncrementCounter("DNS_error.pack_domain_fail")
-		glog.Errorf("could not pack domain %s", state.Name())
-		dns.HandleFailed(state.W, state.Req)
-		h.logger.LogFailed(state, ecs, loc)
-		// nolint: nilerr
-		return dns.RcodeServerFailure, nil
+		return h.fail(ctx, state, fmt.Errorf("%w: %v", ErrMalformedQuery, err), ecs, loc)
 	}
 
 	packedQName = packedQName[:offset]
 
 	ecs = db.FindECS(state.Req)
-	if loc, err = reader.FindLocation(packedQName, ecs, state.IP()); err != nil {
-		glog.Errorf("%s: failed to find location: %v", state.Name(), err)
-		h.logger.LogFailed(state, ecs, loc)
-		return dns.RcodeServerFailure, nil
+	if h.seeder != nil {
+		reader.SetRandSeed(h.seeder.seed(state.Name(), ecs, resolverIP, time.Now()))
+	}
+	if ns := h.mapNamespace(ctx, state); ns != nil {
+		reader.SetMapNamespace(ns.maps)
+		h.stats.IncrementCounter(ns.statsKey)
+	}
+	if loc, err = reader.FindLocation(packedQName, ecs, resolverIP); err != nil {
+		return h.fail(ctx, state, fmt.Errorf("%w: location: %v", dbLookupError(err), err), ecs, loc)
 	}
 
 	if loc == nil {
 		// We could not find a location, not even the default one... potentially a bogus DB.
 		h.stats.IncrementCounter("DNS_location.nil")
-		glog.Errorf("%s: nil location", state.Name())
-		h.logger.LogFailed(state, ecs, loc)
-		return dns.RcodeServerFailure, nil
+		return h.fail(ctx, state, ErrNoLocation, ecs, loc)
 	}
 
 	if string(loc.LocID) == emptyLoc {
@@ -285,18 +457,29 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 			h.stats.IncrementCounter("DNS_location.long")
 		}
 	}
+	if accounted {
+		h.countMapLookup(state.Name(), loc, ecs, resolverIP)
+	}
 
-	if h.cacheConfig.Enabled {
+	if cached {
 		cacheKey = fmt.Sprintf("%.3d%.3d%.3d%s", loc.LocID, state.QType(), state.QClass(), state.Name())
-		if v, ok := h.lru.Get(cacheKey); ok {
-			t := v.(cacheEntry).expiration
-			if t < time.Now().Unix() {
+		// prefetches refresh the entry whether it is cached or not
+		if v, ok := h.lru.Get(cacheKey); ok && !prefetching {
+			entry := v.(*cacheEntry)
+			now := time.Now().Unix()
+			if entry.expiration < now {
 				// evict answer
 				h.stats.IncrementCounter("DNS_cache.expired")
 				h.lru.Remove(cacheKey)
 			} else {
 				h.stats.IncrementCounter("DNS_cache.hit")
-				resp := v.(cacheEntry).response.Copy()
+				if h.cacheConfig.shouldPrefetch(entry, now) {
+					h.prefetch(ctx, w, r)
+				}
+				resp := entry.response.Copy()
+				// the cache key is lower case, the entry may have been
+				// filled by a query with another case
+				copyQNameCase(resp, state.QName())
 				// SetReply sets rcode to RcodeSuccess...
 				rcode := resp.Rcode
 				resp.SetReply(state.Req)
@@ -312,9 +495,9 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 
 					resp.Extra = append([]dns.RR{o}, resp.Extra...)
 				}
-				return h.writeAndLog(state, resp, ecs, loc)
+				return h.writeAndLog(ctx, state, resp, ecs, loc)
 			}
-		} else {
+		} else if !prefetching {
 			h.stats.IncrementCounter("DNS_cache.missed")
 		}
 	}
@@ -332,23 +515,21 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 
 	if err != nil {
 		h.stats.IncrementCounter("DNS_error.is_authoritative")
-		dns.HandleFailed(state.W, state.Req)
-		return dns.RcodeServerFailure, err
+		return h.fail(ctx, state, fmt.Errorf("%w: authority: %v", dbLookupError(err), err), ecs, loc)
 	}
 
 	if !ns && !auth {
-		h.stats.IncrementCounter("DNS_response.refused")
-		m := new(dns.Msg)
-		m.SetRcode(state.Req, dns.RcodeRefused)
-		// We can use Extended DNS Errors to indicate that the server is not authoritative for certain Query
-		// instead of just returning a REFUSED
-		if state.Req.IsEdns0() != nil {
-			m.SetEdns0(4096, true)
-			ede := dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeNotAuthoritative}
-			m.IsEdns0().Option = append(m.IsEdns0().Option, &ede)
+		client, _ := netip.ParseAddr(clientIP)
+		if h.handlerConfig.NotAuthoritative.policy(ctx, state, client) == NotAuthoritativeDrop {
+			// no response at all, as if the query was lost
+			h.countError(state.Name(), ErrNotAuthoritative)
+			h.countQuery(ctx, "DNS_queries_notauthoritative.dropped")
+			return dns.RcodeSuccess, nil
 		}
-		// does not matter if this write fails
-		return h.writeAndLog(state, m, ecs, loc)
+		// Extended DNS Errors tell that the server is not authoritative for
+		// the query, rather than just refusing it
+		h.stats.IncrementCounter("DNS_response.refused")
+		return h.fail(ctx, state, ErrNotAuthoritative, ecs, loc)
 	}
 
 	// Not authoritative but we have NS (implicit or we would not have passed the
@@ -361,8 +542,7 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 		_, auth, zoneCut, err = reader.IsAuthoritative(packedQName[packedQName[0]+1:], loc.LocID)
 		if err != nil {
 			h.stats.IncrementCounter("DNS_error.is_authoritative")
-			dns.HandleFailed(state.W, state.Req)
-			return dns.RcodeServerFailure, err
+			return h.fail(ctx, state, fmt.Errorf("%w: authority of parent: %v", dbLookupError(err), err), ecs, loc)
 		}
 	}
 
@@ -375,11 +555,7 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 	} else {
 		h.stats.IncrementCounter("DNS_response.authoritative")
 
-		maxAns, ok := GetMaxAnswer(ctx)
-		if !ok {
-			maxAns = DefaultMaxAnswer
-			// log something
-		}
+		maxAns := h.answerBudget(ctx).MaxAnswer
 		weighted, a.Rcode = reader.FindAnswer(packedQName, zoneCut, state.QName(), state.QType(), loc.LocID, a, maxAns)
 
 		// CNAME chasing doesn't apply to queries of type CNAME or ANY
@@ -406,15 +582,15 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 				for _, record := range a.Answer {
 					if record.Header().Name == target {
 						h.stats.IncrementCounter("DNS_cname_chasing.cname_cycle")
-						glog.Errorf("CNAME cycle detected: %s", target)
-						return dns.RcodeServerFailure, nil
+						return h.fail(ctx, state, fmt.Errorf("%w: %s", ErrCNAMECycle, target), ecs, loc)
 					}
 				}
 
 				updatedState := state.NewWithQuestion(target, state.QType())
-				newRecords, weighted, err = h.chaseCNAME(reader, updatedState, maxAns, a, ecs)
+				newRecords, weighted, err = h.chaseCNAME(reader, updatedState, resolverIP, maxAns, a, ecs)
 				if err != nil {
-					glog.Errorf("Failed to chase CNAME for domain: %s, target: %s, error: %v", state.Name(), target, err)
+					// answer with the chain resolved so far
+					h.countError(state.Name(), err)
 					break
 				}
 			}
@@ -428,10 +604,7 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 
 	unpackedControlDomain, _, err := dns.UnpackDomainName(zoneCut, 0)
 	if err != nil {
-		glog.Errorf("Failed to unpack control domain name %s", err)
-		dns.HandleFailed(state.W, state.Req)
-		h.logger.Log(state, state.Req, ecs, loc)
-		return dns.RcodeServerFailure, nil
+		return h.fail(ctx, state, fmt.Errorf("%w: zone cut: %v", ErrMalformedData, err), ecs, loc)
 	}
 
 	// If we do not have any answer and we are authoritative, add SOA
@@ -451,20 +624,22 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 		}
 	}
 
-	// Additional section
-	weighted = db.AdditionalSectionForRecords(reader, a, loc.LocID, state.QClass(), a.Answer) || weighted
-	weighted = db.AdditionalSectionForRecords(reader, a, loc.LocID, state.QClass(), a.Ns) || weighted
+	// Additional section, only glue records of referrals are required
+	if !h.handlerConfig.MinimalResponses || isReferral(a) {
+		weighted = db.AdditionalSectionForRecords(reader, a, loc.LocID, state.QClass(), a.Answer) || weighted
+		weighted = db.AdditionalSectionForRecords(reader, a, loc.LocID, state.QClass(), a.Ns) || weighted
+	}
 
-	if h.cacheConfig.Enabled {
+	if cached {
 		// Cache answer before we add ECS/options
 		var timeout int64
 		if !weighted {
 			// FIXME: we can leave this in cache until it get flushed (via DB reload)
 			timeout = time.Now().Unix() + 1000
-			h.lru.Add(cacheKey, cacheEntry{expiration: timeout, response: a.Copy()})
+			h.lru.Add(cacheKey, &cacheEntry{expiration: timeout, response: a.Copy()})
 		} else if h.cacheConfig.WRSTimeout > 0 {
 			timeout = time.Now().Unix() + h.cacheConfig.WRSTimeout
-			h.lru.Add(cacheKey, cacheEntry{expiration: timeout, response: a.Copy()})
+			h.lru.Add(cacheKey, &cacheEntry{expiration: timeout, response: a.Copy()})
 		}
 	}
 
@@ -480,14 +655,29 @@ func (h *FBDNSDB) ServeDNSWithRCODE(ctx context.Context, w dns.ResponseWriter, r
 		a.Extra = append([]dns.RR{o}, a.Extra...)
 	}
 
-	return h.writeAndLog(state, a, ecs, loc)
+	return h.writeAndLog(ctx, state, a, ecs, loc)
 }
 
 // ServeDNS implements the plugin.Handler interface.
 func (h *FBDNSDB) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 	requestStartTime := time.Now()
-	rcode, err := h.ServeDNSWithRCODE(ctx, w, r)
+	if h.shadow == nil || !h.shadow.sample() {
+		rcode, err := h.ServeDNSWithRCODE(ctx, w, r)
+		h.stats.AddSample("DNS.responsetime_us", time.Since(requestStartTime).Microseconds())
+		return rcode, err
+	}
+	// keep the request as received and the response served to compare them
+	// with the ones from the shadow DB
+	req := r.Copy()
+	rec := dnstest.NewRecorder(w)
+	ctx, skip := withShadowSkip(ctx)
+	rcode, err := h.ServeDNSWithRCODE(ctx, rec, r)
 	h.stats.AddSample("DNS.responsetime_us", time.Since(requestStartTime).Microseconds())
+	if *skip {
+		h.stats.IncrementCounter("DNS_shadow.skipped")
+	} else if err == nil && rec.Msg != nil {
+		h.shadow.compare(ctx, w, req, rec.Msg)
+	}
 	return rcode, err
 }
 
@@ -503,6 +693,11 @@ func rrTypeToUnit(qType string) (uint16, error) {
 
 // QuerySingle queries dns server for a query, returning single answer if possible
 func (h *FBDNSDB) QuerySingle(rtype, record, remoteIP, subnet string, maxAns int) (*dnstest.Recorder, error) {
+	return h.querySingle(context.TODO(), rtype, record, remoteIP, subnet, maxAns)
+}
+
+// querySingle is QuerySingle with a context, e.g. to trace the query
+func (h *FBDNSDB) querySingle(ctx context.Context, rtype, record, remoteIP, subnet string, maxAns int) (*dnstest.Recorder, error) {
 	req := new(dns.Msg)
 	qt, err := rrTypeToUnit(rtype)
 	if err != nil {
@@ -517,8 +712,7 @@ func (h *FBDNSDB) QuerySingle(rtype, record, remoteIP, subnet string, maxAns int
 		req.Extra = []dns.RR{o}
 	}
 
-	ctx := context.TODO()
-	ctx = WithMaxAnswer(ctx, maxAns)
+	ctx = WithAnswerBudget(ctx, AnswerBudget{MaxAnswer: maxAns})
 
 	rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: remoteIP})
 	_, err = h.ServeDNSWithRCODE(ctx, rec, req)
@@ -527,3 +721,62 @@ func (h *FBDNSDB) QuerySingle(rtype, record, remoteIP, subnet string, maxAns int
 	}
 	return rec, nil
 }
+
+// LocateClient returns the location the DB matches for a query on qname
+// sent by resolverIP with the ECS option ecs, if not nil, as when answering it
+func (h *FBDNSDB) LocateClient(qname string, ecs *dns.EDNS0_SUBNET, resolverIP string) (*db.Location, error) {
+	reader, err := h.AcquireReader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	packedQName := make([]byte, 255)
+	offset, err := dns.PackDomainName(qname, packedQName, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	if h.anonymizer != nil {
+		resolverIP = h.anonymizer.lookupIP(resolverIP)
+	}
+	if ecs != nil {
+		// the lookup sets the scope of the option
+		ecsCopy := *ecs
+		ecs = &ecsCopy
+	}
+	return reader.FindLocation(packedQName[:offset], ecs, resolverIP)
+}
+
+// AuditOwnerNames returns the owner names spelled in more than one way in
+// the resource record keys of the loaded DB, see db.DB.AuditOwnerNames
+func (h *FBDNSDB) AuditOwnerNames() ([]db.OwnerNameConflict, error) {
+	// the audit reads the whole DB, through a reader of its own rather than
+	// under reloadMu, so that a pending reload, and the queries behind it,
+	// don't wait for it
+	reader, err := h.AcquireReader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return reader.AuditOwnerNames()
+}
+
+// QueryMetadata returns the metadata stored in the DB about the records of a
+// domain, wildcard ones included, e.g. to trace where they come from
+func (h *FBDNSDB) QueryMetadata(record string) ([]string, error) {
+	reader, err := h.AcquireReader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var metadata []string
+	err = reader.ForEach(dnsdata.MetadataKey([]byte(record)), func(value []byte) error {
+		metadata = append(metadata, string(value))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
@@ -19,6 +19,7 @@ import (
 	"net"
 	"os"
 	"path"
+	"strings"
 	"testing"
 	"time"
 
@@ -28,6 +29,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsdata/cdb"
 	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
 	"github.com/facebook/dns/dnsrocks/dnsserver/test"
 	"github.com/facebook/dns/dnsrocks/testaid"
@@ -38,7 +40,10 @@ func TestMain(m *testing.M) {
 }
 
 func OpenDbForTesting(t *testing.T, db *testaid.TestDB) (th *FBDNSDB) {
-	dbConfig := DBConfig{Path: db.Path, Driver: db.Driver, ReloadInterval: 10}
+	return openDbForTestingWithConfig(t, DBConfig{Path: db.Path, Driver: db.Driver, ReloadInterval: 10})
+}
+
+func openDbForTestingWithConfig(t *testing.T, dbConfig DBConfig) (th *FBDNSDB) {
 	cacheConfig := CacheConfig{Enabled: false}
 	handlerConfig := HandlerConfig{}
 
@@ -84,9 +89,9 @@ func CreateTestContext(maxAnswer int) context.Context {
 	return ctx
 }
 
-// TestFBDNSDBBadPathDontWrite confirms that when the CDB path does not exist,
-// we don't do not write any messages back.
-func TestFBDNSDBBadPathDontWrite(t *testing.T) {
+// TestFBDNSDBBadPathServfail confirms that when the CDB path does not exist,
+// queries are answered SERVFAIL.
+func TestFBDNSDBBadPathServfail(t *testing.T) {
 	dbConfig := DBConfig{Path: "bad/path", Driver: "cdb", ReloadInterval: 10}
 	cacheConfig := CacheConfig{Enabled: false}
 	handlerConfig := HandlerConfig{}
@@ -101,12 +106,13 @@ func TestFBDNSDBBadPathDontWrite(t *testing.T) {
 	ctx := CreateTestContext(1)
 	code, _ := th.ServeDNSWithRCODE(ctx, rec, req)
 	require.Equal(t, dns.RcodeServerFailure, code, "RcodeServerFailure was expected to be returned.")
-	require.False(t, w.GetWriteMsgCallCount() > 0, "WriteMsg was called")
+	require.NotNil(t, rec.Msg)
+	require.Equal(t, dns.RcodeServerFailure, rec.Msg.Rcode)
 }
 
-// TestFBDNSDBBadDBDontWrite confirms that when the CDB is bad, we don't
-// write any messages back.
-func TestFBDNSDBBadDBDontWrite(t *testing.T) {
+// TestFBDNSDBBadDBServfail confirms that when the CDB is bad, queries are
+// answered SERVFAIL.
+func TestFBDNSDBBadDBServfail(t *testing.T) {
 	th := OpenDbForTesting(t, &testaid.TestCDBBad)
 
 	w := &test.ResponseWriter{}
@@ -117,34 +123,21 @@ func TestFBDNSDBBadDBDontWrite(t *testing.T) {
 	code, err := th.ServeDNSWithRCODE(ctx, rec, req)
 	require.Nil(t, err)
 	require.Equal(t, dns.RcodeServerFailure, code, "RcodeServerFailure was expected to be returned")
-	require.False(t, w.GetWriteMsgCallCount() > 0, "WriteMsg was called")
+	require.NotNil(t, rec.Msg)
+	require.Equal(t, dns.RcodeServerFailure, rec.Msg.Rcode)
 }
 
 // TestDNSDBOldFindLocation checks locations
 func TestDNSDBOldFindLocation(t *testing.T) {
-	_separateBitMap := db.SeparateBitMap
-	db.SeparateBitMap = false
-	defer func() {
-		db.SeparateBitMap = _separateBitMap
-	}()
-
-	t.Run("cdb !SeparateBitMap", func(t *testing.T) {
-		testAnyDBOldFindLocation(t)
+	t.Run("!SeparateBitMap", func(t *testing.T) {
+		testAnyDBOldFindLocation(t, false)
 	})
-
-	t.Run("rocksdb !SeparateBitMap", func(t *testing.T) {
-		testAnyDBOldFindLocation(t)
-	})
-	db.SeparateBitMap = true
-	t.Run("cdb SeparateBitMap", func(t *testing.T) {
-		testAnyDBOldFindLocation(t)
-	})
-	t.Run("rocksdb SeparateBitMap", func(t *testing.T) {
-		testAnyDBOldFindLocation(t)
+	t.Run("SeparateBitMap", func(t *testing.T) {
+		testAnyDBOldFindLocation(t, true)
 	})
 }
 
-func testAnyDBOldFindLocation(t *testing.T) {
+func testAnyDBOldFindLocation(t *testing.T, separateBitMap bool) {
 	testCases := []struct {
 		qname         string
 		qtype         uint16
@@ -164,7 +157,7 @@ func testAnyDBOldFindLocation(t *testing.T) {
 	}
 
 	for _, db := range testaid.TestDBs {
-		th := OpenDbForTesting(t, &db)
+		th := openDbForTestingWithConfig(t, DBConfig{Path: db.Path, Driver: db.Driver, ReloadInterval: 10, SeparateBitMap: separateBitMap})
 		defer th.Close()
 		for i, tc := range testCases {
 			t.Run(fmt.Sprintf("%s/%v", db.Driver, i), func(t *testing.T) {
@@ -786,6 +779,8 @@ func TestCNAMEchasing(t *testing.T) {
 		resolver       string
 		ecs            string
 		expectedExtra  []dns.RR
+		// zone of the SOA expected in the authority section
+		expectedSOA string
 	}{
 		// Multiple hops of CNAME chaining should be followed
 		{
@@ -1024,6 +1019,44 @@ func TestCNAMEchasing(t *testing.T) {
 			},
 			resolver: "1.1.1.1", // resolver for locID 2
 		},
+		// Chain ending on a name that doesn't exist in our zones
+		{
+			qname:        "dangling.example.org.",
+			qtype:        dns.TypeA,
+			expectedCode: dns.RcodeNameError,
+			expectedAnswer: []dns.RR{
+				&dns.CNAME{
+					Hdr: dns.RR_Header{
+						Name:   "dangling.example.org.",
+						Rrtype: dns.TypeCNAME,
+						Class:  dns.ClassINET,
+						Ttl:    3600,
+					},
+					Target: "missing.example.org.",
+				},
+			},
+			expectedSOA: "example.org.",
+			resolver:    "1.1.1.1", // resolver for locID 2
+		},
+		// Chain ending on a name without records of the query type
+		{
+			qname:        "www3.example.org.",
+			qtype:        dns.TypeMX,
+			expectedCode: dns.RcodeSuccess,
+			expectedAnswer: []dns.RR{
+				&dns.CNAME{
+					Hdr: dns.RR_Header{
+						Name:   "www3.example.org.",
+						Rrtype: dns.TypeCNAME,
+						Class:  dns.ClassINET,
+						Ttl:    3600,
+					},
+					Target: "bar.example.org.",
+				},
+			},
+			expectedSOA: "example.org.",
+			resolver:    "1.1.1.1", // resolver for locID 2
+		},
 	}
 	for _, db := range testaid.TestDBs {
 		th := OpenDbForTesting(t, &db)
@@ -1054,6 +1087,12 @@ func TestCNAMEchasing(t *testing.T) {
 						RRSliceMatch(t, tc.expectedExtra, rec.Msg.Extra)
 					}
 				}
+				if tc.expectedSOA != "" {
+					require.Equal(t, tc.expectedCode, rec.Msg.Rcode)
+					require.Len(t, rec.Msg.Ns, 1)
+					require.Equal(t, dns.TypeSOA, rec.Msg.Ns[0].Header().Rrtype)
+					require.Equal(t, tc.expectedSOA, rec.Msg.Ns[0].Header().Name)
+				}
 			})
 		}
 	}
@@ -1969,6 +2008,28 @@ func TestHandlerCache(t *testing.T) {
 	}
 }
 
+// TestHandlerCacheQNameCase tests that queries differing only by the case of
+// their name share cache entries, and still get answers spelled like them.
+func TestHandlerCacheQNameCase(t *testing.T) {
+	ctr := stats.NewCounters()
+	th := createFBDNSDBWithCache(t, ctr)
+
+	for _, qname := range []string{"www.example.com.", "WwW.eXaMpLe.CoM.", "WWW.EXAMPLE.COM."} {
+		req := new(dns.Msg)
+		req.SetQuestion(qname, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+		require.NoError(t, err)
+		require.Equal(t, qname, rec.Msg.Question[0].Name)
+		require.NotEmpty(t, rec.Msg.Answer)
+		for _, rr := range rec.Msg.Answer {
+			require.Equal(t, qname, rr.Header().Name)
+		}
+	}
+	require.Equal(t, int64(1), ctr["DNS_cache.missed"])
+	require.Equal(t, int64(2), ctr["DNS_cache.hit"])
+}
+
 // TestHandlerNoCache tests that we DO NOT exercise the caching path when
 // caching is disabled.
 func TestHandlerNoCache(t *testing.T) {
@@ -2005,6 +2066,7 @@ func TestHandlerNoCache(t *testing.T) {
 }
 
 func TestReloadPartial(t *testing.T) {
+	testaid.RequireRocksDB(t)
 	th := OpenDbForTesting(t, &testaid.TestRDB)
 	ctr := stats.NewCounters()
 	th.stats = ctr
@@ -2016,7 +2078,25 @@ func TestReloadPartial(t *testing.T) {
 	require.Zero(t, ctr["DNS_db.ErrReloadTimeout"])
 }
 
+func TestCatchUpInterval(t *testing.T) {
+	th := OpenDbForTesting(t, &testaid.TestCDB)
+	defer close(th.done)
+	go th.periodicReload(time.Millisecond)
+	for i := 0; i < 2; i++ {
+		select {
+		case reload := <-th.ReloadChan:
+			require.Equal(t, *NewPartialReloadSignal(), reload)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected catching up to request a partial reload")
+		}
+	}
+
+	_, err := NewFBDNSDBBasic(HandlerConfig{}, DBConfig{Path: testaid.TestRDB.Path, Driver: "rocksdb", ReadOnly: true, CatchUpInterval: time.Second}, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, &stats.DummyStats{})
+	require.Error(t, err)
+}
+
 func TestReloadFull(t *testing.T) {
+	testaid.RequireRocksDB(t)
 	th := OpenDbForTesting(t, &testaid.TestRDB)
 	ctr := stats.NewCounters()
 	th.stats = ctr
@@ -2038,6 +2118,7 @@ func TestReloadFull(t *testing.T) {
 }
 
 func TestReloadFullTimeoutRDB(t *testing.T) {
+	testaid.RequireRocksDB(t)
 	th := OpenDbForTesting(t, &testaid.TestRDB)
 	ctr := stats.NewCounters()
 	th.stats = ctr
@@ -2236,6 +2317,48 @@ func TestDNSDBMinimalNSInAuth(t *testing.T) {
 			expectedCode: dns.RcodeSuccess,
 			expectedAuth: makeSOA("example.com."),
 		},
+		// DS/signed is served by the parent at the delegation point
+		{
+			qname:        "signed.example.com.",
+			qtype:        dns.TypeDS,
+			expectedCode: dns.RcodeSuccess,
+			expectedAnswer: []dns.RR{
+				&dns.DS{
+					Hdr: dns.RR_Header{
+						Name:   "signed.example.com.",
+						Rrtype: dns.TypeDS,
+						Class:  dns.ClassINET,
+						Ttl:    3600,
+					},
+					KeyTag:     12345,
+					Algorithm:  dns.ECDSAP256SHA256,
+					DigestType: dns.SHA256,
+					Digest:     "3490A6806D47F17A34C29E2CE80E8A999FFBE4BE4B7C8E3B0D3CD35A29D0C5E3",
+				},
+			},
+		},
+		// DS/www.signed is in the child zone
+		{
+			qname:        "www.signed.example.com.",
+			qtype:        dns.TypeDS,
+			expectedCode: dns.RcodeSuccess,
+			expectedAuth: []dns.RR{
+				&dns.NS{
+					Hdr: dns.RR_Header{Name: "signed.example.com.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 172800},
+					Ns:  "a.ns.nonauth.example.com.",
+				},
+			},
+			expectedExtra: []dns.RR{
+				&dns.AAAA{
+					Hdr:  dns.RR_Header{Name: "a.ns.nonauth.example.com.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 172800},
+					AAAA: net.ParseIP("fd09:24f5:dead:beef:1::35"),
+				},
+				&dns.A{
+					Hdr: dns.RR_Header{Name: "a.ns.nonauth.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 172800},
+					A:   net.ParseIP("6.5.5.5"),
+				},
+			},
+		},
 	}
 
 	for _, db := range testaid.TestDBs {
@@ -2693,3 +2816,488 @@ func TestCompressionEnforced(t *testing.T) {
 		}
 	}
 }
+
+// TestPreserveQNameCase checks that responses copy the exact case of the
+// query name, even when served from the cache for a query with another case.
+func TestPreserveQNameCase(t *testing.T) {
+	qnames := []struct {
+		name  string
+		qtype uint16
+	}{
+		{name: "WwW.ExAmPlE.cOm.", qtype: dns.TypeA},
+		{name: "FoO.eXaMpLe.CoM.", qtype: dns.TypeAAAA},
+		// wildcard
+		{name: "NoThErE.eXaMpLe.NeT.", qtype: dns.TypeA},
+		// no data
+		{name: "ExAmPlE.cOm.", qtype: dns.TypeCAA},
+		// referral
+		{name: "NoNaUtH.eXaMpLe.CoM.", qtype: dns.TypeNS},
+	}
+	for _, db := range testaid.TestDBs {
+		for _, cache := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/cache=%v", db.Driver, cache), func(t *testing.T) {
+				dbConfig := DBConfig{Path: db.Path, Driver: db.Driver}
+				cacheConfig := CacheConfig{Enabled: cache, LRUSize: 1024}
+				handlerConfig := HandlerConfig{PreserveQNameCase: true}
+				th, err := NewFBDNSDBBasic(handlerConfig, dbConfig, cacheConfig, &TextLogger{IoWriter: os.Stdout}, &stats.DummyStats{})
+				require.NoError(t, err)
+				require.NoError(t, th.Load())
+				defer th.Close()
+
+				for _, q := range qnames {
+					// the lower case query fills the cache first
+					for _, qname := range []string{strings.ToLower(q.name), q.name, strings.ToUpper(q.name)} {
+						req := new(dns.Msg)
+						req.SetQuestion(qname, q.qtype)
+						rec := dnstest.NewRecorder(&test.ResponseWriter{})
+						_, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+						require.NoError(t, err)
+						require.Equal(t, qname, rec.Msg.Question[0].Name)
+						owners := 0
+						for _, rr := range append(append(rec.Msg.Answer, rec.Msg.Ns...), rec.Msg.Extra...) {
+							if strings.EqualFold(rr.Header().Name, qname) {
+								require.Equal(t, qname, rr.Header().Name, rr.String())
+								owners++
+							}
+						}
+						require.NotZero(t, owners, rec.Msg.String())
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestALIASFlattening(t *testing.T) {
+	testCases := []struct {
+		qname          string
+		qtype          uint16
+		expectedAnswer []dns.RR
+	}{
+		// the target's records for the resolver's location, owned by the apex
+		{
+			qname: "example.org.",
+			qtype: dns.TypeA,
+			expectedAnswer: []dns.RR{
+				&dns.A{
+					Hdr: dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 180},
+					A:   net.ParseIP("1.1.1.2"),
+				},
+			},
+		},
+		{
+			qname: "example.org.",
+			qtype: dns.TypeAAAA,
+			expectedAnswer: []dns.RR{
+				&dns.AAAA{
+					Hdr:  dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 180},
+					AAAA: net.ParseIP("fd24:7859:f076:2a21::2"),
+				},
+			},
+		},
+		// other types are not flattened
+		{
+			qname: "example.org.",
+			qtype: dns.TypeMX,
+		},
+		// ALIAS targets are not flattened further
+		{
+			qname: "alias.example.org.",
+			qtype: dns.TypeA,
+		},
+		// targets out of our zones are not resolved
+		{
+			qname: "outside.example.org.",
+			qtype: dns.TypeA,
+		},
+	}
+	for _, db := range testaid.TestDBs {
+		th := OpenDbForTesting(t, &db)
+		defer th.Close()
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s/%s/%s", db.Driver, tc.qname, dns.TypeToString[tc.qtype]), func(t *testing.T) {
+				req := new(dns.Msg)
+				req.SetQuestion(tc.qname, tc.qtype)
+				rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: "1.1.1.1"}) // resolver for locID 2
+				code, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+				require.NoError(t, err)
+				require.Equal(t, dns.RcodeSuccess, code)
+				if tc.expectedAnswer == nil {
+					// no data
+					require.Empty(t, rec.Msg.Answer)
+					require.Len(t, rec.Msg.Ns, 1)
+					require.Equal(t, dns.TypeSOA, rec.Msg.Ns[0].Header().Rrtype)
+					return
+				}
+				RRSliceMatch(t, tc.expectedAnswer, rec.Msg.Answer)
+			})
+		}
+	}
+}
+
+func TestTTLOverrides(t *testing.T) {
+	testCases := []struct {
+		resolver    string
+		expectedTTL uint32
+	}{
+		{
+			resolver:    "1.1.1.1", // resolver for locID 2
+			expectedTTL: 60,
+		},
+		{
+			resolver:    "2.2.2.2", // resolver for locID 3
+			expectedTTL: 120,
+		},
+	}
+	for _, db := range testaid.TestDBs {
+		th := OpenDbForTesting(t, &db)
+		defer th.Close()
+		for _, tc := range testCases {
+			for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeANY} {
+				t.Run(fmt.Sprintf("%s/%s/%s", db.Driver, tc.resolver, dns.TypeToString[qtype]), func(t *testing.T) {
+					req := new(dns.Msg)
+					req.SetQuestion("ttl.example.org.", qtype)
+					rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: tc.resolver})
+					code, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+					require.NoError(t, err)
+					require.Equal(t, dns.RcodeSuccess, code)
+					require.NotEmpty(t, rec.Msg.Answer)
+					for _, rr := range rec.Msg.Answer {
+						require.Equal(t, tc.expectedTTL, rr.Header().Ttl, rr.String())
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestDualStackPolicy(t *testing.T) {
+	testCases := []struct {
+		qname    string
+		resolver string
+		qtype    uint16
+		expected []uint16
+	}{
+		{
+			qname:    "ds.example.org.",
+			resolver: "1.1.1.1", // resolver for locID 2, v6only
+			qtype:    dns.TypeA,
+		},
+		{
+			qname:    "ds.example.org.",
+			resolver: "1.1.1.1",
+			qtype:    dns.TypeAAAA,
+			expected: []uint16{dns.TypeAAAA},
+		},
+		{
+			qname:    "ds.example.org.",
+			resolver: "2.2.2.2", // resolver for locID 3, default v4only
+			qtype:    dns.TypeA,
+			expected: []uint16{dns.TypeA},
+		},
+		{
+			qname:    "ds.example.org.",
+			resolver: "2.2.2.2",
+			qtype:    dns.TypeAAAA,
+		},
+		{
+			qname:    "ds.example.org.",
+			resolver: "2.2.2.2",
+			qtype:    dns.TypeANY,
+			expected: []uint16{dns.TypeA},
+		},
+		{
+			// zone policy
+			qname:    "www.ipv4.example.net.",
+			resolver: "1.1.1.1",
+			qtype:    dns.TypeAAAA,
+		},
+		{
+			qname:    "www.ipv4.example.net.",
+			resolver: "1.1.1.1",
+			qtype:    dns.TypeA,
+			expected: []uint16{dns.TypeA},
+		},
+		{
+			// no policy
+			qname:    "ttl.example.org.",
+			resolver: "1.1.1.1",
+			qtype:    dns.TypeAAAA,
+			expected: []uint16{dns.TypeAAAA},
+		},
+	}
+	for _, db := range testaid.TestDBs {
+		th := OpenDbForTesting(t, &db)
+		defer th.Close()
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s/%s/%s/%s", db.Driver, tc.qname, tc.resolver, dns.TypeToString[tc.qtype]), func(t *testing.T) {
+				req := new(dns.Msg)
+				req.SetQuestion(tc.qname, tc.qtype)
+				rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: tc.resolver})
+				code, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+				require.NoError(t, err)
+				require.Equal(t, dns.RcodeSuccess, code)
+				require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+				var types []uint16
+				for _, rr := range rec.Msg.Answer {
+					types = append(types, rr.Header().Rrtype)
+				}
+				require.Equal(t, tc.expected, types)
+				if len(tc.expected) == 0 {
+					// NODATA
+					require.Len(t, rec.Msg.Ns, 1)
+					require.Equal(t, dns.TypeSOA, rec.Msg.Ns[0].Header().Rrtype)
+				}
+			})
+		}
+	}
+}
+
+func TestQueryMetadata(t *testing.T) {
+	testCases := []struct {
+		qname    string
+		expected []string
+	}{
+		{
+			qname:    "foo.example.org",
+			expected: []string{"owner=traffic ticket=T1234", "source=data.in, line 2"},
+		},
+		{
+			qname:    "FOO.example.org.",
+			expected: []string{"owner=traffic ticket=T1234", "source=data.in, line 2"},
+		},
+		{
+			qname:    "*.example.com.",
+			expected: []string{"owner=web"},
+		},
+		{
+			qname: "bar.example.org.",
+		},
+	}
+	for _, db := range testaid.TestDBs {
+		th := OpenDbForTesting(t, &db)
+		defer th.Close()
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s/%s", db.Driver, tc.qname), func(t *testing.T) {
+				metadata, err := th.QueryMetadata(tc.qname)
+				require.NoError(t, err)
+				require.ElementsMatch(t, tc.expected, metadata)
+			})
+		}
+	}
+}
+
+func TestLocateClient(t *testing.T) {
+	for _, db := range testaid.TestDBs {
+		th := OpenDbForTesting(t, &db)
+		defer th.Close()
+		t.Run(db.Driver, func(t *testing.T) {
+			loc, err := th.LocateClient("example.com.", nil, "1.1.1.1")
+			require.NoError(t, err)
+			require.Equal(t, []byte("c\000"), []byte(loc.MapID))
+			require.Equal(t, []byte{0, 2}, []byte(loc.LocID))
+
+			// the scope of the ECS option is left untouched
+			o, err := MakeOPTWithECS("1.1.1.0/24")
+			require.NoError(t, err)
+			ecs := o.Option[0].(*dns.EDNS0_SUBNET)
+			loc, err = th.LocateClient("example.com.", ecs, "2.2.2.2")
+			require.NoError(t, err)
+			require.Equal(t, []byte("ec"), []byte(loc.MapID))
+			require.Equal(t, []byte{0, 2}, []byte(loc.LocID))
+			require.Equal(t, uint8(0), ecs.SourceScope)
+
+			_, err = th.LocateClient("bad..name.", nil, "1.1.1.1")
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestReferralOnlyRootZone(t *testing.T) {
+	dir := t.TempDir()
+	data := path.Join(dir, "data")
+	err := os.WriteFile(data, []byte(`Z.,a.root-servers.net,nstld.verisign-grs.com,2024010100,1800,900,604800,86400,86400,,
+&.,,a.root-servers.net,518400,,
++a.root-servers.net,198.41.0.4,518400,,
+&internal,,ns1.internal,172800,,
++ns1.internal,10.0.0.1,172800,,
+Kinternal,20326,8,2,e06d44b80b8f1d39a95c0b0d7c65d08458e880409bbc683457104237c7f8ec8d,86400,,
+`), 0o644)
+	require.NoError(t, err)
+	out := path.Join(dir, "data.cdb")
+	_, err = cdb.CreateCDB(data, out, cdb.NewDefaultCreatorOptions())
+	require.NoError(t, err)
+	th := OpenDbForTesting(t, &testaid.TestDB{Driver: "cdb", Path: out})
+	defer th.Close()
+
+	testCases := []struct {
+		qname         string
+		qtype         string
+		rcode         int
+		authoritative bool
+		answer        []string
+		authority     []string
+	}{
+		{
+			qname:         "www.corp.internal",
+			qtype:         "A",
+			rcode:         dns.RcodeSuccess,
+			authoritative: false,
+			authority:     []string{"internal.\t172800\tIN\tNS\tns1.internal."},
+		},
+		{
+			qname:         "internal",
+			qtype:         "DS",
+			rcode:         dns.RcodeSuccess,
+			authoritative: true,
+			answer:        []string{"internal.\t86400\tIN\tDS\t20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"},
+		},
+		{
+			qname:         "corp.internal",
+			qtype:         "DS",
+			rcode:         dns.RcodeSuccess,
+			authoritative: false,
+			authority:     []string{"internal.\t172800\tIN\tNS\tns1.internal."},
+		},
+		{
+			qname:         "nonexistent",
+			qtype:         "A",
+			rcode:         dns.RcodeNameError,
+			authoritative: true,
+			authority:     []string{".\t86400\tIN\tSOA\ta.root-servers.net. nstld.verisign-grs.com. 2024010100 1800 900 604800 86400"},
+		},
+		{
+			qname:         ".",
+			qtype:         "NS",
+			rcode:         dns.RcodeSuccess,
+			authoritative: true,
+			answer:        []string{".\t518400\tIN\tNS\ta.root-servers.net."},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s/%s", tc.qname, tc.qtype), func(t *testing.T) {
+			rec, err := th.QuerySingle(tc.qtype, tc.qname, "127.0.0.1", "", 1)
+			require.NoError(t, err)
+			require.Equal(t, tc.rcode, rec.Msg.Rcode)
+			require.Equal(t, tc.authoritative, rec.Msg.Authoritative)
+			require.ElementsMatch(t, tc.answer, recordStrings(rec.Msg.Answer))
+			require.ElementsMatch(t, tc.authority, recordStrings(rec.Msg.Ns))
+		})
+	}
+}
+
+func TestEmptyNonTerminals(t *testing.T) {
+	dir := t.TempDir()
+	data := path.Join(dir, "data")
+	err := os.WriteFile(data, []byte(`Zexample.com,ns.example.com,dns.example.com,123,1800,900,604800,3600,300
+&example.com,,ns.example.com,300
++a.b.c.example.com,192.0.2.1,300
++*.example.com,192.0.2.2,300
+`), 0o644)
+	require.NoError(t, err)
+	soa := []string{"example.com.\t300\tIN\tSOA\tns.example.com. dns.example.com. 123 1800 900 604800 3600"}
+
+	for _, ents := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ents=%v", ents), func(t *testing.T) {
+			out := path.Join(dir, fmt.Sprintf("data-%v.cdb", ents))
+			_, err := cdb.CreateCDB(data, out, &cdb.CreatorOptions{NumCPU: 1, EmptyNonTerminals: ents})
+			require.NoError(t, err)
+			th := OpenDbForTesting(t, &testaid.TestDB{Driver: "cdb", Path: out})
+			defer th.Close()
+
+			for _, qname := range []string{"b.c.example.com", "c.example.com"} {
+				rec, err := th.QuerySingle("A", qname, "127.0.0.1", "", 1)
+				require.NoError(t, err)
+				if ents {
+					// the wildcard doesn't apply to names which exist
+					require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+					require.Empty(t, rec.Msg.Answer)
+					require.ElementsMatch(t, soa, recordStrings(rec.Msg.Ns))
+				} else {
+					require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+					require.Equal(t, []string{qname + ".\t300\tIN\tA\t192.0.2.2"}, recordStrings(rec.Msg.Answer))
+				}
+			}
+
+			rec, err := th.QuerySingle("A", "a.b.c.example.com", "127.0.0.1", "", 1)
+			require.NoError(t, err)
+			require.Equal(t, []string{"a.b.c.example.com.\t300\tIN\tA\t192.0.2.1"}, recordStrings(rec.Msg.Answer))
+			rec, err = th.QuerySingle("ANY", "c.example.com", "127.0.0.1", "", 1)
+			require.NoError(t, err)
+			if ents {
+				require.Empty(t, rec.Msg.Answer)
+			}
+		})
+	}
+}
+
+// TestQNameMinimization checks that resolvers minimizing query names (RFC
+// 9156) walk down to multi-label delegations, the names above them being
+// answered with NODATA rather than NXDOMAIN, even with a wildcard
+func TestQNameMinimization(t *testing.T) {
+	testDBs := testaid.NewFixture().
+		Zone("example.com", 300, "ns.example.com").
+		Address("*.example.com", "192.0.2.2", 300, "").
+		NS("a.b.c.example.com", "ns.child.example.net", 300).
+		Build(t)
+
+	soa := []string{"example.com.\t300\tIN\tSOA\tns.example.com. hostmaster.example.com. 1 7200 1800 604800 300"}
+	referral := []string{"a.b.c.example.com.\t300\tIN\tNS\tns.child.example.net."}
+	testCases := []struct {
+		qname         string
+		qtype         string
+		authoritative bool
+		authority     []string
+	}{
+		{qname: "c.example.com", qtype: "NS", authoritative: true, authority: soa},
+		{qname: "c.example.com", qtype: "A", authoritative: true, authority: soa},
+		{qname: "b.c.example.com", qtype: "NS", authoritative: true, authority: soa},
+		{qname: "b.c.example.com", qtype: "A", authoritative: true, authority: soa},
+		{qname: "a.b.c.example.com", qtype: "NS", authoritative: false, authority: referral},
+		{qname: "a.b.c.example.com", qtype: "A", authoritative: false, authority: referral},
+		{qname: "www.a.b.c.example.com", qtype: "A", authoritative: false, authority: referral},
+	}
+	for _, testDB := range testDBs {
+		t.Run(testDB.Driver+"/"+testDB.Flavour, func(t *testing.T) {
+			th := OpenDbForTesting(t, &testDB)
+			defer th.Close()
+
+			for _, tc := range testCases {
+				t.Run(fmt.Sprintf("%s/%s", tc.qname, tc.qtype), func(t *testing.T) {
+					rec, err := th.QuerySingle(tc.qtype, tc.qname, "127.0.0.1", "", 1)
+					require.NoError(t, err)
+					require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+					require.Equal(t, tc.authoritative, rec.Msg.Authoritative)
+					require.Empty(t, rec.Msg.Answer)
+					require.ElementsMatch(t, tc.authority, recordStrings(rec.Msg.Ns))
+				})
+			}
+
+			// names off the path to the delegation are still answered by the wildcard
+			rec, err := th.QuerySingle("A", "d.example.com", "127.0.0.1", "", 1)
+			require.NoError(t, err)
+			require.Equal(t, []string{"d.example.com.\t300\tIN\tA\t192.0.2.2"}, recordStrings(rec.Msg.Answer))
+		})
+	}
+}
+
+// TestDBFaults checks that the handler fails queries with SERVFAIL when its
+// DB lookups fail
+func TestDBFaults(t *testing.T) {
+	for _, testDB := range testaid.TestDBs {
+		t.Run(testDB.Driver+"/"+testDB.Flavour, func(t *testing.T) {
+			th := openDbForTestingWithConfig(t, DBConfig{Path: testDB.Path, Driver: testDB.Driver, Faults: db.FaultConfig{ErrorRate: 1}})
+			defer th.Close()
+			req := new(dns.Msg)
+			req.SetQuestion("www.example.com.", dns.TypeA)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			// the server writes the SERVFAIL responses the handler returns
+			rcode, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+			require.NoError(t, err)
+			require.Equal(t, dns.RcodeServerFailure, rcode)
+			require.NotZero(t, th.dnsdb.GetStats()["fault.errors"])
+		})
+	}
+	_, err := NewFBDNSDBBasic(HandlerConfig{}, DBConfig{Faults: db.FaultConfig{ErrorRate: 2}}, CacheConfig{}, &DummyLogger{}, &stats.DummyStats{})
+	require.Error(t, err)
+}
@@ -0,0 +1,298 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Health check defaults
+const (
+	DefaultHealthInterval = 10 * time.Second
+	DefaultHealthFall     = 3
+	DefaultHealthRise     = 2
+)
+
+// Health states, passed to the health hook
+const (
+	HealthUp   = "up"
+	HealthDown = "down"
+)
+
+// HealthConfig configures the health checker, which periodically runs self
+// queries against the live DB, e.g. to withdraw the anycast route of an
+// instance which can't answer correctly.
+type HealthConfig struct {
+	// Addr is the host:port the /health endpoint listens on, or the path of
+	// a unix socket if it starts with "/". Disabled if empty.
+	Addr string
+	// Hook is a command run with HealthUp or HealthDown as last argument
+	// when the instance starts and each time its health changes, e.g. to
+	// announce or withdraw a BGP route. Disabled if empty.
+	Hook string
+	// Checks are the self queries run every Interval. They all must pass for
+	// the round to pass.
+	Checks []ReloadCheck
+	// Interval is the time between rounds, DefaultHealthInterval if 0. It
+	// is also the timeout of the hook.
+	Interval time.Duration
+	// Fall is the number of consecutive failed rounds making the instance
+	// unhealthy, DefaultHealthFall if 0
+	Fall int
+	// Rise is the number of consecutive passed rounds making the instance
+	// healthy, DefaultHealthRise if 0
+	Rise int
+}
+
+// HealthChecker runs the health checks of a FBDNSDB and reports its health
+type HealthChecker struct {
+	h      *FBDNSDB
+	conf   HealthConfig
+	hook   []string
+	server *http.Server
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	healthy  bool
+	passed   int
+	failed   int
+	lastErr  error
+	lastTime time.Time
+}
+
+// NewHealthChecker validates c and returns the matching HealthChecker, or
+// nil when it is disabled. The instance is unhealthy until Rise rounds pass.
+func NewHealthChecker(h *FBDNSDB, c HealthConfig) (*HealthChecker, error) {
+	if c.Addr == "" && c.Hook == "" {
+		return nil, nil
+	}
+	if len(c.Checks) == 0 {
+		return nil, fmt.Errorf("health checker enabled without checks")
+	}
+	if c.Addr != "" && !strings.HasPrefix(c.Addr, "/") {
+		if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+			return nil, fmt.Errorf("invalid health server address %q: %w", c.Addr, err)
+		}
+	}
+	if c.Interval < 0 || c.Fall < 0 || c.Rise < 0 {
+		return nil, fmt.Errorf("invalid health config %+v", c)
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultHealthInterval
+	}
+	if c.Fall == 0 {
+		c.Fall = DefaultHealthFall
+	}
+	if c.Rise == 0 {
+		c.Rise = DefaultHealthRise
+	}
+	s := &HealthChecker{
+		h:    h,
+		conf: c,
+		hook: strings.Fields(c.Hook),
+		done: make(chan struct{}),
+	}
+	if c.Addr != "" {
+		s.server = &http.Server{Addr: c.Addr, Handler: s.Handler()}
+	}
+	return s, nil
+}
+
+// Handler returns the handler serving /health, which answers 200 if the
+// instance is healthy and 503 otherwise
+func (s *HealthChecker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.health)
+	return mux
+}
+
+// Healthy returns whether the instance is healthy, and the error of the
+// last failed round if any
+func (s *HealthChecker) Healthy() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.healthy, s.lastErr
+}
+
+func (s *HealthChecker) health(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	healthy, lastErr, lastTime := s.healthy, s.lastErr, s.lastTime
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if lastErr != nil {
+			fmt.Fprintf(w, "DOWN: %v\n", lastErr)
+			return
+		}
+		fmt.Fprintln(w, "DOWN")
+		return
+	}
+	fmt.Fprintf(w, "UP, last checked %s\n", lastTime.Format(time.RFC3339))
+}
+
+// listen listens on the configured TCP address or unix socket
+func (s *HealthChecker) listen() (net.Listener, error) {
+	if !strings.HasPrefix(s.conf.Addr, "/") {
+		return net.Listen("tcp", s.conf.Addr)
+	}
+	// remove the socket left behind by a previous instance
+	if err := os.Remove(s.conf.Addr); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return net.Listen("unix", s.conf.Addr)
+}
+
+// Start runs the hook with HealthDown, then serves /health and runs the
+// checks in the background
+func (s *HealthChecker) Start() error {
+	if s.server != nil {
+		ln, err := s.listen()
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", s.conf.Addr, err)
+		}
+		go func() {
+			if err := s.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+				glog.Errorf("Health HTTP server failed: %v", err)
+			}
+		}()
+	}
+	s.runHook(HealthDown)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.conf.Interval)
+		defer ticker.Stop()
+		for {
+			s.check()
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops the checks and the server. It doesn't run the hook, the
+// instance being stopped is expected to be withdrawn by other means.
+func (s *HealthChecker) Shutdown() error {
+	close(s.done)
+	s.wg.Wait()
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Shutdown(context.Background())
+}
+
+// probeKey is the context key marking the queries of the health checks
+type probeKey struct{}
+
+// isProbe tells if the query of ctx is sent by the health checker, which
+// skips the cache and isn't accounted for
+func isProbe(ctx context.Context) bool {
+	_, ok := ctx.Value(probeKey{}).(bool)
+	return ok
+}
+
+// round runs all the checks, and returns an error listing the failed ones
+func (s *HealthChecker) round() error {
+	var failed []string
+	ctx := context.WithValue(context.Background(), probeKey{}, true)
+	for _, c := range s.conf.Checks {
+		if err := c.run(ctx, s.h); err != nil {
+			s.h.stats.IncrementCounter("DNS_health.check_failed")
+			failed = append(failed, fmt.Sprintf("%s %s: %v", c.Name, c.Type, err))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// check runs a round and updates the health of the instance, running the
+// hook if it changes
+func (s *HealthChecker) check() {
+	err := s.round()
+	s.mu.Lock()
+	s.lastTime = time.Now()
+	changed := false
+	if err != nil {
+		s.lastErr = err
+		s.passed = 0
+		s.failed++
+		if s.healthy && s.failed >= s.conf.Fall {
+			s.healthy, changed = false, true
+		}
+	} else {
+		s.failed = 0
+		s.passed++
+		if !s.healthy && s.passed >= s.conf.Rise {
+			s.healthy, changed = true, true
+			s.lastErr = nil
+		}
+	}
+	healthy := s.healthy
+	s.mu.Unlock()
+
+	if err != nil {
+		glog.Errorf("Health check failed: %v", err)
+	}
+	if healthy {
+		s.h.stats.ResetCounterTo("DNS_health.healthy", 1)
+	} else {
+		s.h.stats.ResetCounterTo("DNS_health.healthy", 0)
+	}
+	if !changed {
+		return
+	}
+	if healthy {
+		glog.Infof("Instance is healthy after %d passed health checks", s.conf.Rise)
+		s.runHook(HealthUp)
+	} else {
+		glog.Errorf("Instance is unhealthy after %d failed health checks", s.conf.Fall)
+		s.runHook(HealthDown)
+	}
+}
+
+// runHook runs the hook with state as last argument, if any
+func (s *HealthChecker) runHook(state string) {
+	if len(s.hook) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.conf.Interval)
+	defer cancel()
+	args := append(append([]string{}, s.hook[1:]...), state)
+	out, err := exec.CommandContext(ctx, s.hook[0], args...).CombinedOutput()
+	if err != nil {
+		glog.Errorf("Health hook %q %s failed: %v: %s", s.conf.Hook, state, err, out)
+		s.h.stats.IncrementCounter("DNS_health.hook_failed")
+		return
+	}
+	s.h.stats.IncrementCounter("DNS_health.hook_" + state)
+}
@@ -0,0 +1,167 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+var healthChecks = []ReloadCheck{
+	{Name: "foo.example.org", Type: "A", Rcode: dns.RcodeSuccess, MinAnswers: 1, Client: "1.1.1.1"},
+	{Name: "nxdomain.example.org", Type: "A", Rcode: dns.RcodeNameError},
+}
+
+func getHealth(t *testing.T, client *http.Client, url string) (int, string) {
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestNewHealthChecker(t *testing.T) {
+	s, err := NewHealthChecker(nil, HealthConfig{Checks: healthChecks})
+	require.NoError(t, err)
+	require.Nil(t, s)
+
+	_, err = NewHealthChecker(nil, HealthConfig{Addr: "localhost:0"})
+	require.Error(t, err)
+	_, err = NewHealthChecker(nil, HealthConfig{Addr: "localhost", Checks: healthChecks})
+	require.Error(t, err)
+	_, err = NewHealthChecker(nil, HealthConfig{Hook: "true", Checks: healthChecks, Fall: -1})
+	require.Error(t, err)
+
+	s, err = NewHealthChecker(nil, HealthConfig{Addr: "/run/dnsrocks-health.sock", Checks: healthChecks})
+	require.NoError(t, err)
+	require.Equal(t, DefaultHealthInterval, s.conf.Interval)
+	require.Equal(t, DefaultHealthFall, s.conf.Fall)
+	require.Equal(t, DefaultHealthRise, s.conf.Rise)
+}
+
+// TestHealthChecker checks that the health of the instance follows the
+// rise and fall thresholds, and that the hook runs on changes
+func TestHealthChecker(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook is a shell script")
+	}
+	th := OpenDbForTesting(t, &testaid.TestCDB)
+	defer th.Close()
+	ctr := stats.NewCounters()
+	th.stats = ctr
+
+	dir := t.TempDir()
+	states := path.Join(dir, "states")
+	hook := path.Join(dir, "hook.sh")
+	require.NoError(t, os.WriteFile(hook, []byte("#!/bin/sh\necho $2 $1 >> "+states+"\n"), 0o755))
+
+	s, err := NewHealthChecker(th, HealthConfig{Addr: "localhost:0", Hook: hook + " anycast", Checks: healthChecks, Fall: 2, Rise: 2})
+	require.NoError(t, err)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	code, _ := getHealth(t, ts.Client(), ts.URL+"/health")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+
+	s.check()
+	healthy, _ := s.Healthy()
+	require.False(t, healthy)
+	s.check()
+	healthy, _ = s.Healthy()
+	require.True(t, healthy)
+	code, body := getHealth(t, ts.Client(), ts.URL+"/health")
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, strings.HasPrefix(body, "UP"), body)
+	require.Equal(t, int64(1), ctr["DNS_health.healthy"])
+
+	s.conf.Checks = append(s.conf.Checks, ReloadCheck{Name: "example.org", Type: "MX", Rcode: dns.RcodeSuccess, MinAnswers: 1})
+	s.check()
+	healthy, err = s.Healthy()
+	require.True(t, healthy)
+	require.Error(t, err)
+	s.check()
+	healthy, _ = s.Healthy()
+	require.False(t, healthy)
+	code, body = getHealth(t, ts.Client(), ts.URL+"/health")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Contains(t, body, "example.org MX: got 0 answers, expected at least 1")
+	require.Equal(t, int64(0), ctr["DNS_health.healthy"])
+	require.Equal(t, int64(2), ctr["DNS_health.check_failed"])
+
+	out, err := os.ReadFile(states)
+	require.NoError(t, err)
+	require.Equal(t, "up anycast\ndown anycast\n", string(out))
+	require.Equal(t, int64(1), ctr["DNS_health.hook_up"])
+	require.Equal(t, int64(1), ctr["DNS_health.hook_down"])
+}
+
+func TestHealthCheckerUnixSocket(t *testing.T) {
+	th := OpenDbForTesting(t, &testaid.TestCDB)
+	defer th.Close()
+	sock := path.Join(t.TempDir(), "health.sock")
+	s, err := NewHealthChecker(th, HealthConfig{Addr: sock, Checks: healthChecks, Interval: 10 * time.Millisecond, Rise: 1})
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	defer func() {
+		require.NoError(t, s.Shutdown())
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	require.Eventually(t, func() bool {
+		code, _ := getHealth(t, client, "http://health/health")
+		return code == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// TestHealthProbesUnaccounted checks that the queries of the health checks
+// are answered from the DB, without being accounted for like client ones
+func TestHealthProbesUnaccounted(t *testing.T) {
+	dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+	cacheConfig := CacheConfig{Enabled: true, LRUSize: 1024}
+	ctr := stats.NewCounters()
+	th, err := NewFBDNSDBBasic(HandlerConfig{TopTalkers: TopTalkersConfig{Size: 10}}, dbConfig, cacheConfig, &DummyLogger{}, ctr)
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+
+	s, err := NewHealthChecker(th, HealthConfig{Hook: "true", Checks: healthChecks})
+	require.NoError(t, err)
+	require.NoError(t, s.round())
+	require.Zero(t, ctr["DNS_queries"])
+	require.Zero(t, ctr["DNS_cache.missed"])
+	require.Zero(t, th.lru.Len())
+	report := th.topTalkers.report(0)
+	require.Empty(t, report.Subnets)
+	require.Empty(t, report.Names)
+}
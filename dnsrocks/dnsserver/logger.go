@@ -38,9 +38,14 @@ type TextLogger struct {
 }
 
 // Log is used to log to an ioWriter.
+// The protocol is the transport of the query when known, e.g. DOT.
 func (l *TextLogger) Log(state request.Request, _ *dns.Msg, _ *dns.EDNS0_SUBNET, _ *db.Location) {
+	proto := state.Proto()
+	if info, ok := ClientInfoOf(state); ok {
+		proto = string(info.Transport)
+	}
 	fmt.Fprintf(l.IoWriter, "[%s] %s %s %s\n",
-		state.IP(), strings.ToUpper(state.Proto()),
+		state.IP(), strings.ToUpper(proto),
 		state.Name(), state.Type())
 }
 
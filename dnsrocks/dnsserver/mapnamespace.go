@@ -0,0 +1,156 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/facebook/dns/dnsrocks/db"
+)
+
+// MapNamespace makes the queries received on some listeners look their client
+// up in other maps than the ones their names are assigned to, so that the same
+// records are served with another mapping of clients to locations, e.g. a corp
+// view served on its own anycast address.
+type MapNamespace struct {
+	// Name identifies the namespace in stats
+	Name string
+	// Listeners are the addresses, with or without port, of the listeners
+	// whose queries use the namespace, as bound
+	Listeners []string
+	// Maps are the IDs of the maps used instead of the maps names are
+	// assigned to, by ID of the latter, written the way the data format does,
+	// e.g. `ec` or `\000\001`. Other maps are used as is.
+	Maps map[string]string
+}
+
+// String returns the namespace as parsed by ParseMapNamespace
+func (n MapNamespace) String() string {
+	maps := make([]string, 0, len(n.Maps))
+	for from, to := range n.Maps {
+		maps = append(maps, from+"="+to)
+	}
+	sort.Strings(maps)
+	return fmt.Sprintf("%s listeners=%s maps=%s", n.Name, strings.Join(n.Listeners, ","), strings.Join(maps, ","))
+}
+
+// ParseMapNamespace parses a namespace written as
+// `name listeners=addr,... maps=from=to,...`, e.g.
+// `corp listeners=192.0.2.53,[2001:db8::53]:53 maps=ec=ce,rw=wr`
+func ParseMapNamespace(s string) (MapNamespace, error) {
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return MapNamespace{}, fmt.Errorf("empty map namespace")
+	}
+	n := MapNamespace{Name: f[0], Maps: make(map[string]string)}
+	for _, field := range f[1:] {
+		name, value, found := strings.Cut(field, "=")
+		if !found {
+			return n, fmt.Errorf("invalid field %q in namespace %q, expected listeners= or maps=", field, s)
+		}
+		switch name {
+		case "listeners":
+			n.Listeners = append(n.Listeners, splitList(value)...)
+		case "maps":
+			for _, pair := range splitList(value) {
+				from, to, found := strings.Cut(pair, "=")
+				if !found {
+					return n, fmt.Errorf("invalid map replacement %q in namespace %q, expected from=to", pair, s)
+				}
+				n.Maps[from] = to
+			}
+		default:
+			return n, fmt.Errorf("unknown field %q in namespace %q, expected listeners= or maps=", field, s)
+		}
+	}
+	return n, nil
+}
+
+// mapNamespace applies a validated MapNamespace
+type mapNamespace struct {
+	listeners []string
+	maps      db.MapNamespace
+	statsKey  string
+}
+
+// newMapNamespaces validates namespaces and returns the matching
+// mapNamespaces, in the same order
+func newMapNamespaces(namespaces []MapNamespace) ([]*mapNamespace, error) {
+	var (
+		result    []*mapNamespace
+		names     = make(map[string]bool)
+		listeners = make(map[string]string)
+	)
+	for _, n := range namespaces {
+		if n.Name == "" {
+			return nil, fmt.Errorf("map namespace without a name")
+		}
+		if names[n.Name] {
+			return nil, fmt.Errorf("duplicate map namespace %q", n.Name)
+		}
+		names[n.Name] = true
+		if len(n.Listeners) == 0 {
+			return nil, fmt.Errorf("map namespace %q has no listeners", n.Name)
+		}
+		for _, l := range n.Listeners {
+			if other, found := listeners[l]; found {
+				return nil, fmt.Errorf("listener %s is in both map namespaces %q and %q", l, other, n.Name)
+			}
+			listeners[l] = n.Name
+		}
+		if len(n.Maps) == 0 {
+			return nil, fmt.Errorf("map namespace %q replaces no maps", n.Name)
+		}
+		ns := &mapNamespace{
+			listeners: n.Listeners,
+			maps:      make(db.MapNamespace, len(n.Maps)),
+			statsKey:  "DNS_map_namespace." + n.Name,
+		}
+		for from, to := range n.Maps {
+			fromID, err := db.ParseID(from)
+			if err != nil {
+				return nil, fmt.Errorf("map namespace %q: %w", n.Name, err)
+			}
+			toID, err := db.ParseID(to)
+			if err != nil {
+				return nil, fmt.Errorf("map namespace %q: %w", n.Name, err)
+			}
+			ns.maps[string(fromID)] = toID
+		}
+		result = append(result, ns)
+	}
+	return result, nil
+}
+
+// mapNamespace returns the map namespace of the query of state, nil if it
+// uses the maps its name is assigned to
+func (h *FBDNSDB) mapNamespace(ctx context.Context, state request.Request) *mapNamespace {
+	if len(h.mapNamespaces) == 0 {
+		return nil
+	}
+	listener := queryListener(ctx, state)
+	for _, ns := range h.mapNamespaces {
+		for _, l := range ns.listeners {
+			if listenerMatches(l, listener) {
+				return ns
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestParseMapNamespace(t *testing.T) {
+	n, err := ParseMapNamespace("corp listeners=192.0.2.53,[2001:db8::53]:53 maps=pr=corp,ec=\\000\\001")
+	require.NoError(t, err)
+	require.Equal(t, MapNamespace{
+		Name:      "corp",
+		Listeners: []string{"192.0.2.53", "[2001:db8::53]:53"},
+		Maps:      map[string]string{"pr": "corp", "ec": `\000\001`},
+	}, n)
+	require.Equal(t, "corp listeners=192.0.2.53,[2001:db8::53]:53 maps=ec=\\000\\001,pr=corp", n.String())
+
+	for _, s := range []string{"", "corp listeners", "corp maps=pr", "corp zones=example.com"} {
+		_, err := ParseMapNamespace(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestNewMapNamespaces(t *testing.T) {
+	ns, err := newMapNamespaces(nil)
+	require.NoError(t, err)
+	require.Empty(t, ns)
+
+	ns, err = newMapNamespaces([]MapNamespace{
+		{Name: "corp", Listeners: []string{"192.0.2.53"}, Maps: map[string]string{"pr": "corp"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, ns, 1)
+	require.Equal(t, "DNS_map_namespace.corp", ns[0].statsKey)
+	require.Len(t, ns[0].maps, 1)
+
+	corp := MapNamespace{Name: "corp", Listeners: []string{"192.0.2.53"}, Maps: map[string]string{"pr": "corp"}}
+	for _, c := range [][]MapNamespace{
+		{{Listeners: []string{"192.0.2.53"}, Maps: map[string]string{"pr": "corp"}}},
+		{{Name: "corp", Maps: map[string]string{"pr": "corp"}}},
+		{{Name: "corp", Listeners: []string{"192.0.2.53"}}},
+		{{Name: "corp", Listeners: []string{"192.0.2.53"}, Maps: map[string]string{"p": "corp"}}},
+		{corp, corp},
+		{corp, {Name: "lab", Listeners: []string{"192.0.2.53"}, Maps: map[string]string{"pr": "lab"}}},
+	} {
+		_, err := newMapNamespaces(c)
+		require.Error(t, err, c)
+	}
+}
+
+// TestHandlerMapNamespace checks that queries received on the listeners of a
+// namespace locate their client in the maps of the namespace
+func TestHandlerMapNamespace(t *testing.T) {
+	testDBs := testaid.NewFixture().
+		Zone("example.com", 300, "ns.example.com").
+		ResolverMap("www.example.com", "pr").
+		Subnet("pr", "192.0.2.0/24", testaid.Loc(1)).
+		Subnet("corp", "192.0.2.0/24", testaid.Loc(2)).
+		Address("www.example.com", "198.51.100.1", 300, testaid.Loc(1)).
+		Address("www.example.com", "198.51.100.2", 300, testaid.Loc(2)).
+		Build(t)
+
+	for _, db := range testDBs {
+		t.Run(db.Driver+"/"+db.Flavour, func(t *testing.T) {
+			ctr := stats.NewCounters()
+			config := HandlerConfig{MapNamespaces: []MapNamespace{
+				{Name: "corp", Listeners: []string{"192.0.2.53"}, Maps: map[string]string{"pr": "corp"}},
+			}}
+			th, err := NewFBDNSDBBasic(config, DBConfig{Path: db.Path, Driver: db.Driver}, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+			require.NoError(t, err)
+			require.NoError(t, th.Load())
+			defer th.Close()
+
+			for _, tc := range []struct {
+				listener string
+				want     string
+			}{
+				{listener: "198.51.100.53:53", want: "198.51.100.1"},
+				{listener: "192.0.2.53:53", want: "198.51.100.2"},
+			} {
+				req := new(dns.Msg)
+				req.SetQuestion("www.example.com.", dns.TypeA)
+				ctx := WithClientInfo(WithMaxAnswer(context.Background(), 1), ClientInfo{Transport: TransportUDP, Listener: tc.listener})
+				rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: "192.0.2.1"})
+				_, err := th.ServeDNSWithRCODE(ctx, rec, req)
+				require.NoError(t, err)
+				require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+				require.Len(t, rec.Msg.Answer, 1)
+				require.Equal(t, tc.want, rec.Msg.Answer[0].(*dns.A).A.String(), tc.listener)
+			}
+			require.Equal(t, int64(1), ctr["DNS_map_namespace.corp"])
+		})
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/db"
+)
+
+// MapStatsConfig configures per map statistics of location lookups, showing
+// which client populations fall through to default locations.
+type MapStatsConfig struct {
+	// Enabled controls whether location lookups are counted per map ID
+	Enabled bool
+	// UnmatchedSampleRate is the fraction of lookups ending in a default or
+	// empty location which are logged with the client subnets, in [0.0, 1.0].
+	UnmatchedSampleRate float64
+}
+
+// Outcomes of location lookups in a map
+const (
+	mapLookupHit      = "hit"
+	mapLookupFallback = "fallback"
+	mapLookupMiss     = "miss"
+	mapLookupDefault  = "default"
+)
+
+// validate checks the sample rate of c
+func (c MapStatsConfig) validate() error {
+	if c.UnmatchedSampleRate < 0 || c.UnmatchedSampleRate > 1 {
+		return fmt.Errorf("invalid unmatched subnets sample rate %v", c.UnmatchedSampleRate)
+	}
+	return nil
+}
+
+// mapLookupOutcome classifies the location found in a map: a miss when the
+// client matched no subnet, a default when it matched a default location, a
+// fallback when the name is served from a fallback of the location matched,
+// and a hit otherwise.
+func mapLookupOutcome(loc *db.Location) string {
+	if loc.FallbackFrom != nil {
+		return mapLookupFallback
+	}
+	switch string(loc.LocID) {
+	case emptyLoc:
+		return mapLookupMiss
+	case defaultLoc2, defaultLocN, defaultFallbackLoc:
+		return mapLookupDefault
+	}
+	return mapLookupHit
+}
+
+// mapStatsKey returns the counter of outcome for the map, whose ID is hex
+// encoded so that any ID makes a valid key
+func mapStatsKey(mapID db.ID, outcome string) string {
+	return fmt.Sprintf("DNS_map.%x.%s", mapID.Contents(), outcome)
+}
+
+// ecsSubnet returns the client subnet of ecs, host bits zeroed
+func ecsSubnet(ecs *dns.EDNS0_SUBNET) string {
+	bits := 8 * net.IPv4len
+	if ecs.Family == 2 {
+		bits = 8 * net.IPv6len
+	}
+	mask := net.CIDRMask(int(ecs.SourceNetmask), bits)
+	return (&net.IPNet{IP: ecs.Address.Mask(mask), Mask: mask}).String()
+}
+
+// countMapLookup counts the outcome of the location lookup in its map, and
+// samples the client subnets of the lookups which fell through to logs.
+// Names which are not mapped are not counted.
+func (h *FBDNSDB) countMapLookup(qname string, loc *db.Location, ecs *dns.EDNS0_SUBNET, resolverIP string) {
+	conf := h.handlerConfig.MapStats
+	if !conf.Enabled || loc.MapID.IsZero() {
+		return
+	}
+	outcome := mapLookupOutcome(loc)
+	h.stats.IncrementCounter(mapStatsKey(loc.MapID, outcome))
+	if outcome == mapLookupHit || outcome == mapLookupFallback || conf.UnmatchedSampleRate == 0 || rand.Float64() >= conf.UnmatchedSampleRate {
+		return
+	}
+	clientSubnet := "none"
+	if ecs != nil {
+		clientSubnet = ecsSubnet(ecs)
+	}
+	glog.Infof("Unmatched client in map %x for %s: location %s %x, resolver %s, client subnet %s",
+		loc.MapID.Contents(), qname, outcome, loc.LocID.Contents(), resolverIP, clientSubnet)
+}
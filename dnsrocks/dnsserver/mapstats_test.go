@@ -0,0 +1,120 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestMapLookupOutcome(t *testing.T) {
+	testCases := []struct {
+		locID   db.ID
+		outcome string
+	}{
+		{locID: db.ID(emptyLoc), outcome: mapLookupMiss},
+		{locID: db.ID(defaultLoc2), outcome: mapLookupDefault},
+		{locID: db.ID(defaultFallbackLoc), outcome: mapLookupDefault},
+		{locID: db.ID{0, 3}, outcome: mapLookupHit},
+		{locID: db.ID("\xff\x03abc"), outcome: mapLookupHit},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.outcome, mapLookupOutcome(&db.Location{MapID: db.ID("ec"), LocID: tc.locID}), tc.locID)
+	}
+	fallback := &db.Location{MapID: db.ID("ec"), LocID: db.ID{0, 3}, FallbackFrom: db.ID{0, 4}}
+	require.Equal(t, mapLookupFallback, mapLookupOutcome(fallback))
+	require.Equal(t, "DNS_map.6300.hit", mapStatsKey(db.ID("c\000"), mapLookupHit))
+	require.Equal(t, "DNS_map.6162.miss", mapStatsKey(db.ID("\xff\x02ab"), mapLookupMiss))
+}
+
+func TestMapStatsConfigValidate(t *testing.T) {
+	require.NoError(t, MapStatsConfig{Enabled: true, UnmatchedSampleRate: 0.5}.validate())
+	require.Error(t, MapStatsConfig{Enabled: true, UnmatchedSampleRate: 1.5}.validate())
+	require.Error(t, MapStatsConfig{Enabled: true, UnmatchedSampleRate: -1}.validate())
+}
+
+func TestMapStats(t *testing.T) {
+	for _, testDB := range testaid.TestDBs {
+		t.Run(testDB.Driver+"/"+testDB.Flavour, func(t *testing.T) {
+			th := OpenDbForTesting(t, &testDB)
+			defer th.Close()
+			ctr := stats.NewCounters()
+			th.stats = ctr
+			th.handlerConfig.MapStats = MapStatsConfig{Enabled: true, UnmatchedSampleRate: 1}
+
+			testCases := []struct {
+				qname    string
+				resolver string
+				ecs      string
+			}{
+				// resolver subnet in map c\000
+				{qname: "example.com.", resolver: "2.2.2.2"},
+				// default location of map c\000
+				{qname: "example.com.", resolver: "9.9.9.9"},
+				// client subnet in map ec
+				{qname: "example.com.", resolver: "9.9.9.9", ecs: "2.2.2.0/24"},
+				// no subnet at all in map Ma
+				{qname: "www.a.b.c.example.org.", resolver: "1.1.1.1"},
+				// no map
+				{qname: "nonlocationaware.example.com.", resolver: "1.1.1.1"},
+			}
+			for _, tc := range testCases {
+				req := new(dns.Msg)
+				req.SetQuestion(tc.qname, dns.TypeA)
+				if tc.ecs != "" {
+					o, err := MakeOPTWithECS(tc.ecs)
+					require.NoError(t, err)
+					req.Extra = []dns.RR{o}
+				}
+				rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: tc.resolver})
+				_, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+				require.NoError(t, err)
+			}
+
+			var mapCounters []string
+			for key := range ctr {
+				if strings.HasPrefix(key, "DNS_map.") {
+					mapCounters = append(mapCounters, key)
+				}
+			}
+			require.ElementsMatch(t, []string{"DNS_map.6300.hit", "DNS_map.6300.default", "DNS_map.6563.hit", "DNS_map.4d61.miss"}, mapCounters)
+			for _, key := range mapCounters {
+				require.Equal(t, int64(1), ctr[key], key)
+			}
+		})
+	}
+}
+
+func TestMapStatsDisabled(t *testing.T) {
+	th := OpenDbForTesting(t, &testaid.TestCDB)
+	defer th.Close()
+	ctr := stats.NewCounters()
+	th.stats = ctr
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: "1.1.1.1"})
+	_, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+	require.NoError(t, err)
+	require.Zero(t, ctr["DNS_map.6300.hit"])
+}
@@ -0,0 +1,190 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/coredns/coredns/request"
+)
+
+// Policies for queries for zones not served, neither authoritatively nor
+// through a delegation.
+const (
+	// NotAuthoritativeRefuse answers REFUSED, with the Not Authoritative
+	// extended DNS error.
+	NotAuthoritativeRefuse = "refuse"
+	// NotAuthoritativeDrop doesn't answer at all, so that the server is of no
+	// use to reflection attacks spoofing their victims.
+	NotAuthoritativeDrop = "drop"
+)
+
+func validateNotAuthoritativePolicy(policy string) error {
+	switch policy {
+	case NotAuthoritativeRefuse, NotAuthoritativeDrop:
+		return nil
+	}
+	return fmt.Errorf("unknown not authoritative policy %q", policy)
+}
+
+// NotAuthoritativeRule applies a policy to the queries for zones not served
+// matching its listener and sources
+type NotAuthoritativeRule struct {
+	// Policy is NotAuthoritativeRefuse or NotAuthoritativeDrop
+	Policy string
+	// Listener is the address, with or without port, of the listener the
+	// queries are received on, as bound, any if empty
+	Listener string
+	// Sources are the prefixes of the client IPs, any if empty
+	Sources []netip.Prefix
+}
+
+// String returns the rule as parsed by ParseNotAuthoritativeRule
+func (r NotAuthoritativeRule) String() string {
+	s := r.Policy
+	if r.Listener != "" {
+		s += " listener=" + r.Listener
+	}
+	if len(r.Sources) > 0 {
+		sources := make([]string, len(r.Sources))
+		for i, p := range r.Sources {
+			sources[i] = p.String()
+		}
+		s += " from=" + strings.Join(sources, ",")
+	}
+	return s
+}
+
+// ParseNotAuthoritativeRule parses a rule written as
+// `policy [listener=addr] [from=prefix,...]`, e.g.
+// `refuse from=10.0.0.0/8,fd00::/8`
+func ParseNotAuthoritativeRule(s string) (NotAuthoritativeRule, error) {
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return NotAuthoritativeRule{}, fmt.Errorf("empty not authoritative rule")
+	}
+	r := NotAuthoritativeRule{Policy: f[0]}
+	if err := validateNotAuthoritativePolicy(r.Policy); err != nil {
+		return r, err
+	}
+	for _, field := range f[1:] {
+		name, value, _ := strings.Cut(field, "=")
+		switch name {
+		case "listener":
+			r.Listener = value
+		case "from":
+			for _, prefix := range strings.Split(value, ",") {
+				p, err := netip.ParsePrefix(prefix)
+				if err != nil {
+					// single addresses are host prefixes
+					addr, aerr := netip.ParseAddr(prefix)
+					if aerr != nil {
+						return r, fmt.Errorf("invalid source %q in rule %q: %w", prefix, s, err)
+					}
+					p = netip.PrefixFrom(addr, addr.BitLen())
+				}
+				r.Sources = append(r.Sources, p.Masked())
+			}
+		default:
+			return r, fmt.Errorf("unknown field %q in rule %q, expected listener= or from=", field, s)
+		}
+	}
+	return r, nil
+}
+
+// NotAuthoritativeConfig controls how queries for zones not served are
+// answered
+type NotAuthoritativeConfig struct {
+	// Policy applies to the queries no rule matches, NotAuthoritativeRefuse
+	// if empty
+	Policy string
+	// Rules override Policy for the queries they match, the first matching
+	// rule winning
+	Rules []NotAuthoritativeRule
+}
+
+func (c NotAuthoritativeConfig) validate() error {
+	if c.Policy != "" {
+		if err := validateNotAuthoritativePolicy(c.Policy); err != nil {
+			return err
+		}
+	}
+	for _, r := range c.Rules {
+		if err := validateNotAuthoritativePolicy(r.Policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches returns true if the rule applies to queries from client received
+// on listener
+func (r NotAuthoritativeRule) matches(listener string, client netip.Addr) bool {
+	if r.Listener != "" && !listenerMatches(r.Listener, listener) {
+		return false
+	}
+	if len(r.Sources) == 0 {
+		return true
+	}
+	for _, p := range r.Sources {
+		if p.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
+
+// policy returns the policy applying to the query of state, sent by client.
+// client is the real address of the client, state may be anonymized.
+func (c NotAuthoritativeConfig) policy(ctx context.Context, state request.Request, client netip.Addr) string {
+	if len(c.Rules) > 0 {
+		listener := queryListener(ctx, state)
+		client = client.Unmap()
+		for _, r := range c.Rules {
+			if r.matches(listener, client) {
+				return r.Policy
+			}
+		}
+	}
+	if c.Policy == "" {
+		return NotAuthoritativeRefuse
+	}
+	return c.Policy
+}
+
+// queryListener returns the address of the listener the query of state was
+// received on, as bound
+func queryListener(ctx context.Context, state request.Request) string {
+	if info, ok := GetClientInfo(ctx); ok {
+		return info.Listener
+	}
+	if addr := state.W.LocalAddr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+// listenerMatches returns true if the listener address, with or without
+// port, designates listener
+func listenerMatches(address, listener string) bool {
+	if address == listener {
+		return true
+	}
+	host, _, err := net.SplitHostPort(listener)
+	return err == nil && address == host
+}
@@ -0,0 +1,133 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestParseNotAuthoritativeRule(t *testing.T) {
+	r, err := ParseNotAuthoritativeRule("refuse listener=192.0.2.53 from=10.0.0.0/8,fd00::1")
+	require.NoError(t, err)
+	require.Equal(t, NotAuthoritativeRule{
+		Policy:   NotAuthoritativeRefuse,
+		Listener: "192.0.2.53",
+		Sources:  []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::1/128")},
+	}, r)
+	require.Equal(t, "refuse listener=192.0.2.53 from=10.0.0.0/8,fd00::1/128", r.String())
+
+	r, err = ParseNotAuthoritativeRule("drop")
+	require.NoError(t, err)
+	require.Equal(t, NotAuthoritativeRule{Policy: NotAuthoritativeDrop}, r)
+
+	for _, s := range []string{"", "ignore", "drop to=10.0.0.0/8", "drop from=10.0.0.0/33", "drop from="} {
+		_, err := ParseNotAuthoritativeRule(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestNotAuthoritativePolicy(t *testing.T) {
+	conf := NotAuthoritativeConfig{
+		Policy: NotAuthoritativeDrop,
+		Rules: []NotAuthoritativeRule{
+			{Policy: NotAuthoritativeRefuse, Sources: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+			{Policy: NotAuthoritativeRefuse, Listener: "192.0.2.53"},
+		},
+	}
+	ctr := stats.NewCounters()
+	dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+	th, err := NewFBDNSDBBasic(HandlerConfig{NotAuthoritative: conf}, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+
+	query := func(qname, client, listener string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(qname, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: client})
+		ctx := WithClientInfo(CreateTestContext(1), ClientInfo{Transport: TransportUDP, Listener: listener})
+		_, err := th.ServeDNSWithRCODE(ctx, rec, req)
+		require.NoError(t, err)
+		return rec.Msg
+	}
+
+	require.Nil(t, query("www.notourdomain.com.", "1.1.1.1", "198.51.100.53:53"))
+	require.Equal(t, int64(1), ctr["DNS_queries_notauthoritative.dropped"])
+	require.Equal(t, int64(1), ctr[ErrNotAuthoritative.StatsKey()])
+
+	m := query("www.notourdomain.com.", "10.1.2.3", "198.51.100.53:53")
+	require.NotNil(t, m)
+	require.Equal(t, dns.RcodeRefused, m.Rcode)
+	m = query("www.notourdomain.com.", "1.1.1.1", "192.0.2.53:53")
+	require.NotNil(t, m)
+	require.Equal(t, dns.RcodeRefused, m.Rcode)
+	require.Equal(t, int64(1), ctr["DNS_queries_notauthoritative.dropped"])
+
+	// served zones are answered whatever the policy
+	m = query("foo.example.com.", "1.1.1.1", "198.51.100.53:53")
+	require.NotNil(t, m)
+	require.Equal(t, dns.RcodeSuccess, m.Rcode)
+
+	_, err = NewFBDNSDBBasic(HandlerConfig{NotAuthoritative: NotAuthoritativeConfig{Policy: "ignore"}}, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+	require.Error(t, err)
+}
+
+func TestNotAuthoritativePolicyResolverPrivacy(t *testing.T) {
+	conf := NotAuthoritativeConfig{
+		Policy: NotAuthoritativeDrop,
+		Rules: []NotAuthoritativeRule{
+			{Policy: NotAuthoritativeRefuse, Sources: []netip.Prefix{netip.MustParsePrefix("10.1.2.3/32")}},
+		},
+	}
+	for _, privacy := range []PrivacyConfig{
+		{Mode: PrivacyModeTruncate},
+		{Mode: PrivacyModeHash, HashKey: []byte("secret"), IPv4PrefixLen: 32},
+	} {
+		t.Run(privacy.Mode, func(t *testing.T) {
+			ctr := stats.NewCounters()
+			dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+			handlerConfig := HandlerConfig{NotAuthoritative: conf, ResolverPrivacy: privacy}
+			th, err := NewFBDNSDBBasic(handlerConfig, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+			require.NoError(t, err)
+			require.NoError(t, th.Load())
+			defer th.Close()
+
+			query := func(client string) *dns.Msg {
+				req := new(dns.Msg)
+				req.SetQuestion("www.notourdomain.com.", dns.TypeA)
+				rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: client})
+				_, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+				require.NoError(t, err)
+				return rec.Msg
+			}
+
+			// rules match the real client address, not the anonymized one
+			m := query("10.1.2.3")
+			require.NotNil(t, m)
+			require.Equal(t, dns.RcodeRefused, m.Rcode)
+			require.Nil(t, query("10.1.2.4"))
+			require.Equal(t, int64(1), ctr["DNS_queries_notauthoritative.dropped"])
+		})
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+// Defaults for sending NOTIFY messages
+const (
+	DefaultNotifyTimeout = 2 * time.Second
+	DefaultNotifyRetries = 3
+)
+
+// NotifyConfig configures SOA serial tracking and NOTIFY (RFC 1996) sending
+// to secondaries when a zone serial changes.
+type NotifyConfig struct {
+	// Zones is a comma separated list of zones whose SOA serial is tracked.
+	Zones string
+	// Secondaries is a comma separated list of host:port to notify. Port 53
+	// is used if none is set.
+	Secondaries string
+	// Timeout is how long to wait for a secondary to acknowledge a NOTIFY.
+	// 0 means DefaultNotifyTimeout.
+	Timeout time.Duration
+	// Retries is how many times a NOTIFY is sent before giving up.
+	// 0 means DefaultNotifyRetries.
+	Retries int
+}
+
+// notifier tracks the SOA serials of zones across DB loads, and notifies
+// secondaries of the zones whose serial changed.
+type notifier struct {
+	zones       []string
+	secondaries []string
+	retries     int
+	client      *dns.Client
+	stats       stats.Stats
+
+	// serials of the zones as of the last DB load, missing if no SOA was found
+	serials map[string]uint32
+	wg      sync.WaitGroup
+}
+
+// splitList splits a comma separated list, ignoring empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// newNotifier validates c and returns the matching notifier, or nil when no
+// zone is tracked.
+func newNotifier(c NotifyConfig, s stats.Stats) (*notifier, error) {
+	zones := splitList(c.Zones)
+	if len(zones) == 0 {
+		return nil, nil
+	}
+	for i, zone := range zones {
+		if _, ok := dns.IsDomainName(zone); !ok {
+			return nil, fmt.Errorf("invalid notify zone %q", zone)
+		}
+		zones[i] = dns.CanonicalName(zone)
+	}
+	secondaries := splitList(c.Secondaries)
+	for i, secondary := range secondaries {
+		if _, _, err := net.SplitHostPort(secondary); err != nil {
+			secondary = net.JoinHostPort(secondary, "53")
+			if _, _, err := net.SplitHostPort(secondary); err != nil {
+				return nil, fmt.Errorf("invalid notify secondary %q: %w", secondaries[i], err)
+			}
+			secondaries[i] = secondary
+		}
+	}
+	if c.Timeout < 0 {
+		return nil, fmt.Errorf("invalid notify timeout %v", c.Timeout)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultNotifyTimeout
+	}
+	if c.Retries < 0 {
+		return nil, fmt.Errorf("invalid notify retries %d", c.Retries)
+	}
+	if c.Retries == 0 {
+		c.Retries = DefaultNotifyRetries
+	}
+	return &notifier{
+		zones:       zones,
+		secondaries: secondaries,
+		retries:     c.Retries,
+		client:      &dns.Client{Timeout: c.Timeout},
+		stats:       s,
+		serials:     make(map[string]uint32),
+	}, nil
+}
+
+// zoneSerial returns the serial of the SOA of zone, as served to resolvers
+// without a specific location.
+func zoneSerial(reader db.Reader, zone string) (uint32, bool) {
+	packedZone := make([]byte, 255)
+	offset, err := dns.PackDomainName(zone, packedZone, 0, nil, false)
+	if err != nil {
+		return 0, false
+	}
+	a := new(dns.Msg)
+	db.FindSOA(reader, packedZone[:offset], zone, db.ZeroID, a)
+	for _, rr := range a.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial, true
+		}
+	}
+	return 0, false
+}
+
+// update reads the serials of the tracked zones from dnsdb and notifies
+// secondaries in the background for every zone whose serial changed since
+// the previous update. Serials read on the first update are only recorded.
+func (n *notifier) update(dnsdb *db.DB) {
+	reader, err := db.NewReader(dnsdb)
+	if err != nil {
+		glog.Errorf("Failed to read zone serials: %v", err)
+		n.stats.IncrementCounter("DNS_notify.serial_error")
+		return
+	}
+	defer reader.Close()
+
+	for _, zone := range n.zones {
+		serial, found := zoneSerial(reader, zone)
+		if !found {
+			glog.Errorf("No SOA found for notify zone %s", zone)
+			n.stats.IncrementCounter("DNS_notify.serial_error")
+			delete(n.serials, zone)
+			continue
+		}
+		n.stats.ResetCounterTo("DNS_zone_serial."+zone, int64(serial))
+		previous, known := n.serials[zone]
+		n.serials[zone] = serial
+		if !known || previous == serial {
+			continue
+		}
+		glog.Infof("Serial of %s changed from %d to %d, notifying %d secondaries", zone, previous, serial, len(n.secondaries))
+		n.stats.IncrementCounter("DNS_notify.serial_change")
+		for _, secondary := range n.secondaries {
+			n.wg.Add(1)
+			go func(zone, secondary string) {
+				defer n.wg.Done()
+				n.notify(zone, secondary)
+			}(zone, secondary)
+		}
+	}
+}
+
+// notify sends a NOTIFY for zone to secondary until it is acknowledged or
+// retries are exhausted.
+func (n *notifier) notify(zone, secondary string) {
+	m := new(dns.Msg)
+	m.SetNotify(zone)
+	var err error
+	for i := 0; i < n.retries; i++ {
+		var resp *dns.Msg
+		n.stats.IncrementCounter("DNS_notify.sent")
+		resp, _, err = n.client.Exchange(m, secondary)
+		if err == nil {
+			if resp.Opcode == dns.OpcodeNotify && resp.Rcode == dns.RcodeSuccess {
+				n.stats.IncrementCounter("DNS_notify.acked")
+				return
+			}
+			err = fmt.Errorf("unexpected response opcode %s rcode %s",
+				dns.OpcodeToString[resp.Opcode], dns.RcodeToString[resp.Rcode])
+		}
+	}
+	glog.Errorf("Failed to notify %s of %s changes: %v", secondary, zone, err)
+	n.stats.IncrementCounter("DNS_notify.error")
+}
+
+// wait waits for in-flight NOTIFY messages
+func (n *notifier) wait() {
+	n.wg.Wait()
+}
@@ -0,0 +1,130 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+// startNotifyReceiver starts a secondary acknowledging NOTIFY messages, and
+// returns its address and a channel receiving the notified zones.
+func startNotifyReceiver(t *testing.T) (string, chan string) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	notified := make(chan string, 10)
+	server := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			if req.Opcode == dns.OpcodeNotify {
+				notified <- req.Question[0].Name
+			}
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			_ = w.WriteMsg(resp)
+		}),
+	}
+	var started sync.WaitGroup
+	started.Add(1)
+	server.NotifyStartedFunc = started.Done
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	started.Wait()
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return pc.LocalAddr().String(), notified
+}
+
+func TestNewNotifier(t *testing.T) {
+	n, err := newNotifier(NotifyConfig{Secondaries: "192.0.2.1"}, &stats.DummyStats{})
+	require.NoError(t, err)
+	require.Nil(t, n)
+
+	n, err = newNotifier(NotifyConfig{Zones: "Example.com, example.net.", Secondaries: "192.0.2.1,2001:db8::1,192.0.2.2:5353"}, &stats.DummyStats{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"example.com.", "example.net."}, n.zones)
+	require.Equal(t, []string{"192.0.2.1:53", "[2001:db8::1]:53", "192.0.2.2:5353"}, n.secondaries)
+	require.Equal(t, DefaultNotifyTimeout, n.client.Timeout)
+	require.Equal(t, DefaultNotifyRetries, n.retries)
+
+	for _, c := range []NotifyConfig{
+		{Zones: "example..com"},
+		{Zones: "example.com", Timeout: -time.Second},
+		{Zones: "example.com", Retries: -1},
+	} {
+		_, err = newNotifier(c, &stats.DummyStats{})
+		require.Error(t, err, "%+v", c)
+	}
+}
+
+func TestNotifyOnSerialChange(t *testing.T) {
+	addr, notified := startNotifyReceiver(t)
+	ctr := &syncCounters{Counters: stats.NewCounters()}
+	handlerConfig := HandlerConfig{
+		Notify: NotifyConfig{Zones: "example.com,example.net,nonexistent.test", Secondaries: addr},
+	}
+	dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver, ReloadTimeout: time.Second}
+	th, err := NewFBDNSDBBasic(handlerConfig, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+
+	// serials found on load are only recorded
+	require.Equal(t, map[string]uint32{"example.com.": 123, "example.net.": 123}, th.notifier.serials)
+	require.Equal(t, int64(123), ctr.get("DNS_zone_serial.example.com."))
+	require.Equal(t, int64(1), ctr.get("DNS_notify.serial_error"))
+
+	// an unchanged serial doesn't notify
+	require.NoError(t, th.Reload(*NewPartialReloadSignal()))
+	th.notifier.wait()
+	require.Zero(t, ctr.get("DNS_notify.sent"))
+
+	th.notifier.serials["example.net."] = 122
+	require.NoError(t, th.Reload(*NewPartialReloadSignal()))
+	th.notifier.wait()
+	require.Equal(t, "example.net.", <-notified)
+	require.Equal(t, int64(1), ctr.get("DNS_notify.serial_change"))
+	require.Equal(t, int64(1), ctr.get("DNS_notify.sent"))
+	require.Equal(t, int64(1), ctr.get("DNS_notify.acked"))
+	require.Equal(t, uint32(123), th.notifier.serials["example.net."])
+}
+
+func TestNotifyRetries(t *testing.T) {
+	// nothing answers on this port
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	ctr := &syncCounters{Counters: stats.NewCounters()}
+	n, err := newNotifier(NotifyConfig{
+		Zones:       "example.com",
+		Secondaries: pc.LocalAddr().String(),
+		Timeout:     10 * time.Millisecond,
+		Retries:     2,
+	}, ctr)
+	require.NoError(t, err)
+	n.notify("example.com.", n.secondaries[0])
+	require.Equal(t, int64(2), ctr.get("DNS_notify.sent"))
+	require.Zero(t, ctr.get("DNS_notify.acked"))
+	require.Equal(t, int64(1), ctr.get("DNS_notify.error"))
+}
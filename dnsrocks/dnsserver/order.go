@@ -0,0 +1,108 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// Answer orders, for RRsets with more than one record.
+const (
+	// AnswerOrderDefault keeps records in the order they are read from the DB,
+	// except A and AAAA ones which are shuffled after their weighted random
+	// sampling.
+	AnswerOrderDefault = ""
+	// AnswerOrderShuffle shuffles the records of every response.
+	AnswerOrderShuffle = "shuffle"
+	// AnswerOrderFixed sorts records by their data, so that every response
+	// lists them in the same order.
+	AnswerOrderFixed = "fixed"
+	// AnswerOrderRoundRobin rotates the sorted records by one position for
+	// each response, the cursor being shared by all queries.
+	AnswerOrderRoundRobin = "round-robin"
+)
+
+// answerOrderer orders the RRsets of answers following a validated mode.
+type answerOrderer struct {
+	mode   string
+	cursor atomic.Uint64
+}
+
+// newAnswerOrderer returns the answerOrderer for mode, or nil when records
+// are left in the default order.
+func newAnswerOrderer(mode string) (*answerOrderer, error) {
+	switch mode {
+	case AnswerOrderDefault:
+		return nil, nil
+	case AnswerOrderShuffle, AnswerOrderFixed, AnswerOrderRoundRobin:
+		return &answerOrderer{mode: mode}, nil
+	}
+	return nil, fmt.Errorf("unknown answer order %q", mode)
+}
+
+// order reorders the records of each RRset of answers in place. RRsets are
+// runs of records with the same owner name and type, so CNAME chains keep
+// their order.
+func (o *answerOrderer) order(answers []dns.RR) {
+	var rotation uint64
+	if o.mode == AnswerOrderRoundRobin {
+		rotation = o.cursor.Add(1) - 1
+	}
+	for start := 0; start < len(answers); {
+		end := start + 1
+		for end < len(answers) && sameRRset(answers[start], answers[end]) {
+			end++
+		}
+		if end-start > 1 {
+			o.orderRRset(answers[start:end], rotation)
+		}
+		start = end
+	}
+}
+
+// orderRRset reorders the records of a single RRset
+func (o *answerOrderer) orderRRset(rrset []dns.RR, rotation uint64) {
+	if o.mode == AnswerOrderShuffle {
+		rand.Shuffle(len(rrset), func(i, j int) {
+			rrset[i], rrset[j] = rrset[j], rrset[i]
+		})
+		return
+	}
+	sort.SliceStable(rrset, func(i, j int) bool {
+		return rdataString(rrset[i]) < rdataString(rrset[j])
+	})
+	if o.mode == AnswerOrderRoundRobin {
+		n := int(rotation % uint64(len(rrset)))
+		rotated := append(append(make([]dns.RR, 0, len(rrset)), rrset[n:]...), rrset[:n]...)
+		copy(rrset, rotated)
+	}
+}
+
+// sameRRset returns true if a and b belong to the same RRset
+func sameRRset(a, b dns.RR) bool {
+	return a.Header().Rrtype == b.Header().Rrtype &&
+		a.Header().Class == b.Header().Class &&
+		strings.EqualFold(a.Header().Name, b.Header().Name)
+}
+
+// rdataString returns the text form of the data of rr, without its header
+func rdataString(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
@@ -0,0 +1,140 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+)
+
+func mustRRs(t *testing.T, records ...string) []dns.RR {
+	rrs := make([]dns.RR, 0, len(records))
+	for _, r := range records {
+		rr, err := dns.NewRR(r)
+		require.NoError(t, err)
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+func rrStrings(rrs []dns.RR) []string {
+	s := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		s = append(s, rr.String())
+	}
+	return s
+}
+
+func TestNewAnswerOrderer(t *testing.T) {
+	o, err := newAnswerOrderer(AnswerOrderDefault)
+	require.NoError(t, err)
+	require.Nil(t, o)
+	for _, mode := range []string{AnswerOrderShuffle, AnswerOrderFixed, AnswerOrderRoundRobin} {
+		o, err = newAnswerOrderer(mode)
+		require.NoError(t, err)
+		require.NotNil(t, o)
+	}
+	_, err = newAnswerOrderer("random")
+	require.Error(t, err)
+}
+
+func TestAnswerOrderFixed(t *testing.T) {
+	o, err := newAnswerOrderer(AnswerOrderFixed)
+	require.NoError(t, err)
+	answers := mustRRs(t,
+		"www.example.com. 300 IN CNAME b.example.com.",
+		"b.example.com. 300 IN A 192.0.2.3",
+		"b.example.com. 300 IN A 192.0.2.1",
+		"B.example.com. 300 IN A 192.0.2.2",
+		"b.example.com. 300 IN TXT \"z\"",
+		"b.example.com. 300 IN TXT \"a\"",
+	)
+	o.order(answers)
+	require.Equal(t, rrStrings(mustRRs(t,
+		"www.example.com. 300 IN CNAME b.example.com.",
+		"b.example.com. 300 IN A 192.0.2.1",
+		"B.example.com. 300 IN A 192.0.2.2",
+		"b.example.com. 300 IN A 192.0.2.3",
+		"b.example.com. 300 IN TXT \"a\"",
+		"b.example.com. 300 IN TXT \"z\"",
+	)), rrStrings(answers))
+}
+
+func TestAnswerOrderRoundRobin(t *testing.T) {
+	o, err := newAnswerOrderer(AnswerOrderRoundRobin)
+	require.NoError(t, err)
+	expected := [][]string{
+		{"192.0.2.1", "192.0.2.2", "192.0.2.3"},
+		{"192.0.2.2", "192.0.2.3", "192.0.2.1"},
+		{"192.0.2.3", "192.0.2.1", "192.0.2.2"},
+		{"192.0.2.1", "192.0.2.2", "192.0.2.3"},
+	}
+	for _, order := range expected {
+		answers := mustRRs(t,
+			"b.example.com. 300 IN A 192.0.2.3",
+			"b.example.com. 300 IN A 192.0.2.1",
+			"b.example.com. 300 IN A 192.0.2.2",
+		)
+		o.order(answers)
+		var ips []string
+		for _, rr := range answers {
+			ips = append(ips, rr.(*dns.A).A.String())
+		}
+		require.Equal(t, order, ips)
+	}
+}
+
+func TestAnswerOrderShuffle(t *testing.T) {
+	o, err := newAnswerOrderer(AnswerOrderShuffle)
+	require.NoError(t, err)
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		answers := mustRRs(t,
+			"www.example.com. 300 IN CNAME b.example.com.",
+			"b.example.com. 300 IN A 192.0.2.1",
+			"b.example.com. 300 IN A 192.0.2.2",
+		)
+		o.order(answers)
+		require.Equal(t, "www.example.com.\t300\tIN\tCNAME\tb.example.com.", answers[0].String())
+		seen[answers[1].(*dns.A).A.String()] = true
+	}
+	require.Len(t, seen, 2)
+}
+
+// TestHandlerAnswerOrder checks that responses, cached ones included, follow
+// the answer order
+func TestHandlerAnswerOrder(t *testing.T) {
+	th := createFBDNSDBWithCache(t, &stats.DummyStats{})
+	var err error
+	th.orderer, err = newAnswerOrderer(AnswerOrderRoundRobin)
+	require.NoError(t, err)
+
+	var firsts []string
+	for i := 0; i < 4; i++ {
+		req := new(dns.Msg)
+		req.SetQuestion("example.net.", dns.TypeMX)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+		require.NoError(t, err)
+		require.Len(t, rec.Msg.Answer, 2)
+		firsts = append(firsts, rec.Msg.Answer[0].(*dns.MX).Mx)
+	}
+	require.Equal(t, []string{"www.example.net.", "foo.example.net.", "www.example.net.", "foo.example.net."}, firsts)
+}
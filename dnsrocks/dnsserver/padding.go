@@ -0,0 +1,134 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// DefaultPaddingBlockSize is the block size RFC 8467 recommends padding
+// responses to
+const DefaultPaddingBlockSize = 468
+
+// paddingOptionLen is the length of the code and length fields of the padding
+// option
+const paddingOptionLen = 4
+
+// PaddingConfig controls the EDNS0 padding (RFC 7830) of responses over
+// encrypted transports, so that their length tells less about the query. As
+// per RFC 8467, only responses to queries carrying a padding option are padded.
+type PaddingConfig struct {
+	// BlockSizes are the block sizes the length of responses is padded to a
+	// multiple of, by transport. Transports without one aren't padded.
+	BlockSizes map[Transport]int
+}
+
+func (c PaddingConfig) validate() error {
+	for transport, size := range c.BlockSizes {
+		if !transport.encrypted() {
+			return fmt.Errorf("cannot pad responses over unencrypted transport %q", transport)
+		}
+		if size < 1 || size > dns.MaxMsgSize {
+			return fmt.Errorf("invalid %s padding block size %d, must be between 1 and %d", transport, size, dns.MaxMsgSize)
+		}
+	}
+	return nil
+}
+
+// String returns the block sizes as parsed by ParsePaddingBlockSizes
+func (c PaddingConfig) String() string {
+	sizes := make([]string, 0, len(c.BlockSizes))
+	for transport, size := range c.BlockSizes {
+		sizes = append(sizes, fmt.Sprintf("%s=%d", transport, size))
+	}
+	sort.Strings(sizes)
+	return strings.Join(sizes, ",")
+}
+
+// ParsePaddingBlockSizes parses block sizes by transport written as
+// `transport[=size],...`, e.g. `dot,doh=128`, transports without a size being
+// padded to DefaultPaddingBlockSize
+func ParsePaddingBlockSizes(s string) (map[Transport]int, error) {
+	sizes := make(map[Transport]int)
+	for _, f := range strings.Split(s, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(f), "=")
+		transport := Transport(strings.ToLower(name))
+		if !transport.encrypted() {
+			return nil, fmt.Errorf("invalid padding transport %q, must be one of %s, %s, %s", name, TransportDoT, TransportDoH, TransportDoQ)
+		}
+		size := DefaultPaddingBlockSize
+		if found {
+			var err error
+			if size, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("invalid %s padding block size %q: %w", transport, value, err)
+			}
+		}
+		sizes[transport] = size
+	}
+	return sizes, nil
+}
+
+// paddingBlockSize returns the block size responses to the query of state are
+// padded to, 0 if they aren't
+func (c PaddingConfig) paddingBlockSize(state request.Request) int {
+	if len(c.BlockSizes) == 0 {
+		return 0
+	}
+	o := state.Req.IsEdns0()
+	if o == nil || findPadding(o) < 0 {
+		return 0
+	}
+	info, ok := ClientInfoOf(state)
+	if !ok {
+		info = NewClientInfo(state.W)
+	}
+	return c.BlockSizes[info.Transport]
+}
+
+// findPadding returns the index of the padding option of o, -1 if it has none
+func findPadding(o *dns.OPT) int {
+	for i, e := range o.Option {
+		if e.Option() == dns.EDNS0PADDING {
+			return i
+		}
+	}
+	return -1
+}
+
+// padResponse pads resp with zeros so that its length, as packed with its
+// current compression, is a multiple of blockSize. Any padding option of resp
+// is replaced. It returns false, leaving resp unpadded, if resp has no OPT
+// record or padding would make it larger than maxSize.
+func padResponse(resp *dns.Msg, blockSize, maxSize int) bool {
+	o := resp.IsEdns0()
+	if o == nil || blockSize <= 0 {
+		return false
+	}
+	if i := findPadding(o); i >= 0 {
+		o.Option = append(o.Option[:i], o.Option[i+1:]...)
+	}
+	length := resp.Len() + paddingOptionLen
+	padded := (length + blockSize - 1) / blockSize * blockSize
+	if padded > maxSize {
+		return false
+	}
+	o.Option = append(o.Option, &dns.EDNS0_PADDING{Padding: make([]byte, padded-length)})
+	return true
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestParsePaddingBlockSizes(t *testing.T) {
+	sizes, err := ParsePaddingBlockSizes("dot, DoH=128")
+	require.NoError(t, err)
+	require.Equal(t, map[Transport]int{TransportDoT: DefaultPaddingBlockSize, TransportDoH: 128}, sizes)
+	require.Equal(t, "doh=128,dot=468", PaddingConfig{BlockSizes: sizes}.String())
+
+	for _, s := range []string{"", "udp", "tcp=468", "dot=", "dot=big"} {
+		_, err := ParsePaddingBlockSizes(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestPaddingConfigValidate(t *testing.T) {
+	require.NoError(t, PaddingConfig{}.validate())
+	require.NoError(t, PaddingConfig{BlockSizes: map[Transport]int{TransportDoQ: 1}}.validate())
+	require.Error(t, PaddingConfig{BlockSizes: map[Transport]int{TransportUDP: 468}}.validate())
+	require.Error(t, PaddingConfig{BlockSizes: map[Transport]int{TransportDoT: 0}}.validate())
+	require.Error(t, PaddingConfig{BlockSizes: map[Transport]int{TransportDoH: 65536}}.validate())
+}
+
+func TestPadResponse(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		resp := makeLargeResponse(3)
+		resp.Compress = compress
+		require.True(t, padResponse(resp, DefaultPaddingBlockSize, dns.MaxMsgSize))
+		require.Equal(t, DefaultPaddingBlockSize, resp.Len())
+		wire, err := resp.Pack()
+		require.NoError(t, err)
+		require.Len(t, wire, DefaultPaddingBlockSize)
+
+		// padding again replaces the padding option
+		require.True(t, padResponse(resp, 128, dns.MaxMsgSize))
+		require.Zero(t, resp.Len()%128)
+		o := resp.IsEdns0()
+		require.Len(t, o.Option, 1)
+		require.Equal(t, uint16(dns.EDNS0PADDING), o.Option[0].Option())
+	}
+
+	// larger than the max size
+	resp := makeLargeResponse(20)
+	require.False(t, padResponse(resp, dns.MaxMsgSize, resp.Len()+10))
+	require.Nil(t, resp.IsEdns0().Option)
+
+	// no EDNS0
+	resp = new(dns.Msg)
+	resp.SetQuestion("example.com.", dns.TypeA)
+	require.False(t, padResponse(resp, DefaultPaddingBlockSize, dns.MaxMsgSize))
+	require.Nil(t, resp.IsEdns0())
+}
+
+func TestResponsePadding(t *testing.T) {
+	for _, db := range testaid.TestDBs {
+		t.Run(db.Driver+"/"+db.Flavour, func(t *testing.T) {
+			dbConfig := DBConfig{Path: db.Path, Driver: db.Driver}
+			ctr := stats.NewCounters()
+			config := HandlerConfig{Padding: PaddingConfig{BlockSizes: map[Transport]int{TransportDoT: DefaultPaddingBlockSize}}}
+			th, err := NewFBDNSDBBasic(config, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+			require.NoError(t, err)
+			require.NoError(t, th.Load())
+			defer th.Close()
+
+			dot := &tlsResponseWriter{}
+			dot.TCP = true
+			ctx := WithMaxAnswer(context.Background(), 1)
+			dotCtx := WithClientInfo(ctx, NewClientInfo(dot))
+
+			padded := new(dns.Msg)
+			padded.SetQuestion("www.example.com.", dns.TypeA)
+			padded.SetEdns0(4096, false)
+			o := padded.IsEdns0()
+			o.Option = append(o.Option, &dns.EDNS0_PADDING{Padding: make([]byte, 16)})
+
+			rec := dnstest.NewRecorder(dot)
+			_, err = th.ServeDNSWithRCODE(dotCtx, rec, padded)
+			require.NoError(t, err)
+			require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+			require.NotEmpty(t, rec.Msg.Answer)
+			require.Equal(t, DefaultPaddingBlockSize, rec.Msg.Len())
+			require.Equal(t, int64(1), ctr["DNS_response.padded"])
+
+			// queries without a padding option get unpadded responses
+			unpadded := new(dns.Msg)
+			unpadded.SetQuestion("www.example.com.", dns.TypeA)
+			unpadded.SetEdns0(4096, false)
+			rec = dnstest.NewRecorder(dot)
+			_, err = th.ServeDNSWithRCODE(dotCtx, rec, unpadded)
+			require.NoError(t, err)
+			require.Empty(t, rec.Msg.IsEdns0().Option)
+
+			// as do queries over transports not padded
+			tcp := &test.ResponseWriter{}
+			tcp.TCP = true
+			rec = dnstest.NewRecorder(tcp)
+			_, err = th.ServeDNSWithRCODE(ctx, rec, padded)
+			require.NoError(t, err)
+			require.Empty(t, rec.Msg.IsEdns0().Option)
+			require.Equal(t, int64(1), ctr["DNS_response.padded"])
+		})
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/db"
+)
+
+// PerfSamplingConfig controls the sampling of the RocksDB work done by
+// queries, such as block reads, block cache hits and iterator seeks, which is
+// logged for the slow ones
+type PerfSamplingConfig struct {
+	// SampleRate is the fraction of queries sampled, in [0.0, 1.0]
+	SampleRate float64
+	// SlowThreshold is how long sampled queries take at least for their
+	// sample to be logged
+	SlowThreshold time.Duration
+}
+
+// validate checks the sample rate and threshold of c
+func (c PerfSamplingConfig) validate() error {
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("invalid perf sample rate %v", c.SampleRate)
+	}
+	if c.SlowThreshold < 0 {
+		return fmt.Errorf("invalid perf slow query threshold %v", c.SlowThreshold)
+	}
+	return nil
+}
+
+// shouldSamplePerf returns true if the RocksDB work of a query is to be
+// sampled. Only the rocksdb driver has work to sample.
+func (h *FBDNSDB) shouldSamplePerf() bool {
+	rate := h.handlerConfig.PerfSampling.SampleRate
+	return rate > 0 && h.dbConfig.Driver == "rocksdb" && rand.Float64() < rate
+}
+
+// logPerfSample stops the perf sample of the query r, started at start, and
+// logs it to the debug log if the query was slow
+func (h *FBDNSDB) logPerfSample(r *dns.Msg, start time.Time, stop func() db.PerfSample) {
+	sample := stop()
+	h.stats.IncrementCounter("DNS_perf.sampled")
+	elapsed := time.Since(start)
+	if elapsed < h.handlerConfig.PerfSampling.SlowThreshold {
+		return
+	}
+	h.stats.IncrementCounter("DNS_perf.slow")
+	if glog.V(1) && len(r.Question) > 0 {
+		q := r.Question[0]
+		glog.Infof("Slow query %s %s took %v: %s", q.Name, dns.TypeToString[q.Qtype], elapsed, sample)
+	}
+}
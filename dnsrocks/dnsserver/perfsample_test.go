@@ -0,0 +1,73 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestPerfSamplingConfigValidate(t *testing.T) {
+	require.NoError(t, PerfSamplingConfig{SampleRate: 0.01, SlowThreshold: time.Millisecond}.validate())
+	require.Error(t, PerfSamplingConfig{SampleRate: 1.5}.validate())
+	require.Error(t, PerfSamplingConfig{SampleRate: -1}.validate())
+	require.Error(t, PerfSamplingConfig{SampleRate: 1, SlowThreshold: -time.Second}.validate())
+}
+
+func TestPerfSampling(t *testing.T) {
+	for _, testDB := range testaid.TestDBs {
+		t.Run(testDB.Driver+"/"+testDB.Flavour, func(t *testing.T) {
+			th := OpenDbForTesting(t, &testDB)
+			defer th.Close()
+			ctr := stats.NewCounters()
+			th.stats = ctr
+
+			query := func() {
+				req := new(dns.Msg)
+				req.SetQuestion("www.example.com.", dns.TypeA)
+				rec := dnstest.NewRecorder(&test.ResponseWriter{})
+				_, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+				require.NoError(t, err)
+				require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+			}
+
+			// every query is slow
+			th.handlerConfig.PerfSampling = PerfSamplingConfig{SampleRate: 1}
+			query()
+			// no query is
+			th.handlerConfig.PerfSampling = PerfSamplingConfig{SampleRate: 1, SlowThreshold: time.Hour}
+			query()
+			// no query is sampled
+			th.handlerConfig.PerfSampling = PerfSamplingConfig{}
+			query()
+
+			if testDB.Driver == "rocksdb" {
+				require.Equal(t, int64(2), ctr["DNS_perf.sampled"])
+				require.Equal(t, int64(1), ctr["DNS_perf.slow"])
+			} else {
+				// CDB lookups have no RocksDB work to sample
+				require.Zero(t, ctr["DNS_perf.sampled"])
+				require.Zero(t, ctr["DNS_perf.slow"])
+			}
+		})
+	}
+}
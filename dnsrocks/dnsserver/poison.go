@@ -0,0 +1,272 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/request"
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/db"
+)
+
+// DefaultPoisonLogSize is the default number of poison queries kept
+const DefaultPoisonLogSize = 16
+
+// maxQuarantined bounds the number of queries quarantined at once, so that a
+// flood of poison queries can't grow the quarantine without limit
+const maxQuarantined = 1024
+
+// PoisonConfig controls what happens to poison queries, the queries whose
+// handling panics. Panics are always recovered, and their queries answered
+// SERVFAIL, rather than crashing the server.
+type PoisonConfig struct {
+	// LogSize is the number of the last poison queries kept for
+	// FBDNSDB.PoisonQueries and the /poison endpoint of the debug HTTP
+	// server, DefaultPoisonLogSize if 0
+	LogSize int
+	// Quarantine is how long queries with the same name, type, class and
+	// client subnet as a poison query are answered SERVFAIL without being
+	// handled. 0 disables quarantine.
+	Quarantine time.Duration
+}
+
+// PoisonQuery is a query whose handling panicked
+type PoisonQuery struct {
+	Time time.Time `json:"time"`
+	// Client is the resolver IP, anonymized as it is for logging
+	Client    string `json:"client"`
+	Transport string `json:"transport,omitempty"`
+	Listener  string `json:"listener,omitempty"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Class     string `json:"class"`
+	// ECS is the client subnet of the query, if any
+	ECS   string `json:"ecs,omitempty"`
+	Panic string `json:"panic"`
+	Stack string `json:"stack"`
+	// Packet is the query in wire format, to replay it
+	Packet []byte `json:"packet,omitempty"`
+}
+
+// poison records poison queries and quarantines them
+type poison struct {
+	quarantine time.Duration
+	mu         sync.Mutex
+	queries    []PoisonQuery
+	next       int
+	full       bool
+	// quarantined holds the end of quarantine of query keys
+	quarantined map[string]time.Time
+}
+
+// newPoison validates c and returns the matching poison
+func newPoison(c PoisonConfig) (*poison, error) {
+	if c.LogSize < 0 {
+		return nil, fmt.Errorf("invalid poison query log size %d", c.LogSize)
+	}
+	if c.Quarantine < 0 {
+		return nil, fmt.Errorf("invalid poison query quarantine %v", c.Quarantine)
+	}
+	size := c.LogSize
+	if size == 0 {
+		size = DefaultPoisonLogSize
+	}
+	return &poison{
+		quarantine:  c.Quarantine,
+		queries:     make([]PoisonQuery, size),
+		quarantined: make(map[string]time.Time),
+	}, nil
+}
+
+// poisonKey returns the key identical queries share: their name, type,
+// class and client subnet, and false if r has no question
+func poisonKey(r *dns.Msg) (string, bool) {
+	if len(r.Question) == 0 {
+		return "", false
+	}
+	q := r.Question[0]
+	key := fmt.Sprintf("%s/%d/%d", strings.ToLower(q.Name), q.Qtype, q.Qclass)
+	if ecs := db.FindECS(r); ecs != nil {
+		key += "/" + ecsSubnet(ecs)
+	}
+	return key, true
+}
+
+// isQuarantined returns true if queries like r are quarantined at now
+func (p *poison) isQuarantined(r *dns.Msg, now time.Time) bool {
+	if p.quarantine == 0 {
+		return false
+	}
+	key, ok := poisonKey(r)
+	if !ok {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	until, found := p.quarantined[key]
+	if !found {
+		return false
+	}
+	if now.Before(until) {
+		return true
+	}
+	delete(p.quarantined, key)
+	return false
+}
+
+// add records the poison query q, and quarantines queries like r
+func (p *poison) add(q PoisonQuery, r *dns.Msg) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queries[p.next] = q
+	p.next++
+	if p.next == len(p.queries) {
+		p.next = 0
+		p.full = true
+	}
+	if p.quarantine == 0 {
+		return
+	}
+	key, ok := poisonKey(r)
+	if !ok {
+		return
+	}
+	if len(p.quarantined) >= maxQuarantined {
+		for k, until := range p.quarantined {
+			if !q.Time.Before(until) {
+				delete(p.quarantined, k)
+			}
+		}
+		if len(p.quarantined) >= maxQuarantined {
+			glog.Errorf("poison query quarantine is full, not quarantining %s", key)
+			return
+		}
+	}
+	p.quarantined[key] = q.Time.Add(p.quarantine)
+}
+
+// entries returns the poison queries recorded, oldest first
+func (p *poison) entries() []PoisonQuery {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	queries := append([]PoisonQuery(nil), p.queries[:p.next]...)
+	if p.full {
+		queries = append(append([]PoisonQuery(nil), p.queries[p.next:]...), queries...)
+	}
+	return queries
+}
+
+// PoisonQueries returns the last queries whose handling panicked, oldest first
+func (h *FBDNSDB) PoisonQueries() []PoisonQuery {
+	return h.poison.entries()
+}
+
+// recoverPanic records the query r whose handling panicked with e, and
+// answers it SERVFAIL unless a response was already written through w
+func (h *FBDNSDB) recoverPanic(ctx context.Context, w *writtenWriter, r *dns.Msg, e any) (int, error) {
+	q := PoisonQuery{
+		Time:  time.Now(),
+		Panic: fmt.Sprint(e),
+		Stack: string(debug.Stack()),
+	}
+	state := request.Request{W: w, Req: r}
+	if h.anonymizer != nil {
+		state = request.Request{W: h.anonymizer.wrap(w), Req: r}
+	}
+	q.Client = state.IP()
+	if info, ok := GetClientInfo(ctx); ok {
+		q.Transport = string(info.Transport)
+		q.Listener = info.Listener
+	}
+	if len(r.Question) > 0 {
+		q.Name = r.Question[0].Name
+		q.Type = dns.Type(r.Question[0].Qtype).String()
+		q.Class = dns.Class(r.Question[0].Qclass).String()
+	}
+	if ecs := db.FindECS(r); ecs != nil {
+		q.ECS = ecsSubnet(ecs)
+	}
+	// the packet may not pack if the handler broke it
+	if packet, err := r.Pack(); err == nil {
+		q.Packet = packet
+	}
+	h.poison.add(q, r)
+	glog.Errorf("recovered panic handling %s %s from %s: %s\n%s", q.Name, q.Type, q.Client, q.Panic, q.Stack)
+	err := fmt.Errorf("%w: %s", ErrPanic, q.Panic)
+	if w.written {
+		// the client already has its answer, a second one would only be
+		// dropped or confuse it
+		h.countError(q.Name, err)
+		return dns.RcodeSuccess, nil
+	}
+	return h.failPoison(w, r, err)
+}
+
+// failPoison counts err and answers r with its rcode and extended DNS error
+// directly, rather than through writeAndLog which may be what panics
+func (h *FBDNSDB) failPoison(w dns.ResponseWriter, r *dns.Msg, err error) (int, error) {
+	var qname string
+	if len(r.Question) > 0 {
+		qname = r.Question[0].Name
+	}
+	herr := h.countError(qname, err)
+	m := new(dns.Msg)
+	m.SetRcode(r, herr.Rcode)
+	if r.IsEdns0() != nil {
+		m.SetEdns0(4096, true)
+		m.IsEdns0().Option = append(m.IsEdns0().Option, herr.EDNS0())
+	}
+	if err := w.WriteMsg(m); err != nil {
+		return herr.Rcode, err
+	}
+	return herr.Rcode, nil
+}
+
+// writtenWriter is a dns.ResponseWriter recording whether a response was
+// written, so that queries panicking afterwards are not answered twice
+type writtenWriter struct {
+	dns.ResponseWriter
+	written bool
+}
+
+// WriteMsg writes m and records it was written.
+func (w *writtenWriter) WriteMsg(m *dns.Msg) error {
+	err := w.ResponseWriter.WriteMsg(m)
+	w.written = w.written || err == nil
+	return err
+}
+
+// Write writes b and records it was written.
+func (w *writtenWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.written = w.written || err == nil
+	return n, err
+}
+
+// ConnectionState forwards the TLS state of the underlying writer, if any.
+func (w *writtenWriter) ConnectionState() *tls.ConnectionState {
+	if cs, ok := w.ResponseWriter.(dns.ConnectionStater); ok {
+		return cs.ConnectionState()
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+// panickingLogger panics logging the responses to a name
+type panickingLogger struct {
+	DummyLogger
+	name   string
+	panics int
+}
+
+func (l *panickingLogger) Log(state request.Request, _ *dns.Msg, _ *dns.EDNS0_SUBNET, _ *db.Location) {
+	if state.Name() == l.name {
+		l.panics++
+		panic("poisoned " + l.name)
+	}
+}
+
+// panickingWriter panics writing the answers to a name
+type panickingWriter struct {
+	test.ResponseWriter
+	name   string
+	panics int
+}
+
+func (w *panickingWriter) WriteMsg(m *dns.Msg) error {
+	if m.Rcode == dns.RcodeSuccess && m.Question[0].Name == w.name {
+		w.panics++
+		panic("poisoned " + w.name)
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+func TestNewPoison(t *testing.T) {
+	p, err := newPoison(PoisonConfig{})
+	require.NoError(t, err)
+	require.Len(t, p.queries, DefaultPoisonLogSize)
+	require.Zero(t, p.quarantine)
+
+	for _, c := range []PoisonConfig{{LogSize: -1}, {Quarantine: -time.Second}} {
+		_, err := newPoison(c)
+		require.Error(t, err, c)
+	}
+}
+
+func TestPoisonQuarantine(t *testing.T) {
+	p, err := newPoison(PoisonConfig{LogSize: 2, Quarantine: time.Minute})
+	require.NoError(t, err)
+	now := time.Now()
+	query := func(name string, qtype uint16) *dns.Msg {
+		r := new(dns.Msg)
+		r.SetQuestion(name, qtype)
+		return r
+	}
+
+	p.add(PoisonQuery{Time: now, Name: "a.example.com."}, query("a.example.com.", dns.TypeA))
+	require.True(t, p.isQuarantined(query("A.example.COM.", dns.TypeA), now.Add(time.Second)))
+	require.False(t, p.isQuarantined(query("a.example.com.", dns.TypeAAAA), now.Add(time.Second)))
+	require.False(t, p.isQuarantined(query("b.example.com.", dns.TypeA), now.Add(time.Second)))
+	ecs := query("a.example.com.", dns.TypeA)
+	o, err := MakeOPTWithECS("192.0.2.0/24")
+	require.NoError(t, err)
+	ecs.Extra = append(ecs.Extra, o)
+	require.False(t, p.isQuarantined(ecs, now.Add(time.Second)), "other client subnet")
+	require.False(t, p.isQuarantined(query("a.example.com.", dns.TypeA), now.Add(time.Minute)), "quarantine expired")
+	require.Empty(t, p.quarantined)
+
+	// the log keeps the last queries
+	p.add(PoisonQuery{Time: now, Name: "b.example.com."}, query("b.example.com.", dns.TypeA))
+	p.add(PoisonQuery{Time: now, Name: "c.example.com."}, query("c.example.com.", dns.TypeA))
+	entries := p.entries()
+	require.Len(t, entries, 2)
+	require.Equal(t, "b.example.com.", entries[0].Name)
+	require.Equal(t, "c.example.com.", entries[1].Name)
+}
+
+// TestHandlerPoison checks that queries whose handling panics are answered
+// SERVFAIL, recorded and quarantined
+func TestHandlerPoison(t *testing.T) {
+	writer := &panickingWriter{name: "www.example.com."}
+	query := func(t *testing.T, th *FBDNSDB, name string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		req.SetEdns0(4096, false)
+		ctx := WithClientInfo(WithMaxAnswer(context.Background(), 1), ClientInfo{Transport: TransportUDP, Listener: "192.0.2.53:53"})
+		rec := dnstest.NewRecorder(writer)
+		_, err := th.ServeDNSWithRCODE(ctx, rec, req)
+		require.NoError(t, err)
+		require.NotNil(t, rec.Msg)
+		return rec.Msg
+	}
+
+	for _, db := range testaid.TestDBs {
+		t.Run(db.Driver+"/"+db.Flavour, func(t *testing.T) {
+			writer.panics = 0
+			ctr := stats.NewCounters()
+			config := HandlerConfig{Poison: PoisonConfig{Quarantine: time.Minute}}
+			th, err := NewFBDNSDBBasic(config, DBConfig{Path: db.Path, Driver: db.Driver}, CacheConfig{}, &DummyLogger{}, ctr)
+			require.NoError(t, err)
+			require.NoError(t, th.Load())
+			defer th.Close()
+
+			resp := query(t, th, "www.example.com.")
+			require.Equal(t, dns.RcodeServerFailure, resp.Rcode)
+			require.Equal(t, &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeOther}, resp.IsEdns0().Option[0])
+			require.Equal(t, int64(1), ctr[ErrPanic.StatsKey()])
+
+			poison := th.PoisonQueries()
+			require.Len(t, poison, 1)
+			require.Equal(t, "www.example.com.", poison[0].Name)
+			require.Equal(t, "A", poison[0].Type)
+			require.Equal(t, "IN", poison[0].Class)
+			require.Equal(t, "192.0.2.53:53", poison[0].Listener)
+			require.Equal(t, "poisoned www.example.com.", poison[0].Panic)
+			require.Contains(t, poison[0].Stack, "panickingWriter")
+			packet := new(dns.Msg)
+			require.NoError(t, packet.Unpack(poison[0].Packet))
+			require.Equal(t, "www.example.com.", packet.Question[0].Name)
+
+			// identical queries are quarantined, whatever the case
+			resp = query(t, th, "WWW.example.com.")
+			require.Equal(t, dns.RcodeServerFailure, resp.Rcode)
+			require.Equal(t, int64(1), ctr[ErrQuarantined.StatsKey()])
+			require.Equal(t, 1, writer.panics)
+
+			// others are answered
+			resp = query(t, th, "example.com.")
+			require.Equal(t, dns.RcodeSuccess, resp.Rcode)
+			require.Equal(t, int64(3), ctr["DNS_queries"])
+		})
+	}
+}
+
+// TestPoisonQuarantineSkipsShadow checks that quarantined queries are not
+// compared with the shadow DB, which doesn't quarantine them
+func TestPoisonQuarantineSkipsShadow(t *testing.T) {
+	config := HandlerConfig{Poison: PoisonConfig{Quarantine: time.Minute}}
+	dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+	th, err := NewFBDNSDBBasic(config, dbConfig, CacheConfig{}, &DummyLogger{}, stats.NewCounters())
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeA)
+	th.poison.add(PoisonQuery{Time: time.Now(), Name: "www.example.com."}, req)
+	ctx, skip := withShadowSkip(context.Background())
+	rcode, err := th.ServeDNSWithRCODE(ctx, &test.ResponseWriter{}, req)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeServerFailure, rcode)
+	require.True(t, *skip)
+}
+
+// TestHandlerPoisonAnswered checks that queries panicking after being answered
+// are recorded, but not answered twice
+func TestHandlerPoisonAnswered(t *testing.T) {
+	for _, db := range testaid.TestDBs {
+		t.Run(db.Driver+"/"+db.Flavour, func(t *testing.T) {
+			ctr := stats.NewCounters()
+			logger := &panickingLogger{name: "www.example.com."}
+			th, err := NewFBDNSDBBasic(HandlerConfig{}, DBConfig{Path: db.Path, Driver: db.Driver}, CacheConfig{}, logger, ctr)
+			require.NoError(t, err)
+			require.NoError(t, th.Load())
+			defer th.Close()
+
+			req := new(dns.Msg)
+			req.SetQuestion("www.example.com.", dns.TypeA)
+			w := &test.ResponseWriter{}
+			rec := dnstest.NewRecorder(w)
+			rcode, err := th.ServeDNSWithRCODE(context.Background(), rec, req)
+			require.NoError(t, err)
+			require.Equal(t, dns.RcodeSuccess, rcode)
+			require.Equal(t, 1, logger.panics)
+			require.Equal(t, uint64(1), w.GetWriteMsgCallCount())
+			require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+			require.NotEmpty(t, rec.Msg.Answer)
+			require.Equal(t, int64(1), ctr[ErrPanic.StatsKey()])
+			require.Len(t, th.PoisonQueries(), 1)
+		})
+	}
+}
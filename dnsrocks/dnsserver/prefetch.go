@@ -0,0 +1,109 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+)
+
+// cacheEntry is a response cached until expiration, in Unix seconds
+type cacheEntry struct {
+	expiration int64
+	response   *dns.Msg
+	// hits counts the cache hits of the entry, prefetching is set once it is
+	// being refreshed
+	hits        atomic.Int64
+	prefetching atomic.Bool
+}
+
+func (c CacheConfig) validate() error {
+	if c.PrefetchHits > 0 && c.PrefetchWindow <= 0 {
+		return fmt.Errorf("cache prefetch window must be positive, got %d", c.PrefetchWindow)
+	}
+	return nil
+}
+
+// shouldPrefetch counts a hit of e at now, in Unix seconds, and tells if e
+// is popular and close enough to expiry to be refreshed. It is true once per
+// entry.
+func (c CacheConfig) shouldPrefetch(e *cacheEntry, now int64) bool {
+	if c.PrefetchHits <= 0 {
+		return false
+	}
+	return e.hits.Add(1) >= c.PrefetchHits &&
+		e.expiration-now <= c.PrefetchWindow &&
+		e.prefetching.CompareAndSwap(false, true)
+}
+
+// prefetchKey is the context key marking the queries replayed to refresh
+// cache entries
+type prefetchKey struct{}
+
+// isPrefetch tells if the query of ctx is replayed to refresh a cache entry
+func isPrefetch(ctx context.Context) bool {
+	_, ok := ctx.Value(prefetchKey{}).(bool)
+	return ok
+}
+
+// isAccounted tells if the query of ctx was received from a client, rather
+// than replayed to refresh a cache entry or sent by the health checker: only
+// those are accounted for in stats, logs, top talkers and quotas
+func isAccounted(ctx context.Context) bool {
+	return !isPrefetch(ctx) && !isProbe(ctx)
+}
+
+// countQuery increments the query counter key, unless the query of ctx is
+// not accounted for
+func (h *FBDNSDB) countQuery(ctx context.Context, key string) {
+	if isAccounted(ctx) {
+		h.stats.IncrementCounter(key)
+	}
+}
+
+// prefetch refreshes the cache entry of req, received on w, by replaying it
+// in the background with the values of ctx. The replay skips the cache
+// lookup, zone quotas, top talkers, query logs and query counters, and its
+// response is discarded once cached.
+func (h *FBDNSDB) prefetch(ctx context.Context, w dns.ResponseWriter, req *dns.Msg) {
+	h.stats.IncrementCounter("DNS_cache.prefetch")
+	ctx = context.WithValue(context.WithoutCancel(ctx), prefetchKey{}, true)
+	pw := &prefetchWriter{local: w.LocalAddr(), remote: w.RemoteAddr()}
+	req = req.Copy()
+	go func() {
+		if _, err := h.ServeDNSWithRCODE(ctx, pw, req); err != nil {
+			glog.Errorf("Failed to prefetch %s: %v", req.Question[0].Name, err)
+		}
+	}()
+}
+
+// prefetchWriter is the dns.ResponseWriter of replayed queries, with the
+// addresses of the original query. Responses are discarded.
+type prefetchWriter struct {
+	local, remote net.Addr
+}
+
+func (w *prefetchWriter) LocalAddr() net.Addr         { return w.local }
+func (w *prefetchWriter) RemoteAddr() net.Addr        { return w.remote }
+func (w *prefetchWriter) WriteMsg(*dns.Msg) error     { return nil }
+func (w *prefetchWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *prefetchWriter) Close() error                { return nil }
+func (w *prefetchWriter) TsigStatus() error           { return nil }
+func (w *prefetchWriter) TsigTimersOnly(bool)         {}
+func (w *prefetchWriter) Hijack()                     {}
@@ -0,0 +1,99 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestCacheConfigShouldPrefetch(t *testing.T) {
+	c := CacheConfig{PrefetchHits: 2, PrefetchWindow: 10}
+	now := time.Now().Unix()
+
+	e := &cacheEntry{expiration: now + 5}
+	require.False(t, c.shouldPrefetch(e, now), "not popular yet")
+	require.True(t, c.shouldPrefetch(e, now))
+	require.False(t, c.shouldPrefetch(e, now), "already prefetching")
+
+	e = &cacheEntry{expiration: now + 60}
+	require.False(t, c.shouldPrefetch(e, now))
+	require.False(t, c.shouldPrefetch(e, now), "too far from expiry")
+	require.True(t, c.shouldPrefetch(e, now+50))
+
+	require.False(t, CacheConfig{}.shouldPrefetch(&cacheEntry{expiration: now}, now), "disabled")
+
+	require.NoError(t, CacheConfig{}.validate())
+	require.Error(t, CacheConfig{PrefetchHits: 1}.validate())
+}
+
+// TestHandlerCachePrefetch checks that popular cache entries are replaced in
+// the background before expiring
+func TestHandlerCachePrefetch(t *testing.T) {
+	dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+	cacheConfig := CacheConfig{Enabled: true, LRUSize: 1024, PrefetchHits: 2, PrefetchWindow: 2000}
+	ctr := &syncCounters{Counters: stats.NewCounters()}
+	th, err := NewFBDNSDBBasic(HandlerConfig{}, dbConfig, cacheConfig, &DummyLogger{}, ctr)
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+
+	query := func() {
+		req := new(dns.Msg)
+		req.SetQuestion("www.example.com.", dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: "1.1.1.1"})
+		rcode, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeSuccess, rcode)
+		require.NotEmpty(t, rec.Msg.Answer)
+	}
+	cached := func() *cacheEntry {
+		keys := th.lru.Keys()
+		if len(keys) != 1 {
+			return nil
+		}
+		v, _ := th.lru.Peek(keys[0])
+		return v.(*cacheEntry)
+	}
+
+	query()
+	first := cached()
+	require.NotNil(t, first)
+	query()
+	require.Same(t, first, cached())
+	require.False(t, first.prefetching.Load())
+
+	query()
+	require.True(t, first.prefetching.Load())
+	require.Eventually(t, func() bool { return cached() != first }, 5*time.Second, time.Millisecond)
+	refreshed := cached()
+	require.Equal(t, first.response.Answer, refreshed.response.Answer)
+
+	query()
+	require.Same(t, refreshed, cached())
+	require.False(t, refreshed.prefetching.Load(), "hits of the replaced entry are not counted")
+
+	// the replay is not counted as a query
+	require.Equal(t, int64(1), ctr.get("DNS_cache.prefetch"))
+	require.Equal(t, int64(4), ctr.get("DNS_queries"))
+	require.Equal(t, int64(4), ctr.get(typeToStatsKey(dns.TypeA)))
+}
@@ -0,0 +1,174 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// Resolver privacy modes.
+const (
+	// PrivacyModeOff uses resolver IPs as-is.
+	PrivacyModeOff = ""
+	// PrivacyModeTruncate zeroes the host bits of resolver IPs before map
+	// lookups and logging.
+	PrivacyModeTruncate = "truncate"
+	// PrivacyModeHash truncates resolver IPs for map lookups, and logs a keyed
+	// hash of the truncated address instead of the address itself.
+	PrivacyModeHash = "hash"
+)
+
+// Default prefix lengths kept when truncating resolver IPs.
+const (
+	DefaultPrivacyIPv4PrefixLen = 24
+	DefaultPrivacyIPv6PrefixLen = 48
+)
+
+// PrivacyConfig controls how resolver IPs are anonymized before they are used
+// for resolver-based map lookups and logging.
+type PrivacyConfig struct {
+	// Mode is one of PrivacyModeOff, PrivacyModeTruncate or PrivacyModeHash.
+	Mode string
+	// IPv4PrefixLen is the number of leading bits kept for IPv4 resolvers.
+	// 0 means DefaultPrivacyIPv4PrefixLen.
+	IPv4PrefixLen int
+	// IPv6PrefixLen is the number of leading bits kept for IPv6 resolvers.
+	// 0 means DefaultPrivacyIPv6PrefixLen.
+	IPv6PrefixLen int
+	// HashKey is the HMAC key used in PrivacyModeHash.
+	HashKey []byte
+}
+
+// ipAnonymizer applies a validated PrivacyConfig.
+type ipAnonymizer struct {
+	hash   bool
+	v4Mask net.IPMask
+	v6Mask net.IPMask
+	key    []byte
+}
+
+// newIPAnonymizer validates c and returns the matching anonymizer, or nil
+// when privacy mode is off.
+func newIPAnonymizer(c PrivacyConfig) (*ipAnonymizer, error) {
+	switch c.Mode {
+	case PrivacyModeOff:
+		return nil, nil
+	case PrivacyModeTruncate, PrivacyModeHash:
+	default:
+		return nil, fmt.Errorf("unknown resolver privacy mode %q", c.Mode)
+	}
+	v4, v6 := c.IPv4PrefixLen, c.IPv6PrefixLen
+	if v4 == 0 {
+		v4 = DefaultPrivacyIPv4PrefixLen
+	}
+	if v6 == 0 {
+		v6 = DefaultPrivacyIPv6PrefixLen
+	}
+	if v4 < 0 || v4 > 8*net.IPv4len {
+		return nil, fmt.Errorf("invalid IPv4 privacy prefix length %d", v4)
+	}
+	if v6 < 0 || v6 > 8*net.IPv6len {
+		return nil, fmt.Errorf("invalid IPv6 privacy prefix length %d", v6)
+	}
+	a := &ipAnonymizer{
+		hash:   c.Mode == PrivacyModeHash,
+		v4Mask: net.CIDRMask(v4, 8*net.IPv4len),
+		v6Mask: net.CIDRMask(v6, 8*net.IPv6len),
+	}
+	if a.hash {
+		if len(c.HashKey) == 0 {
+			return nil, fmt.Errorf("resolver privacy mode %q requires a hash key", c.Mode)
+		}
+		a.key = c.HashKey
+	}
+	return a, nil
+}
+
+// truncate zeroes the host bits of ip according to its address family.
+func (a *ipAnonymizer) truncate(ip net.IP) net.IP {
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(a.v4Mask)
+	}
+	return ip.Mask(a.v6Mask)
+}
+
+// lookupIP returns the truncated form of the textual address ip, to be used
+// for resolver-based map lookups. Unparsable input is returned unchanged.
+func (a *ipAnonymizer) lookupIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	return a.truncate(parsed).String()
+}
+
+// logIP returns the address that may be logged for ip. In hash mode it is a
+// keyed hash of the truncated address, shaped as an address of the same
+// family so that existing log consumers keep working.
+func (a *ipAnonymizer) logIP(ip net.IP) net.IP {
+	t := a.truncate(ip)
+	if !a.hash || t == nil {
+		return t
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(t.To16())
+	sum := mac.Sum(nil)
+	return net.IP(sum[:len(t)])
+}
+
+// anonymizeAddr returns a copy of addr with its IP replaced by logIP.
+func (a *ipAnonymizer) anonymizeAddr(addr net.Addr) net.Addr {
+	switch v := addr.(type) {
+	case *net.UDPAddr:
+		return &net.UDPAddr{IP: a.logIP(v.IP), Port: v.Port}
+	case *net.TCPAddr:
+		return &net.TCPAddr{IP: a.logIP(v.IP), Port: v.Port}
+	case *net.IPAddr:
+		return &net.IPAddr{IP: a.logIP(v.IP)}
+	}
+	return addr
+}
+
+// wrap returns a ResponseWriter reporting the anonymized remote address.
+func (a *ipAnonymizer) wrap(w dns.ResponseWriter) dns.ResponseWriter {
+	return &anonymizedWriter{ResponseWriter: w, remote: a.anonymizeAddr(w.RemoteAddr())}
+}
+
+// anonymizedWriter is a dns.ResponseWriter hiding the real resolver address.
+type anonymizedWriter struct {
+	dns.ResponseWriter
+	remote net.Addr
+}
+
+// RemoteAddr returns the anonymized remote address.
+func (w *anonymizedWriter) RemoteAddr() net.Addr {
+	return w.remote
+}
+
+// ConnectionState forwards the TLS state of the underlying writer, if any.
+func (w *anonymizedWriter) ConnectionState() *tls.ConnectionState {
+	if cs, ok := w.ResponseWriter.(dns.ConnectionStater); ok {
+		return cs.ConnectionState()
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestNewIPAnonymizer(t *testing.T) {
+	testCases := []struct {
+		conf    PrivacyConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{conf: PrivacyConfig{}, wantNil: true},
+		{conf: PrivacyConfig{Mode: PrivacyModeTruncate}},
+		{conf: PrivacyConfig{Mode: PrivacyModeHash, HashKey: []byte("k")}},
+		{conf: PrivacyConfig{Mode: PrivacyModeHash}, wantErr: true},
+		{conf: PrivacyConfig{Mode: "bogus"}, wantErr: true},
+		{conf: PrivacyConfig{Mode: PrivacyModeTruncate, IPv4PrefixLen: 33}, wantErr: true},
+		{conf: PrivacyConfig{Mode: PrivacyModeTruncate, IPv6PrefixLen: -1}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%+v", tc.conf), func(t *testing.T) {
+			a, err := newIPAnonymizer(tc.conf)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.wantNil, a == nil)
+		})
+	}
+}
+
+func TestIPAnonymizerTruncate(t *testing.T) {
+	a, err := newIPAnonymizer(PrivacyConfig{Mode: PrivacyModeTruncate, IPv4PrefixLen: 16})
+	require.NoError(t, err)
+
+	require.Equal(t, "1.2.0.0", a.lookupIP("1.2.3.4"))
+	require.Equal(t, "2001:db8:1::", a.lookupIP("2001:db8:1:2::1"))
+	require.Equal(t, "not-an-ip", a.lookupIP("not-an-ip"))
+	require.Equal(t, "1.2.0.0", a.logIP(net.ParseIP("1.2.3.4")).String())
+}
+
+func TestIPAnonymizerHash(t *testing.T) {
+	a, err := newIPAnonymizer(PrivacyConfig{Mode: PrivacyModeHash, HashKey: []byte("secret")})
+	require.NoError(t, err)
+	b, err := newIPAnonymizer(PrivacyConfig{Mode: PrivacyModeHash, HashKey: []byte("other")})
+	require.NoError(t, err)
+
+	// lookups keep the configured precision
+	require.Equal(t, "1.2.3.0", a.lookupIP("1.2.3.4"))
+
+	h1 := a.logIP(net.ParseIP("1.2.3.4"))
+	require.Len(t, h1, net.IPv4len)
+	require.NotEqual(t, "1.2.3.0", h1.String())
+	// same truncated prefix, same hash
+	require.Equal(t, h1, a.logIP(net.ParseIP("1.2.3.200")))
+	require.NotEqual(t, h1, a.logIP(net.ParseIP("1.2.4.4")))
+	require.NotEqual(t, h1, b.logIP(net.ParseIP("1.2.3.4")))
+
+	h6 := a.logIP(net.ParseIP("2001:db8::1"))
+	require.Len(t, h6, net.IPv6len)
+	require.Nil(t, h6.To4())
+}
+
+func TestAnonymizedWriter(t *testing.T) {
+	a, err := newIPAnonymizer(PrivacyConfig{Mode: PrivacyModeTruncate})
+	require.NoError(t, err)
+
+	w := a.wrap(&test.ResponseWriterCustomRemote{RemoteIP: "192.0.2.77"})
+	addr, ok := w.RemoteAddr().(*net.UDPAddr)
+	require.True(t, ok)
+	require.Equal(t, "192.0.2.0", addr.IP.String())
+	require.Equal(t, 40212, addr.Port)
+
+	cs, ok := w.(dns.ConnectionStater)
+	require.True(t, ok)
+	require.Nil(t, cs.ConnectionState())
+}
+
+func TestResolverPrivacyResponse(t *testing.T) {
+	testCases := []struct {
+		privacy  PrivacyConfig
+		resolver string
+		target   string
+		logged   string
+	}{
+		// 1.1.1.1/32 maps to locID 2, but truncated to /24 it falls back to default
+		{
+			privacy:  PrivacyConfig{Mode: PrivacyModeTruncate},
+			resolver: "1.1.1.1",
+			target:   "bar.example.org.",
+			logged:   "1.1.1.0",
+		},
+		{
+			privacy:  PrivacyConfig{Mode: PrivacyModeTruncate, IPv4PrefixLen: 32},
+			resolver: "1.1.1.1",
+			target:   "foo.example.org.",
+			logged:   "1.1.1.1",
+		},
+		// fd48:6525:66bd::/56 maps to locID 5 and is kept at /48
+		{
+			privacy:  PrivacyConfig{Mode: PrivacyModeTruncate},
+			resolver: "fd48:6525:66bd::1",
+			target:   "foo.example.org.",
+			logged:   "fd48:6525:66bd::",
+		},
+		{
+			privacy:  PrivacyConfig{Mode: PrivacyModeHash, HashKey: []byte("secret"), IPv4PrefixLen: 32},
+			resolver: "1.1.1.1",
+			target:   "foo.example.org.",
+		},
+	}
+
+	for _, db := range testaid.TestDBs {
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s/%s/%s", db.Driver, tc.privacy.Mode, tc.resolver), func(t *testing.T) {
+				var logs bytes.Buffer
+				dbConfig := DBConfig{Path: db.Path, Driver: db.Driver, ReloadInterval: 10}
+				handlerConfig := HandlerConfig{ResolverPrivacy: tc.privacy}
+				th, err := NewFBDNSDBBasic(handlerConfig, dbConfig, CacheConfig{}, &TextLogger{IoWriter: &logs}, &stats.DummyStats{})
+				require.NoError(t, err)
+				require.NoError(t, th.Load())
+				defer th.Close()
+
+				req := new(dns.Msg)
+				req.SetQuestion("cnamemap.example.org.", dns.TypeA)
+				rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: tc.resolver})
+				code, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+				require.NoError(t, err)
+				require.Equal(t, dns.RcodeSuccess, code)
+				require.NotNil(t, rec.Msg)
+				require.Len(t, rec.Msg.Answer, 1)
+				require.Equal(t, tc.target, rec.Msg.Answer[0].(*dns.CNAME).Target)
+
+				if tc.logged != tc.resolver {
+					require.NotContains(t, logs.String(), "["+tc.resolver+"]")
+				}
+				if tc.logged != "" {
+					require.Contains(t, logs.String(), "["+tc.logged+"]")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"github.com/facebook/dns/dnsrocks/dnsjson"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+)
+
+// Query is a query resolved by QueryBatch
+type Query struct {
+	// Name is the query name
+	Name string `json:"name"`
+	// Type is the query type, e.g. "AAAA"
+	Type string `json:"type"`
+	// Client is the IP of the resolver the query comes from
+	Client string `json:"client,omitempty"`
+	// ECS is the client subnet sent by the resolver, e.g. "1.2.3.0/24"
+	ECS string `json:"ecs,omitempty"`
+}
+
+// QueryResult is the JSON-serializable result of a Query. Records are in
+// their text form.
+type QueryResult struct {
+	Query         Query    `json:"query"`
+	Rcode         string   `json:"rcode,omitempty"`
+	Authoritative bool     `json:"authoritative"`
+	Answer        []string `json:"answer"`
+	Authority     []string `json:"authority"`
+	Additional    []string `json:"additional"`
+	// Message is the whole response in the RFC 8427 JSON format, when
+	// requested
+	Message *dnsjson.Message `json:"message,omitempty"`
+	// Error is set when the query could not be resolved
+	Error string `json:"error,omitempty"`
+}
+
+// recordStrings returns the text form of rrs, leaving out EDNS0 options
+func recordStrings(rrs []dns.RR) []string {
+	records := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		records = append(records, rr.String())
+	}
+	return records
+}
+
+// QueryBatch resolves queries as QuerySingle does, returning up to maxAns
+// answers for each. Failed queries have their error set in their result.
+func (h *FBDNSDB) QueryBatch(queries []Query, maxAns int) []QueryResult {
+	return h.queryBatch(queries, maxAns, false)
+}
+
+// QueryBatchRFC8427 is QueryBatch with the responses in the RFC 8427 JSON
+// format in the Message of the results, for tools expecting a standard schema
+func (h *FBDNSDB) QueryBatchRFC8427(queries []Query, maxAns int) []QueryResult {
+	return h.queryBatch(queries, maxAns, true)
+}
+
+func (h *FBDNSDB) queryBatch(queries []Query, maxAns int, rfc8427 bool) []QueryResult {
+	results := make([]QueryResult, 0, len(queries))
+	for _, q := range queries {
+		rec, err := h.QuerySingle(q.Type, q.Name, q.Client, q.ECS, maxAns)
+		results = append(results, newQueryResult(q, rec, err, rfc8427))
+	}
+	return results
+}
+
+// newQueryResult returns the result of q given the response recorded for it,
+// with the RFC 8427 form of the response if rfc8427 is set
+func newQueryResult(q Query, rec *dnstest.Recorder, err error, rfc8427 bool) QueryResult {
+	result := QueryResult{Query: q}
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+	case rec.Msg == nil:
+		result.Error = "no response"
+	default:
+		result.Rcode = dns.RcodeToString[rec.Msg.Rcode]
+		result.Authoritative = rec.Msg.Authoritative
+		result.Answer = recordStrings(rec.Msg.Answer)
+		result.Authority = recordStrings(rec.Msg.Ns)
+		result.Additional = recordStrings(rec.Msg.Extra)
+		if rfc8427 {
+			if result.Message, err = dnsjson.FromMsg(rec.Msg); err != nil {
+				result.Error = err.Error()
+			}
+		}
+	}
+	return result
+}
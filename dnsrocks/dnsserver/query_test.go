@@ -0,0 +1,97 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestQueryBatch(t *testing.T) {
+	queries := []Query{
+		{Name: "foo.example.org", Type: "A", Client: "2.2.2.2"},
+		{Name: "foo.example.org", Type: "AAAA", Client: "::1", ECS: "3.3.0.0/16"},
+		{Name: "example.org", Type: "MX", Client: "2.2.2.2"},
+		{Name: "nxdomain.example.org", Type: "A", Client: "2.2.2.2"},
+		{Name: "foo.example.org", Type: "BOGUS", Client: "2.2.2.2"},
+	}
+	expected := []QueryResult{
+		{
+			Query:         queries[0],
+			Rcode:         "NOERROR",
+			Authoritative: true,
+			Answer:        []string{"foo.example.org.\t180\tIN\tA\t1.1.1.3"},
+			Authority:     []string{},
+			Additional:    []string{},
+		},
+		{
+			Query:         queries[1],
+			Rcode:         "NOERROR",
+			Authoritative: true,
+			Answer:        []string{"foo.example.org.\t180\tIN\tAAAA\tfd24:7859:f076:2a21::5"},
+			Authority:     []string{},
+			Additional:    []string{},
+		},
+		{
+			Query:         queries[2],
+			Rcode:         "NOERROR",
+			Authoritative: true,
+			Answer:        []string{},
+			Authority:     []string{"example.org.\t120\tIN\tSOA\ta.ns.example.org. dns.example.org. 123 7200 1800 604800 120"},
+			Additional:    []string{},
+		},
+		{
+			Query:         queries[3],
+			Rcode:         "NXDOMAIN",
+			Authoritative: true,
+			Answer:        []string{},
+			Authority:     []string{"example.org.\t120\tIN\tSOA\ta.ns.example.org. dns.example.org. 123 7200 1800 604800 120"},
+			Additional:    []string{},
+		},
+		{
+			Query: queries[4],
+			Error: "could not find Rrtype, error: unknown QTYPE BOGUS, aborting",
+		},
+	}
+	for _, db := range testaid.TestDBs {
+		t.Run(db.Driver, func(t *testing.T) {
+			th := OpenDbForTesting(t, &db)
+			defer th.Close()
+
+			results := th.QueryBatch(queries, 1)
+			require.Equal(t, expected, results)
+			_, err := json.Marshal(results)
+			require.NoError(t, err)
+
+			results = th.QueryBatchRFC8427(queries, 1)
+			for i, r := range results {
+				if expected[i].Error != "" {
+					require.Nil(t, r.Message)
+					continue
+				}
+				require.NotNil(t, r.Message)
+				require.Equal(t, dns.Fqdn(queries[i].Name), r.Message.QNAME)
+				require.Equal(t, queries[i].Type, r.Message.QTYPEname)
+				require.Equal(t, len(expected[i].Answer), r.Message.ANCOUNT)
+				r.Message = nil
+				require.Equal(t, expected[i], r)
+			}
+		})
+	}
+}
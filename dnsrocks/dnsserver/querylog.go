@@ -0,0 +1,174 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/db"
+)
+
+// QueryLogConfig configures the in-memory log of the last queries answered,
+// dumped on demand by the /querylog endpoint of the debug HTTP server or
+// FBDNSDB.QueryLog, without verbose logging having to be enabled beforehand.
+type QueryLogConfig struct {
+	// Size is the number of queries kept, the oldest ones being overwritten.
+	// The log is disabled if 0.
+	Size int
+}
+
+// validate checks the size of c
+func (c QueryLogConfig) validate() error {
+	if c.Size < 0 {
+		return fmt.Errorf("invalid query log size %d", c.Size)
+	}
+	return nil
+}
+
+// QueryLogEntry is a query of the query log, with its response
+type QueryLogEntry struct {
+	Time time.Time `json:"time"`
+	// Client is the resolver IP, anonymized as it is for logging
+	Client    string `json:"client"`
+	Transport string `json:"transport"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	// ECS is the client subnet of the query, if any
+	ECS string `json:"ecs,omitempty"`
+	// Location is the hex encoded ID of the location the client matched
+	Location   string   `json:"location,omitempty"`
+	Rcode      string   `json:"rcode"`
+	Answer     []string `json:"answer"`
+	Authority  []string `json:"authority"`
+	Additional []string `json:"additional"`
+	// Failed is set when the query was not answered, so that the server
+	// failed it with rcode
+	Failed bool `json:"failed,omitempty"`
+}
+
+// queryLogRecord is a query of the query log, as recorded. Responses are only
+// turned into text when the log is dumped.
+type queryLogRecord struct {
+	time      time.Time
+	client    string
+	transport string
+	name      string
+	qtype     uint16
+	ecs       string
+	loc       *db.Location
+	// resp is the response written, nil if the query failed with rcode
+	resp  *dns.Msg
+	rcode int
+}
+
+// queryLog is a ring buffer of the last queries answered
+type queryLog struct {
+	mu      sync.Mutex
+	records []queryLogRecord
+	next    int
+	full    bool
+}
+
+// newQueryLog validates c and returns the matching queryLog, or nil when it
+// is disabled.
+func newQueryLog(c QueryLogConfig) (*queryLog, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if c.Size == 0 {
+		return nil, nil
+	}
+	return &queryLog{records: make([]queryLogRecord, c.Size)}, nil
+}
+
+// add records the query of state, answered with resp, or failed with rcode
+// if resp is nil. resp must not be modified afterwards.
+func (l *queryLog) add(state request.Request, resp *dns.Msg, rcode int, ecs *dns.EDNS0_SUBNET, loc *db.Location) {
+	r := queryLogRecord{
+		time:      time.Now(),
+		client:    state.IP(),
+		transport: strings.ToUpper(state.Proto()),
+		name:      state.Name(),
+		qtype:     state.QType(),
+		loc:       loc,
+		resp:      resp,
+		rcode:     rcode,
+	}
+	if info, ok := ClientInfoOf(state); ok {
+		r.transport = strings.ToUpper(string(info.Transport))
+	}
+	if ecs != nil {
+		r.ecs = ecsSubnet(ecs)
+	}
+	l.mu.Lock()
+	l.records[l.next] = r
+	l.next++
+	if l.next == len(l.records) {
+		l.next = 0
+		l.full = true
+	}
+	l.mu.Unlock()
+}
+
+// entries returns the last n queries of the log, oldest first, or all of them
+// if n is negative
+func (l *queryLog) entries(n int) []QueryLogEntry {
+	l.mu.Lock()
+	records := append([]queryLogRecord(nil), l.records[:l.next]...)
+	if l.full {
+		records = append(append([]queryLogRecord(nil), l.records[l.next:]...), records...)
+	}
+	l.mu.Unlock()
+	if n >= 0 && n < len(records) {
+		records = records[len(records)-n:]
+	}
+	entries := make([]QueryLogEntry, 0, len(records))
+	for _, r := range records {
+		e := QueryLogEntry{
+			Time:      r.time,
+			Client:    r.client,
+			Transport: r.transport,
+			Name:      r.name,
+			Type:      dns.Type(r.qtype).String(),
+			ECS:       r.ecs,
+			Rcode:     dns.RcodeToString[r.rcode],
+			Failed:    r.resp == nil,
+		}
+		if r.loc != nil && len(r.loc.LocID) > 0 {
+			e.Location = fmt.Sprintf("%x", r.loc.LocID.Contents())
+		}
+		if r.resp != nil {
+			e.Answer = recordStrings(r.resp.Answer)
+			e.Authority = recordStrings(r.resp.Ns)
+			e.Additional = recordStrings(r.resp.Extra)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// QueryLog returns the last n queries answered, oldest first, or all of the
+// ones kept if n is negative. It returns nil if the query log is disabled.
+func (h *FBDNSDB) QueryLog(n int) []QueryLogEntry {
+	if h.queryLog == nil {
+		return nil
+	}
+	return h.queryLog.entries(n)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestNewQueryLog(t *testing.T) {
+	l, err := newQueryLog(QueryLogConfig{})
+	require.NoError(t, err)
+	require.Nil(t, l)
+
+	l, err = newQueryLog(QueryLogConfig{Size: 3})
+	require.NoError(t, err)
+	require.Empty(t, l.entries(-1))
+
+	_, err = newQueryLog(QueryLogConfig{Size: -1})
+	require.Error(t, err)
+}
+
+// queryLogHandler returns a handler keeping the last size queries, and a
+// function querying it for qname from client
+func queryLogHandler(t *testing.T, size int) (*FBDNSDB, func(qname, client string)) {
+	dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+	th, err := NewFBDNSDBBasic(HandlerConfig{QueryLog: QueryLogConfig{Size: size}}, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, &stats.DummyStats{})
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	t.Cleanup(th.Close)
+	return th, func(qname, client string) {
+		req := new(dns.Msg)
+		req.SetQuestion(qname, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: client})
+		_, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+		require.NoError(t, err)
+	}
+}
+
+func TestQueryLog(t *testing.T) {
+	th, query := queryLogHandler(t, 3)
+	query("www.example.com.", "1.1.1.1")
+	entries := th.QueryLog(-1)
+	require.Len(t, entries, 1)
+	e := entries[0]
+	require.False(t, e.Time.IsZero())
+	require.Equal(t, "1.1.1.1", e.Client)
+	require.Equal(t, "UDP", e.Transport)
+	require.Equal(t, "www.example.com.", e.Name)
+	require.Equal(t, "A", e.Type)
+	require.Equal(t, "NOERROR", e.Rcode)
+	require.NotEmpty(t, e.Answer)
+	require.False(t, e.Failed)
+
+	// the oldest queries are overwritten
+	for i := 2; i <= 5; i++ {
+		query(fmt.Sprintf("%d.example.com.", i), fmt.Sprintf("1.1.1.%d", i))
+	}
+	var clients []string
+	for _, e := range th.QueryLog(-1) {
+		clients = append(clients, e.Client)
+	}
+	require.Equal(t, []string{"1.1.1.3", "1.1.1.4", "1.1.1.5"}, clients)
+	entries = th.QueryLog(1)
+	require.Len(t, entries, 1)
+	require.Equal(t, "5.example.com.", entries[0].Name)
+	require.Empty(t, th.QueryLog(0))
+
+	th.queryLog = nil
+	require.Nil(t, th.QueryLog(-1))
+}
+
+func TestDebugServerQueryLog(t *testing.T) {
+	th, query := queryLogHandler(t, 10)
+	s, err := NewDebugServer(th, DebugConfig{Addr: "localhost:0"})
+	require.NoError(t, err)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	query("www.example.com.", "1.1.1.1")
+	query("nxdomain.example.org.", "1.1.1.2")
+
+	var entries []QueryLogEntry
+	require.Equal(t, http.StatusOK, getJSON(t, ts.URL+"/querylog", &entries))
+	require.Len(t, entries, 2)
+	require.Equal(t, "www.example.com.", entries[0].Name)
+	require.Equal(t, "NXDOMAIN", entries[1].Rcode)
+	require.Empty(t, entries[1].Answer)
+	require.NotEmpty(t, entries[1].Authority)
+
+	require.Equal(t, http.StatusOK, getJSON(t, ts.URL+"/querylog?n=1", &entries))
+	require.Len(t, entries, 1)
+	require.Equal(t, "nxdomain.example.org.", entries[0].Name)
+	require.Equal(t, http.StatusBadRequest, getJSON(t, ts.URL+"/querylog?n=-1", &entries))
+
+	th.queryLog = nil
+	require.Equal(t, http.StatusNotFound, getJSON(t, ts.URL+"/querylog", &entries))
+}
@@ -0,0 +1,82 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+// Question count policies, for queries without exactly one question.
+const (
+	// QuestionCountFirst answers the first question of queries with more
+	// than one, and fails queries without any with SERVFAIL.
+	QuestionCountFirst = ""
+	// QuestionCountFormErr answers FORMERR to queries without exactly one
+	// question.
+	QuestionCountFormErr = "formerr"
+	// QuestionCountNotImp answers NOTIMP to queries without exactly one
+	// question.
+	QuestionCountNotImp = "notimp"
+)
+
+func validateQuestionCount(policy string) error {
+	switch policy {
+	case QuestionCountFirst, QuestionCountFormErr, QuestionCountNotImp:
+		return nil
+	}
+	return fmt.Errorf("unknown question count policy %q", policy)
+}
+
+// questionCountRcode returns the rcode answering a query with n questions
+// following policy, and false if the query is served
+func questionCountRcode(policy string, n int) (int, bool) {
+	if n == 1 || (n > 1 && policy == QuestionCountFirst) {
+		return dns.RcodeSuccess, false
+	}
+	switch policy {
+	case QuestionCountFormErr:
+		return dns.RcodeFormatError, true
+	case QuestionCountNotImp:
+		return dns.RcodeNotImplemented, true
+	}
+	return dns.RcodeServerFailure, true
+}
+
+// RejectQuestionCount answers r following policy if it doesn't have exactly
+// one question, and counts such queries in s. It returns the rcode answered
+// and true if r must not be served any further.
+func RejectQuestionCount(policy string, w dns.ResponseWriter, r *dns.Msg, s stats.Stats) (int, bool) {
+	n := len(r.Question)
+	if n == 0 {
+		s.IncrementCounter("DNS_queries.malformed.no_question")
+	} else if n > 1 {
+		s.IncrementCounter("DNS_queries.malformed.multiple_questions")
+	}
+	rcode, reject := questionCountRcode(policy, n)
+	if !reject {
+		return rcode, false
+	}
+	s.IncrementCounter("DNS_queries.malformed.rejected")
+	m := new(dns.Msg)
+	m.SetRcode(r, rcode)
+	if err := w.WriteMsg(m); err != nil {
+		glog.Errorf("failed to reject query with %d questions: %v", n, err)
+	}
+	return rcode, true
+}
@@ -0,0 +1,89 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"os"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestValidateQuestionCount(t *testing.T) {
+	for _, policy := range []string{QuestionCountFirst, QuestionCountFormErr, QuestionCountNotImp} {
+		require.NoError(t, validateQuestionCount(policy))
+	}
+	require.Error(t, validateQuestionCount("refused"))
+	_, err := NewFBDNSDBBasic(HandlerConfig{QuestionCount: "refused"}, DBConfig{}, CacheConfig{}, &DummyLogger{}, &stats.DummyStats{})
+	require.Error(t, err)
+}
+
+// TestQuestionCount checks how each policy answers queries without exactly
+// one question
+func TestQuestionCount(t *testing.T) {
+	testCases := []struct {
+		policy    string
+		questions int
+		rcode     int
+		// answered is true if the first question is answered
+		answered bool
+		counter  string
+	}{
+		{policy: QuestionCountFirst, questions: 1, rcode: dns.RcodeSuccess, answered: true},
+		{policy: QuestionCountFirst, questions: 2, rcode: dns.RcodeSuccess, answered: true, counter: "DNS_queries.malformed.multiple_questions"},
+		{policy: QuestionCountFirst, questions: 0, rcode: dns.RcodeServerFailure, counter: "DNS_queries.malformed.no_question"},
+		{policy: QuestionCountFormErr, questions: 1, rcode: dns.RcodeSuccess, answered: true},
+		{policy: QuestionCountFormErr, questions: 2, rcode: dns.RcodeFormatError, counter: "DNS_queries.malformed.multiple_questions"},
+		{policy: QuestionCountFormErr, questions: 0, rcode: dns.RcodeFormatError, counter: "DNS_queries.malformed.no_question"},
+		{policy: QuestionCountNotImp, questions: 2, rcode: dns.RcodeNotImplemented, counter: "DNS_queries.malformed.multiple_questions"},
+		{policy: QuestionCountNotImp, questions: 0, rcode: dns.RcodeNotImplemented, counter: "DNS_queries.malformed.no_question"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.policy+"/"+dns.RcodeToString[tc.rcode], func(t *testing.T) {
+			ctr := stats.NewCounters()
+			dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+			th, err := NewFBDNSDBBasic(HandlerConfig{QuestionCount: tc.policy}, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+			require.NoError(t, err)
+			require.NoError(t, th.Load())
+			defer th.Close()
+
+			req := new(dns.Msg)
+			req.SetQuestion("www.example.com.", dns.TypeA)
+			req.Question = append(req.Question, dns.Question{Name: "www.example.org.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+			req.Question = req.Question[:tc.questions]
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			rcode, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+			require.NoError(t, err)
+			require.Equal(t, tc.rcode, rcode)
+			require.NotNil(t, rec.Msg)
+			require.Equal(t, tc.rcode, rec.Msg.Rcode)
+			if tc.answered {
+				require.NotEmpty(t, rec.Msg.Answer)
+				require.Zero(t, ctr["DNS_queries.malformed.rejected"])
+			} else {
+				require.Empty(t, rec.Msg.Answer)
+				require.Equal(t, int64(1), ctr["DNS_queries.malformed.rejected"])
+			}
+			if tc.counter != "" {
+				require.Equal(t, int64(1), ctr[tc.counter])
+			}
+		})
+	}
+}
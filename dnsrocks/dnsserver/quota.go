@@ -0,0 +1,232 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+
+	"github.com/miekg/dns"
+)
+
+// Actions on queries over the quota of their zone
+const (
+	// QuotaRefuse answers REFUSED, with the Prohibited extended DNS error
+	QuotaRefuse = "refuse"
+	// QuotaDrop doesn't answer at all, as if the query was lost
+	QuotaDrop = "drop"
+)
+
+// ZoneQuota caps the rate of the queries for the zones of an owner, so that
+// a query storm for one of them can't starve the others. All the zones of a
+// quota share its rate.
+type ZoneQuota struct {
+	// Owner tags the quota in stats keys, the first zone if empty, or
+	// "root" for the root zone
+	Owner string
+	// Zones are the names the quota applies to, along with the names below
+	// them
+	Zones []string
+	// QPS is the sustained rate of queries allowed, in queries per second
+	QPS float64
+	// Burst is the number of queries allowed at once, the QPS rounded up if 0
+	Burst int
+	// Action is QuotaRefuse or QuotaDrop, QuotaRefuse if empty
+	Action string
+}
+
+// String returns the quota as parsed by ParseZoneQuota
+func (q ZoneQuota) String() string {
+	s := strings.Join(q.Zones, ",") + " qps=" + strconv.FormatFloat(q.QPS, 'g', -1, 64)
+	if q.Burst > 0 {
+		s += " burst=" + strconv.Itoa(q.Burst)
+	}
+	if q.Action != "" {
+		s += " action=" + q.Action
+	}
+	if q.Owner != "" {
+		s += " owner=" + q.Owner
+	}
+	return s
+}
+
+// ParseZoneQuota parses a quota written as
+// `zone[,zone...] qps=rate [burst=queries] [action=refuse|drop] [owner=tag]`,
+// e.g. `example.com,example.net qps=1000 burst=2000 action=drop owner=acme`
+func ParseZoneQuota(s string) (ZoneQuota, error) {
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return ZoneQuota{}, fmt.Errorf("empty zone quota")
+	}
+	q := ZoneQuota{Zones: strings.Split(f[0], ",")}
+	for _, field := range f[1:] {
+		name, value, _ := strings.Cut(field, "=")
+		var err error
+		switch name {
+		case "qps":
+			q.QPS, err = strconv.ParseFloat(value, 64)
+		case "burst":
+			q.Burst, err = strconv.Atoi(value)
+		case "action":
+			q.Action = value
+		case "owner":
+			q.Owner = value
+		default:
+			return q, fmt.Errorf("unknown field %q in zone quota %q, expected qps=, burst=, action= or owner=", field, s)
+		}
+		if err != nil {
+			return q, fmt.Errorf("invalid %s in zone quota %q: %w", name, s, err)
+		}
+	}
+	return q, q.validate()
+}
+
+func (q ZoneQuota) validate() error {
+	if !(q.QPS > 0) || q.Burst < 0 {
+		return fmt.Errorf("invalid rate of zone quota %q", q)
+	}
+	switch q.Action {
+	case "", QuotaRefuse, QuotaDrop:
+	default:
+		return fmt.Errorf("unknown action %q of zone quota %q", q.Action, q)
+	}
+	for _, zone := range q.Zones {
+		if _, ok := dns.IsDomainName(zone); !ok || zone == "" {
+			return fmt.Errorf("invalid zone %q of zone quota %q", zone, q)
+		}
+	}
+	if strings.ContainsAny(q.Owner, " \t") {
+		return fmt.Errorf("invalid owner %q of zone quota %q", q.Owner, q)
+	}
+	return nil
+}
+
+// quotaBucket is the token bucket of a quota, along with its stats keys
+type quotaBucket struct {
+	action string
+	rate   float64
+	burst  float64
+
+	l      sync.Mutex
+	tokens float64
+	last   time.Time
+
+	queriesKey, overKey string
+}
+
+// allow takes a token from the bucket, if any is left at now
+func (b *quotaBucket) allow(now time.Time) bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// zoneQuotas finds and enforces the quota of queries
+type zoneQuotas struct {
+	// buckets by lower case FQDN
+	buckets map[string]*quotaBucket
+	now     func() time.Time
+}
+
+func newZoneQuotas(quotas []ZoneQuota) (*zoneQuotas, error) {
+	if len(quotas) == 0 {
+		return nil, nil
+	}
+	z := &zoneQuotas{buckets: make(map[string]*quotaBucket), now: time.Now}
+	owners := make(map[string]bool, len(quotas))
+	now := z.now()
+	for _, q := range quotas {
+		if err := q.validate(); err != nil {
+			return nil, err
+		}
+		if len(q.Zones) == 0 {
+			return nil, fmt.Errorf("zone quota %q has no zone", q)
+		}
+		owner := q.Owner
+		if owner == "" {
+			owner = strings.TrimSuffix(dns.CanonicalName(q.Zones[0]), ".")
+		}
+		if owner == "" {
+			owner = "root"
+		}
+		if owners[owner] {
+			return nil, fmt.Errorf("duplicate owner %q of zone quota %q", owner, q)
+		}
+		owners[owner] = true
+		burst := float64(q.Burst)
+		if q.Burst == 0 {
+			burst = max(1, math.Ceil(q.QPS))
+		}
+		b := &quotaBucket{
+			action:     q.Action,
+			rate:       q.QPS,
+			burst:      burst,
+			tokens:     burst,
+			last:       now,
+			queriesKey: "DNS_quota." + owner + ".queries",
+			overKey:    "DNS_quota." + owner + ".over",
+		}
+		if b.action == "" {
+			b.action = QuotaRefuse
+		}
+		for _, zone := range q.Zones {
+			zone = dns.CanonicalName(zone)
+			if _, ok := z.buckets[zone]; ok {
+				return nil, fmt.Errorf("zone %q is in several zone quotas", zone)
+			}
+			z.buckets[zone] = b
+		}
+	}
+	return z, nil
+}
+
+// bucket returns the bucket of the closest enclosing zone of qname, a lower
+// case FQDN, nil if there is none
+func (z *zoneQuotas) bucket(qname string) *quotaBucket {
+	for off, end := 0, false; !end; off, end = dns.NextLabel(qname, off) {
+		if b, ok := z.buckets[qname[off:]]; ok {
+			return b
+		}
+	}
+	return z.buckets["."]
+}
+
+// enforce counts a query for qname, a lower case FQDN, against the quota of
+// its zone, and returns the action to take if it is over quota, "" otherwise
+func (z *zoneQuotas) enforce(qname string, s stats.Stats) string {
+	b := z.bucket(qname)
+	if b == nil {
+		return ""
+	}
+	s.IncrementCounter(b.queriesKey)
+	if b.allow(z.now()) {
+		return ""
+	}
+	s.IncrementCounter(b.overKey)
+	return b.action
+}
@@ -0,0 +1,167 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestParseZoneQuota(t *testing.T) {
+	q, err := ParseZoneQuota("example.com,example.net. qps=1000.5 burst=2000 action=drop owner=acme")
+	require.NoError(t, err)
+	require.Equal(t, ZoneQuota{
+		Owner:  "acme",
+		Zones:  []string{"example.com", "example.net."},
+		QPS:    1000.5,
+		Burst:  2000,
+		Action: QuotaDrop,
+	}, q)
+	require.Equal(t, "example.com,example.net. qps=1000.5 burst=2000 action=drop owner=acme", q.String())
+
+	q, err = ParseZoneQuota(". qps=10")
+	require.NoError(t, err)
+	require.Equal(t, ZoneQuota{Zones: []string{"."}, QPS: 10}, q)
+
+	for _, s := range []string{
+		"", "example.com", "example.com qps=0", "example.com qps=-1", "example.com qps=NaN", "example.com qps=x",
+		"example.com qps=1 burst=-1", "example.com qps=1 action=ignore", "example.com qps=1 rate=2", "example.com, qps=1",
+	} {
+		_, err := ParseZoneQuota(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestQuotaBucket(t *testing.T) {
+	z, err := newZoneQuotas([]ZoneQuota{{Zones: []string{"example.com"}, QPS: 2.5}})
+	require.NoError(t, err)
+	b := z.buckets["example.com."]
+	start := b.last
+	// the burst is the rate rounded up
+	for range 3 {
+		require.True(t, b.allow(start))
+	}
+	require.False(t, b.allow(start))
+	require.False(t, b.allow(start.Add(100*time.Millisecond)))
+	require.True(t, b.allow(start.Add(400*time.Millisecond)))
+	require.False(t, b.allow(start.Add(400*time.Millisecond)))
+	// tokens don't accumulate past the burst
+	for range 3 {
+		require.True(t, b.allow(start.Add(time.Hour)))
+	}
+	require.False(t, b.allow(start.Add(time.Hour)))
+	// nor does time going backwards add any
+	require.False(t, b.allow(start))
+}
+
+func TestZoneQuotasBucket(t *testing.T) {
+	z, err := newZoneQuotas([]ZoneQuota{
+		{Zones: []string{"Example.com", "example.net."}, QPS: 1},
+		{Zones: []string{"www.example.com"}, QPS: 1, Owner: "www"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "DNS_quota.example.com.queries", z.bucket("example.net.").queriesKey)
+	require.Equal(t, z.bucket("example.net."), z.bucket("a.b.example.com."))
+	require.Equal(t, "DNS_quota.www.over", z.bucket("foo.www.example.com.").overKey)
+	require.Nil(t, z.bucket("example.org."))
+	require.Nil(t, z.bucket("com."))
+	require.Nil(t, z.bucket("."))
+
+	z, err = newZoneQuotas([]ZoneQuota{{Zones: []string{"."}, QPS: 1}})
+	require.NoError(t, err)
+	require.Equal(t, "DNS_quota.root.queries", z.bucket(".").queriesKey)
+	require.Equal(t, z.bucket("."), z.bucket("example.org."))
+
+	for _, quotas := range [][]ZoneQuota{
+		{{Zones: []string{"example.com"}, QPS: 1}, {Zones: []string{"EXAMPLE.com."}, QPS: 2}},
+		{{Zones: []string{"example.com"}, QPS: 1, Owner: "a"}, {Zones: []string{"example.net"}, QPS: 2, Owner: "a"}},
+		{{QPS: 1}},
+		{{Zones: []string{"example.com"}}},
+	} {
+		_, err := newZoneQuotas(quotas)
+		require.Error(t, err, "%v", quotas)
+	}
+	z, err = newZoneQuotas(nil)
+	require.NoError(t, err)
+	require.Nil(t, z)
+}
+
+func TestZoneQuotaHandler(t *testing.T) {
+	quotas := []ZoneQuota{
+		{Zones: []string{"example.com"}, QPS: 1, Burst: 2},
+		{Zones: []string{"example.net", "example.org"}, QPS: 1, Action: QuotaDrop, Owner: "net"},
+	}
+	ctr := stats.NewCounters()
+	dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+	th, err := NewFBDNSDBBasic(HandlerConfig{ZoneQuotas: quotas}, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+	now := time.Now()
+	th.quotas.now = func() time.Time { return now }
+
+	query := func(qname string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(qname, dns.TypeA)
+		req.SetEdns0(4096, false)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+		require.NoError(t, err)
+		return rec.Msg
+	}
+
+	for range 2 {
+		m := query("foo.example.com.")
+		require.NotNil(t, m)
+		require.Equal(t, dns.RcodeSuccess, m.Rcode)
+	}
+	m := query("FOO.example.com.")
+	require.NotNil(t, m)
+	require.Equal(t, dns.RcodeRefused, m.Rcode)
+	require.Equal(t, []dns.EDNS0{ErrQuotaExceeded.EDNS0()}, m.IsEdns0().Option)
+	require.Equal(t, int64(3), ctr["DNS_quota.example.com.queries"])
+	require.Equal(t, int64(1), ctr["DNS_quota.example.com.over"])
+
+	// zones of an owner share its quota, and zones without one are not limited
+	m = query("www.example.net.")
+	require.NotNil(t, m)
+	require.Nil(t, query("www.example.org."))
+	require.Equal(t, int64(2), ctr["DNS_quota.net.queries"])
+	require.Equal(t, int64(1), ctr["DNS_quota.net.over"])
+	require.Equal(t, int64(2), ctr[ErrQuotaExceeded.StatsKey()])
+	for range 3 {
+		m = query("www.notourdomain.com.")
+		require.NotNil(t, m)
+		require.Equal(t, dns.RcodeRefused, m.Rcode)
+	}
+	require.Equal(t, int64(2), ctr[ErrQuotaExceeded.StatsKey()])
+
+	// the quota refills over time
+	now = now.Add(time.Second)
+	m = query("foo.example.com.")
+	require.NotNil(t, m)
+	require.Equal(t, dns.RcodeSuccess, m.Rcode)
+
+	_, err = NewFBDNSDBBasic(HandlerConfig{ZoneQuotas: []ZoneQuota{{Zones: []string{"example.com"}}}}, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+	require.Error(t, err)
+}
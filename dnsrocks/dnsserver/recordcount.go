@@ -0,0 +1,110 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+// MaxRecordCountZones is the largest number of zones whose records can be
+// counted on their own, which bounds the number of stats exported
+const MaxRecordCountZones = 100
+
+// RecordCountsConfig controls the counting of the resource records of the DB
+// at every load and reload, so that monitoring can alert on empty or shrunken
+// publishes. Counting reads the whole DB.
+type RecordCountsConfig struct {
+	// Enabled counts the records of the whole DB
+	Enabled bool
+	// Zones are the zones whose records are also counted on their own, at
+	// most MaxRecordCountZones
+	Zones []string
+}
+
+// recordCounter counts the records of each DB loaded, and exports the counts
+// with their change since the previous DB
+type recordCounter struct {
+	zones    []string
+	stats    stats.Stats
+	previous *db.RecordCounts
+}
+
+// newRecordCounter validates c and returns the matching recordCounter, or
+// nil when counting is disabled.
+func newRecordCounter(c RecordCountsConfig, s stats.Stats) (*recordCounter, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	if len(c.Zones) > MaxRecordCountZones {
+		return nil, fmt.Errorf("too many zones to count records of: %d, at most %d", len(c.Zones), MaxRecordCountZones)
+	}
+	r := &recordCounter{stats: s}
+	for _, zone := range c.Zones {
+		if _, ok := dns.IsDomainName(zone); !ok {
+			return nil, fmt.Errorf("invalid zone to count records of %q", zone)
+		}
+		r.zones = append(r.zones, dns.CanonicalName(zone))
+	}
+	return r, nil
+}
+
+// count returns the record counts of d, or nil if they can't be counted, in
+// which case d is served anyway
+func (r *recordCounter) count(d *db.DB) *db.RecordCounts {
+	counts, err := d.CountRecords(r.zones)
+	if err != nil {
+		glog.Errorf("Failed to count DB records: %v", err)
+		r.stats.IncrementCounter("DNS_db.records.error")
+		return nil
+	}
+	return &counts
+}
+
+// recordCountKey returns the stat of the record count of zone
+func recordCountKey(zone string) string {
+	if zone != "." {
+		zone = strings.TrimSuffix(zone, ".")
+	}
+	return "DNS_db.records.zone." + zone
+}
+
+// report exports counts, the counts of the DB now in use, and their change
+// since the previous DB counted. Changes are 0 for the first DB.
+func (r *recordCounter) report(counts *db.RecordCounts) {
+	if counts == nil {
+		return
+	}
+	previous := r.previous
+	if previous == nil {
+		previous = counts
+	}
+	r.stats.ResetCounterTo("DNS_db.records", counts.Total)
+	r.stats.ResetCounterTo("DNS_db.records.delta", counts.Total-previous.Total)
+	for _, zone := range r.zones {
+		key := recordCountKey(zone)
+		r.stats.ResetCounterTo(key, counts.Zones[zone])
+		r.stats.ResetCounterTo(key+".delta", counts.Zones[zone]-previous.Zones[zone])
+	}
+	if counts.Total < previous.Total {
+		glog.Warningf("DB has %d records, %d less than the previous one", counts.Total, previous.Total-counts.Total)
+	}
+	r.previous = counts
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestNewRecordCounter(t *testing.T) {
+	r, err := newRecordCounter(RecordCountsConfig{Zones: []string{"example.com"}}, &stats.DummyStats{})
+	require.NoError(t, err)
+	require.Nil(t, r)
+
+	r, err = newRecordCounter(RecordCountsConfig{Enabled: true, Zones: []string{"Example.com", "example.org."}}, &stats.DummyStats{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"example.com.", "example.org."}, r.zones)
+
+	_, err = newRecordCounter(RecordCountsConfig{Enabled: true, Zones: []string{"example..com"}}, &stats.DummyStats{})
+	require.Error(t, err)
+
+	zones := make([]string, MaxRecordCountZones+1)
+	for i := range zones {
+		zones[i] = fmt.Sprintf("zone%d.example.com", i)
+	}
+	_, err = newRecordCounter(RecordCountsConfig{Enabled: true, Zones: zones}, &stats.DummyStats{})
+	require.Error(t, err)
+}
+
+// TestRecordCountsReload checks that record counts and their changes are
+// exported at load and reload time
+func TestRecordCountsReload(t *testing.T) {
+	full := testaid.NewFixture().
+		Zone("example.com", 300, "ns.example.com").
+		Address("www.example.com", "192.0.2.1", 300, "").
+		Address("mail.example.com", "192.0.2.2", 300, "").
+		Zone("example.org", 300, "ns.example.org").
+		Build(t)
+	shrunk := testaid.NewFixture().
+		Zone("example.com", 300, "ns.example.com").
+		Zone("example.org", 300, "ns.example.org").
+		Address("www.example.org", "192.0.2.3", 300, "").
+		Build(t)
+
+	for i, db := range full {
+		t.Run(db.Driver+"/"+db.Flavour, func(t *testing.T) {
+			ctr := stats.NewCounters()
+			dbConfig := DBConfig{
+				Path:          db.Path,
+				Driver:        db.Driver,
+				ReloadTimeout: 10 * time.Second,
+				RecordCounts:  RecordCountsConfig{Enabled: true, Zones: []string{"example.com"}},
+			}
+			th, err := NewFBDNSDBBasic(HandlerConfig{}, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+			require.NoError(t, err)
+			require.NoError(t, th.Load())
+			defer th.Close()
+
+			require.Equal(t, int64(6), ctr["DNS_db.records"])
+			require.Equal(t, int64(0), ctr["DNS_db.records.delta"])
+			require.Equal(t, int64(4), ctr["DNS_db.records.zone.example.com"])
+			require.Equal(t, int64(0), ctr["DNS_db.records.zone.example.com.delta"])
+
+			require.NoError(t, th.Reload(*NewFullReloadSignal(shrunk[i].Path)))
+			require.Equal(t, int64(5), ctr["DNS_db.records"])
+			require.Equal(t, int64(-1), ctr["DNS_db.records.delta"])
+			require.Equal(t, int64(2), ctr["DNS_db.records.zone.example.com"])
+			require.Equal(t, int64(-2), ctr["DNS_db.records.zone.example.com.delta"])
+			require.Zero(t, ctr["DNS_db.records.error"])
+		})
+	}
+}
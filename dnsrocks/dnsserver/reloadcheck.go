@@ -0,0 +1,180 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+// ErrReloadCheckFailed - a canary query failed against a newly loaded DB
+var ErrReloadCheckFailed = errors.New("reload check failed")
+
+// ReloadCheck is a canary query run against a newly loaded DB before
+// switching to it, on full reloads and on the partial reloads which reopen
+// the DB.
+type ReloadCheck struct {
+	// Name is the query name
+	Name string
+	// Type is the query type, e.g. "AAAA"
+	Type string
+	// Rcode is the expected response code
+	Rcode int
+	// MinAnswers is the minimum number of records expected in the answer
+	// section
+	MinAnswers int
+	// Client is the IP of the resolver the query comes from. 127.0.0.1 is
+	// used if empty.
+	Client string
+}
+
+// String returns the check as found in a checks file
+func (c ReloadCheck) String() string {
+	return fmt.Sprintf("%s %s %s %d %s", c.Name, c.Type, dns.RcodeToString[c.Rcode], c.MinAnswers, c.Client)
+}
+
+// ParseReloadChecks reads reload checks, one
+// `name type rcode [min-answers [client]]` per line, e.g.
+// `www.example.com AAAA NOERROR 1`. Empty lines and lines starting with #
+// are ignored.
+func ParseReloadChecks(r io.Reader) ([]ReloadCheck, error) {
+	var checks []ReloadCheck
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) < 3 || len(f) > 5 {
+			return nil, fmt.Errorf("line %d: expected 'name type rcode [min-answers [client]]'", lineno)
+		}
+		c := ReloadCheck{Name: f[0], Type: strings.ToUpper(f[1])}
+		if _, ok := dns.IsDomainName(c.Name); !ok {
+			return nil, fmt.Errorf("line %d: invalid name %q", lineno, c.Name)
+		}
+		if _, err := rrTypeToUnit(c.Type); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineno, err)
+		}
+		rcode, ok := dns.StringToRcode[strings.ToUpper(f[2])]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown rcode %s", lineno, f[2])
+		}
+		c.Rcode = rcode
+		if len(f) > 3 {
+			n, err := strconv.Atoi(f[3])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("line %d: invalid min-answers %q", lineno, f[3])
+			}
+			c.MinAnswers = n
+		}
+		if len(f) > 4 {
+			if net.ParseIP(f[4]) == nil {
+				return nil, fmt.Errorf("line %d: invalid client %q", lineno, f[4])
+			}
+			c.Client = f[4]
+		}
+		checks = append(checks, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return checks, nil
+}
+
+// run runs the check against canary, with the values of ctx
+func (c ReloadCheck) run(ctx context.Context, canary *FBDNSDB) error {
+	client := c.Client
+	if client == "" {
+		client = "127.0.0.1"
+	}
+	rec, err := canary.querySingle(ctx, c.Type, c.Name, client, "", DefaultMaxAnswer)
+	if err != nil {
+		return err
+	}
+	if rec.Msg == nil {
+		return fmt.Errorf("no response")
+	}
+	if rec.Msg.Rcode != c.Rcode {
+		return fmt.Errorf("got rcode %s, expected %s", dns.RcodeToString[rec.Msg.Rcode], dns.RcodeToString[c.Rcode])
+	}
+	if len(rec.Msg.Answer) < c.MinAnswers {
+		return fmt.Errorf("got %d answers, expected at least %d", len(rec.Msg.Answer), c.MinAnswers)
+	}
+	return nil
+}
+
+// newCanary returns a handler answering from newDB the way h does, but
+// without cache nor side effects: no stats, logs, shadow reads, NOTIFY,
+// query log, top talkers nor quotas
+func (h *FBDNSDB) newCanary(newDB *db.DB) (*FBDNSDB, error) {
+	handlerConfig := h.handlerConfig
+	handlerConfig.Shadow = ShadowConfig{}
+	handlerConfig.Notify = NotifyConfig{}
+	handlerConfig.QueryLog = QueryLogConfig{}
+	handlerConfig.TopTalkers = TopTalkersConfig{}
+	handlerConfig.ZoneQuotas = nil
+	// queries panicking on the new DB fail their check, unquarantined
+	handlerConfig.Poison = PoisonConfig{}
+	dbConfig := h.dbConfig
+	dbConfig.MemoryBudgetMB = 0
+	dbConfig.RecordCounts = RecordCountsConfig{}
+	dbConfig.CatchUpInterval = 0
+	canary, err := NewFBDNSDBBasic(handlerConfig, dbConfig, CacheConfig{}, &DummyLogger{}, &stats.DummyStats{})
+	if err != nil {
+		return nil, err
+	}
+	canary.dnsdb = newDB
+	// the TTL clamp, and in tests the anonymizer, can differ from the
+	// configuration
+	canary.anonymizer = h.anonymizer
+	canary.ttlClamp.Store(h.ttlClamp.Load())
+	return canary, nil
+}
+
+// runReloadChecks runs the configured reload checks against newDB, and
+// returns an error listing the failed ones
+func (h *FBDNSDB) runReloadChecks(newDB *db.DB) error {
+	if len(h.dbConfig.ReloadChecks) == 0 {
+		return nil
+	}
+	canary, err := h.newCanary(newDB)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, c := range h.dbConfig.ReloadChecks {
+		if err := c.run(context.Background(), canary); err != nil {
+			glog.Errorf("Reload check %q failed: %v", c, err)
+			h.stats.IncrementCounter("DNS_db.reload_check.failed")
+			failed = append(failed, fmt.Sprintf("%s %s: %v", c.Name, c.Type, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", ErrReloadCheckFailed, strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestParseReloadChecks(t *testing.T) {
+	checks, err := ParseReloadChecks(strings.NewReader(`
+# canaries
+foo.example.org A NOERROR 1 1.1.1.1
+nxdomain.example.org aaaa nxdomain
+example.org SOA NOERROR 1
+`))
+	require.NoError(t, err)
+	require.Equal(t, []ReloadCheck{
+		{Name: "foo.example.org", Type: "A", Rcode: dns.RcodeSuccess, MinAnswers: 1, Client: "1.1.1.1"},
+		{Name: "nxdomain.example.org", Type: "AAAA", Rcode: dns.RcodeNameError},
+		{Name: "example.org", Type: "SOA", Rcode: dns.RcodeSuccess, MinAnswers: 1},
+	}, checks)
+
+	for _, bad := range []string{
+		"example.org A",
+		"example.org A NOERROR 1 1.1.1.1 extra",
+		"example..org A NOERROR",
+		"example.org BOGUS NOERROR",
+		"example.org A BOGUS",
+		"example.org A NOERROR -1",
+		"example.org A NOERROR 1 not-an-ip",
+	} {
+		_, err := ParseReloadChecks(strings.NewReader(bad))
+		require.Error(t, err, bad)
+	}
+}
+
+func TestReloadChecks(t *testing.T) {
+	th := OpenDbForTesting(t, &testaid.TestCDB)
+	defer th.Close()
+	ctr := stats.NewCounters()
+	th.stats = ctr
+	th.dbConfig.ReloadTimeout = 10 * time.Second
+	th.dbConfig.ReloadChecks = []ReloadCheck{
+		{Name: "foo.example.org", Type: "A", Rcode: dns.RcodeSuccess, MinAnswers: 1, Client: "1.1.1.1"},
+		{Name: "nxdomain.example.org", Type: "A", Rcode: dns.RcodeNameError},
+	}
+
+	err := th.Reload(*NewFullReloadSignal(testaid.TestCDB.Path))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), ctr["DNS_db.reload"])
+
+	th.dbConfig.ReloadChecks = append(th.dbConfig.ReloadChecks,
+		ReloadCheck{Name: "foo.example.org", Type: "A", Rcode: dns.RcodeNameError},
+		ReloadCheck{Name: "example.org", Type: "MX", Rcode: dns.RcodeSuccess, MinAnswers: 1},
+	)
+	err = th.Reload(*NewFullReloadSignal(testaid.TestCDB.Path))
+	require.ErrorIs(t, err, ErrReloadCheckFailed)
+	require.Contains(t, err.Error(), "foo.example.org A: got rcode NOERROR, expected NXDOMAIN")
+	require.Contains(t, err.Error(), "example.org MX: got 0 answers, expected at least 1")
+	require.Equal(t, int64(1), ctr["DNS_db.reload"])
+	require.Equal(t, int64(1), ctr["DNS_db.ErrReloadCheckFailed"])
+	require.Equal(t, int64(2), ctr["DNS_db.reload_check.failed"])
+
+	// the old DB is still served
+	rec, err := th.QuerySingle("A", "foo.example.org", "1.1.1.1", "", 1)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+	require.Len(t, rec.Msg.Answer, 1)
+
+	// partial reloads reopen CDB files, they are checked too
+	err = th.Reload(*NewPartialReloadSignal())
+	require.ErrorIs(t, err, ErrReloadCheckFailed)
+	require.Equal(t, int64(2), ctr["DNS_db.ErrReloadCheckFailed"])
+
+	th.dbConfig.ReloadChecks = th.dbConfig.ReloadChecks[:2]
+	err = th.Reload(*NewPartialReloadSignal())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), ctr["DNS_db.reload"])
+}
+
+// queryingStats answers a query through h whenever a reload check fails
+type queryingStats struct {
+	stats.Stats
+	h       *FBDNSDB
+	answers chan int
+}
+
+func (s *queryingStats) IncrementCounter(key string) {
+	if key == "DNS_db.reload_check.failed" {
+		rec, err := s.h.QuerySingle("A", "foo.example.org", "1.1.1.1", "", 1)
+		if err == nil && rec.Msg != nil {
+			s.answers <- rec.Msg.Rcode
+		}
+	}
+	s.Stats.IncrementCounter(key)
+}
+
+// TestReloadChecksDontBlockQueries checks that the DB in use keeps answering
+// while the new one is checked
+func TestReloadChecksDontBlockQueries(t *testing.T) {
+	th := OpenDbForTesting(t, &testaid.TestCDB)
+	defer th.Close()
+	s := &queryingStats{Stats: stats.NewCounters(), h: th, answers: make(chan int, 1)}
+	th.stats = s
+	th.dbConfig.ReloadTimeout = 10 * time.Second
+	th.dbConfig.ReloadChecks = []ReloadCheck{{Name: "foo.example.org", Type: "A", Rcode: dns.RcodeNameError}}
+
+	done := make(chan error)
+	go func() {
+		done <- th.Reload(*NewFullReloadSignal(testaid.TestCDB.Path))
+	}()
+	select {
+	case rcode := <-s.answers:
+		require.Equal(t, dns.RcodeSuccess, rcode)
+	case <-time.After(5 * time.Second):
+		t.Fatal("query blocked by the reload check")
+	}
+	require.ErrorIs(t, <-done, ErrReloadCheckFailed)
+}
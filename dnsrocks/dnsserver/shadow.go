@@ -0,0 +1,265 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+// DefaultShadowMaxInFlight is the default number of concurrent shadow reads
+const DefaultShadowMaxInFlight = 64
+
+// ShadowConfig configures shadow reads: a fraction of queries is additionally
+// resolved against a second DB, and differences with the served answers are
+// counted and sampled to logs.
+type ShadowConfig struct {
+	// DB is the shadow DB. Shadow reads are disabled if its Path is empty.
+	DB DBConfig
+	// SampleRate is the fraction of queries also resolved against the shadow
+	// DB, in [0.0, 1.0].
+	SampleRate float64
+	// LogSampleRate is the fraction of differences logged, in [0.0, 1.0].
+	LogSampleRate float64
+	// MaxInFlight bounds the number of concurrent shadow reads, queries are
+	// not shadowed while it is reached. 0 means DefaultShadowMaxInFlight.
+	MaxInFlight int
+}
+
+// Kinds of differences between primary and shadow answers
+const (
+	shadowMismatchRcode  = "rcode"
+	shadowMismatchAnswer = "answer"
+)
+
+// shadowReader resolves sampled queries against the shadow DB and compares
+// the answers with the ones served from the primary DB.
+type shadowReader struct {
+	conf     ShadowConfig
+	db       *FBDNSDB
+	inFlight chan struct{}
+	stats    stats.Stats
+}
+
+// newShadowReader validates c and returns the matching shadowReader, or nil
+// when shadow reads are disabled. The shadow DB still needs to be loaded.
+func newShadowReader(c ShadowConfig, handlerConfig HandlerConfig, s stats.Stats) (*shadowReader, error) {
+	if c.DB.Path == "" {
+		return nil, nil
+	}
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return nil, fmt.Errorf("invalid shadow sample rate %v", c.SampleRate)
+	}
+	if c.LogSampleRate < 0 || c.LogSampleRate > 1 {
+		return nil, fmt.Errorf("invalid shadow log sample rate %v", c.LogSampleRate)
+	}
+	if c.MaxInFlight < 0 {
+		return nil, fmt.Errorf("invalid shadow max in-flight reads %d", c.MaxInFlight)
+	}
+	if c.MaxInFlight == 0 {
+		c.MaxInFlight = DefaultShadowMaxInFlight
+	}
+	// The shadow DB shapes answers the same way, minus the cache so that
+	// every sampled query actually reads from it. Server policies, e.g. zone
+	// quotas, are left to the primary.
+	shadowDB, err := NewFBDNSDBBasic(handlerConfig.answerConfig(), c.DB, CacheConfig{}, &DummyLogger{}, &stats.DummyStats{})
+	if err != nil {
+		return nil, err
+	}
+	return &shadowReader{
+		conf:     c,
+		db:       shadowDB,
+		inFlight: make(chan struct{}, c.MaxInFlight),
+		stats:    s,
+	}, nil
+}
+
+// answerConfig returns the part of c shaping answers from the DB, which a
+// shadow DB resolves queries with
+func (c HandlerConfig) answerConfig() HandlerConfig {
+	return HandlerConfig{
+		AlwaysCompress:    c.AlwaysCompress,
+		CNAMEChasing:      c.CNAMEChasing,
+		MaxCNAMEHops:      c.MaxCNAMEHops,
+		MinimalResponses:  c.MinimalResponses,
+		PreserveQNameCase: c.PreserveQNameCase,
+		ResolverPrivacy:   c.ResolverPrivacy,
+		AnswerOrder:       c.AnswerOrder,
+		MaxUDPSize:        c.MaxUDPSize,
+		AnswerBudget:      c.AnswerBudget,
+		QuestionCount:     c.QuestionCount,
+		DBReadTimeout:     c.DBReadTimeout,
+		AnswerSeed:        c.AnswerSeed,
+		MapNamespaces:     c.MapNamespaces,
+		TTLClamp:          c.TTLClamp,
+	}
+}
+
+// shadowSkipKey is the context key of the flag set when the primary answer
+// comes from a server policy rather than from the DB, e.g. a zone quota,
+// which the shadow DB doesn't apply
+type shadowSkipKey struct{}
+
+// withShadowSkip returns ctx carrying a flag skipShadow sets
+func withShadowSkip(ctx context.Context) (context.Context, *bool) {
+	skip := new(bool)
+	return context.WithValue(ctx, shadowSkipKey{}, skip), skip
+}
+
+// skipShadow marks the query of ctx as not to be compared with the shadow DB
+func skipShadow(ctx context.Context) {
+	if skip, ok := ctx.Value(shadowSkipKey{}).(*bool); ok {
+		*skip = true
+	}
+}
+
+// sample returns true if the next query should be shadowed
+func (s *shadowReader) sample() bool {
+	return s.conf.SampleRate > 0 && rand.Float64() < s.conf.SampleRate
+}
+
+// compare resolves req against the shadow DB in the background, and compares
+// the answer with primary. Nothing is done if too many reads are in flight.
+// req must not be used by the caller afterwards.
+func (s *shadowReader) compare(ctx context.Context, w dns.ResponseWriter, req *dns.Msg, primary *dns.Msg) {
+	select {
+	case s.inFlight <- struct{}{}:
+	default:
+		s.stats.IncrementCounter("DNS_shadow.dropped")
+		return
+	}
+	shadowCtx := context.Background()
+	if b, ok := GetAnswerBudget(ctx); ok {
+		shadowCtx = WithAnswerBudget(shadowCtx, b)
+	}
+	sw := &shadowWriter{local: w.LocalAddr(), remote: w.RemoteAddr()}
+	go func() {
+		defer func() { <-s.inFlight }()
+		s.stats.IncrementCounter("DNS_shadow.queries")
+		if _, err := s.db.ServeDNSWithRCODE(shadowCtx, sw, req); err != nil || sw.msg == nil {
+			s.stats.IncrementCounter("DNS_shadow.error")
+			return
+		}
+		mismatch := diffResponses(primary, sw.msg)
+		if mismatch == "" {
+			s.stats.IncrementCounter("DNS_shadow.match")
+			return
+		}
+		s.stats.IncrementCounter("DNS_shadow.mismatch")
+		s.stats.IncrementCounter("DNS_shadow.mismatch." + mismatch)
+		if s.conf.LogSampleRate > 0 && rand.Float64() < s.conf.LogSampleRate {
+			glog.Infof("Shadow read %s mismatch for %s %s from %s: primary %s [%s], shadow %s [%s]",
+				mismatch, req.Question[0].Name, dns.TypeToString[req.Question[0].Qtype], sw.remote,
+				dns.RcodeToString[primary.Rcode], strings.Join(answerStrings(primary), "; "),
+				dns.RcodeToString[sw.msg.Rcode], strings.Join(answerStrings(sw.msg), "; "))
+		}
+	}()
+}
+
+// load loads the shadow DB
+func (s *shadowReader) load() error {
+	return s.db.Load()
+}
+
+// reload catches up with the changes of the shadow DB
+func (s *shadowReader) reload() error {
+	return s.db.Reload(*NewPartialReloadSignal())
+}
+
+// close closes the shadow DB, once in-flight reads are done
+func (s *shadowReader) close() {
+	for i := 0; i < cap(s.inFlight); i++ {
+		s.inFlight <- struct{}{}
+	}
+	s.db.Close()
+}
+
+// answerStrings returns the sorted text form of the answer section of m
+func answerStrings(m *dns.Msg) []string {
+	answers := make([]string, 0, len(m.Answer))
+	for _, rr := range m.Answer {
+		answers = append(answers, rr.String())
+	}
+	sort.Strings(answers)
+	return answers
+}
+
+// diffResponses returns the kind of difference between the primary and the
+// shadow responses, or an empty string if they match. Answers are compared
+// regardless of their order.
+func diffResponses(primary, shadow *dns.Msg) string {
+	if primary.Rcode != shadow.Rcode {
+		return shadowMismatchRcode
+	}
+	a, b := answerStrings(primary), answerStrings(shadow)
+	if len(a) != len(b) {
+		return shadowMismatchAnswer
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return shadowMismatchAnswer
+		}
+	}
+	return ""
+}
+
+// shadowWriter is a dns.ResponseWriter keeping the response of a shadow read
+type shadowWriter struct {
+	local  net.Addr
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+// LocalAddr returns the local address of the original query.
+func (w *shadowWriter) LocalAddr() net.Addr { return w.local }
+
+// RemoteAddr returns the remote address of the original query.
+func (w *shadowWriter) RemoteAddr() net.Addr { return w.remote }
+
+// WriteMsg keeps the response.
+func (w *shadowWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+// Write keeps the response, if it can be unpacked.
+func (w *shadowWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.msg = m
+	return len(b), nil
+}
+
+// Close does nothing.
+func (w *shadowWriter) Close() error { return nil }
+
+// TsigStatus returns nil.
+func (w *shadowWriter) TsigStatus() error { return nil }
+
+// TsigTimersOnly does nothing.
+func (w *shadowWriter) TsigTimersOnly(bool) {}
+
+// Hijack does nothing.
+func (w *shadowWriter) Hijack() {}
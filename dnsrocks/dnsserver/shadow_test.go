@@ -0,0 +1,238 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+// syncCounters are Counters safe for concurrent use by shadow reads
+type syncCounters struct {
+	stats.Counters
+	sync.Mutex
+}
+
+func (s *syncCounters) IncrementCounter(key string) {
+	s.Lock()
+	defer s.Unlock()
+	s.Counters.IncrementCounter(key)
+}
+
+func (s *syncCounters) ResetCounterTo(key string, value int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.Counters.ResetCounterTo(key, value)
+}
+
+func (s *syncCounters) ResetCounter(key string) {
+	s.Lock()
+	defer s.Unlock()
+	s.Counters.ResetCounter(key)
+}
+
+func (s *syncCounters) Snapshot() map[string]int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.Counters.Snapshot()
+}
+
+func (s *syncCounters) get(key string) int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.Counters[key]
+}
+
+func openShadowDbForTesting(t *testing.T, primary, shadow *testaid.TestDB, ctr stats.Stats) *FBDNSDB {
+	handlerConfig := HandlerConfig{
+		Shadow: ShadowConfig{
+			DB:         DBConfig{Path: shadow.Path, Driver: shadow.Driver},
+			SampleRate: 1,
+		},
+	}
+	dbConfig := DBConfig{Path: primary.Path, Driver: primary.Driver}
+	th, err := NewFBDNSDBBasic(handlerConfig, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	return th
+}
+
+func TestShadowConfigValidation(t *testing.T) {
+	db := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+	for _, c := range []ShadowConfig{
+		{DB: db, SampleRate: -0.1},
+		{DB: db, SampleRate: 1.1},
+		{DB: db, LogSampleRate: 2},
+		{DB: db, MaxInFlight: -1},
+	} {
+		_, err := newShadowReader(c, HandlerConfig{}, &stats.DummyStats{})
+		require.Error(t, err, "%+v", c)
+	}
+	s, err := newShadowReader(ShadowConfig{SampleRate: 1}, HandlerConfig{}, &stats.DummyStats{})
+	require.NoError(t, err)
+	require.Nil(t, s)
+}
+
+func TestShadowReads(t *testing.T) {
+	testaid.RequireRocksDB(t)
+	ctr := &syncCounters{Counters: stats.NewCounters()}
+	th := openShadowDbForTesting(t, &testaid.TestCDB, &testaid.TestRDB, ctr)
+	require.NotNil(t, th.shadow)
+
+	questions := []struct {
+		qname string
+		qtype uint16
+	}{
+		{"www.example.com.", dns.TypeA},
+		{"www.example.com.", dns.TypeAAAA},
+		{"nonexistent.example.com.", dns.TypeA},
+		{"example.com.", dns.TypeNS},
+	}
+	for _, q := range questions {
+		req := new(dns.Msg)
+		req.SetQuestion(q.qname, q.qtype)
+		_, err := th.ServeDNS(CreateTestContext(1), &test.ResponseWriter{}, req)
+		require.NoError(t, err)
+	}
+	// closing waits for in-flight shadow reads
+	th.Close()
+
+	// both DBs are built from the same data
+	require.Equal(t, int64(len(questions)), ctr.get("DNS_shadow.queries"))
+	require.Equal(t, int64(len(questions)), ctr.get("DNS_shadow.match"))
+	require.Zero(t, ctr.get("DNS_shadow.mismatch"))
+	require.Zero(t, ctr.get("DNS_shadow.error"))
+}
+
+// TestShadowAnswerConfig checks that the shadow DB only gets the answer
+// shaping config
+func TestShadowAnswerConfig(t *testing.T) {
+	handlerConfig := HandlerConfig{
+		Shadow: ShadowConfig{
+			DB:         DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver},
+			SampleRate: 1,
+		},
+		MinimalResponses: true,
+		ZoneQuotas:       []ZoneQuota{{Zones: []string{"example.com"}, QPS: 0.001, Burst: 1}},
+		QueryLog:         QueryLogConfig{Size: 16},
+	}
+	dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+	th, err := NewFBDNSDBBasic(handlerConfig, dbConfig, CacheConfig{}, &DummyLogger{}, stats.NewCounters())
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+	require.NotNil(t, th.shadow)
+	require.True(t, th.shadow.db.handlerConfig.MinimalResponses)
+	require.Nil(t, th.shadow.db.shadow)
+	require.Nil(t, th.shadow.db.quotas)
+	require.Nil(t, th.shadow.db.queryLog)
+}
+
+// TestShadowSkipsQuotas checks that queries refused by zone quotas are not
+// compared with the shadow DB, which doesn't apply them
+func TestShadowSkipsQuotas(t *testing.T) {
+	ctr := &syncCounters{Counters: stats.NewCounters()}
+	handlerConfig := HandlerConfig{
+		Shadow: ShadowConfig{
+			DB:         DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver},
+			SampleRate: 1,
+		},
+		ZoneQuotas: []ZoneQuota{{Zones: []string{"example.com"}, QPS: 0.001, Burst: 1}},
+	}
+	dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+	th, err := NewFBDNSDBBasic(handlerConfig, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+
+	for range 3 {
+		req := new(dns.Msg)
+		req.SetQuestion("www.example.com.", dns.TypeA)
+		_, err := th.ServeDNS(CreateTestContext(1), &test.ResponseWriter{}, req)
+		require.NoError(t, err)
+	}
+	th.Close()
+
+	// only the first query is under quota
+	require.Equal(t, int64(1), ctr.get("DNS_shadow.queries"))
+	require.Equal(t, int64(1), ctr.get("DNS_shadow.match"))
+	require.Equal(t, int64(2), ctr.get("DNS_shadow.skipped"))
+	require.Zero(t, ctr.get("DNS_shadow.mismatch"))
+	require.Zero(t, ctr.get("DNS_shadow.error"))
+}
+
+func TestShadowSharesMemoryBudget(t *testing.T) {
+	testaid.RequireRocksDB(t)
+	handlerConfig := HandlerConfig{
+		Shadow: ShadowConfig{
+			DB:         DBConfig{Path: testaid.TestRDBV2.Path, Driver: testaid.TestRDBV2.Driver},
+			SampleRate: 1,
+		},
+	}
+	dbConfig := DBConfig{Path: testaid.TestRDB.Path, Driver: testaid.TestRDB.Driver, MemoryBudgetMB: 8, WriteBufferBudgetMB: 16}
+	_, err := NewFBDNSDBBasic(handlerConfig, dbConfig, CacheConfig{}, &DummyLogger{}, &stats.DummyStats{})
+	require.Error(t, err, "write buffer budget larger than the memory budget")
+
+	dbConfig.WriteBufferBudgetMB = 2
+	th, err := NewFBDNSDBBasic(handlerConfig, dbConfig, CacheConfig{}, &DummyLogger{}, &stats.DummyStats{})
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+	require.NotNil(t, th.memoryBudget)
+	require.NotNil(t, th.shadow)
+	require.Same(t, th.memoryBudget, th.shadow.db.dbConfig.memoryBudget)
+	require.Nil(t, th.shadow.db.memoryBudget, "the shadow DB must not free the shared budget")
+}
+
+func TestShadowBadDB(t *testing.T) {
+	ctr := &syncCounters{Counters: stats.NewCounters()}
+	th := openShadowDbForTesting(t, &testaid.TestCDB, &testaid.TestDB{Driver: "cdb", Path: "/nonexistent"}, ctr)
+	defer th.Close()
+	require.Nil(t, th.shadow)
+	require.Equal(t, int64(1), ctr.get("DNS_shadow.load_error"))
+
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeA)
+	rcode, err := th.ServeDNS(CreateTestContext(1), &test.ResponseWriter{}, req)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, rcode)
+}
+
+func TestDiffResponses(t *testing.T) {
+	a1 := test.A("example.com. 60 IN A 192.0.2.1")
+	a2 := test.A("example.com. 60 IN A 192.0.2.2")
+
+	primary := new(dns.Msg)
+	primary.Answer = []dns.RR{a1, a2}
+	shadow := new(dns.Msg)
+	shadow.Answer = []dns.RR{a2, a1}
+	require.Equal(t, "", diffResponses(primary, shadow))
+
+	shadow.Answer = []dns.RR{a1}
+	require.Equal(t, shadowMismatchAnswer, diffResponses(primary, shadow))
+
+	shadow.Answer = []dns.RR{a1, test.A("example.com. 30 IN A 192.0.2.2")}
+	require.Equal(t, shadowMismatchAnswer, diffResponses(primary, shadow))
+
+	shadow.Answer = primary.Answer
+	shadow.Rcode = dns.RcodeNameError
+	require.Equal(t, shadowMismatchRcode, diffResponses(primary, shadow))
+}
@@ -44,3 +44,12 @@ func (s Counters) IncrementCounter(key string) {
 // AddSample is not implemented here
 func (s Counters) AddSample(_ string, _ int64) {
 }
+
+// Snapshot returns a copy of the counters
+func (s Counters) Snapshot() map[string]int64 {
+	ret := make(map[string]int64, len(s))
+	for key, val := range s {
+		ret[key] = val
+	}
+	return ret
+}
@@ -20,6 +20,8 @@ type Stats interface {
 	IncrementCounterBy(key string, value int64)
 	IncrementCounter(key string)
 	AddSample(key string, value int64)
+	// Snapshot returns a consistent point-in-time copy of all counters
+	Snapshot() map[string]int64
 }
 
 // DummyStats is a stub stats implementation
@@ -40,3 +42,8 @@ func (s *DummyStats) IncrementCounter(_ string) {}
 
 // AddSample stub implementation
 func (s *DummyStats) AddSample(_ string, _ int64) {}
+
+// Snapshot stub implementation
+func (s *DummyStats) Snapshot() map[string]int64 {
+	return map[string]int64{}
+}
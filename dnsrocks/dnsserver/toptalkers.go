@@ -0,0 +1,282 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"container/heap"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Top talkers defaults
+const (
+	DefaultTopTalkersWindow        = time.Minute
+	DefaultTopTalkersIPv4PrefixLen = 24
+	DefaultTopTalkersIPv6PrefixLen = 48
+	// topTalkersBuckets is the number of sketches a window is split into, so
+	// that it slides by a fraction of its length
+	topTalkersBuckets = 6
+)
+
+// TopTalkersConfig configures the tracking of the resolver subnets and query
+// names sending the most queries, served by the /toptalkers endpoint of the
+// debug HTTP server.
+type TopTalkersConfig struct {
+	// Size is the number of subnets and names tracked over each fraction
+	// of the window. Tracking is disabled if 0.
+	Size int
+	// Window is the period counts are reported over,
+	// DefaultTopTalkersWindow if 0
+	Window time.Duration
+	// IPv4PrefixLen and IPv6PrefixLen are the lengths of the subnets
+	// resolvers are grouped by, DefaultTopTalkersIPv4PrefixLen and
+	// DefaultTopTalkersIPv6PrefixLen if 0
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+}
+
+// TopTalker is a subnet or name with the number of queries it sent. The
+// count may be overestimated by up to Error.
+type TopTalker struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error,omitempty"`
+}
+
+// TopTalkersReport is the response of /toptalkers
+type TopTalkersReport struct {
+	Window  string      `json:"window"`
+	Subnets []TopTalker `json:"subnets"`
+	Names   []TopTalker `json:"names"`
+}
+
+// sketchEntry is a key tracked by a sketch
+type sketchEntry struct {
+	key   string
+	count uint64
+	err   uint64
+	index int
+}
+
+// sketch is a space-saving heavy hitters sketch: it counts up to size keys
+// exactly, and a new key replaces the least counted one, inheriting its
+// count as error. It is a min-heap of its entries.
+type sketch struct {
+	size    int
+	entries []*sketchEntry
+	keys    map[string]*sketchEntry
+}
+
+func newSketch(size int) *sketch {
+	return &sketch{size: size, keys: make(map[string]*sketchEntry, size)}
+}
+
+func (s *sketch) Len() int           { return len(s.entries) }
+func (s *sketch) Less(i, j int) bool { return s.entries[i].count < s.entries[j].count }
+func (s *sketch) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+	s.entries[i].index = i
+	s.entries[j].index = j
+}
+
+func (s *sketch) Push(x interface{}) {
+	e := x.(*sketchEntry)
+	e.index = len(s.entries)
+	s.entries = append(s.entries, e)
+}
+
+func (s *sketch) Pop() interface{} {
+	e := s.entries[len(s.entries)-1]
+	s.entries = s.entries[:len(s.entries)-1]
+	return e
+}
+
+// add counts a query for key
+func (s *sketch) add(key string) {
+	if e, ok := s.keys[key]; ok {
+		e.count++
+		heap.Fix(s, e.index)
+		return
+	}
+	if len(s.entries) < s.size {
+		e := &sketchEntry{key: key, count: 1}
+		s.keys[key] = e
+		heap.Push(s, e)
+		return
+	}
+	e := s.entries[0]
+	delete(s.keys, e.key)
+	e.key, e.err = key, e.count
+	e.count++
+	s.keys[key] = e
+	heap.Fix(s, 0)
+}
+
+// reset forgets all keys
+func (s *sketch) reset() {
+	s.entries = s.entries[:0]
+	s.keys = make(map[string]*sketchEntry, s.size)
+}
+
+// windowedSketch is a sketch over a sliding window, made of one sketch per
+// fraction of it
+type windowedSketch struct {
+	buckets []*sketch
+	// cur is the bucket counting queries since start
+	cur   int
+	start time.Time
+	span  time.Duration
+}
+
+func newWindowedSketch(size int, window time.Duration, now time.Time) *windowedSketch {
+	w := &windowedSketch{
+		buckets: make([]*sketch, topTalkersBuckets),
+		start:   now,
+		span:    window / topTalkersBuckets,
+	}
+	for i := range w.buckets {
+		w.buckets[i] = newSketch(size)
+	}
+	return w
+}
+
+// rotate resets the buckets which fell out of the window at now
+func (w *windowedSketch) rotate(now time.Time) {
+	elapsed := int(now.Sub(w.start) / w.span)
+	if elapsed <= 0 {
+		return
+	}
+	for i := 0; i < elapsed && i < len(w.buckets); i++ {
+		w.cur = (w.cur + 1) % len(w.buckets)
+		w.buckets[w.cur].reset()
+	}
+	w.start = w.start.Add(time.Duration(elapsed) * w.span)
+}
+
+func (w *windowedSketch) add(key string, now time.Time) {
+	w.rotate(now)
+	w.buckets[w.cur].add(key)
+}
+
+// top returns the n keys counted the most over the window at now
+func (w *windowedSketch) top(n int, now time.Time) []TopTalker {
+	w.rotate(now)
+	merged := make(map[string]*TopTalker)
+	for _, b := range w.buckets {
+		for _, e := range b.entries {
+			t, ok := merged[e.key]
+			if !ok {
+				t = &TopTalker{Key: e.key}
+				merged[e.key] = t
+			}
+			t.Count += e.count
+			t.Error += e.err
+		}
+	}
+	top := make([]TopTalker, 0, len(merged))
+	for _, t := range merged {
+		top = append(top, *t)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Key < top[j].Key
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// topTalkers tracks the resolver subnets and query names sending the most
+// queries
+type topTalkers struct {
+	mu      sync.Mutex
+	window  time.Duration
+	v4Mask  net.IPMask
+	v6Mask  net.IPMask
+	subnets *windowedSketch
+	names   *windowedSketch
+	now     func() time.Time
+}
+
+// newTopTalkers validates c and returns the matching topTalkers, or nil when
+// tracking is disabled
+func newTopTalkers(c TopTalkersConfig) (*topTalkers, error) {
+	if c.Size == 0 {
+		return nil, nil
+	}
+	if c.Window == 0 {
+		c.Window = DefaultTopTalkersWindow
+	}
+	if c.IPv4PrefixLen == 0 {
+		c.IPv4PrefixLen = DefaultTopTalkersIPv4PrefixLen
+	}
+	if c.IPv6PrefixLen == 0 {
+		c.IPv6PrefixLen = DefaultTopTalkersIPv6PrefixLen
+	}
+	if c.Size < 0 || c.Window < topTalkersBuckets || c.IPv4PrefixLen < 0 || c.IPv4PrefixLen > 32 || c.IPv6PrefixLen < 0 || c.IPv6PrefixLen > 128 {
+		return nil, fmt.Errorf("invalid top talkers config %+v", c)
+	}
+	now := time.Now()
+	return &topTalkers{
+		window:  c.Window,
+		v4Mask:  net.CIDRMask(c.IPv4PrefixLen, 8*net.IPv4len),
+		v6Mask:  net.CIDRMask(c.IPv6PrefixLen, 8*net.IPv6len),
+		subnets: newWindowedSketch(c.Size, c.Window, now),
+		names:   newWindowedSketch(c.Size, c.Window, now),
+		now:     time.Now,
+	}, nil
+}
+
+// subnet returns the subnet resolverIP is grouped by
+func (t *topTalkers) subnet(resolverIP string) string {
+	ip := net.ParseIP(resolverIP)
+	if ip == nil {
+		return resolverIP
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(t.v4Mask), Mask: t.v4Mask}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(t.v6Mask), Mask: t.v6Mask}).String()
+}
+
+// add counts a query for qname sent by resolverIP
+func (t *topTalkers) add(resolverIP, qname string) {
+	subnet := t.subnet(resolverIP)
+	qname = strings.ToLower(qname)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.subnets.add(subnet, now)
+	t.names.add(qname, now)
+}
+
+// report returns the n subnets and names which sent the most queries over
+// the window, all the tracked ones if n is 0
+func (t *topTalkers) report(n int) TopTalkersReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	return TopTalkersReport{
+		Window:  t.window.String(),
+		Subnets: t.subnets.top(n, now),
+		Names:   t.names.top(n, now),
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestNewTopTalkers(t *testing.T) {
+	tt, err := newTopTalkers(TopTalkersConfig{})
+	require.NoError(t, err)
+	require.Nil(t, tt)
+
+	for _, c := range []TopTalkersConfig{
+		{Size: -1},
+		{Size: 10, Window: time.Nanosecond},
+		{Size: 10, IPv4PrefixLen: 33},
+		{Size: 10, IPv6PrefixLen: -1},
+	} {
+		_, err := newTopTalkers(c)
+		require.Error(t, err, "%+v", c)
+	}
+	_, err = NewFBDNSDBBasic(HandlerConfig{TopTalkers: TopTalkersConfig{Size: -1}}, DBConfig{}, CacheConfig{}, &DummyLogger{}, &stats.DummyStats{})
+	require.Error(t, err)
+
+	tt, err = newTopTalkers(TopTalkersConfig{Size: 10})
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.0/24", tt.subnet("192.0.2.53"))
+	require.Equal(t, "2001:db8::/48", tt.subnet("2001:db8::53"))
+	require.Equal(t, "bogus", tt.subnet("bogus"))
+}
+
+// TestSketch checks that heavy hitters are counted exactly once tracked, and
+// that new keys inherit the count of the keys they evict as error
+func TestSketch(t *testing.T) {
+	s := newSketch(2)
+	for i := 0; i < 5; i++ {
+		s.add("a")
+	}
+	s.add("b")
+	s.add("c")
+	s.add("a")
+	w := &windowedSketch{buckets: []*sketch{s}, span: time.Hour}
+	require.Equal(t, []TopTalker{
+		{Key: "a", Count: 6},
+		{Key: "c", Count: 2, Error: 1},
+	}, w.top(0, w.start))
+	require.Equal(t, []TopTalker{{Key: "a", Count: 6}}, w.top(1, w.start))
+}
+
+// TestTopTalkersWindow checks that counts slide out of the window
+func TestTopTalkersWindow(t *testing.T) {
+	tt, err := newTopTalkers(TopTalkersConfig{Size: 10, Window: 6 * time.Second})
+	require.NoError(t, err)
+	now := time.Now()
+	tt.subnets = newWindowedSketch(10, 6*time.Second, now)
+	tt.names = newWindowedSketch(10, 6*time.Second, now)
+	tt.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		tt.add("192.0.2.1", "WWW.example.com.")
+	}
+	now = now.Add(3 * time.Second)
+	tt.add("192.0.2.2", "foo.example.com.")
+	tt.add("2001:db8::1", "foo.example.com.")
+	require.Equal(t, TopTalkersReport{
+		Window: "6s",
+		Subnets: []TopTalker{
+			{Key: "192.0.2.0/24", Count: 4},
+			{Key: "2001:db8::/48", Count: 1},
+		},
+		Names: []TopTalker{
+			{Key: "www.example.com.", Count: 3},
+			{Key: "foo.example.com.", Count: 2},
+		},
+	}, tt.report(0))
+
+	// the first queries are now out of the window
+	now = now.Add(4 * time.Second)
+	report := tt.report(1)
+	require.Equal(t, []TopTalker{{Key: "foo.example.com.", Count: 2}}, report.Names)
+
+	now = now.Add(time.Hour)
+	report = tt.report(0)
+	require.Empty(t, report.Subnets)
+	require.Empty(t, report.Names)
+}
+
+func TestDebugServerTopTalkers(t *testing.T) {
+	dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+	th, err := NewFBDNSDBBasic(HandlerConfig{TopTalkers: TopTalkersConfig{Size: 10}}, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, &stats.DummyStats{})
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+	s, err := NewDebugServer(th, DebugConfig{Addr: "localhost:0"})
+	require.NoError(t, err)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	for i, qname := range []string{"foo.example.org.", "foo.example.org.", "www.example.com."} {
+		req := new(dns.Msg)
+		req.SetQuestion(qname, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: fmt.Sprintf("1.1.1.%d", i+1)})
+		_, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+		require.NoError(t, err)
+	}
+
+	var report TopTalkersReport
+	require.Equal(t, http.StatusOK, getJSON(t, ts.URL+"/toptalkers?n=1", &report))
+	require.Equal(t, TopTalkersReport{
+		Window:  "1m0s",
+		Subnets: []TopTalker{{Key: "1.1.1.0/24", Count: 3}},
+		Names:   []TopTalker{{Key: "foo.example.org.", Count: 2}},
+	}, report)
+	require.Equal(t, http.StatusBadRequest, getJSON(t, ts.URL+"/toptalkers?n=x", &report))
+
+	th.topTalkers = nil
+	require.Equal(t, http.StatusNotFound, getJSON(t, ts.URL+"/toptalkers", &report))
+}
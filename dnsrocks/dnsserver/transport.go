@@ -0,0 +1,112 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// Transport is the protocol a query was received over
+type Transport string
+
+// Transports
+const (
+	TransportUDP Transport = "udp"
+	TransportTCP Transport = "tcp"
+	TransportDoT Transport = "dot"
+	TransportDoH Transport = "doh"
+	TransportDoQ Transport = "doq"
+)
+
+// encrypted returns true if queries over t are encrypted
+func (t Transport) encrypted() bool {
+	switch t {
+	case TransportDoT, TransportDoH, TransportDoQ:
+		return true
+	}
+	return false
+}
+
+type clientInfoKey struct{}
+
+// ClientInfo describes how a query reached the server
+type ClientInfo struct {
+	Transport Transport
+	// ServerName is the TLS SNI sent by the client, if any
+	ServerName string
+	// ALPN is the application protocol negotiated over TLS, if any
+	ALPN string
+	// Listener identifies the listener the query was received on, e.g. its
+	// local address
+	Listener string
+}
+
+// NewClientInfo returns the ClientInfo of a query answered through w. Queries
+// over TLS are assumed to be DoT, other transports have to be set by their
+// servers.
+func NewClientInfo(w dns.ResponseWriter) ClientInfo {
+	var info ClientInfo
+	if addr := w.LocalAddr(); addr != nil {
+		info.Listener = addr.String()
+	}
+	if cs, ok := w.(dns.ConnectionStater); ok {
+		if state := cs.ConnectionState(); state != nil {
+			info.Transport = TransportDoT
+			info.ServerName = state.ServerName
+			info.ALPN = state.NegotiatedProtocol
+			return info
+		}
+	}
+	state := request.Request{W: w}
+	info.Transport = Transport(state.Proto())
+	return info
+}
+
+// WithClientInfo makes queries served with ctx report info to loggers and stats
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+// GetClientInfo is used to get the ClientInfo from context
+func GetClientInfo(ctx context.Context) (ClientInfo, bool) {
+	info, ok := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return info, ok
+}
+
+// ClientInfoOf returns the ClientInfo of the query of state, for loggers
+func ClientInfoOf(state request.Request) (ClientInfo, bool) {
+	if w, ok := state.W.(*clientInfoWriter); ok {
+		return w.info, true
+	}
+	return ClientInfo{}, false
+}
+
+// clientInfoWriter is a dns.ResponseWriter carrying the ClientInfo of the
+// query to loggers
+type clientInfoWriter struct {
+	dns.ResponseWriter
+	info ClientInfo
+}
+
+// ConnectionState forwards the TLS state of the underlying writer, if any.
+func (w *clientInfoWriter) ConnectionState() *tls.ConnectionState {
+	if cs, ok := w.ResponseWriter.(dns.ConnectionStater); ok {
+		return cs.ConnectionState()
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+// tlsResponseWriter is a test ResponseWriter over TLS
+type tlsResponseWriter struct {
+	test.ResponseWriter
+	state tls.ConnectionState
+}
+
+func (w *tlsResponseWriter) ConnectionState() *tls.ConnectionState {
+	return &w.state
+}
+
+func TestNewClientInfo(t *testing.T) {
+	udp := &test.ResponseWriter{}
+	require.Equal(t, ClientInfo{Transport: TransportUDP, Listener: udp.LocalAddr().String()}, NewClientInfo(udp))
+
+	tcp := &test.ResponseWriter{}
+	tcp.TCP = true
+	require.Equal(t, TransportTCP, NewClientInfo(tcp).Transport)
+
+	dot := &tlsResponseWriter{state: tls.ConnectionState{ServerName: "dns.example.com", NegotiatedProtocol: "dot"}}
+	dot.TCP = true
+	require.Equal(t, ClientInfo{Transport: TransportDoT, ServerName: "dns.example.com", ALPN: "dot", Listener: dot.LocalAddr().String()}, NewClientInfo(dot))
+}
+
+// TestClientInfo checks that the handler reports the ClientInfo of the context
+// to loggers and stats
+func TestClientInfo(t *testing.T) {
+	var logged bytes.Buffer
+	ctr := stats.NewCounters()
+	dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+	th, err := NewFBDNSDBBasic(HandlerConfig{}, dbConfig, CacheConfig{}, &TextLogger{IoWriter: &logged}, ctr)
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeA)
+	ctx := WithMaxAnswer(context.Background(), 1)
+	_, err = th.ServeDNSWithRCODE(ctx, dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	require.NoError(t, err)
+	require.Contains(t, logged.String(), "] UDP www.example.com. A")
+
+	logged.Reset()
+	w := &tlsResponseWriter{state: tls.ConnectionState{ServerName: "dns.example.com"}}
+	w.TCP = true
+	info := NewClientInfo(w)
+	rcode, err := th.ServeDNSWithRCODE(WithClientInfo(ctx, info), dnstest.NewRecorder(w), req)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, rcode)
+	require.Contains(t, logged.String(), "] DOT www.example.com. A")
+	require.Equal(t, int64(1), ctr["DNS_queries.transport.dot"])
+
+	got, ok := GetClientInfo(WithClientInfo(ctx, info))
+	require.True(t, ok)
+	require.Equal(t, info, got)
+	_, ok = GetClientInfo(ctx)
+	require.False(t, ok)
+}
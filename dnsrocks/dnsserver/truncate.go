@@ -0,0 +1,126 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// isReferral returns true if resp delegates to a child zone, in which case
+// glue records in the additional section are required.
+func isReferral(resp *dns.Msg) bool {
+	return !resp.Authoritative && len(resp.Answer) == 0 && len(resp.Ns) > 0
+}
+
+// trimAdditional drops optional additional records, last first, until resp
+// fits in size bytes once compressed. Unlike dns.Msg.Truncate, dropping them
+// doesn't set the TC bit: per RFC 2181 section 9 it is only needed when
+// required records don't fit. Referrals, whose glue is required, and signed
+// responses are left to dns.Msg.Truncate. It returns the number of dropped
+// records.
+func trimAdditional(resp *dns.Msg, size int) int {
+	if size < dns.MinMsgSize {
+		size = dns.MinMsgSize
+	}
+	if isReferral(resp) || resp.IsTsig() != nil {
+		return 0
+	}
+	compress := resp.Compress
+	resp.Compress = true
+	defer func() { resp.Compress = compress }()
+
+	dropped := 0
+	for resp.Len() > size {
+		// the OPT record is kept, wherever it is
+		last := -1
+		for i := len(resp.Extra) - 1; i >= 0; i-- {
+			if resp.Extra[i].Header().Rrtype != dns.TypeOPT {
+				last = i
+				break
+			}
+		}
+		if last < 0 {
+			break
+		}
+		resp.Extra = append(resp.Extra[:last], resp.Extra[last+1:]...)
+		dropped++
+	}
+	return dropped
+}
+
+// validateMaxUDPSize checks the EDNS0 UDP buffer size clamp, 0 disables it
+func validateMaxUDPSize(size int) error {
+	if size != 0 && (size < dns.MinMsgSize || size > dns.MaxMsgSize) {
+		return fmt.Errorf("invalid max UDP size %d, must be between %d and %d", size, dns.MinMsgSize, dns.MaxMsgSize)
+	}
+	return nil
+}
+
+// clampOPT returns r with its EDNS0 UDP buffer size lowered to maxSize, if it
+// is larger, and whether it was. r itself is left untouched.
+func clampOPT(r *dns.Msg, maxSize int) (*dns.Msg, bool) {
+	o := r.IsEdns0()
+	if o == nil || int(o.UDPSize()) <= maxSize {
+		return r, false
+	}
+	clampedOPT := *o
+	clampedOPT.SetUDPSize(uint16(maxSize))
+	req := *r
+	req.Extra = make([]dns.RR, len(r.Extra))
+	for i, rr := range r.Extra {
+		if rr == o {
+			rr = &clampedOPT
+		}
+		req.Extra[i] = rr
+	}
+	return &req, true
+}
+
+// clampUDPSize returns state with the EDNS0 UDP buffer size of its request
+// lowered to maxSize, if it is larger and the query came over UDP, and whether
+// it was. Responses written through the returned state are truncated to that
+// size, which they also advertise. The request itself is left untouched.
+func clampUDPSize(state request.Request, maxSize int) (request.Request, bool) {
+	if maxSize == 0 || state.Proto() != "udp" {
+		return state, false
+	}
+	req, clamped := clampOPT(state.Req, maxSize)
+	return request.Request{W: state.W, Req: req}, clamped
+}
+
+type receivedRequestKey struct{}
+
+// ClampUDPSize returns r with its EDNS0 UDP buffer size lowered to maxSize, if
+// it is larger, like the coredns bufsize plugin, for all the handlers of a
+// chain to size their responses after it. When it is, the returned context
+// carries r for the DB handler to log the query as received and count the
+// responses affected. r itself is left untouched.
+func ClampUDPSize(ctx context.Context, r *dns.Msg, maxSize int) (context.Context, *dns.Msg) {
+	req, clamped := clampOPT(r, maxSize)
+	if !clamped {
+		return ctx, r
+	}
+	return context.WithValue(ctx, receivedRequestKey{}, r), req
+}
+
+// receivedRequest returns the query of ctx as received, if its EDNS0 UDP
+// buffer size was lowered by ClampUDPSize
+func receivedRequest(ctx context.Context) (*dns.Msg, bool) {
+	r, ok := ctx.Value(receivedRequestKey{}).(*dns.Msg)
+	return r, ok
+}
@@ -0,0 +1,228 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+// makeLargeResponse returns an authoritative response with 5 MX records, and
+// numExtra records and an OPT record in its additional section.
+func makeLargeResponse(numExtra int) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeMX)
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+	for i := 0; i < numExtra; i++ {
+		host := fmt.Sprintf("mx%d.example.com.", i)
+		if i < 5 {
+			resp.Answer = append(resp.Answer, test.MX(fmt.Sprintf("example.com. 300 IN MX %d %s", i, host)))
+		}
+		resp.Extra = append(resp.Extra, test.AAAA(fmt.Sprintf("%s 300 IN AAAA 2001:db8::%x", host, i)))
+	}
+	resp.SetEdns0(dns.MinMsgSize, false)
+	return resp
+}
+
+func TestTrimAdditional(t *testing.T) {
+	resp := makeLargeResponse(2)
+	require.Zero(t, trimAdditional(resp, dns.MinMsgSize))
+	require.Len(t, resp.Extra, 3)
+
+	resp = makeLargeResponse(20)
+	dropped := trimAdditional(resp, dns.MinMsgSize)
+	require.NotZero(t, dropped)
+	require.Len(t, resp.Answer, 5)
+	require.Len(t, resp.Extra, 21-dropped)
+	// the first additional records and the OPT record are kept
+	require.Equal(t, "mx0.example.com.", resp.Extra[0].Header().Name)
+	require.NotNil(t, resp.IsEdns0())
+	require.False(t, resp.Compress)
+
+	// it now fits, the TC bit isn't set
+	resp.Truncate(dns.MinMsgSize)
+	require.False(t, resp.Truncated)
+	require.Len(t, resp.Answer, 5)
+	require.Len(t, resp.Extra, 21-dropped)
+
+	// glue records of referrals are required
+	resp = makeLargeResponse(20)
+	resp.Authoritative = false
+	resp.Ns, resp.Answer = resp.Answer, nil
+	require.Zero(t, trimAdditional(resp, dns.MinMsgSize))
+	require.Len(t, resp.Extra, 21)
+}
+
+func TestMinimalResponses(t *testing.T) {
+	testCases := []struct {
+		qname   string
+		qtype   uint16
+		minimal bool
+		// number of additional records, without OPT
+		extra int
+	}{
+		{qname: "example.com.", qtype: dns.TypeNS, minimal: false, extra: 4},
+		{qname: "example.com.", qtype: dns.TypeNS, minimal: true, extra: 0},
+		// referrals keep their glue
+		{qname: "lotofns.example.org.", qtype: dns.TypeNS, minimal: true, extra: 32},
+	}
+	for _, db := range testaid.TestDBs {
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s/%s/%v", db.Driver, tc.qname, tc.minimal), func(t *testing.T) {
+				dbConfig := DBConfig{Path: db.Path, Driver: db.Driver}
+				th, err := NewFBDNSDBBasic(HandlerConfig{MinimalResponses: tc.minimal}, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, &stats.DummyStats{})
+				require.NoError(t, err)
+				require.NoError(t, th.Load())
+				defer th.Close()
+
+				req := new(dns.Msg)
+				req.SetQuestion(tc.qname, tc.qtype)
+				req.SetEdns0(4096, false)
+				rec := dnstest.NewRecorder(&test.ResponseWriter{})
+				code, err := th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+				require.NoError(t, err)
+				require.Equal(t, dns.RcodeSuccess, code)
+				require.NotNil(t, popEdns0(rec.Msg))
+				require.Len(t, rec.Msg.Extra, tc.extra)
+				require.False(t, rec.Msg.Truncated)
+			})
+		}
+	}
+}
+
+func TestClampUDPSize(t *testing.T) {
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeMX)
+	req.SetEdns0(4096, true)
+	state := request.Request{W: &test.ResponseWriter{}, Req: req}
+
+	clampedState, clamped := clampUDPSize(state, 1232)
+	require.True(t, clamped)
+	require.Equal(t, 1232, clampedState.Size())
+	require.True(t, clampedState.Do())
+	require.Equal(t, uint16(4096), req.IsEdns0().UDPSize())
+	require.Equal(t, 4096, state.Size())
+
+	for _, maxSize := range []int{0, 4096, 8192} {
+		_, clamped = clampUDPSize(state, maxSize)
+		require.False(t, clamped, maxSize)
+	}
+	_, clamped = clampUDPSize(request.Request{W: &test.ResponseWriter{TCP: true}, Req: req}, 1232)
+	require.False(t, clamped)
+	noEDNS := new(dns.Msg)
+	noEDNS.SetQuestion("example.com.", dns.TypeMX)
+	_, clamped = clampUDPSize(request.Request{W: &test.ResponseWriter{}, Req: noEDNS}, 1232)
+	require.False(t, clamped)
+}
+
+func TestValidateMaxUDPSize(t *testing.T) {
+	for _, size := range []int{0, 512, 1232, 65535} {
+		require.NoError(t, validateMaxUDPSize(size), size)
+	}
+	for _, size := range []int{-1, 511, 65536} {
+		require.Error(t, validateMaxUDPSize(size), size)
+	}
+}
+
+// sizeLogger records the EDNS0 UDP buffer size of the queries logged
+type sizeLogger struct {
+	DummyLogger
+	sizes []int
+}
+
+func (l *sizeLogger) Log(state request.Request, _ *dns.Msg, _ *dns.EDNS0_SUBNET, _ *db.Location) {
+	l.sizes = append(l.sizes, state.Size())
+}
+
+// TestClampUDPSizeContext checks that queries clamped in front of the handler
+// are answered after the clamped size, but logged with the one received
+func TestClampUDPSizeContext(t *testing.T) {
+	dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+	ctr := stats.NewCounters()
+	l := &sizeLogger{}
+	th, err := NewFBDNSDBBasic(HandlerConfig{}, dbConfig, CacheConfig{}, l, ctr)
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+
+	req := new(dns.Msg)
+	req.SetQuestion("lotofns.example.org.", dns.TypeNS)
+	req.SetEdns0(4096, false)
+	ctx, clampedReq := ClampUDPSize(CreateTestContext(1), req, dns.MinMsgSize)
+	require.Equal(t, uint16(4096), req.IsEdns0().UDPSize())
+	require.Equal(t, uint16(dns.MinMsgSize), clampedReq.IsEdns0().UDPSize())
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err = th.ServeDNSWithRCODE(ctx, rec, clampedReq)
+	require.NoError(t, err)
+	require.True(t, rec.Msg.Truncated)
+	require.LessOrEqual(t, rec.Msg.Len(), dns.MinMsgSize)
+	require.Equal(t, []int{4096}, l.sizes)
+	require.Equal(t, int64(1), ctr["DNS_response.udp_size_clamped"])
+	require.Equal(t, int64(1), ctr["DNS_response.udp_size_clamped.truncated"])
+
+	ctx, sameReq := ClampUDPSize(CreateTestContext(1), req, 8192)
+	require.Same(t, req, sameReq)
+	_, ok := receivedRequest(ctx)
+	require.False(t, ok)
+}
+
+func TestMaxUDPSize(t *testing.T) {
+	for _, db := range testaid.TestDBs {
+		t.Run(db.Driver+"/"+db.Flavour, func(t *testing.T) {
+			dbConfig := DBConfig{Path: db.Path, Driver: db.Driver}
+			ctr := stats.NewCounters()
+			th, err := NewFBDNSDBBasic(HandlerConfig{MaxUDPSize: dns.MinMsgSize}, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+			require.NoError(t, err)
+			require.NoError(t, th.Load())
+			defer th.Close()
+
+			// the referral fits in 4096 bytes, not in 512
+			req := new(dns.Msg)
+			req.SetQuestion("lotofns.example.org.", dns.TypeNS)
+			req.SetEdns0(4096, false)
+			rec := dnstest.NewRecorder(&test.ResponseWriter{})
+			_, err = th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+			require.NoError(t, err)
+			require.True(t, rec.Msg.Truncated)
+			require.Equal(t, uint16(dns.MinMsgSize), rec.Msg.IsEdns0().UDPSize())
+			require.LessOrEqual(t, rec.Msg.Len(), dns.MinMsgSize)
+			require.Equal(t, int64(1), ctr["DNS_response.udp_size_clamped"])
+			require.Equal(t, int64(1), ctr["DNS_response.udp_size_clamped.truncated"])
+
+			// TCP queries are not clamped
+			rec = dnstest.NewRecorder(&test.ResponseWriter{TCP: true})
+			_, err = th.ServeDNSWithRCODE(CreateTestContext(1), rec, req)
+			require.NoError(t, err)
+			require.False(t, rec.Msg.Truncated)
+			require.Equal(t, int64(1), ctr["DNS_response.udp_size_clamped"])
+		})
+	}
+
+	_, err := NewFBDNSDBBasic(HandlerConfig{MaxUDPSize: 100}, DBConfig{}, CacheConfig{}, &DummyLogger{}, &stats.DummyStats{})
+	require.Error(t, err)
+}
@@ -0,0 +1,158 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// TTLClampConfig controls the TTLs of the records of responses, raised to
+// Min and lowered to Max when responses are assembled, without changing the
+// data served, e.g. to shorten TTLs during incident mitigations.
+type TTLClampConfig struct {
+	// Min is the lowest TTL of records, 0 for none
+	Min uint32
+	// Max is the highest TTL of records, 0 for none
+	Max uint32
+}
+
+func (c TTLClampConfig) validate() error {
+	if c.Max > 0 && c.Min > c.Max {
+		return fmt.Errorf("invalid TTL clamp, min %d is above max %d", c.Min, c.Max)
+	}
+	return nil
+}
+
+// enabled tells whether c changes any TTL
+func (c TTLClampConfig) enabled() bool {
+	return c.Min > 0 || c.Max > 0
+}
+
+// String returns c as parsed by ParseTTLClamp
+func (c TTLClampConfig) String() string {
+	var fields []string
+	if c.Min > 0 {
+		fields = append(fields, fmt.Sprintf("min=%d", c.Min))
+	}
+	if c.Max > 0 {
+		fields = append(fields, fmt.Sprintf("max=%d", c.Max))
+	}
+	return strings.Join(fields, " ")
+}
+
+// ParseTTLClamp parses TTL bounds written as `[min=<ttl>] [max=<ttl>]`, e.g.
+// `min=30 max=86400`. An empty string disables clamping.
+func ParseTTLClamp(s string) (TTLClampConfig, error) {
+	var c TTLClampConfig
+	for _, f := range strings.Fields(s) {
+		key, value, found := strings.Cut(f, "=")
+		if !found {
+			return c, fmt.Errorf("invalid TTL clamp field %q, must be key=value", f)
+		}
+		ttl, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return c, fmt.Errorf("invalid TTL clamp %s %q: %w", key, value, err)
+		}
+		switch key {
+		case "min":
+			c.Min = uint32(ttl)
+		case "max":
+			c.Max = uint32(ttl)
+		default:
+			return c, fmt.Errorf("unknown TTL clamp field %q", key)
+		}
+	}
+	return c, c.validate()
+}
+
+// clamp clamps the TTLs of the records of m but OPT ones, and returns the
+// number of records whose TTL was raised and lowered
+func (c TTLClampConfig) clamp(m *dns.Msg) (raised, lowered int64) {
+	for _, rrs := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range rrs {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				continue
+			}
+			if hdr.Ttl < c.Min {
+				hdr.Ttl = c.Min
+				raised++
+			} else if c.Max > 0 && hdr.Ttl > c.Max {
+				hdr.Ttl = c.Max
+				lowered++
+			}
+		}
+	}
+	return raised, lowered
+}
+
+// clampTTLs applies the TTL clamping in effect to resp, counting the records
+// changed in DNS_ttl_clamp.raised and DNS_ttl_clamp.lowered
+func (h *FBDNSDB) clampTTLs(resp *dns.Msg) {
+	c := h.ttlClamp.Load()
+	if c == nil || !c.enabled() {
+		return
+	}
+	raised, lowered := c.clamp(resp)
+	if raised > 0 {
+		h.stats.IncrementCounterBy("DNS_ttl_clamp.raised", raised)
+	}
+	if lowered > 0 {
+		h.stats.IncrementCounterBy("DNS_ttl_clamp.lowered", lowered)
+	}
+}
+
+// SetTTLClamp replaces the TTL clamping of responses, TTLClampConfig{}
+// disabling it. Cached responses are clamped when served, so the change
+// applies to them at once.
+func (h *FBDNSDB) SetTTLClamp(c TTLClampConfig) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	h.ttlClamp.Store(&c)
+	if h.shadow != nil {
+		h.shadow.db.ttlClamp.Store(&c)
+	}
+	return nil
+}
+
+// TTLClamp returns the TTL clamping of responses in effect
+func (h *FBDNSDB) TTLClamp() TTLClampConfig {
+	if c := h.ttlClamp.Load(); c != nil {
+		return *c
+	}
+	return TTLClampConfig{}
+}
+
+// loadTTLClampFile sets the TTL clamping of responses from the control file
+// at path, written the way ParseTTLClamp reads, then removes it
+func (h *FBDNSDB) loadTTLClampFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c, err := ParseTTLClamp(string(b))
+	if err != nil {
+		return err
+	}
+	if err = h.SetTTLClamp(c); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
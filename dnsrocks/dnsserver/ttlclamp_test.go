@@ -0,0 +1,165 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestParseTTLClamp(t *testing.T) {
+	c, err := ParseTTLClamp("min=30 max=86400")
+	require.NoError(t, err)
+	require.Equal(t, TTLClampConfig{Min: 30, Max: 86400}, c)
+	require.Equal(t, "min=30 max=86400", c.String())
+
+	c, err = ParseTTLClamp(" max=60\n")
+	require.NoError(t, err)
+	require.Equal(t, TTLClampConfig{Max: 60}, c)
+
+	c, err = ParseTTLClamp("")
+	require.NoError(t, err)
+	require.False(t, c.enabled())
+
+	for _, s := range []string{"min", "min=-1", "max=4294967296", "floor=30", "min=600 max=60"} {
+		_, err := ParseTTLClamp(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestTTLClampConfigClamp(t *testing.T) {
+	m := new(dns.Msg)
+	m.Answer = []dns.RR{
+		test.A("example.com. 10 IN A 192.0.2.1"),
+		test.A("example.com. 300 IN A 192.0.2.2"),
+	}
+	m.Ns = []dns.RR{test.NS("example.com. 172800 IN NS a.ns.example.com.")}
+	m.SetEdns0(4096, false)
+
+	raised, lowered := TTLClampConfig{Min: 30, Max: 86400}.clamp(m)
+	require.Equal(t, int64(1), raised)
+	require.Equal(t, int64(1), lowered)
+	require.Equal(t, uint32(30), m.Answer[0].Header().Ttl)
+	require.Equal(t, uint32(300), m.Answer[1].Header().Ttl)
+	require.Equal(t, uint32(86400), m.Ns[0].Header().Ttl)
+	// the OPT record TTL holds flags, not a TTL
+	require.Equal(t, uint32(0), m.IsEdns0().Hdr.Ttl)
+}
+
+// TestHandlerTTLClamp checks that responses, cached ones included, have their
+// TTLs clamped, and that changing the clamping applies at once
+func TestHandlerTTLClamp(t *testing.T) {
+	query := func(t *testing.T, th *FBDNSDB, qtype uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("example.com.", qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err := th.ServeDNSWithRCODE(WithMaxAnswer(context.Background(), 1), rec, req)
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+		require.NotEmpty(t, rec.Msg.Answer)
+		return rec.Msg
+	}
+
+	for _, db := range testaid.TestDBs {
+		t.Run(db.Driver+"/"+db.Flavour, func(t *testing.T) {
+			ctr := stats.NewCounters()
+			config := HandlerConfig{TTLClamp: TTLClampConfig{Min: 600, Max: 86400}}
+			dbConfig := DBConfig{Path: db.Path, Driver: db.Driver}
+			th, err := NewFBDNSDBBasic(config, dbConfig, CacheConfig{Enabled: true, LRUSize: 1024}, &DummyLogger{}, ctr)
+			require.NoError(t, err)
+			require.NoError(t, th.Load())
+			defer th.Close()
+
+			for i := 0; i < 2; i++ {
+				resp := query(t, th, dns.TypeMX)
+				require.Equal(t, uint32(600), resp.Answer[0].Header().Ttl)
+				resp = query(t, th, dns.TypeNS)
+				for _, rr := range append(resp.Answer, resp.Extra...) {
+					require.LessOrEqual(t, rr.Header().Ttl, uint32(86400), rr)
+					require.GreaterOrEqual(t, rr.Header().Ttl, uint32(600), rr)
+				}
+			}
+			require.Equal(t, int64(2), ctr["DNS_ttl_clamp.raised"])
+			require.Positive(t, ctr["DNS_ttl_clamp.lowered"])
+
+			require.Error(t, th.SetTTLClamp(TTLClampConfig{Min: 600, Max: 60}))
+			require.NoError(t, th.SetTTLClamp(TTLClampConfig{}))
+			require.Equal(t, TTLClampConfig{}, th.TTLClamp())
+			// the cache holds the original TTLs
+			require.Equal(t, uint32(300), query(t, th, dns.TypeMX).Answer[0].Header().Ttl)
+			require.Equal(t, uint32(172800), query(t, th, dns.TypeNS).Answer[0].Header().Ttl)
+		})
+	}
+}
+
+func TestWatchControlDirTTLClamp(t *testing.T) {
+	th := OpenDbForTesting(t, &testaid.TestCDB)
+	defer th.Close()
+	ctlDir := t.TempDir()
+	th.dbConfig.ControlPath = ctlDir
+	watcher, err := prepareDBWatcher(th.dbConfig.ControlPath)
+	if watcher != nil {
+		defer watcher.Close()
+	}
+	require.NoError(t, err)
+	go func() {
+		err := th.watchControlDirAndReload(watcher)
+		require.NoError(t, err)
+	}()
+
+	// write temp file, move it to proper path already with the content
+	filePathTmp := path.Join(ctlDir, "."+ControlFileTTLClamp)
+	filePath := path.Join(ctlDir, ControlFileTTLClamp)
+	require.NoError(t, os.WriteFile(filePathTmp, []byte("min=30 max=3600\n"), 0644))
+	require.NoError(t, os.Rename(filePathTmp, filePath))
+
+	require.Eventually(t, func() bool {
+		return th.TTLClamp() == TTLClampConfig{Min: 30, Max: 3600}
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filePath)
+		return os.IsNotExist(err)
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+// TestSetTTLClampShadow checks that the shadow DB follows runtime TTL clamp
+// changes, so that its answers keep comparing equal
+func TestSetTTLClampShadow(t *testing.T) {
+	handlerConfig := HandlerConfig{
+		Shadow: ShadowConfig{
+			DB:         DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver},
+			SampleRate: 1,
+		},
+	}
+	dbConfig := DBConfig{Path: testaid.TestCDB.Path, Driver: testaid.TestCDB.Driver}
+	th, err := NewFBDNSDBBasic(handlerConfig, dbConfig, CacheConfig{}, &DummyLogger{}, stats.NewCounters())
+	require.NoError(t, err)
+	require.NoError(t, th.Load())
+	defer th.Close()
+
+	c := TTLClampConfig{Min: 60, Max: 600}
+	require.NoError(t, th.SetTTLClamp(c))
+	require.Equal(t, c, *th.shadow.db.ttlClamp.Load())
+}
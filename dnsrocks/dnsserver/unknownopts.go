@@ -0,0 +1,127 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+// Policies for the EDNS0 options of queries the server does not implement.
+const (
+	// UnknownOptionsIgnore leaves unknown options out of responses, as RFC
+	// 6891 requires.
+	UnknownOptionsIgnore = ""
+	// UnknownOptionsCount leaves unknown options out of responses, and counts
+	// them.
+	UnknownOptionsCount = "count"
+	// UnknownOptionsEcho echoes the unknown options with an allowed code in
+	// responses, leaves the others out, and counts them all.
+	UnknownOptionsEcho = "echo"
+)
+
+// knownOptions are the codes of the EDNS0 options the server implements
+var knownOptions = map[uint16]bool{
+	dns.EDNS0SUBNET:  true,
+	dns.EDNS0PADDING: true,
+}
+
+// UnknownOptionsConfig controls what happens to the EDNS0 options of queries
+// the server does not implement
+type UnknownOptionsConfig struct {
+	// Policy is one of UnknownOptionsIgnore, UnknownOptionsCount or
+	// UnknownOptionsEcho
+	Policy string
+	// AllowedCodes are the option codes echoed with UnknownOptionsEcho. They
+	// are also counted on their own when counting.
+	AllowedCodes []uint16
+}
+
+// unknownOptions applies a validated UnknownOptionsConfig
+type unknownOptions struct {
+	echo bool
+	// statsKeys are the counters of the allowed codes
+	statsKeys map[uint16]string
+}
+
+// newUnknownOptions validates c and returns the matching unknownOptions, or
+// nil when unknown options are ignored.
+func newUnknownOptions(c UnknownOptionsConfig) (*unknownOptions, error) {
+	switch c.Policy {
+	case UnknownOptionsIgnore:
+		return nil, nil
+	case UnknownOptionsCount:
+	case UnknownOptionsEcho:
+		if len(c.AllowedCodes) == 0 {
+			return nil, fmt.Errorf("EDNS0 option policy %q requires allowed option codes", c.Policy)
+		}
+	default:
+		return nil, fmt.Errorf("unknown EDNS0 option policy %q", c.Policy)
+	}
+	u := &unknownOptions{
+		echo:      c.Policy == UnknownOptionsEcho,
+		statsKeys: make(map[uint16]string, len(c.AllowedCodes)),
+	}
+	for _, code := range c.AllowedCodes {
+		if knownOptions[code] {
+			return nil, fmt.Errorf("EDNS0 option code %d is implemented, it cannot be allowed", code)
+		}
+		u.statsKeys[code] = fmt.Sprintf("DNS_edns0.unknown_option.%d", code)
+	}
+	return u, nil
+}
+
+// ParseOptionCodes parses a comma separated list of EDNS0 option codes, e.g.
+// `65001,65002`
+func ParseOptionCodes(s string) ([]uint16, error) {
+	var codes []uint16
+	for _, f := range strings.Split(s, ",") {
+		code, err := strconv.ParseUint(strings.TrimSpace(f), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EDNS0 option code %q: %w", f, err)
+		}
+		codes = append(codes, uint16(code))
+	}
+	return codes, nil
+}
+
+// handle counts the unknown options of req, and echoes the allowed ones in
+// resp if it has an OPT record
+func (u *unknownOptions) handle(req, resp *dns.Msg, s stats.Stats) {
+	o := req.IsEdns0()
+	if o == nil {
+		return
+	}
+	respOPT := resp.IsEdns0()
+	for _, e := range o.Option {
+		code := e.Option()
+		if knownOptions[code] {
+			continue
+		}
+		s.IncrementCounter("DNS_edns0.unknown_option")
+		key, allowed := u.statsKeys[code]
+		if !allowed {
+			continue
+		}
+		s.IncrementCounter(key)
+		if u.echo && respOPT != nil {
+			respOPT.Option = append(respOPT.Option, e)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dnsserver
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestParseOptionCodes(t *testing.T) {
+	codes, err := ParseOptionCodes("65001, 65002")
+	require.NoError(t, err)
+	require.Equal(t, []uint16{65001, 65002}, codes)
+
+	for _, s := range []string{"", "65001,", "nsid", "65536", "-1"} {
+		_, err := ParseOptionCodes(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestNewUnknownOptions(t *testing.T) {
+	u, err := newUnknownOptions(UnknownOptionsConfig{})
+	require.NoError(t, err)
+	require.Nil(t, u)
+
+	u, err = newUnknownOptions(UnknownOptionsConfig{Policy: UnknownOptionsCount})
+	require.NoError(t, err)
+	require.False(t, u.echo)
+
+	u, err = newUnknownOptions(UnknownOptionsConfig{Policy: UnknownOptionsEcho, AllowedCodes: []uint16{65001}})
+	require.NoError(t, err)
+	require.True(t, u.echo)
+
+	for _, c := range []UnknownOptionsConfig{
+		{Policy: "passthrough"},
+		{Policy: UnknownOptionsEcho},
+		{Policy: UnknownOptionsEcho, AllowedCodes: []uint16{dns.EDNS0SUBNET}},
+		{Policy: UnknownOptionsCount, AllowedCodes: []uint16{dns.EDNS0PADDING}},
+	} {
+		_, err := newUnknownOptions(c)
+		require.Error(t, err, c)
+	}
+}
+
+// TestHandlerUnknownOptions checks that responses echo the allowed unknown
+// options only, and that unknown options are counted
+func TestHandlerUnknownOptions(t *testing.T) {
+	query := func(t *testing.T, th *FBDNSDB) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("www.example.com.", dns.TypeA)
+		req.SetEdns0(4096, false)
+		o := req.IsEdns0()
+		o.Option = append(o.Option,
+			&dns.EDNS0_LOCAL{Code: 65001, Data: []byte("echo me")},
+			&dns.EDNS0_LOCAL{Code: 65002, Data: []byte("drop me")},
+			&dns.EDNS0_PADDING{Padding: make([]byte, 8)},
+		)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err := th.ServeDNSWithRCODE(WithMaxAnswer(context.Background(), 1), rec, req)
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+		require.NotNil(t, rec.Msg.IsEdns0())
+		return rec.Msg
+	}
+
+	for _, db := range testaid.TestDBs {
+		t.Run(db.Driver+"/"+db.Flavour, func(t *testing.T) {
+			dbConfig := DBConfig{Path: db.Path, Driver: db.Driver}
+			for _, policy := range []string{UnknownOptionsIgnore, UnknownOptionsCount, UnknownOptionsEcho} {
+				ctr := stats.NewCounters()
+				config := HandlerConfig{UnknownOptions: UnknownOptionsConfig{Policy: policy, AllowedCodes: []uint16{65001}}}
+				th, err := NewFBDNSDBBasic(config, dbConfig, CacheConfig{}, &TextLogger{IoWriter: os.Stdout}, ctr)
+				require.NoError(t, err)
+				require.NoError(t, th.Load())
+
+				resp := query(t, th)
+				th.Close()
+				if policy == UnknownOptionsEcho {
+					o := resp.IsEdns0()
+					require.Len(t, o.Option, 1)
+					require.Equal(t, &dns.EDNS0_LOCAL{Code: 65001, Data: []byte("echo me")}, o.Option[0])
+				} else {
+					require.Empty(t, resp.IsEdns0().Option, policy)
+				}
+				if policy == UnknownOptionsIgnore {
+					require.Zero(t, ctr["DNS_edns0.unknown_option"])
+				} else {
+					require.Equal(t, int64(2), ctr["DNS_edns0.unknown_option"], policy)
+					require.Equal(t, int64(1), ctr["DNS_edns0.unknown_option.65001"], policy)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package engine runs the dnsrocks authoritative DNS server from other Go
+// programs, without flags:
+//
+//	e, err := engine.New(
+//		engine.WithDB("/var/dns/rdb", "rocksdb"),
+//		engine.WithListener("2001:db8::53"),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	return e.Run(ctx)
+//
+// Settings without option of their own are set on the fbserver.ServerConfig
+// with WithServerConfig.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"time"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/fbserver"
+	"github.com/facebook/dns/dnsrocks/metrics"
+)
+
+// MetricsExporter exports the connection stats of the listeners
+type MetricsExporter interface {
+	ConsumeStats(category string, stats *metrics.Stats) error
+}
+
+// options are the settings of an Engine
+type options struct {
+	conf     fbserver.ServerConfig
+	logger   dnsserver.Logger
+	stats    stats.Stats
+	exporter MetricsExporter
+}
+
+// Option configures an Engine
+type Option func(*options) error
+
+// DefaultServerConfig returns the configuration of the dnsrocks binary
+// without flags, except for the DB which must be set, see WithDB
+func DefaultServerConfig() fbserver.ServerConfig {
+	conf := fbserver.NewServerConfig()
+	conf.Port = 53
+	conf.TCP = true
+	conf.MaxTCPQueries = -1
+	conf.TCPIdleTimeout = 8 * time.Second
+	conf.ReadTimeout = 2 * time.Second
+	conf.NumCPU = runtime.NumCPU()
+	conf.DBConfig.Driver = "rocksdb"
+	conf.DBConfig.ReloadTimeout = time.Second
+	conf.CacheConfig.LRUSize = 1024 * 1024
+	return conf
+}
+
+// WithDB serves the DB at path, opened with driver, cdb or rocksdb
+func WithDB(path, driver string) Option {
+	return func(o *options) error {
+		o.conf.DBConfig.Path = path
+		o.conf.DBConfig.Driver = driver
+		return nil
+	}
+}
+
+// WithListener listens on ip. It can be repeated, the engine listening on
+// all addresses without listener.
+func WithListener(ip string) Option {
+	return func(o *options) error {
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return fmt.Errorf("invalid listener address %q", ip)
+		}
+		o.conf.IPAns[addr.String()] = dnsserver.DefaultMaxAnswer
+		return nil
+	}
+}
+
+// WithPort sets the port of the listeners, 53 by default. With port 0, free
+// ports are picked, see Engine.Addrs.
+func WithPort(port int) Option {
+	return func(o *options) error {
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+		o.conf.Port = port
+		return nil
+	}
+}
+
+// WithTCP sets whether the engine listens on TCP as well as UDP, true by
+// default
+func WithTCP(enabled bool) Option {
+	return func(o *options) error {
+		o.conf.TCP = enabled
+		return nil
+	}
+}
+
+// WithReloadInterval reloads the DB every interval, rounded down to the
+// second
+func WithReloadInterval(interval time.Duration) Option {
+	return func(o *options) error {
+		if interval < time.Second {
+			return fmt.Errorf("invalid reload interval %v", interval)
+		}
+		o.conf.DBConfig.ReloadInterval = int(interval / time.Second)
+		return nil
+	}
+}
+
+// WithWatchDB reloads the DB when its files change
+func WithWatchDB() Option {
+	return func(o *options) error {
+		o.conf.DBConfig.WatchDB = true
+		return nil
+	}
+}
+
+// WithLogger logs the queries to l, which are not logged by default
+func WithLogger(l dnsserver.Logger) Option {
+	return func(o *options) error {
+		o.logger = l
+		return nil
+	}
+}
+
+// WithStats counts the server stats in s, metrics.NewStats by default
+func WithStats(s stats.Stats) Option {
+	return func(o *options) error {
+		o.stats = s
+		return nil
+	}
+}
+
+// WithMetricsExporter exports the connection stats of the listeners with e,
+// which are not exported by default
+func WithMetricsExporter(e MetricsExporter) Option {
+	return func(o *options) error {
+		o.exporter = e
+		return nil
+	}
+}
+
+// WithServerConfig lets f change the configuration of the server, after the
+// options before it
+func WithServerConfig(f func(*fbserver.ServerConfig)) Option {
+	return func(o *options) error {
+		f(&o.conf)
+		return nil
+	}
+}
+
+// Engine is an authoritative DNS server serving a DB
+type Engine struct {
+	srv   *fbserver.Server
+	ready chan struct{}
+}
+
+// New returns an engine configured with opts, with its DB loaded
+func New(opts ...Option) (*Engine, error) {
+	o := options{
+		conf:     DefaultServerConfig(),
+		logger:   &dnsserver.DummyLogger{},
+		stats:    metrics.NewStats(),
+		exporter: &metrics.DummyServer{},
+	}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	if o.conf.DBConfig.Path == "" {
+		return nil, errors.New("no DB to serve, see WithDB")
+	}
+	srv, err := fbserver.New(o.conf, o.logger, o.stats, o.exporter)
+	if err != nil {
+		return nil, err
+	}
+	srv.NotifyStartedFunc = func() {
+		srv.ServersStartedWG.Done()
+	}
+	return &Engine{srv: srv, ready: make(chan struct{})}, nil
+}
+
+// Run starts the listeners, and serves until ctx is done, then shuts the
+// engine down. It returns an error if the listeners could not be started.
+// An engine runs once.
+func (e *Engine) Run(ctx context.Context) error {
+	if err := e.srv.Start(); err != nil {
+		e.srv.Shutdown()
+		return err
+	}
+	go func() {
+		e.srv.ServersStartedWG.Wait()
+		close(e.ready)
+	}()
+	e.srv.Serve(ctx)
+	return nil
+}
+
+// Ready is closed once all the listeners are serving
+func (e *Engine) Ready() <-chan struct{} {
+	return e.ready
+}
+
+// Addrs returns the addresses the engine listens on, by network (udp, tcp or
+// tcp-tls), once ready. With several listeners, one of them is returned.
+func (e *Engine) Addrs() map[string]string {
+	return e.srv.Addrs()
+}
+
+// Reload reloads the DB
+func (e *Engine) Reload() {
+	e.srv.ReloadDB()
+}
+
+// DB returns the handler serving the queries from the DB, e.g. to resolve
+// queries in process with QuerySingle
+func (e *Engine) DB() *dnsserver.FBDNSDB {
+	return e.srv.DB()
+}
+
+// Close releases the DB of an engine which is not run
+func (e *Engine) Close() {
+	e.srv.Shutdown()
+}
@@ -0,0 +1,101 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/fbserver"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(testaid.Run(m, "../testdata/data"))
+}
+
+func TestNewErrors(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"no DB":        nil,
+		"bad listener": {WithDB(testaid.TestCDB.Path, "cdb"), WithListener("localhost")},
+		"bad port":     {WithDB(testaid.TestCDB.Path, "cdb"), WithPort(65536)},
+		"bad interval": {WithDB(testaid.TestCDB.Path, "cdb"), WithReloadInterval(time.Millisecond)},
+		"missing DB":   {WithDB("/nonexistent/db", "cdb")},
+		"bad driver":   {WithDB(testaid.TestCDB.Path, "bogus")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(opts...)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestEngine(t *testing.T) {
+	var configured bool
+	e, err := New(
+		WithDB(testaid.TestCDB.Path, testaid.TestCDB.Driver),
+		WithListener("::1"),
+		WithPort(0),
+		WithServerConfig(func(c *fbserver.ServerConfig) {
+			configured = c.TCP && c.Port == 0
+		}),
+	)
+	require.NoError(t, err)
+	require.True(t, configured)
+
+	// the DB answers in process before the engine runs
+	rec, err := e.DB().QuerySingle("A", "foo.example.org", "1.1.1.1", "", 1)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, rec.Rcode)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- e.Run(ctx)
+	}()
+	select {
+	case <-e.Ready():
+	case err := <-done:
+		t.Fatalf("engine stopped before being ready: %v", err)
+	}
+	addrs := e.Addrs()
+	require.Contains(t, addrs, "udp")
+	require.Contains(t, addrs, "tcp")
+
+	for _, network := range []string{"udp", "tcp"} {
+		m := new(dns.Msg)
+		m.SetQuestion("foo.example.org.", dns.TypeA)
+		c := &dns.Client{Net: network}
+		r, _, err := c.Exchange(m, addrs[network])
+		require.NoError(t, err, network)
+		require.Equal(t, dns.RcodeSuccess, r.Rcode, network)
+		require.NotEmpty(t, r.Answer, network)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("engine did not stop")
+	}
+	// shutting down again is harmless, and so is reloading once shut down
+	e.Close()
+	e.Reload()
+}
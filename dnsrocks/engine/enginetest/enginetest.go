@@ -0,0 +1,166 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package enginetest runs dnsrocks engines on loopback for end-to-end tests,
+// which query them over real sockets the way resolvers do, listeners,
+// transports, reloads and shutdown included:
+//
+//	srv := enginetest.Start(t, testaid.TestCDB)
+//	r := srv.Query(t, "udp", "www.example.com.", dns.TypeA)
+package enginetest
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/engine"
+	"github.com/facebook/dns/dnsrocks/fbserver"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+// shutdownTimeout is how long Close waits for the engine to stop
+const shutdownTimeout = 10 * time.Second
+
+// Server is an engine serving a test DB on 127.0.0.1, on free ports
+type Server struct {
+	// Addrs are the addresses served by network: udp, tcp, and tcp-tls for
+	// servers started with StartTLS
+	Addrs map[string]string
+
+	engine *engine.Engine
+	cancel context.CancelFunc
+	done   chan error
+	err    error
+}
+
+// Start starts an engine serving testDB over UDP and TCP, configured with
+// opts after the harness settings. It is closed when t ends, if not before.
+func Start(t *testing.T, testDB testaid.TestDB, opts ...engine.Option) *Server {
+	t.Helper()
+	return start(t, testDB, opts)
+}
+
+// StartTLS is Start with DNS over TLS as well, with a self-signed
+// certificate, see Client
+func StartTLS(t *testing.T, testDB testaid.TestDB, opts ...engine.Option) *Server {
+	t.Helper()
+	certFile := testaid.MkTestCert(t)
+	t.Cleanup(func() { os.Remove(certFile) })
+	tlsOpt := engine.WithServerConfig(func(c *fbserver.ServerConfig) {
+		c.TLS = true
+		c.TLSConfig.Port = 0
+		c.TLSConfig.CertFile = certFile
+		c.TLSConfig.KeyFile = certFile
+	})
+	return start(t, testDB, append([]engine.Option{tlsOpt}, opts...))
+}
+
+func start(t *testing.T, testDB testaid.TestDB, opts []engine.Option) *Server {
+	t.Helper()
+	opts = append([]engine.Option{
+		engine.WithDB(testDB.Path, testDB.Driver),
+		engine.WithListener("127.0.0.1"),
+		engine.WithPort(0),
+	}, opts...)
+	e, err := engine.New(opts...)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := &Server{engine: e, cancel: cancel, done: make(chan error, 1)}
+	go func() {
+		srv.done <- e.Run(ctx)
+	}()
+	select {
+	case <-e.Ready():
+	case err := <-srv.done:
+		cancel()
+		t.Fatalf("Engine stopped before being ready: %v", err)
+	case <-time.After(shutdownTimeout):
+		cancel()
+		t.Fatal("Engine not ready in time")
+	}
+	srv.Addrs = e.Addrs()
+	t.Cleanup(func() {
+		if err := srv.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return srv
+}
+
+// Engine returns the engine run by srv
+func (srv *Server) Engine() *engine.Engine {
+	return srv.engine
+}
+
+// Client returns a client sending queries over network: udp, tcp or tcp-tls,
+// which trusts the certificate of StartTLS
+func (srv *Server) Client(network string) *dns.Client {
+	c := &dns.Client{Net: network, Timeout: 5 * time.Second}
+	if network == "tcp-tls" {
+		c.TLSConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	}
+	return c
+}
+
+// Exchange sends m to srv over network and returns the response
+func (srv *Server) Exchange(network string, m *dns.Msg) (*dns.Msg, error) {
+	addr, ok := srv.Addrs[network]
+	if !ok {
+		return nil, fmt.Errorf("no %s listener", network)
+	}
+	r, _, err := srv.Client(network).Exchange(m, addr)
+	return r, err
+}
+
+// Query sends a query for qname and qtype over network, failing t if there
+// is no response
+func (srv *Server) Query(t *testing.T, network, qname string, qtype uint16) *dns.Msg {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(qname, qtype)
+	r, err := srv.Exchange(network, m)
+	if err != nil {
+		t.Fatalf("Failed to query %s %s over %s: %v", qname, dns.TypeToString[qtype], network, err)
+	}
+	return r
+}
+
+// Reload asks srv to reload its DB, which is done in the background
+func (srv *Server) Reload() {
+	srv.engine.Reload()
+}
+
+// Close shuts srv down gracefully, and returns the error the engine stopped
+// with, or an error if it did not stop in time. Closing again returns the
+// same error.
+func (srv *Server) Close() error {
+	srv.cancel()
+	if srv.done == nil {
+		return srv.err
+	}
+	select {
+	case srv.err = <-srv.done:
+	case <-time.After(shutdownTimeout):
+		srv.err = fmt.Errorf("engine did not stop within %v", shutdownTimeout)
+	}
+	srv.done = nil
+	return srv.err
+}
@@ -0,0 +1,134 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package enginetest_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/engine/enginetest"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(testaid.Run(m, "../../testdata/data"))
+}
+
+// TestQueries checks that the answers received over every transport are the
+// ones the handler resolves in process
+func TestQueries(t *testing.T) {
+	for _, testDB := range testaid.TestDBs {
+		t.Run(testDB.Driver+"/"+testDB.Flavour, func(t *testing.T) {
+			srv := enginetest.StartTLS(t, testDB)
+			require.Contains(t, srv.Addrs, "udp")
+			require.Contains(t, srv.Addrs, "tcp")
+			require.Contains(t, srv.Addrs, "tcp-tls")
+
+			for _, qname := range []string{"foo.example.org.", "cnamemap.example.net.", "nonexistent.example.com."} {
+				expected, err := srv.Engine().DB().QuerySingle("A", qname, "127.0.0.1", "", 1)
+				require.NoError(t, err)
+				for network := range srv.Addrs {
+					r := srv.Query(t, network, qname, dns.TypeA)
+					require.Equal(t, expected.Msg.Rcode, r.Rcode, "%s over %s", qname, network)
+					require.Equal(t, expected.Msg.Answer, r.Answer, "%s over %s", qname, network)
+					require.True(t, r.Authoritative, "%s over %s", qname, network)
+				}
+			}
+
+			r := srv.Query(t, "udp", "www.notourdomain.com.", dns.TypeA)
+			require.Equal(t, dns.RcodeRefused, r.Rcode)
+		})
+	}
+}
+
+// TestTruncation checks that responses too large for UDP are truncated, and
+// complete over TCP
+func TestTruncation(t *testing.T) {
+	f := testaid.NewFixture().Zone("example.com", 300, "ns.example.com")
+	for i := range 20 {
+		f.TXT("big.example.com", fmt.Sprintf("%02d %s", i, strings.Repeat("x", 60)), 300)
+	}
+	for _, testDB := range f.Build(t) {
+		t.Run(testDB.Driver+"/"+testDB.Flavour, func(t *testing.T) {
+			srv := enginetest.Start(t, testDB)
+
+			r := srv.Query(t, "udp", "big.example.com.", dns.TypeTXT)
+			require.True(t, r.Truncated)
+			r.Compress = true
+			require.LessOrEqual(t, r.Len(), dns.MinMsgSize)
+
+			m := new(dns.Msg)
+			m.SetQuestion("big.example.com.", dns.TypeTXT)
+			m.SetEdns0(4096, false)
+			r, err := srv.Exchange("udp", m)
+			require.NoError(t, err)
+			require.False(t, r.Truncated)
+			require.Len(t, r.Answer, 20)
+
+			r = srv.Query(t, "tcp", "big.example.com.", dns.TypeTXT)
+			require.False(t, r.Truncated)
+			require.Len(t, r.Answer, 20)
+		})
+	}
+}
+
+// TestReload checks that the data of a CDB replaced in place is served once
+// reloaded
+func TestReload(t *testing.T) {
+	build := func(ip string) string {
+		testDBs := testaid.NewFixture().
+			Zone("example.com", 300, "ns.example.com").
+			Address("www.example.com", ip, 300, "").
+			Build(t)
+		return testDBs[0].Path
+	}
+	served := path.Join(t.TempDir(), "data.cdb")
+	require.NoError(t, os.Rename(build("192.0.2.1"), served))
+
+	srv := enginetest.Start(t, testaid.TestDB{Driver: "cdb", Path: served})
+	answer := func() string {
+		r := srv.Query(t, "udp", "www.example.com.", dns.TypeA)
+		if len(r.Answer) != 1 {
+			return ""
+		}
+		return r.Answer[0].(*dns.A).A.String()
+	}
+	require.Equal(t, "192.0.2.1", answer())
+
+	require.NoError(t, os.Rename(build("192.0.2.2"), served))
+	srv.Reload()
+	require.Eventually(t, func() bool { return answer() == "192.0.2.2" }, 10*time.Second, 10*time.Millisecond)
+}
+
+// TestShutdown checks that a closed server stops listening
+func TestShutdown(t *testing.T) {
+	srv := enginetest.Start(t, testaid.TestCDB)
+	srv.Query(t, "tcp", "foo.example.org.", dns.TypeA)
+
+	require.NoError(t, srv.Close())
+	_, err := net.DialTimeout("tcp", srv.Addrs["tcp"], time.Second)
+	require.Error(t, err)
+	_, err = srv.Exchange("tcp", new(dns.Msg).SetQuestion("foo.example.org.", dns.TypeA))
+	require.Error(t, err)
+	// closing again is harmless
+	require.NoError(t, srv.Close())
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/miekg/dns"
+)
+
+// chaosTXTHandler answers CH TXT queries for its name with a text describing
+// the server, e.g. the checksum of the DB in use, so that operators can check
+// which artifact a server runs
+type chaosTXTHandler struct {
+	name string
+	txt  func() string
+	Next plugin.Handler
+}
+
+// newChaosTXTHandler initializes a chaosTXTHandler answering for name with the
+// text returned by txt, no record being returned for an empty text
+func newChaosTXTHandler(name string, txt func() string) (*chaosTXTHandler, error) {
+	return &chaosTXTHandler{name: strings.ToLower(dns.Fqdn(name)), txt: txt}, nil
+}
+
+func (ch *chaosTXTHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	q := r.Question[0]
+	if q.Qclass != dns.ClassCHAOS || strings.ToLower(q.Name) != ch.name {
+		return plugin.NextOrFailure(ch.Name(), ch.Next, ctx, w, r)
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	if q.Qtype == dns.TypeTXT {
+		if text := ch.txt(); text != "" {
+			hdr := dns.RR_Header{Name: q.Name, Ttl: 0, Class: dns.ClassCHAOS, Rrtype: dns.TypeTXT}
+			m.Answer = []dns.RR{&dns.TXT{Hdr: hdr, Txt: splitTXT(text)}}
+		}
+	}
+
+	err := w.WriteMsg(m)
+	if err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	return dns.RcodeSuccess, nil
+}
+
+func (ch *chaosTXTHandler) Name() string { return "chaostxt" }
+
+// splitTXT splits s into the 255 bytes character strings of a TXT record
+func splitTXT(s string) []string {
+	var txt []string
+	for len(s) > 255 {
+		txt = append(txt, s[:255])
+		s = s[255:]
+	}
+	return append(txt, s)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+)
+
+func TestChaosTXTHandler(t *testing.T) {
+	var text string
+	ch, err := newChaosTXTHandler("Checksum.DNSRocks", func() string { return text })
+	require.NoError(t, err)
+
+	query := func(name string, class, qtype uint16) (*dns.Msg, error) {
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		req.Question[0].Qclass = class
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err := ch.ServeDNS(context.TODO(), rec, req)
+		return rec.Msg, err
+	}
+
+	// other queries go to the next handler
+	_, err = query("checksum.dnsrocks.", dns.ClassINET, dns.TypeTXT)
+	require.ErrorContains(t, err, "no next plugin found")
+	_, err = query("version.bind.", dns.ClassCHAOS, dns.TypeTXT)
+	require.ErrorContains(t, err, "no next plugin found")
+
+	// no data without text, or for other types
+	m, err := query("checksum.dnsrocks.", dns.ClassCHAOS, dns.TypeTXT)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, m.Rcode)
+	require.Empty(t, m.Answer)
+	text = "sha256=" + strings.Repeat("ab", 200)
+	m, err = query("CHECKSUM.dnsrocks.", dns.ClassCHAOS, dns.TypeA)
+	require.NoError(t, err)
+	require.Empty(t, m.Answer)
+
+	m, err = query("CHECKSUM.dnsrocks.", dns.ClassCHAOS, dns.TypeTXT)
+	require.NoError(t, err)
+	require.Len(t, m.Answer, 1)
+	txt := m.Answer[0].(*dns.TXT)
+	require.Equal(t, uint16(dns.ClassCHAOS), txt.Hdr.Class)
+	require.Equal(t, "CHECKSUM.dnsrocks.", txt.Hdr.Name)
+	require.Len(t, txt.Txt, 2)
+	require.Equal(t, text, strings.Join(txt.Txt, ""))
+}
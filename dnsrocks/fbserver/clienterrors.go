@@ -0,0 +1,227 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+// Client errors defaults
+const (
+	DefaultClientErrorsIPv4PrefixLen = 24
+	DefaultClientErrorsIPv6PrefixLen = 48
+)
+
+// Kinds of client errors
+const (
+	// clientErrorFormErr counts queries answered with FORMERR, e.g. because
+	// they could not be parsed or had unexpected section counts
+	clientErrorFormErr = "formerr"
+	// clientErrorNotImp counts queries answered with NOTIMP, because of an
+	// unexpected opcode
+	clientErrorNotImp = "notimp"
+	// clientErrorEDNS counts queries violating EDNS (RFC 6891): several OPT
+	// records, OPT records out of the additional section or not owned by the
+	// root, or unsupported EDNS versions
+	clientErrorEDNS = "edns"
+	// clientErrorInvalid counts packets which could not be parsed, which
+	// are not attributed to their source
+	clientErrorInvalid = "invalid"
+)
+
+// clientErrorsOtherSource is the source of the errors of the subnets seen
+// once MaxSources subnets are tracked
+const clientErrorsOtherSource = "other"
+
+// headerLen is the length of the header of DNS messages
+const headerLen = 12
+
+// ClientErrorsConfig configures the counting of malformed queries, EDNS
+// violations and unexpected opcodes per source subnet, to find the broken
+// middleboxes and forwarders sending them.
+type ClientErrorsConfig struct {
+	// MaxSources is the number of source subnets counted on their own, the
+	// errors of further subnets being counted together. Counting is disabled
+	// if 0.
+	MaxSources int
+	// IPv4PrefixLen and IPv6PrefixLen are the lengths of the subnets sources
+	// are grouped by, DefaultClientErrorsIPv4PrefixLen and
+	// DefaultClientErrorsIPv6PrefixLen if 0
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+}
+
+// clientErrors counts client errors under DNS_client_error.<kind> and
+// DNS_client_error.<kind>.<source>, sources being the first MaxSources
+// subnets which sent errors, so that the number of stats keys is bounded.
+type clientErrors struct {
+	stats      stats.Stats
+	maxSources int
+	v4Mask     net.IPMask
+	v6Mask     net.IPMask
+
+	// mu protects sources
+	mu sync.Mutex
+	// sources maps the subnets tracked to their stats key
+	sources map[string]string
+}
+
+// newClientErrors validates c and returns the matching clientErrors, or nil
+// when counting is disabled
+func newClientErrors(c ClientErrorsConfig, s stats.Stats) (*clientErrors, error) {
+	if c.MaxSources == 0 {
+		return nil, nil
+	}
+	if c.IPv4PrefixLen == 0 {
+		c.IPv4PrefixLen = DefaultClientErrorsIPv4PrefixLen
+	}
+	if c.IPv6PrefixLen == 0 {
+		c.IPv6PrefixLen = DefaultClientErrorsIPv6PrefixLen
+	}
+	if c.MaxSources < 0 || c.IPv4PrefixLen < 0 || c.IPv4PrefixLen > 32 || c.IPv6PrefixLen < 0 || c.IPv6PrefixLen > 128 {
+		return nil, fmt.Errorf("invalid client errors config %+v", c)
+	}
+	return &clientErrors{
+		stats:      s,
+		maxSources: c.MaxSources,
+		v4Mask:     net.CIDRMask(c.IPv4PrefixLen, 8*net.IPv4len),
+		v6Mask:     net.CIDRMask(c.IPv6PrefixLen, 8*net.IPv6len),
+		sources:    make(map[string]string, c.MaxSources),
+	}, nil
+}
+
+// sourceKeyReplacer makes subnets valid stats key components
+var sourceKeyReplacer = strings.NewReplacer(".", "_", ":", "_", "/", "_")
+
+// source returns the stats key component of the subnet of addr
+func (c *clientErrors) source(addr net.Addr) string {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	}
+	if ip == nil {
+		return clientErrorsOtherSource
+	}
+	var subnet string
+	if ip4 := ip.To4(); ip4 != nil {
+		subnet = (&net.IPNet{IP: ip4.Mask(c.v4Mask), Mask: c.v4Mask}).String()
+	} else {
+		subnet = (&net.IPNet{IP: ip.Mask(c.v6Mask), Mask: c.v6Mask}).String()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.sources[subnet]; ok {
+		return key
+	}
+	if len(c.sources) >= c.maxSources {
+		return clientErrorsOtherSource
+	}
+	key := sourceKeyReplacer.Replace(subnet)
+	c.sources[subnet] = key
+	return key
+}
+
+// count counts an error of kind sent from addr
+func (c *clientErrors) count(kind string, addr net.Addr) {
+	c.stats.IncrementCounter("DNS_client_error." + kind)
+	c.stats.IncrementCounter("DNS_client_error." + kind + "." + c.source(addr))
+}
+
+// checkEDNS counts req if it violates EDNS
+func (c *clientErrors) checkEDNS(w dns.ResponseWriter, req *dns.Msg) {
+	if ednsViolation(req) {
+		c.count(clientErrorEDNS, w.RemoteAddr())
+	}
+}
+
+// ednsViolation tells whether m has several OPT records, OPT records out of
+// the additional section or not owned by the root, or an unsupported EDNS
+// version
+func ednsViolation(m *dns.Msg) bool {
+	for _, rrs := range [][]dns.RR{m.Answer, m.Ns} {
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				return true
+			}
+		}
+	}
+	opts := 0
+	for _, rr := range m.Extra {
+		opt, ok := rr.(*dns.OPT)
+		if !ok {
+			continue
+		}
+		opts++
+		if opts > 1 || opt.Hdr.Name != "." || opt.Version() != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// clientErrorsWriter counts the responses to client errors written through it
+type clientErrorsWriter struct {
+	dns.Writer
+	w dns.ResponseWriter
+	c *clientErrors
+}
+
+// Write counts m if it answers FORMERR or NOTIMP, then writes it
+func (cw *clientErrorsWriter) Write(m []byte) (int, error) {
+	if len(m) >= headerLen {
+		switch int(m[3] & 0xf) {
+		case dns.RcodeFormatError:
+			cw.c.count(clientErrorFormErr, cw.w.RemoteAddr())
+		case dns.RcodeNotImplemented:
+			cw.c.count(clientErrorNotImp, cw.w.RemoteAddr())
+		}
+	}
+	return cw.Writer.Write(m)
+}
+
+// decorateWriter is the dns.DecorateWriter counting client errors from the
+// responses, which the server writes for queries it rejects before handlers
+// see them
+func (c *clientErrors) decorateWriter(w dns.Writer) dns.Writer {
+	rw, ok := w.(dns.ResponseWriter)
+	if !ok {
+		return w
+	}
+	return &clientErrorsWriter{Writer: w, w: rw, c: c}
+}
+
+// attach makes s count client errors, keeping the dns.MsgInvalidFunc already
+// set, e.g. by throttle.Handler.Attach. The server gives no source for the
+// packets which could not be parsed: they are only counted globally, and
+// per source when answered with FORMERR.
+func (c *clientErrors) attach(s *dns.Server) {
+	s.DecorateWriter = c.decorateWriter
+	next := s.MsgInvalidFunc
+	s.MsgInvalidFunc = func(m []byte, err error) {
+		c.stats.IncrementCounter("DNS_client_error." + clientErrorInvalid)
+		if next != nil {
+			next(m, err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClientErrors(t *testing.T) {
+	c, err := newClientErrors(ClientErrorsConfig{}, stats.NewCounters())
+	require.NoError(t, err)
+	require.Nil(t, c)
+
+	for _, conf := range []ClientErrorsConfig{
+		{MaxSources: -1},
+		{MaxSources: 1, IPv4PrefixLen: 33},
+		{MaxSources: 1, IPv6PrefixLen: 129},
+	} {
+		_, err = newClientErrors(conf, stats.NewCounters())
+		require.Error(t, err, "%+v", conf)
+	}
+}
+
+func TestClientErrorsWriter(t *testing.T) {
+	counters := stats.NewCounters()
+	c, err := newClientErrors(ClientErrorsConfig{MaxSources: 2}, counters)
+	require.NoError(t, err)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	write := func(remoteIP string, rcode int) {
+		m := new(dns.Msg)
+		m.SetRcode(req, rcode)
+		b, err := m.Pack()
+		require.NoError(t, err)
+		w := c.decorateWriter(&test.ResponseWriterCustomRemote{RemoteIP: remoteIP})
+		n, err := w.Write(b)
+		require.NoError(t, err)
+		require.Equal(t, len(b), n)
+	}
+	write("192.0.2.1", dns.RcodeSuccess)
+	write("192.0.2.1", dns.RcodeNameError)
+	write("192.0.2.1", dns.RcodeFormatError)
+	write("192.0.2.200", dns.RcodeFormatError)
+	write("2001:db8:1:2::1", dns.RcodeNotImplemented)
+	// no room left for more sources
+	write("198.51.100.1", dns.RcodeFormatError)
+	write("192.0.2.3", dns.RcodeNotImplemented)
+
+	require.Equal(t, stats.Counters{
+		"DNS_client_error.formerr":                3,
+		"DNS_client_error.formerr.192_0_2_0_24":   2,
+		"DNS_client_error.formerr.other":          1,
+		"DNS_client_error.notimp":                 2,
+		"DNS_client_error.notimp.2001_db8_1___48": 1,
+		"DNS_client_error.notimp.192_0_2_0_24":    1,
+	}, counters)
+}
+
+func TestEDNSViolation(t *testing.T) {
+	withOPT := func(edit func(m *dns.Msg, opt *dns.OPT)) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeA)
+		m.SetEdns0(1232, false)
+		if edit != nil {
+			edit(m, m.IsEdns0())
+		}
+		return m
+	}
+	plain := new(dns.Msg)
+	plain.SetQuestion("example.com.", dns.TypeA)
+
+	require.False(t, ednsViolation(plain))
+	require.False(t, ednsViolation(withOPT(nil)))
+	require.True(t, ednsViolation(withOPT(func(_ *dns.Msg, opt *dns.OPT) {
+		opt.SetVersion(1)
+	})))
+	require.True(t, ednsViolation(withOPT(func(_ *dns.Msg, opt *dns.OPT) {
+		opt.Hdr.Name = "example.com."
+	})))
+	require.True(t, ednsViolation(withOPT(func(m *dns.Msg, opt *dns.OPT) {
+		m.Extra = append(m.Extra, dns.Copy(opt))
+	})))
+	require.True(t, ednsViolation(withOPT(func(m *dns.Msg, opt *dns.OPT) {
+		m.Extra, m.Ns = nil, []dns.RR{opt}
+	})))
+}
+
+func TestServeMuxCountsEDNSViolations(t *testing.T) {
+	counters := stats.NewCounters()
+	c, err := newClientErrors(ClientErrorsConfig{MaxSources: 1}, counters)
+	require.NoError(t, err)
+	mux := &serveMux{stats: counters, clientErrors: c}
+
+	// queries without question are answered before any handler
+	req := new(dns.Msg)
+	req.SetEdns0(1232, false)
+	req.IsEdns0().SetVersion(1)
+	mux.ServeDNS(&test.ResponseWriterCustomRemote{RemoteIP: "192.0.2.1"}, req)
+	require.Equal(t, int64(1), counters["DNS_client_error.edns"])
+	require.Equal(t, int64(1), counters["DNS_client_error.edns.192_0_2_0_24"])
+}
+
+func TestClientErrorsAttach(t *testing.T) {
+	counters := stats.NewCounters()
+	c, err := newClientErrors(ClientErrorsConfig{MaxSources: 1}, counters)
+	require.NoError(t, err)
+
+	var invalid []error
+	s := &dns.Server{MsgInvalidFunc: func(_ []byte, err error) { invalid = append(invalid, err) }}
+	c.attach(s)
+	require.NotNil(t, s.DecorateWriter)
+	errShort := errors.New("short")
+	s.MsgInvalidFunc([]byte{0}, errShort)
+	require.Equal(t, []error{errShort}, invalid)
+	require.Equal(t, int64(1), counters["DNS_client_error.invalid"])
+}
@@ -16,6 +16,7 @@ package fbserver
 import (
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -48,6 +49,36 @@ type ServerConfig struct {
 	DNSSECConfig   DNSSECConfig
 	NSID           bool
 	PrivateInfo    bool
+	// NotifyReceiverConfig configures reloads triggered by NOTIFY messages
+	NotifyReceiverConfig NotifyReceiverConfig
+	// DebugConfig configures the debug HTTP server tracing lookups
+	DebugConfig dnsserver.DebugConfig
+	// HealthConfig configures the self checks reporting the health of the
+	// instance, e.g. to withdraw its anycast route
+	HealthConfig dnsserver.HealthConfig
+	// RPZConfig configures the response policy zone applied before DB lookups
+	RPZConfig RPZConfig
+	// OverridesConfig configures the temporary overrides applied before the
+	// response policy zone and DB lookups
+	OverridesConfig OverridesConfig
+	// DebugZone is the zone answering whoami queries with the map and
+	// location matched for the client, see whoami.NewDebugZone
+	DebugZone string
+	// ChecksumName is the name answering CH TXT queries with the checksum of
+	// the DB in use, see dnsdata.Checksum
+	ChecksumName string
+	// VersionName is the name answering CH TXT queries with the version of
+	// the dataset in use, see dnsserver.FBDNSDB.DatasetVersion
+	VersionName string
+	// VersionOption, if set, is the EDNS0 local option code, between 65001
+	// and 65534, which queries carry to get the version of the dataset in use
+	// in the same option of responses
+	VersionOption int
+	// ClientErrorsConfig configures the counting of client errors per
+	// source subnet
+	ClientErrorsConfig ClientErrorsConfig
+	// UDPPacingConfig configures the pacing of UDP responses per destination
+	UDPPacingConfig UDPPacingConfig
 }
 
 type ipAns map[string]int
@@ -63,6 +94,17 @@ func (ipans ipAns) String() string {
 	return strings.Join(vals, ",")
 }
 
+// Values returns the "IP,maxAns" values setting ipAns, sorted, so that the
+// effective config can list them
+func (ipans ipAns) Values() []string {
+	vals := make([]string, 0, len(ipans))
+	for k, v := range ipans {
+		vals = append(vals, fmt.Sprintf("%s,%d", k, v))
+	}
+	sort.Strings(vals)
+	return vals
+}
+
 // Support setting ipAns with only "IP" or "IP,maxAns"
 func (ipans ipAns) Set(v string) error {
 	ipAnsSpt := strings.Split(v, ",")
@@ -0,0 +1,72 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+// fileReloader loads a file, then reloads it whenever its modification time
+// changes, keeping what was loaded if the new version fails to load.
+type fileReloader struct {
+	path string
+	// load loads the file, and only switches to it if it succeeds
+	load func() error
+	// name names the file in logs and in the DNS_<name>.reload_error counter
+	name    string
+	stats   stats.Stats
+	modTime time.Time
+}
+
+// reload loads the file and records its modification time
+func (r *fileReloader) reload() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	if err := r.load(); err != nil {
+		return err
+	}
+	r.modTime = info.ModTime()
+	return nil
+}
+
+// run checks the file for changes every interval and reloads it, until done
+// is closed
+func (r *fileReloader) run(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(r.path)
+		if err != nil || info.ModTime().Equal(r.modTime) {
+			continue
+		}
+		if err := r.reload(); err != nil {
+			glog.Errorf("Failed to reload %s %s: %v", r.name, r.path, err)
+			r.stats.IncrementCounter("DNS_" + r.name + ".reload_error")
+			// retry on the next change only
+			r.modTime = info.ModTime()
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+func TestFileReloader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, []byte("1"), 0o600))
+	var loaded atomic.Value
+	load := func() error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if string(data) == "broken" {
+			return errors.New("broken")
+		}
+		loaded.Store(string(data))
+		return nil
+	}
+	counters := stats.NewCounters()
+	r := &fileReloader{path: path, load: load, name: "test", stats: counters}
+	require.NoError(t, r.reload())
+	require.Equal(t, "1", loaded.Load())
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		r.run(time.Millisecond, done)
+		close(stopped)
+	}()
+
+	require.NoError(t, os.WriteFile(path, []byte("2"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Hour)))
+	require.Eventually(t, func() bool { return loaded.Load() == "2" }, 5*time.Second, time.Millisecond)
+
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reloader still running after done was closed")
+	}
+
+	// a broken file keeps what was loaded
+	require.NoError(t, os.WriteFile(path, []byte("broken"), 0o600))
+	require.Error(t, r.reload())
+	require.Equal(t, "2", loaded.Load())
+}
@@ -40,7 +40,7 @@ func newMaxAnswerHandler(i int) (*maxAnswerHandler, error) {
 }
 
 func (mh *maxAnswerHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
-	ctx = dnsserver.WithMaxAnswer(ctx, mh.maxAnswer)
+	ctx = dnsserver.WithAnswerBudget(ctx, dnsserver.AnswerBudget{MaxAnswer: mh.maxAnswer})
 	return plugin.NextOrFailure(mh.Name(), mh.Next, ctx, w, r)
 }
 
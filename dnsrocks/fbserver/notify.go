@@ -0,0 +1,186 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+// NotifyReceiverConfig configures how inbound NOTIFY messages trigger DB
+// reloads.
+type NotifyReceiverConfig struct {
+	// Zones is a comma separated list of zones NOTIFY messages are accepted for
+	Zones string
+	// TSIGKeyFile is the path to the file holding the TSIG keys NOTIFY
+	// messages must be signed with, one `[algorithm:]name:secret` per line.
+	TSIGKeyFile string
+}
+
+// defaultTSIGAlgorithm is used for TSIG keys without algorithm
+const defaultTSIGAlgorithm = dns.HmacSHA256
+
+// tsigFudge is the allowed time difference, in seconds, on signed responses
+const tsigFudge = 300
+
+// loadTSIGKeys reads TSIG keys in the `[algorithm:]name:secret` format used by
+// `dig -y`, and returns the secrets and algorithms by key name.
+func loadTSIGKeys(path string) (secrets, algorithms map[string]string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	secrets = make(map[string]string)
+	algorithms = make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		algorithm := defaultTSIGAlgorithm
+		switch len(fields) {
+		case 2:
+		case 3:
+			algorithm = dns.Fqdn(strings.ToLower(fields[0]))
+			fields = fields[1:]
+		default:
+			return nil, nil, fmt.Errorf("invalid TSIG key line %q", line)
+		}
+		name := dns.CanonicalName(fields[0])
+		if _, ok := dns.IsDomainName(name); !ok || fields[1] == "" {
+			return nil, nil, fmt.Errorf("invalid TSIG key %q", fields[0])
+		}
+		secrets[name] = fields[1]
+		algorithms[name] = algorithm
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(secrets) == 0 {
+		return nil, nil, fmt.Errorf("no TSIG key found in %s", path)
+	}
+	return secrets, algorithms, nil
+}
+
+// notifyHandler answers NOTIFY messages (RFC 1996) signed with a known TSIG
+// key by triggering a DB reload. Other messages are passed to the next
+// handler.
+type notifyHandler struct {
+	zones      map[string]struct{}
+	secrets    map[string]string
+	algorithms map[string]string
+	reload     chan<- dnsserver.ReloadSignal
+	stats      stats.Stats
+	Next       plugin.Handler
+}
+
+// newNotifyHandler initialize a new notifyHandler sending reload signals to
+// reload.
+func newNotifyHandler(conf NotifyReceiverConfig, reload chan<- dnsserver.ReloadSignal, s stats.Stats) (*notifyHandler, error) {
+	zones := make(map[string]struct{})
+	for _, zone := range strings.Split(conf.Zones, ",") {
+		if zone = strings.TrimSpace(zone); zone != "" {
+			zones[normalizeZone(zone)] = struct{}{}
+		}
+	}
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("no NOTIFY zone configured")
+	}
+	if conf.TSIGKeyFile == "" {
+		return nil, fmt.Errorf("NOTIFY messages must be authenticated, but no TSIG key file configured")
+	}
+	secrets, algorithms, err := loadTSIGKeys(conf.TSIGKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TSIG keys: %w", err)
+	}
+	return &notifyHandler{
+		zones:      zones,
+		secrets:    secrets,
+		algorithms: algorithms,
+		reload:     reload,
+		stats:      s,
+	}, nil
+}
+
+func (h *notifyHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	if r.Opcode != dns.OpcodeNotify {
+		return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+	}
+	h.stats.IncrementCounter("DNS_notify.received")
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	rcode := h.handle(w, r)
+	m.Rcode = rcode
+	if tsig := r.IsTsig(); tsig != nil && rcode != dns.RcodeNotAuth {
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsigFudge, time.Now().Unix())
+	}
+	if err := w.WriteMsg(m); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	return rcode, nil
+}
+
+// handle checks a NOTIFY message and triggers the matching reload, returning
+// the response code.
+func (h *notifyHandler) handle(w dns.ResponseWriter, r *dns.Msg) int {
+	tsig := r.IsTsig()
+	if tsig == nil {
+		h.stats.IncrementCounter("DNS_notify.unsigned")
+		return dns.RcodeRefused
+	}
+	// the server verifies the signature of messages signed with known keys
+	if algorithm, ok := h.algorithms[dns.CanonicalName(tsig.Hdr.Name)]; !ok || algorithm != strings.ToLower(tsig.Algorithm) || w.TsigStatus() != nil {
+		glog.Errorf("Rejecting NOTIFY from %s: bad TSIG key %s", w.RemoteAddr(), tsig.Hdr.Name)
+		h.stats.IncrementCounter("DNS_notify.unauthorized")
+		return dns.RcodeNotAuth
+	}
+	q := r.Question[0]
+	zone := normalizeZone(q.Name)
+	if _, ok := h.zones[zone]; !ok || q.Qtype != dns.TypeSOA || q.Qclass != dns.ClassINET {
+		h.stats.IncrementCounter("DNS_notify.refused")
+		return dns.RcodeRefused
+	}
+
+	// only the current DB catches up: nothing in a NOTIFY message, even
+	// signed, selects another DB
+	select {
+	case h.reload <- *dnsserver.NewPartialReloadSignal():
+	default:
+		// a reload is in progress, the sender retries until acknowledged
+		h.stats.IncrementCounter("DNS_notify.busy")
+		return dns.RcodeServerFailure
+	}
+	glog.Infof("Reloading DB on NOTIFY for %s from %s", zone, w.RemoteAddr())
+	h.stats.IncrementCounter("DNS_notify.reload")
+	return dns.RcodeSuccess
+}
+
+func (h *notifyHandler) Name() string { return "notify" }
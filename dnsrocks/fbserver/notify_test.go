@@ -0,0 +1,135 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+const (
+	testTSIGKey    = "notify-key."
+	testTSIGSecret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+)
+
+func writeTSIGKeyFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "tsig.keys")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadTSIGKeys(t *testing.T) {
+	path := writeTSIGKeyFile(t, "# comment\n\nnotify-key:"+testTSIGSecret+"\nhmac-sha512:Other.Key.:b3RoZXI=\n")
+	secrets, algorithms, err := loadTSIGKeys(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{testTSIGKey: testTSIGSecret, "other.key.": "b3RoZXI="}, secrets)
+	require.Equal(t, map[string]string{testTSIGKey: dns.HmacSHA256, "other.key.": dns.HmacSHA512}, algorithms)
+
+	for _, content := range []string{"", "notify-key", "a:b:c:d", "notify-key:"} {
+		_, _, err = loadTSIGKeys(writeTSIGKeyFile(t, content))
+		require.Error(t, err, content)
+	}
+	_, _, err = loadTSIGKeys(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
+
+func TestNewNotifyHandler(t *testing.T) {
+	reload := make(chan dnsserver.ReloadSignal)
+	keyFile := writeTSIGKeyFile(t, "notify-key:"+testTSIGSecret)
+	_, err := newNotifyHandler(NotifyReceiverConfig{TSIGKeyFile: keyFile}, reload, &stats.DummyStats{})
+	require.Error(t, err)
+	_, err = newNotifyHandler(NotifyReceiverConfig{Zones: "example.com"}, reload, &stats.DummyStats{})
+	require.Error(t, err)
+	h, err := newNotifyHandler(NotifyReceiverConfig{Zones: "Example.com, example.net", TSIGKeyFile: keyFile}, reload, &stats.DummyStats{})
+	require.NoError(t, err)
+	require.Len(t, h.zones, 2)
+	require.Contains(t, h.zones, "example.com.")
+}
+
+// TestNotifyPartialReload checks that NOTIFY messages only make the current
+// DB catch up, whatever they carry
+func TestNotifyPartialReload(t *testing.T) {
+	reload := make(chan dnsserver.ReloadSignal, 1)
+	h, err := newNotifyHandler(NotifyReceiverConfig{Zones: "example.com", TSIGKeyFile: writeTSIGKeyFile(t, "notify-key:"+testTSIGSecret)}, reload, &stats.DummyStats{})
+	require.NoError(t, err)
+
+	m := new(dns.Msg)
+	m.SetNotify("example.com.")
+	txt, err := dns.NewRR(`example.com. 0 IN TXT "/data/new.cdb"`)
+	require.NoError(t, err)
+	m.Answer = []dns.RR{txt}
+	m.SetTsig(testTSIGKey, dns.HmacSHA256, tsigFudge, time.Now().Unix())
+	require.Equal(t, dns.RcodeSuccess, h.handle(&test.ResponseWriter{}, m))
+	require.Equal(t, *dnsserver.NewPartialReloadSignal(), <-reload)
+}
+
+// TestNotifyReceiver sends NOTIFY messages to a standalone UDP server.
+func TestNotifyReceiver(t *testing.T) {
+	config := makeTestServerConfig(false, false)
+	config.DBConfig.ReloadTimeout = time.Second
+	config.NotifyReceiverConfig = NotifyReceiverConfig{
+		Zones:       "example.com",
+		TSIGKeyFile: writeTSIGKeyFile(t, "notify-key:"+testTSIGSecret),
+	}
+	portMap, srv := makeTestServer(t, config)
+	defer srv.Shutdown()
+
+	notify := func(zone string, key, secret string) (*dns.Msg, error) {
+		c := &dns.Client{TsigSecret: map[string]string{key: secret}}
+		m := new(dns.Msg)
+		m.SetNotify(zone)
+		if key != "" {
+			m.SetTsig(key, dns.HmacSHA256, tsigFudge, time.Now().Unix())
+		}
+		r, _, err := c.Exchange(m, portMap["udp"])
+		return r, err
+	}
+
+	r, err := notify("example.com.", testTSIGKey, testTSIGSecret)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, r.Rcode)
+	require.Equal(t, dns.OpcodeNotify, r.Opcode)
+	// the client verified the signature of the response
+	require.NotNil(t, r.IsTsig())
+
+	r, err = notify("example.org.", testTSIGKey, testTSIGSecret)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeRefused, r.Rcode)
+
+	r, err = notify("example.com.", "", "")
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeRefused, r.Rcode)
+
+	r, err = notify("example.com.", testTSIGKey, "d3Jvbmd3cm9uZ3dyb25nd3Jvbmc=")
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeNotAuth, r.Rcode)
+	require.Nil(t, r.IsTsig())
+
+	// other queries are still answered
+	c := new(dns.Client)
+	m := new(dns.Msg)
+	m.SetQuestion("foo2.example.com.", dns.TypeA)
+	r, _, err = c.Exchange(m, portMap["udp"])
+	require.NoError(t, err)
+	require.NotEmpty(t, r.Answer)
+}
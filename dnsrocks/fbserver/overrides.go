@@ -0,0 +1,454 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+// OverridesConfig configures the temporary overrides applied to queries
+// before they are looked up in the DB, e.g. as incident mitigations which
+// can't wait for a data publish.
+type OverridesConfig struct {
+	// File is the path to the overrides, one `name type [from=prefix,...]
+	// action [ttl rdata]` rule per line, see loadOverrides
+	File string
+	// ReloadInterval controls how often File is checked for changes, 0 disables reloads
+	ReloadInterval time.Duration
+}
+
+// overrideAction is what an override does with matching queries
+type overrideAction int
+
+const (
+	overrideAnswer overrideAction = iota
+	overrideNXDOMAIN
+	overrideNODATA
+	overrideRefuse
+	overrideDrop
+)
+
+// overrideActions maps the actions to their name in overrides files, also
+// used in counters
+var overrideActions = map[string]overrideAction{
+	"answer":   overrideAnswer,
+	"nxdomain": overrideNXDOMAIN,
+	"nodata":   overrideNODATA,
+	"refuse":   overrideRefuse,
+	"drop":     overrideDrop,
+}
+
+// String returns the name of the action, as used in counters
+func (a overrideAction) String() string {
+	for name, action := range overrideActions {
+		if action == a {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// overrideRule is an override of the queries for a name
+type overrideRule struct {
+	// qtype is the type of the queries overridden, dns.TypeANY for all
+	qtype uint16
+	// prefix is the client subnet of the queries overridden, all if invalid
+	prefix netip.Prefix
+	action overrideAction
+	// records answered for overrideAnswer, their owner name is replaced with
+	// the query name
+	records []dns.RR
+}
+
+// matches tells whether r applies to a query of type qtype from client
+func (r *overrideRule) matches(qtype uint16, client netip.Addr) bool {
+	if r.qtype != dns.TypeANY && r.qtype != qtype {
+		return false
+	}
+	return !r.prefix.IsValid() || (client.IsValid() && r.prefix.Contains(client))
+}
+
+// precedence orders the rules matching a query: the longest client prefix
+// first, then the rule for the query type before the one for all types
+func (r *overrideRule) precedence() int {
+	p := 0
+	if r.prefix.IsValid() {
+		p = 2 * (r.prefix.Bits() + 1)
+	}
+	if r.qtype != dns.TypeANY {
+		p++
+	}
+	return p
+}
+
+// scope returns the ECS scope prefix length of the responses of r
+func (r *overrideRule) scope() uint8 {
+	if !r.prefix.IsValid() {
+		return 0
+	}
+	return uint8(r.prefix.Bits())
+}
+
+// answer returns the records of r, renamed to qname
+func (r *overrideRule) answer(qname string) []dns.RR {
+	answer := make([]dns.RR, 0, len(r.records))
+	for _, rr := range r.records {
+		rr = dns.Copy(rr)
+		rr.Header().Name = qname
+		answer = append(answer, rr)
+	}
+	return answer
+}
+
+// overrides are loaded override rules, by name
+type overrides struct {
+	exact map[string][]*overrideRule
+	// wildcard rules, by the name they are below, i.e. without "*."
+	wildcard map[string][]*overrideRule
+	rules    int
+}
+
+// loadOverrides reads the overrides file at path. Each line holds a
+// `name type [from=prefix,...] action [ttl rdata]` rule, e.g.
+// `www.example.com A from=192.0.2.0/24 answer 60 198.51.100.1`, where name
+// may start with `*.` to match the names below, type may be ANY to match
+// every type and action is one of answer, nxdomain, nodata, refuse or drop.
+// Answer rules with the same name, type and prefixes make up an RRset. Empty
+// lines and lines starting with # are ignored.
+func loadOverrides(path string) (*overrides, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	o := &overrides{
+		exact:    make(map[string][]*overrideRule),
+		wildcard: make(map[string][]*overrideRule),
+	}
+	// rules by name, type and prefix, to gather the records of answer rules
+	rules := make(map[string]*overrideRule)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, parsed, err := parseOverride(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		for _, rule := range parsed {
+			key := fmt.Sprintf("%s %d %s", name, rule.qtype, rule.prefix)
+			if existing, ok := rules[key]; ok {
+				if existing.action != overrideAnswer || rule.action != overrideAnswer {
+					return nil, fmt.Errorf("%s:%d: conflicting overrides for %s %s %s", path, line, name, dns.TypeToString[rule.qtype], rule.prefix)
+				}
+				existing.records = append(existing.records, rule.records...)
+				continue
+			}
+			rules[key] = rule
+			if wildcard, found := strings.CutPrefix(name, "*."); found {
+				o.wildcard[wildcard] = append(o.wildcard[wildcard], rule)
+			} else {
+				o.exact[name] = append(o.exact[name], rule)
+			}
+			o.rules++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// parseOverride parses an overrides file line, and returns the name and the
+// rules it holds, one per prefix
+func parseOverride(line string) (string, []*overrideRule, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return "", nil, fmt.Errorf("invalid override %q, must be 'name type [from=prefix,...] action [ttl rdata]'", line)
+	}
+	name := dns.CanonicalName(fields[0])
+	if _, ok := dns.IsDomainName(name); !ok {
+		return "", nil, fmt.Errorf("invalid override name %q", fields[0])
+	}
+	qtype, ok := dns.StringToType[strings.ToUpper(fields[1])]
+	if !ok {
+		return "", nil, fmt.Errorf("invalid override type %q", fields[1])
+	}
+	fields = fields[2:]
+	prefixes := []netip.Prefix{{}}
+	if from, found := strings.CutPrefix(fields[0], "from="); found {
+		prefixes = prefixes[:0]
+		for _, s := range strings.Split(from, ",") {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid override client subnet %q: %w", s, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+		}
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return "", nil, fmt.Errorf("missing override action in %q", line)
+	}
+	action, ok := overrideActions[strings.ToLower(fields[0])]
+	if !ok {
+		return "", nil, fmt.Errorf("invalid override action %q", fields[0])
+	}
+	var records []dns.RR
+	if action == overrideAnswer {
+		if qtype == dns.TypeANY {
+			return "", nil, fmt.Errorf("cannot answer ANY overrides")
+		}
+		if len(fields) < 3 {
+			return "", nil, fmt.Errorf("missing TTL or data of answer override in %q", line)
+		}
+		ttl, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid override TTL %q: %w", fields[1], err)
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, ttl, dns.TypeToString[qtype], strings.Join(fields[2:], " ")))
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid override data: %w", err)
+		}
+		records = []dns.RR{rr}
+	} else if len(fields) > 1 {
+		return "", nil, fmt.Errorf("unexpected data after override action %s", fields[0])
+	}
+
+	rules := make([]*overrideRule, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		rules = append(rules, &overrideRule{qtype: qtype, prefix: prefix, action: action, records: records})
+	}
+	return name, rules, nil
+}
+
+// bestOverride returns the rule of rules with the highest precedence among
+// the ones matching a query of type qtype from client, nil if none does
+func bestOverride(rules []*overrideRule, qtype uint16, client netip.Addr) *overrideRule {
+	var best *overrideRule
+	for _, rule := range rules {
+		if rule.matches(qtype, client) && (best == nil || rule.precedence() > best.precedence()) {
+			best = rule
+		}
+	}
+	return best
+}
+
+// lookup returns the rule applying to a query for qname and qtype from
+// client, nil if none does. Exact names take precedence over wildcard ones,
+// and closer wildcards over farther ones.
+func (o *overrides) lookup(qname string, qtype uint16, client netip.Addr) *overrideRule {
+	qname = dns.CanonicalName(qname)
+	if rule := bestOverride(o.exact[qname], qtype, client); rule != nil {
+		return rule
+	}
+	for off, end := dns.NextLabel(qname, 0); !end; off, end = dns.NextLabel(qname, off) {
+		if rule := bestOverride(o.wildcard[qname[off:]], qtype, client); rule != nil {
+			return rule
+		}
+	}
+	return nil
+}
+
+// minScope returns the lowest ECS scope prefix length of the responses to
+// queries for qname and qtype from the client subnet source. When rules with
+// client subnets apply to qname and qtype, whatever the response, it only
+// holds for source and for the most specific of these rules overlapping it,
+// so that resolvers don't cache it for the clients of other rules. It is 0
+// otherwise.
+func (o *overrides) minScope(qname string, qtype uint16, source netip.Prefix) uint8 {
+	qname = dns.CanonicalName(qname)
+	scope, subnets := 0, false
+	check := func(rules []*overrideRule) {
+		for _, rule := range rules {
+			if !rule.prefix.IsValid() || (rule.qtype != dns.TypeANY && rule.qtype != qtype) {
+				continue
+			}
+			subnets = true
+			if rule.prefix.Overlaps(source) {
+				scope = max(scope, rule.prefix.Bits())
+			}
+		}
+	}
+	check(o.exact[qname])
+	for off, end := dns.NextLabel(qname, 0); !end; off, end = dns.NextLabel(qname, off) {
+		check(o.wildcard[qname[off:]])
+	}
+	if !subnets {
+		return 0
+	}
+	return uint8(max(scope, source.Bits())) //nolint:gosec
+}
+
+// overridesHandler applies the rules of an overrides file to queries,
+// answering matching ones itself and passing the others to the next handler.
+type overridesHandler struct {
+	conf      OverridesConfig
+	overrides atomic.Pointer[overrides]
+	stats     stats.Stats
+	Next      plugin.Handler
+}
+
+// newOverridesHandler initialize a new overridesHandler with the overrides
+// file of conf, reloaded on changes until done is closed
+func newOverridesHandler(conf OverridesConfig, s stats.Stats, done <-chan struct{}) (*overridesHandler, error) {
+	h := &overridesHandler{conf: conf, stats: s}
+	reloader := &fileReloader{path: conf.File, load: h.load, name: "overrides", stats: s}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	if conf.ReloadInterval > 0 {
+		go reloader.run(conf.ReloadInterval, done)
+	}
+	return h, nil
+}
+
+// load (re)loads the overrides file, keeping the current overrides if the
+// new ones fail to load
+func (h *overridesHandler) load() error {
+	o, err := loadOverrides(h.conf.File)
+	if err != nil {
+		return err
+	}
+	h.overrides.Store(o)
+	h.stats.ResetCounterTo("DNS_overrides.rules", int64(o.rules))
+	glog.Infof("Loaded %d overrides from %s", o.rules, h.conf.File)
+	return nil
+}
+
+// ecsSource returns the client subnet of ecs
+func ecsSource(ecs *dns.EDNS0_SUBNET) netip.Prefix {
+	addr, _ := netip.AddrFromSlice(ecs.Address)
+	prefix, _ := addr.Unmap().Prefix(int(ecs.SourceNetmask))
+	return prefix
+}
+
+// scopeWriter raises the ECS scope prefix length of the responses it writes
+// to at least scope
+type scopeWriter struct {
+	dns.ResponseWriter
+	scope uint8
+}
+
+// WriteMsg writes m, with a copy of its ECS option scoped to at least scope
+func (w *scopeWriter) WriteMsg(m *dns.Msg) error {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return w.ResponseWriter.WriteMsg(m)
+	}
+	for i, o := range opt.Option {
+		ecs, ok := o.(*dns.EDNS0_SUBNET)
+		if !ok || ecs.SourceScope >= w.scope {
+			continue
+		}
+		// m may be cached, leave it untouched
+		m = m.Copy()
+		scoped := *ecs
+		scoped.SourceScope = w.scope
+		m.IsEdns0().Option[i] = &scoped
+		break
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// ConnectionState forwards the TLS state of the underlying writer, if any.
+func (w *scopeWriter) ConnectionState() *tls.ConnectionState {
+	if cs, ok := w.ResponseWriter.(dns.ConnectionStater); ok {
+		return cs.ConnectionState()
+	}
+	return nil
+}
+
+// overrideClient returns the address queries are matched against: the
+// client subnet of ecs if set, the resolver IP otherwise
+func overrideClient(state request.Request, ecs *dns.EDNS0_SUBNET) netip.Addr {
+	if ecs != nil {
+		addr, _ := netip.AddrFromSlice(ecs.Address)
+		return addr.Unmap()
+	}
+	addr, _ := netip.ParseAddr(state.IP())
+	return addr.Unmap()
+}
+
+func (h *overridesHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	q := r.Question[0]
+	ecs := db.FindECS(r)
+	o := h.overrides.Load()
+	rule := o.lookup(q.Name, q.Qtype, overrideClient(request.Request{W: w, Req: r}, ecs))
+	var minScope uint8
+	if ecs != nil {
+		minScope = o.minScope(q.Name, q.Qtype, ecsSource(ecs))
+	}
+	if rule == nil {
+		if minScope > 0 {
+			// the DB answer doesn't hold for the clients of the rules
+			w = &scopeWriter{ResponseWriter: w, scope: minScope}
+		}
+		return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+	}
+	h.stats.IncrementCounter("DNS_overrides." + rule.action.String())
+	if rule.action == overrideDrop {
+		// no response at all, as if the query was lost
+		return dns.RcodeSuccess, nil
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	switch rule.action {
+	case overrideAnswer:
+		m.Answer = rule.answer(q.Name)
+	case overrideNXDOMAIN:
+		m.Rcode = dns.RcodeNameError
+	case overrideRefuse:
+		m.Rcode = dns.RcodeRefused
+		m.Authoritative = false
+	}
+	if opt := r.IsEdns0(); opt != nil {
+		m.SetEdns0(opt.UDPSize(), opt.Do())
+		if ecs != nil {
+			// the response holds for the whole subnet of the rule, unless
+			// other rules apply to part of it
+			scoped := *ecs
+			scoped.SourceScope = max(rule.scope(), minScope)
+			m.IsEdns0().Option = append(m.IsEdns0().Option, &scoped)
+		}
+	}
+	if err := w.WriteMsg(m); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	return m.Rcode, nil
+}
+
+func (h *overridesHandler) Name() string { return "overrides" }
@@ -0,0 +1,239 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+)
+
+const testOverrides = `# incident 42
+www.example.com A answer 60 198.51.100.1
+www.example.com A answer 60 198.51.100.2
+www.example.com A from=192.0.2.0/24,2001:db8::/32 answer 30 198.51.100.3
+www.example.com ANY from=192.0.2.128/25 refuse
+
+*.bad.example.com ANY nxdomain
+*.example.org ANY drop
+api.example.org AAAA nodata
+`
+
+func writeOverrides(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "overrides")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadOverrides(t *testing.T) {
+	o, err := loadOverrides(writeOverrides(t, testOverrides))
+	require.NoError(t, err)
+	require.Equal(t, 7, o.rules)
+	require.Len(t, o.exact, 2)
+	require.Len(t, o.wildcard, 2)
+
+	testCases := []struct {
+		qname  string
+		qtype  uint16
+		client string
+		action overrideAction
+		scope  uint8
+	}{
+		{qname: "www.example.com.", qtype: dns.TypeA, client: "203.0.113.1", action: overrideAnswer},
+		{qname: "WWW.example.com.", qtype: dns.TypeA, client: "192.0.2.1", action: overrideAnswer, scope: 24},
+		{qname: "www.example.com.", qtype: dns.TypeA, client: "2001:db8::1", action: overrideAnswer, scope: 32},
+		{qname: "www.example.com.", qtype: dns.TypeA, client: "192.0.2.129", action: overrideRefuse, scope: 25},
+		{qname: "www.example.com.", qtype: dns.TypeMX, client: "192.0.2.129", action: overrideRefuse, scope: 25},
+		{qname: "a.b.bad.example.com.", qtype: dns.TypeTXT, client: "203.0.113.1", action: overrideNXDOMAIN},
+		{qname: "api.example.org.", qtype: dns.TypeAAAA, client: "203.0.113.1", action: overrideNODATA},
+		{qname: "api.example.org.", qtype: dns.TypeA, client: "203.0.113.1", action: overrideDrop},
+	}
+	for _, tc := range testCases {
+		rule := o.lookup(tc.qname, tc.qtype, netip.MustParseAddr(tc.client))
+		require.NotNil(t, rule, tc.qname)
+		require.Equal(t, tc.action, rule.action, tc)
+		require.Equal(t, tc.scope, rule.scope(), tc)
+	}
+	require.Len(t, o.lookup("www.example.com.", dns.TypeA, netip.MustParseAddr("203.0.113.1")).records, 2)
+	for _, qname := range []string{"example.com.", "bad.example.com.", "example.org."} {
+		require.Nil(t, o.lookup(qname, dns.TypeA, netip.MustParseAddr("203.0.113.1")), qname)
+	}
+	require.Nil(t, o.lookup("www.example.com.", dns.TypeAAAA, netip.MustParseAddr("203.0.113.1")))
+
+	scopeCases := []struct {
+		qname  string
+		qtype  uint16
+		source string
+		scope  uint8
+	}{
+		{qname: "www.example.com.", qtype: dns.TypeA, source: "203.0.113.0/24", scope: 24},
+		{qname: "www.example.com.", qtype: dns.TypeA, source: "192.0.2.0/24", scope: 25},
+		{qname: "www.example.com.", qtype: dns.TypeA, source: "192.0.0.0/16", scope: 25},
+		{qname: "www.example.com.", qtype: dns.TypeA, source: "2001:db8::/48", scope: 48},
+		{qname: "www.example.com.", qtype: dns.TypeAAAA, source: "192.0.2.0/26", scope: 26},
+		{qname: "api.example.org.", qtype: dns.TypeAAAA, source: "192.0.2.0/24", scope: 0},
+		{qname: "www.example.net.", qtype: dns.TypeA, source: "192.0.2.0/24", scope: 0},
+	}
+	for _, tc := range scopeCases {
+		require.Equal(t, tc.scope, o.minScope(tc.qname, tc.qtype, netip.MustParsePrefix(tc.source)), tc)
+	}
+}
+
+func TestLoadOverridesErrors(t *testing.T) {
+	for _, content := range []string{
+		"www.example.com A\n",
+		"www.example.com BOGUS nxdomain\n",
+		"www..example.com A nxdomain\n",
+		"www.example.com A from=192.0.2.0/33 nxdomain\n",
+		"www.example.com A from=192.0.2.0/24\n",
+		"www.example.com A block\n",
+		"www.example.com A answer 60\n",
+		"www.example.com A answer 60s 192.0.2.1\n",
+		"www.example.com A answer 60 not-an-ip\n",
+		"www.example.com ANY answer 60 192.0.2.1\n",
+		"www.example.com A nxdomain 60\n",
+		"www.example.com A nxdomain\nwww.example.com A answer 60 192.0.2.1\n",
+	} {
+		_, err := loadOverrides(writeOverrides(t, content))
+		require.Error(t, err, content)
+	}
+	_, err := loadOverrides(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
+
+func TestOverridesHandler(t *testing.T) {
+	counters := stats.NewCounters()
+	h, err := newOverridesHandler(OverridesConfig{File: writeOverrides(t, testOverrides)}, counters, nil)
+	require.NoError(t, err)
+	nextCalled := 0
+	h.Next = plugin.HandlerFunc(func(_ context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		nextCalled++
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if opt := r.IsEdns0(); opt != nil {
+			// echo the client subnet unscoped, as for a response which
+			// doesn't depend on it
+			m.SetEdns0(opt.UDPSize(), opt.Do())
+			for _, o := range opt.Option {
+				if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+					m.IsEdns0().Option = append(m.IsEdns0().Option, ecs)
+				}
+			}
+		}
+		return dns.RcodeSuccess, w.WriteMsg(m)
+	})
+
+	serve := func(qname string, qtype uint16, remoteIP string, ecs *dns.EDNS0_SUBNET) *dnstest.Recorder {
+		req := new(dns.Msg)
+		req.SetQuestion(qname, qtype)
+		if ecs != nil {
+			req.SetEdns0(4096, false)
+			req.IsEdns0().Option = append(req.IsEdns0().Option, ecs)
+		}
+		rec := dnstest.NewRecorder(&test.ResponseWriterCustomRemote{RemoteIP: remoteIP})
+		_, err := h.ServeDNS(context.TODO(), rec, req)
+		require.NoError(t, err)
+		return rec
+	}
+
+	rec := serve("www.example.com.", dns.TypeA, "203.0.113.1", nil)
+	require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+	require.True(t, rec.Msg.Authoritative)
+	require.Len(t, rec.Msg.Answer, 2)
+	require.Nil(t, rec.Msg.IsEdns0())
+
+	// the client subnet wins over the resolver IP, and scopes the response,
+	// narrower than the rule as another one refuses part of the subnet
+	ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("192.0.2.0").To4()}
+	rec = serve("www.example.com.", dns.TypeA, "203.0.113.1", ecs)
+	require.Len(t, rec.Msg.Answer, 1)
+	require.Equal(t, "198.51.100.3", rec.Msg.Answer[0].(*dns.A).A.String())
+	require.Equal(t, uint32(30), rec.Msg.Answer[0].Header().Ttl)
+	opt := rec.Msg.IsEdns0()
+	require.NotNil(t, opt)
+	require.Len(t, opt.Option, 1)
+	require.Equal(t, uint8(25), opt.Option[0].(*dns.EDNS0_SUBNET).SourceScope)
+
+	// the default answer doesn't hold for the clients of the other rules
+	ecs = &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("203.0.113.0").To4()}
+	rec = serve("www.example.com.", dns.TypeA, "203.0.113.1", ecs)
+	require.Len(t, rec.Msg.Answer, 2)
+	require.Equal(t, uint8(24), rec.Msg.IsEdns0().Option[0].(*dns.EDNS0_SUBNET).SourceScope)
+
+	rec = serve("www.example.com.", dns.TypeA, "192.0.2.200", nil)
+	require.Equal(t, dns.RcodeRefused, rec.Msg.Rcode)
+	require.False(t, rec.Msg.Authoritative)
+
+	rec = serve("www.bad.example.com.", dns.TypeA, "203.0.113.1", nil)
+	require.Equal(t, dns.RcodeNameError, rec.Msg.Rcode)
+	require.Empty(t, rec.Msg.Answer)
+
+	rec = serve("api.example.org.", dns.TypeAAAA, "203.0.113.1", nil)
+	require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+	require.Empty(t, rec.Msg.Answer)
+
+	rec = serve("www.example.org.", dns.TypeA, "203.0.113.1", nil)
+	require.Nil(t, rec.Msg)
+
+	require.Equal(t, 0, nextCalled)
+	serve("www.example.com.", dns.TypeAAAA, "203.0.113.1", nil)
+	serve("www.example.net.", dns.TypeA, "203.0.113.1", nil)
+	require.Equal(t, 2, nextCalled)
+
+	// passed on responses are scoped when rules with subnets apply to the
+	// name and type, and left alone otherwise
+	ecs = &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 16, Address: net.ParseIP("192.0.0.0").To4()}
+	rec = serve("www.example.com.", dns.TypeAAAA, "203.0.113.1", ecs)
+	require.Equal(t, uint8(25), rec.Msg.IsEdns0().Option[0].(*dns.EDNS0_SUBNET).SourceScope)
+	rec = serve("www.example.net.", dns.TypeA, "203.0.113.1", ecs)
+	require.Equal(t, uint8(0), rec.Msg.IsEdns0().Option[0].(*dns.EDNS0_SUBNET).SourceScope)
+	require.Equal(t, uint8(0), ecs.SourceScope)
+	require.Equal(t, 4, nextCalled)
+
+	require.Equal(t, int64(3), counters["DNS_overrides.answer"])
+	require.Equal(t, int64(1), counters["DNS_overrides.refuse"])
+	require.Equal(t, int64(1), counters["DNS_overrides.nxdomain"])
+	require.Equal(t, int64(1), counters["DNS_overrides.nodata"])
+	require.Equal(t, int64(1), counters["DNS_overrides.drop"])
+	require.Equal(t, int64(7), counters["DNS_overrides.rules"])
+}
+
+func TestOverridesHandlerReload(t *testing.T) {
+	path := writeOverrides(t, testOverrides)
+	h, err := newOverridesHandler(OverridesConfig{File: path}, &stats.DummyStats{}, nil)
+	require.NoError(t, err)
+	client := netip.MustParseAddr("203.0.113.1")
+	require.NotNil(t, h.overrides.Load().lookup("www.example.com.", dns.TypeA, client))
+
+	require.NoError(t, os.WriteFile(path, []byte("new.example.com ANY drop\n"), 0o600))
+	require.NoError(t, h.load())
+	require.Nil(t, h.overrides.Load().lookup("www.example.com.", dns.TypeA, client))
+	require.NotNil(t, h.overrides.Load().lookup("new.example.com.", dns.TypeA, client))
+
+	// a broken file keeps the current overrides
+	require.NoError(t, os.WriteFile(path, []byte("broken\n"), 0o600))
+	require.Error(t, h.load())
+	require.NotNil(t, h.overrides.Load().lookup("new.example.com.", dns.TypeA, client))
+}
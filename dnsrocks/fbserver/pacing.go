@@ -0,0 +1,252 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+// UDP pacing defaults
+const (
+	DefaultUDPPacingMaxDelay        = 50 * time.Millisecond
+	DefaultUDPPacingMaxDestinations = 10000
+)
+
+// udpPacingSweepInterval is the shortest interval between two sweeps of the
+// idle destinations, when as many destinations as allowed are paced
+const udpPacingSweepInterval = time.Second
+
+// UDPPacingConfig configures the pacing of UDP responses per destination
+// address, so that bursts of large responses to a single client, e.g. a
+// resolver asking for many delegations with glue, are spread over time
+// instead of overflowing socket buffers along the way and getting dropped.
+type UDPPacingConfig struct {
+	// Rate is the number of response bytes per second sent to a destination
+	// without delay. Pacing is disabled if 0.
+	Rate int
+	// Burst is the number of response bytes sent to a destination at once
+	// before responses are delayed, Rate if 0
+	Burst int
+	// MaxDelay is the longest responses are delayed, DefaultUDPPacingMaxDelay
+	// if 0. Responses which would wait longer are replaced by empty truncated
+	// responses, so that clients retry over TCP.
+	MaxDelay time.Duration
+	// MaxDestinations is the number of destinations paced at once,
+	// DefaultUDPPacingMaxDestinations if 0. Responses to further destinations
+	// are sent without delay until idle destinations are forgotten.
+	MaxDestinations int
+}
+
+// pacingBucket is the token bucket of a destination, in bytes
+type pacingBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// udpPacer paces UDP responses per destination address with token buckets,
+// counting what it does under DNS_udp_pacing.<action>
+type udpPacer struct {
+	stats           stats.Stats
+	rate            float64
+	burst           float64
+	maxDelay        time.Duration
+	maxDestinations int
+	// now and afterFunc are time.Now and time.AfterFunc, but for tests
+	now       func() time.Time
+	afterFunc func(time.Duration, func()) *time.Timer
+
+	// mu protects buckets and lastSweep
+	mu        sync.Mutex
+	buckets   map[netip.Addr]*pacingBucket
+	lastSweep time.Time
+}
+
+// newUDPPacer validates c and returns the matching udpPacer, or nil when
+// pacing is disabled
+func newUDPPacer(c UDPPacingConfig, s stats.Stats) (*udpPacer, error) {
+	if c.Rate == 0 {
+		return nil, nil
+	}
+	if c.Burst == 0 {
+		c.Burst = c.Rate
+	}
+	if c.MaxDelay == 0 {
+		c.MaxDelay = DefaultUDPPacingMaxDelay
+	}
+	if c.MaxDestinations == 0 {
+		c.MaxDestinations = DefaultUDPPacingMaxDestinations
+	}
+	if c.Rate < 0 || c.Burst < dns.MinMsgSize || c.MaxDelay < 0 || c.MaxDestinations < 0 {
+		return nil, fmt.Errorf("invalid UDP pacing config %+v", c)
+	}
+	return &udpPacer{
+		stats:           s,
+		rate:            float64(c.Rate),
+		burst:           float64(c.Burst),
+		maxDelay:        c.MaxDelay,
+		maxDestinations: c.MaxDestinations,
+		now:             time.Now,
+		afterFunc:       time.AfterFunc,
+		buckets:         make(map[netip.Addr]*pacingBucket),
+	}, nil
+}
+
+// refill adds the tokens earned by b since its last update
+func (p *udpPacer) refill(b *pacingBucket, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * p.rate
+		if b.tokens > p.burst {
+			b.tokens = p.burst
+		}
+		b.last = now
+	}
+}
+
+// sweep forgets the destinations whose bucket is full again, which are
+// paced as new ones
+func (p *udpPacer) sweep(now time.Time) {
+	p.lastSweep = now
+	for addr, b := range p.buckets {
+		p.refill(b, now)
+		if b.tokens >= p.burst {
+			delete(p.buckets, addr)
+		}
+	}
+}
+
+// reserve takes size bytes from the bucket of addr, and returns how long the
+// response must be delayed. It returns false, taking nothing, if the delay
+// would exceed MaxDelay.
+func (p *udpPacer) reserve(addr netip.Addr, size int) (time.Duration, bool) {
+	now := p.now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.buckets[addr]
+	if !ok {
+		if len(p.buckets) >= p.maxDestinations && now.Sub(p.lastSweep) >= udpPacingSweepInterval {
+			p.sweep(now)
+		}
+		if len(p.buckets) >= p.maxDestinations {
+			p.stats.IncrementCounter("DNS_udp_pacing.untracked")
+			return 0, true
+		}
+		b = &pacingBucket{tokens: p.burst, last: now}
+		p.buckets[addr] = b
+	}
+	p.refill(b, now)
+	tokens := b.tokens - float64(size)
+	if tokens >= 0 {
+		b.tokens = tokens
+		return 0, true
+	}
+	delay := time.Duration(-tokens / p.rate * float64(time.Second))
+	if delay > p.maxDelay {
+		return 0, false
+	}
+	b.tokens = tokens
+	return delay, true
+}
+
+// pacedWriter paces the responses written through it to addr
+type pacedWriter struct {
+	dns.Writer
+	addr netip.Addr
+	p    *udpPacer
+}
+
+// Write sends m right away if the bucket of the destination allows it, or
+// later, copying it, if it can wait. Otherwise, m is replaced by an empty
+// truncated response.
+func (pw *pacedWriter) Write(m []byte) (int, error) {
+	delay, ok := pw.p.reserve(pw.addr, len(m))
+	if !ok {
+		pw.p.stats.IncrementCounter("DNS_udp_pacing.truncated")
+		tc, err := truncatedResponse(m)
+		if err != nil {
+			return 0, fmt.Errorf("failed to truncate paced response: %w", err)
+		}
+		if _, err = pw.Writer.Write(tc); err != nil {
+			return 0, err
+		}
+		return len(m), nil
+	}
+	if delay == 0 {
+		return pw.Writer.Write(m)
+	}
+	pw.p.stats.IncrementCounter("DNS_udp_pacing.delayed")
+	pw.p.stats.AddSample("DNS_udp_pacing.delay_us", delay.Microseconds())
+	b := append([]byte(nil), m...)
+	pw.p.afterFunc(delay, func() {
+		if _, err := pw.Writer.Write(b); err != nil {
+			pw.p.stats.IncrementCounter("DNS_udp_pacing.write_error")
+		}
+	})
+	return len(m), nil
+}
+
+// truncatedResponse returns m with the TC bit set, and without records but
+// the OPT one
+func truncatedResponse(m []byte) ([]byte, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(m); err != nil {
+		return nil, err
+	}
+	msg.Truncated = true
+	msg.Answer, msg.Ns = nil, nil
+	var extra []dns.RR
+	if opt := msg.IsEdns0(); opt != nil {
+		extra = append(extra, opt)
+	}
+	msg.Extra = extra
+	return msg.Pack()
+}
+
+// destination returns the address responses written to w go to, and whether
+// it is a UDP one
+func destination(w dns.ResponseWriter) (netip.Addr, bool) {
+	a, ok := w.RemoteAddr().(*net.UDPAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	return a.AddrPort().Addr().Unmap(), true
+}
+
+// attach makes s pace its UDP responses, keeping the dns.DecorateWriter
+// already set, e.g. by clientErrors.attach: responses go through it once
+// their time has come.
+func (p *udpPacer) attach(s *dns.Server) {
+	next := s.DecorateWriter
+	s.DecorateWriter = func(w dns.Writer) dns.Writer {
+		rw, ok := w.(dns.ResponseWriter)
+		if next != nil {
+			w = next(w)
+		}
+		if !ok {
+			return w
+		}
+		addr, ok := destination(rw)
+		if !ok {
+			return w
+		}
+		return &pacedWriter{Writer: w, addr: addr, p: p}
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	coretest "github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+)
+
+// writesRecorder is a dns.Writer recording what is written through it
+type writesRecorder struct {
+	writes [][]byte
+}
+
+func (r *writesRecorder) Write(m []byte) (int, error) {
+	r.writes = append(r.writes, m)
+	return len(m), nil
+}
+
+// newTestUDPPacer returns a udpPacer whose clock only moves with the
+// returned function, and which runs delayed writes when it moves past them
+func newTestUDPPacer(t *testing.T, c UDPPacingConfig, s stats.Stats) (*udpPacer, func(time.Duration)) {
+	p, err := newUDPPacer(c, s)
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	type pending struct {
+		at time.Time
+		f  func()
+	}
+	var timers []pending
+	p.now = func() time.Time { return now }
+	p.afterFunc = func(d time.Duration, f func()) *time.Timer {
+		timers = append(timers, pending{at: now.Add(d), f: f})
+		return nil
+	}
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		left := timers[:0]
+		for _, tm := range timers {
+			if tm.at.After(now) {
+				left = append(left, tm)
+			} else {
+				tm.f()
+			}
+		}
+		timers = left
+	}
+	return p, advance
+}
+
+func TestNewUDPPacer(t *testing.T) {
+	p, err := newUDPPacer(UDPPacingConfig{}, stats.NewCounters())
+	require.NoError(t, err)
+	require.Nil(t, p)
+
+	p, err = newUDPPacer(UDPPacingConfig{Rate: 100000}, stats.NewCounters())
+	require.NoError(t, err)
+	require.Equal(t, float64(100000), p.burst)
+	require.Equal(t, DefaultUDPPacingMaxDelay, p.maxDelay)
+	require.Equal(t, DefaultUDPPacingMaxDestinations, p.maxDestinations)
+
+	for _, conf := range []UDPPacingConfig{
+		{Rate: -1},
+		{Rate: 100000, Burst: 100},
+		{Rate: 100000, MaxDelay: -time.Second},
+		{Rate: 100000, MaxDestinations: -1},
+	} {
+		_, err = newUDPPacer(conf, stats.NewCounters())
+		require.Error(t, err, "%+v", conf)
+	}
+}
+
+func TestUDPPacerReserve(t *testing.T) {
+	p, advance := newTestUDPPacer(t, UDPPacingConfig{Rate: 10000, Burst: 2000, MaxDelay: 100 * time.Millisecond}, stats.NewCounters())
+	client := netip.MustParseAddr("192.0.2.1")
+	other := netip.MustParseAddr("2001:db8::1")
+
+	// The burst goes out right away
+	delay, ok := p.reserve(client, 1500)
+	require.True(t, ok)
+	require.Zero(t, delay)
+	// Then responses wait for the bytes they lack
+	delay, ok = p.reserve(client, 1500)
+	require.True(t, ok)
+	require.Equal(t, 100*time.Millisecond, delay)
+	// Unless they would wait too long
+	_, ok = p.reserve(client, 100)
+	require.False(t, ok)
+	// Other destinations have their own bucket
+	delay, ok = p.reserve(other, 1500)
+	require.True(t, ok)
+	require.Zero(t, delay)
+
+	advance(100 * time.Millisecond)
+	delay, ok = p.reserve(client, 100)
+	require.True(t, ok)
+	require.Equal(t, 10*time.Millisecond, delay)
+}
+
+func TestUDPPacerMaxDestinations(t *testing.T) {
+	counters := stats.NewCounters()
+	p, advance := newTestUDPPacer(t, UDPPacingConfig{Rate: 10000, Burst: 1000, MaxDestinations: 1}, counters)
+	first := netip.MustParseAddr("192.0.2.1")
+	second := netip.MustParseAddr("192.0.2.2")
+
+	_, ok := p.reserve(first, 1000)
+	require.True(t, ok)
+	// The second destination is not paced while the first one is busy
+	for i := 0; i < 3; i++ {
+		delay, ok := p.reserve(second, 1000)
+		require.True(t, ok)
+		require.Zero(t, delay)
+	}
+	require.Equal(t, int64(3), counters.Snapshot()["DNS_udp_pacing.untracked"])
+
+	// Once the first one is idle, it is forgotten
+	advance(udpPacingSweepInterval)
+	_, ok = p.reserve(second, 1000)
+	require.True(t, ok)
+	require.Len(t, p.buckets, 1)
+	require.Contains(t, p.buckets, second)
+}
+
+func TestPacedWriter(t *testing.T) {
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeNS)
+	req.SetEdns0(4096, false)
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	for i := 0; i < 26; i++ {
+		rr, err := dns.NewRR(fmt.Sprintf("example.com. 3600 IN NS ns%d.example.net.", i))
+		require.NoError(t, err)
+		resp.Ns = append(resp.Ns, rr)
+	}
+	resp.SetEdns0(4096, false)
+	b, err := resp.Pack()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(b), dns.MinMsgSize)
+
+	// Each response takes 100ms worth of bytes, after the first one
+	counters := stats.NewCounters()
+	p, advance := newTestUDPPacer(t, UDPPacingConfig{Rate: 10 * len(b), Burst: len(b), MaxDelay: 250 * time.Millisecond}, counters)
+	r := &writesRecorder{}
+	w := &pacedWriter{Writer: r, addr: netip.MustParseAddr("192.0.2.1"), p: p}
+	write := func() {
+		n, err := w.Write(b)
+		require.NoError(t, err)
+		require.Equal(t, len(b), n)
+	}
+
+	write()
+	require.Len(t, r.writes, 1)
+	// The next ones wait in line
+	write()
+	write()
+	require.Len(t, r.writes, 1)
+	require.Equal(t, int64(2), counters.Snapshot()["DNS_udp_pacing.delayed"])
+
+	// Until they would wait too long, and an empty truncated response is
+	// sent instead
+	write()
+	require.Len(t, r.writes, 2)
+	require.Equal(t, int64(1), counters.Snapshot()["DNS_udp_pacing.truncated"])
+	tc := new(dns.Msg)
+	require.NoError(t, tc.Unpack(r.writes[1]))
+	require.True(t, tc.Truncated)
+	require.Empty(t, tc.Ns)
+	require.NotNil(t, tc.IsEdns0())
+	require.Equal(t, req.Question, tc.Question)
+
+	advance(100 * time.Millisecond)
+	require.Len(t, r.writes, 3)
+	advance(100 * time.Millisecond)
+	require.Len(t, r.writes, 4)
+	require.Equal(t, b, r.writes[3])
+}
+
+func TestUDPPacerAttach(t *testing.T) {
+	p, err := newUDPPacer(UDPPacingConfig{Rate: 10000}, stats.NewCounters())
+	require.NoError(t, err)
+	c, err := newClientErrors(ClientErrorsConfig{MaxSources: 1}, stats.NewCounters())
+	require.NoError(t, err)
+
+	s := &dns.Server{}
+	c.attach(s)
+	p.attach(s)
+
+	w := s.DecorateWriter(&test.ResponseWriterCustomRemote{RemoteIP: "::ffff:192.0.2.1"})
+	pw, ok := w.(*pacedWriter)
+	require.True(t, ok)
+	require.Equal(t, netip.MustParseAddr("192.0.2.1"), pw.addr)
+	// Responses go through the client errors counting once paced
+	require.IsType(t, &clientErrorsWriter{}, pw.Writer)
+
+	w = s.DecorateWriter(&test.ResponseWriter{ResponseWriter: coretest.ResponseWriter{TCP: true}})
+	require.IsType(t, &clientErrorsWriter{}, w)
+}
@@ -0,0 +1,302 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+)
+
+// RPZConfig configures the response policy zone applied to queries before
+// they are looked up in the DB.
+type RPZConfig struct {
+	// File is the path to the policy zone, in master file format with a SOA
+	// record at its origin. Only QNAME triggers are supported.
+	File string
+	// ReloadInterval controls how often File is checked for changes, 0 disables reloads
+	ReloadInterval time.Duration
+}
+
+// rpzAction is what a policy does with matching queries
+type rpzAction int
+
+const (
+	rpzNXDOMAIN rpzAction = iota
+	rpzNODATA
+	rpzDrop
+	rpzPassthru
+	rpzLocalData
+)
+
+// String returns the name of the action, as used in counters
+func (a rpzAction) String() string {
+	switch a {
+	case rpzNXDOMAIN:
+		return "nxdomain"
+	case rpzNODATA:
+		return "nodata"
+	case rpzDrop:
+		return "drop"
+	case rpzPassthru:
+		return "passthru"
+	case rpzLocalData:
+		return "local_data"
+	}
+	return "unknown"
+}
+
+// rpzPolicy is the policy of a trigger
+type rpzPolicy struct {
+	action rpzAction
+	// records answered for rpzLocalData, their owner name is replaced with the
+	// query name
+	records []dns.RR
+}
+
+// rpzZone is a loaded policy zone
+type rpzZone struct {
+	soa   *dns.SOA
+	exact map[string]*rpzPolicy
+	// wildcard policies, by the name they are below, i.e. without "*."
+	wildcard map[string]*rpzPolicy
+}
+
+// rpzTriggerSuffixes are the labels of the triggers other than QNAME ones
+var rpzTriggerSuffixes = []string{".rpz-client-ip.", ".rpz-ip.", ".rpz-nsdname.", ".rpz-nsip."}
+
+// loadRPZ reads the policy zone at path
+func loadRPZ(path string) (*rpzZone, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	z := &rpzZone{
+		exact:    make(map[string]*rpzPolicy),
+		wildcard: make(map[string]*rpzPolicy),
+	}
+	var (
+		origin  string
+		records = make(map[string][]dns.RR)
+		names   []string
+	)
+	zp := dns.NewZoneParser(f, "", path)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		name := dns.CanonicalName(rr.Header().Name)
+		if soa, isSOA := rr.(*dns.SOA); isSOA && z.soa == nil {
+			z.soa = soa
+			origin = name
+			continue
+		}
+		if origin == "" {
+			return nil, fmt.Errorf("%s: records before the SOA record", path)
+		}
+		if rr.Header().Rrtype == dns.TypeNS || rr.Header().Rrtype == dns.TypeSOA {
+			continue
+		}
+		if !dns.IsSubDomain(origin, name) || name == origin {
+			return nil, fmt.Errorf("%s: %s is out of zone %s", path, name, origin)
+		}
+		if _, ok := records[name]; !ok {
+			names = append(names, name)
+		}
+		records[name] = append(records[name], rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	if z.soa == nil {
+		return nil, fmt.Errorf("%s: no SOA record", path)
+	}
+
+	ignored := 0
+	for _, name := range names {
+		trigger := strings.TrimSuffix(name, origin)
+		if isOtherTrigger(trigger) {
+			ignored++
+			continue
+		}
+		policy, err := newRPZPolicy(records[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+		if strings.HasPrefix(trigger, "*.") {
+			z.wildcard[dns.Fqdn(strings.TrimPrefix(trigger, "*."))] = policy
+		} else {
+			z.exact[dns.Fqdn(trigger)] = policy
+		}
+	}
+	if ignored > 0 {
+		glog.Warningf("%s: ignored %d policies with unsupported triggers", path, ignored)
+	}
+	return z, nil
+}
+
+func isOtherTrigger(trigger string) bool {
+	for _, suffix := range rpzTriggerSuffixes {
+		if strings.HasSuffix("."+trigger, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// newRPZPolicy derives the policy from the records of a trigger
+func newRPZPolicy(records []dns.RR) (*rpzPolicy, error) {
+	if cname, ok := records[0].(*dns.CNAME); ok && len(records) == 1 {
+		switch dns.CanonicalName(cname.Target) {
+		case ".":
+			return &rpzPolicy{action: rpzNXDOMAIN}, nil
+		case "*.":
+			return &rpzPolicy{action: rpzNODATA}, nil
+		case "rpz-drop.":
+			return &rpzPolicy{action: rpzDrop}, nil
+		case "rpz-passthru.":
+			return &rpzPolicy{action: rpzPassthru}, nil
+		}
+		if strings.HasPrefix(cname.Target, "*.") || strings.HasPrefix(dns.CanonicalName(cname.Target), "rpz-") {
+			return nil, fmt.Errorf("unsupported policy CNAME %s", cname.Target)
+		}
+	}
+	for _, rr := range records {
+		if rr.Header().Rrtype == dns.TypeCNAME && len(records) > 1 {
+			return nil, fmt.Errorf("CNAME and other data")
+		}
+	}
+	return &rpzPolicy{action: rpzLocalData, records: records}, nil
+}
+
+// lookup returns the policy for qname, nil if none applies. Exact triggers
+// take precedence over wildcard ones, and closer wildcards over farther ones.
+func (z *rpzZone) lookup(qname string) *rpzPolicy {
+	qname = dns.CanonicalName(qname)
+	if policy, ok := z.exact[qname]; ok {
+		return policy
+	}
+	for off, end := dns.NextLabel(qname, 0); !end; off, end = dns.NextLabel(qname, off) {
+		if policy, ok := z.wildcard[qname[off:]]; ok {
+			return policy
+		}
+	}
+	return nil
+}
+
+// rpzHandler applies the policies of a response policy zone to queries,
+// answering matching ones itself and passing the others to the next handler.
+type rpzHandler struct {
+	conf  RPZConfig
+	zone  atomic.Pointer[rpzZone]
+	stats stats.Stats
+	Next  plugin.Handler
+}
+
+// newRPZHandler initialize a new rpzHandler with the policy zone of conf,
+// reloaded on changes until done is closed
+func newRPZHandler(conf RPZConfig, s stats.Stats, done <-chan struct{}) (*rpzHandler, error) {
+	h := &rpzHandler{conf: conf, stats: s}
+	reloader := &fileReloader{path: conf.File, load: h.load, name: "rpz", stats: s}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	if conf.ReloadInterval > 0 {
+		go reloader.run(conf.ReloadInterval, done)
+	}
+	return h, nil
+}
+
+// load (re)loads the policy zone, keeping the current policies if the new
+// ones fail to load
+func (h *rpzHandler) load() error {
+	zone, err := loadRPZ(h.conf.File)
+	if err != nil {
+		return err
+	}
+	h.zone.Store(zone)
+	h.stats.ResetCounterTo("DNS_rpz.policies", int64(len(zone.exact)+len(zone.wildcard)))
+	glog.Infof("Loaded %d RPZ policies from %s, serial %d", len(zone.exact)+len(zone.wildcard), h.conf.File, zone.soa.Serial)
+	return nil
+}
+
+func (h *rpzHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	zone := h.zone.Load()
+	q := r.Question[0]
+	policy := zone.lookup(q.Name)
+	if policy == nil {
+		return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+	}
+	h.stats.IncrementCounter("DNS_rpz." + policy.action.String())
+	switch policy.action {
+	case rpzPassthru:
+		return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+	case rpzDrop:
+		// no response at all, as if the query was lost
+		return dns.RcodeSuccess, nil
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	switch policy.action {
+	case rpzNXDOMAIN:
+		m.Rcode = dns.RcodeNameError
+		m.Ns = []dns.RR{zone.negativeSOA()}
+	case rpzNODATA:
+		m.Ns = []dns.RR{zone.negativeSOA()}
+	case rpzLocalData:
+		m.Answer = policy.answer(q)
+		if len(m.Answer) == 0 {
+			m.Ns = []dns.RR{zone.negativeSOA()}
+		}
+	}
+	if err := w.WriteMsg(m); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	return m.Rcode, nil
+}
+
+// negativeSOA returns the SOA record of the zone for negative answers, with
+// the negative caching TTL
+func (z *rpzZone) negativeSOA() dns.RR {
+	soa := dns.Copy(z.soa).(*dns.SOA)
+	soa.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+	return soa
+}
+
+// answer returns the local data records matching q, renamed to the query name
+func (p *rpzPolicy) answer(q dns.Question) []dns.RR {
+	var answer []dns.RR
+	for _, rr := range p.records {
+		t := rr.Header().Rrtype
+		if t != q.Qtype && t != dns.TypeCNAME && q.Qtype != dns.TypeANY {
+			continue
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Name = q.Name
+		answer = append(answer, rr)
+	}
+	return answer
+}
+
+func (h *rpzHandler) Name() string { return "rpz" }
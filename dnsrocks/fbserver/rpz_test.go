@@ -0,0 +1,171 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
+)
+
+const testRPZ = `$ORIGIN rpz.example.
+$TTL 300
+@ IN SOA ns.example. admin.example. 42 3600 600 86400 60
+@ IN NS ns.example.
+bad.example.com CNAME .
+*.bad.example.com CNAME .
+empty.example.com CNAME *.
+drop.example.com CNAME rpz-drop.
+*.example.org CNAME rpz-drop.
+ok.example.org CNAME rpz-passthru.
+local.example.com A 192.0.2.1
+local.example.com A 192.0.2.2
+local.example.com TXT "blocked"
+alias.example.com CNAME walled.example.com.
+32.1.2.0.192.rpz-ip CNAME .
+`
+
+func writeRPZ(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "rpz.zone")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadRPZ(t *testing.T) {
+	z, err := loadRPZ(writeRPZ(t, testRPZ))
+	require.NoError(t, err)
+	require.Equal(t, uint32(42), z.soa.Serial)
+	require.Len(t, z.exact, 6)
+	require.Len(t, z.wildcard, 2)
+
+	testCases := []struct {
+		qname  string
+		action rpzAction
+	}{
+		{qname: "bad.example.com.", action: rpzNXDOMAIN},
+		{qname: "www.Bad.example.com.", action: rpzNXDOMAIN},
+		{qname: "empty.example.com.", action: rpzNODATA},
+		{qname: "drop.example.com.", action: rpzDrop},
+		{qname: "www.example.org.", action: rpzDrop},
+		{qname: "ok.example.org.", action: rpzPassthru},
+		{qname: "local.example.com.", action: rpzLocalData},
+		{qname: "alias.example.com.", action: rpzLocalData},
+	}
+	for _, tc := range testCases {
+		policy := z.lookup(tc.qname)
+		require.NotNil(t, policy, tc.qname)
+		require.Equal(t, tc.action, policy.action, tc.qname)
+	}
+	for _, qname := range []string{"example.com.", "example.org.", "www.drop.example.com.", "rpz.example."} {
+		require.Nil(t, z.lookup(qname), qname)
+	}
+}
+
+func TestLoadRPZErrors(t *testing.T) {
+	for _, content := range []string{
+		"",
+		"bad.example.com. 300 CNAME .\n",
+		"rpz.example. 300 SOA ns.example. admin.example. 1 1 1 1 1\nbad.example.com. 300 CNAME .\n",
+		"rpz.example. 300 SOA ns.example. admin.example. 1 1 1 1 1\nbad.rpz.example. 300 CNAME rpz-tcp-only.\n",
+		"rpz.example. 300 SOA ns.example. admin.example. 1 1 1 1 1\nbad.rpz.example. 300 CNAME foo.\nbad.rpz.example. 300 A 192.0.2.1\n",
+		"rpz.example. 300 SOA ns.example. admin.example. 1 1 1 1 1\nbad.rpz.example. 300 IN A not-an-ip\n",
+	} {
+		_, err := loadRPZ(writeRPZ(t, content))
+		require.Error(t, err, content)
+	}
+	_, err := loadRPZ(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
+
+func TestRPZHandler(t *testing.T) {
+	counters := stats.NewCounters()
+	h, err := newRPZHandler(RPZConfig{File: writeRPZ(t, testRPZ)}, counters, nil)
+	require.NoError(t, err)
+	nextCalled := 0
+	h.Next = plugin.HandlerFunc(func(_ context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		nextCalled++
+		m := new(dns.Msg)
+		m.SetReply(r)
+		return dns.RcodeSuccess, w.WriteMsg(m)
+	})
+
+	serve := func(qname string, qtype uint16) *dnstest.Recorder {
+		req := new(dns.Msg)
+		req.SetQuestion(qname, qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err := h.ServeDNS(context.TODO(), rec, req)
+		require.NoError(t, err)
+		return rec
+	}
+
+	rec := serve("www.bad.example.com.", dns.TypeA)
+	require.Equal(t, dns.RcodeNameError, rec.Msg.Rcode)
+	require.Len(t, rec.Msg.Ns, 1)
+	require.Equal(t, uint32(60), rec.Msg.Ns[0].Header().Ttl)
+
+	rec = serve("empty.example.com.", dns.TypeA)
+	require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+	require.Empty(t, rec.Msg.Answer)
+	require.Len(t, rec.Msg.Ns, 1)
+
+	rec = serve("drop.example.com.", dns.TypeA)
+	require.Nil(t, rec.Msg)
+
+	rec = serve("local.example.com.", dns.TypeA)
+	require.Equal(t, dns.RcodeSuccess, rec.Msg.Rcode)
+	require.Len(t, rec.Msg.Answer, 2)
+	require.Equal(t, "local.example.com.", rec.Msg.Answer[0].Header().Name)
+	require.Equal(t, "192.0.2.1", rec.Msg.Answer[0].(*dns.A).A.String())
+
+	rec = serve("local.example.com.", dns.TypeAAAA)
+	require.Empty(t, rec.Msg.Answer)
+	require.Len(t, rec.Msg.Ns, 1)
+
+	rec = serve("alias.example.com.", dns.TypeA)
+	require.Len(t, rec.Msg.Answer, 1)
+	require.Equal(t, "walled.example.com.", rec.Msg.Answer[0].(*dns.CNAME).Target)
+
+	require.Equal(t, 0, nextCalled)
+	serve("ok.example.org.", dns.TypeA)
+	serve("www.example.net.", dns.TypeA)
+	require.Equal(t, 2, nextCalled)
+
+	require.Equal(t, int64(1), counters["DNS_rpz.nxdomain"])
+	require.Equal(t, int64(1), counters["DNS_rpz.nodata"])
+	require.Equal(t, int64(1), counters["DNS_rpz.drop"])
+	require.Equal(t, int64(3), counters["DNS_rpz.local_data"])
+	require.Equal(t, int64(1), counters["DNS_rpz.passthru"])
+	require.Equal(t, int64(8), counters["DNS_rpz.policies"])
+}
+
+func TestRPZHandlerReload(t *testing.T) {
+	path := writeRPZ(t, testRPZ)
+	h, err := newRPZHandler(RPZConfig{File: path}, &stats.DummyStats{}, nil)
+	require.NoError(t, err)
+	require.NotNil(t, h.zone.Load().lookup("bad.example.com."))
+
+	require.NoError(t, os.WriteFile(path, []byte("rpz.example. 300 SOA ns.example. admin.example. 43 1 1 1 1\nnew.rpz.example. 300 CNAME .\n"), 0o600))
+	require.NoError(t, h.load())
+	require.Nil(t, h.zone.Load().lookup("bad.example.com."))
+	require.NotNil(t, h.zone.Load().lookup("new."))
+}
@@ -16,6 +16,9 @@ package fbserver
 import (
 	"context"
 
+	"github.com/facebook/dns/dnsrocks/dnsserver"
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+
 	"github.com/coredns/coredns/plugin"
 	"github.com/golang/glog"
 	"github.com/miekg/dns"
@@ -25,14 +28,30 @@ import (
 // request through the plugin handler chain.
 type serveMux struct {
 	defaultHandler plugin.Handler
+	// questionCount is the policy answering queries without question,
+	// handlers answer the ones with more than one
+	questionCount string
+	stats         stats.Stats
+	// clientErrors counts EDNS violations when set
+	clientErrors *clientErrors
+	// maxUDPSize, if set, clamps the EDNS0 UDP buffer size of queries for
+	// the whole handler chain
+	maxUDPSize int
 }
 
 func (mux *serveMux) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	if mux.clientErrors != nil {
+		mux.clientErrors.checkEDNS(w, req)
+	}
 	if len(req.Question) < 1 {
-		dns.HandleFailed(w, req)
+		dnsserver.RejectQuestionCount(mux.questionCount, w, req, mux.stats)
 		return
 	}
-	ctx := context.TODO()
+	// handlers can tell how the query reached the server, e.g. over DoT
+	ctx := dnsserver.WithClientInfo(context.TODO(), dnsserver.NewClientInfo(w))
+	if mux.maxUDPSize > 0 {
+		ctx, req = dnsserver.ClampUDPSize(ctx, req, mux.maxUDPSize)
+	}
 	_, err := mux.defaultHandler.ServeDNS(ctx, w, req)
 	if err != nil {
 		glog.Errorf("%v", err)
@@ -15,22 +15,20 @@ package fbserver
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
-	"github.com/coredns/coredns/plugin/bufsize"
+	"github.com/coredns/coredns/request"
 	"github.com/golang/glog"
 	"github.com/miekg/dns"
-	"golang.org/x/sys/unix"
 
 	"github.com/facebook/dns/dnsrocks/db"
 	"github.com/facebook/dns/dnsrocks/dnsserver"
@@ -47,8 +45,16 @@ type Server struct {
 	conf            ServerConfig
 	db              *dnsserver.FBDNSDB
 	servers         []*dns.Server
+	tsigSecrets     map[string]string
 	stats           stats.Stats
 	metricsExporter anyMetricsExporter
+	debugServer     *dnsserver.DebugServer
+	healthChecker   *dnsserver.HealthChecker
+	clientErrors    *clientErrors
+	udpPacer        *udpPacer
+	// done is closed on shutdown, stopping the background tasks
+	done         chan struct{}
+	shutdownOnce sync.Once
 	// If NotifyStartedFunc is set it is called once the server has started listening.
 	NotifyStartedFunc func()
 
@@ -92,17 +98,37 @@ type anyMetricsExporter interface {
 	ConsumeStats(category string, stats *metrics.Stats) error
 }
 
-// NewServer start the server given a server config, a logger and a stat collector
+// NewServer start the server given a server config, a logger and a stat
+// collector, exiting if the DB can't be loaded
 func NewServer(conf ServerConfig, logger dnsserver.Logger, stats stats.Stats, metricsExporter anyMetricsExporter) *Server {
+	srv, err := New(conf, logger, stats, metricsExporter)
+	failOnErr(err, "Error creating server")
+	return srv
+}
+
+// New returns a server given a server config, a logger and a stat collector,
+// with its DB loaded. Servers are started with Start or Run.
+func New(conf ServerConfig, logger dnsserver.Logger, stats stats.Stats, metricsExporter anyMetricsExporter) (*Server, error) {
 	// if no ip provided, use the default wildcard.
 	if len(conf.IPAns) == 0 {
-		conf.IPAns[""] = 1
+		conf.IPAns = ipAns{"": 1}
 	}
 
 	tdb, err := dnsserver.NewFBDNSDB(conf.HandlerConfig, conf.DBConfig, conf.CacheConfig, logger, stats)
-	failOnErr(err, "Error creating TinyDB handle")
-	failOnErr(tdb.Load(), "Error loading TinyDB")
-	return &Server{conf: conf, db: tdb, stats: stats, metricsExporter: metricsExporter}
+	if err != nil {
+		return nil, fmt.Errorf("error creating TinyDB handle: %w", err)
+	}
+	if err = tdb.Load(); err != nil {
+		tdb.Close()
+		return nil, fmt.Errorf("error loading TinyDB: %w", err)
+	}
+	return &Server{conf: conf, db: tdb, stats: stats, metricsExporter: metricsExporter, done: make(chan struct{})}, nil
+}
+
+// DB returns the handler serving the queries from the DB, e.g. to resolve
+// queries without going through the listeners with QuerySingle
+func (srv *Server) DB() *dnsserver.FBDNSDB {
+	return srv.db
 }
 
 // monitoredReader is a wrapper around dns default reader which serves to log the number of "read"
@@ -149,6 +175,7 @@ func (srv *Server) initUDPServer(addr string, h dns.Handler) (*dns.Server, error
 		Net:        "udp",
 		PacketConn: pc,
 		Handler:    h,
+		TsigSecret: srv.tsigSecrets,
 	}, nil
 }
 
@@ -165,6 +192,7 @@ func (srv *Server) initTCPServer(addr string, h dns.Handler, s *metrics.Stats) (
 		Listener:       l,
 		Handler:        h,
 		DecorateReader: newMonitoredReader(l),
+		TsigSecret:     srv.tsigSecrets,
 	}, nil
 }
 
@@ -188,6 +216,7 @@ func (srv *Server) initTLSServer(ctx context.Context, addr string, h dns.Handler
 		TLSConfig:      tlsConf,
 		Handler:        h,
 		DecorateReader: newMonitoredReader(l),
+		TsigSecret:     srv.tsigSecrets,
 	}, nil
 }
 
@@ -210,10 +239,17 @@ func (srv *Server) Start() (err error) {
 		defaultHandler   plugin.Handler = srv.db
 		maxAnswerHandler *maxAnswerHandler
 		whoamiHandler    *whoami.Handler
+		debugZoneHandler *whoami.Handler
 		dotTLSAHandler   *dotTLSAHandler
 		anyHandler       *anyHandler
+		checksumHandler  *chaosTXTHandler
+		versionHandler   *chaosTXTHandler
+		optionHandler    *versionOptionHandler
 		nsidHandler      *nsid.Handler
+		notifyHandler    *notifyHandler
 		throttleHandler  *throttle.Handler
+		rpzHandler       *rpzHandler
+		overridesHandler *overridesHandler
 		throttleLimiter  *throttle.Limiter
 		numListeners     = srv.conf.ReusePort
 	)
@@ -223,6 +259,30 @@ func (srv *Server) Start() (err error) {
 		numListeners = 1
 	}
 
+	if srv.debugServer, err = dnsserver.NewDebugServer(srv.db, srv.conf.DebugConfig); err != nil {
+		return fmt.Errorf("failed to initialize debug server: %w", err)
+	}
+	if srv.debugServer != nil {
+		if err = srv.debugServer.Start(); err != nil {
+			return fmt.Errorf("failed to start debug server: %w", err)
+		}
+	}
+	if srv.healthChecker, err = dnsserver.NewHealthChecker(srv.db, srv.conf.HealthConfig); err != nil {
+		return fmt.Errorf("failed to initialize health checker: %w", err)
+	}
+	if srv.healthChecker != nil {
+		if err = srv.healthChecker.Start(); err != nil {
+			return fmt.Errorf("failed to start health checker: %w", err)
+		}
+	}
+
+	if srv.clientErrors, err = newClientErrors(srv.conf.ClientErrorsConfig, srv.stats); err != nil {
+		return fmt.Errorf("failed to initialize client errors counting: %w", err)
+	}
+	if srv.udpPacer, err = newUDPPacer(srv.conf.UDPPacingConfig, srv.stats); err != nil {
+		return fmt.Errorf("failed to initialize UDP pacing: %w", err)
+	}
+
 	// DNS connection stats
 	stats := metrics.NewStats()
 	err = srv.metricsExporter.ConsumeStats(connectionKey, stats)
@@ -234,10 +294,30 @@ func (srv *Server) Start() (err error) {
 		return srv.conf.TCPIdleTimeout
 	}
 
+	// Only add rpzHandler to the plugin chain if it is enabled. It goes first,
+	// so that policies apply to DB lookups only.
+	if srv.conf.RPZConfig.File != "" {
+		glog.Infof("Enabling RPZ handler with policies from %s", srv.conf.RPZConfig.File)
+		if rpzHandler, err = newRPZHandler(srv.conf.RPZConfig, srv.stats, srv.done); err != nil {
+			return fmt.Errorf("failed to initialize rpzHandler: %w", err)
+		}
+		rpzHandler.Next = defaultHandler
+		defaultHandler = rpzHandler
+	}
+	// Only add overridesHandler to the plugin chain if it is enabled. It goes
+	// right before rpzHandler, so that overrides win over policies.
+	if srv.conf.OverridesConfig.File != "" {
+		glog.Infof("Enabling overrides from %s", srv.conf.OverridesConfig.File)
+		if overridesHandler, err = newOverridesHandler(srv.conf.OverridesConfig, srv.stats, srv.done); err != nil {
+			return fmt.Errorf("failed to initialize overridesHandler: %w", err)
+		}
+		overridesHandler.Next = defaultHandler
+		defaultHandler = overridesHandler
+	}
 	if srv.conf.TLSConfig.DoTTLSAEnabled {
 		glog.Infof("Enabling DoTTLSAHandler")
 		if !srv.conf.TLS {
-			log.Fatalf("TLS is disabled yet are enabling DoTTLSAHandler. This is likely unexpected")
+			return errors.New("TLS is disabled yet are enabling DoTTLSAHandler. This is likely unexpected")
 		}
 		if dotTLSAHandler, err = newDotTLSA(&srv.conf.TLSConfig); err != nil {
 			return fmt.Errorf("failed to initialize dotTLSAHandler: %w", err)
@@ -258,6 +338,54 @@ func (srv *Server) Start() (err error) {
 	} else {
 		glog.Infof("-whoami-domain was not specified, not initializing whoamiHandler")
 	}
+	// Only add debugZoneHandler to the plugin chain if it is enabled.
+	if srv.conf.DebugZone != "" {
+		zone := strings.ToLower(dns.Fqdn(srv.conf.DebugZone))
+		glog.Infof("Enabling debug zone %s with privateInfo=%v", zone, srv.conf.PrivateInfo)
+		locate := func(qname string, state request.Request) (*db.Location, error) {
+			return srv.db.LocateClient(qname, db.FindECS(state.Req), state.IP())
+		}
+		if debugZoneHandler, err = whoami.NewDebugZone(zone, srv.conf.PrivateInfo, locate); err != nil {
+			return fmt.Errorf("failed to initialize debugZoneHandler: %w", err)
+		}
+		debugZoneHandler.Next = defaultHandler
+		defaultHandler = debugZoneHandler
+	}
+	// Only add checksumHandler to the plugin chain if it is enabled.
+	if srv.conf.ChecksumName != "" {
+		glog.Infof("Enabling checksum handler for %s", srv.conf.ChecksumName)
+		checksum := func() string {
+			sum := srv.db.Checksum()
+			if sum == nil {
+				return ""
+			}
+			text, _ := sum.MarshalText()
+			return string(text)
+		}
+		if checksumHandler, err = newChaosTXTHandler(srv.conf.ChecksumName, checksum); err != nil {
+			return fmt.Errorf("failed to initialize checksumHandler: %w", err)
+		}
+		checksumHandler.Next = defaultHandler
+		defaultHandler = checksumHandler
+	}
+	// Only add versionHandler to the plugin chain if it is enabled.
+	if srv.conf.VersionName != "" {
+		glog.Infof("Enabling dataset version handler for %s", srv.conf.VersionName)
+		if versionHandler, err = newChaosTXTHandler(srv.conf.VersionName, srv.db.DatasetVersion); err != nil {
+			return fmt.Errorf("failed to initialize versionHandler: %w", err)
+		}
+		versionHandler.Next = defaultHandler
+		defaultHandler = versionHandler
+	}
+	// Only add optionHandler to the plugin chain if it is enabled.
+	if srv.conf.VersionOption != 0 {
+		glog.Infof("Enabling dataset version in EDNS0 option %d", srv.conf.VersionOption)
+		if optionHandler, err = newVersionOptionHandler(srv.conf.VersionOption, srv.db.DatasetVersion); err != nil {
+			return fmt.Errorf("failed to initialize optionHandler: %w", err)
+		}
+		optionHandler.Next = defaultHandler
+		defaultHandler = optionHandler
+	}
 	// Only add anyHandler to the plugin chain if it is enabled.
 	if srv.conf.RefuseANY {
 		glog.Infof("Enabling ANY handler")
@@ -282,6 +410,17 @@ func (srv *Server) Start() (err error) {
 		glog.Info("-nsid was not specified, disabling NSID responses")
 	}
 
+	// Only add notifyHandler to the plugin chain if it is enabled.
+	if srv.conf.NotifyReceiverConfig.Zones != "" {
+		glog.Infof("Enabling NOTIFY handler for zones %s", srv.conf.NotifyReceiverConfig.Zones)
+		if notifyHandler, err = newNotifyHandler(srv.conf.NotifyReceiverConfig, srv.db.ReloadChan, srv.stats); err != nil {
+			return fmt.Errorf("failed to initialize notifyHandler: %w", err)
+		}
+		notifyHandler.Next = defaultHandler
+		defaultHandler = notifyHandler
+		srv.tsigSecrets = notifyHandler.secrets
+	}
+
 	// Share one limiter across all IPs.
 	if srv.conf.MaxConcurrency > 0 {
 		maxWorkers := srv.conf.MaxConcurrency * srv.conf.NumCPU
@@ -290,7 +429,7 @@ func (srv *Server) Start() (err error) {
 			return err
 		}
 
-		go throttle.Monitor(throttleLimiter, srv.stats, time.Second)
+		go throttle.Monitor(throttleLimiter, srv.stats, time.Second, srv.done)
 	}
 
 	// For each configured IP, we may start a number of DNS servers for each
@@ -303,6 +442,20 @@ func (srv *Server) Start() (err error) {
 		glog.Infof("Creating handler with VIP %s and max answer %d", ip, maxAns)
 		handler := &serveMux{
 			defaultHandler: maxAnswerHandler,
+			questionCount:  srv.conf.HandlerConfig.QuestionCount,
+			stats:          srv.stats,
+			clientErrors:   srv.clientErrors,
+		}
+
+		// the whole handler chain sizes its responses after the clamped
+		// EDNS0 buffer size, the DB handler logs the query as received
+		if srv.conf.MaxUDPSize >= dns.MinMsgSize && srv.conf.MaxUDPSize <= dns.MaxMsgSize {
+			glog.Infof("Limiting UDP response size to %d", srv.conf.MaxUDPSize)
+			handler.maxUDPSize = srv.conf.MaxUDPSize
+		} else if srv.conf.MaxUDPSize > 0 {
+			glog.Warningf("Ignoring non-compliant -max-udp-size: %d", srv.conf.MaxUDPSize)
+		} else {
+			glog.Infof("Max UDP size not set")
 		}
 
 		if srv.conf.DNSSECConfig.Zones != "" && srv.conf.DNSSECConfig.Keys != "" {
@@ -322,18 +475,6 @@ func (srv *Server) Start() (err error) {
 				srv.conf.DNSSECConfig.Keys)
 		}
 
-		if srv.conf.MaxUDPSize >= dns.MinMsgSize && srv.conf.MaxUDPSize <= dns.MaxMsgSize {
-			glog.Infof("Limiting UDP response size to %d", srv.conf.MaxUDPSize)
-			handler.defaultHandler = bufsize.Bufsize{
-				Size: srv.conf.MaxUDPSize,
-				Next: handler.defaultHandler,
-			}
-		} else if srv.conf.MaxUDPSize > 0 {
-			glog.Warningf("Ignoring non-compliant -max-udp-size: %d", srv.conf.MaxUDPSize)
-		} else {
-			glog.Infof("Max UDP size not set")
-		}
-
 		if throttleLimiter != nil {
 			throttleHandler = throttle.NewHandler(throttleLimiter)
 			throttleHandler.Next = handler.defaultHandler
@@ -353,6 +494,12 @@ func (srv *Server) Start() (err error) {
 			if throttleHandler != nil {
 				throttleHandler.Attach(s)
 			}
+			if srv.clientErrors != nil {
+				srv.clientErrors.attach(s)
+			}
+			if srv.udpPacer != nil {
+				srv.udpPacer.attach(s)
+			}
 			srv.servers = append(srv.servers, s)
 			// Server never calls Done() method, it only provides
 			// this wg for client to use.
@@ -377,6 +524,9 @@ func (srv *Server) Start() (err error) {
 				if throttleHandler != nil {
 					throttleHandler.Attach(s)
 				}
+				if srv.clientErrors != nil {
+					srv.clientErrors.attach(s)
+				}
 				srv.servers = append(srv.servers, s)
 				// Server never calls Done() method, it only provides
 				// this wg for client to use.
@@ -405,6 +555,9 @@ func (srv *Server) Start() (err error) {
 				if throttleHandler != nil {
 					throttleHandler.Attach(s)
 				}
+				if srv.clientErrors != nil {
+					srv.clientErrors.attach(s)
+				}
 				srv.servers = append(srv.servers, s)
 				// Server never calls Done() method, it only provides
 				// this wg for client to use.
@@ -423,8 +576,60 @@ func (srv *Server) Start() (err error) {
 	return nil
 }
 
-// Shutdown shuts down all the underlying servers and close the DB.
+// Addrs returns the addresses the servers listen on, by network (udp, tcp or
+// tcp-tls), once started. With several IPs, one of them is returned.
+func (srv *Server) Addrs() map[string]string {
+	m := make(map[string]string)
+	for _, s := range srv.servers {
+		if s.Listener != nil {
+			m[s.Net] = s.Listener.Addr().String()
+		} else if s.PacketConn != nil {
+			m[s.Net] = s.PacketConn.LocalAddr().String()
+		}
+	}
+	return m
+}
+
+// Run starts the servers, then serves until ctx is done, see Serve
+func (srv *Server) Run(ctx context.Context) error {
+	if err := srv.Start(); err != nil {
+		srv.Shutdown()
+		return err
+	}
+	srv.Serve(ctx)
+	return nil
+}
+
+// Serve runs the background tasks enabled in the configuration of the
+// started servers: DB watchers and stats reporting. It then blocks until ctx
+// is done, or the server is shut down, e.g. because a DB watcher failed, and
+// shuts everything down.
+func (srv *Server) Serve(ctx context.Context) {
+	if srv.conf.DBConfig.WatchDB {
+		go srv.WatchDBAndReload()
+	}
+	if srv.conf.DBConfig.ControlPath != "" {
+		go srv.WatchControlDirAndReload()
+	}
+	go srv.LogMapAge()
+	go srv.DumpBackendStats()
+	go srv.DumpSocketStats()
+
+	select {
+	case <-ctx.Done():
+	case <-srv.done:
+	}
+	srv.Shutdown()
+}
+
+// Shutdown shuts down all the underlying servers and close the DB. Only the
+// first call has any effect.
 func (srv *Server) Shutdown() {
+	srv.shutdownOnce.Do(srv.shutdown)
+}
+
+func (srv *Server) shutdown() {
+	close(srv.done)
 	glog.Infof("Shutting down %d servers", len(srv.servers))
 	for _, s := range srv.servers {
 		glog.Infof("Shutting down %s/%s", s.Addr, s.Net)
@@ -433,12 +638,41 @@ func (srv *Server) Shutdown() {
 			glog.Errorf("%v", err)
 		}
 	}
+	if srv.debugServer != nil {
+		if err := srv.debugServer.Shutdown(); err != nil {
+			glog.Errorf("Failed to shut down debug server: %v", err)
+		}
+	}
+	if srv.healthChecker != nil {
+		if err := srv.healthChecker.Shutdown(); err != nil {
+			glog.Errorf("Failed to shut down health checker: %v", err)
+		}
+	}
 	srv.db.Close()
 }
 
 // ReloadDB refreshes the data view
 func (srv *Server) ReloadDB() {
-	srv.db.ReloadChan <- *dnsserver.NewPartialReloadSignal()
+	srv.db.RequestReload(*dnsserver.NewPartialReloadSignal())
+}
+
+// DumpQueryLog logs the queries of the query log, oldest first, one JSON
+// object per line
+func (srv *Server) DumpQueryLog() {
+	entries := srv.db.QueryLog(-1)
+	if entries == nil {
+		glog.Info("Query log is disabled, nothing to dump")
+		return
+	}
+	glog.Infof("Dumping the %d queries of the query log", len(entries))
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			glog.Errorf("Failed to dump query log entry: %v", err)
+			continue
+		}
+		glog.Info(string(b))
+	}
 }
 
 // ValidateDbKey checks whether record of certain key is in db
@@ -503,15 +737,22 @@ func (srv *Server) getDBTimestamp() error {
 	return nil
 }
 
-// DumpBackendStats reports stats reported by DB backend as server counters
+// DumpBackendStats reports stats reported by DB backend as server counters,
+// until shutdown
 func (srv *Server) DumpBackendStats() {
 	ticker := time.NewTicker(BackendStatsInterval * time.Second)
-	for range ticker.C {
-		srv.db.ReportBackendStats()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-srv.done:
+			return
+		case <-ticker.C:
+			srv.db.ReportBackendStats()
+		}
 	}
 }
 
-// LogMapAge will log DB timestamp every `DBTimestampInterval`.
+// LogMapAge will log DB timestamp every `DBTimestampInterval`, until shutdown.
 func (srv *Server) LogMapAge() {
 	for _, k := range []string{
 		DBTimestampDBReadError,
@@ -527,9 +768,15 @@ func (srv *Server) LogMapAge() {
 		glog.Errorf("LogMapAge: %s", err)
 	}
 	ticker := time.NewTicker(DBTimestampInterval * time.Second)
-	for range ticker.C {
-		if err := srv.getDBTimestamp(); err != nil {
-			glog.Errorf("LogMapAge: %s", err)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-srv.done:
+			return
+		case <-ticker.C:
+			if err := srv.getDBTimestamp(); err != nil {
+				glog.Errorf("LogMapAge: %s", err)
+			}
 		}
 	}
 }
@@ -568,17 +815,3 @@ func failOnErr(err error, msg string) {
 func joinAddress(host string, port int) string {
 	return net.JoinHostPort(host, strconv.Itoa(port))
 }
-
-// reusePort sets a UNIX socket option that allows the listener to bind to a
-// port that is already in use. The delegation of traffic to listeners is
-// equally distributed via this method.
-func reusePort(_, _ string, c syscall.RawConn) error {
-	var opErr error
-	err := c.Control(func(fd uintptr) {
-		opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
-	})
-	if err != nil {
-		return err
-	}
-	return opErr
-}
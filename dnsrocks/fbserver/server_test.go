@@ -14,6 +14,7 @@ limitations under the License.
 package fbserver
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -25,11 +26,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/coredns/coredns/plugin"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
 
 	"github.com/facebook/dns/dnsrocks/dnsserver"
 	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
+	"github.com/facebook/dns/dnsrocks/dnsserver/test"
 	"github.com/facebook/dns/dnsrocks/metrics"
 	"github.com/facebook/dns/dnsrocks/testaid"
 )
@@ -38,6 +41,21 @@ func TestMain(m *testing.M) {
 	os.Exit(testaid.Run(m, "../testdata/data"))
 }
 
+// TestIPAnsValues checks that the listed values set the same IPs back
+func TestIPAnsValues(t *testing.T) {
+	s := NewServerConfig()
+	require.NoError(t, s.IPAns.Set("::1"))
+	require.NoError(t, s.IPAns.Set("192.0.2.53,8"))
+	values := s.IPAns.Values()
+	require.Equal(t, []string{"192.0.2.53,8", fmt.Sprintf("::1,%d", dnsserver.DefaultMaxAnswer)}, values)
+
+	c := NewServerConfig()
+	for _, v := range values {
+		require.NoError(t, c.IPAns.Set(v))
+	}
+	require.Equal(t, s.IPAns, c.IPAns)
+}
+
 func makeTestServerConfig(tcp, tls bool) ServerConfig {
 	s := NewServerConfig()
 	s.IPAns["::1"] = 1
@@ -60,7 +78,6 @@ func makeTestServerConfig(tcp, tls bool) ServerConfig {
 // returns a map of `network`/listening address.
 // network can be any of udp, tcp, tcp-tls
 func makeTestServer(t testing.TB, config ServerConfig) (map[string]string, *Server) {
-	var m = make(map[string]string)
 	logger := dnsserver.DummyLogger{}
 	stats := stats.DummyStats{}
 	metricsExporter, _ := metrics.NewMetricsServer(":0")
@@ -85,14 +102,7 @@ func makeTestServer(t testing.TB, config ServerConfig) (map[string]string, *Serv
 	}
 	close(serverUpChan)
 
-	for _, s := range srv.servers {
-		if s.Listener != nil {
-			m[s.Net] = s.Listener.Addr().String()
-		} else {
-			m[s.Net] = s.PacketConn.LocalAddr().String()
-		}
-	}
-	return m, srv
+	return srv.Addrs(), srv
 }
 
 // RunUDPTestServer spins up a standalone UDP DNS server.
@@ -478,6 +488,31 @@ func TestMaxUDPSize(t *testing.T) {
 	}
 }
 
+// TestServeMuxMaxUDPSize checks that the EDNS0 UDP buffer size of queries is
+// clamped for the whole handler chain
+func TestServeMuxMaxUDPSize(t *testing.T) {
+	var size uint16
+	next := plugin.HandlerFunc(func(_ context.Context, _ dns.ResponseWriter, r *dns.Msg) (int, error) {
+		size = r.IsEdns0().UDPSize()
+		return dns.RcodeSuccess, nil
+	})
+	mux := &serveMux{defaultHandler: next, stats: stats.NewCounters(), maxUDPSize: 1232}
+
+	for _, tc := range []struct {
+		clientMax uint16
+		size      uint16
+	}{
+		{clientMax: 4096, size: 1232},
+		{clientMax: 512, size: 512},
+	} {
+		req := new(dns.Msg)
+		req.SetQuestion("example.com.", dns.TypeA)
+		req.SetEdns0(tc.clientMax, false)
+		mux.ServeDNS(&test.ResponseWriterCustomRemote{RemoteIP: "192.0.2.1"}, req)
+		require.Equal(t, tc.size, size)
+	}
+}
+
 // Regression test for throttle jamming bug.
 func TestThrottleJamming(t *testing.T) {
 	// Setup
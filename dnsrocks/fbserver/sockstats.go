@@ -0,0 +1,79 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// SocketStatsInterval is the interval at which we report UDP socket stats, in seconds.
+const SocketStatsInterval = 10
+
+// socketStats are the kernel statistics of a socket
+type socketStats struct {
+	// Drops is the number of packets dropped since the socket was opened,
+	// e.g. because its receive buffer was full
+	Drops int64
+	// RcvQueued is the number of bytes in the receive buffer
+	RcvQueued int64
+	// RcvBuf is the size of the receive buffer
+	RcvBuf int64
+}
+
+// reportSocketStats reports the kernel statistics of the UDP sockets as
+// server counters: DNS_udp.socket_drops sums the packets dropped, and
+// DNS_udp.socket_rcvbuf_pct is the fullest receive buffer, in percents. It
+// returns false if they can't be read.
+func (srv *Server) reportSocketStats() bool {
+	var drops, fullest int64
+	for _, s := range srv.servers {
+		if s.PacketConn == nil {
+			continue
+		}
+		st, err := readSocketStats(s.PacketConn)
+		if err != nil {
+			glog.Errorf("Failed to read stats of UDP socket %s: %v", s.Addr, err)
+			return false
+		}
+		drops += st.Drops
+		if st.RcvBuf > 0 {
+			fullest = max(fullest, 100*st.RcvQueued/st.RcvBuf)
+		}
+	}
+	srv.stats.ResetCounterTo("DNS_udp.socket_drops", drops)
+	srv.stats.ResetCounterTo("DNS_udp.socket_rcvbuf_pct", fullest)
+	return true
+}
+
+// DumpSocketStats reports the kernel statistics of the UDP sockets every
+// SocketStatsInterval, until shutdown or if they can't be read.
+func (srv *Server) DumpSocketStats() {
+	if !srv.reportSocketStats() {
+		return
+	}
+	ticker := time.NewTicker(SocketStatsInterval * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-srv.done:
+			return
+		case <-ticker.C:
+			if !srv.reportSocketStats() {
+				return
+			}
+		}
+	}
+}
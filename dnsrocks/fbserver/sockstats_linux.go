@@ -0,0 +1,60 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// readSocketStats returns the kernel statistics of the socket of pc, read
+// with SO_MEMINFO. Its drops are the ones SO_RXQ_OVFL reports along with
+// every packet received.
+func readSocketStats(pc net.PacketConn) (socketStats, error) {
+	var s socketStats
+	sc, ok := pc.(syscall.Conn)
+	if !ok {
+		return s, fmt.Errorf("no socket for %T", pc)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return s, err
+	}
+	var (
+		info  [unix.SK_MEMINFO_VARS]uint32
+		opErr error
+	)
+	err = rc.Control(func(fd uintptr) {
+		size := uint32(unsafe.Sizeof(info))
+		_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, fd, unix.SOL_SOCKET, unix.SO_MEMINFO,
+			uintptr(unsafe.Pointer(&info[0])), uintptr(unsafe.Pointer(&size)), 0)
+		if errno != 0 {
+			opErr = errno
+		}
+	})
+	if err != nil {
+		return s, err
+	}
+	if opErr != nil {
+		return s, fmt.Errorf("getsockopt SO_MEMINFO: %w", opErr)
+	}
+	s.Drops = int64(info[unix.SK_MEMINFO_DROPS])
+	s.RcvQueued = int64(info[unix.SK_MEMINFO_RMEM_ALLOC])
+	s.RcvBuf = int64(info[unix.SK_MEMINFO_RCVBUF])
+	return s, nil
+}
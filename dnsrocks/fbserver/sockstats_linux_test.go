@@ -0,0 +1,39 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSocketStats(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	client, err := net.Dial("udp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write(make([]byte, 100))
+	require.NoError(t, err)
+
+	s, err := readSocketStats(pc)
+	require.NoError(t, err)
+	require.Zero(t, s.Drops)
+	require.Positive(t, s.RcvBuf)
+	require.Positive(t, s.RcvQueued)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/miekg/dns"
+)
+
+// versionOptionHandler adds the version of the dataset in use to the
+// responses of queries carrying its EDNS0 local option, so that monitoring
+// can follow the propagation of a publish through regular queries
+type versionOptionHandler struct {
+	code    uint16
+	version func() string
+	Next    plugin.Handler
+}
+
+// newVersionOptionHandler initializes a versionOptionHandler for the EDNS0
+// local option code, adding the text returned by version
+func newVersionOptionHandler(code int, version func() string) (*versionOptionHandler, error) {
+	if code < dns.EDNS0LOCALSTART || code > dns.EDNS0LOCALEND {
+		return nil, fmt.Errorf("EDNS0 option %d is not a local option, between %d and %d", code, dns.EDNS0LOCALSTART, dns.EDNS0LOCALEND)
+	}
+	return &versionOptionHandler{code: uint16(code), version: version}, nil
+}
+
+func (vh *versionOptionHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	if opt := r.IsEdns0(); opt != nil {
+		for _, option := range opt.Option {
+			if option.Option() == vh.code {
+				w = versionResponseWriter{ResponseWriter: w, code: vh.code, version: vh.version}
+				break
+			}
+		}
+	}
+	return plugin.NextOrFailure(vh.Name(), vh.Next, ctx, w, r)
+}
+
+func (vh *versionOptionHandler) Name() string { return "versionoption" }
+
+type versionResponseWriter struct {
+	dns.ResponseWriter
+	code    uint16
+	version func() string
+}
+
+// WriteMsg adds the version option to the response
+func (w versionResponseWriter) WriteMsg(response *dns.Msg) error {
+	if opt := response.IsEdns0(); opt != nil {
+		if version := w.version(); version != "" {
+			opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: w.code, Data: []byte(version)})
+		}
+	}
+	return w.ResponseWriter.WriteMsg(response)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fbserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionOptionHandler(t *testing.T) {
+	_, err := newVersionOptionHandler(dns.EDNS0NSID, func() string { return "" })
+	require.Error(t, err)
+
+	version := "serial=1 version=v1"
+	vh, err := newVersionOptionHandler(65301, func() string { return version })
+	require.NoError(t, err)
+	vh.Next = test.HandlerFunc(func(_ context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.IsEdns0() != nil {
+			m.SetEdns0(1232, false)
+		}
+		return dns.RcodeSuccess, w.WriteMsg(m)
+	})
+
+	query := func(options ...dns.EDNS0) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("example.com.", dns.TypeA)
+		if options != nil {
+			req.SetEdns0(1232, false)
+			req.IsEdns0().Option = options
+		}
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		_, err := vh.ServeDNS(context.TODO(), rec, req)
+		require.NoError(t, err)
+		return rec.Msg
+	}
+
+	require.Nil(t, query().IsEdns0())
+	require.Empty(t, query(&dns.EDNS0_NSID{Code: dns.EDNS0NSID}).IsEdns0().Option)
+
+	opt := query(&dns.EDNS0_LOCAL{Code: 65301}).IsEdns0()
+	require.Len(t, opt.Option, 1)
+	local := opt.Option[0].(*dns.EDNS0_LOCAL)
+	require.Equal(t, uint16(65301), local.Code)
+	require.Equal(t, version, string(local.Data))
+
+	version = ""
+	require.Empty(t, query(&dns.EDNS0_LOCAL{Code: 65301}).IsEdns0().Option)
+}
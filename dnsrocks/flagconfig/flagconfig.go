@@ -0,0 +1,286 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package flagconfig sets the flags of a flag.FlagSet from a YAML config
+// file, so that deployments can be reviewed as a file rather than a long
+// command line. Flags set on the command line override the file.
+//
+// Keys are flag names, and nested maps join their keys with "-", so that
+//
+//	health:
+//	  addr: localhost:8054
+//	  interval: 5s
+//
+// sets -health-addr and -health-interval. Lists set repeatable flags once
+// per item. Values may reference environment variables as ${VAR}, or
+// ${VAR:-default} when VAR may be unset or empty; $$ is a literal $. They
+// are replaced in the parsed values only, never in keys or comments, and
+// always make a single value.
+//
+// Only YAML config files are supported, not TOML: JSON ones, a subset of
+// YAML, can be loaded too. goose keeps a copy of the package, so as not to
+// depend on the dnsrocks module; changes should be made to both.
+package flagconfig
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Names of the flags registered by Register
+const (
+	FlagConfig      = "config"
+	FlagPrintConfig = "print-effective-config"
+)
+
+// Flags holds the values of the flags registered by Register
+type Flags struct {
+	Path  string
+	Print bool
+}
+
+// Register adds -config and -print-effective-config to fs
+func Register(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Path, FlagConfig, "", "Path to a YAML config file setting flags by name, nested keys being joined with '-'. Flags set on the command line override it.")
+	fs.BoolVar(&f.Print, FlagPrintConfig, false, "Print the effective config, the config file and command line flags applied, as YAML and exit.")
+	return f
+}
+
+var envRef = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Interpolate replaces the ${VAR} and ${VAR:-default} references of s with
+// the environment variables returned by lookup. It fails on references to
+// unset variables without default.
+func Interpolate(s string, lookup func(string) (string, bool)) (string, error) {
+	var missing []string
+	out := envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		m := envRef.FindStringSubmatch(ref)
+		v, ok := lookup(m[1])
+		if m[2] != "" && v == "" {
+			return m[3]
+		}
+		if !ok {
+			missing = append(missing, m[1])
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined environment variables %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// ListValue is implemented by the values of repeatable flags, which Print
+// writes as a list of the values to set them with, once each
+type ListValue interface {
+	flag.Value
+	Values() []string
+}
+
+// scalar returns the value of node, with environment variables replaced
+func scalar(name string, node *yaml.Node) (string, error) {
+	v, err := Interpolate(node.Value, os.LookupEnv)
+	if err != nil {
+		return "", fmt.Errorf("line %d: %s: %w", node.Line, name, err)
+	}
+	return v, nil
+}
+
+// flatten adds the flag values of node, found under prefix, to values
+func flatten(prefix string, node *yaml.Node, values map[string][]string) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			if err := flatten(prefix, n, values); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k := node.Content[i]
+			if k.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: invalid key", k.Line)
+			}
+			name := k.Value
+			if prefix != "" {
+				name = prefix + "-" + name
+			}
+			if err := flatten(name, node.Content[i+1], values); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, n := range node.Content {
+			if n.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: %s: lists may only hold values", n.Line, prefix)
+			}
+			v, err := scalar(prefix, n)
+			if err != nil {
+				return err
+			}
+			values[prefix] = append(values[prefix], v)
+		}
+	case yaml.ScalarNode:
+		if prefix == "" {
+			return fmt.Errorf("line %d: expected a map of flags", node.Line)
+		}
+		if _, ok := values[prefix]; ok {
+			return fmt.Errorf("line %d: %s is set twice", node.Line, prefix)
+		}
+		v, err := scalar(prefix, node)
+		if err != nil {
+			return err
+		}
+		values[prefix] = []string{v}
+	case yaml.AliasNode:
+		return flatten(prefix, node.Alias, values)
+	}
+	return nil
+}
+
+// Parse returns the flag values of the config read from r, by flag name
+func Parse(r io.Reader) (map[string][]string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	values := make(map[string][]string)
+	if err := flatten("", &doc, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Apply sets the flags of fs to values, except the ones already set on the
+// command line. It fails on unknown flags and invalid values.
+func Apply(fs *flag.FlagSet, values map[string][]string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == FlagConfig || name == FlagPrintConfig {
+			return fmt.Errorf("%s can't be set in a config file", name)
+		}
+		f := fs.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown flag %s", name)
+		}
+		if set[name] {
+			continue
+		}
+		if _, ok := f.Value.(ListValue); !ok && len(values[name]) == 1 && values[name][0] == f.Value.String() {
+			// already set to the value, e.g. by a printed config
+			continue
+		}
+		for _, v := range values[name] {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("invalid value %q for %s: %w", v, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Load applies the config file at path to fs, see Apply
+func Load(fs *flag.FlagSet, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	values, err := Parse(f)
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if err := Apply(fs, values); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Print writes the values of the flags of fs as a YAML config file, which
+// Load can read back. Repeatable flags are written as lists if their values
+// implement ListValue.
+func Print(w io.Writer, fs *flag.FlagSet) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == FlagConfig || f.Name == FlagPrintConfig {
+			return
+		}
+		if l, ok := f.Value.(ListValue); ok {
+			v := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+			for _, item := range l.Values() {
+				v.Content = append(v.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: item, Style: yaml.DoubleQuotedStyle})
+			}
+			doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.Name}, v)
+			return
+		}
+		// values are quoted, unless they are typed ones
+		v := &yaml.Node{Kind: yaml.ScalarNode, Value: f.Value.String(), Style: yaml.DoubleQuotedStyle}
+		if g, ok := f.Value.(flag.Getter); ok {
+			switch g.Get().(type) {
+			case bool, int, int64, uint, uint64, float64:
+				v.Style = 0
+			}
+		}
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.Name}, v)
+	})
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Run loads the config file set by -config, if any, then prints the
+// effective config to w if -print-effective-config is set, in which case it
+// returns true for the caller to exit. fs must have been parsed.
+func (f *Flags) Run(fs *flag.FlagSet, w io.Writer) (exit bool, err error) {
+	if f.Path != "" {
+		if err := Load(fs, f.Path); err != nil {
+			return false, err
+		}
+	}
+	if !f.Print {
+		return false, nil
+	}
+	return true, Print(w, fs)
+}
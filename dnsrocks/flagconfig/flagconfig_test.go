@@ -0,0 +1,174 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package flagconfig
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func (l *listFlag) Values() []string { return *l }
+
+type testFlags struct {
+	fs       *flag.FlagSet
+	config   *Flags
+	dbpath   string
+	port     int
+	tcp      bool
+	interval time.Duration
+	ips      listFlag
+}
+
+func newTestFlags() *testFlags {
+	f := &testFlags{fs: flag.NewFlagSet("test", flag.ContinueOnError)}
+	f.fs.SetOutput(io.Discard)
+	f.config = Register(f.fs)
+	f.fs.StringVar(&f.dbpath, "dbpath", "./rocksdb", "")
+	f.fs.IntVar(&f.port, "port", 53, "")
+	f.fs.BoolVar(&f.tcp, "tcp", false, "")
+	f.fs.DurationVar(&f.interval, "health-interval", 10*time.Second, "")
+	f.fs.Var(&f.ips, "ip", "")
+	return f
+}
+
+func TestInterpolate(t *testing.T) {
+	env := map[string]string{"DB": "/var/db", "EMPTY": ""}
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+	s, err := Interpolate("dbpath: ${DB}/rocksdb\nport: ${PORT:-5353}\nname: ${EMPTY:-x}${EMPTY}\ncost: $$5 $HOME", lookup)
+	require.NoError(t, err)
+	require.Equal(t, "dbpath: /var/db/rocksdb\nport: 5353\nname: x\ncost: $5 $HOME", s)
+
+	_, err = Interpolate("dbpath: ${DB}${UNSET}", lookup)
+	require.ErrorContains(t, err, "UNSET")
+}
+
+func TestParse(t *testing.T) {
+	t.Setenv("FLAGCONFIG_TEST_DB", "/var/db")
+	t.Setenv("FLAGCONFIG_TEST_YAML", "5353\ntcp: true")
+	values, err := Parse(strings.NewReader(`
+# ${FLAGCONFIG_TEST_UNSET} is only replaced in values
+dbpath: ${FLAGCONFIG_TEST_DB}/rocksdb
+port: 5353
+name: ${FLAGCONFIG_TEST_YAML}
+health:
+  interval: 5s
+ip:
+  - 192.0.2.53
+  - 2001:db8::53
+`))
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"dbpath":          {"/var/db/rocksdb"},
+		"port":            {"5353"},
+		"name":            {"5353\ntcp: true"},
+		"health-interval": {"5s"},
+		"ip":              {"192.0.2.53", "2001:db8::53"},
+	}, values)
+
+	for _, bad := range []string{
+		"port",
+		"ip: [[192.0.2.53]]",
+		"health-interval: 5s\nhealth:\n  interval: 6s",
+		"dbpath: ${FLAGCONFIG_TEST_UNSET}",
+		"ip: [192.0.2.53, ${FLAGCONFIG_TEST_UNSET}]",
+		"port: [",
+	} {
+		_, err := Parse(strings.NewReader(bad))
+		require.Error(t, err, bad)
+	}
+}
+
+// TestLoad checks that the config file sets flags, command line ones
+// excepted
+func TestLoad(t *testing.T) {
+	config := path.Join(t.TempDir(), "dnsrocks.yaml")
+	require.NoError(t, os.WriteFile(config, []byte("dbpath: /var/db\nport: 5353\ntcp: true\nip: [192.0.2.53, 192.0.2.54]\n"), 0o644))
+
+	f := newTestFlags()
+	require.NoError(t, f.fs.Parse([]string{"-config", config, "-port", "8053"}))
+	exit, err := f.config.Run(f.fs, io.Discard)
+	require.NoError(t, err)
+	require.False(t, exit)
+	require.Equal(t, "/var/db", f.dbpath)
+	require.Equal(t, 8053, f.port)
+	require.True(t, f.tcp)
+	require.Equal(t, listFlag{"192.0.2.53", "192.0.2.54"}, f.ips)
+
+	for _, bad := range []string{"bogus: 1", "port: x", "config: other.yaml"} {
+		require.NoError(t, os.WriteFile(config, []byte(bad), 0o644))
+		f := newTestFlags()
+		require.NoError(t, f.fs.Parse([]string{"-config", config}))
+		_, err := f.config.Run(f.fs, io.Discard)
+		require.Error(t, err, bad)
+	}
+	f = newTestFlags()
+	require.NoError(t, f.fs.Parse([]string{"-config", path.Join(t.TempDir(), "missing.yaml")}))
+	_, err = f.config.Run(f.fs, io.Discard)
+	require.Error(t, err)
+}
+
+// TestPrint checks that the effective config can be loaded back
+func TestPrint(t *testing.T) {
+	f := newTestFlags()
+	require.NoError(t, f.fs.Parse([]string{"-print-effective-config", "-dbpath", "/var/db", "-health-interval", "1m", "-ip", "192.0.2.53", "-ip", "2001:db8::53"}))
+	var buf bytes.Buffer
+	exit, err := f.config.Run(f.fs, &buf)
+	require.NoError(t, err)
+	require.True(t, exit)
+	require.Equal(t, `dbpath: "/var/db"
+health-interval: "1m0s"
+ip: ["192.0.2.53", "2001:db8::53"]
+port: 53
+tcp: false
+`, buf.String())
+
+	values, err := Parse(&buf)
+	require.NoError(t, err)
+	g := newTestFlags()
+	require.NoError(t, Apply(g.fs, values))
+	require.Equal(t, "/var/db", g.dbpath)
+	require.Equal(t, time.Minute, g.interval)
+	require.Equal(t, listFlag{"192.0.2.53", "2001:db8::53"}, g.ips)
+
+	// empty lists are printed too
+	f = newTestFlags()
+	buf.Reset()
+	require.NoError(t, Print(&buf, f.fs))
+	require.Contains(t, buf.String(), "ip: []\n")
+	values, err = Parse(&buf)
+	require.NoError(t, err)
+	g = newTestFlags()
+	require.NoError(t, Apply(g.fs, values))
+	require.Empty(t, g.ips)
+}
@@ -183,6 +183,30 @@ func (c *Cdb) readNums(pos uint32, context *Context) (uint32, uint32) {
 		binary.LittleEndian.Uint32(data[4:])
 }
 
+// ForEachRecord calls f with the key and value of every record, in the order
+// they were written, until f returns an error.
+func (c *Cdb) ForEachRecord(f func(key, value []byte) error) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = e.(error)
+		}
+	}()
+
+	var context = NewContext()
+
+	// the first hash table follows the records
+	eod, _ := c.readNums(0, context)
+	for pos := headerSize; pos < eod; {
+		klen, vlen := c.readNums(pos, context)
+		x := pos + 8
+		if err := f(c.mmappedData[x:x+klen], c.mmappedData[x+klen:x+klen+vlen]); err != nil {
+			return err
+		}
+		pos = x + klen + vlen
+	}
+	return nil
+}
+
 // ForEachKeys will call a function with the key hash as well as key and value.
 func (c *Cdb) ForEachKeys(f func(keyHash uint32, key, value []byte)) (err error) {
 	defer func() {
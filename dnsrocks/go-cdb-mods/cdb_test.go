@@ -82,6 +82,25 @@ func TestCdb(t *testing.T) {
 		}
 	}
 
+	// Test ForEachRecord, in write order
+	var got []string
+	err = c.ForEachRecord(func(key, value []byte) error {
+		got = append(got, string(key)+"="+string(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachRecord failed: %s", err)
+	}
+	var expected []string
+	for _, rec := range records {
+		for _, value := range rec.values {
+			expected = append(expected, rec.key+"="+value)
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("ForEachRecord returned %v, expected %v", got, expected)
+	}
+
 	// Test Dump
 	if _, err = tmp.Seek(0, 0); err != nil {
 		t.Fatal(err)
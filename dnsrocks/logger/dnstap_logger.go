@@ -14,6 +14,7 @@ limitations under the License.
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"net"
@@ -21,6 +22,8 @@ import (
 	"time"
 
 	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/dnsjson"
+	"github.com/facebook/dns/dnsrocks/dnsserver"
 
 	msg "github.com/coredns/coredns/plugin/dnstap/msg"
 	"github.com/coredns/coredns/request"
@@ -71,9 +74,11 @@ func NewLogger(config Config) (l *DNSTapLogger, err error) {
 			formatterFunc = dnstap.YamlFormat
 		case "text":
 			formatterFunc = dnstap.TextFormat
+		case "rfc8427":
+			formatterFunc = rfc8427Format
 
 		default:
-			return nil, fmt.Errorf("%s: is an invalid log format for dnstap stdoutlogger. Valid formats are: text, json, yaml ", config.LogFormat)
+			return nil, fmt.Errorf("%s: is an invalid log format for dnstap stdoutlogger. Valid formats are: text, json, yaml, rfc8427 ", config.LogFormat)
 		}
 		l.dnsTapOutput = dnstap.NewTextOutput(os.Stdout, formatterFunc)
 	case "tcp":
@@ -113,6 +118,29 @@ func NewLogger(config Config) (l *DNSTapLogger, err error) {
 	return l, nil
 }
 
+// rfc8427Format is a dnstap.TextFormatFunc writing the DNS message of dt, as
+// RFC 8427 JSON dated with its query time, one per line
+func rfc8427Format(dt *dnstap.Dnstap) ([]byte, bool) {
+	m := dt.GetMessage()
+	wire := m.GetResponseMessage()
+	if wire == nil {
+		// the server logs its responses as query messages
+		wire = m.GetQueryMessage()
+	}
+	j, err := dnsjson.FromWire(wire)
+	if err != nil {
+		return nil, false
+	}
+	if m.QueryTimeSec != nil {
+		j.SetDate(time.Unix(int64(m.GetQueryTimeSec()), int64(m.GetQueryTimeNsec()))) //nolint:gosec
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		return nil, false
+	}
+	return append(b, '\n'), true
+}
+
 // StartLoggerOutput starts the dnstap logger output loop
 func (l *DNSTapLogger) StartLoggerOutput() {
 	go l.dnsTapOutput.RunOutputLoop()
@@ -136,6 +164,7 @@ func (l *DNSTapLogger) Log(state request.Request, r *dns.Msg, _ *dns.EDNS0_SUBNE
 	if err != nil {
 		glog.Errorf("Failed to set ResponseAddress %v for dnstap message", state.W.RemoteAddr())
 	}
+	setSocketProtocol(m, state)
 	msg.SetQueryTime(m, time.Now())
 	msg.SetType(m, dnstap.Message_AUTH_QUERY)
 	buf, _ = r.Pack()
@@ -159,6 +188,25 @@ func (l *DNSTapLogger) Log(state request.Request, r *dns.Msg, _ *dns.EDNS0_SUBNE
 	}
 }
 
+// setSocketProtocol sets the protocol of queries received over encrypted
+// transports, which the socket addresses don't tell apart from plain TCP
+func setSocketProtocol(m *dnstap.Message, state request.Request) {
+	info, ok := dnsserver.ClientInfoOf(state)
+	if !ok {
+		return
+	}
+	var proto dnstap.SocketProtocol
+	switch info.Transport {
+	case dnsserver.TransportDoT:
+		proto = dnstap.SocketProtocol_DOT
+	case dnsserver.TransportDoH:
+		proto = dnstap.SocketProtocol_DOH
+	default:
+		return
+	}
+	m.SocketProtocol = &proto
+}
+
 // LogFailed is used to log failures
 func (l *DNSTapLogger) LogFailed(state request.Request, ecs *dns.EDNS0_SUBNET, loc *db.Location) {
 	m := new(dns.Msg)
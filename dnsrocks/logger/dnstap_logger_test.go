@@ -14,10 +14,13 @@ limitations under the License.
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/coredns/coredns/request"
+	dnstap "github.com/dnstap/golang-dnstap"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
 )
@@ -127,6 +130,7 @@ func TestLoggerNotErrorGoodLogFormat(t *testing.T) {
 		"text",
 		"yaml",
 		"json",
+		"rfc8427",
 	}
 
 	for _, tc := range testCases {
@@ -227,3 +231,25 @@ func TestComputeDNSFlag(t *testing.T) {
 	flags |= (5 << 11) | 3
 	require.Equal(t, int(computeDNSFlag(m)), flags)
 }
+
+func TestRFC8427Format(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.com.", dns.TypeA)
+	m.Response = true
+	wire, err := m.Pack()
+	require.NoError(t, err)
+	sec, nsec := uint64(1700000000), uint32(250000000)
+	dt := &dnstap.Dnstap{Message: &dnstap.Message{QueryMessage: wire, QueryTimeSec: &sec, QueryTimeNsec: &nsec}}
+
+	out, ok := rfc8427Format(dt)
+	require.True(t, ok)
+	require.Equal(t, byte('\n'), out[len(out)-1])
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(out, &fields))
+	require.Equal(t, "www.example.com.", fields["QNAME"])
+	require.Equal(t, float64(1), fields["QR"])
+	require.Equal(t, time.Unix(1700000000, 250000000).UTC().Format(time.RFC3339Nano), fields["dateString"])
+
+	_, ok = rfc8427Format(&dnstap.Dnstap{Message: &dnstap.Message{QueryMessage: wire[:3]}})
+	require.False(t, ok)
+}
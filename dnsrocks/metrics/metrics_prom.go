@@ -79,7 +79,7 @@ func (s *PrometheusMetricsServer) ConsumeStats(category string, stats *Stats) er
 func (s *PrometheusMetricsServer) UpdateExporter() {
 	for range time.Tick(1 * time.Second) {
 		for category, stats := range s.stats {
-			metricsmap := stats.Get()
+			metricsmap := stats.Snapshot()
 			for mkey, mval := range metricsmap {
 				promCollector := prometheus.NewGauge(prometheus.GaugeOpts{
 					Namespace: flattenKey(category),
@@ -16,6 +16,7 @@ package metrics
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
@@ -28,94 +29,138 @@ import (
 // * IncrementCounterBy increments the counter by `value`
 // * ResetCounter resets the counter to 0
 // * ResetCounterTo resets the counter to `value`
-// * Get to export them.
+// * Snapshot or Get to export them.
+//
+// Counters are incremented without locking once they exist, the increments
+// being aggregated into the exported values when flushed, see
+// NewStatsWithFlushInterval.
 type Stats struct {
-	vlock   sync.RWMutex
-	wlock   sync.RWMutex
-	values  map[string]int64
-	windows map[string]*slidingWindow
+	// counters maps keys to *atomic.Int64 holding the increments not flushed yet
+	counters sync.Map
+	// windows maps keys to *slidingWindow
+	windows sync.Map
+	// flushInterval is the minimum time between flushes, 0 to flush on every
+	// snapshot
+	flushInterval time.Duration
+
+	// lock protects the fields below
+	lock      sync.Mutex
+	values    map[string]int64
+	flushedAt time.Time
+	snapshot  map[string]int64
 }
 
-// NewStats creates a new stats counter.
-// The entity parameter specifies the ODS entity.
-// If entity is the empty string, the ODS entity is inferred from the
-// Tupperware task name, or the hostname if the process doesn't
-// run in Tupperware.
+// NewStats creates a new stats counter, flushed on every snapshot.
 func NewStats() *Stats {
-	stats := new(Stats)
+	return NewStatsWithFlushInterval(0)
+}
 
-	stats.values = make(map[string]int64)
-	stats.windows = make(map[string]*slidingWindow)
-	return stats
+// NewStatsWithFlushInterval creates a new stats counter flushed at most every
+// interval: snapshots taken less than interval after the previous flush
+// return the same values, sparing counters from aggregating for frequent
+// readers.
+func NewStatsWithFlushInterval(interval time.Duration) *Stats {
+	return &Stats{
+		values:        make(map[string]int64),
+		flushInterval: interval,
+	}
+}
+
+// counter returns the pending increments of the counter for key
+func (stats *Stats) counter(key string) *atomic.Int64 {
+	if c, ok := stats.counters.Load(key); ok {
+		return c.(*atomic.Int64)
+	}
+	c, _ := stats.counters.LoadOrStore(key, new(atomic.Int64))
+	return c.(*atomic.Int64)
 }
 
 // IncrementCounter increments the counter for key by 1.
 // Implements dnsserver.IncrementCounter interface.
 func (stats *Stats) IncrementCounter(key string) {
-	stats.vlock.Lock()
-	stats.values[key]++
-	stats.vlock.Unlock()
+	stats.counter(key).Add(1)
 }
 
 // IncrementCounterBy adds Value to the counter for key
 // Implements dnsserver.IncrementCounterBy interface.
 func (stats *Stats) IncrementCounterBy(key string, value int64) {
-	stats.vlock.Lock()
-	stats.values[key] += value
-	stats.vlock.Unlock()
+	stats.counter(key).Add(value)
 }
 
 // ResetCounter sets the counter for key to 0.
 // Implements dnsserver.ResetCounter interface.
 func (stats *Stats) ResetCounter(key string) {
-	stats.vlock.Lock()
-	stats.values[key] = 0
-	stats.vlock.Unlock()
+	stats.ResetCounterTo(key, 0)
 }
 
 // ResetCounterTo sets the counter for key to the given value.
 // Implements dnsserver.ResetCounterTo interface.
 func (stats *Stats) ResetCounterTo(key string, value int64) {
-	stats.vlock.Lock()
+	c := stats.counter(key)
+	stats.lock.Lock()
+	// increments before the reset are overridden
+	c.Store(0)
 	stats.values[key] = value
-	stats.vlock.Unlock()
+	stats.lock.Unlock()
 }
 
 // AddSample adds a sample to the sliding window identified by key
 func (stats *Stats) AddSample(key string, value int64) {
-	stats.wlock.Lock()
-	defer stats.wlock.Unlock()
-	var win *slidingWindow
-	winfound, found := stats.windows[key]
+	win, found := stats.windows.Load(key)
 	if !found {
 		newwin, err := newSlidingWindow(60 * time.Second)
 		if err != nil {
 			glog.Errorf("failed to register new sliding window")
 			return
 		}
-		stats.windows[key] = newwin
-		win = newwin
-	} else {
-		win = winfound
+		var loaded bool
+		if win, loaded = stats.windows.LoadOrStore(key, newwin); loaded {
+			// another sample registered the window first
+			newwin.stopping <- struct{}{}
+		}
 	}
-	win.Add(value)
+	win.(*slidingWindow).Add(value)
 }
 
-// Get implements export.Int interface
-func (stats *Stats) Get() map[string]int64 {
-	var ret = make(map[string]int64)
-	stats.vlock.Lock()
+// flush aggregates the pending increments into the values, and takes a
+// snapshot of them, must be called with lock held
+func (stats *Stats) flush(now time.Time) {
+	stats.counters.Range(func(key, c any) bool {
+		stats.values[key.(string)] += c.(*atomic.Int64).Swap(0)
+		return true
+	})
+	snapshot := make(map[string]int64, len(stats.values))
 	for key, val := range stats.values {
-		ret[key] = val
+		snapshot[key] = val
 	}
-	stats.vlock.Unlock()
-	stats.wlock.Lock()
-	for key, val := range stats.windows {
-		s := val.Stats()
-		ret[fmt.Sprintf("%s.min", key)] = s.min
-		ret[fmt.Sprintf("%s.max", key)] = s.max
-		ret[fmt.Sprintf("%s.avg", key)] = s.avg
+	stats.windows.Range(func(key, win any) bool {
+		s := win.(*slidingWindow).Stats()
+		snapshot[fmt.Sprintf("%s.min", key)] = s.min
+		snapshot[fmt.Sprintf("%s.max", key)] = s.max
+		snapshot[fmt.Sprintf("%s.avg", key)] = s.avg
+		return true
+	})
+	stats.snapshot = snapshot
+	stats.flushedAt = now
+}
+
+// Snapshot returns a copy of all counters and sliding window aggregates as of
+// the last flush, flushing first if the flush interval elapsed.
+// Implements dnsserver.Snapshot interface.
+func (stats *Stats) Snapshot() map[string]int64 {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	if now := time.Now(); stats.snapshot == nil || now.Sub(stats.flushedAt) >= stats.flushInterval {
+		stats.flush(now)
+	}
+	ret := make(map[string]int64, len(stats.snapshot))
+	for key, val := range stats.snapshot {
+		ret[key] = val
 	}
-	stats.wlock.Unlock()
 	return ret
 }
+
+// Get implements export.Int interface
+func (stats *Stats) Get() map[string]int64 {
+	return stats.Snapshot()
+}
@@ -14,7 +14,9 @@ limitations under the License.
 package metrics
 
 import (
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -49,3 +51,47 @@ func TestStatsGet(t *testing.T) {
 	}
 	require.Equal(t, want, got)
 }
+
+func TestStatsSnapshot(t *testing.T) {
+	s := NewStats()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s.IncrementCounter("queries")
+				s.IncrementCounterBy("bytes", 2)
+			}
+		}()
+	}
+	wg.Wait()
+	s.ResetCounterTo("gauge", 42)
+	require.Equal(t, map[string]int64{"queries": 8000, "bytes": 16000, "gauge": 42}, s.Snapshot())
+
+	// increments before a reset are overridden, increments after it are kept
+	s.IncrementCounter("queries")
+	s.ResetCounter("queries")
+	s.IncrementCounter("queries")
+	snapshot := s.Snapshot()
+	require.Equal(t, int64(1), snapshot["queries"])
+
+	// snapshots are copies
+	snapshot["queries"] = 100
+	require.Equal(t, int64(1), s.Snapshot()["queries"])
+	require.Equal(t, s.Snapshot(), s.Get())
+}
+
+func TestStatsFlushInterval(t *testing.T) {
+	s := NewStatsWithFlushInterval(time.Hour)
+	s.IncrementCounter("queries")
+	require.Equal(t, map[string]int64{"queries": 1}, s.Snapshot())
+
+	// increments are only aggregated on the next flush
+	s.IncrementCounter("queries")
+	s.AddSample("latency", 10)
+	require.Equal(t, map[string]int64{"queries": 1}, s.Snapshot())
+
+	s.flushInterval = 0
+	require.Equal(t, map[string]int64{"queries": 2, "latency.min": 10, "latency.max": 10, "latency.avg": 10}, s.Snapshot())
+}
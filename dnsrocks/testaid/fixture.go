@@ -0,0 +1,165 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testaid
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata/cdb"
+)
+
+// Fixture is a test database declared in Go rather than in the data files of
+// testdata, e.g. to set up the records a test is about and nothing else. Its
+// methods add lines of the data format (see docs/data_format.md) in call
+// order, and return the Fixture so that calls can be chained:
+//
+//	dbs := testaid.NewFixture().
+//		Zone("example.com", 300, "ns.example.com").
+//		Address("www.example.com", "192.0.2.1", 300, "").
+//		Build(t)
+//
+// Names are written without trailing dot, locations as escaped IDs, see Loc.
+type Fixture struct {
+	lines []string
+}
+
+// NewFixture returns an empty Fixture
+func NewFixture() *Fixture {
+	return &Fixture{}
+}
+
+// Loc returns the data format spelling of the 2-byte location ID id, e.g.
+// \000\002 for 2
+func Loc(id uint16) string {
+	return fmt.Sprintf("\\%03o\\%03o", id>>8, id&0xff)
+}
+
+// Line adds a raw line of the data format, for records without a method
+func (f *Fixture) Line(line string) *Fixture {
+	f.lines = append(f.lines, line)
+	return f
+}
+
+// Zone adds the SOA record of zone, with the first name server as primary,
+// serial 1 and ttl as TTL and negative caching TTL, and its NS records
+func (f *Fixture) Zone(zone string, ttl uint32, nameservers ...string) *Fixture {
+	primary := ""
+	if len(nameservers) > 0 {
+		primary = nameservers[0]
+	}
+	f.Line(fmt.Sprintf("Z%s,%s,hostmaster.%s,1,7200,1800,604800,%d,%d,,", zone, primary, zone, ttl, ttl))
+	for _, ns := range nameservers {
+		f.NS(zone, ns, ttl)
+	}
+	return f
+}
+
+// NS adds an NS record, delegating name to ns unless name is a zone
+func (f *Fixture) NS(name, ns string, ttl uint32) *Fixture {
+	return f.Line(fmt.Sprintf("&%s,,%s,%d,,", name, ns, ttl))
+}
+
+// Address adds an A or AAAA record, depending on the family of ip, for the
+// location loc, all of them if empty
+func (f *Fixture) Address(name, ip string, ttl uint32, loc string) *Fixture {
+	return f.Line(fmt.Sprintf("+%s,%s,%d,,%s", name, ip, ttl, loc))
+}
+
+// WeightedAddress adds an A or AAAA record like Address, picked at random
+// among the ones of name and loc according to its weight
+func (f *Fixture) WeightedAddress(name, ip string, ttl uint32, loc string, weight uint32) *Fixture {
+	return f.Line(fmt.Sprintf("+%s,%s,%d,,%s,%d", name, ip, ttl, loc, weight))
+}
+
+// CNAME adds a CNAME record for the location loc, all of them if empty
+func (f *Fixture) CNAME(name, target string, ttl uint32, loc string) *Fixture {
+	return f.Line(fmt.Sprintf("C%s,%s,%d,,%s", name, target, ttl, loc))
+}
+
+// MX adds an MX record
+func (f *Fixture) MX(name, host string, preference uint16, ttl uint32) *Fixture {
+	return f.Line(fmt.Sprintf("@%s,,%s,%d,%d,,", name, host, preference, ttl))
+}
+
+// TXT adds a TXT record; commas and colons of text are escaped
+func (f *Fixture) TXT(name, text string, ttl uint32) *Fixture {
+	text = strings.NewReplacer(",", `\054`, ":", `\072`).Replace(text)
+	return f.Line(fmt.Sprintf("'%s,%s,%d,,", name, text, ttl))
+}
+
+// ECSMap makes queries for name look up the location of their ECS subnet in
+// the map mapID; *.name covers the names below name
+func (f *Fixture) ECSMap(name, mapID string) *Fixture {
+	return f.Line(fmt.Sprintf("8%s,%s", name, mapID))
+}
+
+// ResolverMap makes queries for name look up the location of their resolver
+// IP in the map mapID; *.name covers the names below name
+func (f *Fixture) ResolverMap(name, mapID string) *Fixture {
+	return f.Line(fmt.Sprintf("M%s,%s", name, mapID))
+}
+
+// Subnet maps the subnet prefix, e.g. 192.0.2.0/24, to the location loc in
+// the map mapID
+func (f *Fixture) Subnet(mapID, prefix, loc string) *Fixture {
+	return f.Line(fmt.Sprintf("%%%s,%s,%s", loc, prefix, mapID))
+}
+
+// Data returns the fixture in the data format
+func (f *Fixture) Data() []byte {
+	var b strings.Builder
+	for _, line := range f.lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+// Build compiles the fixture into a temporary directory removed when t ends,
+// to a CDB and, when supported by the build, to RDBs with v1 and v2 keys. It
+// returns the test databases built, like TestDBs.
+func (f *Fixture) Build(t testing.TB) []TestDB {
+	t.Helper()
+	dir := t.TempDir()
+	input := path.Join(dir, inputFileName)
+	if err := os.WriteFile(input, f.Data(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// compilation logs progress, which is of no interest here
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dbs := []TestDB{{Driver: "cdb", Path: path.Join(dir, cdbFileName)}}
+	if _, err := cdb.CreateCDB(input, dbs[0].Path, cdb.NewDefaultCreatorOptions()); err != nil {
+		t.Fatalf("Error compiling fixture to CDB: %v", err)
+	}
+	if !RocksDB {
+		return dbs
+	}
+	for i, db := range []TestDB{TestRDB, TestRDBV2} {
+		db.Path = path.Join(dir, fmt.Sprintf("rdb-%d", i+1))
+		if err := compileRDB(input, db.Path, db.Flavour == TestRDBV2.Flavour); err != nil {
+			t.Fatalf("Error compiling fixture to RDB (%s): %v", db.Flavour, err)
+		}
+		dbs = append(dbs, db)
+	}
+	return dbs
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testaid_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/facebook/dns/dnsrocks/db"
+	"github.com/facebook/dns/dnsrocks/testaid"
+)
+
+func TestFixtureData(t *testing.T) {
+	require.Equal(t, `\000\002`, testaid.Loc(2))
+	require.Equal(t, `\001\377`, testaid.Loc(511))
+
+	f := testaid.NewFixture().
+		Zone("example.com", 300, "ns1.example.com", "ns2.example.com").
+		MX("example.com", "mx.example.com", 10, 300).
+		TXT("example.com", "v=1, a:b", 300).
+		CNAME("www.example.com", "web.example.net", 60, testaid.Loc(2)).
+		WeightedAddress("web.example.com", "2001:db8::1", 60, "", 5).
+		Line("# done")
+	require.Equal(t, `Zexample.com,ns1.example.com,hostmaster.example.com,1,7200,1800,604800,300,300,,
+&example.com,,ns1.example.com,300,,
+&example.com,,ns2.example.com,300,,
+@example.com,,mx.example.com,10,300,,
+'example.com,v=1\054 a\072b,300,,
+Cwww.example.com,web.example.net,60,,\000\002
++web.example.com,2001:db8::1,60,,,5
+# done
+`, string(f.Data()))
+}
+
+func TestFixtureBuild(t *testing.T) {
+	testDBs := testaid.NewFixture().
+		Zone("example.com", 300, "ns.example.com").
+		ECSMap("*.example.com", "ec").
+		Subnet("ec", "192.0.2.0/24", testaid.Loc(2)).
+		Subnet("ec", "0.0.0.0/0", testaid.Loc(1)).
+		Address("www.example.com", "198.51.100.1", 60, testaid.Loc(1)).
+		Address("www.example.com", "198.51.100.2", 60, testaid.Loc(2)).
+		Build(t)
+	if testaid.RocksDB {
+		require.Len(t, testDBs, 3)
+	} else {
+		require.Len(t, testDBs, 1)
+	}
+
+	qname := make([]byte, 255)
+	offset, err := dns.PackDomainName("www.example.com.", qname, 0, nil, false)
+	require.NoError(t, err)
+	qname = qname[:offset]
+
+	for _, testDB := range testDBs {
+		t.Run(testDB.Driver+"/"+testDB.Flavour, func(t *testing.T) {
+			d, err := db.Open(testDB.Path, testDB.Driver)
+			require.NoError(t, err)
+			defer d.Destroy()
+			r, err := db.NewReader(d)
+			require.NoError(t, err)
+			defer r.Close()
+
+			ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("192.0.2.0")}
+			loc, err := r.FindLocation(qname, ecs, "127.0.0.1")
+			require.NoError(t, err)
+			require.Equal(t, db.ID{0, 2}, loc.LocID)
+
+			a := new(dns.Msg)
+			_, rcode := r.FindAnswer(qname, qname[4:], "www.example.com.", dns.TypeA, loc.LocID, a, 1)
+			require.Equal(t, dns.RcodeSuccess, rcode)
+			require.Len(t, a.Answer, 1)
+			require.Equal(t, "198.51.100.2", a.Answer[0].(*dns.A).A.String())
+		})
+	}
+}
@@ -0,0 +1,69 @@
+//go:build cgo && !norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testaid
+
+import (
+	"log"
+	"os"
+
+	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
+)
+
+// RocksDB is true when the RocksDB test databases are supported by this
+// build, which takes cgo and no norocksdb tag
+const RocksDB = true
+
+// compileRDBs compiles the RDB test databases from input into tempdirs,
+// removed by cleanup. On failure, it returns the database and path which
+// failed to compile.
+func compileRDBs(input string) (cleanup func(), err error, errDB, errPath string) {
+	// create tempdir for RDB
+	rdbDir, err := os.MkdirTemp("", "rocksdb-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// create tempdir for RDB v2
+	rdbDirV2, err := os.MkdirTemp("", "rocksdb-v2-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	cleanup = func() {
+		os.RemoveAll(rdbDir)
+		os.RemoveAll(rdbDirV2)
+	}
+	TestRDB.Path = rdbDir // override path to RDB
+	TestRDBV2.Path = rdbDirV2
+
+	// compile RDB into tempdir
+	if err = compileRDB(input, rdbDir, false); err != nil {
+		return cleanup, err, "RDB", rdbDir
+	}
+	// compile RDB v2 into tempdir
+	if err = compileRDB(input, rdbDirV2, true); err != nil {
+		return cleanup, err, "RDBv2", rdbDirV2
+	}
+	return cleanup, nil, "", ""
+}
+
+// compileRDB compiles input to an RDB in dir, created if needed, with v2
+// keys if v2 is set
+func compileRDB(input, dir string, v2 bool) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	_, err := rdb.CompileToSpecificRDBVersion(input, dir, rdb.CompilationOptions{UseV2KeySyntax: v2})
+	return err
+}
@@ -0,0 +1,30 @@
+//go:build !cgo || norocksdb
+
+/*
+Copyright (c) Meta Platforms, Inc. and affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testaid
+
+// RocksDB is false when the RocksDB test databases are not supported by
+// this build, for lack of cgo or because of the norocksdb tag
+const RocksDB = false
+
+// compileRDBs does nothing, RocksDB not being supported
+func compileRDBs(_ string) (cleanup func(), err error, errDB, errPath string) {
+	return func() {}, nil, "", ""
+}
+
+// compileRDB does nothing, RocksDB not being supported
+func compileRDB(_, _ string, _ bool) error {
+	return nil
+}
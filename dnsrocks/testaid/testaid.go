@@ -30,7 +30,6 @@ import (
 	"time"
 
 	"github.com/facebook/dns/dnsrocks/dnsdata/cdb"
-	"github.com/facebook/dns/dnsrocks/dnsdata/rdb"
 	"github.com/facebook/dns/dnsrocks/testutils"
 
 	"github.com/stretchr/testify/require"
@@ -66,20 +65,6 @@ var TestDBs []TestDB
 
 // Run creates the test databases and runs the tests. It returns an exit code to pass to os.Exit.
 func Run(m *testing.M, relativePath string) int {
-	// create tempdir for RDB
-	rdbDir, err := os.MkdirTemp("", "rocksdb-test")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer os.RemoveAll(rdbDir)
-
-	// create tempdir for RDB v2
-	rdbDirV2, err := os.MkdirTemp("", "rocksdb-v2-test")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer os.RemoveAll(rdbDirV2)
-
 	// create tempdir for CDB
 	cdbDir, err := os.MkdirTemp("", "cdb-test")
 	if err != nil {
@@ -93,40 +78,39 @@ func Run(m *testing.M, relativePath string) int {
 
 	// temporarily suppress output to make test suite happy
 	// (otherwise any output from CompileRDB() will fail the test
-	err, errDB, errPath := func() (error, string, string) {
+	cleanup, err, errDB, errPath := func() (func(), error, string, string) {
 		log.SetOutput(io.Discard)
 		defer log.SetOutput(os.Stderr)
-		// compile RDB into tempdir
-		o := rdb.CompilationOptions{}
-		_, err = rdb.CompileToSpecificRDBVersion(fullInputFileName, rdbDir, o)
-		if err != nil {
-			return err, "RDB", rdbDir
-		}
-		// compile RDB v2 into tempdir
-		o.UseV2KeySyntax = true
-		_, err = rdb.CompileToSpecificRDBVersion(fullInputFileName, rdbDirV2, o)
+		// compile RDBs into tempdirs, if supported
+		cleanup, err, errDB, errPath := compileRDBs(fullInputFileName)
 		if err != nil {
-			return err, "RDBv2", rdbDirV2
+			return cleanup, err, errDB, errPath
 		}
 		// compile CDB into tempdir
 		creatorOptions := cdb.NewDefaultCreatorOptions()
 		_, err = cdb.CreateCDB(fullInputFileName, TestCDB.Path, creatorOptions)
-		return err, "CDB", TestCDB.Path
+		return cleanup, err, "CDB", TestCDB.Path
 	}()
+	defer cleanup()
 	if err != nil {
 		log.Fatalf("Error compiling %s (%s) to %s: %s", inputFileName, errDB, errPath, err)
 	}
 	TestCDBBad.Path = testutils.FixturePath(relativePath, inputFileName) // path to CDB should be relative to test executable
-	TestRDB.Path = rdbDir                                                // override path to RDB
-	TestRDBV2.Path = rdbDirV2
-	TestDBs = []TestDB{
-		TestCDB,
-		TestRDB,
-		TestRDBV2,
+	TestDBs = []TestDB{TestCDB}
+	if RocksDB {
+		TestDBs = append(TestDBs, TestRDB, TestRDBV2)
 	}
 	return m.Run()
 }
 
+// RequireRocksDB skips the test if the RocksDB test databases are not
+// supported by this build
+func RequireRocksDB(t testing.TB) {
+	if !RocksDB {
+		t.Skip("rocksdb driver is not supported by this build")
+	}
+}
+
 func pemBlockForKey(priv interface{}) *pem.Block {
 	switch k := priv.(type) {
 	case *rsa.PrivateKey:
@@ -205,9 +205,16 @@ func (r *Reader) ReadTCP(conn net.Conn, timeout time.Duration) ([]byte, error) {
 }
 
 // Monitor the number of active queries and record it in the stats
-// as a percentage of the maximum allowed.
-func Monitor(lim *Limiter, stats stats.Stats, interval time.Duration) {
-	for range time.Tick(interval) {
-		stats.AddSample(statName, (100*lim.Count())/lim.limit)
+// as a percentage of the maximum allowed, until done is closed.
+func Monitor(lim *Limiter, stats stats.Stats, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			stats.AddSample(statName, (100*lim.Count())/lim.limit)
+		}
 	}
 }
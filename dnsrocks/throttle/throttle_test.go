@@ -16,6 +16,7 @@ package throttle
 import (
 	"context"
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -23,6 +24,8 @@ import (
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/semaphore"
+
+	"github.com/facebook/dns/dnsrocks/dnsserver/stats"
 )
 
 func TestConcurrencyReader(t *testing.T) {
@@ -151,3 +154,35 @@ func TestCount(t *testing.T) {
 	require.Error(t, err)
 	require.EqualValues(t, 1, l.Count())
 }
+
+func TestMonitor(t *testing.T) {
+	l, err := NewLimiter(4)
+	require.NoError(t, err)
+	require.NoError(t, l.acquire(context.Background()))
+
+	s := &sampleStats{}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		Monitor(l, s, time.Millisecond, done)
+		close(stopped)
+	}()
+	require.Eventually(t, func() bool { return s.last.Load() == 25 }, 5*time.Second, time.Millisecond)
+
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Monitor still running after done was closed")
+	}
+}
+
+// sampleStats keeps the last sample added
+type sampleStats struct {
+	stats.DummyStats
+	last atomic.Int64
+}
+
+func (s *sampleStats) AddSample(_ string, value int64) {
+	s.last.Store(value)
+}
@@ -14,23 +14,33 @@ limitations under the License.
 package whoami
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"net"
 	"strings"
 
+	"github.com/facebook/dns/dnsrocks/db"
 	"github.com/facebook/dns/dnsrocks/debuginfo"
+	"github.com/facebook/dns/dnsrocks/dnsdata"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
 )
 
+// Locator returns the location the DB matches for a query on qname sent by
+// the client of state
+type Locator func(qname string, state request.Request) (*db.Location, error)
+
 // Handler is the base struct
 // representing the Handler
 type Handler struct {
 	whoamiDomain string
 	infoGen      func() debuginfo.InfoSrc
-	Next         plugin.Handler
+	// locate is set for debug zones, see NewDebugZone
+	locate Locator
+	Next   plugin.Handler
 }
 
 // NewWhoami initializes a new whoami Handler.
@@ -41,9 +51,39 @@ func NewWhoami(d string, privateInfo bool) (*Handler, error) {
 	return wh, nil
 }
 
+// NewDebugZone initializes a whoami Handler answering for the zone d and the
+// names below it. On top of the whoami TXT records, it returns the map and
+// location the DB matches for the client, and the resolver IP in A or AAAA
+// records. Queries below d, e.g. www.example.com.<d>, get the location
+// matched for the name in front of d, www.example.com.
+func NewDebugZone(d string, privateInfo bool, locate Locator) (*Handler, error) {
+	if locate == nil {
+		return nil, fmt.Errorf("no locator for debug zone %s", d)
+	}
+	wh, err := NewWhoami(d, privateInfo)
+	if err != nil {
+		return nil, err
+	}
+	wh.locate = locate
+	return wh, nil
+}
+
+// lookupName returns the name the location of a query on qname is looked up
+// for, false if qname is not handled
+func (wh *Handler) lookupName(qname string) (string, bool) {
+	if len(qname) == len(wh.whoamiDomain) && strings.ToLower(qname) == wh.whoamiDomain {
+		return qname, true
+	}
+	if wh.locate == nil || !dns.IsSubDomain(wh.whoamiDomain, strings.ToLower(qname)) {
+		return "", false
+	}
+	return qname[:len(qname)-len(wh.whoamiDomain)], true
+}
+
 // ServeDNS serves whoami queries
 func (wh *Handler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
-	if len(r.Question[0].Name) != len(wh.whoamiDomain) || strings.ToLower(r.Question[0].Name) != wh.whoamiDomain {
+	name, ok := wh.lookupName(r.Question[0].Name)
+	if !ok {
 		return plugin.NextOrFailure(wh.Name(), wh.Next, ctx, w, r)
 	}
 	state := request.Request{W: w, Req: r}
@@ -52,19 +92,37 @@ func (wh *Handler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 	m.SetReply(r)
 	m.Compress = true
 	m.Authoritative = true
-	if state.QType() == dns.TypeTXT {
+	hdr := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: r.Question[0].Name, Rrtype: rrtype, Class: state.QClass()}
+	}
+	switch state.QType() {
+	case dns.TypeTXT:
 		mkTxt := func(key, value string) dns.RR {
-			var rr dns.RR = new(dns.TXT)
-			rr.(*dns.TXT).Hdr = dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: state.QClass()}
-			rr.(*dns.TXT).Txt = []string{fmt.Sprintf("%s %s", key, value)}
-			return rr
+			return &dns.TXT{Hdr: hdr(dns.TypeTXT), Txt: []string{fmt.Sprintf("%s %s", key, value)}}
 		}
 		info := wh.infoGen().GetInfo(state)
+		if wh.locate != nil {
+			loc, err := wh.locate(name, state)
+			if err != nil {
+				return dns.RcodeServerFailure, err
+			}
+			addLocation(info, loc)
+		}
 		for _, pair := range *info {
 			if len(pair.Val) > 0 {
 				m.Answer = append(m.Answer, mkTxt(pair.Key, pair.Val))
 			}
 		}
+	case dns.TypeA, dns.TypeAAAA:
+		if wh.locate == nil {
+			break
+		}
+		ip := net.ParseIP(state.IP())
+		if ip4 := ip.To4(); ip4 != nil && state.QType() == dns.TypeA {
+			m.Answer = append(m.Answer, &dns.A{Hdr: hdr(dns.TypeA), A: ip4})
+		} else if ip4 == nil && ip != nil && state.QType() == dns.TypeAAAA {
+			m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr(dns.TypeAAAA), AAAA: ip})
+		}
 	}
 
 	state.SizeAndDo(m)
@@ -76,5 +134,16 @@ func (wh *Handler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 	return dns.RcodeSuccess, nil
 }
 
+// addLocation adds the map and location IDs to info, escaped as in data files
+func addLocation(info *debuginfo.Values, loc *db.Location) {
+	if loc == nil {
+		return
+	}
+	info.Add("map", dnsdata.Lmap(loc.MapID.Contents()).String())
+	var lo bytes.Buffer
+	dnsdata.Putloctext(&lo, dnsdata.Loc(loc.LocID.Contents()))
+	info.Add("location", lo.String())
+}
+
 // Name returns the handlers name
 func (wh *Handler) Name() string { return "whoami" }
@@ -20,9 +20,11 @@ import (
 	"time"
 
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
 
+	"github.com/facebook/dns/dnsrocks/db"
 	"github.com/facebook/dns/dnsrocks/debuginfo"
 	"github.com/facebook/dns/dnsrocks/dnsserver/test"
 )
@@ -98,3 +100,93 @@ func TestHandlerValidRequestNonTxt(t *testing.T) {
 	require.Equal(t, len(rec.Msg.Answer), 0, "No answers in the answer section.")
 	require.Equal(t, req.MsgHdr.Id, rec.Msg.MsgHdr.Id, "Request and response IDs should match.")
 }
+
+// TestDebugZone checks that debug zones answer for names below them with the
+// location matched for the name in front of the zone.
+func TestDebugZone(t *testing.T) {
+	var locatedName string
+	locate := func(qname string, _ request.Request) (*db.Location, error) {
+		locatedName = qname
+		return &db.Location{MapID: db.ID("ec"), LocID: db.ID{0, 2}}, nil
+	}
+	_, err := NewDebugZone("whoami.dnsrocks.arpa", false, nil)
+	require.Error(t, err)
+	wh, err := NewDebugZone("whoami.dnsrocks.arpa", false, locate)
+	require.NoError(t, err)
+	wh.infoGen = func() debuginfo.InfoSrc {
+		src := debuginfo.MockInfoSrc([]debuginfo.Pair{{Key: "source", Val: "198.51.100.10:40212"}})
+		return &src
+	}
+
+	testCases := []struct {
+		qname       string
+		locatedName string
+	}{
+		{qname: "whoami.dnsrocks.arpa.", locatedName: "whoami.dnsrocks.arpa."},
+		{qname: "WWW.Example.com.Whoami.dnsrocks.arpa.", locatedName: "WWW.Example.com."},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.qname, func(t *testing.T) {
+			w := &test.ResponseWriterCustomRemote{RemoteIP: "198.51.100.10"}
+			req := new(dns.Msg)
+			req.SetQuestion(tc.qname, dns.TypeTXT)
+			rec := dnstest.NewRecorder(w)
+			rc, err := wh.ServeDNS(context.TODO(), rec, req)
+			require.NoError(t, err)
+			require.Equal(t, dns.RcodeSuccess, rc)
+			require.Equal(t, tc.locatedName, locatedName)
+			var txts []string
+			for _, rr := range rec.Msg.Answer {
+				require.Equal(t, tc.qname, rr.Header().Name)
+				txts = append(txts, rr.(*dns.TXT).Txt[0])
+			}
+			require.Equal(t, []string{"source 198.51.100.10:40212", `map \145\143`, `location \000\002`}, txts)
+		})
+	}
+
+	for _, tc := range []struct {
+		remoteIP string
+		qtype    uint16
+		answer   string
+	}{
+		{remoteIP: "198.51.100.10", qtype: dns.TypeA, answer: "198.51.100.10"},
+		{remoteIP: "198.51.100.10", qtype: dns.TypeAAAA},
+		{remoteIP: "2001:db8::1", qtype: dns.TypeAAAA, answer: "2001:db8::1"},
+		{remoteIP: "2001:db8::1", qtype: dns.TypeA},
+	} {
+		w := &test.ResponseWriterCustomRemote{RemoteIP: tc.remoteIP}
+		req := new(dns.Msg)
+		req.SetQuestion("whoami.dnsrocks.arpa.", tc.qtype)
+		rec := dnstest.NewRecorder(w)
+		_, err := wh.ServeDNS(context.TODO(), rec, req)
+		require.NoError(t, err)
+		if tc.answer == "" {
+			require.Empty(t, rec.Msg.Answer)
+			continue
+		}
+		require.Len(t, rec.Msg.Answer, 1)
+		switch rr := rec.Msg.Answer[0].(type) {
+		case *dns.A:
+			require.Equal(t, tc.answer, rr.A.String())
+		case *dns.AAAA:
+			require.Equal(t, tc.answer, rr.AAAA.String())
+		}
+	}
+
+	// other names go to the next handler
+	req := new(dns.Msg)
+	req.SetQuestion("dnsrocks.arpa.", dns.TypeTXT)
+	_, err = wh.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	require.Error(t, err)
+}
+
+// TestWhoamiSubdomain checks that plain whoami handlers don't answer for the
+// names below their domain.
+func TestWhoamiSubdomain(t *testing.T) {
+	wh, err := NewWhoami("example.com", false)
+	require.NoError(t, err)
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeTXT)
+	_, err = wh.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), req)
+	require.Error(t, err)
+}
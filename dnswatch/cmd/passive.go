@@ -0,0 +1,127 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/facebook/dns/dnswatch/passive"
+	"github.com/facebook/dns/dnswatch/snoop"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	passiveDuration time.Duration
+	passiveTop      int
+	passiveLog      string
+)
+
+func init() {
+	passiveCmd.Flags().DurationVar(&passiveDuration, "duration", time.Minute, "how long to capture traffic for, until interrupted if 0")
+	passiveCmd.Flags().IntVar(&passiveTop, "top", 20, "number of query names and clients to report, all of them if 0")
+	passiveCmd.Flags().StringVar(&passiveLog, "dnsrocks-log", "", "dnsrocks text log of the capture period, to report the queries which were not logged")
+	RootCmd.AddCommand(passiveCmd)
+}
+
+// runPassive captures the traffic of the server port and reports it
+func runPassive() error {
+	decoder, err := snoop.RawDecoderByType("dns")
+	if err != nil {
+		return fmt.Errorf("unable to get decoder: %w", err)
+	}
+	filter := &snoop.Filter{
+		Rule:       fmt.Sprintf("port %d", cfg.Port),
+		Interface:  cfg.Interface,
+		RingSizeMB: cfg.RingSizeMB,
+	}
+	if err := filter.Setup(); err != nil {
+		return fmt.Errorf("unable to setup BPF filter: %w", err)
+	}
+
+	ch := make(chan *snoop.FilterDTO, 1024)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- filter.Run(decoder, ch)
+	}()
+
+	var done <-chan time.Time
+	if passiveDuration > 0 {
+		done = time.After(passiveDuration)
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	agg := passive.NewAggregator(cfg.Port)
+capture:
+	for {
+		select {
+		case dto := <-ch:
+			agg.Observe(dto.SrcAddr, dto.SrcPort, dto.DstAddr, dto.DstPort, dto.DNS)
+		case err := <-errCh:
+			return err
+		case <-done:
+			break capture
+		case <-sigs:
+			break capture
+		}
+	}
+
+	var drops []passive.Drop
+	if passiveLog != "" {
+		f, err := os.Open(passiveLog)
+		if err != nil {
+			return fmt.Errorf("unable to open dnsrocks log: %w", err)
+		}
+		defer f.Close()
+		logged, err := passive.ParseTextLog(f)
+		if err != nil {
+			return fmt.Errorf("unable to parse dnsrocks log: %w", err)
+		}
+		drops = passive.Diff(agg.Queries(), logged)
+		if drops == nil {
+			drops = []passive.Drop{}
+		}
+	}
+	return passive.WriteReport(os.Stdout, agg, passiveTop, drops)
+}
+
+var passiveCmd = &cobra.Command{
+	Use:   "passive",
+	Short: "Passively capture the DNS traffic of a server and report it per query name and client",
+	Long: `Passively capture the DNS traffic of a server and report it per query name and client
+
+Queries are the packets to the server port, responses the packets from it.
+Given the dnsrocks text log of the capture period, also reports the queries
+observed on the wire which were not logged, i.e. dropped before the handler.
+
+Usage example:
+  dnswatch passive --duration 30s --dnsrocks-log /var/log/dnsrocks.log
+`,
+
+	Run: func(_ *cobra.Command, _ []string) {
+		ConfigureVerbosity()
+
+		if err := runPassive(); err != nil {
+			log.Fatalf("unable to run passive capture: %v", err)
+		}
+		// the filter keeps reading packets, stop immediately like snoop does
+		os.Exit(0)
+	},
+}
@@ -0,0 +1,81 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package passive
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ParseTextLog counts the queries logged by the dnsrocks text logger, one
+// "[client] PROTO qname TYPE" line per query
+func ParseTextLog(r io.Reader) (map[Key]int, error) {
+	logged := make(map[Key]int)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 4 || !strings.HasPrefix(fields[0], "[") || !strings.HasSuffix(fields[0], "]") {
+			return nil, fmt.Errorf("line %d: unexpected log line %q", line, text)
+		}
+		key := Key{
+			Client: strings.TrimSuffix(strings.TrimPrefix(fields[0], "["), "]"),
+			QName:  normalizeName(fields[2]),
+			QType:  fields[3],
+		}
+		logged[key]++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read log: %w", err)
+	}
+	return logged, nil
+}
+
+// Drop is a number of queries observed on the wire but not logged
+type Drop struct {
+	Key
+	Observed int
+	Logged   int
+}
+
+// Missing returns the number of queries which were not logged
+func (d Drop) Missing() int {
+	return d.Observed - d.Logged
+}
+
+// Diff returns the keys with more queries observed than logged, the most
+// missing first. Observed and logged queries must cover the same period.
+func Diff(observed, logged map[Key]int) []Drop {
+	var drops []Drop
+	for k, n := range observed {
+		if n > logged[k] {
+			drops = append(drops, Drop{Key: k, Observed: n, Logged: logged[k]})
+		}
+	}
+	sort.Slice(drops, func(i, j int) bool {
+		if drops[i].Missing() != drops[j].Missing() {
+			return drops[i].Missing() > drops[j].Missing()
+		}
+		return drops[i].Key.String() < drops[j].Key.String()
+	})
+	return drops
+}
@@ -0,0 +1,55 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package passive
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTextLog(t *testing.T) {
+	logged, err := ParseTextLog(strings.NewReader(`[198.51.100.1] UDP www.example.com. A
+[198.51.100.1] TCP www.example.com. A
+
+[2001:db8::1] UDP www.example.com. AAAA
+`))
+	require.NoError(t, err)
+	require.Equal(t, map[Key]int{
+		{Client: "198.51.100.1", QName: "www.example.com.", QType: "A"}:   2,
+		{Client: "2001:db8::1", QName: "www.example.com.", QType: "AAAA"}: 1,
+	}, logged)
+
+	_, err = ParseTextLog(strings.NewReader("198.51.100.1 UDP www.example.com. A\n"))
+	require.Error(t, err)
+	_, err = ParseTextLog(strings.NewReader("[198.51.100.1] UDP www.example.com.\n"))
+	require.Error(t, err)
+}
+
+func TestDiff(t *testing.T) {
+	a := Key{Client: "198.51.100.1", QName: "www.example.com.", QType: "A"}
+	aaaa := Key{Client: "198.51.100.1", QName: "www.example.com.", QType: "AAAA"}
+	nx := Key{Client: "2001:db8::1", QName: "nx.example.com.", QType: "A"}
+	observed := map[Key]int{a: 3, aaaa: 1, nx: 4}
+	logged := map[Key]int{a: 2, aaaa: 1, nx: 1}
+
+	drops := Diff(observed, logged)
+	require.Equal(t, []Drop{
+		{Key: nx, Observed: 4, Logged: 1},
+		{Key: a, Observed: 3, Logged: 2},
+	}, drops)
+	require.Equal(t, 3, drops[0].Missing())
+	require.Empty(t, Diff(logged, logged))
+}
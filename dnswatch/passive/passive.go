@@ -0,0 +1,183 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package passive aggregates the DNS traffic of a server, as observed on the
+// wire, and compares it with the queries the server logged, to find the ones
+// dropped before reaching the handler.
+package passive
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/gopacket/layers"
+	"github.com/miekg/dns"
+)
+
+// Key identifies the queries of a client for a name and type
+type Key struct {
+	Client string
+	QName  string
+	QType  string
+}
+
+// String returns the key the way dnsrocks logs queries
+func (k Key) String() string {
+	return fmt.Sprintf("[%s] %s %s", k.Client, k.QName, k.QType)
+}
+
+// Counts are the numbers of queries and responses observed
+type Counts struct {
+	Queries   int
+	Responses int
+	Rcodes    map[string]int
+}
+
+// Unanswered returns the number of queries without a response
+func (c *Counts) Unanswered() int {
+	if c.Responses > c.Queries {
+		return 0
+	}
+	return c.Queries - c.Responses
+}
+
+// Aggregator aggregates the queries to a server and its responses per query
+// name and per client
+type Aggregator struct {
+	// Port is the port the server listens on
+	Port int
+
+	sync.Mutex
+	queries   map[Key]int
+	perQName  map[string]*Counts
+	perClient map[string]*Counts
+}
+
+// NewAggregator returns an Aggregator of the traffic of a server listening on
+// port
+func NewAggregator(port int) *Aggregator {
+	return &Aggregator{
+		Port:      port,
+		queries:   make(map[Key]int),
+		perQName:  make(map[string]*Counts),
+		perClient: make(map[string]*Counts),
+	}
+}
+
+// normalizeName returns name spelled the way dnsrocks logs it: lower case and
+// fully qualified
+func normalizeName(name string) string {
+	return dns.Fqdn(strings.ToLower(name))
+}
+
+func counts(m map[string]*Counts, key string) *Counts {
+	c, ok := m[key]
+	if !ok {
+		c = &Counts{Rcodes: make(map[string]int)}
+		m[key] = c
+	}
+	return c
+}
+
+// Observe accounts a DNS packet captured between src and dst. Queries are
+// packets to the server port, responses packets from it, others are ignored.
+func (a *Aggregator) Observe(src net.IP, srcPort uint16, dst net.IP, dstPort uint16, d *layers.DNS) {
+	if d == nil || len(d.Questions) == 0 {
+		return
+	}
+	var client net.IP
+	switch {
+	case !d.QR && int(dstPort) == a.Port:
+		client = src
+	case d.QR && int(srcPort) == a.Port:
+		client = dst
+	default:
+		return
+	}
+	q := d.Questions[0]
+	key := Key{
+		Client: client.String(),
+		QName:  normalizeName(string(q.Name)),
+		QType:  dns.Type(q.Type).String(),
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	qc := counts(a.perQName, key.QName)
+	cc := counts(a.perClient, key.Client)
+	if !d.QR {
+		a.queries[key]++
+		qc.Queries++
+		cc.Queries++
+		return
+	}
+	rcode := dns.RcodeToString[int(d.ResponseCode)]
+	qc.Responses++
+	qc.Rcodes[rcode]++
+	cc.Responses++
+	cc.Rcodes[rcode]++
+}
+
+// Entry is the Counts of a query name or a client
+type Entry struct {
+	Name string
+	Counts
+}
+
+// top returns the n entries of m with the most queries, all of them if n is 0
+func top(m map[string]*Counts, n int) []Entry {
+	entries := make([]Entry, 0, len(m))
+	for name, c := range m {
+		entries = append(entries, Entry{Name: name, Counts: *c})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Queries != entries[j].Queries {
+			return entries[i].Queries > entries[j].Queries
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// TopQNames returns the n query names with the most queries, all of them if n
+// is 0
+func (a *Aggregator) TopQNames(n int) []Entry {
+	a.Lock()
+	defer a.Unlock()
+	return top(a.perQName, n)
+}
+
+// TopClients returns the n clients with the most queries, all of them if n is
+// 0
+func (a *Aggregator) TopClients(n int) []Entry {
+	a.Lock()
+	defer a.Unlock()
+	return top(a.perClient, n)
+}
+
+// Queries returns the number of queries observed per key
+func (a *Aggregator) Queries() map[Key]int {
+	a.Lock()
+	defer a.Unlock()
+	queries := make(map[Key]int, len(a.queries))
+	for k, v := range a.queries {
+		queries[k] = v
+	}
+	return queries
+}
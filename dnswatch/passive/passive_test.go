@@ -0,0 +1,97 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package passive
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	serverIP = net.ParseIP("192.0.2.53")
+	client1  = net.ParseIP("198.51.100.1")
+	client2  = net.ParseIP("2001:db8::1")
+)
+
+func dnsPacket(name string, qtype layers.DNSType, response bool, rcode layers.DNSResponseCode) *layers.DNS {
+	return &layers.DNS{
+		ID:           1,
+		QR:           response,
+		ResponseCode: rcode,
+		Questions:    []layers.DNSQuestion{{Name: []byte(name), Type: qtype, Class: layers.DNSClassIN}},
+	}
+}
+
+func observeExchange(a *Aggregator, client net.IP, name string, qtype layers.DNSType, rcode layers.DNSResponseCode, answered bool) {
+	a.Observe(client, 40000, serverIP, 53, dnsPacket(name, qtype, false, 0))
+	if answered {
+		a.Observe(serverIP, 53, client, 40000, dnsPacket(name, qtype, true, rcode))
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	a := NewAggregator(53)
+	observeExchange(a, client1, "www.example.com", layers.DNSTypeA, layers.DNSResponseCodeNoErr, true)
+	observeExchange(a, client1, "WWW.example.com", layers.DNSTypeA, layers.DNSResponseCodeNoErr, false)
+	observeExchange(a, client2, "www.example.com", layers.DNSTypeAAAA, layers.DNSResponseCodeNoErr, true)
+	observeExchange(a, client2, "nx.example.com", layers.DNSTypeA, layers.DNSResponseCodeNXDomain, true)
+	// traffic of other ports
+	a.Observe(client1, 40000, serverIP, 5353, dnsPacket("www.example.com", layers.DNSTypeA, false, 0))
+	a.Observe(serverIP, 5353, client1, 40000, dnsPacket("www.example.com", layers.DNSTypeA, true, 0))
+	a.Observe(client1, 40000, serverIP, 53, &layers.DNS{ID: 1})
+
+	qnames := a.TopQNames(0)
+	require.Equal(t, []Entry{
+		{Name: "www.example.com.", Counts: Counts{Queries: 3, Responses: 2, Rcodes: map[string]int{"NOERROR": 2}}},
+		{Name: "nx.example.com.", Counts: Counts{Queries: 1, Responses: 1, Rcodes: map[string]int{"NXDOMAIN": 1}}},
+	}, qnames)
+	require.Equal(t, 1, qnames[0].Unanswered())
+	require.Len(t, a.TopQNames(1), 1)
+
+	clients := a.TopClients(0)
+	require.Equal(t, []string{"198.51.100.1", "2001:db8::1"}, []string{clients[0].Name, clients[1].Name})
+	require.Equal(t, 2, clients[0].Queries)
+	require.Equal(t, 2, clients[1].Responses)
+
+	require.Equal(t, map[Key]int{
+		{Client: "198.51.100.1", QName: "www.example.com.", QType: "A"}:   2,
+		{Client: "2001:db8::1", QName: "www.example.com.", QType: "AAAA"}: 1,
+		{Client: "2001:db8::1", QName: "nx.example.com.", QType: "A"}:     1,
+	}, a.Queries())
+}
+
+func TestWriteReport(t *testing.T) {
+	a := NewAggregator(53)
+	observeExchange(a, client1, "www.example.com", layers.DNSTypeA, layers.DNSResponseCodeNoErr, true)
+
+	var b bytes.Buffer
+	require.NoError(t, WriteReport(&b, a, 10, nil))
+	require.Equal(t, `QNAME             QUERIES  RESPONSES  UNANSWERED  RCODES
+www.example.com.  1        1          0           NOERROR:1
+
+CLIENT        QUERIES  RESPONSES  UNANSWERED  RCODES
+198.51.100.1  1        1          0           NOERROR:1
+`, b.String())
+
+	b.Reset()
+	drops := []Drop{{Key: Key{Client: "198.51.100.1", QName: "www.example.com.", QType: "A"}, Observed: 1}}
+	require.NoError(t, WriteReport(&b, a, 10, drops))
+	require.Contains(t, b.String(), `NOT LOGGED                         OBSERVED  LOGGED  MISSING
+[198.51.100.1] www.example.com. A  1         0       1
+`)
+}
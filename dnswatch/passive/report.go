@@ -0,0 +1,63 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package passive
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// rcodesString returns the response codes of c, sorted
+func rcodesString(c Counts) string {
+	rcodes := make([]string, 0, len(c.Rcodes))
+	for rcode, n := range c.Rcodes {
+		rcodes = append(rcodes, fmt.Sprintf("%s:%d", rcode, n))
+	}
+	sort.Strings(rcodes)
+	return strings.Join(rcodes, ",")
+}
+
+func writeEntries(w io.Writer, title string, entries []Entry) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tQUERIES\tRESPONSES\tUNANSWERED\tRCODES\n", title)
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", e.Name, e.Queries, e.Responses, e.Unanswered(), rcodesString(e.Counts))
+	}
+	return tw.Flush()
+}
+
+// WriteReport writes the n query names and clients with the most queries, and
+// the drops if not nil
+func WriteReport(w io.Writer, a *Aggregator, n int, drops []Drop) error {
+	if err := writeEntries(w, "QNAME", a.TopQNames(n)); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	if err := writeEntries(w, "CLIENT", a.TopClients(n)); err != nil {
+		return err
+	}
+	if drops == nil {
+		return nil
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "NOT LOGGED\tOBSERVED\tLOGGED\tMISSING\n")
+	for _, d := range drops {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", d.Key, d.Observed, d.Logged, d.Missing())
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,121 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package control
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/facebook/dns/goose/query"
+	"github.com/facebook/dns/goose/report"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Status is returned by all endpoints changing the state of the run
+type Status struct {
+	// Status is one of query.StatusRunning, query.StatusPaused or query.StatusStopped
+	Status string
+	// TargetQPS is the QPS currently targeted, 0 if unknown or unlimited
+	TargetQPS int
+}
+
+// Server exposes an HTTP API to control a run in daemon mode
+type Server struct {
+	Addr     string
+	RunState *query.RunState
+}
+
+// Handler returns the handler serving the API:
+//
+//	GET  /health      returns 200 and the status of the run
+//	GET  /stats       returns the results of the run, in the -report-json format
+//	POST /start       starts sending queries, a stopped run starts over with fresh results
+//	POST /pause       stops sending queries, results are kept
+//	POST /stop        stops sending queries, results are reset on start
+//	POST /qps?qps=N   replaces the load profile by a constant rate of N queries per second, 0 means unlimited
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.get(s.writeStatus))
+	mux.HandleFunc("/stats", s.get(s.handleStats))
+	mux.HandleFunc("/start", s.post(s.RunState.Start))
+	mux.HandleFunc("/pause", s.post(s.RunState.Pause))
+	mux.HandleFunc("/stop", s.post(s.RunState.Stop))
+	mux.HandleFunc("/qps", s.handleQPS)
+	return mux
+}
+
+// ListenAndServe serves the API on s.Addr
+func (s *Server) ListenAndServe() error {
+	log.Infof("Starting control API at %q", s.Addr)
+	return http.ListenAndServe(s.Addr, s.Handler())
+}
+
+func (s *Server) get(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) post(action func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		action()
+		log.Infof("Control API: %s, run is now %s", r.URL.Path, s.RunState.Status())
+		s.writeStatus(w, r)
+	}
+}
+
+func (s *Server) writeStatus(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(Status{
+		Status:    s.RunState.Status(),
+		TargetQPS: s.RunState.TargetQPS(),
+	})
+	if err != nil {
+		log.Errorf("Failed to write status: %v", err)
+	}
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := report.WriteJSON(w, s.RunState.ExportResults()); err != nil {
+		log.Errorf("Failed to write stats: %v", err)
+	}
+}
+
+func (s *Server) handleQPS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	qps, err := strconv.Atoi(r.URL.Query().Get("qps"))
+	if err == nil {
+		err = s.RunState.SetMaxQPS(qps)
+	}
+	if err != nil {
+		http.Error(w, "qps must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Control API: max qps is now %d", qps)
+	s.writeStatus(w, r)
+}
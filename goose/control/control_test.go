@@ -0,0 +1,65 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package control
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/facebook/dns/goose/query"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/ratelimit"
+)
+
+func do(t *testing.T, h http.Handler, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func requireStatus(t *testing.T, w *httptest.ResponseRecorder, expected Status) {
+	require.Equal(t, http.StatusOK, w.Code)
+	var status Status
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.Equal(t, expected, status)
+}
+
+func TestControlAPI(t *testing.T) {
+	runState := query.NewRunState(0, ratelimit.NewUnlimited(), true, time.Now)
+	h := (&Server{RunState: runState}).Handler()
+
+	requireStatus(t, do(t, h, http.MethodGet, "/health"), Status{Status: query.StatusRunning})
+	requireStatus(t, do(t, h, http.MethodPost, "/pause"), Status{Status: query.StatusPaused})
+	requireStatus(t, do(t, h, http.MethodPost, "/start"), Status{Status: query.StatusRunning})
+	requireStatus(t, do(t, h, http.MethodPost, "/stop"), Status{Status: query.StatusStopped})
+	requireStatus(t, do(t, h, http.MethodPost, "/qps?qps=500"), Status{Status: query.StatusStopped, TargetQPS: 500})
+	requireStatus(t, do(t, h, http.MethodPost, "/start"), Status{Status: query.StatusRunning, TargetQPS: 500})
+	requireStatus(t, do(t, h, http.MethodPost, "/qps?qps=0"), Status{Status: query.StatusRunning})
+
+	require.Equal(t, http.StatusBadRequest, do(t, h, http.MethodPost, "/qps?qps=-1").Code)
+	require.Equal(t, http.StatusBadRequest, do(t, h, http.MethodPost, "/qps").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, do(t, h, http.MethodGet, "/start").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, do(t, h, http.MethodPost, "/stats").Code)
+
+	w := do(t, h, http.MethodGet, "/stats")
+	require.Equal(t, http.StatusOK, w.Code)
+	var stats map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	require.Contains(t, stats, "Processed")
+	require.Contains(t, stats, "Errors")
+}
@@ -14,8 +14,10 @@ limitations under the License.
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -25,6 +27,8 @@ import (
 
 	_ "net/http/pprof"
 
+	"github.com/facebook/dns/goose/control"
+	"github.com/facebook/dns/goose/flagconfig"
 	"github.com/facebook/dns/goose/query"
 	"github.com/facebook/dns/goose/report"
 	"github.com/facebook/dns/goose/stats"
@@ -57,32 +61,96 @@ var (
 	parallelConnections int
 	reportJSON          bool
 	inputFile           string
+	scenarioFile        string
 	exporterAddr        string
+	protocol            string
+	tlsInsecure         bool
+	tlsServerName       string
+	dohPath             string
+	loadProfile         string
+	startQPS            int
+	stepQPS             int
+	stepDuration        time.Duration
+	sinePeriod          time.Duration
+	ednsBufSize         uint
+	ednsDO              bool
+	ecsSubnets          string
+	ecsRandom           bool
+	ecsIPv4PrefixLen    int
+	ecsIPv6PrefixLen    int
+	ednsCookie          bool
+	controlAddr         string
+	backoffThreshold    float64
+	backoffFactor       float64
+	backoffWindow       time.Duration
+	sourcePrefix        string
+	randomSourcePort    bool
+	retryTCP            bool
+	targetsStr          string
+	targetsFile         string
+	latencyBuckets      string
+	jitterBuckets       string
+	nativeHistFactor    float64
 )
 
 func main() {
+	configFlags := flagconfig.Register(flag.CommandLine)
 	flag.BoolVar(&daemon, "daemon", false,
 		"Running in daemon mode means that metrics will be exported rather than printed to stdout")
 	flag.StringVar(&logLevel, "loglevel", "info", "Set a log level. Can be: debug, info, warning, error")
 	flag.IntVar(&totalQueries, "total-queries", 50000, "Total queries to send")
-	flag.IntVar(&dport, "port", 53, "destination port")
+	flag.IntVar(&dport, "port", 53, "destination port (defaults to 853 for dot/doq and 443 for doh)")
+	flag.StringVar(&protocol, "protocol", query.ProtocolUDP, "Transport protocol used to send queries. Can be: udp, tcp, dot, doh, doq")
+	flag.BoolVar(&tlsInsecure, "tls-insecure", false, "Skip verification of the server certificate for dot, doh and doq")
+	flag.StringVar(&tlsServerName, "tls-server-name", "", "Server name used to verify the server certificate for dot, doh and doq (defaults to host)")
+	flag.StringVar(&dohPath, "doh-path", query.DefaultDoHPath, "URL path of DoH queries")
 	flag.StringVar(&domain, "domain", "", "Domain for uncached queries")
 	flag.StringVar(&qTypeStr, "query-type", "A", "Query type to be used for the query")
 	flag.StringVar(&inputFile, "input-file", "", "The file that contains queries to be made in qname qtype format")
+	flag.StringVar(&scenarioFile, "scenario-file", "", "YAML file describing a mix of queries to be made, with their percentages, think times and bursts, instead of domain or input file")
 	flag.StringVar(&host, "host", "127.0.0.1", "IP address of DNS server to test")
+	flag.StringVar(&targetsStr, "targets", "", "Comma separated list of DNS servers to spread queries across instead of host, as host[:port][=weight], weights default to 1 and ports to port")
+	flag.StringVar(&targetsFile, "targets-file", "", "File listing DNS servers to spread queries across instead of host, one per line in the targets format")
 	flag.BoolVar(&logging, "enable-logging", true, "Whether to enable logging or not")
 	flag.BoolVar(&randomiseQueries, "randomise-queries", false, "Whether to randomise dns queries to bypass potential caching")
 	flag.IntVar(&monitorPort, "monitor-port", 8953, "DNS queries not sent if this port is down on the monitored host (defaults to unbound remote-control port)")
 	flag.StringVar(&monitorHost, "monitor-host", "127.0.0.1", "DNS queries not sent if the monitored port on this host is down")
 	flag.StringVar(&exporterAddr, "exporter-addr", ":6869", "Exporter bind address")
+	flag.StringVar(&latencyBuckets, "latency-buckets", report.DefaultLatencyBuckets, "Comma separated upper bounds of the buckets of the latency histogram exported in daemon mode")
+	flag.StringVar(&jitterBuckets, "jitter-buckets", report.DefaultJitterBuckets, "Comma separated upper bounds of the buckets of the inter-send jitter histogram exported in daemon mode")
+	flag.Float64Var(&nativeHistFactor, "native-histogram-factor", 0, "Also export histograms as Prometheus native histograms with buckets growing by this factor, e.g. 1.1, disabled if 0")
+	flag.StringVar(&controlAddr, "control-addr", "", "Bind address of the HTTP API controlling the load in daemon mode, disabled if empty")
 	flag.DurationVar(&duration, "max-duration", 0*time.Second, "Maximum duration of test (seconds)")
 	flag.DurationVar(&timeout, "timeout", 3*time.Second, "Duration of timeout for queries")
 	flag.DurationVar(&samplingInterval, "sample", 0*time.Second, "Sampling frequency for reporting (seconds)")
 	flag.BoolVar(&debugger, "pprof", false, "Enable pprof")
 	flag.IntVar(&maxqps, "max-qps", 0, "max number of QPS")
+	flag.StringVar(&loadProfile, "load-profile", query.ProfileConstant, "How the target QPS changes over the test. Can be: constant, linear, step, sine, backoff. All but constant require max-qps")
+	flag.IntVar(&startQPS, "start-qps", 0, "QPS the linear, step and backoff (defaults to max-qps) profiles start at, and the trough of the sine profile")
+	flag.IntVar(&stepQPS, "step-qps", 0, "QPS added at every step of the step profile, and after every healthy window of the backoff profile")
+	flag.DurationVar(&stepDuration, "step-duration", 0, "Duration of every step of the step profile")
+	flag.DurationVar(&sinePeriod, "sine-period", 0, "Period of the sine profile")
+	flag.Float64Var(&backoffThreshold, "backoff-threshold", query.DefaultBackoffThreshold, "Ratio of queries answered SERVFAIL or not answered above which the backoff profile cuts the QPS")
+	flag.Float64Var(&backoffFactor, "backoff-factor", query.DefaultBackoffFactor, "Factor the backoff profile multiplies the QPS by when cutting it")
+	flag.DurationVar(&backoffWindow, "backoff-window", query.DefaultBackoffWindow, "How often the backoff profile evaluates the ratio of failed queries")
 	flag.IntVar(&parallelConnections, "parallel-connections", 1, "max number of parallel connections")
+	flag.UintVar(&ednsBufSize, "edns-bufsize", 0, fmt.Sprintf("EDNS UDP buffer size advertised in queries (defaults to %d when any EDNS option is set)", query.DefaultEDNSBufSize))
+	flag.BoolVar(&ednsDO, "edns-do", false, "Set the DNSSEC OK bit in queries")
+	flag.StringVar(&ecsSubnets, "ecs", "", "Comma separated list of subnets sent as EDNS Client Subnet, one is picked at random for every query")
+	flag.BoolVar(&ecsRandom, "ecs-random", false, "Randomize the ECS address within the picked subnet")
+	flag.IntVar(&ecsIPv4PrefixLen, "ecs-v4-prefix", query.DefaultECSIPv4PrefixLen, "Source prefix length of randomized IPv4 ECS subnets")
+	flag.IntVar(&ecsIPv6PrefixLen, "ecs-v6-prefix", query.DefaultECSIPv6PrefixLen, "Source prefix length of randomized IPv6 ECS subnets")
+	flag.BoolVar(&ednsCookie, "edns-cookie", false, "Send DNS cookies in queries")
+	flag.StringVar(&sourcePrefix, "source-prefix", "", "Prefix bound to the host whose addresses queries are sent from, one picked at random for every connection, and every udp query with random-source-port")
+	flag.BoolVar(&randomSourcePort, "random-source-port", false, "Send every udp query from a new source port rather than one per connection")
+	flag.BoolVar(&retryTCP, "retry-tcp", false, "Retry udp queries over tcp when their response is truncated, as resolvers do")
 	flag.BoolVar(&reportJSON, "report-json", false, "Report run results to stdout in json format")
 	flag.Parse()
+	if exit, err := configFlags.Run(flag.CommandLine, os.Stdout); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	} else if exit {
+		os.Exit(0)
+	}
 
 	switch logLevel {
 	case "debug":
@@ -97,18 +165,85 @@ func main() {
 		log.Fatalf("Unrecognized log level: %v", logLevel)
 	}
 
-	if domain == "" && inputFile == "" {
-		log.Fatal("Need to specify either domain or input file, neither is specified")
+	if err := query.ValidateProtocol(protocol); err != nil {
+		log.Fatalf("%v", err)
+	}
+	portSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "port" {
+			portSet = true
+		}
+	})
+	if !portSet {
+		dport = query.DefaultPort(protocol)
+	}
+
+	if ednsBufSize > dns.MaxMsgSize {
+		log.Fatalf("Invalid EDNS buffer size: %d", ednsBufSize)
+	}
+	if ecsIPv4PrefixLen < 0 || ecsIPv4PrefixLen > 32 || ecsIPv6PrefixLen < 0 || ecsIPv6PrefixLen > 128 {
+		log.Fatalf("Invalid ECS prefix lengths: %d, %d", ecsIPv4PrefixLen, ecsIPv6PrefixLen)
+	}
+	ecsPrefixes, ecsErr := query.ParseECSPrefixes(ecsSubnets)
+	if ecsErr != nil {
+		log.Fatalf("%v", ecsErr)
+	}
+	var sourceNet *net.IPNet
+	if sourcePrefix != "" {
+		var sourceErr error
+		if _, sourceNet, sourceErr = net.ParseCIDR(sourcePrefix); sourceErr != nil {
+			log.Fatalf("Invalid source prefix: %v", sourceErr)
+		}
+	}
+	if targetsStr != "" && targetsFile != "" {
+		log.Fatal("Need to specify either targets or targets file, both are specified, please only specify one of them")
+	}
+	var targets []query.Target
+	if targetsFile != "" {
+		var targetsErr error
+		if targets, targetsErr = query.ReadTargetsFile(targetsFile, dport); targetsErr != nil {
+			log.Fatalf("Failed to process targets file: %s %v", targetsFile, targetsErr)
+		}
+	} else {
+		var targetsErr error
+		if targets, targetsErr = query.ParseTargets(targetsStr, dport); targetsErr != nil {
+			log.Fatalf("%v", targetsErr)
+		}
+	}
+	ednsConfig := query.EDNSConfig{
+		BufSize:          uint16(ednsBufSize),
+		DO:               ednsDO,
+		ECS:              ecsPrefixes,
+		ECSRandom:        ecsRandom,
+		ECSIPv4PrefixLen: ecsIPv4PrefixLen,
+		ECSIPv6PrefixLen: ecsIPv6PrefixLen,
+		Cookie:           ednsCookie,
+	}
 
+	sources := 0
+	for _, source := range []string{domain, inputFile, scenarioFile} {
+		if source != "" {
+			sources++
+		}
 	}
-	if domain != "" && inputFile != "" {
-		log.Fatal("Need to specify either domain or input file, both are specified, please only specify one of them")
+	if sources == 0 {
+		log.Fatal("Need to specify either domain, input file or scenario file, neither is specified")
+
+	}
+	if sources > 1 {
+		log.Fatal("Need to specify either domain, input file or scenario file, several are specified, please only specify one of them")
 
 	}
 	qnames := make([]string, 0)
 	qtypes := make([]dns.Type, 0)
+	var scenario *query.Scenario
 	var err error
-	if inputFile != "" {
+	if scenarioFile != "" {
+		scenario, err = query.ReadScenarioFile(scenarioFile)
+		if err != nil {
+			log.Fatalf("Failed to process scenario file: %s %v", scenarioFile, err)
+		}
+	} else if inputFile != "" {
 		qnames, qtypes, err = query.ProcessQueryInputFile(inputFile)
 		if err != nil {
 			log.Fatalf("Failed to process query input file: %s %v", inputFile, err)
@@ -148,7 +283,15 @@ func main() {
 		log.Infof("Monitor Host/Port is %s:%d", monitorHost, monitorPort)
 		query.MonitorTarget(sigPause, monitorPort, monitorHost)
 		// @fb-only: reporter = &report.ODSMetricsReporter{Prefix: "dns.goose", Addr: exporterAddr}
-		reporter = &report.PrometheusMetricsReporter{Addr: exporterAddr} // @oss-only
+		latencyHistBuckets, bucketsErr := report.ParseDurationBuckets(latencyBuckets)
+		if bucketsErr != nil {
+			log.Fatalf("invalid -latency-buckets: %v", bucketsErr)
+		}
+		jitterHistBuckets, bucketsErr := report.ParseDurationBuckets(jitterBuckets)
+		if bucketsErr != nil {
+			log.Fatalf("invalid -jitter-buckets: %v", bucketsErr)
+		}
+		reporter = &report.PrometheusMetricsReporter{Addr: exporterAddr, LatencyBuckets: latencyHistBuckets, JitterBuckets: jitterHistBuckets, NativeHistogramFactor: nativeHistFactor} // @oss-only
 
 		// Do nothing on SIGHUP (terminal disconnect)
 		signal.Notify(make(chan os.Signal, 1), syscall.SIGHUP)
@@ -193,15 +336,80 @@ func main() {
 	}()
 	var rate ratelimit.Limiter
 	qpsStr := "Unlimited"
-	if maxqps > 0 {
+	switch {
+	case loadProfile == query.ProfileBackoff:
+		limiter, backoffErr := query.NewBackoffLimiter(query.BackoffConfig{
+			StartQPS:  startQPS,
+			MaxQPS:    maxqps,
+			StepQPS:   stepQPS,
+			Threshold: backoffThreshold,
+			Factor:    backoffFactor,
+			Window:    backoffWindow,
+		}, time.Now)
+		if backoffErr != nil {
+			log.Fatalf("%v", backoffErr)
+		}
+		log.Infof("Backing off from up to %d qps above %v%% of failed queries", maxqps, backoffThreshold*100)
+		rate = limiter
+		qpsStr = fmt.Sprintf("%s %d", loadProfile, maxqps)
+	case loadProfile != query.ProfileConstant:
+		// linear ramps last for the whole test
+		profile, profileErr := query.NewLoadProfile(query.ProfileConfig{
+			Name:         loadProfile,
+			MaxQPS:       maxqps,
+			StartQPS:     startQPS,
+			Duration:     duration,
+			StepQPS:      stepQPS,
+			StepDuration: stepDuration,
+			Period:       sinePeriod,
+		})
+		if profileErr != nil {
+			log.Fatalf("%v", profileErr)
+		}
+		log.Infof("Following the %s load profile up to %d qps", loadProfile, maxqps)
+		rate = query.NewProfileLimiter(profile, time.Now)
+		qpsStr = fmt.Sprintf("%s %d-%d", loadProfile, startQPS, maxqps)
+	case maxqps > 0:
 		log.Infof("Limiting max qps to: %d", maxqps)
-		rate = ratelimit.New(maxqps)
+		// a constant profile exposes the target QPS, needed for the jitter
+		profile, profileErr := query.NewLoadProfile(query.ProfileConfig{Name: query.ProfileConstant, MaxQPS: maxqps})
+		if profileErr != nil {
+			log.Fatalf("%v", profileErr)
+		}
+		rate = query.NewProfileLimiter(profile, time.Now)
 		qpsStr = fmt.Sprint(maxqps)
-	} else {
+	default:
+		// closed loop, every connection sends a query as soon as the previous one is answered
 		rate = ratelimit.NewUnlimited()
 	}
 	log.Infof(goosestr, host, dport, qpsStr)
+	for _, target := range targets {
+		log.Infof("Sending %d share(s) of queries to %s", target.Weight, target)
+	}
+	log.Infof("Sending queries over %s", protocol)
+	transportConfig := query.TransportConfig{
+		Protocol: protocol,
+		Host:     host,
+		Port:     dport,
+		Timeout:  timeout,
+		TLSConfig: &tls.Config{
+			ServerName:         tlsServerName,
+			InsecureSkipVerify: tlsInsecure, // #nosec G402 -- opt-in for test targets with self-signed certificates
+		},
+		DoHPath:          dohPath,
+		SourcePrefix:     sourceNet,
+		RandomSourcePort: randomSourcePort,
+		RetryTCP:         retryTCP,
+	}
 	runState := query.NewRunState(totalQueries, rate, daemon, time.Now)
+	if daemon && controlAddr != "" {
+		controlServer := &control.Server{Addr: controlAddr, RunState: runState}
+		go func() {
+			if controlErr := controlServer.ListenAndServe(); controlErr != nil {
+				log.Errorf("Failed to start control API %v", controlErr)
+			}
+		}()
+	}
 	if duration != 0 && !daemon {
 		timer := time.NewTimer(duration)
 		go func() {
@@ -240,8 +448,13 @@ func main() {
 		for i := 0; i < parallelConnections; i++ {
 			wg.Add(1)
 			go func() {
-				qErr := query.RunQueries(dport, host, qnames, timeout, randomiseQueries, qtypes, time.Now, runState, sigPause)
-				if err != nil {
+				var qErr error
+				if scenario != nil {
+					qErr = query.RunScenario(transportConfig, targets, ednsConfig, scenario, time.Now, runState, sigPause)
+				} else {
+					qErr = query.RunQueries(transportConfig, targets, ednsConfig, qnames, randomiseQueries, qtypes, time.Now, runState, sigPause)
+				}
+				if qErr != nil {
 					log.Errorf("Failed to run queries %v", qErr)
 				}
 				wg.Done()
@@ -0,0 +1,180 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/facebook/dns/goose/stats"
+
+	"github.com/miekg/dns"
+	"go.uber.org/ratelimit"
+)
+
+// ProfileBackoff is the load profile adapting the QPS to the health of the
+// target, see BackoffLimiter
+const ProfileBackoff = "backoff"
+
+// Defaults of the backoff profile
+const (
+	DefaultBackoffThreshold = 0.01
+	DefaultBackoffFactor    = 0.5
+	DefaultBackoffWindow    = time.Second
+)
+
+// BackoffConfig describes the backoff profile
+type BackoffConfig struct {
+	// StartQPS is the QPS the profile starts at, MaxQPS if 0
+	StartQPS int
+	// MaxQPS is the QPS the profile never goes above
+	MaxQPS int
+	// StepQPS is the QPS added after every healthy window
+	StepQPS int
+	// Threshold is the ratio of failed queries, SERVFAIL or no response,
+	// above which a window is unhealthy
+	Threshold float64
+	// Factor multiplies the QPS after every unhealthy window
+	Factor float64
+	// Window is how often the ratio of failed queries is evaluated
+	Window time.Duration
+}
+
+// BackoffLimiter is a ratelimit.Limiter adapting the QPS to the health of
+// the target, to probe its capacity safely: the QPS is multiplied by a factor
+// after every window with too many queries answered SERVFAIL or not
+// answered, and goes back up by steps after every healthy window (additive
+// increase, multiplicative decrease). It measures the goodput, the rate of
+// queries answered without SERVFAIL sustained over the healthy windows since
+// the last backoff.
+type BackoffLimiter struct {
+	config BackoffConfig
+	now    func() time.Time
+
+	// m protects all fields below
+	m           sync.Mutex
+	windowStart time.Time
+	good        int
+	failed      int
+	qps         int
+	limiter     ratelimit.Limiter
+	// good queries and time of the healthy windows since the last backoff
+	healthyGood int
+	healthyTime time.Duration
+	goodput     int
+}
+
+// NewBackoffLimiter validates c and creates a BackoffLimiter, the first
+// window starts on creation
+func NewBackoffLimiter(c BackoffConfig, now func() time.Time) (*BackoffLimiter, error) {
+	if c.MaxQPS <= 0 {
+		return nil, fmt.Errorf("load profile %q requires a positive max qps", ProfileBackoff)
+	}
+	if c.StartQPS == 0 {
+		c.StartQPS = c.MaxQPS
+	}
+	if c.StartQPS < 0 || c.StartQPS > c.MaxQPS {
+		return nil, fmt.Errorf("start qps %d must be between 0 and max qps %d", c.StartQPS, c.MaxQPS)
+	}
+	if c.StepQPS <= 0 {
+		return nil, fmt.Errorf("load profile %q requires a positive step qps", ProfileBackoff)
+	}
+	if c.Threshold <= 0 || c.Threshold >= 1 {
+		return nil, fmt.Errorf("backoff threshold %v must be between 0 and 1", c.Threshold)
+	}
+	if c.Factor <= 0 || c.Factor >= 1 {
+		return nil, fmt.Errorf("backoff factor %v must be between 0 and 1", c.Factor)
+	}
+	if c.Window <= 0 {
+		return nil, fmt.Errorf("load profile %q requires a positive window", ProfileBackoff)
+	}
+	l := &BackoffLimiter{config: c, now: now, windowStart: now()}
+	l.setQPS(c.StartQPS)
+	return l, nil
+}
+
+// setQPS re-creates the underlying limiter if the target QPS changed
+func (l *BackoffLimiter) setQPS(qps int) {
+	qps = min(max(qps, 1), l.config.MaxQPS)
+	if qps == l.qps {
+		return
+	}
+	l.qps = qps
+	l.limiter = ratelimit.New(qps)
+}
+
+// evaluate adapts the QPS to the window ending at t
+func (l *BackoffLimiter) evaluate(t time.Time) {
+	elapsed := t.Sub(l.windowStart)
+	total := l.good + l.failed
+	switch {
+	case total == 0:
+		// nothing sent, e.g. while paused
+	case float64(l.failed)/float64(total) > l.config.Threshold:
+		l.healthyGood, l.healthyTime = 0, 0
+		l.setQPS(int(float64(l.qps) * l.config.Factor))
+	default:
+		l.healthyGood += l.good
+		l.healthyTime += elapsed
+		l.goodput = int(float64(l.healthyGood) / l.healthyTime.Seconds())
+		l.setQPS(l.qps + l.config.StepQPS)
+	}
+	l.windowStart = t
+	l.good, l.failed = 0, 0
+}
+
+// Take blocks to ensure the time spent between calls follows the target QPS
+func (l *BackoffLimiter) Take() time.Time {
+	l.m.Lock()
+	if t := l.now(); t.Sub(l.windowStart) >= l.config.Window {
+		l.evaluate(t)
+	}
+	limiter := l.limiter
+	l.m.Unlock()
+	return limiter.Take()
+}
+
+// Observe counts the outcome of a query in the current window
+func (l *BackoffLimiter) Observe(rcode string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if isBackoffFailure(rcode) {
+		l.failed++
+	} else {
+		l.good++
+	}
+}
+
+// isBackoffFailure returns true if the outcome of a query, see
+// responseRcode, means the target is overloaded
+func isBackoffFailure(rcode string) bool {
+	return rcode == dns.RcodeToString[dns.RcodeServerFailure] || rcode == stats.RcodeTimeout || rcode == stats.RcodeError
+}
+
+// TargetQPS returns the QPS currently targeted
+func (l *BackoffLimiter) TargetQPS() int {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.qps
+}
+
+// GoodputQPS returns the goodput sustained since the last backoff, that of
+// the last healthy windows before it if none was healthy since, 0 if none
+// ever was
+func (l *BackoffLimiter) GoodputQPS() int {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.goodput
+}
@@ -0,0 +1,101 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewBackoffLimiterErrors(t *testing.T) {
+	valid := BackoffConfig{MaxQPS: 1000, StepQPS: 100, Threshold: DefaultBackoffThreshold, Factor: DefaultBackoffFactor, Window: DefaultBackoffWindow}
+	for _, update := range []func(c *BackoffConfig){
+		func(c *BackoffConfig) { c.MaxQPS = 0 },
+		func(c *BackoffConfig) { c.StartQPS = 2000 },
+		func(c *BackoffConfig) { c.StepQPS = 0 },
+		func(c *BackoffConfig) { c.Threshold = 0 },
+		func(c *BackoffConfig) { c.Threshold = 1 },
+		func(c *BackoffConfig) { c.Factor = 1 },
+		func(c *BackoffConfig) { c.Window = 0 },
+	} {
+		c := valid
+		update(&c)
+		_, err := NewBackoffLimiter(c, time.Now)
+		require.Error(t, err, "%+v", c)
+	}
+	l, err := NewBackoffLimiter(valid, time.Now)
+	require.NoError(t, err)
+	require.Equal(t, 1000, l.TargetQPS())
+}
+
+func Test_BackoffLimiter(t *testing.T) {
+	now := time.Date(2020, time.November, 10, 23, 0, 0, 0, time.UTC)
+	nowfunc := func() time.Time { return now }
+	l, err := NewBackoffLimiter(BackoffConfig{StartQPS: 8000, MaxQPS: 10000, StepQPS: 1000, Threshold: 0.1, Factor: 0.5, Window: time.Second}, nowfunc)
+	require.NoError(t, err)
+	runState := NewRunState(1, l, false, nowfunc)
+	// window adds good NOERROR queries and bad ones answered with rcode,
+	// then the next window starts
+	window := func(good, bad int, rcode string) {
+		for i := 0; i < good; i++ {
+			runState.addOutcome("A", "NOERROR", true)
+		}
+		for i := 0; i < bad; i++ {
+			runState.addOutcome("A", rcode, false)
+		}
+		now = now.Add(time.Second)
+		l.Take()
+	}
+
+	// healthy, NXDOMAIN is not a failure, up to MaxQPS
+	window(95, 5, "NXDOMAIN")
+	require.Equal(t, 9000, l.TargetQPS())
+	require.Equal(t, 100, l.GoodputQPS())
+	// 10% failures is still healthy
+	window(90, 10, "SERVFAIL")
+	require.Equal(t, 10000, l.TargetQPS())
+	require.Equal(t, 95, l.GoodputQPS())
+	window(100, 0, "")
+	require.Equal(t, 10000, l.TargetQPS())
+
+	// unhealthy windows back off, the goodput of the healthy ones is kept
+	window(80, 20, "TIMEOUT")
+	require.Equal(t, 5000, l.TargetQPS())
+	window(0, 1, "ERROR")
+	require.Equal(t, 2500, l.TargetQPS())
+	require.Equal(t, 96, l.GoodputQPS())
+
+	// nothing sent changes nothing
+	window(0, 0, "")
+	require.Equal(t, 2500, l.TargetQPS())
+
+	// healthy again, ramps up and measures the goodput since
+	window(300, 0, "")
+	window(100, 0, "")
+	require.Equal(t, 4500, l.TargetQPS())
+	require.Equal(t, 200, l.GoodputQPS())
+
+	// never below 1 QPS, evaluated without taking, which waits at low QPS
+	for i := 0; i < 20; i++ {
+		l.Observe("SERVFAIL")
+		now = now.Add(time.Second)
+		l.evaluate(now)
+	}
+	require.Equal(t, 1, l.TargetQPS())
+
+	require.Equal(t, 200, runState.ExportIntermediateResults().GoodputQPS)
+	require.Equal(t, 200, runState.ExportResults().GoodputQPS)
+}
@@ -0,0 +1,127 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"fmt"
+	"time"
+
+	"go.uber.org/ratelimit"
+)
+
+// Status of a run
+const (
+	// StatusRunning means queries are being sent
+	StatusRunning = "running"
+	// StatusPaused means no queries are sent, results are kept
+	StatusPaused = "paused"
+	// StatusStopped means no queries are sent, results are reset on start
+	StatusStopped = "stopped"
+)
+
+// Status returns whether queries are being sent
+func (r *RunState) Status() string {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.status
+}
+
+// setStatus changes the status and wakes up the waiting connections
+func (r *RunState) setStatus(status string) {
+	r.status = status
+	r.statusChanged.Broadcast()
+}
+
+// Start resumes sending queries. A stopped run starts over with fresh results.
+func (r *RunState) Start() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.status == StatusStopped {
+		r.reset()
+	}
+	r.setStatus(StatusRunning)
+}
+
+// Pause stops sending queries until Start is called, results are kept
+func (r *RunState) Pause() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.status == StatusRunning {
+		r.setStatus(StatusPaused)
+	}
+}
+
+// Stop stops sending queries until Start is called, which resets the results
+func (r *RunState) Stop() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.setStatus(StatusStopped)
+}
+
+// SetMaxQPS replaces the limiter, including any load profile, by a constant
+// rate of qps. 0 means unlimited.
+func (r *RunState) SetMaxQPS(qps int) error {
+	if qps < 0 {
+		return fmt.Errorf("invalid max qps %d", qps)
+	}
+	var limiter ratelimit.Limiter = ratelimit.NewUnlimited()
+	if qps > 0 {
+		limiter = NewProfileLimiter(constantProfile(qps), r.nowfunc)
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.limiter = limiter
+	return nil
+}
+
+// TargetQPS returns the QPS currently targeted, 0 if unknown or unlimited
+func (r *RunState) TargetQPS() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.targetQPS()
+}
+
+// getLimiter returns the current limiter
+func (r *RunState) getLimiter() ratelimit.Limiter {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.limiter
+}
+
+// waitRunning blocks while the run is paused or stopped
+func (r *RunState) waitRunning() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	for r.status == StatusPaused || r.status == StatusStopped {
+		r.statusChanged.Wait()
+	}
+}
+
+// reset clears the results, r.m must be held
+func (r *RunState) reset() {
+	now := r.nowfunc()
+	r.startTime = now
+	r.processed, r.errors, r.connErrors = 0, 0, 0
+	r.qtypes, r.rcodes = nil, nil
+	r.unexportedQTypes, r.unexportedRcodes = nil, nil
+	r.responses, r.unexportedResponses, r.tcpRetries = nil, nil, 0
+	r.targets, r.unexportedTargets = nil, nil
+	r.unexportedLatencies = make([]float64, 0)
+	r.alreadyExportedLatencies = make([]float64, 0)
+	r.unexportedJitters, r.alreadyExportedJitters = nil, nil
+	r.lastSentAt = time.Time{}
+	r.lastExportedAt = time.Time{}
+	r.lastExportedProcessed, r.lastExportedErrors, r.lastExportedConnErrs = 0, 0, 0
+	r.lastExportedRetries = 0
+}
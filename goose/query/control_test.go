@@ -0,0 +1,77 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/ratelimit"
+)
+
+func Test_RunStateControl(t *testing.T) {
+	tf := timefunc()
+	runState := NewRunState(1, ratelimit.NewUnlimited(), true, tf)
+	reqMsg := new(dns.Msg)
+	reqMsg.SetQuestion("example.com.", dns.TypeA)
+	RunQuery(reqMsg, doSendMsgSuccess, tf, runState)
+
+	// pausing keeps the results
+	runState.Pause()
+	require.Equal(t, StatusPaused, runState.Status())
+	runState.Start()
+	require.Equal(t, StatusRunning, runState.Status())
+	require.Equal(t, 1, runState.ExportResults().Processed)
+
+	// stopping resets them on start
+	runState.Stop()
+	runState.Pause()
+	require.Equal(t, StatusStopped, runState.Status())
+	require.Equal(t, 1, runState.ExportResults().Processed)
+	runState.Start()
+	results := runState.ExportResults()
+	require.Equal(t, 0, results.Processed)
+	require.Empty(t, results.Latencies)
+	require.Nil(t, results.QTypes)
+
+	require.Error(t, runState.SetMaxQPS(-1))
+	require.NoError(t, runState.SetMaxQPS(100))
+	require.Equal(t, 100, runState.TargetQPS())
+	require.NoError(t, runState.SetMaxQPS(0))
+	require.Equal(t, 0, runState.TargetQPS())
+}
+
+func Test_RunQueriesPaused(t *testing.T) {
+	host, port := hostPort(t, startDNSServer(t, "udp"))
+	runState := NewRunState(5, ratelimit.NewUnlimited(), false, time.Now)
+	runState.Pause()
+
+	done := make(chan error)
+	go func() {
+		conf := TransportConfig{Protocol: ProtocolUDP, Host: host, Port: port, Timeout: time.Second}
+		done <- RunQueries(conf, nil, EDNSConfig{}, []string{"example.com"}, false, []dns.Type{dns.Type(dns.TypeA)}, time.Now, runState, make(chan struct{}))
+	}()
+	select {
+	case <-done:
+		require.Fail(t, "queries sent while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.Equal(t, 0, runState.ExportResults().Processed)
+
+	runState.Start()
+	require.NoError(t, <-done)
+	require.Equal(t, 5, runState.ExportResults().Processed)
+}
@@ -0,0 +1,153 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultEDNSBufSize is the EDNS buffer size advertised when none is set,
+// as recommended by DNS flag day 2020
+const DefaultEDNSBufSize = 1232
+
+// Default source prefix lengths of randomized ECS subnets
+const (
+	DefaultECSIPv4PrefixLen = 24
+	DefaultECSIPv6PrefixLen = 56
+)
+
+// clientCookieLen is the length of a client cookie in bytes (RFC 7873)
+const clientCookieLen = 8
+
+// EDNSConfig describes the EDNS0 options attached to queries
+type EDNSConfig struct {
+	// BufSize is the advertised UDP buffer size, DefaultEDNSBufSize if 0
+	BufSize uint16
+	// DO sets the DNSSEC OK bit
+	DO bool
+	// ECS lists the subnets sent as EDNS Client Subnet, one is picked at random for every query
+	ECS []*net.IPNet
+	// ECSRandom randomizes the address within the picked ECS subnet, up to the prefix lengths below
+	ECSRandom bool
+	// ECSIPv4PrefixLen is the source prefix length of randomized IPv4 subnets, DefaultECSIPv4PrefixLen if 0
+	ECSIPv4PrefixLen int
+	// ECSIPv6PrefixLen is the source prefix length of randomized IPv6 subnets, DefaultECSIPv6PrefixLen if 0
+	ECSIPv6PrefixLen int
+	// Cookie sends a DNS cookie, and echoes the server cookie once learnt
+	Cookie bool
+}
+
+// Enabled returns true if queries need an OPT record
+func (c *EDNSConfig) Enabled() bool {
+	return c.BufSize != 0 || c.DO || len(c.ECS) > 0 || c.Cookie
+}
+
+// ParseECSPrefixes parses a comma separated list of subnets
+func ParseECSPrefixes(s string) ([]*net.IPNet, error) {
+	var prefixes []*net.IPNet
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ECS subnet %q: %w", p, err)
+		}
+		prefixes = append(prefixes, ipnet)
+	}
+	return prefixes, nil
+}
+
+// ecsOption returns an ECS option for one of the configured subnets
+func (c *EDNSConfig) ecsOption() *dns.EDNS0_SUBNET {
+	prefix := c.ECS[rand.Intn(len(c.ECS))]
+	ones, bits := prefix.Mask.Size()
+	ip := make(net.IP, len(prefix.IP))
+	copy(ip, prefix.IP)
+	if c.ECSRandom {
+		target := c.ECSIPv4PrefixLen
+		if target == 0 {
+			target = DefaultECSIPv4PrefixLen
+		}
+		if bits == 8*net.IPv6len {
+			target = c.ECSIPv6PrefixLen
+			if target == 0 {
+				target = DefaultECSIPv6PrefixLen
+			}
+		}
+		if target > ones {
+			// fill the host bits at random, then keep the first target bits
+			for i := range ip {
+				ip[i] |= byte(rand.Intn(256)) &^ prefix.Mask[i]
+			}
+			ip = ip.Mask(net.CIDRMask(target, bits))
+			ones = target
+		}
+	}
+	family := uint16(1)
+	if bits == 8*net.IPv6len {
+		family = 2
+	}
+	return &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: uint8(ones),
+		Address:       ip,
+	}
+}
+
+// WithEDNS attaches the EDNS0 options of c to requests before sending them.
+// Every call creates its own client cookie, so it should be used once per connection.
+func WithEDNS(send SendMsg, c EDNSConfig) SendMsg {
+	bufSize := c.BufSize
+	if bufSize == 0 {
+		bufSize = DefaultEDNSBufSize
+	}
+	var clientCookie, serverCookie string
+	if c.Cookie {
+		b := make([]byte, clientCookieLen)
+		binary.BigEndian.PutUint64(b, rand.Uint64())
+		clientCookie = hex.EncodeToString(b)
+	}
+	return func(request *dns.Msg) (*dns.Msg, error) {
+		request.SetEdns0(bufSize, c.DO)
+		opt := request.IsEdns0()
+		if len(c.ECS) > 0 {
+			opt.Option = append(opt.Option, c.ecsOption())
+		}
+		if c.Cookie {
+			opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: clientCookie + serverCookie})
+		}
+		resp, err := send(request)
+		if c.Cookie && resp != nil {
+			if ropt := resp.IsEdns0(); ropt != nil {
+				for _, o := range ropt.Option {
+					// the server cookie follows the echoed client cookie
+					if cookie, ok := o.(*dns.EDNS0_COOKIE); ok && len(cookie.Cookie) > 2*clientCookieLen {
+						serverCookie = cookie.Cookie[2*clientCookieLen:]
+					}
+				}
+			}
+		}
+		return resp, err
+	}
+}
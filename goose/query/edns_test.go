@@ -0,0 +1,113 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseECSPrefixes(t *testing.T) {
+	prefixes, err := ParseECSPrefixes("10.0.0.0/8, 2001:db8::/32,")
+	require.NoError(t, err)
+	require.Len(t, prefixes, 2)
+	require.Equal(t, "10.0.0.0/8", prefixes[0].String())
+	require.Equal(t, "2001:db8::/32", prefixes[1].String())
+
+	prefixes, err = ParseECSPrefixes("")
+	require.NoError(t, err)
+	require.Empty(t, prefixes)
+
+	_, err = ParseECSPrefixes("10.0.0.0")
+	require.Error(t, err)
+}
+
+func Test_ECSOption(t *testing.T) {
+	prefixes, err := ParseECSPrefixes("192.0.2.0/24")
+	require.NoError(t, err)
+	c := EDNSConfig{ECS: prefixes}
+	o := c.ecsOption()
+	require.Equal(t, uint16(1), o.Family)
+	require.Equal(t, uint8(24), o.SourceNetmask)
+	require.Equal(t, "192.0.2.0", o.Address.String())
+
+	prefixes, err = ParseECSPrefixes("10.0.0.0/8,2001:db8::/32")
+	require.NoError(t, err)
+	c = EDNSConfig{ECS: prefixes, ECSRandom: true, ECSIPv6PrefixLen: 48}
+	for i := 0; i < 100; i++ {
+		o = c.ecsOption()
+		if o.Family == 1 {
+			require.Equal(t, uint8(DefaultECSIPv4PrefixLen), o.SourceNetmask)
+			require.True(t, prefixes[0].Contains(o.Address))
+			require.Equal(t, byte(0), o.Address.To4()[3])
+		} else {
+			require.Equal(t, uint16(2), o.Family)
+			require.Equal(t, uint8(48), o.SourceNetmask)
+			require.True(t, prefixes[1].Contains(o.Address))
+			require.Equal(t, o.Address, o.Address.Mask(net.CIDRMask(48, 128)))
+		}
+	}
+}
+
+func Test_WithEDNS(t *testing.T) {
+	require.False(t, (&EDNSConfig{}).Enabled())
+
+	prefixes, err := ParseECSPrefixes("192.0.2.0/24")
+	require.NoError(t, err)
+	c := EDNSConfig{BufSize: 4096, DO: true, ECS: prefixes, Cookie: true}
+	require.True(t, c.Enabled())
+
+	const serverCookie = "0102030405060708"
+	var sent []*dns.OPT
+	send := WithEDNS(func(req *dns.Msg) (*dns.Msg, error) {
+		opt := req.IsEdns0()
+		sent = append(sent, opt)
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.SetEdns0(DefaultEDNSBufSize, false)
+		for _, o := range opt.Option {
+			if cookie, ok := o.(*dns.EDNS0_COOKIE); ok {
+				ropt := resp.IsEdns0()
+				ropt.Option = append(ropt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie.Cookie[:16] + serverCookie})
+			}
+		}
+		return resp, nil
+	}, c)
+
+	for i := 0; i < 2; i++ {
+		_, err = send(MakeReq("example.com", time.Now, false, dns.Type(dns.TypeA)))
+		require.NoError(t, err)
+	}
+	require.Len(t, sent, 2)
+	var cookies []string
+	for _, opt := range sent {
+		require.NotNil(t, opt)
+		require.Equal(t, uint16(4096), opt.UDPSize())
+		require.True(t, opt.Do())
+		require.Len(t, opt.Option, 2)
+		ecs, ok := opt.Option[0].(*dns.EDNS0_SUBNET)
+		require.True(t, ok)
+		require.Equal(t, "192.0.2.0", ecs.Address.String())
+		cookie, ok := opt.Option[1].(*dns.EDNS0_COOKIE)
+		require.True(t, ok)
+		cookies = append(cookies, cookie.Cookie)
+	}
+	// the client cookie is kept, and the server cookie echoed once learnt
+	require.Len(t, cookies[0], 16)
+	require.Equal(t, cookies[0]+serverCookie, cookies[1])
+}
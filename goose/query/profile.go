@@ -0,0 +1,193 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/ratelimit"
+)
+
+// Supported load profiles
+const (
+	ProfileConstant = "constant"
+	ProfileLinear   = "linear"
+	ProfileStep     = "step"
+	ProfileSine     = "sine"
+)
+
+// profileUpdateInterval is how often the target QPS of a profile is re-evaluated
+const profileUpdateInterval = time.Second
+
+// LoadProfile returns the target QPS after elapsed time since the start of the test
+type LoadProfile interface {
+	QPS(elapsed time.Duration) int
+}
+
+// ProfileConfig describes a load profile
+type ProfileConfig struct {
+	// Name is one of ProfileConstant, ProfileLinear, ProfileStep or ProfileSine
+	Name string
+	// MaxQPS is the constant QPS, the QPS reached at the end of a ramp, or the peak of a sine wave
+	MaxQPS int
+	// StartQPS is the QPS a ramp starts at, or the trough of a sine wave
+	StartQPS int
+	// Duration is the time the linear ramp takes to reach MaxQPS
+	Duration time.Duration
+	// StepQPS is the QPS added at every step
+	StepQPS int
+	// StepDuration is the time spent at each step
+	StepDuration time.Duration
+	// Period is the period of the sine wave
+	Period time.Duration
+}
+
+// NewLoadProfile validates c and returns the matching LoadProfile
+func NewLoadProfile(c ProfileConfig) (LoadProfile, error) {
+	if c.MaxQPS <= 0 {
+		return nil, fmt.Errorf("load profile %q requires a positive max qps", c.Name)
+	}
+	if c.StartQPS < 0 || c.StartQPS > c.MaxQPS {
+		return nil, fmt.Errorf("start qps %d must be between 0 and max qps %d", c.StartQPS, c.MaxQPS)
+	}
+	switch c.Name {
+	case ProfileConstant:
+		return constantProfile(c.MaxQPS), nil
+	case ProfileLinear:
+		if c.Duration <= 0 {
+			return nil, fmt.Errorf("load profile %q requires a duration", c.Name)
+		}
+		return &linearProfile{start: c.StartQPS, end: c.MaxQPS, duration: c.Duration}, nil
+	case ProfileStep:
+		if c.StepQPS <= 0 || c.StepDuration <= 0 {
+			return nil, fmt.Errorf("load profile %q requires a positive step qps and step duration", c.Name)
+		}
+		return &stepProfile{start: c.StartQPS, max: c.MaxQPS, step: c.StepQPS, stepDuration: c.StepDuration}, nil
+	case ProfileSine:
+		if c.Period <= 0 {
+			return nil, fmt.Errorf("load profile %q requires a period", c.Name)
+		}
+		return &sineProfile{min: c.StartQPS, max: c.MaxQPS, period: c.Period}, nil
+	default:
+		return nil, fmt.Errorf("invalid load profile %q", c.Name)
+	}
+}
+
+// constantProfile always targets the same QPS
+type constantProfile int
+
+func (p constantProfile) QPS(time.Duration) int {
+	return int(p)
+}
+
+// linearProfile ramps up from start to end QPS over duration, then stays at end
+type linearProfile struct {
+	start    int
+	end      int
+	duration time.Duration
+}
+
+func (p *linearProfile) QPS(elapsed time.Duration) int {
+	if elapsed >= p.duration {
+		return p.end
+	}
+	progress := float64(elapsed) / float64(p.duration)
+	return p.start + int(progress*float64(p.end-p.start))
+}
+
+// stepProfile adds step QPS every stepDuration, starting at start, up to max
+type stepProfile struct {
+	start        int
+	max          int
+	step         int
+	stepDuration time.Duration
+}
+
+func (p *stepProfile) QPS(elapsed time.Duration) int {
+	qps := p.start + int(elapsed/p.stepDuration)*p.step
+	if qps > p.max {
+		return p.max
+	}
+	return qps
+}
+
+// sineProfile oscillates between min and max QPS, starting at min
+type sineProfile struct {
+	min    int
+	max    int
+	period time.Duration
+}
+
+func (p *sineProfile) QPS(elapsed time.Duration) int {
+	phase := 2 * math.Pi * float64(elapsed%p.period) / float64(p.period)
+	// 1 - cos goes from 0 to 2 and back over a period
+	return p.min + int(math.Round(float64(p.max-p.min)*(1-math.Cos(phase))/2))
+}
+
+// ProfileLimiter is a ratelimit.Limiter following a LoadProfile
+type ProfileLimiter struct {
+	profile LoadProfile
+	start   time.Time
+	now     func() time.Time
+
+	// m protects all fields below
+	m         sync.Mutex
+	updatedAt time.Time
+	qps       int
+	limiter   ratelimit.Limiter
+}
+
+// NewProfileLimiter creates a ProfileLimiter, the profile starts on creation
+func NewProfileLimiter(profile LoadProfile, now func() time.Time) *ProfileLimiter {
+	l := &ProfileLimiter{profile: profile, start: now(), now: now}
+	l.update(l.start)
+	return l
+}
+
+// update re-creates the underlying limiter if the target QPS changed
+func (l *ProfileLimiter) update(t time.Time) {
+	l.updatedAt = t
+	qps := l.profile.QPS(t.Sub(l.start))
+	// a profile may go down to 0 QPS, but some queries must still go through
+	if qps < 1 {
+		qps = 1
+	}
+	if qps == l.qps {
+		return
+	}
+	l.qps = qps
+	l.limiter = ratelimit.New(qps)
+}
+
+// Take blocks to ensure the time spent between calls follows the target QPS
+func (l *ProfileLimiter) Take() time.Time {
+	l.m.Lock()
+	// re-evaluating the profile at every call would reset the limiter too often
+	if t := l.now(); t.Sub(l.updatedAt) >= profileUpdateInterval {
+		l.update(t)
+	}
+	limiter := l.limiter
+	l.m.Unlock()
+	return limiter.Take()
+}
+
+// TargetQPS returns the QPS currently targeted
+func (l *ProfileLimiter) TargetQPS() int {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.qps
+}
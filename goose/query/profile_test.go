@@ -0,0 +1,122 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewLoadProfileErrors(t *testing.T) {
+	invalid := []ProfileConfig{
+		{Name: ProfileConstant},
+		{Name: "derp", MaxQPS: 10},
+		{Name: ProfileLinear, MaxQPS: 10},
+		{Name: ProfileLinear, MaxQPS: 10, StartQPS: 20, Duration: time.Second},
+		{Name: ProfileStep, MaxQPS: 10, StepQPS: 1},
+		{Name: ProfileStep, MaxQPS: 10, StepDuration: time.Second},
+		{Name: ProfileSine, MaxQPS: 10},
+	}
+	for _, c := range invalid {
+		_, err := NewLoadProfile(c)
+		require.Error(t, err, "%+v", c)
+	}
+}
+
+func Test_LoadProfiles(t *testing.T) {
+	testCases := []struct {
+		config   ProfileConfig
+		expected map[time.Duration]int
+	}{
+		{
+			config:   ProfileConfig{Name: ProfileConstant, MaxQPS: 100},
+			expected: map[time.Duration]int{0: 100, time.Hour: 100},
+		},
+		{
+			config: ProfileConfig{Name: ProfileLinear, StartQPS: 100, MaxQPS: 1100, Duration: 10 * time.Second},
+			expected: map[time.Duration]int{
+				0:                100,
+				time.Second:      200,
+				5 * time.Second:  600,
+				10 * time.Second: 1100,
+				time.Minute:      1100,
+			},
+		},
+		{
+			config: ProfileConfig{Name: ProfileStep, StartQPS: 100, MaxQPS: 250, StepQPS: 50, StepDuration: time.Minute},
+			expected: map[time.Duration]int{
+				0:                 100,
+				59 * time.Second:  100,
+				time.Minute:       150,
+				3 * time.Minute:   250,
+				2*time.Minute - 1: 150,
+				2 * time.Minute:   200,
+				time.Hour:         250,
+			},
+		},
+		{
+			config: ProfileConfig{Name: ProfileSine, StartQPS: 100, MaxQPS: 300, Period: 4 * time.Second},
+			expected: map[time.Duration]int{
+				0:               100,
+				time.Second:     200,
+				2 * time.Second: 300,
+				3 * time.Second: 200,
+				4 * time.Second: 100,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.config.Name, func(t *testing.T) {
+			p, err := NewLoadProfile(tc.config)
+			require.NoError(t, err)
+			for elapsed, qps := range tc.expected {
+				require.Equal(t, qps, p.QPS(elapsed), "at %v", elapsed)
+			}
+		})
+	}
+}
+
+func Test_ProfileLimiter(t *testing.T) {
+	now := time.Date(2020, time.November, 10, 23, 0, 0, 0, time.UTC)
+	nowfunc := func() time.Time { return now }
+	p, err := NewLoadProfile(ProfileConfig{Name: ProfileLinear, StartQPS: 0, MaxQPS: 1000, Duration: 10 * time.Second})
+	require.NoError(t, err)
+	// never drops below 1 QPS
+	require.Equal(t, 1, NewProfileLimiter(p, nowfunc).TargetQPS())
+
+	p, err = NewLoadProfile(ProfileConfig{Name: ProfileLinear, StartQPS: 100, MaxQPS: 1000, Duration: 10 * time.Second})
+	require.NoError(t, err)
+	l := NewProfileLimiter(p, nowfunc)
+	require.Equal(t, 100, l.TargetQPS())
+	l.Take()
+
+	// not re-evaluated before profileUpdateInterval
+	now = now.Add(profileUpdateInterval / 2)
+	l.Take()
+	require.Equal(t, 100, l.TargetQPS())
+
+	now = now.Add(4*time.Second + profileUpdateInterval/2)
+	l.Take()
+	require.Equal(t, 550, l.TargetQPS())
+
+	now = now.Add(time.Hour)
+	l.Take()
+	require.Equal(t, 1000, l.TargetQPS())
+
+	runState := NewRunState(1, l, false, nowfunc)
+	require.Equal(t, 1000, runState.ExportIntermediateResults().TargetQPS)
+	require.Equal(t, 1000, runState.ExportResults().TargetQPS)
+}
@@ -15,6 +15,7 @@ package query
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -163,20 +164,110 @@ func DNS(client *dns.Client, check ValidateResponse, timeout time.Duration, conn
 
 // RunQuery wraps the function that sends the DNS query and passes metrics to a channel
 func RunQuery(reqMsg *dns.Msg, requestFunc SendMsg, now func() time.Time, state *RunState) {
+	runQuery(reqMsg, requestFunc, now, state, "")
+}
+
+// runQuery is RunQuery, also breaking down the results by target unless
+// target is empty
+func runQuery(reqMsg *dns.Msg, requestFunc SendMsg, now func() time.Time, state *RunState, target string) {
 	reqStart := now()
 	log.Debugf("%v:: Request: %v", reqStart.Nanosecond(), reqMsg.Question[0].Name)
-	_, err := requestFunc(reqMsg)
+	resp, err := requestFunc(reqMsg)
+	rcode := responseRcode(resp, err)
+	state.addOutcome(dns.TypeToString[reqMsg.Question[0].Qtype], rcode, err == nil)
+	state.addResponse(responseClass(resp), err == nil)
 	if err != nil {
-		state.incErrors()
+		var transportErr *TransportError
+		state.incErrors(errors.As(err, &transportErr))
 	} else {
 		state.incProcessed()
 	}
 	reqEnd := now()
 	latency := reqEnd.Sub(reqStart)
 	state.addLatency(float64(latency))
+	if target != "" {
+		state.addTargetOutcome(target, rcode, err == nil, float64(latency))
+	}
 	log.Debugf("%v:: Response Latency: %v", reqEnd.Nanosecond(), latency)
 }
 
+// responseRcode returns the label of the response code of a query, or why
+// there was no response
+func responseRcode(resp *dns.Msg, err error) string {
+	if resp != nil {
+		if rcode, ok := dns.RcodeToString[resp.Rcode]; ok {
+			return rcode
+		}
+		return strconv.Itoa(resp.Rcode)
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return stats.RcodeTimeout
+	}
+	return stats.RcodeError
+}
+
+// responseClass returns the class of a response, as experienced by a
+// resolver, ResponseNetworkError if there was no response
+func responseClass(resp *dns.Msg) string {
+	switch {
+	case resp == nil:
+		return stats.ResponseNetworkError
+	case resp.Truncated:
+		return stats.ResponseTruncated
+	case resp.Rcode == dns.RcodeNameError:
+		return stats.ResponseNXDomain
+	case resp.Rcode != dns.RcodeSuccess:
+		return stats.ResponseFailure
+	case len(resp.Answer) == 0:
+		return stats.ResponseNoData
+	default:
+		return stats.ResponseAnswer
+	}
+}
+
+// addOutcome counts a query in the breakdowns
+func addOutcome(outcomes map[string]stats.Outcome, key string, success bool) {
+	o := outcomes[key]
+	if success {
+		o.Processed++
+	} else {
+		o.Errors++
+	}
+	outcomes[key] = o
+}
+
+// addTargetOutcome counts a query in the breakdown by target, with its
+// latency if withLatency
+func addTargetOutcome(targets map[string]stats.TargetOutcome, target, rcode string, success bool, latency float64, withLatency bool) {
+	t := targets[target]
+	if success {
+		t.Processed++
+	} else {
+		t.Errors++
+	}
+	if t.Rcodes == nil {
+		t.Rcodes = make(map[string]stats.Outcome)
+	}
+	addOutcome(t.Rcodes, rcode, success)
+	if withLatency {
+		t.Latencies = append(t.Latencies, latency)
+	}
+	targets[target] = t
+}
+
+// copyOutcomes returns a copy of outcomes, nil if empty
+func copyOutcomes(outcomes map[string]stats.Outcome) map[string]stats.Outcome {
+	if len(outcomes) == 0 {
+		return nil
+	}
+	c := make(map[string]stats.Outcome, len(outcomes))
+	for k, v := range outcomes {
+		c[k] = v
+	}
+	return c
+}
+
 // RunState object holds the state of the performance test
 type RunState struct {
 	// rate limits the queries per second.
@@ -189,17 +280,48 @@ type RunState struct {
 	processed int
 	// errors is the number of queries that failed.
 	errors int
+	// connErrors is the number of queries that failed to be exchanged with the target.
+	connErrors int
+	// protocol is the transport protocol queries are sent over.
+	protocol string
+	// qtypes and rcodes break down all queries by query type and response code.
+	qtypes map[string]stats.Outcome
+	rcodes map[string]stats.Outcome
+	// unexportedQTypes and unexportedRcodes are the breakdowns which haven't been exported yet.
+	unexportedQTypes map[string]stats.Outcome
+	unexportedRcodes map[string]stats.Outcome
+	// responses and unexportedResponses are the same breakdown by class of response.
+	responses           map[string]stats.Outcome
+	unexportedResponses map[string]stats.Outcome
+	// tcpRetries is the number of queries retried over TCP after a truncated response.
+	tcpRetries int
+	// targets and unexportedTargets break down queries by target like qtypes
+	// and rcodes, the latencies of targets being the ones already exported,
+	// like alreadyExportedLatencies.
+	targets           map[string]stats.TargetOutcome
+	unexportedTargets map[string]stats.TargetOutcome
 	// unexportedLatencies contain per query latency which havent been exported yet.
 	unexportedLatencies []float64
 	// lastExportedAt is the last time we printed the intermediate state.
 	lastExportedAt        time.Time
 	lastExportedProcessed int
 	lastExportedErrors    int
+	lastExportedConnErrs  int
+	lastExportedRetries   int
 	// alreadyExportedLatencies contain per query latency which have already been exported by `ExportIntermediateResults`, these still need to be accounted at the final export
 	alreadyExportedLatencies []float64
+	// unexportedJitters and alreadyExportedJitters are the same for the inter-send jitter, see addSend.
+	unexportedJitters      []float64
+	alreadyExportedJitters []float64
+	// lastSentAt is the time the last query was sent.
+	lastSentAt time.Time
 
 	// are we running in daemon mode
 	daemon bool
+	// status is whether queries are being sent, see Status.
+	status string
+	// statusChanged is signalled when status changes.
+	statusChanged *sync.Cond
 	// m protects all fields.
 	m sync.Mutex
 
@@ -207,9 +329,40 @@ type RunState struct {
 	nowfunc func() time.Time
 }
 
+// targetQPSLimiter is implemented by limiters whose rate changes over time
+type targetQPSLimiter interface {
+	TargetQPS() int
+}
+
+// targetQPS returns the QPS currently targeted by the limiter, 0 if unknown.
+func (r *RunState) targetQPS() int {
+	if l, ok := r.limiter.(targetQPSLimiter); ok {
+		return l.TargetQPS()
+	}
+	return 0
+}
+
+// outcomeObserver is implemented by limiters adapting to the outcome of queries
+type outcomeObserver interface {
+	Observe(rcode string)
+}
+
+// goodputLimiter is implemented by limiters measuring the goodput of the target
+type goodputLimiter interface {
+	GoodputQPS() int
+}
+
+// goodputQPS returns the goodput measured by the limiter, 0 if unknown.
+func (r *RunState) goodputQPS() int {
+	if l, ok := r.limiter.(goodputLimiter); ok {
+		return l.GoodputQPS()
+	}
+	return 0
+}
+
 // NewRunState creates a new RunState instance
 func NewRunState(queriesToSend int, limiter ratelimit.Limiter, daemon bool, nowfunc func() time.Time) *RunState {
-	return &RunState{
+	r := &RunState{
 		limiter:                  limiter,
 		queriesToSend:            queriesToSend,
 		startTime:                nowfunc(),
@@ -221,9 +374,12 @@ func NewRunState(queriesToSend int, limiter ratelimit.Limiter, daemon bool, nowf
 		lastExportedErrors:       0,
 		alreadyExportedLatencies: make([]float64, 0),
 		daemon:                   daemon,
+		status:                   StatusRunning,
 		m:                        sync.Mutex{},
 		nowfunc:                  nowfunc,
 	}
+	r.statusChanged = sync.NewCond(&r.m)
+	return r
 }
 
 // ExportIntermediateResults is used to export intermediate results while the test is still in progress
@@ -232,6 +388,7 @@ func (r *RunState) ExportIntermediateResults() *stats.ExportedMetrics {
 	defer r.m.Unlock()
 	processed := r.processed - r.lastExportedProcessed
 	failed := r.errors - r.lastExportedErrors
+	connFailed := r.connErrors - r.lastExportedConnErrs
 
 	startTime := r.lastExportedAt
 	if r.lastExportedAt.IsZero() {
@@ -244,15 +401,46 @@ func (r *RunState) ExportIntermediateResults() *stats.ExportedMetrics {
 		r.alreadyExportedLatencies = append(r.alreadyExportedLatencies, r.unexportedLatencies...)
 	}
 	r.unexportedLatencies = make([]float64, 0)
+	jitters := r.unexportedJitters
+	if !r.daemon {
+		r.alreadyExportedJitters = append(r.alreadyExportedJitters, r.unexportedJitters...)
+	}
+	r.unexportedJitters = nil
+	qtypes, rcodes := r.unexportedQTypes, r.unexportedRcodes
+	r.unexportedQTypes, r.unexportedRcodes = nil, nil
+	responses := r.unexportedResponses
+	r.unexportedResponses = nil
+	targets := r.unexportedTargets
+	r.unexportedTargets = nil
+	if !r.daemon {
+		for name, t := range targets {
+			exported := r.targets[name]
+			exported.Latencies = append(exported.Latencies, t.Latencies...)
+			r.targets[name] = exported
+		}
+	}
 
 	r.lastExportedAt = r.nowfunc()
 	r.lastExportedProcessed = r.processed
 	r.lastExportedErrors = r.errors
+	r.lastExportedConnErrs = r.connErrors
+	retries := r.tcpRetries - r.lastExportedRetries
+	r.lastExportedRetries = r.tcpRetries
 	return &stats.ExportedMetrics{
-		Elapsed:   elapsed,
-		Processed: processed,
-		Errors:    failed,
-		Latencies: latencies,
+		Elapsed:    elapsed,
+		Protocol:   r.protocol,
+		TargetQPS:  r.targetQPS(),
+		GoodputQPS: r.goodputQPS(),
+		Processed:  processed,
+		Errors:     failed,
+		ConnErrors: connFailed,
+		QTypes:     copyOutcomes(qtypes),
+		Rcodes:     copyOutcomes(rcodes),
+		Responses:  copyOutcomes(responses),
+		TCPRetries: retries,
+		Targets:    targets,
+		Latencies:  latencies,
+		Jitters:    jitters,
 	}
 }
 
@@ -260,11 +448,41 @@ func (r *RunState) ExportIntermediateResults() *stats.ExportedMetrics {
 func (r *RunState) ExportResults() *stats.ExportedMetrics {
 	r.m.Lock()
 	defer r.m.Unlock()
+	// copy the latencies, they get sorted by the reporters while the test may still be running
+	latencies := make([]float64, 0, len(r.alreadyExportedLatencies)+len(r.unexportedLatencies))
+	latencies = append(latencies, r.alreadyExportedLatencies...)
+	latencies = append(latencies, r.unexportedLatencies...)
+	var targets map[string]stats.TargetOutcome
+	if len(r.targets) > 0 {
+		targets = make(map[string]stats.TargetOutcome, len(r.targets))
+	}
+	for name, t := range r.targets {
+		unexported := r.unexportedTargets[name]
+		targets[name] = stats.TargetOutcome{
+			Outcome:   t.Outcome,
+			Rcodes:    copyOutcomes(t.Rcodes),
+			Latencies: append(append([]float64(nil), t.Latencies...), unexported.Latencies...),
+		}
+	}
+	// without target QPS there are no jitters
+	var jitters []float64
+	jitters = append(jitters, r.alreadyExportedJitters...)
+	jitters = append(jitters, r.unexportedJitters...)
 	return &stats.ExportedMetrics{
-		Elapsed:   r.nowfunc().Sub(r.startTime),
-		Processed: r.processed,
-		Errors:    r.errors,
-		Latencies: append(r.alreadyExportedLatencies, r.unexportedLatencies...),
+		Elapsed:    r.nowfunc().Sub(r.startTime),
+		Protocol:   r.protocol,
+		TargetQPS:  r.targetQPS(),
+		GoodputQPS: r.goodputQPS(),
+		Processed:  r.processed,
+		Errors:     r.errors,
+		ConnErrors: r.connErrors,
+		QTypes:     copyOutcomes(r.qtypes),
+		Rcodes:     copyOutcomes(r.rcodes),
+		Responses:  copyOutcomes(r.responses),
+		TCPRetries: r.tcpRetries,
+		Targets:    targets,
+		Latencies:  latencies,
+		Jitters:    jitters,
 	}
 }
 
@@ -283,11 +501,65 @@ func (r *RunState) incProcessed() {
 	r.processed++
 }
 
-// incErrors increments errors number
-func (r *RunState) incErrors() {
+// incErrors increments errors number, and connErrors if the query could not
+// be exchanged with the target
+func (r *RunState) incErrors(connErr bool) {
 	r.m.Lock()
 	defer r.m.Unlock()
 	r.errors++
+	if connErr {
+		r.connErrors++
+	}
+}
+
+// addOutcome records the query type and response code of a query, and passes
+// the response code to limiters adapting to it
+func (r *RunState) addOutcome(qtype, rcode string, success bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if o, ok := r.limiter.(outcomeObserver); ok {
+		o.Observe(rcode)
+	}
+	if r.qtypes == nil {
+		r.qtypes = make(map[string]stats.Outcome)
+		r.rcodes = make(map[string]stats.Outcome)
+	}
+	if r.unexportedQTypes == nil {
+		r.unexportedQTypes = make(map[string]stats.Outcome)
+		r.unexportedRcodes = make(map[string]stats.Outcome)
+	}
+	addOutcome(r.qtypes, qtype, success)
+	addOutcome(r.rcodes, rcode, success)
+	addOutcome(r.unexportedQTypes, qtype, success)
+	addOutcome(r.unexportedRcodes, rcode, success)
+}
+
+// addResponse records the class of the response of a query
+func (r *RunState) addResponse(class string, success bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.responses == nil {
+		r.responses = make(map[string]stats.Outcome)
+	}
+	if r.unexportedResponses == nil {
+		r.unexportedResponses = make(map[string]stats.Outcome)
+	}
+	addOutcome(r.responses, class, success)
+	addOutcome(r.unexportedResponses, class, success)
+}
+
+// incTCPRetries increments the number of queries retried over TCP
+func (r *RunState) incTCPRetries() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.tcpRetries++
+}
+
+// setProtocol records the transport protocol used for the test
+func (r *RunState) setProtocol(protocol string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.protocol = protocol
 }
 
 // addLatency records a query execution time
@@ -297,31 +569,139 @@ func (r *RunState) addLatency(latency float64) {
 	r.unexportedLatencies = append(r.unexportedLatencies, latency)
 }
 
+// addTargetOutcome records the outcome and latency of a query sent to target
+func (r *RunState) addTargetOutcome(target, rcode string, success bool, latency float64) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.targets == nil {
+		r.targets = make(map[string]stats.TargetOutcome)
+	}
+	if r.unexportedTargets == nil {
+		r.unexportedTargets = make(map[string]stats.TargetOutcome)
+	}
+	addTargetOutcome(r.targets, target, rcode, success, latency, false)
+	addTargetOutcome(r.unexportedTargets, target, rcode, success, latency, true)
+}
+
+// addSend records the time t a query was sent at, and the inter-send jitter:
+// how much the time since the previous query, sent by any connection, differs
+// from the interval of the QPS targeted. Without target, e.g. unlimited, there
+// is no jitter.
+func (r *RunState) addSend(t time.Time) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	last := r.lastSentAt
+	r.lastSentAt = t
+	qps := r.targetQPS()
+	if last.IsZero() || qps == 0 {
+		return
+	}
+	jitter := t.Sub(last) - time.Second/time.Duration(qps)
+	if jitter < 0 {
+		jitter = -jitter
+	}
+	r.unexportedJitters = append(r.unexportedJitters, float64(jitter))
+}
+
+// getStartTime returns the time the test has been started
+func (r *RunState) getStartTime() time.Time {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.startTime
+}
+
 func (r *RunState) getProcessedQueries() int {
 	r.m.Lock()
 	defer r.m.Unlock()
 	return r.processed + r.errors
 }
 
-// RunQueries starts loading the target host with DNS queries
-func RunQueries(dport int, host string, domains []string, timeout time.Duration, randomiseQueries bool, qTypes []dns.Type, now func() time.Time, runState *RunState, sigpause chan struct{}) error {
-	client := DNSClient(timeout)
-	conn, err := ClientConnection(client, dport, host)
-	if err != nil {
-		return err
+// queryGenerator generates the queries sent by a connection
+type queryGenerator interface {
+	// next returns the next query to send
+	next() *dns.Msg
+	// take blocks until the next query may be sent, following limiter, and
+	// returns the time it's sent at
+	take(limiter ratelimit.Limiter) time.Time
+}
+
+// listGenerator sends queries for domains and their query types in turn
+type listGenerator struct {
+	domains   []string
+	qTypes    []dns.Type
+	randomise bool
+	runState  *RunState
+}
+
+func (g *listGenerator) next() *dns.Msg {
+	idx := g.runState.getProcessedQueries() % len(g.domains)
+	return MakeReq(g.domains[idx], time.Now, g.randomise, g.qTypes[idx])
+}
+
+func (g *listGenerator) take(limiter ratelimit.Limiter) time.Time {
+	return limiter.Take()
+}
+
+// RunQueries starts loading the target host with DNS queries, or targets if
+// any, spreading queries across them following their weights
+func RunQueries(transportConfig TransportConfig, targets []Target, edns EDNSConfig, domains []string, randomiseQueries bool, qTypes []dns.Type, now func() time.Time, runState *RunState, sigpause chan struct{}) error {
+	gen := &listGenerator{domains: domains, qTypes: qTypes, randomise: randomiseQueries, runState: runState}
+	return runQueries(transportConfig, targets, edns, gen, now, runState, sigpause)
+}
+
+// RunScenario is RunQueries, sending the queries of scenario
+func RunScenario(transportConfig TransportConfig, targets []Target, edns EDNSConfig, scenario *Scenario, now func() time.Time, runState *RunState, sigpause chan struct{}) error {
+	gen := scenario.newGenerator(runState.getStartTime(), now)
+	return runQueries(transportConfig, targets, edns, gen, now, runState, sigpause)
+}
+
+// runQueries is RunQueries, sending the queries of gen
+func runQueries(transportConfig TransportConfig, targets []Target, edns EDNSConfig, gen queryGenerator, now func() time.Time, runState *RunState, sigpause chan struct{}) error {
+	if len(targets) == 0 {
+		targets = []Target{{Host: transportConfig.Host, Port: transportConfig.Port, Weight: 1}}
+	}
+	requests := make([]SendMsg, len(targets))
+	// results are only broken down by target when there are several
+	names := make([]string, len(targets))
+	for i, target := range targets {
+		config := transportConfig
+		config.Host, config.Port = target.Host, target.Port
+		transport, err := NewTransport(config)
+		if err != nil {
+			return err
+		}
+		defer transport.Close()
+		runState.setProtocol(transport.Protocol())
+		requests[i] = TransportSendMsg(transport, CheckResponse)
+		if config.RetryTCP && config.Protocol == ProtocolUDP {
+			config.Protocol = ProtocolTCP
+			tcp, err := NewTransport(config)
+			if err != nil {
+				return err
+			}
+			defer tcp.Close()
+			requests[i] = withTCPRetry(requests[i], TransportSendMsg(tcp, CheckResponse), runState.incTCPRetries)
+		}
+		if edns.Enabled() {
+			requests[i] = WithEDNS(requests[i], edns)
+		}
+		if len(targets) > 1 {
+			names[i] = target.String()
+		}
 	}
-	request := DNS(client, CheckResponse, timeout, conn)
+	picker := newTargetPicker(targets)
 	queriesToSend := runState.decQueriesToSend()
 	for queriesToSend >= 0 || runState.daemon {
+		runState.waitRunning()
 		select {
 		case <-sigpause:
 			log.Warningf("Pausing for 5 seconds as Monitor Host/Port not responding")
 			time.Sleep(time.Second * 5)
 		default:
-			idx := runState.getProcessedQueries() % len(domains)
-			reqMsg := MakeReq(domains[idx], time.Now, randomiseQueries, qTypes[idx])
-			runState.limiter.Take()
-			RunQuery(reqMsg, request, now, runState)
+			reqMsg := gen.next()
+			runState.addSend(gen.take(runState.getLimiter()))
+			i := picker.next()
+			runQuery(reqMsg, requests[i], now, runState, names[i])
 			queriesToSend = runState.decQueriesToSend()
 		}
 	}
@@ -14,6 +14,7 @@ limitations under the License.
 package query
 
 import (
+	"context"
 	"errors"
 	"os"
 	"regexp"
@@ -131,13 +132,24 @@ func Test_RunQuery(t *testing.T) {
 
 	RunQuery(reqMsg, doSendMsgSuccess, tf, runState)
 
-	expected := &stats.ExportedMetrics{Elapsed: 30000000, Processed: 1, Errors: 0, Latencies: []float64{10000000}}
+	expected := &stats.ExportedMetrics{
+		Elapsed:   30000000,
+		Processed: 1,
+		Errors:    0,
+		QTypes:    map[string]stats.Outcome{"ANY": {Processed: 1}},
+		Rcodes:    map[string]stats.Outcome{"NOERROR": {Processed: 1}},
+		Responses: map[string]stats.Outcome{stats.ResponseAnswer: {Processed: 1}},
+		Latencies: []float64{10000000},
+	}
 	exportedMetrics := runState.ExportResults()
 	require.Equal(t, expected, exportedMetrics)
 
 	RunQuery(reqMsg, doSendMsgFailure, tf, runState)
 
 	expected.Errors = 1
+	expected.QTypes["ANY"] = stats.Outcome{Processed: 1, Errors: 1}
+	expected.Rcodes["NOERROR"] = stats.Outcome{Processed: 1, Errors: 1}
+	expected.Responses[stats.ResponseNoData] = stats.Outcome{Errors: 1}
 	expected.Latencies = append(expected.Latencies, 10000000)
 	expected.Elapsed = 60000000
 
@@ -147,6 +159,9 @@ func Test_RunQuery(t *testing.T) {
 	RunQuery(reqMsg, doSendMsgNil, tf, runState)
 
 	expected.Errors = 2
+	expected.QTypes["ANY"] = stats.Outcome{Processed: 1, Errors: 2}
+	expected.Rcodes[stats.RcodeError] = stats.Outcome{Errors: 1}
+	expected.Responses[stats.ResponseNetworkError] = stats.Outcome{Errors: 1}
 	expected.Latencies = append(expected.Latencies, 10000000)
 	expected.Elapsed = 90000000
 	exportedMetrics = runState.ExportResults()
@@ -161,7 +176,15 @@ func Test_exportIntermediateMetrics(t *testing.T) {
 
 	RunQuery(reqMsg, doSendMsgSuccess, tf, runState)
 
-	expected := &stats.ExportedMetrics{Elapsed: 30000000, Processed: 1, Errors: 0, Latencies: []float64{10000000}}
+	expected := &stats.ExportedMetrics{
+		Elapsed:   30000000,
+		Processed: 1,
+		Errors:    0,
+		QTypes:    map[string]stats.Outcome{"ANY": {Processed: 1}},
+		Rcodes:    map[string]stats.Outcome{"NOERROR": {Processed: 1}},
+		Responses: map[string]stats.Outcome{stats.ResponseAnswer: {Processed: 1}},
+		Latencies: []float64{10000000},
+	}
 	exportedMetrics := runState.ExportIntermediateResults()
 	require.Equal(t, expected, exportedMetrics)
 
@@ -169,6 +192,9 @@ func Test_exportIntermediateMetrics(t *testing.T) {
 
 	expected.Errors = 1
 	expected.Processed = 0
+	expected.QTypes = map[string]stats.Outcome{"ANY": {Errors: 1}}
+	expected.Rcodes = map[string]stats.Outcome{"NOERROR": {Errors: 1}}
+	expected.Responses = map[string]stats.Outcome{stats.ResponseNoData: {Errors: 1}}
 
 	exportedMetrics = runState.ExportIntermediateResults()
 	require.Equal(t, expected, exportedMetrics)
@@ -177,6 +203,9 @@ func Test_exportIntermediateMetrics(t *testing.T) {
 
 	expected.Errors = 2
 	expected.Processed = 1
+	expected.QTypes = map[string]stats.Outcome{"ANY": {Processed: 1, Errors: 2}}
+	expected.Rcodes = map[string]stats.Outcome{"NOERROR": {Processed: 1, Errors: 2}}
+	expected.Responses = map[string]stats.Outcome{stats.ResponseAnswer: {Processed: 1}, stats.ResponseNoData: {Errors: 2}}
 	expected.Latencies = append(expected.Latencies, 10000000, 10000000)
 	expected.Elapsed = 110000000
 	exportedMetrics = runState.ExportResults()
@@ -193,13 +222,104 @@ func Test_StateAddSuccess(t *testing.T) {
 func Test_StateAddError(t *testing.T) {
 	runState := &RunState{nowfunc: timefunc()}
 
-	runState.incErrors()
+	runState.incErrors(false)
 	require.Equal(t, 1, runState.ExportResults().Errors)
 }
 
+func Test_responseRcode(t *testing.T) {
+	nxdomain := new(dns.Msg)
+	nxdomain.Rcode = dns.RcodeNameError
+	require.Equal(t, "NXDOMAIN", responseRcode(nxdomain, errors.New("DNS request: no reply received")))
+	require.Equal(t, "NOERROR", responseRcode(msgSingleARecord(), nil))
+	require.Equal(t, stats.RcodeTimeout, responseRcode(nil, &TransportError{Protocol: ProtocolUDP, Err: os.ErrDeadlineExceeded}))
+	require.Equal(t, stats.RcodeTimeout, responseRcode(nil, context.DeadlineExceeded))
+	require.Equal(t, stats.RcodeError, responseRcode(nil, errors.New("I am an error")))
+}
+
+func Test_responseClass(t *testing.T) {
+	resp := func(rcode int, answers int, truncated bool) *dns.Msg {
+		m := new(dns.Msg)
+		m.Rcode, m.Truncated = rcode, truncated
+		for i := 0; i < answers; i++ {
+			m.Answer = append(m.Answer, A("example.com. 60 IN A 192.0.2.1"))
+		}
+		return m
+	}
+	require.Equal(t, stats.ResponseAnswer, responseClass(resp(dns.RcodeSuccess, 2, false)))
+	require.Equal(t, stats.ResponseNoData, responseClass(resp(dns.RcodeSuccess, 0, false)))
+	require.Equal(t, stats.ResponseNXDomain, responseClass(resp(dns.RcodeNameError, 0, false)))
+	require.Equal(t, stats.ResponseTruncated, responseClass(resp(dns.RcodeSuccess, 0, true)))
+	require.Equal(t, stats.ResponseFailure, responseClass(resp(dns.RcodeServerFailure, 0, false)))
+	require.Equal(t, stats.ResponseNetworkError, responseClass(nil))
+}
+
+func Test_StateAddResponse(t *testing.T) {
+	runState := &RunState{nowfunc: timefunc()}
+	runState.addResponse(stats.ResponseAnswer, true)
+	runState.addResponse(stats.ResponseNoData, false)
+	runState.incTCPRetries()
+	intermediate := runState.ExportIntermediateResults()
+	require.Equal(t, map[string]stats.Outcome{stats.ResponseAnswer: {Processed: 1}, stats.ResponseNoData: {Errors: 1}}, intermediate.Responses)
+	require.Equal(t, 1, intermediate.TCPRetries)
+
+	runState.addResponse(stats.ResponseAnswer, true)
+	intermediate = runState.ExportIntermediateResults()
+	require.Equal(t, map[string]stats.Outcome{stats.ResponseAnswer: {Processed: 1}}, intermediate.Responses)
+	require.Equal(t, 0, intermediate.TCPRetries)
+
+	final := runState.ExportResults()
+	require.Equal(t, map[string]stats.Outcome{stats.ResponseAnswer: {Processed: 2}, stats.ResponseNoData: {Errors: 1}}, final.Responses)
+	require.Equal(t, 1, final.TCPRetries)
+}
+
 func Test_StateAddLatency(t *testing.T) {
 	runState := &RunState{nowfunc: timefunc()}
 
 	runState.addLatency(2)
 	require.Equal(t, []float64{2}, runState.ExportResults().Latencies)
 }
+
+func Test_StateAddSend(t *testing.T) {
+	now := time.Date(2020, time.November, 10, 23, 0, 0, 0, time.UTC)
+	nowfunc := func() time.Time { return now }
+	profile, err := NewLoadProfile(ProfileConfig{Name: ProfileConstant, MaxQPS: 100})
+	require.NoError(t, err)
+	runState := NewRunState(1, NewProfileLimiter(profile, nowfunc), true, nowfunc)
+
+	// the first query has nothing to be compared to
+	runState.addSend(now)
+	runState.addSend(now.Add(12 * time.Millisecond))
+	runState.addSend(now.Add(20 * time.Millisecond))
+	require.Equal(t, []float64{float64(2 * time.Millisecond), float64(2 * time.Millisecond)}, runState.ExportIntermediateResults().Jitters)
+	require.Empty(t, runState.ExportIntermediateResults().Jitters)
+
+	// no jitter without a target QPS
+	runState = NewRunState(1, ratelimit.NewUnlimited(), false, nowfunc)
+	runState.addSend(now)
+	runState.addSend(now.Add(time.Millisecond))
+	require.Empty(t, runState.ExportResults().Jitters)
+}
+
+func Test_StateAddTargetOutcome(t *testing.T) {
+	runState := NewRunState(1, ratelimit.NewUnlimited(), false, timefunc())
+
+	runState.addTargetOutcome("a", "NOERROR", true, 1)
+	runState.addTargetOutcome("b", stats.RcodeTimeout, false, 2)
+	intermediate := runState.ExportIntermediateResults()
+	require.Equal(t, map[string]stats.TargetOutcome{
+		"a": {Outcome: stats.Outcome{Processed: 1}, Rcodes: map[string]stats.Outcome{"NOERROR": {Processed: 1}}, Latencies: []float64{1}},
+		"b": {Outcome: stats.Outcome{Errors: 1}, Rcodes: map[string]stats.Outcome{stats.RcodeTimeout: {Errors: 1}}, Latencies: []float64{2}},
+	}, intermediate.Targets)
+
+	runState.addTargetOutcome("a", "SERVFAIL", true, 3)
+	require.Equal(t, map[string]stats.TargetOutcome{
+		"a": {Outcome: stats.Outcome{Processed: 1}, Rcodes: map[string]stats.Outcome{"SERVFAIL": {Processed: 1}}, Latencies: []float64{3}},
+	}, runState.ExportIntermediateResults().Targets)
+
+	// the final results cover the whole test
+	results := runState.ExportResults()
+	require.Equal(t, stats.Outcome{Processed: 2}, results.Targets["a"].Outcome)
+	require.Equal(t, []float64{1, 3}, results.Targets["a"].Latencies)
+	require.Equal(t, []float64{2}, results.Targets["b"].Latencies)
+	require.Nil(t, NewRunState(1, ratelimit.NewUnlimited(), false, timefunc()).ExportResults().Targets)
+}
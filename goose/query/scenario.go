@@ -0,0 +1,240 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/ratelimit"
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is a mix of queries read from a YAML scenario file, e.g.
+//
+//	seed: 42
+//	think-time: 5ms
+//	think-time-jitter: 10ms
+//	burst:
+//	  every: 1m
+//	  length: 5s
+//	queries:
+//	  - qname: www.example.com
+//	    qtype: AAAA
+//	    percent: 70
+//	  - qname: "host{rand:1000}.example.com"
+//	    percent: 30
+//
+// Every connection picks its queries at random following their percentages,
+// from its own generator seeded with Seed, so that runs with the same seed
+// and number of connections send the same queries.
+type Scenario struct {
+	// Seed seeds the random choices of the connections
+	Seed int64 `yaml:"seed"`
+	// Queries are the queries sent, summing up to 100 percent
+	Queries []ScenarioQuery `yaml:"queries"`
+	// ThinkTime is how long every connection waits after a query before
+	// sending the next one, plus up to ThinkTimeJitter picked at random
+	ThinkTime       time.Duration `yaml:"think-time"`
+	ThinkTimeJitter time.Duration `yaml:"think-time-jitter"`
+	// Burst are the periods queries are sent back to back
+	Burst ScenarioBurst `yaml:"burst"`
+
+	// connections is the number of connections which started running the
+	// scenario, each one seeding its generator with the next seed
+	connections atomic.Int64
+}
+
+// ScenarioQuery is a query of a Scenario
+type ScenarioQuery struct {
+	// QName is the name queried, {rand} being replaced with a random number,
+	// and {rand:N} with a random number below N, bounding the number of
+	// distinct names
+	QName string `yaml:"qname"`
+	// QType is the type queried, defaults to A
+	QType string `yaml:"qtype"`
+	// Percent is the share of the queries sent
+	Percent float64 `yaml:"percent"`
+
+	qtype dns.Type
+}
+
+// ScenarioBurst describes the bursts of a Scenario: for Length at the start
+// of every Every since the start of the test, connections send queries back
+// to back, ignoring the max QPS and think times. Disabled if Every is 0.
+type ScenarioBurst struct {
+	Every  time.Duration `yaml:"every"`
+	Length time.Duration `yaml:"length"`
+}
+
+// percentEpsilon is the rounding error tolerated on the sum of percentages
+const percentEpsilon = 0.001
+
+// qnameRand matches the random parts of scenario qnames
+var qnameRand = regexp.MustCompile(`\{rand(:[0-9]+)?\}`)
+
+// ParseScenario parses and validates a scenario, see Scenario
+func ParseScenario(b []byte) (*Scenario, error) {
+	s := &Scenario{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(s); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %w", err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ReadScenarioFile reads a scenario from a file, see ParseScenario
+func ReadScenarioFile(path string) (*Scenario, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseScenario(b)
+}
+
+// validate checks s and parses the query types of its queries
+func (s *Scenario) validate() error {
+	if len(s.Queries) == 0 {
+		return fmt.Errorf("scenario has no queries")
+	}
+	total := 0.0
+	for i := range s.Queries {
+		q := &s.Queries[i]
+		if q.QName == "" {
+			return fmt.Errorf("scenario query %d has no qname", i)
+		}
+		for _, m := range qnameRand.FindAllStringSubmatch(q.QName, -1) {
+			if m[1] == "" {
+				continue
+			}
+			if n, err := strconv.Atoi(m[1][1:]); err != nil || n <= 0 {
+				return fmt.Errorf("scenario query %q: invalid %s", q.QName, m[0])
+			}
+		}
+		if q.QType == "" {
+			q.QType = "A"
+		}
+		qtype, err := QTypeStrToDNSQtype(q.QType)
+		if err != nil {
+			return fmt.Errorf("scenario query %q: %w", q.QName, err)
+		}
+		q.qtype = qtype
+		if q.Percent <= 0 {
+			return fmt.Errorf("scenario query %q: percent must be positive", q.QName)
+		}
+		total += q.Percent
+	}
+	if math.Abs(total-100) > percentEpsilon {
+		return fmt.Errorf("scenario query percentages sum up to %v, not 100", total)
+	}
+	if s.ThinkTime < 0 || s.ThinkTimeJitter < 0 {
+		return fmt.Errorf("scenario think times must not be negative")
+	}
+	if s.Burst.Every < 0 || (s.Burst.Every > 0 && (s.Burst.Length <= 0 || s.Burst.Length >= s.Burst.Every)) {
+		return fmt.Errorf("scenario burst length %v must be positive and shorter than every %v", s.Burst.Length, s.Burst.Every)
+	}
+	return nil
+}
+
+// scenarioGenerator generates the queries of a connection following a
+// Scenario
+type scenarioGenerator struct {
+	scenario *Scenario
+	rand     *rand.Rand
+	// cumulative are the cumulative percentages of the queries
+	cumulative []float64
+	start      time.Time
+	now        func() time.Time
+	sent       bool
+}
+
+// newGenerator returns the generator of the next connection running s, start
+// being the start of the test bursts are timed from
+func (s *Scenario) newGenerator(start time.Time, now func() time.Time) *scenarioGenerator {
+	seed := s.Seed + s.connections.Add(1) - 1
+	g := &scenarioGenerator{
+		scenario:   s,
+		rand:       rand.New(rand.NewSource(seed)), // #nosec G404 -- reproducible, not secure, randomness
+		cumulative: make([]float64, len(s.Queries)),
+		start:      start,
+		now:        now,
+	}
+	total := 0.0
+	for i, q := range s.Queries {
+		total += q.Percent
+		g.cumulative[i] = total
+	}
+	return g
+}
+
+// next returns the next query to send
+func (g *scenarioGenerator) next() *dns.Msg {
+	p := g.rand.Float64() * g.cumulative[len(g.cumulative)-1]
+	i := 0
+	for i < len(g.cumulative)-1 && p >= g.cumulative[i] {
+		i++
+	}
+	q := g.scenario.Queries[i]
+	qname := qnameRand.ReplaceAllStringFunc(q.QName, func(m string) string {
+		if m == "{rand}" {
+			return strconv.Itoa(g.rand.Intn(1000000000))
+		}
+		n, _ := strconv.Atoi(m[len("{rand:") : len(m)-1])
+		return strconv.Itoa(g.rand.Intn(n))
+	})
+	return MakeReq(qname, g.now, false, q.qtype)
+}
+
+// bursting returns whether t is within a burst
+func (g *scenarioGenerator) bursting(t time.Time) bool {
+	b := g.scenario.Burst
+	return b.Every > 0 && t.Sub(g.start)%b.Every < b.Length
+}
+
+// thinkTime returns how long to wait before sending the next query
+func (g *scenarioGenerator) thinkTime() time.Duration {
+	d := g.scenario.ThinkTime
+	if g.scenario.ThinkTimeJitter > 0 {
+		d += time.Duration(g.rand.Int63n(int64(g.scenario.ThinkTimeJitter) + 1))
+	}
+	return d
+}
+
+// take blocks until the next query may be sent, and returns the time it's
+// sent at
+func (g *scenarioGenerator) take(limiter ratelimit.Limiter) time.Time {
+	if g.bursting(g.now()) {
+		g.sent = true
+		return g.now()
+	}
+	if g.sent {
+		if d := g.thinkTime(); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	g.sent = true
+	return limiter.Take()
+}
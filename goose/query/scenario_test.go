@@ -0,0 +1,168 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/ratelimit"
+)
+
+const testScenario = `
+seed: 42
+think-time: 5ms
+think-time-jitter: 10ms
+burst:
+  every: 1m
+  length: 5s
+queries:
+  - qname: www.example.com
+    qtype: AAAA
+    percent: 75
+  - qname: "host{rand:10}.example.com"
+    percent: 25
+`
+
+func Test_ParseScenario(t *testing.T) {
+	s, err := ParseScenario([]byte(testScenario))
+	require.NoError(t, err)
+	require.Equal(t, int64(42), s.Seed)
+	require.Equal(t, 5*time.Millisecond, s.ThinkTime)
+	require.Equal(t, 10*time.Millisecond, s.ThinkTimeJitter)
+	require.Equal(t, ScenarioBurst{Every: time.Minute, Length: 5 * time.Second}, s.Burst)
+	require.Len(t, s.Queries, 2)
+	require.Equal(t, dns.Type(dns.TypeAAAA), s.Queries[0].qtype)
+	// qtype defaults to A
+	require.Equal(t, "A", s.Queries[1].QType)
+	require.Equal(t, dns.Type(dns.TypeA), s.Queries[1].qtype)
+
+	for _, in := range []string{
+		"",
+		"queries: []",
+		"queries: [{qname: a.example.com, percent: 50}]",
+		"queries: [{qname: a.example.com, percent: 100, qtype: BOGUS}]",
+		"queries: [{qname: a.example.com, percent: 0}, {qname: b.example.com, percent: 100}]",
+		"queries: [{percent: 100}]",
+		"queries: [{qname: 'a{rand:0}.example.com', percent: 100}]",
+		"queries: [{qname: a.example.com, percent: 100}]\nthink-time: -1s",
+		"queries: [{qname: a.example.com, percent: 100}]\nburst: {every: 1s}",
+		"queries: [{qname: a.example.com, percent: 100}]\nburst: {every: 1s, length: 2s}",
+		"queries: [{qname: a.example.com, percent: 100}]\nunknown: 1",
+	} {
+		_, err := ParseScenario([]byte(in))
+		require.Error(t, err, in)
+	}
+}
+
+func Test_ReadScenarioFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testScenario), 0o600))
+	s, err := ReadScenarioFile(path)
+	require.NoError(t, err)
+	require.Len(t, s.Queries, 2)
+
+	_, err = ReadScenarioFile(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
+
+// generate returns the names and types of n queries of a new generator of s
+func generate(s *Scenario, n int) []string {
+	g := s.newGenerator(time.Now(), time.Now)
+	var queries []string
+	for i := 0; i < n; i++ {
+		q := g.next().Question[0]
+		queries = append(queries, q.Name+" "+dns.TypeToString[q.Qtype])
+	}
+	return queries
+}
+
+func Test_scenarioGeneratorMix(t *testing.T) {
+	s, err := ParseScenario([]byte(testScenario))
+	require.NoError(t, err)
+	queries := generate(s, 4000)
+	www, hosts := 0, make(map[string]bool)
+	for _, q := range queries {
+		if q == "www.example.com. AAAA" {
+			www++
+			continue
+		}
+		require.True(t, strings.HasPrefix(q, "host"), q)
+		require.True(t, strings.HasSuffix(q, ".example.com. A"), q)
+		hosts[q] = true
+	}
+	require.InDelta(t, 3000, www, 200)
+	// {rand:10} bounds the number of distinct names
+	require.LessOrEqual(t, len(hosts), 10)
+
+	// the next connection gets different queries
+	require.NotEqual(t, queries, generate(s, 4000))
+	// the same scenario sends the same queries on every run
+	again, err := ParseScenario([]byte(testScenario))
+	require.NoError(t, err)
+	require.Equal(t, queries, generate(again, 4000))
+}
+
+func Test_scenarioGeneratorPacing(t *testing.T) {
+	s, err := ParseScenario([]byte(testScenario))
+	require.NoError(t, err)
+	start := time.Now()
+	now := start
+	g := s.newGenerator(start, func() time.Time { return now })
+	require.True(t, g.bursting(now))
+	require.True(t, g.bursting(start.Add(61*time.Second)))
+	require.False(t, g.bursting(start.Add(5*time.Second)))
+	require.False(t, g.bursting(start.Add(59*time.Second)))
+	for i := 0; i < 100; i++ {
+		d := g.thinkTime()
+		require.GreaterOrEqual(t, d, 5*time.Millisecond)
+		require.LessOrEqual(t, d, 15*time.Millisecond)
+	}
+
+	// bursts ignore the limiter
+	limiter := ratelimit.New(1)
+	limiter.Take()
+	require.Equal(t, now, g.take(limiter))
+	require.Equal(t, now, g.take(limiter))
+}
+
+func Test_RunScenario(t *testing.T) {
+	host, port := hostPort(t, startDNSServer(t, "udp"))
+	s, err := ParseScenario([]byte(`
+queries:
+  - qname: www.example.com
+    qtype: AAAA
+    percent: 50
+  - qname: "{rand}.example.com"
+    qtype: TXT
+    percent: 50
+`))
+	require.NoError(t, err)
+	runState := NewRunState(100, ratelimit.NewUnlimited(), false, time.Now)
+	conf := TransportConfig{Protocol: ProtocolUDP, Host: host, Port: port, Timeout: time.Second}
+	err = RunScenario(conf, nil, EDNSConfig{}, s, time.Now, runState, make(chan struct{}))
+	require.NoError(t, err)
+	results := runState.ExportResults()
+	require.Equal(t, 100, results.Processed+results.Errors)
+	require.Len(t, results.QTypes, 2)
+	aaaa, txt := results.QTypes["AAAA"], results.QTypes["TXT"]
+	require.Equal(t, 100, aaaa.Processed+aaaa.Errors+txt.Processed+txt.Errors)
+	require.Positive(t, aaaa.Processed+aaaa.Errors)
+	require.Positive(t, txt.Processed+txt.Errors)
+}
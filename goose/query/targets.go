@@ -0,0 +1,141 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Target is a DNS server queries are sent to, and its share of the queries
+type Target struct {
+	Host string
+	Port int
+	// Weight is the share of queries sent to the target relative to the
+	// other targets
+	Weight int
+}
+
+// String returns the address of the target, used to label its results
+func (t Target) String() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+// ParseTarget parses a target written host[:port][=weight], IPv6 addresses
+// with a port being enclosed in brackets. Port defaults to defaultPort and
+// weight to 1.
+func ParseTarget(s string, defaultPort int) (Target, error) {
+	t := Target{Port: defaultPort, Weight: 1}
+	addr := s
+	if i := strings.LastIndex(s, "="); i >= 0 {
+		addr = s[:i]
+		w, err := strconv.Atoi(s[i+1:])
+		if err != nil || w <= 0 {
+			return t, fmt.Errorf("invalid target %q: weight must be a positive integer", s)
+		}
+		t.Weight = w
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// no port
+		host = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	} else {
+		p, err := strconv.Atoi(port)
+		if err != nil || p <= 0 || p > 65535 {
+			return t, fmt.Errorf("invalid target %q: invalid port %q", s, port)
+		}
+		t.Port = p
+	}
+	if host == "" {
+		return t, fmt.Errorf("invalid target %q: missing host", s)
+	}
+	t.Host = host
+	return t, nil
+}
+
+// ParseTargets parses a comma separated list of targets, see ParseTarget
+func ParseTargets(s string, defaultPort int) ([]Target, error) {
+	var targets []Target
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		t, err := ParseTarget(f, defaultPort)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// ReadTargetsFile reads targets from a file, one per line, see ParseTarget.
+// Empty lines and lines starting with # are ignored.
+func ReadTargetsFile(path string, defaultPort int) ([]Target, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var targets []Target
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		t, err := ParseTarget(line, defaultPort)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, scanner.Err()
+}
+
+// targetPicker spreads queries across targets following their weights, using
+// smooth weighted round robin so that every target gets its exact share of
+// any run of total weight queries, interleaved
+type targetPicker struct {
+	weights []int
+	current []int
+	total   int
+}
+
+func newTargetPicker(targets []Target) *targetPicker {
+	p := &targetPicker{weights: make([]int, len(targets)), current: make([]int, len(targets))}
+	for i, t := range targets {
+		p.weights[i] = t.Weight
+		p.total += t.Weight
+	}
+	return p
+}
+
+// next returns the index of the target the next query goes to
+func (p *targetPicker) next() int {
+	best := 0
+	for i, w := range p.weights {
+		p.current[i] += w
+		if p.current[i] > p.current[best] {
+			best = i
+		}
+	}
+	p.current[best] -= p.total
+	return best
+}
@@ -0,0 +1,80 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseTarget(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected Target
+	}{
+		{in: "192.0.2.1", expected: Target{Host: "192.0.2.1", Port: 53, Weight: 1}},
+		{in: "192.0.2.1:8053=3", expected: Target{Host: "192.0.2.1", Port: 8053, Weight: 3}},
+		{in: "::1=2", expected: Target{Host: "::1", Port: 53, Weight: 2}},
+		{in: "[::1]", expected: Target{Host: "::1", Port: 53, Weight: 1}},
+		{in: "[::1]:8053", expected: Target{Host: "::1", Port: 8053, Weight: 1}},
+		{in: "dns.example.com:5353", expected: Target{Host: "dns.example.com", Port: 5353, Weight: 1}},
+	}
+	for _, tt := range tests {
+		target, err := ParseTarget(tt.in, 53)
+		require.NoError(t, err, tt.in)
+		require.Equal(t, tt.expected, target, tt.in)
+	}
+	for _, in := range []string{"", "=2", "192.0.2.1=0", "192.0.2.1=a", "192.0.2.1:0", "192.0.2.1:dns"} {
+		_, err := ParseTarget(in, 53)
+		require.Error(t, err, in)
+	}
+	require.Equal(t, "[::1]:8053", Target{Host: "::1", Port: 8053}.String())
+}
+
+func Test_ParseTargets(t *testing.T) {
+	targets, err := ParseTargets("192.0.2.1=3, 192.0.2.2:8053", 53)
+	require.NoError(t, err)
+	require.Equal(t, []Target{{Host: "192.0.2.1", Port: 53, Weight: 3}, {Host: "192.0.2.2", Port: 8053, Weight: 1}}, targets)
+
+	targets, err = ParseTargets("", 53)
+	require.NoError(t, err)
+	require.Empty(t, targets)
+
+	_, err = ParseTargets("192.0.2.1,192.0.2.2=-1", 53)
+	require.Error(t, err)
+}
+
+func Test_ReadTargetsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets")
+	require.NoError(t, os.WriteFile(path, []byte("# canary\n192.0.2.1=1\n\n192.0.2.2=9\n"), 0o600))
+	targets, err := ReadTargetsFile(path, 853)
+	require.NoError(t, err)
+	require.Equal(t, []Target{{Host: "192.0.2.1", Port: 853, Weight: 1}, {Host: "192.0.2.2", Port: 853, Weight: 9}}, targets)
+
+	_, err = ReadTargetsFile(filepath.Join(t.TempDir(), "missing"), 53)
+	require.Error(t, err)
+}
+
+func Test_targetPicker(t *testing.T) {
+	p := newTargetPicker([]Target{{Weight: 5}, {Weight: 1}, {Weight: 1}})
+	var picked []int
+	for i := 0; i < 7; i++ {
+		picked = append(picked, p.next())
+	}
+	// every run of 7 queries follows the weights, interleaved
+	require.Equal(t, []int{0, 0, 1, 0, 2, 0, 0}, picked)
+}
@@ -0,0 +1,487 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// Supported transport protocols
+const (
+	ProtocolUDP = "udp"
+	ProtocolTCP = "tcp"
+	ProtocolDoT = "dot"
+	ProtocolDoH = "doh"
+	ProtocolDoQ = "doq"
+)
+
+// DefaultDoHPath is the URL path DoH queries are sent to, as suggested by RFC 8484
+const DefaultDoHPath = "/dns-query"
+
+const dnsMessageMimeType = "application/dns-message"
+
+// Protocols lists the supported transport protocols
+var Protocols = []string{ProtocolUDP, ProtocolTCP, ProtocolDoT, ProtocolDoH, ProtocolDoQ}
+
+// ValidateProtocol returns an error if protocol is not supported
+func ValidateProtocol(protocol string) error {
+	for _, p := range Protocols {
+		if p == protocol {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid protocol %q, must be one of %s", protocol, strings.Join(Protocols, ", "))
+}
+
+// DefaultPort returns the well known port for protocol
+func DefaultPort(protocol string) int {
+	switch protocol {
+	case ProtocolDoT, ProtocolDoQ:
+		return 853
+	case ProtocolDoH:
+		return 443
+	default:
+		return 53
+	}
+}
+
+// TransportConfig describes how queries are sent to the target host
+type TransportConfig struct {
+	Protocol string
+	Host     string
+	Port     int
+	Timeout  time.Duration
+	// TLSConfig is used by the dot, doh and doq protocols
+	TLSConfig *tls.Config
+	// DoHPath is the URL path used by the doh protocol, DefaultDoHPath if empty
+	DoHPath string
+	// SourcePrefix spreads queries across the addresses of a prefix bound to
+	// the host: every connection, and every udp query with RandomSourcePort,
+	// is sent from an address of the prefix picked at random
+	SourcePrefix *net.IPNet
+	// RandomSourcePort sends every udp query from a new socket, on an
+	// ephemeral port picked by the kernel, rather than over one socket per
+	// connection. Other protocols get a new port per connection anyway.
+	RandomSourcePort bool
+	// RetryTCP retries udp queries over tcp when their response is
+	// truncated, as resolvers do, the latency of the query including the
+	// retry
+	RetryTCP bool
+}
+
+func (c TransportConfig) addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// sourceIP returns an address of SourcePrefix picked at random, nil if not
+// set. The network and broadcast addresses of IPv4 prefixes are avoided.
+func (c TransportConfig) sourceIP() net.IP {
+	prefix := c.SourcePrefix
+	if prefix == nil {
+		return nil
+	}
+	ones, bits := prefix.Mask.Size()
+	for {
+		ip := make(net.IP, len(prefix.IP))
+		zeros, allOnes := true, true
+		for i := range ip {
+			host := byte(rand.Intn(256)) &^ prefix.Mask[i]
+			ip[i] = prefix.IP[i]&prefix.Mask[i] | host
+			zeros = zeros && host == 0
+			allOnes = allOnes && host == ^prefix.Mask[i]
+		}
+		if bits != 8*net.IPv4len || bits-ones < 2 || (!zeros && !allOnes) {
+			return ip
+		}
+	}
+}
+
+// localAddr returns the address to send queries from over network, udp or
+// tcp, nil to let the kernel pick it
+func (c TransportConfig) localAddr(network string) net.Addr {
+	ip := c.sourceIP()
+	switch {
+	case ip == nil:
+		return nil
+	case network == "udp":
+		return &net.UDPAddr{IP: ip}
+	default:
+		return &net.TCPAddr{IP: ip}
+	}
+}
+
+// tlsConfig returns a copy of the configured TLS config advertising alpn
+func (c TransportConfig) tlsConfig(alpn ...string) *tls.Config {
+	conf := &tls.Config{}
+	if c.TLSConfig != nil {
+		conf = c.TLSConfig.Clone()
+	}
+	if len(alpn) > 0 {
+		conf.NextProtos = alpn
+	}
+	return conf
+}
+
+// TransportError is returned when a query could not be exchanged with the
+// target, as opposed to the target returning an invalid response
+type TransportError struct {
+	Protocol string
+	Err      error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Protocol, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Transport sends DNS messages to the target host over a single protocol.
+// Connections are kept open between queries and re-established after failures,
+// so that each Transport behaves as one pooled connection to the target.
+type Transport interface {
+	Exchange(*dns.Msg) (*dns.Msg, error)
+	Protocol() string
+	Close() error
+}
+
+// NewTransport creates a Transport for the protocol set in c
+func NewTransport(c TransportConfig) (Transport, error) {
+	if err := ValidateProtocol(c.Protocol); err != nil {
+		return nil, err
+	}
+	switch c.Protocol {
+	case ProtocolDoH:
+		return newDoHTransport(c), nil
+	case ProtocolDoQ:
+		return &doqTransport{config: c, tlsConfig: c.tlsConfig("doq")}, nil
+	default:
+		t := &dnsTransport{config: c, client: DNSClient(c.Timeout)}
+		switch c.Protocol {
+		case ProtocolTCP:
+			t.client.Net = "tcp"
+		case ProtocolDoT:
+			t.client.Net = "tcp-tls"
+			t.client.TLSConfig = c.tlsConfig("dot")
+		}
+		// dial early so that unreachable targets are reported straight away
+		if err := t.dial(); err != nil {
+			return nil, &TransportError{Protocol: c.Protocol, Err: err}
+		}
+		return t, nil
+	}
+}
+
+// TransportSendMsg sends DNS requests over t and checks the response from the DNS server
+func TransportSendMsg(t Transport, check ValidateResponse) SendMsg {
+	return func(request *dns.Msg) (*dns.Msg, error) {
+		resp, err := t.Exchange(request)
+		if err != nil {
+			log.Debugf("Connection failure: %v", err)
+			return nil, &TransportError{Protocol: t.Protocol(), Err: err}
+		}
+		// the invalid response is still returned so that its rcode can be accounted for
+		if err = check(resp); err != nil {
+			log.Debugf("Response received not valid: %v", err)
+			return resp, err
+		}
+		return resp, nil
+	}
+}
+
+// withTCPRetry re-sends the requests whose response is truncated over retry,
+// calling retried for every one of them
+func withTCPRetry(send, retry SendMsg, retried func()) SendMsg {
+	return func(request *dns.Msg) (*dns.Msg, error) {
+		resp, err := send(request)
+		if resp == nil || !resp.Truncated {
+			return resp, err
+		}
+		retried()
+		return retry(request)
+	}
+}
+
+// dnsTransport implements the udp, tcp and dot protocols
+type dnsTransport struct {
+	config TransportConfig
+	client *dns.Client
+	conn   *dns.Conn
+}
+
+func (t *dnsTransport) dial() error {
+	if t.config.SourcePrefix != nil {
+		network := "tcp"
+		if t.config.Protocol == ProtocolUDP {
+			network = "udp"
+		}
+		t.client.Dialer = &net.Dialer{Timeout: t.config.Timeout, LocalAddr: t.config.localAddr(network)}
+	}
+	conn, err := t.client.Dial(t.config.addr())
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	return nil
+}
+
+func (t *dnsTransport) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	if t.conn == nil {
+		if err := t.dial(); err != nil {
+			return nil, err
+		}
+	}
+	resp, _, err := t.client.ExchangeWithConn(m, t.conn)
+	// a failed exchange may leave a stream out of sync, start over, and
+	// every udp query gets its own socket with random source ports
+	if (err != nil && t.config.Protocol != ProtocolUDP) || (t.config.Protocol == ProtocolUDP && t.config.RandomSourcePort) {
+		t.conn.Close()
+		t.conn = nil
+	}
+	return resp, err
+}
+
+func (t *dnsTransport) Protocol() string {
+	return t.config.Protocol
+}
+
+func (t *dnsTransport) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+// dohTransport implements DNS over HTTPS (RFC 8484) using POST requests
+type dohTransport struct {
+	config TransportConfig
+	url    string
+	client *http.Client
+}
+
+func newDoHTransport(c TransportConfig) *dohTransport {
+	path := c.DoHPath
+	if path == "" {
+		path = DefaultDoHPath
+	}
+	u := url.URL{Scheme: "https", Host: c.addr(), Path: path}
+	transport := &http.Transport{
+		TLSClientConfig:   c.tlsConfig(),
+		ForceAttemptHTTP2: true,
+		IdleConnTimeout:   90 * time.Second,
+	}
+	if c.SourcePrefix != nil {
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d := &net.Dialer{Timeout: c.Timeout, LocalAddr: c.localAddr("tcp")}
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	return &dohTransport{
+		config: c,
+		url:    u.String(),
+		client: &http.Client{
+			Timeout:   c.Timeout,
+			Transport: transport,
+		},
+	}
+}
+
+func (t *dohTransport) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	// RFC 8484 recommends ID 0 to make responses cache friendly
+	id := m.Id
+	m.Id = 0
+	buf, err := m.Pack()
+	m.Id = id
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessageMimeType)
+	req.Header.Set("Accept", dnsMessageMimeType)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// drain the body so that the connection can be reused
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	r := new(dns.Msg)
+	if err = r.Unpack(body); err != nil {
+		return nil, err
+	}
+	r.Id = id
+	return r, nil
+}
+
+func (t *dohTransport) Protocol() string {
+	return ProtocolDoH
+}
+
+func (t *dohTransport) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
+
+// doqTransport implements DNS over QUIC (RFC 9250), one stream per query
+type doqTransport struct {
+	config    TransportConfig
+	tlsConfig *tls.Config
+	conn      quic.Connection
+	// packetConn is the socket bound to a source address, if any, which
+	// quic-go leaves open when closing conn
+	packetConn net.PacketConn
+}
+
+func (t *doqTransport) dial(ctx context.Context) error {
+	quicConfig := &quic.Config{
+		HandshakeIdleTimeout: t.config.Timeout,
+		KeepAlivePeriod:      t.config.Timeout,
+	}
+	if t.config.SourcePrefix == nil {
+		conn, err := quic.DialAddr(ctx, t.config.addr(), t.tlsConfig, quicConfig)
+		if err != nil {
+			return err
+		}
+		t.conn = conn
+		return nil
+	}
+	raddr, err := net.ResolveUDPAddr("udp", t.config.addr())
+	if err != nil {
+		return err
+	}
+	packetConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: t.config.sourceIP()})
+	if err != nil {
+		return err
+	}
+	conn, err := quic.Dial(ctx, packetConn, raddr, t.tlsConfig, quicConfig)
+	if err != nil {
+		packetConn.Close()
+		return err
+	}
+	t.conn, t.packetConn = conn, packetConn
+	return nil
+}
+
+// closeConn closes the connection and its socket
+func (t *doqTransport) closeConn() error {
+	err := t.conn.CloseWithError(0, "")
+	t.conn = nil
+	if t.packetConn != nil {
+		t.packetConn.Close()
+		t.packetConn = nil
+	}
+	return err
+}
+
+func (t *doqTransport) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.config.Timeout)
+	defer cancel()
+	if t.conn == nil {
+		if err := t.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := t.exchange(ctx, m)
+	if err != nil {
+		// the connection may have been closed by the server, redial on next query
+		_ = t.closeConn()
+	}
+	return resp, err
+}
+
+func (t *doqTransport) exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	// RFC 9250 mandates ID 0, streams identify queries
+	id := m.Id
+	m.Id = 0
+	buf, err := m.Pack()
+	m.Id = id
+	if err != nil {
+		return nil, err
+	}
+	stream, err := t.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+	out := make([]byte, 2+len(buf))
+	binary.BigEndian.PutUint16(out, uint16(len(buf)))
+	copy(out[2:], buf)
+	if _, err = stream.Write(out); err != nil {
+		stream.CancelRead(0)
+		return nil, err
+	}
+	// the client must indicate it won't send more data on the stream
+	if err = stream.Close(); err != nil {
+		stream.CancelRead(0)
+		return nil, err
+	}
+	var l uint16
+	if err = binary.Read(stream, binary.BigEndian, &l); err != nil {
+		stream.CancelRead(0)
+		return nil, err
+	}
+	in := make([]byte, l)
+	if _, err = io.ReadFull(stream, in); err != nil {
+		stream.CancelRead(0)
+		return nil, err
+	}
+	r := new(dns.Msg)
+	if err = r.Unpack(in); err != nil {
+		return nil, err
+	}
+	r.Id = id
+	return r, nil
+}
+
+func (t *doqTransport) Protocol() string {
+	return ProtocolDoQ
+}
+
+func (t *doqTransport) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+	return t.closeConn()
+}
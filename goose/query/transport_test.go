@@ -0,0 +1,359 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/facebook/dns/goose/stats"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/ratelimit"
+)
+
+// answer answers req, with the address the query came from in a TXT record
+// of the additional section
+func answer(req *dns.Msg, from string) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Answer = []dns.RR{A(req.Question[0].Name + " 60 IN A 192.0.2.1")}
+	resp.Extra = []dns.RR{&dns.TXT{Hdr: dns.RR_Header{Name: "from.", Rrtype: dns.TypeTXT, Class: dns.ClassINET}, Txt: []string{from}}}
+	return resp
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "goose"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func hostPort(t *testing.T, addr string) (string, int) {
+	host, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	return host, p
+}
+
+// startDNSServer starts a miekg/dns server on net and returns its address
+func startDNSServer(t *testing.T, network string) string {
+	server := &dns.Server{
+		Net: network,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			_ = w.WriteMsg(answer(req, w.RemoteAddr().String()))
+		}),
+	}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	switch network {
+	case "udp":
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		server.PacketConn = pc
+	case "tcp":
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		server.Listener = l
+	case "tcp-tls":
+		l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}})
+		require.NoError(t, err)
+		server.Listener = l
+	}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+	if server.PacketConn != nil {
+		return server.PacketConn.LocalAddr().String()
+	}
+	return server.Listener.Addr().String()
+}
+
+func startDoHServer(t *testing.T) string {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != DefaultDoHPath || r.Header.Get("Content-Type") != dnsMessageMimeType {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		req := new(dns.Msg)
+		require.NoError(t, req.Unpack(body))
+		require.Equal(t, uint16(0), req.Id)
+		buf, err := answer(req, r.RemoteAddr).Pack()
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", dnsMessageMimeType)
+		_, _ = w.Write(buf)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+func startDoQServer(t *testing.T) string {
+	l, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t)},
+		NextProtos:   []string{"doq"},
+	}, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					stream, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					var length uint16
+					if err := binary.Read(stream, binary.BigEndian, &length); err != nil {
+						return
+					}
+					buf := make([]byte, length)
+					if _, err := io.ReadFull(stream, buf); err != nil {
+						return
+					}
+					req := new(dns.Msg)
+					if err := req.Unpack(buf); err != nil || req.Id != 0 {
+						return
+					}
+					out, err := answer(req, conn.RemoteAddr().String()).Pack()
+					if err != nil {
+						return
+					}
+					_ = binary.Write(stream, binary.BigEndian, uint16(len(out)))
+					_, _ = stream.Write(out)
+					stream.Close()
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func Test_ValidateProtocol(t *testing.T) {
+	for _, p := range Protocols {
+		require.NoError(t, ValidateProtocol(p))
+	}
+	require.Error(t, ValidateProtocol("derp"))
+	require.Equal(t, 53, DefaultPort(ProtocolTCP))
+	require.Equal(t, 853, DefaultPort(ProtocolDoT))
+	require.Equal(t, 443, DefaultPort(ProtocolDoH))
+	require.Equal(t, 853, DefaultPort(ProtocolDoQ))
+}
+
+func Test_Transports(t *testing.T) {
+	testCases := []struct {
+		protocol string
+		start    func(t *testing.T) string
+	}{
+		{protocol: ProtocolUDP, start: func(t *testing.T) string { return startDNSServer(t, "udp") }},
+		{protocol: ProtocolTCP, start: func(t *testing.T) string { return startDNSServer(t, "tcp") }},
+		{protocol: ProtocolDoT, start: func(t *testing.T) string { return startDNSServer(t, "tcp-tls") }},
+		{protocol: ProtocolDoH, start: startDoHServer},
+		{protocol: ProtocolDoQ, start: startDoQServer},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.protocol, func(t *testing.T) {
+			host, port := hostPort(t, tc.start(t))
+			transport, err := NewTransport(TransportConfig{
+				Protocol:  tc.protocol,
+				Host:      host,
+				Port:      port,
+				Timeout:   time.Second,
+				TLSConfig: &tls.Config{InsecureSkipVerify: true}, // #nosec G402
+			})
+			require.NoError(t, err)
+			defer transport.Close()
+			require.Equal(t, tc.protocol, transport.Protocol())
+
+			send := TransportSendMsg(transport, CheckResponse)
+			// several queries exercise connection reuse
+			for i := 0; i < 3; i++ {
+				req := MakeReq("example.com", time.Now, true, dns.Type(dns.TypeA))
+				resp, err := send(req)
+				require.NoError(t, err)
+				require.Equal(t, req.Id, resp.Id)
+				require.Equal(t, req.Question[0].Name, resp.Answer[0].Header().Name)
+			}
+		})
+	}
+}
+
+func Test_TransportSourceSpreading(t *testing.T) {
+	// the whole 127.0.0.0/8 is bound to the loopback interface
+	_, prefix, err := net.ParseCIDR("127.0.0.8/29")
+	require.NoError(t, err)
+	testCases := []struct {
+		protocol string
+		start    func(t *testing.T) string
+		// whether every query gets a new source address and port
+		perQuery bool
+	}{
+		{protocol: ProtocolUDP, start: func(t *testing.T) string { return startDNSServer(t, "udp") }, perQuery: true},
+		{protocol: ProtocolTCP, start: func(t *testing.T) string { return startDNSServer(t, "tcp") }},
+		{protocol: ProtocolDoT, start: func(t *testing.T) string { return startDNSServer(t, "tcp-tls") }},
+		{protocol: ProtocolDoH, start: startDoHServer},
+		{protocol: ProtocolDoQ, start: startDoQServer},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.protocol, func(t *testing.T) {
+			host, port := hostPort(t, tc.start(t))
+			transport, err := NewTransport(TransportConfig{
+				Protocol:         tc.protocol,
+				Host:             host,
+				Port:             port,
+				Timeout:          time.Second,
+				TLSConfig:        &tls.Config{InsecureSkipVerify: true}, // #nosec G402
+				SourcePrefix:     prefix,
+				RandomSourcePort: true,
+			})
+			require.NoError(t, err)
+			defer transport.Close()
+
+			send := TransportSendMsg(transport, CheckResponse)
+			sources := make(map[string]bool)
+			for i := 0; i < 20; i++ {
+				resp, err := send(MakeReq("example.com", time.Now, true, dns.Type(dns.TypeA)))
+				require.NoError(t, err)
+				from := resp.Extra[0].(*dns.TXT).Txt[0]
+				ip, _ := hostPort(t, from)
+				require.True(t, prefix.Contains(net.ParseIP(ip)), from)
+				require.NotContains(t, []string{"127.0.0.8", "127.0.0.15"}, ip)
+				sources[from] = true
+			}
+			if tc.perQuery {
+				require.Greater(t, len(sources), 1)
+			} else {
+				require.Len(t, sources, 1)
+			}
+		})
+	}
+}
+
+func Test_RunQueriesConnErrors(t *testing.T) {
+	host, port := hostPort(t, startDoHServer(t))
+	runState := NewRunState(3, ratelimit.NewUnlimited(), false, time.Now)
+	conf := TransportConfig{
+		Protocol: ProtocolDoH,
+		Host:     host,
+		Port:     port,
+		Timeout:  time.Second,
+		// the test server certificate isn't trusted
+		TLSConfig: &tls.Config{},
+	}
+	err := RunQueries(conf, nil, EDNSConfig{}, []string{"example.com"}, false, []dns.Type{dns.Type(dns.TypeA)}, time.Now, runState, make(chan struct{}))
+	require.NoError(t, err)
+	results := runState.ExportResults()
+	require.Equal(t, ProtocolDoH, results.Protocol)
+	require.Equal(t, 0, results.Processed)
+	require.Equal(t, 3, results.Errors)
+	require.Equal(t, 3, results.ConnErrors)
+}
+
+func Test_RunQueriesTargets(t *testing.T) {
+	host1, port1 := hostPort(t, startDNSServer(t, "udp"))
+	host2, port2 := hostPort(t, startDNSServer(t, "udp"))
+	runState := NewRunState(40, ratelimit.NewUnlimited(), false, time.Now)
+	conf := TransportConfig{Protocol: ProtocolUDP, Timeout: time.Second}
+	targets := []Target{{Host: host1, Port: port1, Weight: 3}, {Host: host2, Port: port2, Weight: 1}}
+	err := RunQueries(conf, targets, EDNSConfig{}, []string{"example.com"}, false, []dns.Type{dns.Type(dns.TypeA)}, time.Now, runState, make(chan struct{}))
+	require.NoError(t, err)
+	results := runState.ExportResults()
+	require.Equal(t, 40, results.Processed+results.Errors)
+	require.Len(t, results.Targets, 2)
+	first, second := results.Targets[targets[0].String()], results.Targets[targets[1].String()]
+	require.Equal(t, 30, first.Processed+first.Errors)
+	require.Equal(t, 10, second.Processed+second.Errors)
+	require.Len(t, first.Latencies, 30)
+}
+
+// startTruncatingServer starts a server truncating the responses over udp,
+// and answering them over tcp on the same port, and returns its address
+func startTruncatingServer(t *testing.T) string {
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := answer(req, w.RemoteAddr().String())
+		if w.RemoteAddr().Network() == "udp" {
+			resp.Answer, resp.Extra = nil, nil
+			resp.Truncated = true
+		}
+		_ = w.WriteMsg(resp)
+	})
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	for _, server := range []*dns.Server{{PacketConn: pc, Handler: handler}, {Listener: l, Handler: handler}} {
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go func() {
+			_ = server.ActivateAndServe()
+		}()
+		<-started
+		t.Cleanup(func() { _ = server.Shutdown() })
+	}
+	return pc.LocalAddr().String()
+}
+
+func Test_RunQueriesTCPRetry(t *testing.T) {
+	host, port := hostPort(t, startTruncatingServer(t))
+	for _, retry := range []bool{false, true} {
+		runState := NewRunState(10, ratelimit.NewUnlimited(), false, time.Now)
+		conf := TransportConfig{Protocol: ProtocolUDP, Host: host, Port: port, Timeout: time.Second, RetryTCP: retry}
+		err := RunQueries(conf, nil, EDNSConfig{}, []string{"example.com"}, false, []dns.Type{dns.Type(dns.TypeA)}, time.Now, runState, make(chan struct{}))
+		require.NoError(t, err)
+		results := runState.ExportResults()
+		if retry {
+			require.Equal(t, 10, results.Processed)
+			require.Equal(t, 10, results.TCPRetries)
+			require.Equal(t, map[string]stats.Outcome{stats.ResponseAnswer: {Processed: 10}}, results.Responses)
+		} else {
+			require.Equal(t, 10, results.Errors)
+			require.Equal(t, 0, results.TCPRetries)
+			require.Equal(t, map[string]stats.Outcome{stats.ResponseTruncated: {Errors: 10}}, results.Responses)
+		}
+	}
+}
@@ -15,6 +15,7 @@ package report
 
 import (
 	"encoding/json"
+	"io"
 	"os"
 	"time"
 
@@ -27,10 +28,27 @@ type JSONStatsReporter struct{}
 type jsonPrintableMetrics struct {
 	//Elapsed is the elapsed time duration
 	Elapsed time.Duration
+	// Protocol is the transport protocol queries were sent over.
+	Protocol string
+	// TargetQPS is the QPS targeted by the load profile.
+	TargetQPS int
+	// GoodputQPS is the goodput measured by the backoff profile.
+	GoodputQPS int `json:",omitempty"`
 	// processed is the number of queries successfully processed.
 	Processed int
 	// errors is the number of queries that failed.
-	Errors  int
+	Errors int
+	// ConnErrors is the number of queries that could not be exchanged with the target.
+	ConnErrors int
+	// QTypes and Rcodes break down queries by query type and response code.
+	QTypes map[string]stats.Outcome `json:",omitempty"`
+	Rcodes map[string]stats.Outcome `json:",omitempty"`
+	// Responses breaks down queries by class of response.
+	Responses map[string]stats.Outcome `json:",omitempty"`
+	// TCPRetries is the number of queries retried over TCP after a truncated response.
+	TCPRetries int `json:",omitempty"`
+	// Targets breaks down queries by target, when sent to several.
+	Targets map[string]jsonPrintableTarget `json:",omitempty"`
 	Min     float64
 	Max     float64
 	Mean    float64
@@ -40,6 +58,20 @@ type jsonPrintableMetrics struct {
 	Average float64
 }
 
+// jsonPrintableTarget is the breakdown of queries sent to a target
+type jsonPrintableTarget struct {
+	Processed int
+	Errors    int
+	Rcodes    map[string]stats.Outcome `json:",omitempty"`
+	Min       float64
+	Max       float64
+	Mean      float64
+	Median    float64
+	Lowerq    float64
+	Upperq    float64
+	Average   float64
+}
+
 // Initialize does nothing, just to meet the interface requirements
 func (r *JSONStatsReporter) Initialize() error {
 	return nil
@@ -47,17 +79,55 @@ func (r *JSONStatsReporter) Initialize() error {
 
 // ReportMetrics sends metric to stdout as json
 func (r *JSONStatsReporter) ReportMetrics(exportedMetrics *stats.ExportedMetrics) error {
+	return WriteJSON(os.Stdout, exportedMetrics)
+}
+
+// WriteJSON writes the metrics to w in the same json format as JSONStatsReporter
+func WriteJSON(w io.Writer, exportedMetrics *stats.ExportedMetrics) error {
 	aggregatedLatencyStats := exportedMetrics.AggregateLatencies()
-	return json.NewEncoder(os.Stdout).Encode(jsonPrintableMetrics{
-		Elapsed:   exportedMetrics.Elapsed,
-		Processed: exportedMetrics.Processed,
-		Errors:    exportedMetrics.Errors,
-		Min:       aggregatedLatencyStats.Min,
-		Max:       aggregatedLatencyStats.Max,
-		Mean:      aggregatedLatencyStats.Mean,
-		Median:    aggregatedLatencyStats.Median,
-		Lowerq:    aggregatedLatencyStats.Lowerq,
-		Upperq:    aggregatedLatencyStats.Upperq,
-		Average:   aggregatedLatencyStats.Average,
+	return json.NewEncoder(w).Encode(jsonPrintableMetrics{
+		Elapsed:    exportedMetrics.Elapsed,
+		Protocol:   exportedMetrics.Protocol,
+		TargetQPS:  exportedMetrics.TargetQPS,
+		GoodputQPS: exportedMetrics.GoodputQPS,
+		Processed:  exportedMetrics.Processed,
+		Errors:     exportedMetrics.Errors,
+		ConnErrors: exportedMetrics.ConnErrors,
+		QTypes:     exportedMetrics.QTypes,
+		Rcodes:     exportedMetrics.Rcodes,
+		Responses:  exportedMetrics.Responses,
+		TCPRetries: exportedMetrics.TCPRetries,
+		Targets:    jsonTargets(exportedMetrics.Targets),
+		Min:        aggregatedLatencyStats.Min,
+		Max:        aggregatedLatencyStats.Max,
+		Mean:       aggregatedLatencyStats.Mean,
+		Median:     aggregatedLatencyStats.Median,
+		Lowerq:     aggregatedLatencyStats.Lowerq,
+		Upperq:     aggregatedLatencyStats.Upperq,
+		Average:    aggregatedLatencyStats.Average,
 	})
 }
+
+// jsonTargets aggregates the latencies of every target, nil without targets
+func jsonTargets(targets map[string]stats.TargetOutcome) map[string]jsonPrintableTarget {
+	if len(targets) == 0 {
+		return nil
+	}
+	printable := make(map[string]jsonPrintableTarget, len(targets))
+	for name, t := range targets {
+		l := t.AggregateLatencies()
+		printable[name] = jsonPrintableTarget{
+			Processed: t.Processed,
+			Errors:    t.Errors,
+			Rcodes:    t.Rcodes,
+			Min:       l.Min,
+			Max:       l.Max,
+			Mean:      l.Mean,
+			Median:    l.Median,
+			Lowerq:    l.Lowerq,
+			Upperq:    l.Upperq,
+			Average:   l.Average,
+		}
+	}
+	return printable
+}
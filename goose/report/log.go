@@ -14,6 +14,9 @@ limitations under the License.
 package report
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/facebook/dns/goose/stats"
 
 	log "github.com/sirupsen/logrus"
@@ -35,7 +38,50 @@ func (r *LogStatsReporter) ReportMetrics(exportedMetrics *stats.ExportedMetrics)
 		exportedMetrics.Processed, exportedMetrics.Errors, toTime(aggregatedLatencyStats.Max), toTime(aggregatedLatencyStats.Min), toTime(aggregatedLatencyStats.Mean),
 		toTime(aggregatedLatencyStats.Median), toTime(aggregatedLatencyStats.Upperq), toTime(aggregatedLatencyStats.Lowerq),
 	)
-	log.Infof("Requests: Successful: %v Failed: %v", exportedMetrics.Processed, exportedMetrics.Errors)
+	log.Infof("Requests: Successful: %v Failed: %v Connection failures: %v", exportedMetrics.Processed, exportedMetrics.Errors, exportedMetrics.ConnErrors)
+	if exportedMetrics.Protocol != "" {
+		log.Infof("Protocol: %v", exportedMetrics.Protocol)
+	}
+	logOutcomes("Query type", exportedMetrics.QTypes)
+	logOutcomes("Response code", exportedMetrics.Rcodes)
+	logOutcomes("Response class", exportedMetrics.Responses)
+	if exportedMetrics.TCPRetries > 0 {
+		log.Infof("Retried over TCP: %v", exportedMetrics.TCPRetries)
+	}
+	logTargets(exportedMetrics.Targets)
 	log.Infof("Elapsed: %v", exportedMetrics.Elapsed)
+	if exportedMetrics.TargetQPS > 0 {
+		log.Infof("QPS: %.2f Target: %v", exportedMetrics.QPSTotal(), exportedMetrics.TargetQPS)
+	}
+	if exportedMetrics.GoodputQPS > 0 {
+		log.Infof("Goodput: %v QPS", exportedMetrics.GoodputQPS)
+	}
 	return nil
 }
+
+// logTargets logs the breakdown of queries by target, sorted by target
+func logTargets(targets map[string]stats.TargetOutcome) {
+	keys := make([]string, 0, len(targets))
+	for k := range targets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		t := targets[k]
+		l := t.AggregateLatencies()
+		log.Infof("Target %s: Successful: %v Failed: %v Median: %v Upper Quartile: %v Max: %v", k, t.Processed, t.Errors, toTime(l.Median), toTime(l.Upperq), toTime(l.Max))
+		logOutcomes(fmt.Sprintf("Target %s response code", k), t.Rcodes)
+	}
+}
+
+// logOutcomes logs a breakdown of queries, sorted by key
+func logOutcomes(name string, outcomes map[string]stats.Outcome) {
+	keys := make([]string, 0, len(outcomes))
+	for k := range outcomes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		log.Infof("%s %s: Successful: %v Failed: %v", name, k, outcomes[k].Processed, outcomes[k].Errors)
+	}
+}
@@ -14,8 +14,10 @@ limitations under the License.
 package report
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/facebook/dns/goose/stats"
 
@@ -24,65 +26,168 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// protocolLabels labels every metric with the transport protocol of the test
+var protocolLabels = []string{"protocol"}
+
 // PrometheusMetricsReporter contains the struct for the PrometheusMetricsReporter
 type PrometheusMetricsReporter struct {
-	Addr               string
+	Addr string
+	// LatencyBuckets and JitterBuckets are the upper bounds of the buckets of
+	// the latency and inter-send jitter histograms, in microseconds, see
+	// ParseDurationBuckets. DefaultLatencyBuckets and DefaultJitterBuckets if
+	// empty.
+	LatencyBuckets []float64
+	JitterBuckets  []float64
+	// NativeHistogramFactor, if above 1, also exports the histograms as
+	// native histograms, with buckets growing by this factor
+	NativeHistogramFactor float64
+
 	registry           *prometheus.Registry
-	successGauge       prometheus.Gauge
-	failedGauge        prometheus.Gauge
-	maxLatancyGauge    prometheus.Gauge
-	meanLatencyGauge   prometheus.Gauge
-	medianLatencyGauge prometheus.Gauge
-	minLatencyGauge    prometheus.Gauge
-	avgLatencyGauge    prometheus.Gauge
+	successGauge       *prometheus.GaugeVec
+	failedGauge        *prometheus.GaugeVec
+	connFailedGauge    *prometheus.GaugeVec
+	maxLatancyGauge    *prometheus.GaugeVec
+	meanLatencyGauge   *prometheus.GaugeVec
+	medianLatencyGauge *prometheus.GaugeVec
+	minLatencyGauge    *prometheus.GaugeVec
+	avgLatencyGauge    *prometheus.GaugeVec
+	targetQPSGauge     *prometheus.GaugeVec
+	goodputQPSGauge    *prometheus.GaugeVec
+	qtypeGauge         *prometheus.GaugeVec
+	rcodeGauge         *prometheus.GaugeVec
+	responseGauge      *prometheus.GaugeVec
+	tcpRetriesGauge    *prometheus.GaugeVec
+	latencyHistogram   *prometheus.HistogramVec
+	jitterHistogram    *prometheus.HistogramVec
+	targetGauge        *prometheus.GaugeVec
+	targetRcodeGauge   *prometheus.GaugeVec
+	targetHistogram    *prometheus.HistogramVec
 }
 
 // Initialize sets up  and starts the prometheus http server
 func (r *PrometheusMetricsReporter) Initialize() error {
 	r.registry = prometheus.NewRegistry()
-	r.successGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	r.successGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "dns_goose",
 		Name:      flattenKey(successes),
 		Help:      "Number of successful queries sent",
-	})
-	r.failedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	}, protocolLabels)
+	r.failedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "dns_goose",
 		Name:      flattenKey(errors),
 		Help:      "Number of failed queries sent",
-	})
-	r.maxLatancyGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	}, protocolLabels)
+	r.connFailedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dns_goose",
+		Name:      flattenKey(connErrors),
+		Help:      "Number of queries which could not be exchanged with the target",
+	}, protocolLabels)
+	r.maxLatancyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "dns_goose",
 		Name:      flattenKey(latencyMax),
 		Help:      "Max query latency in microseconds",
-	})
-	r.meanLatencyGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	}, protocolLabels)
+	r.meanLatencyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "dns_goose",
 		Name:      flattenKey(latencyMean),
 		Help:      "Mean query latency in microseconds",
-	})
-	r.medianLatencyGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	}, protocolLabels)
+	r.medianLatencyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "dns_goose",
 		Name:      flattenKey(latencyMedian),
 		Help:      "Median query latency in microseconds",
-	})
-	r.minLatencyGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	}, protocolLabels)
+	r.minLatencyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "dns_goose",
 		Name:      flattenKey(latencyMin),
 		Help:      "Min query latency in microseconds",
-	})
-	r.avgLatencyGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	}, protocolLabels)
+	r.avgLatencyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "dns_goose",
 		Name:      flattenKey(latencyAvg),
 		Help:      "Average query latency in microseconds",
-	})
+	}, protocolLabels)
+	r.targetQPSGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dns_goose",
+		Name:      flattenKey(targetQPS),
+		Help:      "QPS targeted by the load profile",
+	}, protocolLabels)
+	r.goodputQPSGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dns_goose",
+		Name:      flattenKey(goodputQPS),
+		Help:      "Rate of queries answered without SERVFAIL sustained by the target, measured by the backoff profile",
+	}, protocolLabels)
+	r.qtypeGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dns_goose",
+		Name:      flattenKey(qtypeQueries),
+		Help:      "Number of queries sent by query type and result",
+	}, []string{"protocol", "qtype", "result"})
+	r.rcodeGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dns_goose",
+		Name:      flattenKey(rcodeQueries),
+		Help:      "Number of queries sent by response code and result",
+	}, []string{"protocol", "rcode", "result"})
+	r.responseGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dns_goose",
+		Name:      flattenKey(classQueries),
+		Help:      "Number of queries sent by class of response and result",
+	}, []string{"protocol", "class", "result"})
+	r.tcpRetriesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dns_goose",
+		Name:      flattenKey(tcpRetries),
+		Help:      "Number of queries retried over TCP after a truncated response",
+	}, protocolLabels)
+	r.targetGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dns_goose",
+		Name:      flattenKey(targetQueries),
+		Help:      "Number of queries sent by target and result, when sent to several targets",
+	}, []string{"protocol", "target", "result"})
+	r.targetRcodeGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dns_goose",
+		Name:      flattenKey(targetRcodes),
+		Help:      "Number of queries sent by target, response code and result, when sent to several targets",
+	}, []string{"protocol", "target", "rcode", "result"})
+	r.targetHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                   "dns_goose",
+		Name:                        flattenKey(targetLatency),
+		Help:                        "Query latency by target in microseconds, when sent to several targets",
+		Buckets:                     bucketsOrDefault(r.LatencyBuckets, DefaultLatencyBuckets),
+		NativeHistogramBucketFactor: r.NativeHistogramFactor,
+	}, []string{"protocol", "target"})
+	r.latencyHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                   "dns_goose",
+		Name:                        flattenKey(latencyHist),
+		Help:                        "Query latency in microseconds",
+		Buckets:                     bucketsOrDefault(r.LatencyBuckets, DefaultLatencyBuckets),
+		NativeHistogramBucketFactor: r.NativeHistogramFactor,
+	}, protocolLabels)
+	r.jitterHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                   "dns_goose",
+		Name:                        flattenKey(jitterHist),
+		Help:                        "Difference between the time between consecutive queries and the interval of the target QPS, in microseconds",
+		Buckets:                     bucketsOrDefault(r.JitterBuckets, DefaultJitterBuckets),
+		NativeHistogramBucketFactor: r.NativeHistogramFactor,
+	}, protocolLabels)
 
 	r.registry.MustRegister(r.successGauge)
 	r.registry.MustRegister(r.failedGauge)
+	r.registry.MustRegister(r.connFailedGauge)
 	r.registry.MustRegister(r.maxLatancyGauge)
 	r.registry.MustRegister(r.meanLatencyGauge)
 	r.registry.MustRegister(r.medianLatencyGauge)
 	r.registry.MustRegister(r.minLatencyGauge)
 	r.registry.MustRegister(r.avgLatencyGauge)
+	r.registry.MustRegister(r.targetQPSGauge)
+	r.registry.MustRegister(r.goodputQPSGauge)
+	r.registry.MustRegister(r.qtypeGauge)
+	r.registry.MustRegister(r.rcodeGauge)
+	r.registry.MustRegister(r.responseGauge)
+	r.registry.MustRegister(r.tcpRetriesGauge)
+	r.registry.MustRegister(r.latencyHistogram)
+	r.registry.MustRegister(r.jitterHistogram)
+	r.registry.MustRegister(r.targetGauge)
+	r.registry.MustRegister(r.targetRcodeGauge)
+	r.registry.MustRegister(r.targetHistogram)
 
 	log.Infof("Starting prometheus metrics server at %q\n", r.Addr)
 	http.Handle("/metrics", promhttp.HandlerFor(
@@ -98,15 +203,96 @@ func (r *PrometheusMetricsReporter) Initialize() error {
 // ReportMetrics registers the metrics in fb303 for collection
 func (r *PrometheusMetricsReporter) ReportMetrics(exportedMetrics *stats.ExportedMetrics) error {
 	aggregatedLatencyStats := exportedMetrics.AggregateLatencies()
-	r.successGauge.Set(float64(exportedMetrics.Processed))
-	r.failedGauge.Set(float64(exportedMetrics.Errors))
-	r.avgLatencyGauge.Set(float64(toMicro(aggregatedLatencyStats.Average)))
-	r.maxLatancyGauge.Set(float64(toMicro(aggregatedLatencyStats.Max)))
-	r.meanLatencyGauge.Set(float64(toMicro(aggregatedLatencyStats.Mean)))
-	r.medianLatencyGauge.Set(float64(toMicro(aggregatedLatencyStats.Median)))
-	r.minLatencyGauge.Set(float64(toMicro(aggregatedLatencyStats.Min)))
+	r.successGauge.WithLabelValues(exportedMetrics.Protocol).Set(float64(exportedMetrics.Processed))
+	r.failedGauge.WithLabelValues(exportedMetrics.Protocol).Set(float64(exportedMetrics.Errors))
+	r.connFailedGauge.WithLabelValues(exportedMetrics.Protocol).Set(float64(exportedMetrics.ConnErrors))
+	r.avgLatencyGauge.WithLabelValues(exportedMetrics.Protocol).Set(float64(toMicro(aggregatedLatencyStats.Average)))
+	r.maxLatancyGauge.WithLabelValues(exportedMetrics.Protocol).Set(float64(toMicro(aggregatedLatencyStats.Max)))
+	r.meanLatencyGauge.WithLabelValues(exportedMetrics.Protocol).Set(float64(toMicro(aggregatedLatencyStats.Mean)))
+	r.medianLatencyGauge.WithLabelValues(exportedMetrics.Protocol).Set(float64(toMicro(aggregatedLatencyStats.Median)))
+	r.minLatencyGauge.WithLabelValues(exportedMetrics.Protocol).Set(float64(toMicro(aggregatedLatencyStats.Min)))
+	r.targetQPSGauge.WithLabelValues(exportedMetrics.Protocol).Set(float64(exportedMetrics.TargetQPS))
+	r.goodputQPSGauge.WithLabelValues(exportedMetrics.Protocol).Set(float64(exportedMetrics.GoodputQPS))
+	setOutcomes(r.qtypeGauge, exportedMetrics.Protocol, exportedMetrics.QTypes)
+	setOutcomes(r.rcodeGauge, exportedMetrics.Protocol, exportedMetrics.Rcodes)
+	setOutcomes(r.responseGauge, exportedMetrics.Protocol, exportedMetrics.Responses)
+	r.tcpRetriesGauge.WithLabelValues(exportedMetrics.Protocol).Set(float64(exportedMetrics.TCPRetries))
+	// every query is observed once, reports only carry the queries since the
+	// previous one in daemon mode
+	observeMicro(r.latencyHistogram.WithLabelValues(exportedMetrics.Protocol), exportedMetrics.Latencies)
+	observeMicro(r.jitterHistogram.WithLabelValues(exportedMetrics.Protocol), exportedMetrics.Jitters)
+	r.setTargets(exportedMetrics.Protocol, exportedMetrics.Targets)
 	return nil
 }
+
+// setTargets replaces the breakdown by target like setOutcomes, and observes
+// the latency of every target
+func (r *PrometheusMetricsReporter) setTargets(protocol string, targets map[string]stats.TargetOutcome) {
+	r.targetGauge.Reset()
+	r.targetRcodeGauge.Reset()
+	for name, t := range targets {
+		r.targetGauge.WithLabelValues(protocol, name, resultSuccess).Set(float64(t.Processed))
+		r.targetGauge.WithLabelValues(protocol, name, resultError).Set(float64(t.Errors))
+		for rcode, o := range t.Rcodes {
+			r.targetRcodeGauge.WithLabelValues(protocol, name, rcode, resultSuccess).Set(float64(o.Processed))
+			r.targetRcodeGauge.WithLabelValues(protocol, name, rcode, resultError).Set(float64(o.Errors))
+		}
+		observeMicro(r.targetHistogram.WithLabelValues(protocol, name), t.Latencies)
+	}
+}
+
+// observeMicro adds durations in nanoseconds to a histogram in microseconds
+func observeMicro(h prometheus.Observer, durations []float64) {
+	for _, d := range durations {
+		h.Observe(d / float64(time.Microsecond))
+	}
+}
+
+// Default buckets of the histograms, see ParseDurationBuckets
+const (
+	DefaultLatencyBuckets = "250us,500us,1ms,2.5ms,5ms,10ms,25ms,50ms,100ms,250ms,500ms,1s,2.5s"
+	DefaultJitterBuckets  = "10us,25us,50us,100us,250us,500us,1ms,2.5ms,5ms,10ms,25ms,50ms,100ms"
+)
+
+// ParseDurationBuckets parses comma separated increasing durations, e.g.
+// "1ms,10ms,100ms", into histogram buckets in microseconds
+func ParseDurationBuckets(s string) ([]float64, error) {
+	var buckets []float64
+	for _, f := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(f))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q: %w", f, err)
+		}
+		b := float64(d) / float64(time.Microsecond)
+		if len(buckets) > 0 && b <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("buckets %q are not increasing", s)
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// bucketsOrDefault returns buckets, or the parsed defaults if empty
+func bucketsOrDefault(buckets []float64, defaults string) []float64 {
+	if len(buckets) > 0 {
+		return buckets
+	}
+	b, err := ParseDurationBuckets(defaults)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// setOutcomes replaces the values of a breakdown gauge, so that keys without
+// queries in the last report don't keep their previous value
+func setOutcomes(gauge *prometheus.GaugeVec, protocol string, outcomes map[string]stats.Outcome) {
+	gauge.Reset()
+	for k, o := range outcomes {
+		gauge.WithLabelValues(protocol, k, resultSuccess).Set(float64(o.Processed))
+		gauge.WithLabelValues(protocol, k, resultError).Set(float64(o.Errors))
+	}
+}
 func flattenKey(key string) string {
 	key = strings.ReplaceAll(key, " ", "_")
 	key = strings.ReplaceAll(key, ".", "_")
@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 
 	"github.com/facebook/dns/goose/stats"
@@ -26,7 +27,7 @@ import (
 )
 
 func TestReportMetrics(t *testing.T) {
-	exportedMetrics := &stats.ExportedMetrics{Elapsed: 100 * time.Second, Processed: 1, Errors: 0, Latencies: []float64{1000, 2000, 3000}}
+	exportedMetrics := &stats.ExportedMetrics{Elapsed: 100 * time.Second, Protocol: "doh", TargetQPS: 500, GoodputQPS: 420, Processed: 1, Errors: 2, ConnErrors: 1, TCPRetries: 4, Latencies: []float64{1000, 2000, 3000}, Jitters: []float64{20000}}
 	r := &PrometheusMetricsReporter{Addr: ":0"}
 	go func() {
 		_ = r.Initialize()
@@ -35,10 +36,89 @@ func TestReportMetrics(t *testing.T) {
 	err := r.ReportMetrics(exportedMetrics)
 	require.NoError(t, err)
 	requireMetricRegisteredAndHasExpectedValue(t, r.registry, "dns_goose_response_success", 1)
-	requireMetricRegisteredAndHasExpectedValue(t, r.registry, "dns_goose_response_error", 0)
+	requireMetricRegisteredAndHasExpectedValue(t, r.registry, "dns_goose_response_error", 2)
+	requireMetricRegisteredAndHasExpectedValue(t, r.registry, "dns_goose_connection_error", 1)
 	requireMetricRegisteredAndHasExpectedValue(t, r.registry, "dns_goose_latency_max_us", 3)
 	requireMetricRegisteredAndHasExpectedValue(t, r.registry, "dns_goose_latency_min_us", 1)
 	requireMetricRegisteredAndHasExpectedValue(t, r.registry, "dns_goose_latency_avg_us", 2)
+	requireMetricRegisteredAndHasExpectedValue(t, r.registry, "dns_goose_qps_target", 500)
+	requireMetricRegisteredAndHasExpectedValue(t, r.registry, "dns_goose_qps_goodput", 420)
+	requireMetricRegisteredAndHasExpectedValue(t, r.registry, "dns_goose_tcp_retries", 4)
+	requireHistogramHasExpectedCount(t, r.registry, "dns_goose_latency_us", 3, 6)
+	requireHistogramHasExpectedCount(t, r.registry, "dns_goose_jitter_us", 1, 20)
+
+	// breakdowns are labelled by key and result
+	err = r.ReportMetrics(&stats.ExportedMetrics{
+		Protocol: "udp",
+		QTypes:   map[string]stats.Outcome{"A": {Processed: 3, Errors: 1}},
+		Rcodes:   map[string]stats.Outcome{"NOERROR": {Processed: 3}, "TIMEOUT": {Errors: 1}},
+		Responses: map[string]stats.Outcome{
+			stats.ResponseAnswer:       {Processed: 3},
+			stats.ResponseNetworkError: {Errors: 1},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, float64(3), testutil.ToFloat64(r.qtypeGauge.WithLabelValues("udp", "A", "success")))
+	require.Equal(t, float64(1), testutil.ToFloat64(r.responseGauge.WithLabelValues("udp", stats.ResponseNetworkError, "error")))
+	require.Equal(t, float64(1), testutil.ToFloat64(r.qtypeGauge.WithLabelValues("udp", "A", "error")))
+	require.Equal(t, float64(1), testutil.ToFloat64(r.rcodeGauge.WithLabelValues("udp", "TIMEOUT", "error")))
+
+	// keys missing from the next report are dropped
+	err = r.ReportMetrics(&stats.ExportedMetrics{
+		Protocol: "udp",
+		Rcodes:   map[string]stats.Outcome{"NOERROR": {Processed: 2}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 0, testutil.CollectAndCount(r.qtypeGauge))
+	require.Equal(t, 2, testutil.CollectAndCount(r.rcodeGauge))
+
+	// targets are broken down when queries are sent to several
+	err = r.ReportMetrics(&stats.ExportedMetrics{
+		Protocol: "udp",
+		Targets: map[string]stats.TargetOutcome{
+			"192.0.2.1:53": {Outcome: stats.Outcome{Processed: 3, Errors: 1}, Rcodes: map[string]stats.Outcome{"NOERROR": {Processed: 3}, "TIMEOUT": {Errors: 1}}, Latencies: []float64{1000, 2000}},
+			"192.0.2.2:53": {Outcome: stats.Outcome{Processed: 1}, Rcodes: map[string]stats.Outcome{"NOERROR": {Processed: 1}}, Latencies: []float64{3000}},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, float64(3), testutil.ToFloat64(r.targetGauge.WithLabelValues("udp", "192.0.2.1:53", "success")))
+	require.Equal(t, float64(1), testutil.ToFloat64(r.targetRcodeGauge.WithLabelValues("udp", "192.0.2.1:53", "TIMEOUT", "error")))
+	require.Equal(t, 2, testutil.CollectAndCount(r.targetHistogram))
+}
+
+func TestParseDurationBuckets(t *testing.T) {
+	buckets, err := ParseDurationBuckets("500us, 1ms,1.5s")
+	require.NoError(t, err)
+	require.Equal(t, []float64{500, 1000, 1500000}, buckets)
+
+	_, err = ParseDurationBuckets("1ms,1ms")
+	require.Error(t, err)
+	_, err = ParseDurationBuckets("1ms,")
+	require.Error(t, err)
+	_, err = ParseDurationBuckets("10")
+	require.Error(t, err)
+
+	for _, defaults := range []string{DefaultLatencyBuckets, DefaultJitterBuckets} {
+		_, err = ParseDurationBuckets(defaults)
+		require.NoError(t, err)
+	}
+}
+
+// requireHistogramHasExpectedCount checks the number and sum of the samples of
+// a doh histogram
+func requireHistogramHasExpectedCount(t *testing.T, registry *prometheus.Registry, metricKey string, expectedCount uint64, expectedSum float64) {
+	metrics, err := registry.Gather()
+	require.NoError(t, err)
+	for _, metric := range metrics {
+		if metric.GetName() == metricKey {
+			require.Equal(t, dto.MetricType_HISTOGRAM, metric.GetType())
+			histogram := metric.GetMetric()[0].GetHistogram()
+			require.Equal(t, expectedCount, histogram.GetSampleCount())
+			require.Equal(t, expectedSum, histogram.GetSampleSum())
+			return
+		}
+	}
+	require.Failf(t, "histogram not registered", "%s", metricKey)
 }
 
 func requireMetricRegisteredAndHasExpectedValue(t *testing.T, registry *prometheus.Registry, metricKey string, expectedValue float64) {
@@ -51,6 +131,8 @@ func requireMetricRegisteredAndHasExpectedValue(t *testing.T, registry *promethe
 			found = true
 			require.Equal(t, metric.GetType(), dto.MetricType_GAUGE)
 			rawmetric := metric.GetMetric()[0]
+			require.Equal(t, "protocol", rawmetric.GetLabel()[0].GetName())
+			require.Equal(t, "doh", rawmetric.GetLabel()[0].GetValue())
 			require.Equal(t, *rawmetric.Gauge.Value, expectedValue)
 			break
 		}
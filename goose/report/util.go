@@ -20,12 +20,30 @@ import (
 
 var (
 	errors        = "response.error"
+	connErrors    = "connection.error"
 	latencyMax    = "latency.max.us"
 	latencyMean   = "latency.mean.us"
 	latencyMedian = "latency.median.us"
 	latencyMin    = "latency.min.us"
 	latencyAvg    = "latency.avg.us"
 	successes     = "response.success"
+	targetQPS     = "qps.target"
+	goodputQPS    = "qps.goodput"
+	latencyHist   = "latency.us"
+	jitterHist    = "jitter.us"
+	qtypeQueries  = "queries.qtype"
+	rcodeQueries  = "queries.rcode"
+	classQueries  = "queries.response"
+	tcpRetries    = "tcp.retries"
+	targetQueries = "queries.target"
+	targetRcodes  = "queries.target.rcode"
+	targetLatency = "target.latency.us"
+)
+
+// outcomeResults are the values of the result label of breakdown metrics
+const (
+	resultSuccess = "success"
+	resultError   = "error"
 )
 
 func toTime(t float64) time.Duration {
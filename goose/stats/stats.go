@@ -34,15 +34,79 @@ func average(samples []float64) float64 {
 	return total / float64(len(samples))
 }
 
+// Labels of failed queries which did not get a response, used in place of an rcode
+const (
+	RcodeTimeout = "TIMEOUT"
+	RcodeError   = "ERROR"
+)
+
+// Classes of responses, as experienced by a resolver, see ExportedMetrics.Responses
+const (
+	// ResponseAnswer is a NOERROR response with data
+	ResponseAnswer = "ANSWER"
+	// ResponseNoData is a NOERROR response without data
+	ResponseNoData = "NODATA"
+	// ResponseNXDomain is a NXDOMAIN response
+	ResponseNXDomain = "NXDOMAIN"
+	// ResponseTruncated is a truncated response, which needs to be retried
+	// over TCP
+	ResponseTruncated = "TRUNCATED"
+	// ResponseFailure is a response with any other rcode, e.g. SERVFAIL
+	ResponseFailure = "FAILURE"
+	// ResponseNetworkError is no response at all, e.g. a timeout
+	ResponseNetworkError = "NETWORK_ERROR"
+)
+
+// Outcome counts successful and failed queries
+type Outcome struct {
+	Processed int
+	Errors    int
+}
+
+// TargetOutcome breaks down the queries sent to one target
+type TargetOutcome struct {
+	Outcome
+	// Rcodes breaks down queries by response code, RcodeTimeout or RcodeError.
+	Rcodes map[string]Outcome
+	// Latencies contain per query latency
+	Latencies []float64
+}
+
 // ExportedMetrics holds the basic metrics returned by the query engine
 type ExportedMetrics struct {
 	Elapsed time.Duration
+	// Protocol is the transport protocol queries were sent over.
+	Protocol string
+	// TargetQPS is the QPS targeted by the load profile at export time, 0 if not rate limited.
+	TargetQPS int
+	// GoodputQPS is the rate of queries answered without SERVFAIL sustained by
+	// the target, measured by the backoff profile, 0 if not measured.
+	GoodputQPS int
 	// processed is the number of queries successfully processed.
 	Processed int
 	// errors is the number of queries that failed.
 	Errors int
+	// ConnErrors is the number of failed queries which could not be exchanged
+	// with the target, they are included in Errors.
+	ConnErrors int
+	// QTypes breaks down queries by query type.
+	QTypes map[string]Outcome
+	// Rcodes breaks down queries by response code, RcodeTimeout or RcodeError.
+	Rcodes map[string]Outcome
+	// Responses breaks down queries by class of response, ResponseAnswer,
+	// ResponseNoData, ResponseNXDomain, ResponseTruncated, ResponseFailure or
+	// ResponseNetworkError.
+	Responses map[string]Outcome
+	// TCPRetries is the number of queries retried over TCP after a truncated
+	// response, the class of their response being the one of the retry.
+	TCPRetries int
+	// Targets breaks down queries by target, when sent to several.
+	Targets map[string]TargetOutcome
 	// Latencies contain per query latency
 	Latencies []float64
+	// Jitters contain the inter-send jitter of queries: how much the time
+	// between consecutive queries differs from the interval of the target QPS
+	Jitters []float64
 }
 
 // QPSTotal returns the number of queries processed in one second.
@@ -68,16 +132,25 @@ func newLatencyStats() *LatencyStats {
 
 // AggregateLatencies aggregates query latency metrics
 func (m *ExportedMetrics) AggregateLatencies() *LatencyStats {
+	return aggregateLatencies(m.Latencies)
+}
+
+// AggregateLatencies aggregates the latency of the queries sent to the target
+func (t TargetOutcome) AggregateLatencies() *LatencyStats {
+	return aggregateLatencies(t.Latencies)
+}
+
+func aggregateLatencies(latencies []float64) *LatencyStats {
 	l := newLatencyStats()
-	sort.Float64s(m.Latencies)
-	if len(m.Latencies) > 0 {
-		l.Min = stat.Quantile(0.0, stat.Empirical, m.Latencies, nil)
-		l.Max = stat.Quantile(1.0, stat.Empirical, m.Latencies, nil)
-		l.Mean = stat.Mean(m.Latencies, nil)
-		l.Median = stat.Quantile(0.5, stat.Empirical, m.Latencies, nil)
-		l.Upperq = stat.Quantile(0.75, stat.Empirical, m.Latencies, nil)
-		l.Lowerq = stat.Quantile(0.25, stat.Empirical, m.Latencies, nil)
-		l.Average = average(m.Latencies)
+	sort.Float64s(latencies)
+	if len(latencies) > 0 {
+		l.Min = stat.Quantile(0.0, stat.Empirical, latencies, nil)
+		l.Max = stat.Quantile(1.0, stat.Empirical, latencies, nil)
+		l.Mean = stat.Mean(latencies, nil)
+		l.Median = stat.Quantile(0.5, stat.Empirical, latencies, nil)
+		l.Upperq = stat.Quantile(0.75, stat.Empirical, latencies, nil)
+		l.Lowerq = stat.Quantile(0.25, stat.Empirical, latencies, nil)
+		l.Average = average(latencies)
 	}
 	return l
 }